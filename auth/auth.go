@@ -4,22 +4,65 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"spiritchat/config"
 	"strings"
 
+	"github.com/auth0/go-auth0"
 	"github.com/auth0/go-auth0/authentication"
 	"github.com/auth0/go-auth0/authentication/database"
+	"github.com/auth0/go-auth0/authentication/oauth"
+	"github.com/auth0/go-auth0/management"
 )
 
 var ErrInvalidUsername = errors.New("invalid username")
 var ErrInvalidEmail = errors.New("invalid email")
 var ErrInvalidPassword = errors.New("invalid password")
 var ErrUserExists = errors.New("that user already exists")
+var ErrUserNotFound = errors.New("no such user")
+var ErrInvalidCredentials = errors.New("wrong username or password")
+
+// Role grants a user access to staff features.
+type Role string
+
+const (
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+	// RoleRetention grants access to deleted posts held in retention. Not implied by RoleAdmin.
+	RoleRetention Role = "retention"
+	// RoleImpersonate lets admins act as other users. Not implied by RoleAdmin.
+	RoleImpersonate Role = "impersonate"
+)
 
 type UserData struct {
+	// Auth0 subject identifier, stable across email changes.
+	ID         string `json:"-"`
 	Username   string `json:"username"`
 	Email      string `json:"email"`
 	IsVerified bool   `json:"-"`
+	Roles      []Role `json:"roles,omitempty"`
+	// Scopes the user's token was granted, nil if it's unscoped.
+	Scopes []Scope `json:"scopes,omitempty"`
+}
+
+// HasRole returns true if the user was granted the role.
+func (user *UserData) HasRole(role Role) bool {
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Tokens are what a login's authorization code is exchanged for.
+type Tokens struct {
+	AccessToken  string `json:"accessToken"`
+	IDToken      string `json:"idToken,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
 }
 
 type Auth interface {
@@ -28,10 +71,32 @@ type Auth interface {
 		username string, email string, password string,
 	) (*UserData, error)
 	GetUserFromToken(ctx context.Context, token string) (*UserData, error)
+	// ResendVerification sends the user another email to verify their account with.
+	ResendVerification(ctx context.Context, userID string) error
+	// GetUser looks up a user by their ID, without any staff roles. Returns ErrUserNotFound if there's no such user.
+	GetUser(ctx context.Context, userID string) (*UserData, error)
+	// AuthorizeURL returns where to send users to log in through the connection, coming back to redirectURI.
+	AuthorizeURL(connection string, redirectURI string, state string) string
+	// Login exchanges a username or email and password for the user's tokens. Returns ErrInvalidCredentials if they're wrong.
+	Login(ctx context.Context, username string, password string) (*Tokens, error)
+	// ExchangeCode exchanges the authorization code a login came back with for the user's tokens.
+	ExchangeCode(ctx context.Context, code string, redirectURI string) (*Tokens, error)
+	// Refresh exchanges a refresh token for a new access token. Returns ErrInvalidCredentials if it's been revoked or expired.
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+	// RevokeRefreshToken stops the refresh token being exchanged for access tokens.
+	RevokeRefreshToken(ctx context.Context, refreshToken string) error
 }
 
 type OAuth struct {
-	auth *authentication.Authentication
+	domain     string
+	auth       *authentication.Authentication
+	management *management.Management
+	clientID   string
+	staff      map[string][]string
+	// Whether tokens without any of our scopes are given read-only access, unless they're the first-party client's.
+	requireScopes bool
+	// Audience tokens' claims are only trusted for.
+	audience string
 }
 
 // / Try to sign up the requested credentials
@@ -73,13 +138,137 @@ func (a *OAuth) GetUserFromToken(ctx context.Context, token string) (*UserData,
 	if err != nil {
 		return nil, err
 	}
-	return &UserData{
+	user := &UserData{
+		ID:         info.Sub,
 		Username:   info.PreferredUsername,
 		Email:      info.Email,
 		IsVerified: info.EmailVerified,
+	}
+	// UserInfo has accepted the token, so its claims can be trusted if it was issued for us.
+	user.Scopes = grantedScopes(token, a.clientID, a.audience, a.requireScopes)
+	// Only trust the email for staff roles once it's verified.
+	if user.IsVerified {
+		for _, role := range a.staff[user.Email] {
+			user.Roles = append(user.Roles, Role(role))
+		}
+	}
+	return user, nil
+}
+
+// Starts an Auth0 job sending the verification email, which needs the client to be allowed the Management API.
+func (a *OAuth) ResendVerification(ctx context.Context, userID string) error {
+	err := a.management.Job.VerifyEmail(ctx, &management.Job{
+		UserID:   auth0.String(userID),
+		ClientID: auth0.String(a.clientID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start verification email job: %w", err)
+	}
+	return nil
+}
+
+// Reads the user through the Management API, which needs the client to be granted read:users.
+func (a *OAuth) GetUser(ctx context.Context, userID string) (*UserData, error) {
+	user, err := a.management.User.Read(ctx, userID)
+	if err != nil {
+		var mErr management.Error
+		if errors.As(err, &mErr) && mErr.Status() == http.StatusNotFound {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to read user: %w", err)
+	}
+	username := user.GetUsername()
+	if len(username) == 0 {
+		username = user.GetNickname()
+	}
+	return &UserData{
+		ID:         user.GetID(),
+		Username:   username,
+		Email:      user.GetEmail(),
+		IsVerified: user.GetEmailVerified(),
 	}, nil
 }
 
+func (a *OAuth) AuthorizeURL(connection string, redirectURI string, state string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.clientID},
+		"connection":    {connection},
+		"redirect_uri":  {redirectURI},
+		"scope":         {"openid profile email"},
+		"state":         {state},
+	}
+	return (&url.URL{Scheme: "https", Host: a.domain, Path: "/authorize", RawQuery: query.Encode()}).String()
+}
+
+// Logs in with the password grant, which needs to be enabled for the Auth0 application.
+func (a *OAuth) Login(ctx context.Context, username string, password string) (*Tokens, error) {
+	tokens, err := a.auth.OAuth.LoginWithPassword(ctx, oauth.LoginWithPasswordRequest{
+		Username: username,
+		Password: password,
+		Realm:    "Username-Password-Authentication",
+		Scope:    "openid profile email",
+	}, oauth.IDTokenValidationOptions{})
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid_grant") {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to log in: %w", err)
+	}
+	return &Tokens{
+		AccessToken:  tokens.AccessToken,
+		IDToken:      tokens.IDToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    tokens.TokenType,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
+}
+
+func (a *OAuth) ExchangeCode(ctx context.Context, code string, redirectURI string) (*Tokens, error) {
+	tokens, err := a.auth.OAuth.LoginWithAuthCode(ctx, oauth.LoginWithAuthCodeRequest{
+		Code:        code,
+		RedirectURI: redirectURI,
+	}, oauth.IDTokenValidationOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	return &Tokens{
+		AccessToken:  tokens.AccessToken,
+		IDToken:      tokens.IDToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    tokens.TokenType,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
+}
+
+func (a *OAuth) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	tokens, err := a.auth.OAuth.RefreshToken(ctx, oauth.RefreshTokenRequest{
+		RefreshToken: refreshToken,
+	}, oauth.IDTokenValidationOptions{})
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid_grant") {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to refresh tokens: %w", err)
+	}
+	return &Tokens{
+		AccessToken:  tokens.AccessToken,
+		IDToken:      tokens.IDToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    tokens.TokenType,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil
+}
+
+// Revokes through /oauth/revoke. Access tokens it already issued stay valid at Auth0 until they expire.
+func (a *OAuth) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	err := a.auth.OAuth.RevokeRefreshToken(ctx, oauth.RevokeRefreshTokenRequest{Token: refreshToken})
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	return nil
+}
+
 func NewOAuth(ctx context.Context, cfg config.SpiritAuthConfig) (*OAuth, error) {
 	auth, err := authentication.New(
 		ctx,
@@ -91,7 +280,21 @@ func NewOAuth(ctx context.Context, cfg config.SpiritAuthConfig) (*OAuth, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize the auth0 API client: %+v", err)
 	}
+	manager, err := management.New(
+		cfg.Domain,
+		management.WithClientCredentials(ctx, cfg.ClientID, cfg.ClientSecret),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize the auth0 management client: %+v", err)
+	}
 	return &OAuth{
-		auth,
+		domain:     cfg.Domain,
+		auth:       auth,
+		management: manager,
+		clientID:   cfg.ClientID,
+		staff:      cfg.Staff,
+
+		requireScopes: cfg.RequireScopes,
+		audience:      cfg.Audience,
 	}, nil
 }
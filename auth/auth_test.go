@@ -2,6 +2,9 @@ package auth
 
 import (
 	"context"
+	"encoding/base64"
+	"net/url"
+	"reflect"
 	"spiritchat/config"
 	"testing"
 )
@@ -14,9 +17,114 @@ func createExampleAuthConfig() config.SpiritAuthConfig {
 	}
 }
 
+func TestHasRole(t *testing.T) {
+	user := &UserData{Roles: []Role{RoleModerator}}
+	if !user.HasRole(RoleModerator) {
+		t.Error("expected user to have moderator role")
+	}
+	if user.HasRole(RoleAdmin) {
+		t.Error("expected user not to have admin role")
+	}
+}
+
 func TestNew(t *testing.T) {
 	_, err := NewOAuth(context.TODO(), createExampleAuthConfig())
 	if err != nil {
 		t.Errorf("auth client couldn't be created: %v", err)
 	}
 }
+
+func TestAuthorizeURL(t *testing.T) {
+	a, err := NewOAuth(context.TODO(), createExampleAuthConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorize, err := url.Parse(a.AuthorizeURL("github", "https://example.com/callback?from=login", "abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authorize.Query()
+	if authorize.Host != "example.us.auth0.com" || authorize.Path != "/authorize" {
+		t.Errorf("expected the tenant's authorize endpoint, got %s", authorize)
+	}
+	if query.Get("connection") != "github" || query.Get("state") != "abc" || query.Get("response_type") != "code" ||
+		query.Get("redirect_uri") != "https://example.com/callback?from=login" || query.Get("client_id") != createExampleAuthConfig().ClientID {
+		t.Errorf("expected login parameters in the query, got %s", authorize.RawQuery)
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	jwt := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+	}
+	tests := map[string][]Scope{
+		jwt(`{"scope": "openid read post"}`): {ScopeRead, ScopePost},
+		jwt(`{"scope": "openid profile"}`):   nil,
+		jwt(`{}`):                            nil,
+		"opaque":                             nil,
+	}
+	for token, expected := range tests {
+		scopes := TokenScopes(token)
+		if !reflect.DeepEqual(scopes, expected) {
+			t.Errorf("%s: expected %v, got %v", token, expected, scopes)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	user := &UserData{Scopes: []Scope{ScopePost}}
+	if !user.HasScope(ScopeRead) || !user.HasScope(ScopePost) {
+		t.Error("expected post scope to grant read and post")
+	}
+	if user.HasScope(ScopeModerate) {
+		t.Error("expected post scope not to grant moderate")
+	}
+	if !(&UserData{}).HasScope(ScopeAdmin) {
+		t.Error("expected unscoped user to have every scope")
+	}
+	if (&UserData{Scopes: []Scope{}}).HasScope(ScopeRead) {
+		t.Error("expected user with no scopes to have none")
+	}
+}
+
+func TestGrantedScopes(t *testing.T) {
+	jwt := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+	}
+	clientID := createExampleAuthConfig().ClientID
+	api := "https://api.spirit.example"
+	tests := []struct {
+		name          string
+		token         string
+		requireScopes bool
+		expected      []Scope
+	}{
+		{"scoped", jwt(`{"scope": "openid post", "azp": "third-party", "aud": "` + api + `"}`), true, []Scope{ScopePost}},
+		{"first-party", jwt(`{"scope": "openid profile", "azp": "` + clientID + `", "aud": ["` + api + `", "userinfo"]}`), true, nil},
+		{"first-party client_id", jwt(`{"client_id": "` + clientID + `", "aud": "` + api + `"}`), true, nil},
+		{"first-party for another API", jwt(`{"azp": "` + clientID + `", "aud": "https://other.example"}`), true, []Scope{ScopeRead}},
+		{"first-party without an audience", jwt(`{"azp": "` + clientID + `"}`), true, []Scope{ScopeRead}},
+		{"scoped for another API", jwt(`{"scope": "admin", "aud": "https://other.example"}`), true, []Scope{ScopeRead}},
+		{"third-party", jwt(`{"scope": "openid profile", "azp": "third-party", "aud": "` + api + `"}`), true, []Scope{ScopeRead}},
+		{"no client", jwt(`{"aud": "` + api + `"}`), true, []Scope{ScopeRead}},
+		{"opaque", "opaque", true, []Scope{ScopeRead}},
+		{"scopes not required", jwt(`{"azp": "third-party", "aud": "` + api + `"}`), false, nil},
+	}
+	for _, test := range tests {
+		scopes := grantedScopes(test.token, clientID, api, test.requireScopes)
+		if !reflect.DeepEqual(scopes, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, scopes)
+		}
+		if test.expected == nil && !(&UserData{Scopes: scopes}).HasScope(ScopeAdmin) {
+			t.Errorf("%s: expected every scope", test.name)
+		}
+		if test.expected != nil && (&UserData{Scopes: scopes}).HasScope(ScopeAdmin) {
+			t.Errorf("%s: expected admin scope refused", test.name)
+		}
+	}
+
+	token := jwt(`{"azp": "` + clientID + `", "aud": "` + api + `"}`)
+	if scopes := grantedScopes(token, clientID, "", true); !reflect.DeepEqual(scopes, []Scope{ScopeRead}) {
+		t.Errorf("expected claims untrusted without a configured audience, got %v", scopes)
+	}
+}
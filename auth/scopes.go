@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Scope limits what a token can be used for, so third-party clients can ask for only what they need.
+type Scope string
+
+// Scopes are ordered, each granting those before it.
+const (
+	// ScopeRead allows GET requests as the user.
+	ScopeRead Scope = "read"
+	// ScopePost allows posting, messaging and changing the user's settings.
+	ScopePost Scope = "post"
+	// ScopeModerate allows using the user's moderator role.
+	ScopeModerate Scope = "moderate"
+	// ScopeAdmin allows using the user's admin roles.
+	ScopeAdmin Scope = "admin"
+)
+
+var scopeOrder = []Scope{ScopeRead, ScopePost, ScopeModerate, ScopeAdmin}
+
+// RoleScope returns the scope a token needs for its user to use a role.
+func RoleScope(role Role) Scope {
+	if role == RoleModerator {
+		return ScopeModerate
+	}
+	return ScopeAdmin
+}
+
+/*
+HasScope returns true if the user's token was granted the scope, or one after it.
+Users with nil scopes have every scope, which only the first-party client's tokens are given.
+*/
+func (user *UserData) HasScope(scope Scope) bool {
+	if user.Scopes == nil {
+		return true
+	}
+	for _, s := range user.Scopes {
+		if scopeRank(s) >= scopeRank(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func scopeRank(scope Scope) int {
+	for i, s := range scopeOrder {
+		if s == scope {
+			return i
+		}
+	}
+	return -1
+}
+
+// audience is a JWT's aud claim, which can be a single string or a list of them.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(raw []byte) error {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	return json.Unmarshal(raw, (*[]string)(aud))
+}
+
+// tokenClaims are the claims of a JWT access token that decide what it can be used for.
+type tokenClaims struct {
+	Scope string `json:"scope"`
+	// Client the token was issued to.
+	AuthorizedParty string `json:"azp"`
+	ClientID        string `json:"client_id"`
+	// APIs the token was issued for.
+	Audience audience `json:"aud"`
+}
+
+// issuedFor returns true if the token was issued for the API, never if the API isn't known.
+func (claims tokenClaims) issuedFor(api string) bool {
+	if len(api) == 0 {
+		return false
+	}
+	for _, aud := range claims.Audience {
+		if aud == api {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+parseTokenClaims returns the claims of a JWT access token, or empty claims if it isn't a JWT.
+The token's signature isn't checked, so it must already have been accepted by Auth0.
+*/
+func parseTokenClaims(token string) tokenClaims {
+	var claims tokenClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return tokenClaims{}
+	}
+	return claims
+}
+
+/*
+TokenScopes returns the scopes in a JWT access token's scope claim, ignoring any that aren't ours.
+Returns nil if it has none of them, or isn't a JWT.
+*/
+func TokenScopes(token string) []Scope {
+	claims := parseTokenClaims(token)
+	var scopes []Scope
+	for _, s := range strings.Fields(claims.Scope) {
+		if scopeRank(Scope(s)) >= 0 {
+			scopes = append(scopes, Scope(s))
+		}
+	}
+	return scopes
+}
+
+// TokenClient returns the ID of the client a JWT access token was issued to, empty if it isn't a JWT or doesn't say.
+func TokenClient(token string) string {
+	claims := parseTokenClaims(token)
+	if len(claims.AuthorizedParty) > 0 {
+		return claims.AuthorizedParty
+	}
+	return claims.ClientID
+}
+
+/*
+grantedScopes returns the scopes a token accepted by Auth0 is allowed. Tokens without any of our scopes
+get them all if they were issued to the first-party client, and only ScopeRead otherwise,
+unless scopes aren't required, which trusts every client with them all.
+Tokens issued for another API, or any if ours isn't known, are treated as having no scopes from no client.
+*/
+func grantedScopes(token string, firstPartyClientID string, api string, requireScopes bool) []Scope {
+	if !parseTokenClaims(token).issuedFor(api) {
+		if !requireScopes {
+			return nil
+		}
+		return []Scope{ScopeRead}
+	}
+	scopes := TokenScopes(token)
+	if scopes != nil || !requireScopes {
+		return scopes
+	}
+	if len(firstPartyClientID) > 0 && TokenClient(token) == firstPartyClientID {
+		return nil
+	}
+	return []Scope{ScopeRead}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238's SHA-1 test vectors, cut to 6 digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, code := range vectors {
+		step, ok := ValidateTOTP(secret, code, time.Unix(unix, 0))
+		if !ok || step != unix/30 {
+			t.Errorf("%d: expected %s valid for step %d, got %d %v", unix, code, unix/30, step, ok)
+		}
+	}
+
+	// A step either side is allowed for clock drift, and no further.
+	if _, ok := ValidateTOTP(secret, "287082", time.Unix(59+30, 0)); !ok {
+		t.Error("expected the previous step's code accepted")
+	}
+	if _, ok := ValidateTOTP(secret, "287082", time.Unix(59+90, 0)); ok {
+		t.Error("expected codes from further back refused")
+	}
+	if _, ok := ValidateTOTP(secret, "287083", time.Unix(59, 0)); ok {
+		t.Error("expected a wrong code refused")
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", time.Unix(59, 0)); ok {
+		t.Error("expected an invalid secret refused")
+	}
+
+	if code, err := TOTPCode(secret, time.Unix(59, 0)); err != nil || code != "287082" {
+		t.Errorf("expected the code at 59 to be 287082, got %s %v", code, err)
+	}
+
+	generated, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := TOTPURI("spiritchat", "bob@gmail.com", generated)
+	if !strings.HasPrefix(uri, "otpauth://totp/spiritchat:bob@gmail.com?") || !strings.Contains(uri, "secret="+generated) {
+		t.Errorf("unexpected URI %s", uri)
+	}
+}
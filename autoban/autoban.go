@@ -0,0 +1,194 @@
+package autoban
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"spiritchat/data"
+	"spiritchat/events"
+	"spiritchat/validation"
+	"sync"
+	"time"
+)
+
+// Store is the subset of data.Store rules are evaluated against.
+type Store interface {
+	GetRules(ctx context.Context) ([]*data.Rule, error)
+	CountPosterDeletions(ctx context.Context, categoryTag string, num int, since time.Time) (string, int, error)
+	IsBanned(ctx context.Context, posterHashes ...string) (bool, error)
+	BanPoster(ctx context.Context, posterHash string, reason string, banFor time.Duration) error
+	QuarantinePost(ctx context.Context, categoryTag string, num int) error
+	WriteRuleAction(ctx context.Context, action *data.RuleAction) error
+}
+
+/*
+Engine evaluates the enabled autoban rules in the store against moderation events.
+Rules are read on every event, so changes through the admin API apply immediately.
+*/
+type Engine struct {
+	store    Store
+	mut      sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+// NewEngine creates an engine acting on the rules in the store.
+func NewEngine(store Store) *Engine {
+	return &Engine{
+		store:    store,
+		patterns: make(map[string]*regexp.Regexp),
+	}
+}
+
+// Subscribe evaluates the engine's rules against new and deleted posts published on the bus.
+func Subscribe(bus *events.Bus, engine *Engine) {
+	bus.Subscribe(engine.PostCreated, events.PostCreated)
+	bus.Subscribe(engine.PostDeleted, events.PostDeleted)
+}
+
+// pattern returns the compiled pattern, compiling it once.
+func (engine *Engine) pattern(pattern string) (*regexp.Regexp, error) {
+	engine.mut.Lock()
+	defer engine.mut.Unlock()
+	if re, ok := engine.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	engine.patterns[pattern] = re
+	return re, nil
+}
+
+// enabledRules returns the enabled rules of the given kind.
+func (engine *Engine) enabledRules(ctx context.Context, kind data.RuleKind) ([]*data.Rule, error) {
+	rules, err := engine.store.GetRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	enabled := make([]*data.Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled && rule.Kind == kind {
+			enabled = append(enabled, rule)
+		}
+	}
+	return enabled, nil
+}
+
+// PostCreated quarantines new posts matching a content rule, banning their poster if the rule says to.
+func (engine *Engine) PostCreated(ctx context.Context, event events.Event) {
+	if event.Post == nil {
+		return
+	}
+	rules, err := engine.enabledRules(ctx, data.RuleContentMatch)
+	if err != nil {
+		log.Printf("autoban: failed to get rules: %v", err)
+		return
+	}
+
+	// Rules see posts as they were written, before any words were masked.
+	content := event.Post.Content
+	if len(event.Post.Original) > 0 {
+		content = event.Post.Original
+	}
+	text := event.Post.Subject + "\n" + content
+	var folded string
+	for _, rule := range rules {
+		re, err := engine.pattern(rule.Pattern)
+		if err != nil {
+			log.Printf("autoban: rule %d has a bad pattern: %v", rule.ID, err)
+			continue
+		}
+		matched := text
+		if rule.Fold {
+			if len(folded) == 0 {
+				folded = validation.FoldHomoglyphs(text)
+			}
+			matched = folded
+		}
+		if !re.MatchString(matched) {
+			continue
+		}
+
+		if rule.Action == data.ActionBan && len(event.Poster) > 0 {
+			err := engine.ban(ctx, rule, event.Poster, event.Category, event.Num)
+			if err != nil {
+				log.Printf("autoban: rule %d failed to ban poster: %v", rule.ID, err)
+			}
+		}
+		err = engine.store.QuarantinePost(ctx, event.Category, event.Num)
+		if err != nil {
+			log.Printf("autoban: rule %d failed to quarantine %s/%d: %v", rule.ID, event.Category, event.Num, err)
+			return
+		}
+		engine.record(ctx, rule, data.ActionQuarantine, event.Poster, event.Category, event.Num)
+		// The post's quarantined, later rules can't do more than ban again.
+		return
+	}
+}
+
+// PostDeleted bans the poster of a deleted post if they've had too many posts deleted by moderators.
+func (engine *Engine) PostDeleted(ctx context.Context, event events.Event) {
+	rules, err := engine.enabledRules(ctx, data.RuleDeletedPosts)
+	if err != nil {
+		log.Printf("autoban: failed to get rules: %v", err)
+		return
+	}
+
+	for _, rule := range rules {
+		since := event.At.Add(-time.Duration(rule.WindowHours) * time.Hour)
+		posterHash, count, err := engine.store.CountPosterDeletions(ctx, event.Category, event.Num, since)
+		if err != nil {
+			// The post was deleted by its poster, which doesn't count against them.
+			if errors.Is(err, data.ErrNotFound) {
+				return
+			}
+			log.Printf("autoban: failed to count deletions for %s/%d: %v", event.Category, event.Num, err)
+			return
+		}
+		if count < rule.Threshold {
+			continue
+		}
+		err = engine.ban(ctx, rule, posterHash, event.Category, event.Num)
+		if err != nil {
+			log.Printf("autoban: rule %d failed to ban poster: %v", rule.ID, err)
+		}
+	}
+}
+
+// ban bans the poster under the rule, unless they're already banned.
+func (engine *Engine) ban(ctx context.Context, rule *data.Rule, posterHash string, categoryTag string, num int) error {
+	banned, err := engine.store.IsBanned(ctx, posterHash)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return nil
+	}
+	err = engine.store.BanPoster(
+		ctx, posterHash, fmt.Sprintf("autoban: %s", rule.Name), time.Duration(rule.BanHours)*time.Hour,
+	)
+	if err != nil {
+		return err
+	}
+	engine.record(ctx, rule, data.ActionBan, posterHash, categoryTag, num)
+	return nil
+}
+
+// record writes an action to the audit log.
+func (engine *Engine) record(ctx context.Context, rule *data.Rule, action data.ModerationAction, posterHash string, categoryTag string, num int) {
+	log.Printf("autoban: rule %d (%s) took action %s on %s/%d", rule.ID, rule.Name, action, categoryTag, num)
+	err := engine.store.WriteRuleAction(ctx, &data.RuleAction{
+		RuleID:     rule.ID,
+		RuleName:   rule.Name,
+		PosterHash: posterHash,
+		Cat:        categoryTag,
+		Num:        num,
+		Action:     action,
+	})
+	if err != nil {
+		log.Printf("autoban: failed to record action: %v", err)
+	}
+}
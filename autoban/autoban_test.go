@@ -0,0 +1,158 @@
+package autoban
+
+import (
+	"context"
+	"spiritchat/data"
+	"spiritchat/events"
+	"testing"
+	"time"
+)
+
+type mockStore struct {
+	rules       []*data.Rule
+	deletions   int
+	banned      map[string]time.Duration
+	quarantined []int
+	actions     []*data.RuleAction
+}
+
+func (ms *mockStore) GetRules(ctx context.Context) ([]*data.Rule, error) {
+	return ms.rules, nil
+}
+
+// CountPosterDeletions treats every even numbered post as deleted by its poster.
+func (ms *mockStore) CountPosterDeletions(ctx context.Context, categoryTag string, num int, since time.Time) (string, int, error) {
+	if num%2 == 0 {
+		return "", 0, data.ErrNotFound
+	}
+	return "poster", ms.deletions, nil
+}
+
+func (ms *mockStore) IsBanned(ctx context.Context, posterHashes ...string) (bool, error) {
+	for _, posterHash := range posterHashes {
+		if _, ok := ms.banned[posterHash]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ms *mockStore) BanPoster(ctx context.Context, posterHash string, reason string, banFor time.Duration) error {
+	ms.banned[posterHash] = banFor
+	return nil
+}
+
+func (ms *mockStore) QuarantinePost(ctx context.Context, categoryTag string, num int) error {
+	ms.quarantined = append(ms.quarantined, num)
+	return nil
+}
+
+func (ms *mockStore) WriteRuleAction(ctx context.Context, action *data.RuleAction) error {
+	ms.actions = append(ms.actions, action)
+	return nil
+}
+
+func TestContentMatch(t *testing.T) {
+	store := &mockStore{
+		banned: make(map[string]time.Duration),
+		rules: []*data.Rule{
+			{ID: 1, Name: "disabled", Kind: data.RuleContentMatch, Pattern: "hello", Action: data.ActionBan},
+			{ID: 2, Name: "links", Kind: data.RuleContentMatch, Pattern: `(?i)buy\s+now`, Action: data.ActionBan, Enabled: true},
+			{ID: 3, Name: "shouting", Kind: data.RuleContentMatch, Pattern: `(?m)^[A-Z ]+$`, Action: data.ActionQuarantine, Enabled: true},
+		},
+	}
+	engine := NewEngine(store)
+	post := func(num int, poster string, content string) events.Event {
+		return events.Event{
+			Kind: events.PostCreated, Category: "cat", Num: num, Poster: poster,
+			Post: &data.Post{Num: num, Cat: "cat", Content: content},
+		}
+	}
+
+	engine.PostCreated(context.Background(), post(1, "a", "hello there"))
+	if len(store.quarantined) != 0 || len(store.banned) != 0 {
+		t.Fatalf("expected disabled rule to be ignored, got %v quarantined", store.quarantined)
+	}
+
+	engine.PostCreated(context.Background(), post(2, "b", "BUY  NOW"))
+	if banFor, ok := store.banned["b"]; !ok || banFor != 0 {
+		t.Errorf("expected poster b to be permanently banned, got %v", store.banned)
+	}
+	if len(store.quarantined) != 1 || store.quarantined[0] != 2 {
+		t.Errorf("expected post 2 quarantined, got %v", store.quarantined)
+	}
+	if len(store.actions) != 2 || store.actions[0].Action != data.ActionBan || store.actions[0].RuleID != 2 {
+		t.Errorf("expected ban and quarantine by rule 2 recorded, got %+v", store.actions)
+	}
+
+	engine.PostCreated(context.Background(), post(3, "c", "WHY"))
+	if _, ok := store.banned["c"]; ok {
+		t.Errorf("expected quarantine rule not to ban")
+	}
+	if len(store.quarantined) != 2 || store.quarantined[1] != 3 {
+		t.Errorf("expected post 3 quarantined, got %v", store.quarantined)
+	}
+}
+
+func TestContentMatchFold(t *testing.T) {
+	store := &mockStore{
+		banned: make(map[string]time.Duration),
+		rules: []*data.Rule{
+			{ID: 1, Name: "spam", Kind: data.RuleContentMatch, Pattern: `free money`, Action: data.ActionQuarantine, Enabled: true},
+			{ID: 2, Name: "folded spam", Kind: data.RuleContentMatch, Pattern: `cheap pills`, Fold: true, Action: data.ActionQuarantine, Enabled: true},
+		},
+	}
+	engine := NewEngine(store)
+	post := func(num int, content string) events.Event {
+		return events.Event{
+			Kind: events.PostCreated, Category: "cat", Num: num,
+			Post: &data.Post{Num: num, Cat: "cat", Content: content},
+		}
+	}
+
+	engine.PostCreated(context.Background(), post(1, "fr\u0435e money"))
+	if len(store.quarantined) != 0 {
+		t.Fatalf("expected unfolded rule not to match lookalikes, got %v quarantined", store.quarantined)
+	}
+	engine.PostCreated(context.Background(), post(2, "ChE\u0410P Ρills"))
+	if len(store.quarantined) != 1 || store.quarantined[0] != 2 {
+		t.Errorf("expected folded rule to match lookalikes, got %v quarantined", store.quarantined)
+	}
+}
+
+func TestDeletedPosts(t *testing.T) {
+	store := &mockStore{
+		banned: make(map[string]time.Duration),
+		rules: []*data.Rule{
+			{ID: 1, Name: "three strikes", Kind: data.RuleDeletedPosts, Threshold: 3, WindowHours: 24, Action: data.ActionBan, BanHours: 72, Enabled: true},
+		},
+	}
+	engine := NewEngine(store)
+	deleted := func(num int) events.Event {
+		return events.Event{Kind: events.PostDeleted, Category: "cat", Num: num, At: time.Now()}
+	}
+
+	store.deletions = 2
+	engine.PostDeleted(context.Background(), deleted(1))
+	if len(store.banned) != 0 {
+		t.Fatalf("expected no ban under the threshold")
+	}
+
+	store.deletions = 3
+	engine.PostDeleted(context.Background(), deleted(2))
+	if len(store.banned) != 0 {
+		t.Fatalf("expected posts deleted by their poster not to count")
+	}
+
+	engine.PostDeleted(context.Background(), deleted(3))
+	if banFor := store.banned["poster"]; banFor != time.Hour*72 {
+		t.Errorf("expected a 72 hour ban, got %v", store.banned)
+	}
+
+	// Already banned posters aren't banned again.
+	store.deletions = 4
+	engine.PostDeleted(context.Background(), deleted(5))
+	if len(store.actions) != 1 {
+		t.Errorf("expected a single recorded ban, got %+v", store.actions)
+	}
+}
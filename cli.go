@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"spiritchat/config"
+	"spiritchat/data"
+)
+
+// Exit codes, so cron and scripts can tell what happened without reading the output.
+const (
+	exitOK = 0
+	// The command ran and failed.
+	exitFailed = 1
+	// The command line couldn't be understood, nothing was run.
+	exitUsage = 2
+	// The command ran and found something wrong, like fsck --check finding drifted counters.
+	exitFound = 3
+)
+
+// result is what a command did, printed as text, or encoded as JSON with --json.
+type result interface {
+	String() string
+}
+
+// finding is a result that should exit with exitFound, when found is true.
+type finding interface {
+	result
+	found() bool
+}
+
+// runFunc runs a command with its positional arguments, once its flags are parsed.
+type runFunc func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error)
+
+// command is a subcommand of the binary, like migrate or prune.
+type command struct {
+	name string
+	// Positional arguments, as shown in usage, like "<category> <file>".
+	args    string
+	summary string
+	// How many positional arguments the command takes.
+	minArgs int
+	maxArgs int
+	// Defines the command's flags, returning what runs it.
+	define func(flags *flag.FlagSet) runFunc
+}
+
+func (cmd *command) usage() string {
+	if len(cmd.args) == 0 {
+		return "spirit " + cmd.name + " [flags]"
+	}
+	return "spirit " + cmd.name + " [flags] " + cmd.args
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: spirit [--json] <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nRun spirit help <command> for a command's flags. Without a command, spirit serves.")
+}
+
+/*
+parseArgs parses flags wherever they are among the positional arguments, like "prune tech 30 --archive",
+returning the positional arguments in order.
+*/
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// printResult writes what a command did to w, as JSON if asked for.
+func printResult(w io.Writer, res result, asJSON bool) error {
+	if !asJSON {
+		_, err := fmt.Fprintln(w, res.String())
+		return err
+	}
+	return json.NewEncoder(w).Encode(res)
+}
+
+// commandError is a failed command, as printed with --json.
+type commandError struct {
+	Error string `json:"error"`
+}
+
+/*
+runCLI runs the command named by args, serving if there isn't one, and returns the code to exit with.
+Results are written to stdout and everything else logged to stderr, so scripts can read results with --json.
+*/
+func runCLI(args []string, stdout io.Writer) int {
+	var asJSON bool
+	global := flag.NewFlagSet("spirit", flag.ContinueOnError)
+	global.BoolVar(&asJSON, "json", false, "print results as JSON")
+	global.Usage = func() { printUsage(global.Output()) }
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	args = global.Args()
+
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		if len(args) == 0 {
+			printUsage(stdout)
+			return exitOK
+		}
+		name, args = args[0], []string{"--help"}
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.BoolVar(&asJSON, "json", asJSON, "print results as JSON")
+	run := cmd.define(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n\n%s\n\nFlags:\n", cmd.usage(), cmd.summary)
+		flags.PrintDefaults()
+	}
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(positional) < cmd.minArgs || len(positional) > cmd.maxArgs {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", cmd.usage())
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fail := func(err error) int {
+		log.Printf("%s failed: %v", cmd.name, err)
+		if asJSON {
+			json.NewEncoder(stdout).Encode(commandError{Error: err.Error()})
+		}
+		return exitFailed
+	}
+
+	conf := config.ParseEnv()
+	store, err := openStore(ctx, conf)
+	if err != nil {
+		return fail(err)
+	}
+	defer store.Cleanup(ctx)
+
+	res, err := run(ctx, conf, store, positional)
+	if err != nil {
+		return fail(err)
+	}
+	if res == nil {
+		return exitOK
+	}
+	if err := printResult(stdout, res, asJSON); err != nil {
+		log.Printf("Failed to print result: %v", err)
+		return exitFailed
+	}
+	if f, ok := res.(finding); ok && f.found() {
+		return exitFound
+	}
+	return exitOK
+}
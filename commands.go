@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"spiritchat/config"
+	"spiritchat/data"
+	"spiritchat/media"
+	"spiritchat/validation"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every command the binary runs, in the order they're listed in its usage.
+var commands = []*command{
+	{
+		name:    "serve",
+		summary: "serves the API, the default without a command",
+		define: func(flags *flag.FlagSet) runFunc {
+			return func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+				return nil, serveAPI(ctx, conf, store)
+			}
+		},
+	},
+	{
+		name:    "migrate",
+		args:    "up|down",
+		summary: "applies migrations up, or drops everything down",
+		minArgs: 1,
+		maxArgs: 1,
+		define: func(flags *flag.FlagSet) runFunc {
+			return runMigrate
+		},
+	},
+	{
+		name:    "gc",
+		summary: "collects orphaned attachments now, reporting the space reclaimed",
+		define: func(flags *flag.FlagSet) runFunc {
+			return runCollectOrphans
+		},
+	},
+	{
+		name:    "import",
+		args:    "<category> <file>",
+		summary: "copies posts from a file of JSON lines onto a category, renumbering them after its existing posts",
+		minArgs: 2,
+		maxArgs: 2,
+		define: func(flags *flag.FlagSet) runFunc {
+			batch := flags.Int("batch", 0, "posts imported per transaction (default 1000)")
+			return func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+				return importPosts(ctx, store, args[0], args[1], *batch)
+			}
+		},
+	},
+	{
+		name:    "export",
+		args:    "<category> <file>",
+		summary: "writes a category's posts to a file of JSON lines that import takes",
+		minArgs: 2,
+		maxArgs: 2,
+		define: func(flags *flag.FlagSet) runFunc {
+			return func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+				return exportPosts(ctx, store, args[0], args[1])
+			}
+		},
+	},
+	{
+		name:    "prune",
+		args:    "<category> <days>",
+		summary: "removes threads with no posts in the last days days",
+		minArgs: 2,
+		maxArgs: 2,
+		define: func(flags *flag.FlagSet) runFunc {
+			archive := flags.Bool("archive", false, "archive pruned threads before removing them")
+			batch := flags.Int("batch", 0, "threads pruned per transaction (default 1000)")
+			return func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+				return pruneThreads(ctx, store, args[0], args[1], *archive, *batch)
+			}
+		},
+	},
+	{
+		name:    "fsck",
+		summary: "repairs drifted category post counters and recounts the thread catalog",
+		define: func(flags *flag.FlagSet) runFunc {
+			check := flags.Bool("check", false, "only report drifted counters, exiting 3 if there are any")
+			return func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+				return checkPostCounts(ctx, store, !*check)
+			}
+		},
+	},
+	{
+		name:    "ban",
+		args:    "<ip or email>",
+		summary: "bans a poster by their IP or email",
+		minArgs: 1,
+		maxArgs: 1,
+		define: func(flags *flag.FlagSet) runFunc {
+			reason := flags.String("reason", "", "reason recorded against the ban")
+			days := flags.Int("days", 0, "days the ban lasts (default permanent)")
+			return func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+				return banPoster(ctx, store, args[0], *reason, *days)
+			}
+		},
+	},
+	{
+		name:    "canary",
+		args:    "<category> <subject> <content>",
+		summary: "makes a thread nothing links to, logging anything that requests it as a scraper",
+		minArgs: 3,
+		maxArgs: 3,
+		define: func(flags *flag.FlagSet) runFunc {
+			label := flags.String("label", "", "where the thread's being planted, shown alongside its requests")
+			return func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+				return writeCanary(ctx, store, args[0], args[1], args[2], *label)
+			}
+		},
+	},
+	{
+		name:    "rekey",
+		summary: "re-encrypts every stored email and IP with the current PII key",
+		define: func(flags *flag.FlagSet) runFunc {
+			batch := flags.Int("batch", 1000, "rows re-encrypted per transaction")
+			return func(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+				return rotatePIIKeys(ctx, store, *batch)
+			}
+		},
+	},
+}
+
+// migration is which way the database was migrated.
+type migration struct {
+	Direction string `json:"direction"`
+}
+
+func (m *migration) String() string {
+	return "Migrated " + m.Direction
+}
+
+func runMigrate(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+	direction := args[0]
+	if direction != "up" && direction != "down" {
+		return nil, fmt.Errorf("can only migrate up or down, not %q", direction)
+	}
+	log.Printf("Migrating %s", direction)
+	err := store.Migrate(ctx, direction == "up")
+	if err != nil {
+		return nil, err
+	}
+	return &migration{Direction: direction}, nil
+}
+
+// collection is what collecting orphaned attachments on demand reclaimed.
+type collection struct {
+	*media.Reclaimed
+}
+
+func (c *collection) String() string {
+	return fmt.Sprintf(
+		"Collected %d orphaned attachments, removing %d files and reclaiming %d bytes, %d files couldn't be removed",
+		c.Attachments, c.Files, c.Bytes, c.Failed,
+	)
+}
+
+func runCollectOrphans(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore, args []string) (result, error) {
+	uploads := getUploadOptions(conf)
+	if uploads.Storage == nil {
+		return nil, errors.New("uploads aren't enabled, there's nothing to collect")
+	}
+	reclaimed, err := media.CollectOrphans(ctx, store, uploads.Storage, time.Duration(conf.AttachmentGraceHours)*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	return &collection{Reclaimed: reclaimed}, nil
+}
+
+// postsMoved counts posts imported into or exported from a category.
+type postsMoved struct {
+	Category string `json:"category"`
+	Posts    int    `json:"posts"`
+	verb     string
+}
+
+func (p *postsMoved) String() string {
+	return fmt.Sprintf("%s %d posts on %s", p.verb, p.Posts, p.Category)
+}
+
+/*
+Imports posts into a category from a file of JSON lines, one data.ImportedPost each with threads before
+their replies, sanitizing them like new posts. Nothing is imported if any post is invalid.
+*/
+func importPosts(ctx context.Context, store *data.DataStore, categoryTag string, path string, batch int) (result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var posts []*data.ImportedPost
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		post := &data.ImportedPost{}
+		err := decoder.Decode(post)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read post %d: %w", len(posts)+1, err)
+		}
+		post.Subject, err = validation.ValidateReplySubject(post.Subject, post.Parent == 0)
+		if err == nil {
+			post.Content, err = validation.ValidateReplyContent(post.Content)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("post %d is invalid: %w", post.Num, err)
+		}
+		posts = append(posts, post)
+	}
+
+	opts := data.BulkOptions{BatchSize: batch, Progress: logProgress("Imported", "posts")}
+	imported, err := store.ImportPosts(ctx, categoryTag, posts, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import posts after %d were imported: %w", imported, err)
+	}
+	return &postsMoved{Category: categoryTag, Posts: imported, verb: "Imported"}, nil
+}
+
+// Exports a category's posts to a file of JSON lines, in the shape importPosts reads.
+func exportPosts(ctx context.Context, store *data.DataStore, categoryTag string, path string) (result, error) {
+	if _, err := store.GetCategory(ctx, categoryTag); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buffered)
+	exported, err := store.ExportPosts(ctx, categoryTag, func(post *data.ImportedPost) error {
+		return encoder.Encode(post)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export posts after %d were exported: %w", exported, err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return &postsMoved{Category: categoryTag, Posts: exported, verb: "Exported"}, nil
+}
+
+// prune is what pruning a category removed.
+type prune struct {
+	Category string `json:"category"`
+	*data.ThreadPrune
+}
+
+func (p *prune) String() string {
+	return fmt.Sprintf("Pruned %d threads from %s, removing %d posts", p.Threads, p.Category, p.Posts)
+}
+
+func pruneThreads(ctx context.Context, store *data.DataStore, categoryTag string, days string, archive bool, batch int) (result, error) {
+	n, err := strconv.Atoi(days)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid number of days %q", days)
+	}
+	opts := data.BulkOptions{BatchSize: batch, Progress: logProgress("Pruned", "threads")}
+	pruned, err := store.PruneThreads(ctx, categoryTag, time.Now().AddDate(0, 0, -n), archive, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &prune{Category: categoryTag, ThreadPrune: pruned}, nil
+}
+
+// fsck is the categories found with drifted post counters, and what was repaired.
+type fsck struct {
+	Drifted  []*data.CounterDrift `json:"drifted"`
+	Repaired bool                 `json:"repaired"`
+	// Threads corrected in the catalog, when repairing.
+	CatalogFixed int `json:"catalogFixed"`
+}
+
+func (f *fsck) String() string {
+	lines := make([]string, 0, len(f.Drifted)+2)
+	for _, drift := range f.Drifted {
+		lines = append(lines, describeDrift(drift, f.Repaired))
+	}
+	lines = append(lines, fmt.Sprintf("Found %d categories with drifted post counters", len(f.Drifted)))
+	if f.Repaired {
+		lines = append(lines, fmt.Sprintf("Corrected %d threads in the catalog", f.CatalogFixed))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Drift that's only been checked is left for whoever's running the check to deal with.
+func (f *fsck) found() bool {
+	return !f.Repaired && len(f.Drifted) > 0
+}
+
+func checkPostCounts(ctx context.Context, store *data.DataStore, repair bool) (result, error) {
+	drifted, err := store.CheckPostCounts(ctx, repair)
+	if err != nil {
+		return nil, err
+	}
+	checked := &fsck{Drifted: drifted, Repaired: repair}
+	if repair {
+		checked.CatalogFixed, err = store.RefreshCatalog(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return checked, nil
+}
+
+// ban is a poster banned from the command line.
+type ban struct {
+	PosterHash string     `json:"posterHash"`
+	Expires    *time.Time `json:"expires,omitempty"`
+}
+
+func (b *ban) String() string {
+	if b.Expires == nil {
+		return "Banned " + b.PosterHash + " permanently"
+	}
+	return "Banned " + b.PosterHash + " until " + b.Expires.Format(time.RFC1123)
+}
+
+// Bans the poster hash of an IP or email.
+func banPoster(ctx context.Context, store *data.DataStore, target string, reason string, days int) (result, error) {
+	if days < 0 {
+		return nil, fmt.Errorf("invalid number of days %d", days)
+	}
+	banFor := time.Duration(days) * time.Hour * 24
+	banned := &ban{PosterHash: data.PosterHash(target)}
+	if banFor > 0 {
+		expires := time.Now().Add(banFor)
+		banned.Expires = &expires
+	}
+	err := store.BanPoster(ctx, banned.PosterHash, reason, banFor)
+	if err != nil {
+		return nil, err
+	}
+	return banned, nil
+}
+
+// canary is a canary thread made from the command line, and where it's served.
+type canary struct {
+	*data.Canary
+	Path string `json:"path"`
+}
+
+func (c *canary) String() string {
+	return fmt.Sprintf("Made canary thread %d on %s, plant %s somewhere only scrapers will find it", c.Num, c.Cat, c.Path)
+}
+
+// Makes a canary thread on a category, sanitizing it like a new thread so it passes for one.
+func writeCanary(ctx context.Context, store *data.DataStore, categoryTag string, subject string, content string, label string) (result, error) {
+	category, err := store.GetCategory(ctx, categoryTag)
+	if err != nil {
+		return nil, err
+	}
+	subject, err = validation.ValidateReplySubject(subject, true)
+	if err != nil {
+		return nil, err
+	}
+	content, err = validation.ValidateReplyContent(content)
+	if err != nil {
+		return nil, err
+	}
+	made := &data.Canary{Cat: categoryTag, Label: strings.TrimSpace(label), Subject: subject, Content: content}
+	err = store.WriteCanary(ctx, made)
+	if err != nil {
+		return nil, err
+	}
+	return &canary{Canary: made, Path: fmt.Sprintf("/v1/categories/%s/%d", category.Slug, made.Num)}, nil
+}
+
+// rekeyed counts the rows re-encrypted with the current PII key, by table.
+type rekeyed struct {
+	Tables map[string]int64 `json:"tables"`
+}
+
+func (r *rekeyed) String() string {
+	tables := make([]string, 0, len(r.Tables))
+	for table := range r.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	counts := make([]string, len(tables))
+	for i, table := range tables {
+		counts[i] = fmt.Sprintf("%d %s", r.Tables[table], table)
+	}
+	return "Re-encrypted " + strings.Join(counts, ", ") + ", old keys can be removed"
+}
+
+// Re-encrypts emails and IPs sealed with old keys, or stored before encryption was enabled.
+func rotatePIIKeys(ctx context.Context, store *data.DataStore, batch int) (result, error) {
+	log.Println("Re-encrypting PII with the current key")
+	tables, err := store.RotatePIIKeys(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	return &rekeyed{Tables: tables}, nil
+}
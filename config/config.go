@@ -1,7 +1,11 @@
 package config
 
 import (
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 /*
@@ -9,52 +13,418 @@ GetIntegrationsConfig is a testing function,
 returns false if integrations shouldn't be run, or true, and integration config.
 */
 func GetIntegrationsConfig() (*SpiritConfig, bool) {
-	val, present := os.LookupEnv("SPIRIT_INTEGRATIONS")
-	runIntegrations := false
-	if present && len(val) > 0 && val != "0" && val != "FALSE" {
-		runIntegrations = true
-	}
-
-	return ParseEnv(), runIntegrations
+	return ParseEnv(), lookupBool("SPIRIT_INTEGRATIONS")
 }
 
 type SpiritAuthConfig struct {
 	Domain       string
 	ClientID     string
 	ClientSecret string
+	// Staff maps verified emails to the roles they're granted.
+	Staff map[string][]string
+	// Social login providers mapped to their Auth0 connections, and where logins come back to.
+	SocialProviders   map[string]string
+	SocialRedirectURI string
+	/*
+		Gives tokens without any scopes read-only access unless they were issued to ClientID, instead of treating
+		them all as first-party tokens with every scope. On unless turned off.
+	*/
+	RequireScopes bool
+	// API identifier access tokens must be issued for before their scope and client claims are trusted.
+	Audience string
+}
+
+// Auth0's connection names for social providers, others are assumed to be named after the provider.
+var socialConnections = map[string]string{
+	"google": "google-oauth2",
+}
+
+/*
+parseSocialProviders parses a comma separated list of social providers, optionally naming their
+Auth0 connection, e.g. "google,github,discord=discord-custom".
+*/
+func parseSocialProviders(val string) map[string]string {
+	providers := make(map[string]string)
+	for _, entry := range splitList(val) {
+		provider, connection, ok := strings.Cut(entry, "=")
+		if !ok {
+			connection = provider
+			if known, ok := socialConnections[provider]; ok {
+				connection = known
+			}
+		}
+		if len(provider) == 0 || len(connection) == 0 {
+			log.Printf("ignoring invalid social provider entry %q", entry)
+			continue
+		}
+		providers[provider] = connection
+	}
+	return providers
 }
 
-func parseAuthEnv() SpiritAuthConfig {
+/*
+parseStaff parses a comma separated list of role=email pairs,
+e.g. "admin=a@example.com,moderator=b@example.com".
+*/
+func parseStaff(val string) map[string][]string {
+	staff := make(map[string][]string)
+	for _, pair := range strings.Split(val, ",") {
+		role, email, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || len(role) == 0 || len(email) == 0 {
+			if len(pair) > 0 {
+				log.Printf("ignoring invalid staff entry %q", pair)
+			}
+			continue
+		}
+		staff[email] = append(staff[email], role)
+	}
+	return staff
+}
+
+/*
+parseReputationPolicies parses a comma separated list of category=policy pairs,
+where the category * sets the default, e.g. "*=captcha,tech=block".
+*/
+func parseReputationPolicies(val string) map[string]string {
+	policies := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		cat, policy, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || len(cat) == 0 || len(policy) == 0 {
+			if len(pair) > 0 {
+				log.Printf("ignoring invalid reputation policy entry %q", pair)
+			}
+			continue
+		}
+		policies[cat] = policy
+	}
+	return policies
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(val string) []string {
+	var list []string
+	for _, entry := range strings.Split(val, ",") {
+		if entry = strings.TrimSpace(entry); len(entry) > 0 {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func parseAuthEnv(secrets *secrets) SpiritAuthConfig {
 	return SpiritAuthConfig{
 		Domain:       os.Getenv("AUTH_DOMAIN"),
 		ClientID:     os.Getenv("AUTH_CLIENTID"),
-		ClientSecret: os.Getenv("AUTH_CLIENTSECRET"),
+		ClientSecret: secrets.lookup("AUTH_CLIENTSECRET"),
+		Staff:        parseStaff(os.Getenv("SPIRITCHAT_STAFF")),
+
+		SocialProviders:   parseSocialProviders(os.Getenv("SPIRITCHAT_SOCIAL_PROVIDERS")),
+		SocialRedirectURI: os.Getenv("SPIRITCHAT_SOCIAL_REDIRECT_URI"),
+
+		RequireScopes: lookupBoolDefault("SPIRITCHAT_REQUIRE_SCOPES", true),
+		Audience:      os.Getenv("AUTH_AUDIENCE"),
 	}
 }
 
+// lookupInt returns the integer value of an environment variable, or def if unset or invalid.
+func lookupInt(key string, def int) int {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf("ignoring invalid %s %q, using %d", key, val, def)
+		return def
+	}
+	return n
+}
+
+// lookupBool returns true if an environment variable is set to a truthy value.
+func lookupBool(key string) bool {
+	val, present := os.LookupEnv(key)
+	return present && len(val) > 0 && val != "0" && val != "FALSE"
+}
+
+// lookupBoolDefault is lookupBool for settings that default to def when the environment variable's unset.
+func lookupBoolDefault(key string, def bool) bool {
+	if _, present := os.LookupEnv(key); !present {
+		return def
+	}
+	return lookupBool(key)
+}
+
 // SpiritConfig stores configuration for the app.
 type SpiritConfig struct {
 	HTTPAddress string
-	CORSAllow   string
-	PGURL       string
-	AuthConfig  SpiritAuthConfig
+	// Serves admin and moderation routes here instead of on HTTPAddress when set, like 127.0.0.1:3001.
+	AdminAddress string
+	CORSAllow    string
+	PGURL        string
+	AuthConfig   SpiritAuthConfig
+
+	// Connection attempts made against Postgres on startup, and the backoff between them.
+	PGConnectAttempts   int
+	PGConnectBackoff    time.Duration
+	PGConnectMaxBackoff time.Duration
+	// Longest any one query can run while serving, zero for no limit.
+	PGQueryTimeout time.Duration
+
+	// Redis is optional, posts are rate limited in memory without it.
+	RedisURL string
+	// Attempts made to reach Redis at startup, and the backoff between them.
+	RedisConnectAttempts   int
+	RedisConnectBackoff    time.Duration
+	RedisConnectMaxBackoff time.Duration
+	PostCooldownSeconds    int
+	// Time between posts by the same account, whatever IP they post from.
+	AccountCooldownSeconds int
+	// Hours unverified accounts can reply for after they're first seen, zero to require verification.
+	UnverifiedGraceHours int
+	// Reject posts rather than skipping rate limiting while Redis is down.
+	RateLimitFailClosed bool
+	// Where rate limits are kept, "redis", "memory" or "none". Redis if there's a URL for it otherwise.
+	RateLimitBackend string
+	// Posts allowed in a row before the cooldown applies, with the in-memory limiter.
+	RateLimitBurst int
+	// GET requests made a minute without logging in, from each IP and with each client key. Zero doesn't limit reads.
+	ReadsPerMinute          int
+	ClientKeyReadsPerMinute int
+	// How live events reach other instances, "redis" or "none". Redis if there's a URL for it otherwise.
+	EventRelay string
+	// Reports server errors and panics to Sentry when set.
+	SentryDSN         string
+	SentryEnvironment string
+	// Server abuse complaints are emailed through, they're only queued for moderators without one.
+	SMTPAddress  string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	// Where abuse complaints and takedown notices are emailed.
+	AbuseEmails []string
+
+	// External search engine, "meilisearch" or "opensearch". Postgres is searched without one.
+	SearchBackend  string
+	SearchURL      string
+	SearchIndex    string
+	SearchAPIKey   string
+	SearchUsername string
+	SearchPassword string
+
+	// Base64 AES-256 key, deleted posts are kept encrypted in retention when set.
+	RetentionKey  string
+	RetentionDays int
+
+	// Base64 AES-256 keys, current first, emails and IPs are encrypted in the database when set.
+	PIIKeys []string
+	// Secret posters' IPs and emails are hashed with.
+	PosterHashKey string
+
+	// Posts scoring above AntibotMaxScore are rejected as likely bots, zero disables scoring.
+	AntibotMaxScore      int
+	AntibotHoneypot      string
+	AntibotMinReplyDelay time.Duration
+
+	// IP reputation sources, posters' IPs are only checked if at least one is set.
+	DNSBLZones          []string
+	TorExitListURL      string
+	ReputationAPIURL    string
+	ReputationAPIFields []string
+	// Minutes reputation results are cached in Redis for.
+	ReputationCacheMinutes int
+	// Maps category tags, or * for the default, to reputation policies.
+	ReputationPolicies map[string]string
+
+	// Captcha siteverify endpoint and secret, required by the captcha reputation policy.
+	CaptchaVerifyURL string
+	CaptchaSecret    string
+
+	// Serves each category's moderation log publicly when set.
+	PublicModLog bool
+	// Serves a page warning people following links in posts off the board when set.
+	LinkRedirect bool
+	// Rejects first posts to categories with rules unless the poster accepts them.
+	RequireRulesAcceptance bool
+	// Lets thread authors delete replies to their threads.
+	OPDeleteReplies bool
+	// Refuses staff roles to sessions that haven't had a two-factor code entered.
+	StaffTwoFactor bool
+
+	// Starts the API read-only, rejecting everything but GET requests.
+	ReadOnly        bool
+	ReadOnlyMessage string
+
+	// Describes the board to clients.
+	BoardName        string
+	BoardDescription string
+	ContactEmail     string
+	// Where the board's site is served, for short links to redirect to.
+	SiteURL string
+	// IANA name of the board's local timezone, like Europe/London, defaulting to UTC.
+	Timezone string
+	// Directory of message catalogs named by language, like fr.json, messages are English without one.
+	LocalesDir string
+
+	// Directory uploads are stored in, uploads are disabled without one.
+	UploadDir       string
+	MaxImageMB      int
+	MaxMediaMB      int
+	MaxMediaSeconds int
+	// Audio and video uploads need ffprobe, and ffmpeg for posters and transcoding.
+	FFprobePath string
+	FFmpegPath  string
+	// Transcodes audio and video uploads to MP3 and MP4 in the background.
+	Transcode bool
+	// Re-encodes uploaded images rather than only stripping their metadata.
+	ReencodeImages bool
+	// Storage all attachments can take up, zero for no quota.
+	StorageQuotaMB int
+	// Formats thumbnails are encoded as with ffmpeg besides JPEG, webp and avif.
+	ThumbnailFormats []string
+	// Hours between digests of subscribed categories, zero disables them.
+	DigestHours int
+	// Most threads in a digest.
+	DigestSize int
+	// How long attachments of deleted posts, and uploads that weren't posted, are kept before they're collected.
+	AttachmentGraceHours int
+	// Usernames and display names nobody can take, or anything that looks like them.
+	ReservedNames []string
+	// Origins browsers can send writes from, any if empty.
+	TrustedOrigins []string
+	// Minutes between recounts of the thread catalog category views are served from, zero disables them.
+	CatalogRefreshMinutes int
 }
 
 // ParseEnv parses system environment variables, returning app configuration.
 func ParseEnv() *SpiritConfig {
+	secrets := loadSecrets()
 
 	conf := &SpiritConfig{
 		HTTPAddress: "0.0.0.0:3000",
 		CORSAllow:   "https://example.com",
-		PGURL:       os.Getenv("SPIRITCHAT_PG_URL"),
-		AuthConfig:  parseAuthEnv(),
+		PGURL:       secrets.lookup("SPIRITCHAT_PG_URL"),
+		AuthConfig:  parseAuthEnv(secrets),
+
+		PGConnectAttempts:   lookupInt("SPIRITCHAT_PG_CONNECT_ATTEMPTS", 10),
+		PGConnectBackoff:    time.Duration(lookupInt("SPIRITCHAT_PG_CONNECT_BACKOFF_MS", 500)) * time.Millisecond,
+		PGConnectMaxBackoff: time.Duration(lookupInt("SPIRITCHAT_PG_CONNECT_MAX_BACKOFF_MS", 15000)) * time.Millisecond,
+		PGQueryTimeout:      time.Duration(lookupInt("SPIRITCHAT_PG_QUERY_TIMEOUT_MS", 10000)) * time.Millisecond,
+
+		RedisURL:               secrets.lookup("SPIRITCHAT_REDIS_URL"),
+		RedisConnectAttempts:   lookupInt("SPIRITCHAT_REDIS_CONNECT_ATTEMPTS", 10),
+		RedisConnectBackoff:    time.Duration(lookupInt("SPIRITCHAT_REDIS_CONNECT_BACKOFF_MS", 200)) * time.Millisecond,
+		RedisConnectMaxBackoff: time.Duration(lookupInt("SPIRITCHAT_REDIS_CONNECT_MAX_BACKOFF_MS", 5000)) * time.Millisecond,
+		EventRelay:             os.Getenv("SPIRITCHAT_EVENT_RELAY"),
+		SentryDSN:              secrets.lookup("SPIRITCHAT_SENTRY_DSN"),
+		SentryEnvironment:      os.Getenv("SPIRITCHAT_SENTRY_ENVIRONMENT"),
+		SMTPAddress:            os.Getenv("SPIRITCHAT_SMTP_ADDRESS"),
+		SMTPUsername:           os.Getenv("SPIRITCHAT_SMTP_USERNAME"),
+		SMTPPassword:           secrets.lookup("SPIRITCHAT_SMTP_PASSWORD"),
+		SMTPFrom:               os.Getenv("SPIRITCHAT_SMTP_FROM"),
+		PostCooldownSeconds:    lookupInt("SPIRITCHAT_POST_COOLDOWN_SECONDS", 30),
+		AccountCooldownSeconds: lookupInt("SPIRITCHAT_ACCOUNT_COOLDOWN_SECONDS", 30),
+		UnverifiedGraceHours:   lookupInt("SPIRITCHAT_UNVERIFIED_GRACE_HOURS", 0),
+		RateLimitFailClosed:    lookupBool("SPIRITCHAT_RATELIMIT_FAIL_CLOSED"),
+		RateLimitBackend:       os.Getenv("SPIRITCHAT_RATELIMIT_BACKEND"),
+		RateLimitBurst:         lookupInt("SPIRITCHAT_RATELIMIT_BURST", 1),
+
+		ReadsPerMinute:          lookupInt("SPIRITCHAT_READS_PER_MINUTE", 600),
+		ClientKeyReadsPerMinute: lookupInt("SPIRITCHAT_CLIENT_KEY_READS_PER_MINUTE", 6000),
+
+		SearchBackend:  os.Getenv("SPIRITCHAT_SEARCH_BACKEND"),
+		SearchURL:      os.Getenv("SPIRITCHAT_SEARCH_URL"),
+		SearchIndex:    "posts",
+		SearchAPIKey:   secrets.lookup("SPIRITCHAT_SEARCH_API_KEY"),
+		SearchUsername: os.Getenv("SPIRITCHAT_SEARCH_USERNAME"),
+		SearchPassword: secrets.lookup("SPIRITCHAT_SEARCH_PASSWORD"),
+
+		RetentionKey:  secrets.lookup("SPIRITCHAT_RETENTION_KEY"),
+		RetentionDays: lookupInt("SPIRITCHAT_RETENTION_DAYS", 90),
+
+		PIIKeys:       splitList(secrets.lookup("SPIRITCHAT_PII_KEYS")),
+		PosterHashKey: secrets.lookup("SPIRITCHAT_POSTER_HASH_KEY"),
+
+		AntibotMaxScore:      lookupInt("SPIRITCHAT_ANTIBOT_MAX_SCORE", 0),
+		AntibotHoneypot:      os.Getenv("SPIRITCHAT_ANTIBOT_HONEYPOT"),
+		AntibotMinReplyDelay: time.Duration(lookupInt("SPIRITCHAT_ANTIBOT_MIN_REPLY_MS", 3000)) * time.Millisecond,
+
+		DNSBLZones:             splitList(os.Getenv("SPIRITCHAT_DNSBL_ZONES")),
+		TorExitListURL:         os.Getenv("SPIRITCHAT_TOR_EXIT_LIST_URL"),
+		ReputationAPIURL:       os.Getenv("SPIRITCHAT_REPUTATION_API_URL"),
+		ReputationAPIFields:    []string{"proxy", "vpn", "tor"},
+		ReputationCacheMinutes: lookupInt("SPIRITCHAT_REPUTATION_CACHE_MINUTES", 60),
+		ReputationPolicies:     parseReputationPolicies(os.Getenv("SPIRITCHAT_REPUTATION_POLICY")),
+
+		CaptchaVerifyURL: os.Getenv("SPIRITCHAT_CAPTCHA_VERIFY_URL"),
+		CaptchaSecret:    secrets.lookup("SPIRITCHAT_CAPTCHA_SECRET"),
+
+		PublicModLog:           lookupBool("SPIRITCHAT_PUBLIC_MODLOG"),
+		LinkRedirect:           lookupBool("SPIRITCHAT_LINK_REDIRECT"),
+		RequireRulesAcceptance: lookupBool("SPIRITCHAT_REQUIRE_RULES_ACCEPTANCE"),
+		OPDeleteReplies:        lookupBool("SPIRITCHAT_OP_DELETE_REPLIES"),
+		StaffTwoFactor:         lookupBool("SPIRITCHAT_STAFF_2FA"),
+
+		ReadOnly:        lookupBool("SPIRITCHAT_READ_ONLY"),
+		ReadOnlyMessage: os.Getenv("SPIRITCHAT_READ_ONLY_MESSAGE"),
+
+		BoardName:        os.Getenv("SPIRITCHAT_BOARD_NAME"),
+		BoardDescription: os.Getenv("SPIRITCHAT_BOARD_DESCRIPTION"),
+		ContactEmail:     os.Getenv("SPIRITCHAT_CONTACT_EMAIL"),
+		SiteURL:          os.Getenv("SPIRITCHAT_SITE_URL"),
+		Timezone:         os.Getenv("SPIRITCHAT_TIMEZONE"),
+		LocalesDir:       os.Getenv("SPIRITCHAT_LOCALES_DIR"),
+
+		UploadDir:            os.Getenv("SPIRITCHAT_UPLOAD_DIR"),
+		MaxImageMB:           lookupInt("SPIRITCHAT_MAX_IMAGE_MB", 8),
+		MaxMediaMB:           lookupInt("SPIRITCHAT_MAX_MEDIA_MB", 64),
+		MaxMediaSeconds:      lookupInt("SPIRITCHAT_MAX_MEDIA_SECONDS", 300),
+		FFprobePath:          "ffprobe",
+		FFmpegPath:           "ffmpeg",
+		Transcode:            lookupBool("SPIRITCHAT_TRANSCODE"),
+		ReencodeImages:       lookupBool("SPIRITCHAT_REENCODE_IMAGES"),
+		StorageQuotaMB:       lookupInt("SPIRITCHAT_STORAGE_QUOTA_MB", 0),
+		ThumbnailFormats:     []string{"webp", "avif"},
+		AttachmentGraceHours: lookupInt("SPIRITCHAT_ATTACHMENT_GRACE_HOURS", 24),
+		DigestHours:          lookupInt("SPIRITCHAT_DIGEST_HOURS", 24),
+		DigestSize:           lookupInt("SPIRITCHAT_DIGEST_SIZE", 10),
+
+		CatalogRefreshMinutes: lookupInt("SPIRITCHAT_CATALOG_REFRESH_MINUTES", 60),
+	}
+	if names, ok := os.LookupEnv("SPIRITCHAT_RESERVED_NAMES"); ok {
+		conf.ReservedNames = splitList(names)
+	}
+	if origins, ok := os.LookupEnv("SPIRITCHAT_TRUSTED_ORIGINS"); ok {
+		conf.TrustedOrigins = splitList(origins)
+	}
+	if emails, ok := os.LookupEnv("SPIRITCHAT_ABUSE_EMAIL"); ok {
+		conf.AbuseEmails = splitList(emails)
+	}
+	if formats, ok := os.LookupEnv("SPIRITCHAT_THUMBNAIL_FORMATS"); ok {
+		conf.ThumbnailFormats = splitList(formats)
+	}
+	if path, ok := os.LookupEnv("SPIRITCHAT_FFPROBE_PATH"); ok {
+		conf.FFprobePath = path
+	}
+	if path, ok := os.LookupEnv("SPIRITCHAT_FFMPEG_PATH"); ok {
+		conf.FFmpegPath = path
 	}
 	if addr, ok := os.LookupEnv("SPIRITCHAT_ADDRESS"); ok {
 		conf.HTTPAddress = addr
 	}
+	if addr, ok := os.LookupEnv("SPIRITCHAT_ADMIN_ADDRESS"); ok {
+		conf.AdminAddress = addr
+	}
 
 	if allow, ok := os.LookupEnv("SPIRITCHAT_CORS_ALLOW"); ok {
 		conf.CORSAllow = allow
 	}
+
+	if index, ok := os.LookupEnv("SPIRITCHAT_SEARCH_INDEX"); ok {
+		conf.SearchIndex = index
+	}
+
+	if fields, ok := os.LookupEnv("SPIRITCHAT_REPUTATION_API_FIELDS"); ok {
+		conf.ReputationAPIFields = splitList(fields)
+	}
 	return conf
 }
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+secrets looks up secrets like the Postgres URL, from the environment variable itself,
+a file named by the variable suffixed with _FILE (as with Docker secrets), or Vault, in that order.
+*/
+type secrets struct {
+	vault map[string]string
+}
+
+/*
+loadSecrets reads the Vault secret at SPIRITCHAT_VAULT_PATH when SPIRITCHAT_VAULT_ADDR is set.
+Its fields are named after the environment variables they stand in for, like SPIRITCHAT_PG_URL.
+*/
+func loadSecrets() *secrets {
+	s := &secrets{}
+	addr := os.Getenv("SPIRITCHAT_VAULT_ADDR")
+	if len(addr) == 0 {
+		return s
+	}
+	vault, err := readVault(addr, s.lookup("SPIRITCHAT_VAULT_TOKEN"), os.Getenv("SPIRITCHAT_VAULT_PATH"))
+	if err != nil {
+		log.Printf("Failed to read secrets from Vault: %v", err)
+		return s
+	}
+	s.vault = vault
+	return s
+}
+
+// lookup returns the secret for an environment variable, or an empty string if it's nowhere to be found.
+func (s *secrets) lookup(key string) string {
+	if val, ok := os.LookupEnv(key); ok {
+		return val
+	}
+	if path := os.Getenv(key + "_FILE"); len(path) > 0 {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Failed to read %s_FILE: %v", key, err)
+			return ""
+		}
+		return strings.TrimRight(string(contents), "\r\n")
+	}
+	return s.vault[key]
+}
+
+// readVault reads a secret's fields from a Vault KV version 2 engine, at a path like secret/data/spiritchat.
+func readVault(addr string, token string, path string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	client := &http.Client{Timeout: time.Second * 10}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault responded %s", res.Status)
+	}
+
+	var secret struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("failed to decode vault secret: %w", err)
+	}
+	return secret.Data.Data, nil
+}
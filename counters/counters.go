@@ -0,0 +1,307 @@
+package counters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strconv"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+Counter caches how many threads each category has and how many replies each thread has, so they can be shown
+without counting posts. Counts are kept up as posts are made and removed, and categories that haven't been
+counted yet, or whose counts were lost, are left out until Reset.
+*/
+type Counter interface {
+	// Posted counts a new post, a thread if parent is zero or a reply to parent.
+	Posted(ctx context.Context, categoryTag string, num int, parent int) error
+	// Removed stops counting a removed post, and a removed thread's replies.
+	Removed(ctx context.Context, categoryTag string, num int, parent int) error
+	// Threads returns how many threads each of the categories has, leaving out those that haven't been counted.
+	Threads(ctx context.Context, categoryTags []string) (map[string]int, error)
+	// Replies returns how many replies a thread has, or false if it hasn't been counted.
+	Replies(ctx context.Context, categoryTag string, thread int) (int, bool, error)
+	// Reset replaces a category's counts with the reply counts of each of its threads.
+	Reset(ctx context.Context, categoryTag string, replies map[int]int) error
+}
+
+// Subscribe keeps the counter up as posts are made and removed.
+func Subscribe(bus *events.Bus, counter Counter) {
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		if err := counter.Posted(ctx, event.Category, event.Num, event.Parent); err != nil {
+			log.Printf("failed to count post %s/%d: %v", event.Category, event.Num, err)
+		}
+	}, events.PostCreated)
+
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		if err := counter.Removed(ctx, event.Category, event.Num, event.Parent); err != nil {
+			log.Printf("failed to uncount post %s/%d: %v", event.Category, event.Num, err)
+		}
+	}, events.PostDeleted)
+}
+
+// Source is where counts are reconciled from.
+type Source interface {
+	GetCategories(ctx context.Context) ([]*data.Category, error)
+	GetReplyCounts(ctx context.Context, categoryTag string) (map[int]int, error)
+}
+
+// Reconcile resets every category's counts from the source, returning how many categories were counted.
+func Reconcile(ctx context.Context, source Source, counter Counter) (int, error) {
+	categories, err := source.GetCategories(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, category := range categories {
+		replies, err := source.GetReplyCounts(ctx, category.Tag)
+		if err != nil {
+			return i, err
+		}
+		err = counter.Reset(ctx, category.Tag, replies)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(categories), nil
+}
+
+// Count a post if the category's been counted, and its thread if it's a reply.
+var postedScript = redis.NewScript(1, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if ARGV[2] == "0" then
+	redis.call("HINCRBY", KEYS[1], "threads", 1)
+	redis.call("HSET", KEYS[1], ARGV[1], 0)
+elseif redis.call("HEXISTS", KEYS[1], ARGV[2]) == 1 then
+	redis.call("HINCRBY", KEYS[1], ARGV[2], 1)
+end
+return 1
+`)
+
+// Uncount a post. Replies removed without their thread leave the category uncounted until it's next reset.
+var removedScript = redis.NewScript(1, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if ARGV[2] ~= "0" then
+	if redis.call("HEXISTS", KEYS[1], ARGV[2]) == 1 then
+		redis.call("HINCRBY", KEYS[1], ARGV[2], -1)
+	end
+elseif redis.call("HDEL", KEYS[1], ARGV[1]) == 1 then
+	redis.call("HINCRBY", KEYS[1], "threads", -1)
+else
+	redis.call("DEL", KEYS[1])
+end
+return 1
+`)
+
+// Redis is a Counter shared between every instance using the same Redis.
+type Redis struct {
+	pool *redis.Pool
+}
+
+// NewRedis creates a counter using connections from the given pool.
+func NewRedis(pool *redis.Pool) *Redis {
+	return &Redis{pool: pool}
+}
+
+// Each category's counts are a hash of its threads' reply counts by number, and its thread count.
+func redisKey(categoryTag string) string {
+	return "counts:" + categoryTag
+}
+
+func (r *Redis) Posted(ctx context.Context, categoryTag string, num int, parent int) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = postedScript.Do(conn, redisKey(categoryTag), num, parent)
+	if err != nil {
+		return fmt.Errorf("failed to count post: %w", err)
+	}
+	return nil
+}
+
+func (r *Redis) Removed(ctx context.Context, categoryTag string, num int, parent int) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = removedScript.Do(conn, redisKey(categoryTag), num, parent)
+	if err != nil {
+		return fmt.Errorf("failed to uncount post: %w", err)
+	}
+	return nil
+}
+
+func (r *Redis) Threads(ctx context.Context, categoryTags []string) (map[string]int, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	for _, tag := range categoryTags {
+		err := conn.Send("HGET", redisKey(tag), "threads")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get thread counts: %w", err)
+		}
+	}
+	err = conn.Flush()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread counts: %w", err)
+	}
+	counts := make(map[string]int, len(categoryTags))
+	for _, tag := range categoryTags {
+		count, err := redis.Int(conn.Receive())
+		if errors.Is(err, redis.ErrNil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get thread counts: %w", err)
+		}
+		counts[tag] = count
+	}
+	return counts, nil
+}
+
+func (r *Redis) Replies(ctx context.Context, categoryTag string, thread int) (int, bool, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	count, err := redis.Int(conn.Do("HGET", redisKey(categoryTag), thread))
+	if errors.Is(err, redis.ErrNil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get reply count: %w", err)
+	}
+	return count, true, nil
+}
+
+func (r *Redis) Reset(ctx context.Context, categoryTag string, replies map[int]int) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	args := redis.Args{redisKey(categoryTag), "threads", len(replies)}
+	for thread, count := range replies {
+		args = append(args, strconv.Itoa(thread), count)
+	}
+	conn.Send("MULTI")
+	conn.Send("DEL", redisKey(categoryTag))
+	conn.Send("HSET", args...)
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		return fmt.Errorf("failed to reset counts: %w", err)
+	}
+	return nil
+}
+
+type memoryCounts struct {
+	threads int
+	replies map[int]int
+}
+
+// Memory is a Counter held in process, for running a single instance without Redis.
+type Memory struct {
+	mut    sync.Mutex
+	counts map[string]*memoryCounts
+}
+
+// NewMemory creates an in process counter.
+func NewMemory() *Memory {
+	return &Memory{counts: make(map[string]*memoryCounts)}
+}
+
+func (m *Memory) Posted(ctx context.Context, categoryTag string, num int, parent int) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	counts, ok := m.counts[categoryTag]
+	if !ok {
+		return nil
+	}
+	if parent == 0 {
+		counts.threads++
+		counts.replies[num] = 0
+	} else if _, ok := counts.replies[parent]; ok {
+		counts.replies[parent]++
+	}
+	return nil
+}
+
+func (m *Memory) Removed(ctx context.Context, categoryTag string, num int, parent int) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	counts, ok := m.counts[categoryTag]
+	if !ok {
+		return nil
+	}
+	if parent != 0 {
+		if _, ok := counts.replies[parent]; ok {
+			counts.replies[parent]--
+		}
+	} else if _, ok := counts.replies[num]; ok {
+		delete(counts.replies, num)
+		counts.threads--
+	} else {
+		// A reply removed without its thread, which can't be uncounted.
+		delete(m.counts, categoryTag)
+	}
+	return nil
+}
+
+func (m *Memory) Threads(ctx context.Context, categoryTags []string) (map[string]int, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	threads := make(map[string]int, len(categoryTags))
+	for _, tag := range categoryTags {
+		if counts, ok := m.counts[tag]; ok {
+			threads[tag] = counts.threads
+		}
+	}
+	return threads, nil
+}
+
+func (m *Memory) Replies(ctx context.Context, categoryTag string, thread int) (int, bool, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	counts, ok := m.counts[categoryTag]
+	if !ok {
+		return 0, false, nil
+	}
+	replies, ok := counts.replies[thread]
+	return replies, ok, nil
+}
+
+func (m *Memory) Reset(ctx context.Context, categoryTag string, replies map[int]int) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	counts := &memoryCounts{threads: len(replies), replies: make(map[int]int, len(replies))}
+	for thread, count := range replies {
+		counts.replies[thread] = count
+	}
+	m.counts[categoryTag] = counts
+	return nil
+}
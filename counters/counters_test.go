@@ -0,0 +1,87 @@
+package counters
+
+import (
+	"context"
+	"spiritchat/data"
+	"spiritchat/events"
+	"testing"
+)
+
+type mockSource struct {
+	replies map[string]map[int]int
+}
+
+func (ms *mockSource) GetCategories(ctx context.Context) ([]*data.Category, error) {
+	categories := make([]*data.Category, 0, len(ms.replies))
+	for tag := range ms.replies {
+		categories = append(categories, &data.Category{Tag: tag})
+	}
+	return categories, nil
+}
+
+func (ms *mockSource) GetReplyCounts(ctx context.Context, categoryTag string) (map[int]int, error) {
+	return ms.replies[categoryTag], nil
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	counter := NewMemory()
+
+	// Nothing's counted until it's reset.
+	counter.Posted(ctx, "tech", 1, 0)
+	if threads, _ := counter.Threads(ctx, []string{"tech"}); len(threads) != 0 {
+		t.Errorf("expected an uncounted category to be left out, got: %v", threads)
+	}
+
+	counted, err := Reconcile(ctx, &mockSource{replies: map[string]map[int]int{"tech": {1: 2}}}, counter)
+	if err != nil || counted != 1 {
+		t.Fatalf("expected one category reconciled, got %d: %v", counted, err)
+	}
+	counter.Posted(ctx, "tech", 4, 0)
+	counter.Posted(ctx, "tech", 5, 4)
+	counter.Posted(ctx, "tech", 6, 1)
+	if threads, _ := counter.Threads(ctx, []string{"tech", "art"}); len(threads) != 1 || threads["tech"] != 2 {
+		t.Errorf("expected 2 threads on tech only, got: %v", threads)
+	}
+	if replies, ok, _ := counter.Replies(ctx, "tech", 1); !ok || replies != 3 {
+		t.Errorf("expected thread 1 to have 3 replies, got %d (counted %v)", replies, ok)
+	}
+
+	counter.Removed(ctx, "tech", 5, 4)
+	if replies, _, _ := counter.Replies(ctx, "tech", 4); replies != 0 {
+		t.Errorf("expected removed reply to be uncounted, got: %d", replies)
+	}
+	counter.Removed(ctx, "tech", 4, 0)
+	if _, ok, _ := counter.Replies(ctx, "tech", 4); ok {
+		t.Error("expected removed thread to be uncounted")
+	}
+	if threads, _ := counter.Threads(ctx, []string{"tech"}); threads["tech"] != 1 {
+		t.Errorf("expected 1 thread left, got: %d", threads["tech"])
+	}
+
+	// A reply removed without saying which thread it was on can't be uncounted.
+	counter.Removed(ctx, "tech", 6, 0)
+	if threads, _ := counter.Threads(ctx, []string{"tech"}); len(threads) != 0 {
+		t.Errorf("expected the category to be left for reconciling, got: %v", threads)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	counter := NewMemory()
+	counter.Reset(ctx, "tech", map[int]int{})
+
+	bus := events.NewBus()
+	Subscribe(bus, counter)
+	bus.Publish(events.Event{Kind: events.PostCreated, Category: "tech", Num: 1})
+	bus.Wait()
+	bus.Publish(events.Event{Kind: events.PostCreated, Category: "tech", Num: 2, Parent: 1})
+	bus.Publish(events.Event{Kind: events.PostCreated, Category: "tech", Num: 3, Parent: 1})
+	bus.Wait()
+	bus.Publish(events.Event{Kind: events.PostDeleted, Category: "tech", Num: 3, Parent: 1})
+	bus.Wait()
+
+	if replies, ok, _ := counter.Replies(ctx, "tech", 1); !ok || replies != 1 {
+		t.Errorf("expected thread 1 to have 1 reply, got %d (counted %v)", replies, ok)
+	}
+}
@@ -0,0 +1,23 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func (store *DataStore) AccountFirstSeen(ctx context.Context, accountID string) (time.Time, error) {
+	var firstSeen time.Time
+	// The no-op update makes RETURNING give back the existing row too.
+	err := store.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO accounts_seen (id) VALUES ($1)
+		ON CONFLICT (id) DO UPDATE SET id = EXCLUDED.id
+		RETURNING first_seen`,
+		accountID,
+	).Scan(&firstSeen)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to record account: %w", err)
+	}
+	return firstSeen, nil
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// JobStatus is how far a queued job has got.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+)
+
+/*
+AnonymizeJob strips the usernames, emails and accounts from a category's posts made before a time, archived ones
+included, for categories going from registered to anonymous posting. Posts are shown as Anonymous, and their IPs
+are kept for moderation. Their accounts are cleared too, or they'd still be listed as their authors' posts.
+*/
+type AnonymizeJob struct {
+	ID     int       `json:"id"`
+	Cat    string    `json:"cat"`
+	Before time.Time `json:"before"`
+	// Email of the admin who queued it.
+	QueuedBy string    `json:"queuedBy"`
+	Status   JobStatus `json:"status"`
+	// Posts, live and archived, there were to anonymize when it was queued, and how many it's anonymized.
+	Total      int        `json:"total"`
+	Done       int        `json:"done"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Posts on the job's category from before its time that still have a username, email or account.
+const anonymizablePosts = `cat = $1 AND created_at < $2
+	AND (username != $3 OR email != '' OR email_hash != '' OR COALESCE(author_id, '') != '')`
+
+// Archived posts, kept as JSON of their rows, that anonymizablePosts would match.
+const anonymizableArchivedPosts = `cat = $1 AND (post->>'created_at')::timestamp < $2
+	AND (post->>'username' != $3 OR COALESCE(post->>'email', '') != '' OR COALESCE(post->>'email_hash', '') != ''
+	OR COALESCE(post->>'author_id', '') != '')`
+
+const anonymizeJobColumns = "id, cat, before, queued_by, status, total, done, created_at, finished_at"
+
+func scanAnonymizeJob(row pgx.Row) (*AnonymizeJob, error) {
+	job := &AnonymizeJob{}
+	err := row.Scan(
+		&job.ID, &job.Cat, &job.Before, &job.QueuedBy, &job.Status, &job.Total, &job.Done, &job.CreatedAt, &job.FinishedAt,
+	)
+	return job, err
+}
+
+func (store *DataStore) QueueAnonymization(ctx context.Context, categoryTag string, before time.Time, queuedBy string) (*AnonymizeJob, error) {
+	var total int
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT (SELECT count(*) FROM posts WHERE `+anonymizablePosts+`)
+		+ (SELECT count(*) FROM post_archive WHERE `+anonymizableArchivedPosts+`)`,
+		categoryTag,
+		before,
+		AnonymousName,
+	).Scan(&total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count posts to anonymize: %w", err)
+	}
+	job, err := scanAnonymizeJob(store.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO anonymize_jobs (cat, before, queued_by, status, total) SELECT tag, $2, $3, $4, $5 FROM cats WHERE tag = $1
+		RETURNING `+anonymizeJobColumns,
+		categoryTag,
+		before,
+		queuedBy,
+		JobQueued,
+		total,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to queue anonymization: %w", err)
+	}
+	return job, nil
+}
+
+func (store *DataStore) GetAnonymizeJobs(ctx context.Context, limit int) ([]*AnonymizeJob, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT "+anonymizeJobColumns+" FROM anonymize_jobs ORDER BY id DESC LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query anonymization jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]*AnonymizeJob, 0)
+	for rows.Next() {
+		job, err := scanAnonymizeJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse an anonymization job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (store *DataStore) GetAnonymizeJob(ctx context.Context, id int) (*AnonymizeJob, error) {
+	job, err := scanAnonymizeJob(store.pgPool.QueryRow(
+		ctx,
+		"SELECT "+anonymizeJobColumns+" FROM anonymize_jobs WHERE id = $1",
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get anonymization job: %w", err)
+	}
+	return job, nil
+}
+
+func (store *DataStore) AnonymizeBatch(ctx context.Context, size int) (*AnonymizeJob, []*Post, error) {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin anonymization batch: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Locked so instances that both find the job queued take turns at its batches.
+	job, err := scanAnonymizeJob(tx.QueryRow(
+		ctx,
+		"SELECT "+anonymizeJobColumns+" FROM anonymize_jobs WHERE status != $1 ORDER BY id ASC LIMIT 1 FOR UPDATE",
+		JobDone,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get queued anonymization job: %w", err)
+	}
+
+	// Returned so copies of the posts kept elsewhere, like search indexes, can be rewritten too.
+	rows, err := tx.Query(
+		ctx,
+		`UPDATE posts SET username = $3, email = '', email_hash = '', author_id = NULL WHERE cat = $1 AND num IN (
+			SELECT num FROM posts WHERE `+anonymizablePosts+` ORDER BY num LIMIT $4
+		) RETURNING num, cat, parent, subject, content, username, created_at`,
+		job.Cat,
+		job.Before,
+		AnonymousName,
+		size,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to anonymize posts: %w", err)
+	}
+	defer rows.Close()
+
+	posts := make([]*Post, 0)
+	for rows.Next() {
+		post := &Post{}
+		err := rows.Scan(&post.Num, &post.Cat, &post.Parent, &post.Subject, &post.Content, &post.Username, &post.CreatedAt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse an anonymized post: %w", err)
+		}
+		post.derive()
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to anonymize posts: %w", err)
+	}
+
+	// Archived posts are served by the archive views, so they're rewritten in the same batches.
+	archived, err := tx.Exec(
+		ctx,
+		`UPDATE post_archive SET post = post || jsonb_build_object(
+			'username', $3::text, 'email', '', 'email_hash', '', 'author_id', NULL
+		) WHERE id IN (
+			SELECT id FROM post_archive WHERE `+anonymizableArchivedPosts+` ORDER BY id LIMIT $4
+		)`,
+		job.Cat,
+		job.Before,
+		AnonymousName,
+		size,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to anonymize archived posts: %w", err)
+	}
+
+	job.Done += len(posts) + int(archived.RowsAffected())
+	job.Status = JobRunning
+	if len(posts) < size && archived.RowsAffected() < int64(size) {
+		job.Status = JobDone
+		now := time.Now()
+		job.FinishedAt = &now
+	}
+	_, err = tx.Exec(
+		ctx,
+		"UPDATE anonymize_jobs SET status = $2, done = $3, finished_at = $4 WHERE id = $1",
+		job.ID,
+		job.Status,
+		job.Done,
+		job.FinishedAt,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to record anonymization progress: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit anonymization batch: %w", err)
+	}
+	return job, posts, nil
+}
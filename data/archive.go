@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+/*
+Archived posts are kept as JSON of the row they were, so columns added since they were archived are defaulted.
+Quarantined posts are left out, as they are from live views, and emails and IPs are never read.
+*/
+const archivedPostColumns = `a.num, a.cat, a.post->>'subject', a.post->>'content', (a.post->>'parent')::integer,
+	a.post->>'username', (a.post->>'created_at')::timestamp, COALESCE((a.post->>'locked')::boolean, false),
+	COALESCE(a.post->>'locked_reason', ''), COALESCE(a.post->>'capcode', ''),
+	COALESCE((a.post->>'best_answer')::integer, 0), COALESCE(a.post->>'thread_type', ''),
+	COALESCE(a.post->>'nonce', '')`
+
+const archivedPostVisible = `NOT COALESCE((a.post->>'quarantined')::boolean, false)`
+
+// scanArchivedPost reads a post selected with archivedPostColumns, followed by any of extra.
+func scanArchivedPost(rows pgx.Rows, extra ...interface{}) (*Post, error) {
+	post := &Post{}
+	err := rows.Scan(append([]interface{}{
+		&post.Num, &post.Cat, &post.Subject, &post.Content, &post.Parent, &post.Username, &post.CreatedAt,
+		&post.Locked, &post.LockedReason, &post.Capcode, &post.BestAnswer, &post.Type, &post.Nonce,
+	}, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if post.IsReply() {
+		post.Type = ""
+	}
+	post.Solved = post.Type == ThreadQuestion && post.BestAnswer != 0
+	post.derive()
+	post.Hash = post.ContentHash()
+	return post, nil
+}
+
+// archivedCategory returns the category, or what it was when it was removed if it's been removed since.
+func (store *DataStore) archivedCategory(ctx context.Context, categoryTag string) (*Category, error) {
+	cat, err := store.GetCategory(ctx, categoryTag)
+	if !errors.Is(err, ErrNotFound) {
+		return cat, err
+	}
+	// Removed categories don't take posts, so they're shown as archived.
+	cat = &Category{Tag: categoryTag, Archived: true}
+	err = store.pgPool.QueryRow(
+		ctx,
+		`SELECT COALESCE(category->>'slug', tag), category->>'name', COALESCE(category->>'description', ''),
+		(category->>'post_count')::integer, COALESCE(category->>'username_policy', $2), archived_at
+		FROM category_archive WHERE tag = $1 ORDER BY id DESC LIMIT 1`,
+		categoryTag,
+		UsernamesChoice,
+	).Scan(&cat.Slug, &cat.Name, &cat.Description, &cat.PostCount, &cat.Usernames, &cat.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query archived category: %w", err)
+	}
+	return cat, nil
+}
+
+func (store *DataStore) GetArchivedCategoryView(ctx context.Context, categoryTag string, offset int, limit int) (*CatView, error) {
+	cat, err := store.archivedCategory(ctx, categoryTag)
+	if err != nil {
+		return nil, err
+	}
+
+	// A thread archived more than once, by removing a category that was made again with the same tag, is its latest.
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT `+archivedPostColumns+`, r.replies, GREATEST((a.post->>'created_at')::timestamp, r.bumped_at)
+		FROM (
+			SELECT DISTINCT ON (num) * FROM post_archive WHERE cat = $1 AND (post->>'parent')::integer = 0
+			ORDER BY num, id DESC
+		) a
+		CROSS JOIN LATERAL (
+			SELECT count(*) AS replies, max((post->>'created_at')::timestamp) AS bumped_at FROM post_archive
+			WHERE cat = a.cat AND archived_at = a.archived_at AND (post->>'parent')::integer = a.num
+			AND NOT COALESCE((post->>'quarantined')::boolean, false)
+		) r
+		WHERE `+archivedPostVisible+` ORDER BY a.num DESC LIMIT $2 OFFSET $3`,
+		categoryTag,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archived threads: %w", err)
+	}
+	defer rows.Close()
+
+	threads := make([]*Post, 0)
+	for rows.Next() {
+		var replies int
+		var bumpedAt time.Time
+		thread, err := scanArchivedPost(rows, &replies, &bumpedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse an archived thread: %w", err)
+		}
+		thread.Replies = replies
+		thread.BumpedAt = &bumpedAt
+		threads = append(threads, thread)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query archived threads: %w", err)
+	}
+	return &CatView{Category: cat, Rules: make([]string, 0), Threads: threads}, nil
+}
+
+func (store *DataStore) GetArchivedThreadView(ctx context.Context, categoryTag string, threadNum int) (*ThreadView, error) {
+	cat, err := store.archivedCategory(ctx, categoryTag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replies are archived in the same transaction as their thread, so they share its archived_at.
+	rows, err := store.pgPool.Query(
+		ctx,
+		`WITH thread AS (
+			SELECT archived_at, CASE WHEN post->>'thread_type' = 'question'
+			THEN COALESCE((post->>'best_answer')::integer, 0) ELSE 0 END AS best_answer
+			FROM post_archive WHERE cat = $1 AND num = $2 AND (post->>'parent')::integer = 0
+			ORDER BY id DESC LIMIT 1
+		)
+		SELECT `+archivedPostColumns+` FROM post_archive a JOIN thread t ON a.archived_at = t.archived_at
+		WHERE a.cat = $1 AND (a.num = $2 OR (a.post->>'parent')::integer = $2) AND `+archivedPostVisible+`
+		ORDER BY a.num = $2 DESC, a.num = t.best_answer DESC, a.num ASC`,
+		categoryTag,
+		threadNum,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archived thread: %w", err)
+	}
+	defer rows.Close()
+
+	posts := make([]*Post, 0)
+	for rows.Next() {
+		post, err := scanArchivedPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse an archived post: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query archived thread: %w", err)
+	}
+	if len(posts) == 0 || posts[0].Num != threadNum {
+		return nil, ErrNotFound
+	}
+	return &ThreadView{Category: cat, Posts: posts}, nil
+}
@@ -0,0 +1,409 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var ErrAttachmentUnavailable = errors.New("attachment doesn't exist, isn't yours or is already on a post")
+
+// ActionSpoiler marks a post's attachment as a spoiler or NSFW.
+const ActionSpoiler ModerationAction = "spoiler"
+
+// Storage key of the placeholder shown in place of spoilered and NSFW attachments, served by the server itself.
+const SpoilerPreview = "spoiler.png"
+
+// AttachmentKind is the kind of media an attachment holds.
+type AttachmentKind string
+
+const (
+	AttachmentImage AttachmentKind = "image"
+	AttachmentAudio AttachmentKind = "audio"
+	AttachmentVideo AttachmentKind = "video"
+)
+
+// Attachment is an uploaded file, which belongs to a post once it's been posted with one.
+type Attachment struct {
+	ID   int            `json:"id"`
+	Kind AttachmentKind `json:"kind"`
+	MIME string         `json:"mime"`
+	Size int64          `json:"size"`
+	// Storage key of the file, served at /v1/media/:file.
+	File string `json:"file"`
+	// Storage key of a still from a video, if one could be taken.
+	Poster string `json:"poster,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	// Length of audio and video.
+	DurationMS int `json:"durationMs,omitempty"`
+	// Storage key and type of audio and video transcoded to a normalized format, once it's done.
+	Transcoded     string `json:"transcoded,omitempty"`
+	TranscodedMIME string `json:"transcodedMime,omitempty"`
+	// Hidden behind a placeholder until they're opened.
+	Spoiler bool `json:"spoiler,omitempty"`
+	NSFW    bool `json:"nsfw,omitempty"`
+	// Storage key of what's shown before the attachment's opened, empty if there's nothing to show.
+	Preview string `json:"preview,omitempty"`
+	// Scaled down images and video posters, each size in every format that could be encoded.
+	Thumbnails []Thumbnail `json:"thumbnails,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	// Account that uploaded the file, only they can post it.
+	UploaderID string `json:"-"`
+	// Post the attachment's on, empty and zero until it's posted.
+	Cat string `json:"-"`
+	Num int    `json:"-"`
+}
+
+// Thumbnail is a scaled down variant of an image or video poster.
+type Thumbnail struct {
+	// Which of the sizes it is, small or medium.
+	Size string `json:"size"`
+	MIME string `json:"mime"`
+	// Storage key of the file, served at /v1/media/:file.
+	File   string `json:"file"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// AttachmentRef is an upload being posted, with how the poster wants it shown.
+type AttachmentRef struct {
+	ID      int
+	Spoiler bool
+	NSFW    bool
+}
+
+// AttachmentFlags holds a moderator's changes to an attachment, nil fields are left as they are.
+type AttachmentFlags struct {
+	Spoiler *bool `json:"spoiler"`
+	NSFW    *bool `json:"nsfw"`
+}
+
+// setPreview picks what to show before the attachment's opened.
+func (a *Attachment) setPreview() {
+	switch {
+	case a.Spoiler || a.NSFW:
+		a.Preview = SpoilerPreview
+	case a.Kind == AttachmentImage:
+		a.Preview = a.File
+	default:
+		a.Preview = a.Poster
+	}
+}
+
+// Files returns the storage keys of every file belonging to the attachment.
+func (a *Attachment) Files() []string {
+	keys := make([]string, 0, 3+len(a.Thumbnails))
+	for _, key := range []string{a.File, a.Poster, a.Transcoded} {
+		if len(key) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	for _, thumbnail := range a.Thumbnails {
+		keys = append(keys, thumbnail.File)
+	}
+	return keys
+}
+
+func (store *DataStore) WriteAttachment(ctx context.Context, attachment *Attachment) (int, error) {
+	thumbnails := attachment.Thumbnails
+	if thumbnails == nil {
+		thumbnails = []Thumbnail{}
+	}
+	var id int
+	err := store.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO attachments (kind, mime, size, file, poster, width, height, duration_ms, uploader_id, thumbnails)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		string(attachment.Kind),
+		attachment.MIME,
+		attachment.Size,
+		attachment.File,
+		attachment.Poster,
+		attachment.Width,
+		attachment.Height,
+		attachment.DurationMS,
+		attachment.UploaderID,
+		thumbnails,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write attachment: %w", err)
+	}
+	return id, nil
+}
+
+func (store *DataStore) GetAttachment(ctx context.Context, id int) (*Attachment, error) {
+	a := &Attachment{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT id, kind, mime, size, file, poster, width, height, duration_ms, transcoded, transcoded_mime,
+		spoiler, nsfw, thumbnails, created_at, uploader_id, COALESCE(cat, ''), COALESCE(num, 0)
+		FROM attachments WHERE id = $1`,
+		id,
+	).Scan(
+		&a.ID, &a.Kind, &a.MIME, &a.Size, &a.File, &a.Poster, &a.Width, &a.Height, &a.DurationMS, &a.Transcoded,
+		&a.TranscodedMIME, &a.Spoiler, &a.NSFW, &a.Thumbnails, &a.CreatedAt, &a.UploaderID, &a.Cat, &a.Num,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query attachment: %w", err)
+	}
+	a.setPreview()
+	return a, nil
+}
+
+func (store *DataStore) SetAttachmentFlags(ctx context.Context, id int, flags *AttachmentFlags) (*Attachment, error) {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin attachment flags transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var categoryTag string
+	var num int
+	err = tx.QueryRow(
+		ctx,
+		`UPDATE attachments SET spoiler = COALESCE($2, spoiler), nsfw = COALESCE($3, nsfw)
+		WHERE id = $1 RETURNING COALESCE(cat, ''), COALESCE(num, 0)`,
+		id,
+		flags.Spoiler,
+		flags.NSFW,
+	).Scan(&categoryTag, &num)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to set attachment flags: %w", err)
+	}
+	// Only posted attachments have a post to log against.
+	if len(categoryTag) > 0 {
+		err = logAction(ctx, tx, categoryTag, num, ActionSpoiler, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit attachment flags: %w", err)
+	}
+	return store.GetAttachment(ctx, id)
+}
+
+func (store *DataStore) SetAttachmentTranscode(ctx context.Context, id int, file string, mime string) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE attachments SET transcoded = $2, transcoded_mime = $3 WHERE id = $1",
+		id,
+		file,
+		mime,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set attachment transcode: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// claimAttachments puts the uploader's unposted attachments on a post, failing if any of them aren't available.
+func claimAttachments(ctx context.Context, tx pgx.Tx, categoryTag string, num int, uploaderID string, refs []AttachmentRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	claim, spoiler, nsfw := []int32{}, []int32{}, []int32{}
+	for _, ref := range refs {
+		claim = append(claim, int32(ref.ID))
+		if ref.Spoiler {
+			spoiler = append(spoiler, int32(ref.ID))
+		}
+		if ref.NSFW {
+			nsfw = append(nsfw, int32(ref.ID))
+		}
+	}
+	res, err := tx.Exec(
+		ctx,
+		`UPDATE attachments SET cat = $1, num = $2, spoiler = (id = ANY($5)), nsfw = (id = ANY($6))
+		WHERE id = ANY($3) AND cat IS NULL AND uploader_id = $4`,
+		categoryTag,
+		num,
+		claim,
+		uploaderID,
+		spoiler,
+		nsfw,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to claim attachments: %w", err)
+	}
+	if res.RowsAffected() != int64(len(refs)) {
+		return ErrAttachmentUnavailable
+	}
+	return nil
+}
+
+// attachAttachments loads the attachments on each of the posts, which must be on the same category.
+func (store *DataStore) attachAttachments(ctx context.Context, categoryTag string, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byNum := make(map[int]*Post, len(posts))
+	nums := make([]int32, len(posts))
+	for i, post := range posts {
+		byNum[post.Num] = post
+		nums[i] = int32(post.Num)
+	}
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT num, id, kind, mime, size, file, poster, width, height, duration_ms, transcoded, transcoded_mime,
+		spoiler, nsfw, thumbnails, created_at
+		FROM attachments WHERE cat = $1 AND num = ANY($2)
+		ORDER BY num, id`,
+		categoryTag,
+		nums,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var num int
+		a := &Attachment{}
+		err := rows.Scan(
+			&num, &a.ID, &a.Kind, &a.MIME, &a.Size, &a.File, &a.Poster, &a.Width, &a.Height, &a.DurationMS,
+			&a.Transcoded, &a.TranscodedMIME, &a.Spoiler, &a.NSFW, &a.Thumbnails, &a.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to parse an attachment: %w", err)
+		}
+		a.setPreview()
+		if post, ok := byNum[num]; ok {
+			a.Cat, a.Num = categoryTag, num
+			post.Attachments = append(post.Attachments, a)
+		}
+	}
+	return nil
+}
+
+// StorageUsage is how much storage attachments take up, counting originals but not posters or transcodes.
+type StorageUsage struct {
+	TotalBytes int64 `json:"totalBytes"`
+	TotalFiles int   `json:"totalFiles"`
+	// Uploads that haven't been posted yet.
+	UnpostedBytes int64            `json:"unpostedBytes"`
+	UnpostedFiles int              `json:"unpostedFiles"`
+	Categories    []*CategoryUsage `json:"categories"`
+}
+
+// CategoryUsage is how much storage a category's attachments take up.
+type CategoryUsage struct {
+	Cat   string `json:"cat"`
+	Bytes int64  `json:"bytes"`
+	Files int    `json:"files"`
+	// Zero if the category has no quota.
+	QuotaBytes int64 `json:"quotaBytes"`
+}
+
+func (store *DataStore) GetStorageUsage(ctx context.Context) (*StorageUsage, error) {
+	usage := &StorageUsage{Categories: make([]*CategoryUsage, 0)}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT COALESCE(SUM(size), 0), COUNT(*),
+		COALESCE(SUM(size) FILTER (WHERE cat IS NULL), 0), COUNT(*) FILTER (WHERE cat IS NULL)
+		FROM attachments`,
+	).Scan(&usage.TotalBytes, &usage.TotalFiles, &usage.UnpostedBytes, &usage.UnpostedFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query storage usage: %w", err)
+	}
+
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT cats.tag, COALESCE(SUM(attachments.size), 0), COUNT(attachments.id), cats.storage_quota_bytes
+		FROM cats LEFT JOIN attachments ON attachments.cat = cats.tag
+		GROUP BY cats.tag ORDER BY cats.tag`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category storage usage: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := &CategoryUsage{}
+		if err := rows.Scan(&c.Cat, &c.Bytes, &c.Files, &c.QuotaBytes); err != nil {
+			return nil, fmt.Errorf("failed to parse category storage usage: %w", err)
+		}
+		usage.Categories = append(usage.Categories, c)
+	}
+	return usage, nil
+}
+
+func (store *DataStore) GetCategoryStorageUsage(ctx context.Context, categoryTag string) (int64, error) {
+	var used int64
+	err := store.pgPool.QueryRow(
+		ctx,
+		"SELECT COALESCE(SUM(size), 0) FROM attachments WHERE cat = $1",
+		categoryTag,
+	).Scan(&used)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query category storage usage: %w", err)
+	}
+	return used, nil
+}
+
+func (store *DataStore) PruneAttachments(ctx context.Context, categoryTag string, budget int64) ([]*Attachment, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`DELETE FROM attachments WHERE id IN (
+			SELECT id FROM (
+				SELECT id, SUM(size) OVER (ORDER BY created_at DESC, id DESC) AS kept
+				FROM attachments WHERE cat = $1
+			) newest WHERE kept > $2
+		) RETURNING id, kind, mime, size, file, poster, transcoded, thumbnails, COALESCE(num, 0)`,
+		categoryTag,
+		budget,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune attachments: %w", err)
+	}
+	defer rows.Close()
+
+	pruned := make([]*Attachment, 0)
+	for rows.Next() {
+		a := &Attachment{Cat: categoryTag}
+		err := rows.Scan(&a.ID, &a.Kind, &a.MIME, &a.Size, &a.File, &a.Poster, &a.Transcoded, &a.Thumbnails, &a.Num)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a pruned attachment: %w", err)
+		}
+		pruned = append(pruned, a)
+	}
+	return pruned, rows.Err()
+}
+
+func (store *DataStore) CollectOrphanedAttachments(ctx context.Context, grace time.Duration) ([]*Attachment, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`DELETE FROM attachments
+		WHERE orphaned_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+		OR (cat IS NULL AND created_at < CURRENT_TIMESTAMP - make_interval(secs => $1))
+		RETURNING id, kind, mime, size, file, poster, transcoded, thumbnails, COALESCE(cat, ''), COALESCE(num, 0)`,
+		grace.Seconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect orphaned attachments: %w", err)
+	}
+	defer rows.Close()
+
+	collected := make([]*Attachment, 0)
+	for rows.Next() {
+		a := &Attachment{}
+		err := rows.Scan(&a.ID, &a.Kind, &a.MIME, &a.Size, &a.File, &a.Poster, &a.Transcoded, &a.Thumbnails, &a.Cat, &a.Num)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse an orphaned attachment: %w", err)
+		}
+		collected = append(collected, a)
+	}
+	return collected, rows.Err()
+}
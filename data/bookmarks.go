@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// Bookmark is a post a user saved, with the thread it's on.
+type Bookmark struct {
+	ID   int   `json:"id"`
+	Post *Post `json:"post"`
+	// Thread the post is on, the post itself if it's a thread.
+	Thread        int       `json:"thread"`
+	ThreadSubject string    `json:"threadSubject"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func (store *DataStore) SetBookmark(ctx context.Context, accountID string, categoryTag string, num int, bookmarked bool) error {
+	if !bookmarked {
+		_, err := store.pgPool.Exec(
+			ctx,
+			"DELETE FROM bookmarks WHERE account_id = $1 AND cat = $2 AND num = $3",
+			accountID,
+			categoryTag,
+			num,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to remove bookmark: %w", err)
+		}
+		return nil
+	}
+
+	_, err := store.pgPool.Exec(
+		ctx,
+		"INSERT INTO bookmarks (account_id, cat, num) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		accountID,
+		categoryTag,
+		num,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to write bookmark: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetBookmarks(ctx context.Context, accountID string, before int, limit int) ([]*Bookmark, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT b.id, b.created_at, p.num, p.cat, p.subject, p.content, p.username, p.created_at, p.capcode,
+		COALESCE(t.num, p.num), COALESCE(t.subject, p.subject)
+		FROM bookmarks b
+		JOIN posts p ON p.cat = b.cat AND p.num = b.num
+		LEFT JOIN posts t ON t.cat = p.cat AND t.num = p.parent AND p.parent <> 0
+		WHERE b.account_id = $1 AND ($2 = 0 OR b.id < $2)
+		ORDER BY b.id DESC LIMIT $3`,
+		accountID,
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	bookmarks := make([]*Bookmark, 0)
+	for rows.Next() {
+		bookmark := &Bookmark{Post: &Post{}}
+		post := bookmark.Post
+		err := rows.Scan(
+			&bookmark.ID, &bookmark.CreatedAt,
+			&post.Num, &post.Cat, &post.Subject, &post.Content, &post.Username, &post.CreatedAt, &post.Capcode,
+			&bookmark.Thread, &bookmark.ThreadSubject,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a bookmark: %w", err)
+		}
+		post.derive()
+		post.Parent = bookmark.Thread
+		if post.Parent == post.Num {
+			post.Parent = 0
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return bookmarks, nil
+}
@@ -0,0 +1,260 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Rows imported or threads pruned per transaction, unless BulkOptions says otherwise.
+const defaultBulkBatch = 1000
+
+// BulkOptions control bulk imports and prunes, which run a batch per transaction.
+type BulkOptions struct {
+	// Posts imported or threads pruned per batch, defaulting to 1000.
+	BatchSize int
+	// Called after each batch with how much of the total has been done.
+	Progress func(done int, total int)
+}
+
+func (opts BulkOptions) batchSize() int {
+	if opts.BatchSize > 0 {
+		return opts.BatchSize
+	}
+	return defaultBulkBatch
+}
+
+func (opts BulkOptions) progress(done int, total int) {
+	if opts.Progress != nil {
+		opts.Progress(done, total)
+	}
+}
+
+// ImportedPost is a post from another board, numbered as it was there.
+type ImportedPost struct {
+	Num int `json:"num"`
+	// Number of the thread it's a reply to, zero for threads.
+	Parent    int       `json:"parent"`
+	Subject   string    `json:"subject"`
+	Content   string    `json:"content"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+var importColumns = []string{"cat", "num", "parent", "subject", "content", "username", "email", "ip", "created_at", "ip_hash", "email_hash"}
+
+/*
+ImportPosts copies posts into a category, numbering them after its existing posts. Threads must come before
+their replies. Returns how many posts were imported, which are kept if a later batch fails.
+Returns ErrNotFound if there's no such category, or ErrCategoryArchived if it's archived.
+*/
+func (store *DataStore) ImportPosts(ctx context.Context, categoryTag string, posts []*ImportedPost, opts BulkOptions) (int, error) {
+	threads := make(map[int]bool)
+	for _, post := range posts {
+		if post.Parent == 0 {
+			threads[post.Num] = true
+		} else if !threads[post.Parent] {
+			return 0, fmt.Errorf("post %d replies to %d, which isn't a thread imported before it", post.Num, post.Parent)
+		}
+	}
+
+	// Numbers the posts were given here, by their number on the old board.
+	numbered := make(map[int]int, len(posts))
+	size := opts.batchSize()
+	for done := 0; done < len(posts); {
+		end := done + size
+		if end > len(posts) {
+			end = len(posts)
+		}
+		err := store.importBatch(ctx, categoryTag, posts[done:end], numbered)
+		if err != nil {
+			return done, err
+		}
+		done = end
+		opts.progress(done, len(posts))
+	}
+	return len(posts), nil
+}
+
+func (store *DataStore) importBatch(ctx context.Context, categoryTag string, posts []*ImportedPost, numbered map[int]int) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin import: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var next int
+	var archived bool
+	err = tx.QueryRow(ctx, "SELECT post_count, archived FROM cats WHERE tag = $1 FOR UPDATE", categoryTag).Scan(&next, &archived)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to lock category for import: %w", err)
+	}
+	if archived {
+		return ErrCategoryArchived
+	}
+
+	// Threads are copied first, so the replies copied after can see them.
+	var threads, replies [][]interface{}
+	for _, post := range posts {
+		numbered[post.Num] = next
+		parent := 0
+		if post.Parent != 0 {
+			parent = numbered[post.Parent]
+		}
+		row := []interface{}{categoryTag, next, parent, post.Subject, post.Content, post.Username, "", "", post.CreatedAt, PosterHash(""), PosterHash("")}
+		if parent == 0 {
+			threads = append(threads, row)
+		} else {
+			replies = append(replies, row)
+		}
+		next++
+	}
+	for _, rows := range [][][]interface{}{threads, replies} {
+		if len(rows) == 0 {
+			continue
+		}
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"posts"}, importColumns, pgx.CopyFromRows(rows))
+		if err != nil {
+			return fmt.Errorf("failed to copy imported posts: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE cats SET post_count = $2 WHERE tag = $1", categoryTag, next)
+	if err != nil {
+		return fmt.Errorf("failed to update post count after import: %w", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit import: %w", err)
+	}
+	return nil
+}
+
+/*
+ExportPosts passes every visible post on a category to write, in the shape ImportPosts takes, threads before
+their replies. Nothing identifying posters is exported. Returns how many posts were written.
+*/
+func (store *DataStore) ExportPosts(ctx context.Context, categoryTag string, write func(*ImportedPost) error) (int, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT num, parent, subject, content, username, created_at FROM posts WHERE cat = $1 AND NOT quarantined ORDER BY num",
+		categoryTag,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query posts to export: %w", err)
+	}
+	defer rows.Close()
+
+	var exported int
+	for rows.Next() {
+		post := &ImportedPost{}
+		err := rows.Scan(&post.Num, &post.Parent, &post.Subject, &post.Content, &post.Username, &post.CreatedAt)
+		if err != nil {
+			return exported, fmt.Errorf("failed to parse a post to export: %w", err)
+		}
+		if err := write(post); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+	return exported, rows.Err()
+}
+
+// ThreadPrune counts what pruning a category removed.
+type ThreadPrune struct {
+	Threads int64 `json:"threads"`
+	Posts   int64 `json:"posts"`
+}
+
+/*
+PruneThreads removes the threads on a category that haven't had a post since before, and their replies,
+copying them to the archive tables first if archive is set. Returns what was pruned, which is kept
+if a later batch fails.
+*/
+func (store *DataStore) PruneThreads(ctx context.Context, categoryTag string, before time.Time, archive bool, opts BulkOptions) (*ThreadPrune, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT num FROM posts thread WHERE cat = $1 AND parent = 0 AND created_at < $2
+		AND NOT EXISTS (SELECT 1 FROM posts reply WHERE reply.cat = $1 AND reply.parent = thread.num AND reply.created_at >= $2)
+		ORDER BY num`,
+		categoryTag,
+		before,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stale threads: %w", err)
+	}
+	var nums []int32
+	for rows.Next() {
+		var num int32
+		err := rows.Scan(&num)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to parse a stale thread: %w", err)
+		}
+		nums = append(nums, num)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query stale threads: %w", err)
+	}
+
+	pruned := &ThreadPrune{}
+	size := opts.batchSize()
+	for done := 0; done < len(nums); {
+		end := done + size
+		if end > len(nums) {
+			end = len(nums)
+		}
+		posts, err := store.pruneBatch(ctx, categoryTag, nums[done:end], archive)
+		if err != nil {
+			return pruned, err
+		}
+		pruned.Threads += int64(end - done)
+		pruned.Posts += posts
+		done = end
+		opts.progress(done, len(nums))
+	}
+	return pruned, nil
+}
+
+// pruneBatch removes the threads and their replies in one transaction, returning how many posts went.
+func (store *DataStore) pruneBatch(ctx context.Context, categoryTag string, threads []int32, archive bool) (int64, error) {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin prune: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if archive {
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO post_archive (cat, num, post) SELECT cat, num, to_jsonb(posts) FROM posts
+			WHERE cat = $1 AND (num = ANY($2) OR parent = ANY($2))`,
+			categoryTag,
+			threads,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to archive pruned threads: %w", err)
+		}
+	}
+	// Replies go in one statement first, rather than one at a time as each thread's removal cascades.
+	replies, err := tx.Exec(ctx, "DELETE FROM posts WHERE cat = $1 AND parent = ANY($2)", categoryTag, threads)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune replies: %w", err)
+	}
+	removed, err := tx.Exec(ctx, "DELETE FROM posts WHERE cat = $1 AND num = ANY($2)", categoryTag, threads)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune threads: %w", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit prune: %w", err)
+	}
+	return replies.RowsAffected() + removed.RowsAffected(), nil
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+/*
+Canary is a thread that's never listed or linked to, bait for scrapers. Its number is taken from its category
+like any other thread, so no post ever gets it, and anything that asks for it found it somewhere it shouldn't have.
+*/
+type Canary struct {
+	ID  int    `json:"id"`
+	Cat string `json:"cat"`
+	Num int    `json:"num"`
+	// Where the operator planted it, like "sitemap" or "hidden footer link".
+	Label string `json:"label"`
+	// What it looks like to whoever requests it.
+	Subject   string    `json:"subject"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+	Hits      int       `json:"hits"`
+	// When it was last requested, nil if it never has been.
+	LastHitAt *time.Time `json:"lastHitAt,omitempty"`
+}
+
+// Post returns the canary as the thread it passes for.
+func (canary *Canary) Post() *Post {
+	post := &Post{
+		Num:       canary.Num,
+		Cat:       canary.Cat,
+		Subject:   canary.Subject,
+		Content:   canary.Content,
+		CreatedAt: canary.CreatedAt,
+	}
+	post.derive()
+	return post
+}
+
+// CanaryHit is a request for a canary thread.
+type CanaryHit struct {
+	CanaryID  int       `json:"canaryId"`
+	Cat       string    `json:"cat"`
+	Num       int       `json:"num"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	At        time.Time `json:"at"`
+}
+
+func (store *DataStore) WriteCanary(ctx context.Context, canary *Canary) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin canary transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Taken like write_post takes a number, so the category's next post gets the one after.
+	err = tx.QueryRow(
+		ctx,
+		"UPDATE cats SET post_count = post_count + 1 WHERE tag = $1 RETURNING post_count - 1",
+		canary.Cat,
+	).Scan(&canary.Num)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to take a canary's number: %w", err)
+	}
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO canaries (cat, num, label, subject, content) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		canary.Cat,
+		canary.Num,
+		canary.Label,
+		canary.Subject,
+		canary.Content,
+	).Scan(&canary.ID, &canary.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to write canary: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit canary: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetCanary(ctx context.Context, categoryTag string, num int) (*Canary, error) {
+	canary := &Canary{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT id, cat, num, label, subject, content, created_at, hits, last_hit_at FROM canaries
+		WHERE cat = $1 AND num = $2`,
+		categoryTag,
+		num,
+	).Scan(
+		&canary.ID, &canary.Cat, &canary.Num, &canary.Label, &canary.Subject, &canary.Content,
+		&canary.CreatedAt, &canary.Hits, &canary.LastHitAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query canary: %w", err)
+	}
+	return canary, nil
+}
+
+func (store *DataStore) GetCanaries(ctx context.Context) ([]*Canary, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT id, cat, num, label, subject, content, created_at, hits, last_hit_at FROM canaries
+		ORDER BY id DESC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query canaries: %w", err)
+	}
+	defer rows.Close()
+
+	canaries := make([]*Canary, 0)
+	for rows.Next() {
+		canary := &Canary{}
+		err := rows.Scan(
+			&canary.ID, &canary.Cat, &canary.Num, &canary.Label, &canary.Subject, &canary.Content,
+			&canary.CreatedAt, &canary.Hits, &canary.LastHitAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a canary: %w", err)
+		}
+		canaries = append(canaries, canary)
+	}
+	return canaries, rows.Err()
+}
+
+func (store *DataStore) RecordCanaryHit(ctx context.Context, hit *CanaryHit) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin canary hit transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(
+		ctx,
+		`UPDATE canaries SET hits = hits + 1, last_hit_at = CURRENT_TIMESTAMP WHERE id = $1
+		RETURNING cat, num, last_hit_at`,
+		hit.CanaryID,
+	).Scan(&hit.Cat, &hit.Num, &hit.At)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to count canary hit: %w", err)
+	}
+	ip, err := store.pii.seal(hit.IP)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt canary hit IP: %w", err)
+	}
+	_, err = tx.Exec(
+		ctx,
+		"INSERT INTO canary_hits (canary_id, ip, ip_hash, user_agent, at) VALUES ($1, $2, $3, $4, $5)",
+		hit.CanaryID,
+		ip,
+		PosterHash(hit.IP),
+		hit.UserAgent,
+		hit.At,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record canary hit: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit canary hit: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetCanaryHits(ctx context.Context, limit int) ([]*CanaryHit, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT h.canary_id, c.cat, c.num, h.ip, h.user_agent, h.at FROM canary_hits h
+		JOIN canaries c ON c.id = h.canary_id ORDER BY h.at DESC, h.id DESC LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query canary hits: %w", err)
+	}
+	defer rows.Close()
+
+	hits := make([]*CanaryHit, 0)
+	for rows.Next() {
+		hit := &CanaryHit{}
+		err := rows.Scan(&hit.CanaryID, &hit.Cat, &hit.Num, &hit.IP, &hit.UserAgent, &hit.At)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a canary hit: %w", err)
+		}
+		hit.IP, err = store.pii.open(hit.IP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt canary hit IP: %w", err)
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+RefreshCatalog recounts every thread's replies and last bump from its posts, correcting the catalog
+category views are served from. Returns how many threads had drifted.
+*/
+func (store *DataStore) RefreshCatalog(ctx context.Context) (int, error) {
+	var fixed int
+	err := store.pgPool.QueryRow(ctx, "SELECT refresh_thread_catalog()").Scan(&fixed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to refresh thread catalog: %w", err)
+	}
+	return fixed, nil
+}
+
+func (store *DataStore) GetReplyCounts(ctx context.Context, categoryTag string) (map[int]int, error) {
+	rows, err := store.pgPool.Query(ctx, "SELECT num, replies FROM thread_catalog WHERE cat = $1", categoryTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reply counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var num, replies int
+		err := rows.Scan(&num, &replies)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a reply count: %w", err)
+		}
+		counts[num] = replies
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query reply counts: %w", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// RulesAcceptance records a poster accepting a category's rules.
+type RulesAcceptance struct {
+	Cat        string    `json:"cat"`
+	AcceptedAt time.Time `json:"acceptedAt"`
+}
+
+func (store *DataStore) GetCategoryRules(ctx context.Context, categoryTag string) ([]string, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT content FROM category_rules WHERE cat = $1 ORDER BY position ASC",
+		categoryTag,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []string = make([]string, 0)
+	for rows.Next() {
+		var rule string
+		err := rows.Scan(&rule)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a category rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (store *DataStore) SetCategoryRules(ctx context.Context, categoryTag string, rules []string) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin setting category rules: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "DELETE FROM category_rules WHERE cat = $1", categoryTag)
+	if err != nil {
+		return fmt.Errorf("failed to clear category rules: %w", err)
+	}
+	for position, rule := range rules {
+		_, err = tx.Exec(
+			ctx,
+			"INSERT INTO category_rules (cat, position, content) VALUES ($1, $2, $3)",
+			categoryTag,
+			position,
+			rule,
+		)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+				return ErrNotFound
+			}
+			return fmt.Errorf("failed to write category rule: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit category rules: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) MustAcceptRules(ctx context.Context, categoryTag string, posterHashes ...string) (bool, error) {
+	var mustAccept bool
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT EXISTS (SELECT FROM category_rules WHERE cat = $1)
+		AND NOT EXISTS (SELECT FROM rules_acceptances WHERE cat = $1 AND poster_hash = ANY($2))`,
+		categoryTag,
+		posterHashes,
+	).Scan(&mustAccept)
+	if err != nil {
+		return false, fmt.Errorf("failed to check rules acceptance: %w", err)
+	}
+	return mustAccept, nil
+}
+
+func (store *DataStore) AcceptRules(ctx context.Context, categoryTag string, posterHashes ...string) error {
+	_, err := store.pgPool.Exec(
+		ctx,
+		`INSERT INTO rules_acceptances (cat, poster_hash) SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING`,
+		categoryTag,
+		posterHashes,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to record rules acceptance: %w", err)
+	}
+	return nil
+}
+
+// getRulesAcceptances returns the categories a poster has accepted the rules of.
+func (store *DataStore) getRulesAcceptances(ctx context.Context, posterHash string) ([]*RulesAcceptance, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT cat, accepted_at FROM rules_acceptances WHERE poster_hash = $1 ORDER BY accepted_at ASC",
+		posterHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rules acceptances: %w", err)
+	}
+	defer rows.Close()
+
+	var acceptances []*RulesAcceptance = make([]*RulesAcceptance, 0)
+	for rows.Next() {
+		acceptance := &RulesAcceptance{}
+		err := rows.Scan(&acceptance.Cat, &acceptance.AcceptedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a rules acceptance: %w", err)
+		}
+		acceptances = append(acceptances, acceptance)
+	}
+	return acceptances, nil
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ChangeKind is what happened to a post in the changes feed.
+type ChangeKind string
+
+const (
+	PostCreated ChangeKind = "created"
+	PostEdited  ChangeKind = "edited"
+	PostDeleted ChangeKind = "deleted"
+	// Quarantined, approved, locked or unlocked.
+	PostModerated ChangeKind = "moderated"
+)
+
+// PostChange is a post being created, edited, deleted or moderated, as recorded in the changes feed.
+type PostChange struct {
+	// Cursor for reading the feed from after this change.
+	Cursor int64      `json:"cursor"`
+	Kind   ChangeKind `json:"kind"`
+	Cat    string     `json:"cat"`
+	Num    int        `json:"num"`
+	// Thread the post's in, the post itself for OPs.
+	Thread    int       `json:"thread"`
+	ChangedAt time.Time `json:"changedAt"`
+	/*
+		The post as it is now, on creations and edits of posts that can still be seen.
+		Posts that have since been deleted or quarantined are left out, and their later change says why.
+	*/
+	Post *Post `json:"post,omitempty"`
+}
+
+func (store *DataStore) GetChanges(ctx context.Context, since int64, limit int) ([]*PostChange, error) {
+	// Changes are only read up to the oldest transaction still running, which may yet commit changes before them.
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT c.id, c.kind, c.cat, c.num, c.parent, c.changed_at,
+		p.num IS NOT NULL, COALESCE(p.subject, ''), COALESCE(p.content, ''), COALESCE(p.username, ''),
+		COALESCE(p.created_at, c.changed_at), COALESCE(p.capcode, ''), COALESCE(p.nonce, '')
+		FROM post_events c
+		LEFT JOIN posts p ON c.kind != 'deleted' AND p.cat = c.cat AND p.num = c.num AND NOT p.quarantined
+		WHERE c.id > $1 AND c.tx < txid_snapshot_xmin(txid_current_snapshot())
+		ORDER BY c.id ASC
+		LIMIT $2`,
+		since,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := make([]*PostChange, 0)
+	for rows.Next() {
+		change := &PostChange{}
+		post := &Post{}
+		var kind string
+		var parent int
+		var exists bool
+		err := rows.Scan(
+			&change.Cursor, &kind, &change.Cat, &change.Num, &parent, &change.ChangedAt,
+			&exists, &post.Subject, &post.Content, &post.Username, &post.CreatedAt, &post.Capcode, &post.Nonce,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse change: %w", err)
+		}
+		change.Kind = ChangeKind(kind)
+		change.Thread = parent
+		if parent == 0 {
+			change.Thread = change.Num
+		}
+		if exists {
+			post.Num, post.Cat, post.Parent = change.Num, change.Cat, parent
+			post.derive()
+			post.Hash = post.ContentHash()
+			change.Post = post
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+/*
+PostEvent is a change to a post as recorded for moderators, with who made it.
+Posts' events are written by the database in the same transaction as the change, and never altered.
+*/
+type PostEvent struct {
+	ID   int64      `json:"id"`
+	Kind ChangeKind `json:"kind"`
+	// Account that made the change, empty for anonymous posters and automatic changes.
+	Actor string `json:"actor,omitempty"`
+	// What a moderation event did, like quarantined or locked.
+	Detail    string    `json:"detail,omitempty"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+/*
+tagActor records the identity carried by the context as the actor of the events the transaction writes.
+The setting's local to the transaction, so it doesn't leak to whoever uses the connection next.
+*/
+func tagActor(ctx context.Context, tx querier) error {
+	identity := IdentityFrom(ctx)
+	if identity == nil || len(identity.ID) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, "SELECT set_config('spiritchat.actor', $1, true)", identity.ID)
+	if err != nil {
+		return fmt.Errorf("failed to tag transaction actor: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetPostEvents(ctx context.Context, categoryTag string, num int) ([]*PostEvent, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT id, kind, actor, detail, changed_at FROM post_events WHERE cat = $1 AND num = $2 ORDER BY id ASC",
+		categoryTag,
+		num,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query post events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]*PostEvent, 0)
+	for rows.Next() {
+		event := &PostEvent{}
+		var kind string
+		err := rows.Scan(&event.ID, &kind, &event.Actor, &event.Detail, &event.ChangedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse post event: %w", err)
+		}
+		event.Kind = ChangeKind(kind)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ClientKey lets an anonymous client, like an archiver, read with a higher quota. Only its hash is kept.
+type ClientKey struct {
+	ID        int        `json:"id"`
+	Label     string     `json:"label"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// ClientKeyHash returns the hash client keys are stored and looked up by.
+func ClientKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newClientKey returns a random 32 character URL-safe key.
+func newClientKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (store *DataStore) WriteClientKey(ctx context.Context, label string) (*ClientKey, string, error) {
+	key, err := newClientKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate client key: %w", err)
+	}
+	clientKey := &ClientKey{Label: label}
+	err = store.pgPool.QueryRow(
+		ctx,
+		"INSERT INTO client_keys (key_hash, label) VALUES ($1, $2) RETURNING id, created_at",
+		ClientKeyHash(key),
+		label,
+	).Scan(&clientKey.ID, &clientKey.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to write client key: %w", err)
+	}
+	return clientKey, key, nil
+}
+
+func (store *DataStore) GetClientKeys(ctx context.Context) ([]*ClientKey, error) {
+	rows, err := store.pgPool.Query(ctx, "SELECT id, label, created_at, revoked_at FROM client_keys ORDER BY id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query client keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]*ClientKey, 0)
+	for rows.Next() {
+		key := &ClientKey{}
+		err := rows.Scan(&key.ID, &key.Label, &key.CreatedAt, &key.RevokedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (store *DataStore) RevokeClientKey(ctx context.Context, id int) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE client_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL",
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke client key: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *DataStore) IsClientKeyValid(ctx context.Context, keyHash string) (bool, error) {
+	var id int
+	err := store.pgPool.QueryRow(
+		ctx,
+		"SELECT id FROM client_keys WHERE key_hash = $1 AND revoked_at IS NULL",
+		keyHash,
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to look up client key: %w", err)
+	}
+	return true, nil
+}
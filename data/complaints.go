@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ComplaintKind is what a complaint is about.
+type ComplaintKind string
+
+const (
+	// ComplaintAbuse is a complaint about illegal or abusive posts.
+	ComplaintAbuse ComplaintKind = "abuse"
+	// ComplaintDMCA is a takedown notice for posts infringing copyright.
+	ComplaintDMCA ComplaintKind = "dmca"
+)
+
+// Complaint is an abuse complaint or takedown notice, filed by anyone, with or without an account.
+type Complaint struct {
+	ID      int           `json:"id"`
+	Kind    ComplaintKind `json:"kind"`
+	Name    string        `json:"name"`
+	Email   string        `json:"email"`
+	Details string        `json:"details"`
+	// The copyrighted work and the complainant's signature, on takedown notices.
+	Work      string    `json:"work,omitempty"`
+	Signature string    `json:"signature,omitempty"`
+	Posts     []PostRef `json:"posts"`
+	// PosterHash of the IP the complaint was filed from.
+	ReporterHash string    `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// reportReason is what reports filed from the complaint give as their reason in the moderation queue.
+func (complaint *Complaint) reportReason() string {
+	if complaint.Kind == ComplaintDMCA {
+		return fmt.Sprintf("Takedown notice #%d", complaint.ID)
+	}
+	return fmt.Sprintf("Abuse complaint #%d", complaint.ID)
+}
+
+func (store *DataStore) WriteComplaint(ctx context.Context, complaint *Complaint) (int, error) {
+	cats := make([]string, len(complaint.Posts))
+	nums := make([]int32, len(complaint.Posts))
+	for i, ref := range complaint.Posts {
+		cats[i], nums[i] = ref.Cat, int32(ref.Num)
+	}
+	email, err := store.pii.seal(complaint.Email)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt complaint email: %w", err)
+	}
+
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin writing complaint: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO abuse_complaints (kind, name, email, details, work, signature, post_cats, post_nums, reporter_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at`,
+		string(complaint.Kind),
+		complaint.Name,
+		email,
+		complaint.Details,
+		complaint.Work,
+		complaint.Signature,
+		cats,
+		nums,
+		complaint.ReporterHash,
+	).Scan(&complaint.ID, &complaint.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write complaint: %w", err)
+	}
+
+	// Posts that are already gone are only kept on the complaint.
+	res, err := tx.Exec(
+		ctx,
+		`INSERT INTO reports (cat, num, reason, reporter_hash, priority, complaint_id)
+		SELECT DISTINCT p.cat, p.num, $3, $4, true, $5
+		FROM posts p JOIN unnest($1::text[], $2::integer[]) AS c (cat, num) ON p.cat = c.cat AND p.num = c.num`,
+		cats,
+		nums,
+		complaint.reportReason(),
+		complaint.ReporterHash,
+		complaint.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to report complained about posts: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit complaint: %w", err)
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (store *DataStore) GetComplaint(ctx context.Context, id int) (*Complaint, error) {
+	complaint := &Complaint{}
+	var kind string
+	var cats []string
+	var nums []int32
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT id, kind, name, email, details, work, signature, post_cats, post_nums, reporter_hash, created_at
+		FROM abuse_complaints WHERE id = $1`,
+		id,
+	).Scan(
+		&complaint.ID, &kind, &complaint.Name, &complaint.Email, &complaint.Details, &complaint.Work,
+		&complaint.Signature, &cats, &nums, &complaint.ReporterHash, &complaint.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get complaint: %w", err)
+	}
+	complaint.Email, err = store.pii.open(complaint.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt complaint email: %w", err)
+	}
+	complaint.Kind = ComplaintKind(kind)
+	complaint.Posts = make([]PostRef, len(cats))
+	for i := range cats {
+		complaint.Posts[i] = PostRef{Cat: cats[i], Num: int(nums[i])}
+	}
+	return complaint, nil
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+func (store *DataStore) WriteConfirmation(ctx context.Context, userID string, requestHash string, expires time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate confirmation token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	// Nothing needs confirmations once they've expired, so they're cleared out as new ones are made.
+	_, err := store.pgPool.Exec(ctx, "DELETE FROM confirmations WHERE expires_at < now()")
+	if err != nil {
+		return "", fmt.Errorf("failed to clear expired confirmations: %w", err)
+	}
+	_, err = store.pgPool.Exec(
+		ctx,
+		"INSERT INTO confirmations (token_hash, user_id, request_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		TokenHash(token),
+		userID,
+		requestHash,
+		expires,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to write confirmation: %w", err)
+	}
+	return token, nil
+}
+
+func (store *DataStore) UseConfirmation(ctx context.Context, token string, userID string, requestHash string) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		`UPDATE confirmations SET used_at = now()
+		WHERE token_hash = $1 AND user_id = $2 AND request_hash = $3 AND used_at IS NULL AND expires_at > now()`,
+		TokenHash(token),
+		userID,
+		requestHash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use confirmation: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package data_test
+
+import (
+	"context"
+	"spiritchat/config"
+	"spiritchat/data"
+	"spiritchat/data/datatest"
+	"testing"
+)
+
+// Fails to compile when DataStore falls behind the interface.
+var _ data.Store = (*data.DataStore)(nil)
+
+func TestStoreContract(t *testing.T) {
+	conf, shouldRun := config.GetIntegrationsConfig()
+	if !shouldRun {
+		t.Log("skipping integration test")
+		return
+	}
+
+	ctx := context.Background()
+	store, err := data.NewDatastore(ctx, conf.PGURL, 100)
+	if err != nil {
+		t.Fatalf("integration test setup failure: %v", err)
+	}
+	defer store.Cleanup(ctx)
+	datatest.StoreContract(ctx, store)(t)
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+)
+
+/*
+crossRefPattern matches references to posts on other categories, such as >>>/tech/123.
+Content is stored HTML escaped, so escaped references match too.
+*/
+var crossRefPattern = regexp.MustCompile(`(?:>|&gt;){3}/([^/\s&]+)/([0-9]+)`)
+
+// Only the first references in a post are stored, so a post can't fan out to every thread.
+const maxCrossRefs = 20
+
+// CrossRef is a reference in a post's content to a post on another category.
+type CrossRef struct {
+	Cat string `json:"cat"`
+	Num int    `json:"num"`
+	// Thread the referenced post is on, its own number if it's a thread.
+	Thread int `json:"thread"`
+}
+
+// parseCrossRefs returns the distinct posts referenced in content.
+func parseCrossRefs(content string) []PostRef {
+	var refs []PostRef
+	seen := make(map[PostRef]bool)
+	for _, match := range crossRefPattern.FindAllStringSubmatch(content, -1) {
+		// Post numbers are stored as 32 bit integers, and anything bigger can't be a post.
+		num, err := strconv.ParseInt(match[2], 10, 32)
+		if err != nil || num < 1 {
+			continue
+		}
+		ref := PostRef{Cat: match[1], Num: int(num)}
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+		if len(refs) == maxCrossRefs {
+			break
+		}
+	}
+	return refs
+}
+
+// writeCrossRefs stores the post's references to posts that exist, dropping the rest.
+func writeCrossRefs(ctx context.Context, tx pgx.Tx, categoryTag string, num int, refs []PostRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	cats := make([]string, len(refs))
+	nums := make([]int32, len(refs))
+	for i, ref := range refs {
+		cats[i], nums[i] = ref.Cat, int32(ref.Num)
+	}
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO cross_refs (cat, num, target_cat, target_num)
+		SELECT $1, $2, posts.cat, posts.num FROM posts
+		JOIN unnest($3::text[], $4::integer[]) AS refs (cat, num) ON posts.cat = refs.cat AND posts.num = refs.num
+		ON CONFLICT DO NOTHING`,
+		categoryTag,
+		num,
+		cats,
+		nums,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write cross references: %w", err)
+	}
+	return nil
+}
+
+// attachCrossRefs loads the references made by each of the posts, which must be on the same category.
+func (store *DataStore) attachCrossRefs(ctx context.Context, categoryTag string, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byNum := make(map[int]*Post, len(posts))
+	nums := make([]int32, len(posts))
+	for i, post := range posts {
+		byNum[post.Num] = post
+		nums[i] = int32(post.Num)
+	}
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT refs.num, target.cat, target.num, CASE WHEN target.parent = 0 THEN target.num ELSE target.parent END
+		FROM cross_refs refs JOIN posts target ON target.cat = refs.target_cat AND target.num = refs.target_num
+		WHERE refs.cat = $1 AND refs.num = ANY($2)
+		ORDER BY refs.num, target.cat, target.num`,
+		categoryTag,
+		nums,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query cross references: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var num int
+		ref := CrossRef{}
+		err := rows.Scan(&num, &ref.Cat, &ref.Num, &ref.Thread)
+		if err != nil {
+			return fmt.Errorf("failed to parse a cross reference: %w", err)
+		}
+		if post, ok := byNum[num]; ok {
+			post.CrossRefs = append(post.CrossRefs, ref)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCrossRefs(t *testing.T) {
+	tests := map[string][]PostRef{
+		"no references":                        nil,
+		">>>/tech/12 and >>>/art/3":            {{Cat: "tech", Num: 12}, {Cat: "art", Num: 3}},
+		"&gt;&gt;&gt;/tech/12 escaped":         {{Cat: "tech", Num: 12}},
+		">>>/tech/12 >>>/tech/12 repeated":     {{Cat: "tech", Num: 12}},
+		">>12 and >>/tech/12 aren't, >>>/tech": nil,
+		">>>/tech/0 >>>/tech/4294967297":       nil,
+	}
+	for content, expected := range tests {
+		refs := parseCrossRefs(content)
+		if !reflect.DeepEqual(refs, expected) {
+			t.Errorf("parsing %q: expected %+v, got %+v", content, expected, refs)
+		}
+	}
+
+	content := ""
+	for i := 0; i < maxCrossRefs+5; i++ {
+		content += fmt.Sprintf(">>>/tech/%d ", i)
+	}
+	if refs := parseCrossRefs(content); len(refs) != maxCrossRefs {
+		t.Errorf("expected at most %d references, got %d", maxCrossRefs, len(refs))
+	}
+}
+
+func FuzzParseCrossRefs(f *testing.F) {
+	for _, seed := range []string{">>>/tech/12 and >>>/art/3", "&gt;&gt;&gt;/tech/12", ">>>/tech/99999999999", ">>>/tech/0"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, content string) {
+		refs := parseCrossRefs(content)
+		if len(refs) > maxCrossRefs {
+			t.Errorf("%d references", len(refs))
+		}
+		seen := make(map[PostRef]bool)
+		for _, ref := range refs {
+			if ref.Num < 1 || ref.Num > math.MaxInt32 {
+				t.Errorf("post number %d out of range", ref.Num)
+			}
+			if len(ref.Cat) == 0 || strings.ContainsAny(ref.Cat, "/& \t\n") {
+				t.Errorf("bad category %q", ref.Cat)
+			}
+			if seen[ref] {
+				t.Errorf("repeated reference %+v", ref)
+			}
+			seen[ref] = true
+		}
+	})
+}
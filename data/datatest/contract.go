@@ -0,0 +1,139 @@
+/*
+Package datatest holds the behaviour handlers rely on from any data.Store, so every implementation,
+test doubles included, can be held to the same contract.
+*/
+package datatest
+
+import (
+	"context"
+	"errors"
+	"spiritchat/data"
+	"testing"
+)
+
+// threadCollector keeps a streamed thread in memory.
+type threadCollector struct {
+	view data.ThreadView
+}
+
+func (tc *threadCollector) WriteCategory(category *data.Category) error {
+	tc.view.Category = category
+	return nil
+}
+
+func (tc *threadCollector) WritePosts(posts []*data.Post) error {
+	tc.view.Posts = append(tc.view.Posts, posts...)
+	return nil
+}
+
+// MissingContract checks a store reports data.ErrNotFound reading from a category it doesn't have.
+func MissingContract(ctx context.Context, store data.Store) func(t *testing.T) {
+	return func(t *testing.T) {
+		notFound := map[string]func() error{
+			"GetCategory": func() error {
+				_, err := store.GetCategory(ctx, "contract-missing")
+				return err
+			},
+			"GetCategoryView": func() error {
+				_, err := store.GetCategoryView(ctx, "contract-missing")
+				return err
+			},
+			"GetThreadView": func() error {
+				_, err := store.GetThreadView(ctx, "contract-missing", 1)
+				return err
+			},
+			"GetPostByNumber": func() error {
+				_, err := store.GetPostByNumber(ctx, "contract-missing", 1)
+				return err
+			},
+			"GetPostOwnership": func() error {
+				_, err := store.GetPostOwnership(ctx, "contract-missing", 1)
+				return err
+			},
+			"GetPage": func() error {
+				_, err := store.GetPage(ctx, "contract-missing")
+				return err
+			},
+			"GetComplaint": func() error {
+				_, err := store.GetComplaint(ctx, -1)
+				return err
+			},
+		}
+		for name, call := range notFound {
+			if err := call(); !errors.Is(err, data.ErrNotFound) {
+				t.Errorf("%s: expected ErrNotFound for a missing category, got: %v", name, err)
+			}
+		}
+	}
+}
+
+// StoreContract checks a store against the whole contract, writing and reading back a category of its own.
+func StoreContract(ctx context.Context, store data.Store) func(t *testing.T) {
+	return func(t *testing.T) {
+		MissingContract(ctx, store)(t)
+		_, err := store.WritePost(ctx, "contract-missing", 0, "hi", "hi", &data.Identity{IP: "127.0.0.1"}, "", "", nil)
+		if !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("WritePost: expected ErrNotFound for a missing category, got: %v", err)
+		}
+
+		err = store.WriteCategory(ctx, "contract", "Contract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.RemoveCategory(ctx, "contract", data.RemoveCategoryOptions{})
+		if err := store.WriteCategory(ctx, "contract", "Again"); !errors.Is(err, data.ErrCategoryExists) {
+			t.Errorf("expected data.ErrCategoryExists writing a taken tag, got: %v", err)
+		}
+		for _, tag := range []string{"; DROP TABLE posts", "Contract", "contract-tags"} {
+			if err := store.WriteCategory(ctx, tag, "Invalid"); !errors.Is(err, data.ErrInvalidCategoryTag) {
+				t.Errorf("expected data.ErrInvalidCategoryTag writing %q, got: %v", tag, err)
+			}
+		}
+
+		author := &data.Identity{ID: "contract-author", Username: "a", Email: "b", IP: "127.0.0.1"}
+		thread, err := store.WritePost(ctx, "contract", 0, "subject", "thread", author, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := store.WritePost(ctx, "contract", thread, "", "reply", &data.Identity{IP: "127.0.0.2"}, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply <= thread {
+			t.Errorf("expected post numbers to increase, got thread %d then reply %d", thread, reply)
+		}
+
+		view, err := store.GetThreadView(ctx, "contract", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(view.Posts) != 2 || view.Posts[0].Num != thread || view.Posts[1].Num != reply {
+			t.Errorf("expected the thread then its reply, got: %v", view.Posts)
+		}
+		streamed := &threadCollector{}
+		more, err := store.StreamThreadView(ctx, "contract", thread, data.ThreadPage{Limit: 1}, streamed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !more || len(streamed.view.Posts) != 1 || streamed.view.Posts[0].Num != thread {
+			t.Errorf("expected only the thread streamed with more set, got %v, more %v", streamed.view.Posts, more)
+		}
+		_, err = store.StreamThreadView(ctx, "contract", reply, data.ThreadPage{}, &threadCollector{})
+		if !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("expected data.ErrNotFound streaming a reply as a thread, got: %v", err)
+		}
+
+		_, err = store.GetThreadView(ctx, "contract", reply)
+		if !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("expected data.ErrNotFound viewing a reply as a thread, got: %v", err)
+		}
+
+		ownership, err := store.GetPostOwnership(ctx, "contract", reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ownership.IsThreadAuthor(author) || ownership.IsAuthor(author) {
+			t.Errorf("expected the thread's author to own the thread but not the reply, got: %+v", ownership)
+		}
+	}
+}
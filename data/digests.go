@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// Digest is a batch of the top new threads in the categories a user subscribes to.
+type Digest struct {
+	ID        int             `json:"id"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Threads   []*DigestThread `json:"threads"`
+}
+
+// DigestThread is a thread in a digest, with its replies so far.
+type DigestThread struct {
+	*Post
+	Replies int `json:"replies"`
+}
+
+func (store *DataStore) SetCategorySubscription(ctx context.Context, accountID string, categoryTag string, subscribed bool) error {
+	if !subscribed {
+		_, err := store.pgPool.Exec(
+			ctx,
+			"DELETE FROM category_subscriptions WHERE account_id = $1 AND cat = $2",
+			accountID,
+			categoryTag,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to unsubscribe from category: %w", err)
+		}
+		return nil
+	}
+
+	_, err := store.pgPool.Exec(
+		ctx,
+		"INSERT INTO category_subscriptions (account_id, cat) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		accountID,
+		categoryTag,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to subscribe to category: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetCategorySubscriptions(ctx context.Context, accountID string) ([]string, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT cat FROM category_subscriptions WHERE account_id = $1 ORDER BY created_at",
+		accountID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := make([]string, 0)
+	for rows.Next() {
+		var categoryTag string
+		if err := rows.Scan(&categoryTag); err != nil {
+			return nil, fmt.Errorf("failed to parse a category subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, categoryTag)
+	}
+	return subscriptions, nil
+}
+
+func (store *DataStore) WriteDigests(ctx context.Context, size int) (int, error) {
+	rows, err := store.pgPool.Query(ctx, "SELECT DISTINCT account_id FROM category_subscriptions")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query subscribers: %w", err)
+	}
+	var accounts []string
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to parse a subscriber: %w", err)
+		}
+		accounts = append(accounts, accountID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query subscribers: %w", err)
+	}
+
+	written := 0
+	for _, accountID := range accounts {
+		ok, err := store.writeDigest(ctx, accountID, size)
+		if err != nil {
+			return written, err
+		}
+		if ok {
+			written++
+		}
+	}
+	return written, nil
+}
+
+// writeDigest writes a digest of threads made since the account's last one, returning false if there weren't any.
+func (store *DataStore) writeDigest(ctx context.Context, accountID string, size int) (bool, error) {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin digest transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var digestID int
+	err = tx.QueryRow(ctx, "INSERT INTO digests (account_id) VALUES ($1) RETURNING id", accountID).Scan(&digestID)
+	if err != nil {
+		return false, fmt.Errorf("failed to write digest: %w", err)
+	}
+	// Threads from before the subscription, or already in a digest, are left out.
+	res, err := tx.Exec(
+		ctx,
+		`INSERT INTO digest_threads (digest_id, cat, num, position)
+		SELECT $1, cat, num, row_number() OVER (ORDER BY score DESC) FROM (
+			SELECT t.cat, t.num, trending_score(
+				(SELECT count(*) FROM posts r WHERE r.cat = t.cat AND r.parent = t.num), t.created_at
+			) AS score
+			FROM category_subscriptions s
+			JOIN posts t ON t.cat = s.cat AND t.parent = 0 AND NOT t.quarantined
+			WHERE s.account_id = $2 AND t.created_at > GREATEST(
+				s.created_at,
+				(SELECT max(created_at) FROM digests WHERE account_id = $2 AND id <> $1)
+			)
+			ORDER BY score DESC LIMIT $3
+		) top`,
+		digestID,
+		accountID,
+		size,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to write digest threads: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return false, nil
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit digest: %w", err)
+	}
+	return true, nil
+}
+
+func (store *DataStore) GetDigests(ctx context.Context, accountID string, before int, limit int) ([]*Digest, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT id, created_at FROM digests
+		WHERE account_id = $1 AND ($2 = 0 OR id < $2) AND EXISTS (SELECT FROM digest_threads WHERE digest_threads.digest_id = digests.id)
+		ORDER BY id DESC LIMIT $3`,
+		accountID,
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query digests: %w", err)
+	}
+	defer rows.Close()
+
+	digests := make([]*Digest, 0)
+	byID := make(map[int]*Digest)
+	ids := make([]int32, 0)
+	for rows.Next() {
+		digest := &Digest{Threads: make([]*DigestThread, 0)}
+		if err := rows.Scan(&digest.ID, &digest.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse a digest: %w", err)
+		}
+		digests = append(digests, digest)
+		byID[digest.ID] = digest
+		ids = append(ids, int32(digest.ID))
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return digests, nil
+	}
+
+	rows, err = store.pgPool.Query(
+		ctx,
+		`SELECT d.digest_id, p.num, p.cat, p.subject, p.content, p.username, p.created_at,
+		(SELECT count(*) FROM posts r WHERE r.cat = p.cat AND r.parent = p.num)
+		FROM digest_threads d JOIN posts p ON p.cat = d.cat AND p.num = d.num
+		WHERE d.digest_id = ANY($1)
+		ORDER BY d.digest_id, d.position`,
+		ids,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query digest threads: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var digestID int
+		thread := &DigestThread{Post: &Post{}}
+		err := rows.Scan(
+			&digestID, &thread.Num, &thread.Cat, &thread.Subject, &thread.Content, &thread.Username, &thread.CreatedAt,
+			&thread.Replies,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a digest thread: %w", err)
+		}
+		thread.derive()
+		byID[digestID].Threads = append(byID[digestID].Threads, thread)
+	}
+	return digests, nil
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DomainAction is what happens to posts linking to a blocked domain.
+type DomainAction string
+
+const (
+	// DomainReject refuses posts linking to the domain.
+	DomainReject DomainAction = "reject"
+	// DomainStrip removes links to the domain from posts, accepting the rest.
+	DomainStrip DomainAction = "strip"
+)
+
+// BlockedDomain is a domain posts can't link to, like example.com, or *.example.com for it and every subdomain.
+type BlockedDomain struct {
+	Domain string       `json:"domain"`
+	Action DomainAction `json:"action"`
+	// Posts that linked to it and were rejected or stripped, and when the last one was.
+	Blocked       int        `json:"blocked"`
+	LastBlockedAt *time.Time `json:"lastBlockedAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// Matches reports whether a link's lowercase host is the domain, or under it if it's a wildcard.
+func (blocked *BlockedDomain) Matches(host string) bool {
+	if !strings.HasPrefix(blocked.Domain, "*.") {
+		return host == blocked.Domain
+	}
+	parent := blocked.Domain[2:]
+	return host == parent || strings.HasSuffix(host, "."+parent)
+}
+
+func (store *DataStore) GetBlockedDomains(ctx context.Context) ([]*BlockedDomain, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT domain, action, blocked, last_blocked_at, created_at FROM blocked_domains ORDER BY domain ASC",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocked domains: %w", err)
+	}
+	defer rows.Close()
+
+	domains := make([]*BlockedDomain, 0)
+	for rows.Next() {
+		blocked := &BlockedDomain{}
+		err := rows.Scan(&blocked.Domain, &blocked.Action, &blocked.Blocked, &blocked.LastBlockedAt, &blocked.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a blocked domain: %w", err)
+		}
+		domains = append(domains, blocked)
+	}
+	return domains, rows.Err()
+}
+
+func (store *DataStore) BlockDomain(ctx context.Context, domain string, action DomainAction) (*BlockedDomain, error) {
+	blocked := &BlockedDomain{Domain: domain, Action: action}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO blocked_domains (domain, action) VALUES ($1, $2)
+		ON CONFLICT (domain) DO UPDATE SET action = EXCLUDED.action
+		RETURNING blocked, last_blocked_at, created_at`,
+		domain,
+		action,
+	).Scan(&blocked.Blocked, &blocked.LastBlockedAt, &blocked.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to block domain: %w", err)
+	}
+	return blocked, nil
+}
+
+func (store *DataStore) UnblockDomain(ctx context.Context, domain string) error {
+	res, err := store.pgPool.Exec(ctx, "DELETE FROM blocked_domains WHERE domain = $1", domain)
+	if err != nil {
+		return fmt.Errorf("failed to unblock domain: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *DataStore) RecordBlockedLinks(ctx context.Context, domains ...string) error {
+	_, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE blocked_domains SET blocked = blocked + 1, last_blocked_at = CURRENT_TIMESTAMP WHERE domain = ANY($1)",
+		domains,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record blocked links: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// categoryFixture describes a category for setUpFixtures to write, and the threads on it.
+type categoryFixture struct {
+	tag     string
+	name    string
+	threads int
+	replies int
+	author  *Identity
+}
+
+// newCategory describes an empty category, named after its tag.
+func newCategory(tag string) *categoryFixture {
+	return &categoryFixture{
+		tag:    tag,
+		name:   tag,
+		author: &Identity{Username: "fixture", Email: "fixture@example.com", IP: "127.0.0.1"},
+	}
+}
+
+func (c *categoryFixture) Named(name string) *categoryFixture {
+	c.name = name
+	return c
+}
+
+func (c *categoryFixture) WithThreads(n int) *categoryFixture {
+	c.threads = n
+	return c
+}
+
+// WithReplies adds n replies to each of the category's threads.
+func (c *categoryFixture) WithReplies(n int) *categoryFixture {
+	c.replies = n
+	return c
+}
+
+// By sets who writes the category's posts.
+func (c *categoryFixture) By(author *Identity) *categoryFixture {
+	c.author = author
+	return c
+}
+
+// fixtures are what setUpFixtures wrote.
+type fixtures struct {
+	// Thread numbers by category tag, in the order they were written.
+	Threads map[string][]int
+}
+
+/*
+setUpFixtures writes the categories in the order given, then each one's threads followed by their replies,
+one at a time so post numbers are the same on every run. Everything is removed when the test finishes,
+and the test stops if anything can't be written.
+*/
+func setUpFixtures(ctx context.Context, t *testing.T, store Store, categories ...*categoryFixture) *fixtures {
+	t.Helper()
+	written := &fixtures{Threads: make(map[string][]int)}
+	for _, cat := range categories {
+		tag := cat.tag
+		err := store.WriteCategory(ctx, tag, cat.name)
+		if err != nil {
+			t.Fatalf("failed to create category %s: %v", tag, err)
+		}
+		t.Cleanup(func() {
+			store.RemoveCategory(ctx, tag, RemoveCategoryOptions{})
+		})
+
+		for i := 1; i <= cat.threads; i++ {
+			thread, err := store.WritePost(
+				ctx, tag, 0, fmt.Sprintf("thread %d", i), fmt.Sprintf("thread %d", i), cat.author, "", "", nil,
+			)
+			if err != nil {
+				t.Fatalf("failed to write thread on %s: %v", tag, err)
+			}
+			for j := 1; j <= cat.replies; j++ {
+				_, err := store.WritePost(ctx, tag, thread, "", fmt.Sprintf("reply %d", j), cat.author, "", "", nil)
+				if err != nil {
+					t.Fatalf("failed to write reply on %s/%d: %v", tag, thread, err)
+				}
+			}
+			written.Threads[tag] = append(written.Threads[tag], thread)
+		}
+	}
+	return written
+}
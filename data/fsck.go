@@ -0,0 +1,69 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+// CounterDrift is a category whose post counter has fallen behind its posts.
+type CounterDrift struct {
+	Tag string `json:"tag"`
+	// Number the category would have given its next post.
+	PostCount int `json:"postCount"`
+	// Highest number of a post on the category.
+	Highest int `json:"highest"`
+}
+
+/*
+CheckPostCounts finds categories whose post counter isn't past their highest numbered post, which would have
+their next post collide with it. Counters ahead of their posts are expected, numbers aren't reused after deletes.
+If repair is set the drifted counters are moved past their highest post, in the same transaction they're found in.
+*/
+func (store *DataStore) CheckPostCounts(ctx context.Context, repair bool) ([]*CounterDrift, error) {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin post count check: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT c.tag, c.post_count, h.highest FROM cats c
+		JOIN (SELECT cat, max(num) AS highest FROM posts GROUP BY cat) h ON h.cat = c.tag
+		WHERE c.post_count <= h.highest ORDER BY c.tag FOR UPDATE OF c`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query post counts: %w", err)
+	}
+	drifted := make([]*CounterDrift, 0)
+	for rows.Next() {
+		drift := &CounterDrift{}
+		err := rows.Scan(&drift.Tag, &drift.PostCount, &drift.Highest)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to parse a post count: %w", err)
+		}
+		drifted = append(drifted, drift)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query post counts: %w", err)
+	}
+	if !repair {
+		return drifted, nil
+	}
+
+	for _, drift := range drifted {
+		_, err := tx.Exec(
+			ctx, "UPDATE cats SET post_count = GREATEST(post_count, $2) WHERE tag = $1", drift.Tag, drift.Highest+1,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to repair post count of %s: %w", drift.Tag, err)
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit post count repairs: %w", err)
+	}
+	return drifted, nil
+}
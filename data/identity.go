@@ -0,0 +1,87 @@
+package data
+
+import "context"
+
+/*
+Identity is who's making a request, so store operations can act on a single source
+of truth instead of loose emails and IPs.
+*/
+type Identity struct {
+	// Auth0 subject of the account, recorded as the author of its posts.
+	ID       string
+	Email    string
+	Username string
+	// Staff roles the user's been granted.
+	Roles []string
+	IP    string
+	// PosterHash of the IP.
+	IPHash string
+}
+
+// NewIdentity creates an identity, hashing the IP.
+func NewIdentity(id string, email string, username string, roles []string, ip string) *Identity {
+	return &Identity{
+		ID:       id,
+		Email:    email,
+		Username: username,
+		Roles:    roles,
+		IP:       ip,
+		IPHash:   PosterHash(ip),
+	}
+}
+
+// EmailHash returns the PosterHash of the identity's email.
+func (identity *Identity) EmailHash() string {
+	return PosterHash(identity.Email)
+}
+
+// PosterHashes returns every hash the identity's posts and bans can be found under.
+func (identity *Identity) PosterHashes() []string {
+	return []string{identity.IPHash, identity.EmailHash()}
+}
+
+// HasRole returns true if the identity was granted the role.
+func (identity *Identity) HasRole(role string) bool {
+	for _, r := range identity.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// PostOwnership is who owns a post, for authorizing changes to it.
+type PostOwnership struct {
+	AuthorID string
+	// Author of the thread the post is on, the post's own author if it's a thread.
+	ThreadAuthorID string
+	// Thread the post is on, zero if it's a thread.
+	Parent int
+}
+
+// IsAuthor returns true if the identity wrote the post.
+func (ownership *PostOwnership) IsAuthor(identity *Identity) bool {
+	return isAuthor(ownership.AuthorID, identity)
+}
+
+// IsThreadAuthor returns true if the identity wrote the thread the post is on.
+func (ownership *PostOwnership) IsThreadAuthor(identity *Identity) bool {
+	return isAuthor(ownership.ThreadAuthorID, identity)
+}
+
+func isAuthor(authorID string, identity *Identity) bool {
+	return len(authorID) > 0 && identity != nil && authorID == identity.ID
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the identity.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity carried by the context, nil if there isn't one.
+func IdentityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
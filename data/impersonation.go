@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Impersonation scopes, read only allowing GET requests.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Impersonation is an admin's time limited grant to act as a user.
+type Impersonation struct {
+	ID int `json:"id"`
+	// Email of the admin acting as the user.
+	Admin    string    `json:"admin"`
+	UserID   string    `json:"userId"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	Scope    string    `json:"scope"`
+	Reason   string    `json:"reason"`
+	Expires  time.Time `json:"expires"`
+	Revoked  bool      `json:"revoked"`
+	Created  time.Time `json:"createdAt"`
+}
+
+// ImpersonationAction is a request made while impersonating a user.
+type ImpersonationAction struct {
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	At     time.Time `json:"at"`
+}
+
+func (store *DataStore) WriteImpersonation(ctx context.Context, imp *Impersonation) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate impersonation token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	email, err := store.pii.seal(imp.Email)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt impersonated email: %w", err)
+	}
+	// Only the token's hash is kept, so the table can't be used to impersonate anyone.
+	err = store.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO impersonations (token_hash, admin, user_id, username, email, scope, reason, expires)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		TokenHash(token),
+		imp.Admin,
+		imp.UserID,
+		imp.Username,
+		email,
+		imp.Scope,
+		imp.Reason,
+		imp.Expires,
+	).Scan(&imp.ID, &imp.Created)
+	if err != nil {
+		return "", fmt.Errorf("failed to write impersonation: %w", err)
+	}
+	return token, nil
+}
+
+func (store *DataStore) GetImpersonationByToken(ctx context.Context, token string) (*Impersonation, error) {
+	imp := &Impersonation{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT id, admin, user_id, username, email, scope, reason, expires, revoked, created_at FROM impersonations
+		WHERE token_hash = $1 AND NOT revoked AND expires > now()`,
+		TokenHash(token),
+	).Scan(
+		&imp.ID, &imp.Admin, &imp.UserID, &imp.Username, &imp.Email, &imp.Scope, &imp.Reason,
+		&imp.Expires, &imp.Revoked, &imp.Created,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get impersonation: %w", err)
+	}
+	imp.Email, err = store.pii.open(imp.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt impersonated email: %w", err)
+	}
+	return imp, nil
+}
+
+func (store *DataStore) GetImpersonations(ctx context.Context, limit int) ([]*Impersonation, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT id, admin, user_id, username, email, scope, reason, expires, revoked, created_at FROM impersonations
+		ORDER BY id DESC LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query impersonations: %w", err)
+	}
+	defer rows.Close()
+
+	impersonations := make([]*Impersonation, 0)
+	for rows.Next() {
+		imp := &Impersonation{}
+		err := rows.Scan(
+			&imp.ID, &imp.Admin, &imp.UserID, &imp.Username, &imp.Email, &imp.Scope, &imp.Reason,
+			&imp.Expires, &imp.Revoked, &imp.Created,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse an impersonation: %w", err)
+		}
+		imp.Email, err = store.pii.open(imp.Email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt impersonated email: %w", err)
+		}
+		impersonations = append(impersonations, imp)
+	}
+	return impersonations, nil
+}
+
+func (store *DataStore) RevokeImpersonation(ctx context.Context, id int) error {
+	res, err := store.pgPool.Exec(ctx, "UPDATE impersonations SET revoked = true WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke impersonation: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *DataStore) LogImpersonationAction(ctx context.Context, id int, method string, path string) error {
+	_, err := store.pgPool.Exec(
+		ctx,
+		"INSERT INTO impersonation_actions (impersonation_id, method, path) VALUES ($1, $2, $3)",
+		id,
+		method,
+		path,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to log impersonation action: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetImpersonationActions(ctx context.Context, id int) ([]*ImpersonationAction, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT method, path, at FROM impersonation_actions WHERE impersonation_id = $1 ORDER BY id",
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query impersonation actions: %w", err)
+	}
+	defer rows.Close()
+
+	actions := make([]*ImpersonationAction, 0)
+	for rows.Next() {
+		action := &ImpersonationAction{}
+		if err := rows.Scan(&action.Method, &action.Path, &action.At); err != nil {
+			return nil, fmt.Errorf("failed to parse an impersonation action: %w", err)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
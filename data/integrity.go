@@ -0,0 +1,91 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+ContentHash returns the hex SHA-256 of the post's number, category, parent, subject, content, author, creation time
+and nonce, each written as its byte length followed by its bytes, so mirrors can recompute it from the post's JSON.
+The creation time is written in UTC as RFC 3339 with nanoseconds.
+*/
+func (post *Post) ContentHash() string {
+	h := sha256.New()
+	var length [8]byte
+	for _, field := range []string{
+		strconv.Itoa(post.Num),
+		post.Cat,
+		strconv.Itoa(post.Parent),
+		post.Subject,
+		post.Content,
+		post.Username,
+		post.CreatedAt.UTC().Format(time.RFC3339Nano),
+		post.Nonce,
+	} {
+		binary.BigEndian.PutUint64(length[:], uint64(len(field)))
+		h.Write(length[:])
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PostDigest is a post's number and content hash.
+type PostDigest struct {
+	Num  int    `json:"num"`
+	Hash string `json:"hash"`
+}
+
+// ThreadDigest lets a mirror check its copy of a thread hasn't been tampered with.
+type ThreadDigest struct {
+	Cat    string       `json:"cat"`
+	Thread int          `json:"thread"`
+	Posts  []PostDigest `json:"posts"`
+	Root   string       `json:"root"`
+}
+
+/*
+NewThreadDigest digests a thread's posts in number order. The root is a Merkle tree over the post hashes: each pair of
+nodes is hashed as SHA-256 of 0x01 followed by both, and a node left without a pair is carried up as it is.
+*/
+func NewThreadDigest(categoryTag string, threadNum int, posts []*Post) *ThreadDigest {
+	digest := &ThreadDigest{Cat: categoryTag, Thread: threadNum, Posts: make([]PostDigest, len(posts))}
+	for i, post := range posts {
+		hash := post.Hash
+		if len(hash) == 0 {
+			hash = post.ContentHash()
+		}
+		digest.Posts[i] = PostDigest{Num: post.Num, Hash: hash}
+	}
+	sort.Slice(digest.Posts, func(i, j int) bool {
+		return digest.Posts[i].Num < digest.Posts[j].Num
+	})
+
+	level := make([][]byte, len(digest.Posts))
+	for i, post := range digest.Posts {
+		level[i], _ = hex.DecodeString(post.Hash)
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte{1})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+	if len(level) == 1 {
+		digest.Root = hex.EncodeToString(level[0])
+	}
+	return digest
+}
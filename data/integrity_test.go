@@ -0,0 +1,64 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestContentHash(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 5, time.UTC)
+	post := &Post{Num: 2, Cat: "tech", Parent: 1, Content: "hello", Username: "Anon", CreatedAt: created, Nonce: "abc"}
+
+	hash := post.ContentHash()
+	if hash != post.ContentHash() {
+		t.Fatal("expected the same post to hash the same")
+	}
+	zoned := *post
+	zoned.CreatedAt = created.In(time.FixedZone("here", 3600))
+	if zoned.ContentHash() != hash {
+		t.Error("expected the creation time's zone not to change the hash")
+	}
+
+	changes := map[string]func(post *Post){
+		"content":  func(post *Post) { post.Content = "hellO" },
+		"nonce":    func(post *Post) { post.Nonce = "abd" },
+		"parent":   func(post *Post) { post.Parent = 0 },
+		"created":  func(post *Post) { post.CreatedAt = created.Add(time.Nanosecond) },
+		"boundary": func(post *Post) { post.Subject, post.Content = "h", "ello" },
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			changed := *post
+			change(&changed)
+			if changed.ContentHash() == hash {
+				t.Errorf("expected changing the %s to change the hash", name)
+			}
+		})
+	}
+}
+
+func TestNewThreadDigest(t *testing.T) {
+	posts := []*Post{{Num: 1, Cat: "tech"}, {Num: 3, Cat: "tech", Parent: 1}, {Num: 2, Cat: "tech", Parent: 1}}
+	digest := NewThreadDigest("tech", 1, posts)
+
+	if len(digest.Posts) != 3 || digest.Posts[0].Num != 1 || digest.Posts[1].Num != 2 || digest.Posts[2].Num != 3 {
+		t.Fatalf("expected posts in number order, got: %+v", digest.Posts)
+	}
+
+	node := func(left string, right string) string {
+		l, _ := hex.DecodeString(left)
+		r, _ := hex.DecodeString(right)
+		sum := sha256.Sum256(append(append([]byte{1}, l...), r...))
+		return hex.EncodeToString(sum[:])
+	}
+	expected := node(node(digest.Posts[0].Hash, digest.Posts[1].Hash), digest.Posts[2].Hash)
+	if digest.Root != expected {
+		t.Errorf("expected root %s, got %s", expected, digest.Root)
+	}
+
+	if single := NewThreadDigest("tech", 1, posts[:1]); single.Root != posts[0].ContentHash() {
+		t.Errorf("expected a lone post's hash to be the root, got %s", single.Root)
+	}
+}
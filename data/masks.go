@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v4"
+)
+
+/*
+MaskWords replaces whole-word, case-insensitive matches of any of the words in text with asterisks,
+one per character.
+*/
+func MaskWords(text string, words []string) string {
+	if len(words) == 0 {
+		return text
+	}
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if len(word) > 0 {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return text
+	}
+	re := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	return re.ReplaceAllStringFunc(text, func(match string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(match))
+	})
+}
+
+func (store *DataStore) GetCategoryMasks(ctx context.Context, categoryTag string) ([]string, error) {
+	var words []string
+	err := store.pgPool.QueryRow(ctx, "SELECT masked_words FROM cats WHERE tag = $1", categoryTag).Scan(&words)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query category masks: %w", err)
+	}
+	return words, nil
+}
+
+func (store *DataStore) SetCategoryMasks(ctx context.Context, categoryTag string, words []string) error {
+	if words == nil {
+		words = make([]string, 0)
+	}
+	res, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE cats SET masked_words = $2, version = version + 1, updated_at = now() WHERE tag = $1",
+		categoryTag,
+		words,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set category masks: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
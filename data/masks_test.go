@@ -0,0 +1,27 @@
+package data
+
+import "testing"
+
+func TestMaskWords(t *testing.T) {
+	words := []string{"darn", "heck", "a.b"}
+	tests := map[string]string{
+		"darn it":             "**** it",
+		"DARN it, Heck":       "**** it, ****",
+		"darned":              "darned",
+		"what the heck!":      "what the ****!",
+		"a.b but not axb":     "*** but not axb",
+		"nothing to see here": "nothing to see here",
+	}
+
+	for input, expect := range tests {
+		t.Run(input, func(t *testing.T) {
+			if got := MaskWords(input, words); got != expect {
+				t.Errorf("expected %q, got %q", expect, got)
+			}
+		})
+	}
+
+	if got := MaskWords("darn", nil); got != "darn" {
+		t.Errorf("expected no masks to leave text alone, got %q", got)
+	}
+}
@@ -0,0 +1,291 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrCantMessage = errors.New("you can't message that user")
+var ErrAmbiguousUsername = errors.New("more than one account has that username")
+
+// Conversation is a thread of private messages between the user and one other account.
+type Conversation struct {
+	ID int `json:"id"`
+	// Username of the other account.
+	With      string    `json:"with"`
+	Unread    int       `json:"unread"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Message is a private message in a conversation.
+type Message struct {
+	ID           int `json:"id"`
+	Conversation int `json:"conversation"`
+	// Username of the sender when they sent it.
+	From    string `json:"from"`
+	Content string `json:"content"`
+	// Whether the recipient has seen it.
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+/*
+accountByUsername finds the account seen with the username.
+Usernames from different connections can clash, so it returns ErrAmbiguousUsername rather than guess which was meant.
+*/
+func accountByUsername(ctx context.Context, q querier, username string) (string, error) {
+	rows, err := q.Query(ctx, "SELECT id FROM accounts_seen WHERE username = $1 LIMIT 2", username)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	switch len(ids) {
+	case 0:
+		return "", ErrNotFound
+	case 1:
+		return ids[0], nil
+	default:
+		return "", ErrAmbiguousUsername
+	}
+}
+
+func (store *DataStore) RecordAccount(ctx context.Context, accountID string, username string) error {
+	_, err := store.pgPool.Exec(
+		ctx,
+		`INSERT INTO accounts_seen (id, username) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET username = EXCLUDED.username`,
+		accountID,
+		username,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record account: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) SendMessage(ctx context.Context, sender *Identity, recipient string, content string) (*Message, error) {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin message transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	recipientID, err := accountByUsername(ctx, tx, recipient)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrAmbiguousUsername) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find recipient: %w", err)
+	}
+	if recipientID == sender.ID {
+		return nil, ErrCantMessage
+	}
+
+	var blocked bool
+	err = tx.QueryRow(
+		ctx,
+		`SELECT EXISTS (SELECT FROM blocks WHERE (blocker_id, blocked_id) IN (($1, $2), ($2, $1)))`,
+		sender.ID,
+		recipientID,
+	).Scan(&blocked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check blocks: %w", err)
+	}
+	if blocked {
+		return nil, ErrCantMessage
+	}
+
+	// Conversations are keyed on the pair of accounts in order, so either can start it.
+	first, second := sender.ID, recipientID
+	if second < first {
+		first, second = second, first
+	}
+	message := &Message{From: sender.Username, Content: content}
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO conversations (first_id, second_id) VALUES ($1, $2)
+		ON CONFLICT (first_id, second_id) DO UPDATE SET updated_at = now()
+		RETURNING id`,
+		first,
+		second,
+	).Scan(&message.Conversation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write conversation: %w", err)
+	}
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO messages (conversation_id, sender_id, sender_name, content) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		message.Conversation,
+		sender.ID,
+		sender.Username,
+		content,
+	).Scan(&message.ID, &message.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write message: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit message: %w", err)
+	}
+	return message, nil
+}
+
+func (store *DataStore) GetConversations(ctx context.Context, accountID string) ([]*Conversation, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT c.id, COALESCE(a.username, ''), c.updated_at,
+		(SELECT count(*) FROM messages m WHERE m.conversation_id = c.id AND m.sender_id <> $1 AND NOT m.read)
+		FROM conversations c
+		LEFT JOIN accounts_seen a ON a.id = CASE WHEN c.first_id = $1 THEN c.second_id ELSE c.first_id END
+		WHERE $1 IN (c.first_id, c.second_id)
+		ORDER BY c.updated_at DESC`,
+		accountID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := make([]*Conversation, 0)
+	for rows.Next() {
+		conversation := &Conversation{}
+		err := rows.Scan(&conversation.ID, &conversation.With, &conversation.UpdatedAt, &conversation.Unread)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a conversation: %w", err)
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, nil
+}
+
+func (store *DataStore) GetMessages(ctx context.Context, accountID string, conversationID int, before int, limit int) ([]*Message, error) {
+	var exists bool
+	err := store.pgPool.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT FROM conversations WHERE id = $1 AND $2 IN (first_id, second_id))",
+		conversationID,
+		accountID,
+	).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check conversation: %w", err)
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT id, sender_id, sender_name, content, read, created_at FROM messages
+		WHERE conversation_id = $1 AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC LIMIT $3`,
+		conversationID,
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]*Message, 0)
+	var unread []int32
+	for rows.Next() {
+		message := &Message{Conversation: conversationID}
+		var senderID string
+		err := rows.Scan(&message.ID, &senderID, &message.From, &message.Content, &message.Read, &message.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a message: %w", err)
+		}
+		if senderID != accountID && !message.Read {
+			unread = append(unread, int32(message.ID))
+		}
+		messages = append(messages, message)
+	}
+	rows.Close()
+
+	// Messages sent to the user are read once they've been fetched.
+	if len(unread) > 0 {
+		_, err = store.pgPool.Exec(ctx, "UPDATE messages SET read = true WHERE id = ANY($1)", unread)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mark messages read: %w", err)
+		}
+	}
+	return messages, nil
+}
+
+func (store *DataStore) CountUnreadMessages(ctx context.Context, accountID string) (int, error) {
+	var unread int
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT count(*) FROM messages m JOIN conversations c ON c.id = m.conversation_id
+		WHERE $1 IN (c.first_id, c.second_id) AND m.sender_id <> $1 AND NOT m.read`,
+		accountID,
+	).Scan(&unread)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	return unread, nil
+}
+
+func (store *DataStore) GetBlocked(ctx context.Context, accountID string) ([]string, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT COALESCE(a.username, '') FROM blocks b LEFT JOIN accounts_seen a ON a.id = b.blocked_id
+		WHERE b.blocker_id = $1 ORDER BY b.created_at`,
+		accountID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocks: %w", err)
+	}
+	defer rows.Close()
+
+	blocked := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, fmt.Errorf("failed to parse a block: %w", err)
+		}
+		blocked = append(blocked, username)
+	}
+	return blocked, nil
+}
+
+func (store *DataStore) SetBlocked(ctx context.Context, accountID string, username string, blocked bool) error {
+	blockedID, err := accountByUsername(ctx, store.pgPool, username)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrAmbiguousUsername) {
+			return err
+		}
+		return fmt.Errorf("failed to find account to block: %w", err)
+	}
+
+	if blocked {
+		_, err = store.pgPool.Exec(
+			ctx,
+			"INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			accountID,
+			blockedID,
+		)
+	} else {
+		_, err = store.pgPool.Exec(ctx, "DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2", accountID, blockedID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set block: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+var ErrUnknownAction = errors.New("unknown moderation action")
+
+// ModerationAction is how a moderator resolves a queued post.
+type ModerationAction string
+
+const (
+	// ActionApprove releases the post from quarantine and dismisses its reports.
+	ActionApprove ModerationAction = "approve"
+	// ActionDelete removes the post.
+	ActionDelete ModerationAction = "delete"
+	// ActionBan bans the poster's IP and removes the post.
+	ActionBan ModerationAction = "ban"
+)
+
+// Resolution resolves a queued post.
+type Resolution struct {
+	Action ModerationAction
+	// Reason recorded against a ban.
+	Reason string
+	// How long a ban lasts, zero bans permanently.
+	BanFor time.Duration
+}
+
+// QueueItem is a post awaiting moderation, because it's been reported or quarantined.
+type QueueItem struct {
+	Post *Post `json:"post"`
+	// Thread the post is on, its own number if it's a thread.
+	Thread      int    `json:"thread"`
+	PosterHash  string `json:"posterHash"`
+	Quarantined bool   `json:"quarantined"`
+	// Reasons given by each open report, oldest first.
+	Reports    []string   `json:"reports"`
+	ReportedAt *time.Time `json:"reportedAt,omitempty"`
+	// Reported by an abuse complaint or takedown notice, which are dealt with first.
+	Priority bool `json:"priority,omitempty"`
+	// Staff notes on the post or its poster, oldest first.
+	Notes []*Note `json:"notes"`
+}
+
+func (store *DataStore) WriteReport(ctx context.Context, categoryTag string, num int, reason string, reporterHash string) error {
+	_, err := store.pgPool.Exec(
+		ctx,
+		"INSERT INTO reports (cat, num, reason, reporter_hash) VALUES ($1, $2, $3, $4)",
+		categoryTag,
+		num,
+		reason,
+		reporterHash,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetModerationQueue(ctx context.Context, limit int) ([]*QueueItem, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT p.num, p.cat, p.parent, p.subject, p.content, p.original_content, p.username, p.created_at, p.ip_hash, p.quarantined,
+			COALESCE(array_agg(r.reason ORDER BY r.created_at) FILTER (WHERE r.id IS NOT NULL), '{}'),
+			MIN(r.created_at), COALESCE(bool_or(r.priority), false)
+		FROM posts p
+		LEFT JOIN reports r ON r.cat = p.cat AND r.num = p.num AND r.resolved_at IS NULL
+		WHERE p.quarantined OR r.id IS NOT NULL
+		GROUP BY p.num, p.cat
+		ORDER BY COALESCE(bool_or(r.priority), false) DESC, COALESCE(MIN(r.created_at), p.created_at) ASC
+		LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query moderation queue: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*QueueItem = make([]*QueueItem, 0)
+	var refs []PostRef
+	var posterHashes []string
+	for rows.Next() {
+		post := &Post{}
+		item := &QueueItem{Post: post}
+		err := rows.Scan(
+			&post.Num, &post.Cat, &post.Parent, &post.Subject, &post.Content, &post.Original, &post.Username, &post.CreatedAt,
+			&item.PosterHash, &item.Quarantined, &item.Reports, &item.ReportedAt, &item.Priority,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a queued post: %w", err)
+		}
+		post.derive()
+		item.Thread = post.Parent
+		if !post.IsReply() {
+			item.Thread = post.Num
+		}
+		items = append(items, item)
+		refs = append(refs, PostRef{Cat: post.Cat, Num: post.Num})
+		posterHashes = append(posterHashes, item.PosterHash)
+	}
+	rows.Close()
+
+	notes, err := store.getNotes(ctx, refs, posterHashes)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		item.Notes = notesOn(notes, refs[i], item.PosterHash)
+	}
+	return items, nil
+}
+
+func (store *DataStore) ResolveQueueItem(ctx context.Context, categoryTag string, num int, resolution Resolution) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin resolving queued post: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		"UPDATE reports SET resolved_at = CURRENT_TIMESTAMP, resolution = $3 WHERE cat = $1 AND num = $2 AND resolved_at IS NULL",
+		categoryTag,
+		num,
+		string(resolution.Action),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to resolve reports: %w", err)
+	}
+
+	switch resolution.Action {
+	case ActionApprove:
+		res, err := tx.Exec(ctx, "UPDATE posts SET quarantined = false WHERE cat = $1 AND num = $2", categoryTag, num)
+		if err != nil {
+			return fmt.Errorf("failed to approve post: %w", err)
+		}
+		if res.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+	case ActionBan:
+		err := banPoster(ctx, tx, categoryTag, num, resolution.Reason, resolution.BanFor)
+		if err != nil {
+			return err
+		}
+		fallthrough
+	case ActionDelete:
+		err := logAction(ctx, tx, categoryTag, num, resolution.Action, 0)
+		if err != nil {
+			return err
+		}
+		err = recordDeletion(ctx, tx, categoryTag, num)
+		if err != nil {
+			return err
+		}
+		removed, err := store.removePost(ctx, tx, categoryTag, num)
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return ErrNotFound
+		}
+	default:
+		return ErrUnknownAction
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit resolving queued post: %w", err)
+	}
+	return nil
+}
+
+// banExpiry returns when a ban starting now expires, nil if it's permanent.
+func banExpiry(banFor time.Duration) *time.Time {
+	if banFor <= 0 {
+		return nil
+	}
+	expires := time.Now().Add(banFor)
+	return &expires
+}
+
+// banPoster bans the IP a post was made from.
+func banPoster(ctx context.Context, tx pgx.Tx, categoryTag string, num int, reason string, banFor time.Duration) error {
+	res, err := tx.Exec(
+		ctx,
+		"INSERT INTO bans (poster_hash, reason, expires_at) SELECT ip_hash, $3, $4 FROM posts WHERE cat = $1 AND num = $2",
+		categoryTag,
+		num,
+		reason,
+		banExpiry(banFor),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to ban poster: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *DataStore) IsBanned(ctx context.Context, posterHashes ...string) (bool, error) {
+	var banned bool
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT EXISTS (
+			SELECT FROM bans WHERE poster_hash = ANY($1) AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		)`,
+		posterHashes,
+	).Scan(&banned)
+	if err != nil {
+		return false, fmt.Errorf("failed to check bans: %w", err)
+	}
+	return banned, nil
+}
+
+// PostRef identifies a post.
+type PostRef struct {
+	Cat string `json:"cat"`
+	Num int    `json:"num"`
+}
+
+// BulkActions is a batch of moderation actions, such as cleaning up after a raid.
+type BulkActions struct {
+	DeletePosts []PostRef
+	// Poster hashes of IPs or emails to ban.
+	BanPosters  []string
+	LockThreads []PostRef
+	// Reason recorded against every ban.
+	BanReason string
+	// How long bans last, zero bans permanently.
+	BanFor time.Duration
+}
+
+// ActionResult is the outcome of one action in a batch.
+type ActionResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// BulkResults holds the result of each action in a batch, in the order they were given.
+type BulkResults struct {
+	DeletePosts []ActionResult
+	BanPosters  []ActionResult
+	LockThreads []ActionResult
+}
+
+/*
+applyAction runs fn in a savepoint, so a failing action is rolled back
+without undoing the rest of the batch.
+*/
+func applyAction(ctx context.Context, tx pgx.Tx, fn func(tx pgx.Tx) error) ActionResult {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return ActionResult{Error: err.Error()}
+	}
+	defer savepoint.Rollback(ctx)
+
+	err = fn(savepoint)
+	if err == nil {
+		err = savepoint.Commit(ctx)
+	}
+	if err != nil {
+		return ActionResult{Error: err.Error()}
+	}
+	return ActionResult{OK: true}
+}
+
+func (store *DataStore) ApplyBulkActions(ctx context.Context, actions BulkActions) (*BulkResults, error) {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin bulk actions: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	expiresAt := banExpiry(actions.BanFor)
+	results := &BulkResults{}
+	// Ban and lock before deleting, deleting a thread first would fail locking it.
+	for _, posterHash := range actions.BanPosters {
+		results.BanPosters = append(results.BanPosters, applyAction(ctx, tx, func(tx pgx.Tx) error {
+			_, err := tx.Exec(
+				ctx,
+				"INSERT INTO bans (poster_hash, reason, expires_at) VALUES ($1, $2, $3)",
+				posterHash,
+				actions.BanReason,
+				expiresAt,
+			)
+			return err
+		}))
+	}
+	for _, thread := range actions.LockThreads {
+		results.LockThreads = append(results.LockThreads, applyAction(ctx, tx, func(tx pgx.Tx) error {
+			err := lockThread(ctx, tx, thread.Cat, thread.Num)
+			if err != nil {
+				return err
+			}
+			return logAction(ctx, tx, thread.Cat, thread.Num, ActionLock, 0)
+		}))
+	}
+	for _, post := range actions.DeletePosts {
+		results.DeletePosts = append(results.DeletePosts, applyAction(ctx, tx, func(tx pgx.Tx) error {
+			err := logAction(ctx, tx, post.Cat, post.Num, ActionDelete, 0)
+			if err != nil {
+				return err
+			}
+			err = recordDeletion(ctx, tx, post.Cat, post.Num)
+			if err != nil {
+				return err
+			}
+			removed, err := store.removePost(ctx, tx, post.Cat, post.Num)
+			if err == nil && removed == 0 {
+				return ErrNotFound
+			}
+			return err
+		}))
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit bulk actions: %w", err)
+	}
+	return results, nil
+}
+
+// Reason given to threads locked for going without replies.
+const LockedInactive = "inactive"
+
+func (store *DataStore) LockInactiveThreads(ctx context.Context) (int64, error) {
+	res, err := store.pgPool.Exec(
+		ctx,
+		`UPDATE posts p SET locked = true, locked_reason = $1
+		FROM thread_catalog t JOIN cats c ON c.tag = t.cat
+		WHERE p.cat = t.cat AND p.num = t.num AND NOT p.locked AND c.lock_inactive_days > 0
+		AND t.bumped_at < CURRENT_TIMESTAMP - make_interval(days => c.lock_inactive_days)`,
+		LockedInactive,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock inactive threads: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
+
+// lockThread stops replies to a thread.
+func lockThread(ctx context.Context, tx pgx.Tx, categoryTag string, num int) error {
+	res, err := tx.Exec(ctx, "UPDATE posts SET locked = true WHERE cat = $1 AND num = $2 AND parent = 0", categoryTag, num)
+	if err != nil {
+		return fmt.Errorf("failed to lock thread: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ActionLock stops replies to a thread.
+const ActionLock ModerationAction = "lock"
+
+/*
+ModLogEntry is a moderation action as shown publicly. Who took the action, the poster,
+the post's content and any reason given by a moderator are never included.
+*/
+type ModLogEntry struct {
+	Num int `json:"num"`
+	// Thread the post was on, its own number if it was a thread.
+	Thread int              `json:"thread"`
+	Action ModerationAction `json:"action"`
+	// Autoban rule that took the action, zero if it was taken by a moderator.
+	Rule      int       `json:"rule,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// logAction records a moderation action on a post to the moderation log, before the post's removed.
+func logAction(ctx context.Context, tx pgx.Tx, categoryTag string, num int, action ModerationAction, ruleID int) error {
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO mod_log (cat, num, thread, action, rule_id)
+		SELECT cat, num, CASE WHEN parent = 0 THEN num ELSE parent END, $3, $4 FROM posts WHERE cat = $1 AND num = $2`,
+		categoryTag,
+		num,
+		string(action),
+		ruleID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to log moderation action: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetModLog(ctx context.Context, categoryTag string, limit int) ([]*ModLogEntry, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT num, thread, action, rule_id, created_at FROM mod_log WHERE cat = $1 ORDER BY created_at DESC LIMIT $2",
+		categoryTag,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query moderation log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*ModLogEntry = make([]*ModLogEntry, 0)
+	for rows.Next() {
+		entry := &ModLogEntry{}
+		err := rows.Scan(&entry.Num, &entry.Thread, &entry.Action, &entry.Rule, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a moderation log entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// Note is a private staff note on either a post or a poster.
+type Note struct {
+	ID int `json:"id"`
+	// Post the note is on, if it's on a post.
+	Cat string `json:"cat,omitempty"`
+	Num int    `json:"num,omitempty"`
+	// Poster the note is on, if it's on a poster.
+	PosterHash string `json:"posterHash,omitempty"`
+	Content    string `json:"content"`
+	// Email of the staff member who wrote the note.
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// nullable returns nil for zero values, for writing optional columns.
+func nullable(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if len(v) == 0 {
+			return nil
+		}
+	case int:
+		if v == 0 {
+			return nil
+		}
+	}
+	return value
+}
+
+func (store *DataStore) WriteNote(ctx context.Context, note *Note) (int, error) {
+	var id int
+	err := store.pgPool.QueryRow(
+		ctx,
+		"INSERT INTO notes (cat, num, poster_hash, content, author) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		nullable(note.Cat),
+		nullable(note.Num),
+		nullable(note.PosterHash),
+		note.Content,
+		note.Author,
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to write note: %w", err)
+	}
+	return id, nil
+}
+
+func (store *DataStore) RemoveNote(ctx context.Context, id int) error {
+	res, err := store.pgPool.Exec(ctx, "DELETE FROM notes WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to remove note: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// getNotes returns every note on the given posts or posters, oldest first.
+func (store *DataStore) getNotes(ctx context.Context, posts []PostRef, posterHashes []string) ([]*Note, error) {
+	cats := make([]string, len(posts))
+	nums := make([]int32, len(posts))
+	for i, post := range posts {
+		cats[i], nums[i] = post.Cat, int32(post.Num)
+	}
+
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT id, COALESCE(cat, ''), COALESCE(num, 0), COALESCE(poster_hash, ''), content, author, created_at FROM notes
+		WHERE poster_hash = ANY($1) OR (cat, num) IN (SELECT * FROM unnest($2::text[], $3::integer[]))
+		ORDER BY created_at ASC`,
+		posterHashes,
+		cats,
+		nums,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []*Note
+	for rows.Next() {
+		note := &Note{}
+		err := rows.Scan(&note.ID, &note.Cat, &note.Num, &note.PosterHash, &note.Content, &note.Author, &note.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a note: %w", err)
+		}
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
+
+// notesOn filters notes down to those on the given post or poster.
+func notesOn(notes []*Note, post PostRef, posterHash string) []*Note {
+	filtered := make([]*Note, 0)
+	for _, note := range notes {
+		if (note.Cat == post.Cat && note.Num == post.Num) || (len(posterHash) > 0 && note.PosterHash == posterHash) {
+			filtered = append(filtered, note)
+		}
+	}
+	return filtered
+}
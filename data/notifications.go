@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// NotificationMention is the kind of notification sent to users mentioned in a post.
+const NotificationMention = "mention"
+
+// Notification tells a user about something on the board that concerns them.
+type Notification struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+	// Post the notification is about.
+	Cat    string `json:"cat"`
+	Num    int    `json:"num"`
+	Parent int    `json:"parent,omitempty"`
+	// Name the post was made under.
+	From      string    `json:"from"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (store *DataStore) WriteMentions(ctx context.Context, post *Post, authorID string, usernames []string) (int, error) {
+	// Usernames are matched case insensitively, against the account last seen with each.
+	res, err := store.pgPool.Exec(
+		ctx,
+		`INSERT INTO notifications (account_id, kind, cat, num, parent, from_name)
+		SELECT DISTINCT ON (lower(a.username)) a.id, $1, $2, $3, $4, $5 FROM accounts_seen a
+		LEFT JOIN preferences p ON p.id = a.id
+		WHERE lower(a.username) = ANY(SELECT lower(u) FROM unnest($6::text[]) u)
+		AND a.id <> $7 AND NOT COALESCE(p.mute_mentions, false)
+		ORDER BY lower(a.username), a.first_seen DESC`,
+		NotificationMention,
+		post.Cat,
+		post.Num,
+		post.Parent,
+		post.Username,
+		usernames,
+		authorID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write mentions: %w", err)
+	}
+	return int(res.RowsAffected()), nil
+}
+
+func (store *DataStore) GetNotifications(ctx context.Context, accountID string, before int, limit int) ([]*Notification, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT id, kind, cat, num, parent, from_name, read, created_at FROM notifications
+		WHERE account_id = $1 AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC LIMIT $3`,
+		accountID,
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := make([]*Notification, 0)
+	var unread []int32
+	for rows.Next() {
+		n := &Notification{}
+		err := rows.Scan(&n.ID, &n.Kind, &n.Cat, &n.Num, &n.Parent, &n.From, &n.Read, &n.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a notification: %w", err)
+		}
+		if !n.Read {
+			unread = append(unread, int32(n.ID))
+		}
+		notifications = append(notifications, n)
+	}
+	rows.Close()
+
+	// Notifications are read once they've been fetched.
+	if len(unread) > 0 {
+		_, err = store.pgPool.Exec(ctx, "UPDATE notifications SET read = true WHERE id = ANY($1)", unread)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mark notifications read: %w", err)
+		}
+	}
+	return notifications, nil
+}
+
+func (store *DataStore) CountUnreadNotifications(ctx context.Context, accountID string) (int, error) {
+	var unread int
+	err := store.pgPool.QueryRow(
+		ctx,
+		"SELECT count(*) FROM notifications WHERE account_id = $1 AND NOT read",
+		accountID,
+	).Scan(&unread)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	return unread, nil
+}
@@ -0,0 +1,79 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Page is a static page such as the FAQ, with a markdown body.
+type Page struct {
+	Slug      string    `json:"slug"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (store *DataStore) GetPages(ctx context.Context) ([]*Page, error) {
+	rows, err := store.pgPool.Query(ctx, "SELECT slug, title, updated_at FROM pages ORDER BY slug ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pages: %w", err)
+	}
+	defer rows.Close()
+
+	var pages []*Page = make([]*Page, 0)
+	for rows.Next() {
+		page := &Page{}
+		err := rows.Scan(&page.Slug, &page.Title, &page.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a page: %w", err)
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+func (store *DataStore) GetPage(ctx context.Context, slug string) (*Page, error) {
+	page := &Page{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		"SELECT slug, title, body, updated_at FROM pages WHERE slug = $1",
+		slug,
+	).Scan(&page.Slug, &page.Title, &page.Body, &page.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get page: %w", err)
+	}
+	return page, nil
+}
+
+func (store *DataStore) WritePage(ctx context.Context, page *Page) error {
+	_, err := store.pgPool.Exec(
+		ctx,
+		`INSERT INTO pages (slug, title, body) VALUES ($1, $2, $3)
+		ON CONFLICT (slug) DO UPDATE SET title = $2, body = $3, updated_at = CURRENT_TIMESTAMP`,
+		page.Slug,
+		page.Title,
+		page.Body,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write page: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) RemovePage(ctx context.Context, slug string) error {
+	res, err := store.pgPool.Exec(ctx, "DELETE FROM pages WHERE slug = $1", slug)
+	if err != nil {
+		return fmt.Errorf("failed to remove page: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
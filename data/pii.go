@@ -0,0 +1,291 @@
+package data
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrPIIDisabled = errors.New("PII encryption isn't enabled")
+
+// Sealed values are "pii:<key id>:<base64 nonce and ciphertext>", anything else is plaintext from before encryption.
+const piiPrefix = "pii:"
+
+// PIIOptions configure encrypting posters' emails and IPs in the database.
+type PIIOptions struct {
+	// AES-256 keys, the first encrypting new values. The rest only decrypt values sealed before a rotation.
+	Keys [][]byte
+}
+
+type piiKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+/*
+piiCipher encrypts emails and IPs before they're stored. Lookups go through the PosterHash columns
+written alongside them, since sealing the same value twice never gives the same ciphertext.
+A nil piiCipher stores values as they are.
+*/
+type piiCipher struct {
+	keys []*piiKey
+}
+
+func newPIICipher(opts PIIOptions) (*piiCipher, error) {
+	if len(opts.Keys) == 0 {
+		return nil, errors.New("no PII keys given")
+	}
+	c := &piiCipher{}
+	for i, key := range opts.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PII key %d: %w", i+1, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		// Identified by a hash of the key, so the keys can be listed in any order.
+		sum := sha256.Sum256(key)
+		c.keys = append(c.keys, &piiKey{id: hex.EncodeToString(sum[:4]), aead: aead})
+	}
+	return c, nil
+}
+
+// current is the prefix of values sealed with the current key.
+func (c *piiCipher) current() string {
+	return piiPrefix + c.keys[0].id + ":"
+}
+
+// seal encrypts a value with the current key. Empty values are left empty.
+func (c *piiCipher) seal(value string) (string, error) {
+	if c == nil || len(value) == 0 {
+		return value, nil
+	}
+	key := c.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(value), nil)
+	return c.current() + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a sealed value with whichever key sealed it. Plaintext values are returned as they are.
+func (c *piiCipher) open(value string) (string, error) {
+	if !strings.HasPrefix(value, piiPrefix) {
+		return value, nil
+	}
+	if c == nil {
+		return "", ErrPIIDisabled
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, piiPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("malformed sealed PII")
+	}
+	for _, key := range c.keys {
+		if key.id != parts[0] {
+			continue
+		}
+		sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+		if err != nil || len(sealed) < key.aead.NonceSize() {
+			return "", errors.New("malformed sealed PII")
+		}
+		nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+		plain, err := key.aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt PII: %w", err)
+		}
+		return string(plain), nil
+	}
+	return "", fmt.Errorf("PII sealed with unknown key %s", parts[0])
+}
+
+// EnablePIIEncryption makes the store encrypt emails and IPs it writes, and decrypt those it reads.
+func (store *DataStore) EnablePIIEncryption(opts PIIOptions) error {
+	c, err := newPIICipher(opts)
+	if err != nil {
+		return err
+	}
+	store.pii = c
+	return nil
+}
+
+// PIIAccess is an audit record of staff viewing emails or IPs.
+type PIIAccess struct {
+	ID int `json:"id"`
+	// Email of the staff member who viewed them.
+	Staff  string    `json:"staff"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+func (store *DataStore) LogPIIAccess(ctx context.Context, access *PIIAccess) error {
+	err := store.pgPool.QueryRow(
+		ctx,
+		"INSERT INTO pii_access (staff, method, path, reason) VALUES ($1, $2, $3, $4) RETURNING id, at",
+		access.Staff,
+		access.Method,
+		access.Path,
+		access.Reason,
+	).Scan(&access.ID, &access.At)
+	if err != nil {
+		return fmt.Errorf("failed to log PII access: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetPIIAccesses(ctx context.Context, limit int) ([]*PIIAccess, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT id, staff, method, path, reason, at FROM pii_access ORDER BY id DESC LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query PII accesses: %w", err)
+	}
+	defer rows.Close()
+
+	accesses := make([]*PIIAccess, 0)
+	for rows.Next() {
+		access := &PIIAccess{}
+		err := rows.Scan(&access.ID, &access.Staff, &access.Method, &access.Path, &access.Reason, &access.At)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a PII access: %w", err)
+		}
+		accesses = append(accesses, access)
+	}
+	return accesses, rows.Err()
+}
+
+// piiColumns are the columns of a table holding emails or IPs, and the columns its rows are found by.
+type piiColumns struct {
+	table   string
+	keys    []string
+	columns []string
+}
+
+var piiTables = []piiColumns{
+	{table: "posts", keys: []string{"cat", "num"}, columns: []string{"email", "ip"}},
+	{table: "abuse_complaints", keys: []string{"id"}, columns: []string{"email"}},
+	{table: "impersonations", keys: []string{"id"}, columns: []string{"email"}},
+	{table: "canary_hits", keys: []string{"id"}, columns: []string{"ip"}},
+	{table: "totp", keys: []string{"user_id"}, columns: []string{"secret"}},
+	{table: "sessions", keys: []string{"id"}, columns: []string{"refresh_token"}},
+}
+
+/*
+RotatePIIKeys re-encrypts every email and IP not already sealed with the current key, including plaintext
+written before encryption was enabled, batchSize rows at a time. Returns the rows rewritten by table.
+Once it's finished, old keys can be dropped from the configuration.
+*/
+func (store *DataStore) RotatePIIKeys(ctx context.Context, batchSize int) (map[string]int64, error) {
+	if store.pii == nil {
+		return nil, ErrPIIDisabled
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("batch size must be at least 1, got %d", batchSize)
+	}
+	rotated := make(map[string]int64)
+	for _, table := range piiTables {
+		for {
+			n, err := store.rotatePIIBatch(ctx, table, batchSize)
+			if err != nil {
+				return rotated, err
+			}
+			rotated[table.table] += n
+			if n < int64(batchSize) {
+				break
+			}
+		}
+	}
+	return rotated, nil
+}
+
+// rotatePIIBatch re-encrypts up to limit rows of a table, returning how many it rewrote.
+func (store *DataStore) rotatePIIBatch(ctx context.Context, table piiColumns, limit int) (int64, error) {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin PII rotation: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	stale := make([]string, len(table.columns))
+	for i, column := range table.columns {
+		stale[i] = fmt.Sprintf("(%s <> '' AND %s NOT LIKE $1)", column, column)
+	}
+	rows, err := tx.Query(
+		ctx,
+		fmt.Sprintf(
+			"SELECT %s, %s FROM %s WHERE %s LIMIT $2 FOR UPDATE",
+			strings.Join(table.keys, ", "),
+			strings.Join(table.columns, ", "),
+			table.table,
+			strings.Join(stale, " OR "),
+		),
+		store.pii.current()+"%",
+		limit,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s to rotate: %w", table.table, err)
+	}
+	var batch [][]interface{}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to parse a row of %s to rotate: %w", table.table, err)
+		}
+		batch = append(batch, values)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query %s to rotate: %w", table.table, err)
+	}
+
+	set := make([]string, len(table.columns))
+	for i, column := range table.columns {
+		set[i] = fmt.Sprintf("%s = $%d", column, i+1)
+	}
+	where := make([]string, len(table.keys))
+	for i, key := range table.keys {
+		where[i] = fmt.Sprintf("%s = $%d", key, len(table.columns)+i+1)
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s WHERE %s", table.table, strings.Join(set, ", "), strings.Join(where, " AND "))
+
+	for _, values := range batch {
+		keys, columns := values[:len(table.keys)], values[len(table.keys):]
+		args := make([]interface{}, 0, len(values))
+		for _, column := range columns {
+			value, _ := column.(string)
+			plain, err := store.pii.open(value)
+			if err != nil {
+				return 0, fmt.Errorf("failed to rotate %s: %w", table.table, err)
+			}
+			sealed, err := store.pii.seal(plain)
+			if err != nil {
+				return 0, fmt.Errorf("failed to rotate %s: %w", table.table, err)
+			}
+			args = append(args, sealed)
+		}
+		args = append(args, keys...)
+		if _, err := tx.Exec(ctx, update, args...); err != nil {
+			return 0, fmt.Errorf("failed to rewrite %s: %w", table.table, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit PII rotation: %w", err)
+	}
+	return int64(len(batch)), nil
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPIICipher(t *testing.T) {
+	if _, err := newPIICipher(PIIOptions{Keys: [][]byte{[]byte("short")}}); err == nil {
+		t.Error("expected error on invalid key")
+	}
+
+	oldKey, newKey := bytes.Repeat([]byte("o"), 32), bytes.Repeat([]byte("n"), 32)
+	old, err := newPIICipher(PIIOptions{Keys: [][]byte{oldKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := newPIICipher(PIIOptions{Keys: [][]byte{newKey, oldKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := old.seal("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "a@example.com") || !strings.HasPrefix(sealed, old.current()) {
+		t.Errorf("expected the email sealed with the current key, got %q", sealed)
+	}
+	again, _ := old.seal("a@example.com")
+	if again == sealed {
+		t.Error("expected sealing to be randomized")
+	}
+
+	// Old keys still open what they sealed, and plaintext from before encryption reads as it is.
+	for value, expected := range map[string]string{sealed: "a@example.com", "127.0.0.1": "127.0.0.1", "": ""} {
+		plain, err := rotated.open(value)
+		if err != nil || plain != expected {
+			t.Errorf("expected %q opened to %q, got %q %v", value, expected, plain, err)
+		}
+	}
+	if empty, _ := rotated.seal(""); empty != "" {
+		t.Errorf("expected empty values left empty, got %q", empty)
+	}
+
+	newer, _ := rotated.seal("a@example.com")
+	if _, err := old.open(newer); err == nil {
+		t.Error("expected error opening a value sealed with an unknown key")
+	}
+	var disabled *piiCipher
+	if _, err := disabled.open(sealed); err != ErrPIIDisabled {
+		t.Errorf("expected ErrPIIDisabled opening sealed values without keys, got: %v", err)
+	}
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if _, err := old.open(tampered); err == nil {
+		t.Error("expected error opening a tampered value")
+	}
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// PostPolicy is what a category accepts in new posts.
+type PostPolicy struct {
+	// Most links a post can have, zero for no limit.
+	MaxLinks int `json:"maxLinks"`
+	// Reject posts that are nothing but links.
+	RejectLinkOnly bool `json:"rejectLinkOnly"`
+	// Threads must have an attachment, replies are unaffected.
+	RequireAttachment bool `json:"requireAttachment"`
+	// Posts with an attachment can leave their content empty.
+	AllowEmptyContentWithAttachment bool `json:"allowEmptyContentWithAttachment"`
+	// Words masked in new posts, set through SetCategoryMasks.
+	MaskedWords []string     `json:"maskedWords"`
+	Uploads     UploadPolicy `json:"uploads"`
+	// Whose choice it is to show posters' usernames, PostAs enforces it.
+	Usernames UsernamePolicy `json:"usernames"`
+}
+
+// UsernamePolicy is whether a category's posts are shown under their posters' usernames.
+type UsernamePolicy string
+
+const (
+	// Posters choose with their preferences, the default.
+	UsernamesChoice UsernamePolicy = "choice"
+	// Posts are always shown under the poster's username or display name.
+	UsernamesAlways UsernamePolicy = "always"
+	// Posts are always shown as Anonymous.
+	UsernamesAnonymous UsernamePolicy = "anonymous"
+)
+
+// Valid reports whether the policy is one of the three.
+func (policy UsernamePolicy) Valid() bool {
+	return policy == UsernamesChoice || policy == UsernamesAlways || policy == UsernamesAnonymous
+}
+
+// UploadPolicy limits what can be attached to a category's posts, zero values leave it to the server's limits.
+type UploadPolicy struct {
+	// Largest attachment in bytes.
+	MaxFileBytes int64 `json:"maxFileBytes"`
+	// Sniffed MIME types that can be attached, anything the server accepts if empty.
+	AllowedTypes []string `json:"allowedTypes"`
+	// Most attachments on a single post.
+	MaxFiles int `json:"maxFiles"`
+	// Bytes the category's attachments can take up in total, zero for no quota.
+	QuotaBytes int64 `json:"quotaBytes"`
+	// Deletes the category's oldest attachments to make room instead of refusing new ones.
+	PruneOldest bool `json:"pruneOldest"`
+}
+
+// Allows reports whether a file of the type and size can be attached under the policy.
+func (policy *UploadPolicy) Allows(mime string, size int64) bool {
+	if policy.MaxFileBytes > 0 && size > policy.MaxFileBytes {
+		return false
+	}
+	if len(policy.AllowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range policy.AllowedTypes {
+		if allowed == mime {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *DataStore) GetPostPolicy(ctx context.Context, categoryTag string) (*PostPolicy, error) {
+	policy := &PostPolicy{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT max_links, reject_link_only, require_attachment, allow_empty_content, masked_words,
+		max_file_bytes, allowed_types, max_files, storage_quota_bytes, prune_oldest, username_policy
+		FROM cats WHERE tag = $1`,
+		categoryTag,
+	).Scan(
+		&policy.MaxLinks, &policy.RejectLinkOnly, &policy.RequireAttachment,
+		&policy.AllowEmptyContentWithAttachment, &policy.MaskedWords,
+		&policy.Uploads.MaxFileBytes, &policy.Uploads.AllowedTypes, &policy.Uploads.MaxFiles,
+		&policy.Uploads.QuotaBytes, &policy.Uploads.PruneOldest, &policy.Usernames,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query post policy: %w", err)
+	}
+	return policy, nil
+}
+
+func (store *DataStore) SetPostPolicy(ctx context.Context, categoryTag string, policy *PostPolicy) error {
+	allowedTypes := policy.Uploads.AllowedTypes
+	if allowedTypes == nil {
+		allowedTypes = make([]string, 0)
+	}
+	usernames := policy.Usernames
+	if len(usernames) == 0 {
+		usernames = UsernamesChoice
+	}
+	res, err := store.pgPool.Exec(
+		ctx,
+		`UPDATE cats SET max_links = $2, reject_link_only = $3, require_attachment = $4, allow_empty_content = $5,
+		max_file_bytes = $6, allowed_types = $7, max_files = $8, storage_quota_bytes = $9, prune_oldest = $10,
+		username_policy = $11, version = version + 1, updated_at = now() WHERE tag = $1`,
+		categoryTag,
+		policy.MaxLinks,
+		policy.RejectLinkOnly,
+		policy.RequireAttachment,
+		policy.AllowEmptyContentWithAttachment,
+		policy.Uploads.MaxFileBytes,
+		allowedTypes,
+		policy.Uploads.MaxFiles,
+		policy.Uploads.QuotaBytes,
+		policy.Uploads.PruneOldest,
+		usernames,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set post policy: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
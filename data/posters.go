@@ -0,0 +1,334 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// posterHashKey is the secret poster hashes are keyed with, so they can't be reversed by hashing every IP.
+var posterHashKey []byte
+
+// SetPosterHashKey sets the secret PosterHash is keyed with. It's set once at startup, before the store's used.
+func SetPosterHashKey(key []byte) {
+	posterHashKey = key
+}
+
+/*
+PosterHash returns the hash identifying a poster by their IP or email,
+so moderators can track posters without seeing either.
+Matches the ip_hash and email_hash columns written alongside posts, which stay searchable when PII is encrypted.
+*/
+func PosterHash(value string) string {
+	mac := hmac.New(sha256.New, posterHashKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// rehashSources are the columns poster hashes are made from, and the columns of their hashes.
+var rehashSources = []struct{ table, source, hash string }{
+	{"posts", "ip", "ip_hash"},
+	{"posts", "email", "email_hash"},
+	{"canary_hits", "ip", "ip_hash"},
+}
+
+// rehashedColumns are every column holding poster hashes. Posts from before authors had IDs are owned by their email hash.
+var rehashedColumns = []struct{ table, column string }{
+	{"posts", "ip_hash"},
+	{"posts", "email_hash"},
+	{"posts", "author_id"},
+	{"retained_posts", "ip_hash"},
+	{"retained_posts", "email_hash"},
+	{"canary_hits", "ip_hash"},
+	{"bans", "poster_hash"},
+	{"reports", "reporter_hash"},
+	{"mod_deletions", "poster_hash"},
+	{"rule_actions", "poster_hash"},
+	{"notes", "poster_hash"},
+	{"rules_acceptances", "poster_hash"},
+	{"abuse_complaints", "reporter_hash"},
+	{"sessions", "ip_hash"},
+}
+
+// posterHashKeyID identifies the key poster hashes are made with, without giving it away.
+func posterHashKeyID() string {
+	return PosterHash("poster hash key")[:16]
+}
+
+/*
+rehashPosters re-hashes every stored poster hash with the current key, if they were made with another key or
+before hashes were keyed. Each old hash is replaced by hashing the IP or email it was made from again,
+so hashes with nothing left to make them from, like bans of IPs that never posted, are left as they were.
+*/
+func (store *DataStore) rehashPosters(ctx context.Context) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin re-hashing posters: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var current string
+	err = tx.QueryRow(ctx, "SELECT id FROM poster_hash_key FOR UPDATE").Scan(&current)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to get poster hash key: %w", err)
+	}
+	if current == posterHashKeyID() {
+		return nil
+	}
+
+	// Old hashes map to new ones one to one, so only each poster's hashes are held, not each row's.
+	rehashed := make(map[string]string)
+	for _, source := range rehashSources {
+		rows, err := tx.Query(
+			ctx,
+			fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s <> ''", source.source, source.hash, source.table, source.hash),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to query %s to re-hash: %w", source.table, err)
+		}
+		for rows.Next() {
+			var value, hash string
+			if err := rows.Scan(&value, &hash); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to parse a row of %s to re-hash: %w", source.table, err)
+			}
+			if _, ok := rehashed[hash]; ok {
+				continue
+			}
+			plain, err := store.pii.open(value)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to re-hash %s: %w", source.table, err)
+			}
+			// Rows written since the key was set already have the new hash, and map to themselves.
+			rehashed[hash] = PosterHash(plain)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to query %s to re-hash: %w", source.table, err)
+		}
+	}
+
+	// Deleted posters can only be re-hashed out of retention.
+	if store.retention != nil {
+		rows, err := tx.Query(
+			ctx,
+			"SELECT payload, COALESCE(ip_hash, ''), COALESCE(email_hash, '') FROM retained_posts WHERE ip_hash <> '' OR email_hash <> ''",
+		)
+		if err != nil {
+			return fmt.Errorf("failed to query retained posts to re-hash: %w", err)
+		}
+		for rows.Next() {
+			var sealed []byte
+			var ipHash, emailHash string
+			if err := rows.Scan(&sealed, &ipHash, &emailHash); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to parse a retained post to re-hash: %w", err)
+			}
+			post, err := store.retention.open(sealed)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if len(ipHash) > 0 {
+				rehashed[ipHash] = PosterHash(post.IP)
+			}
+			if len(emailHash) > 0 {
+				rehashed[emailHash] = PosterHash(post.Email)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to query retained posts to re-hash: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, "CREATE TEMPORARY TABLE rehashed (old text PRIMARY KEY, new text NOT NULL) ON COMMIT DROP")
+	if err != nil {
+		return fmt.Errorf("failed to create re-hash table: %w", err)
+	}
+	pairs := make([][]interface{}, 0, len(rehashed))
+	for old, hash := range rehashed {
+		if old != hash {
+			pairs = append(pairs, []interface{}{old, hash})
+		}
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"rehashed"}, []string{"old", "new"}, pgx.CopyFromRows(pairs))
+	if err != nil {
+		return fmt.Errorf("failed to write re-hashes: %w", err)
+	}
+	// Posters who accepted a category's rules again since the key was set have both hashes, keep the newer.
+	_, err = tx.Exec(
+		ctx,
+		`DELETE FROM rules_acceptances a USING rehashed r WHERE a.poster_hash = r.old
+		AND EXISTS (SELECT FROM rules_acceptances b WHERE b.cat = a.cat AND b.poster_hash = r.new)`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to drop re-accepted rules: %w", err)
+	}
+	for _, column := range rehashedColumns {
+		_, err := tx.Exec(
+			ctx,
+			fmt.Sprintf(
+				"UPDATE %[1]s SET %[2]s = r.new FROM rehashed r WHERE %[1]s.%[2]s = r.old",
+				column.table,
+				column.column,
+			),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to re-hash %s.%s: %w", column.table, column.column, err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM poster_hash_key")
+	if err != nil {
+		return fmt.Errorf("failed to clear poster hash key: %w", err)
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO poster_hash_key (id) VALUES ($1)", posterHashKeyID())
+	if err != nil {
+		return fmt.Errorf("failed to record poster hash key: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit re-hashing posters: %w", err)
+	}
+	return nil
+}
+
+// TokenHash returns the hash a token is stored and found by. Tokens are random, so unlike IPs they aren't keyed.
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PosterPost is a post in a poster's history, which may have been deleted.
+type PosterPost struct {
+	Num     int    `json:"num"`
+	Cat     string `json:"cat"`
+	Parent  int    `json:"parent"`
+	Subject string `json:"subject"`
+	Content string `json:"content"`
+	// Content before words were masked.
+	Original  string     `json:"original,omitempty"`
+	Username  string     `json:"username"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Deleted   bool       `json:"deleted"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Staff notes on the post, notes don't outlive deleted posts.
+	Notes []*Note `json:"notes,omitempty"`
+}
+
+/*
+PosterHistory contains every post by a poster across categories, how often they've been banned,
+staff notes on them, and the categories they've accepted the rules of.
+*/
+type PosterHistory struct {
+	Posts         []*PosterPost      `json:"posts"`
+	Bans          int                `json:"bans"`
+	Notes         []*Note            `json:"notes"`
+	AcceptedRules []*RulesAcceptance `json:"acceptedRules"`
+}
+
+func (store *DataStore) GetPosterHistory(ctx context.Context, posterHash string) (*PosterHistory, error) {
+	history := &PosterHistory{Posts: make([]*PosterPost, 0)}
+
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT num, cat, parent, subject, content, original_content, username, created_at FROM posts
+		WHERE ip_hash = $1 OR email_hash = $1`,
+		posterHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query poster's posts: %w", err)
+	}
+	var refs []PostRef
+	for rows.Next() {
+		post := &PosterPost{}
+		err := rows.Scan(
+			&post.Num, &post.Cat, &post.Parent, &post.Subject, &post.Content, &post.Original, &post.Username, &post.CreatedAt,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to parse a poster's post: %w", err)
+		}
+		history.Posts = append(history.Posts, post)
+		refs = append(refs, PostRef{Cat: post.Cat, Num: post.Num})
+	}
+	rows.Close()
+
+	notes, err := store.getNotes(ctx, refs, []string{posterHash})
+	if err != nil {
+		return nil, err
+	}
+	history.Notes = notesOn(notes, PostRef{}, posterHash)
+	for _, post := range history.Posts {
+		post.Notes = notesOn(notes, PostRef{Cat: post.Cat, Num: post.Num}, "")
+	}
+
+	// Deleted posts can only be read back out of retention.
+	if store.retention != nil {
+		deleted, err := store.getRetainedPosterPosts(ctx, posterHash)
+		if err != nil {
+			return nil, err
+		}
+		history.Posts = append(history.Posts, deleted...)
+	}
+	sort.SliceStable(history.Posts, func(i, j int) bool {
+		return history.Posts[i].CreatedAt.After(history.Posts[j].CreatedAt)
+	})
+
+	err = store.pgPool.QueryRow(ctx, "SELECT COUNT(*) FROM bans WHERE poster_hash = $1", posterHash).Scan(&history.Bans)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count poster's bans: %w", err)
+	}
+
+	history.AcceptedRules, err = store.getRulesAcceptances(ctx, posterHash)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (store *DataStore) getRetainedPosterPosts(ctx context.Context, posterHash string) ([]*PosterPost, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT payload, deleted_at FROM retained_posts WHERE ip_hash = $1 OR email_hash = $1",
+		posterHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query poster's retained posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []*PosterPost
+	for rows.Next() {
+		var sealed []byte
+		var deletedAt time.Time
+		err := rows.Scan(&sealed, &deletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a poster's retained post: %w", err)
+		}
+		retained, err := store.retention.open(sealed)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, &PosterPost{
+			Num:       retained.Num,
+			Cat:       retained.Cat,
+			Parent:    retained.Parent,
+			Subject:   retained.Subject,
+			Content:   retained.Content,
+			Username:  retained.Username,
+			CreatedAt: retained.CreatedAt,
+			Deleted:   true,
+			DeletedAt: &deletedAt,
+		})
+	}
+	return posts, nil
+}
@@ -0,0 +1,26 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPosterHash(t *testing.T) {
+	defer SetPosterHashKey(posterHashKey)
+
+	SetPosterHashKey([]byte("first"))
+	first := PosterHash("127.0.0.1")
+	if first != PosterHash("127.0.0.1") {
+		t.Error("expected the same IP to hash the same")
+	}
+	sum := sha256.Sum256([]byte("127.0.0.1"))
+	if first == hex.EncodeToString(sum[:]) {
+		t.Error("expected the hash to be keyed")
+	}
+
+	SetPosterHashKey([]byte("second"))
+	if PosterHash("127.0.0.1") == first {
+		t.Error("expected a different key to give a different hash")
+	}
+}
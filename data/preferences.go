@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Preferences are a user's own settings, kept with their account.
+type Preferences struct {
+	// Shown on the user's posts in place of their username, if set.
+	DisplayName string `json:"displayName"`
+	// Post as Anonymous by default.
+	Anonymous bool `json:"anonymous"`
+	// Hide NSFW attachments instead of showing them behind a placeholder.
+	HideNSFW bool `json:"hideNsfw"`
+	// IANA name of the timezone the user sees times in, the client's own if empty.
+	Timezone string `json:"timezone"`
+	// Don't notify the user when they're mentioned.
+	MuteMentions bool `json:"muteMentions"`
+}
+
+// PreferencesUpdate changes the preferences that are set, leaving the rest as they are.
+type PreferencesUpdate struct {
+	DisplayName  *string `json:"displayName"`
+	Anonymous    *bool   `json:"anonymous"`
+	HideNSFW     *bool   `json:"hideNsfw"`
+	Timezone     *string `json:"timezone"`
+	MuteMentions *bool   `json:"muteMentions"`
+}
+
+// Name posts by users posting anonymously are shown under.
+const AnonymousName = "Anonymous"
+
+/*
+PostAs returns a copy of the identity named as its preferences say its posts should be,
+unless the category's username policy makes the choice for them.
+*/
+func (prefs *Preferences) PostAs(identity *Identity, usernames UsernamePolicy) *Identity {
+	author := *identity
+	anonymous := prefs.Anonymous
+	switch usernames {
+	case UsernamesAlways:
+		anonymous = false
+	case UsernamesAnonymous:
+		anonymous = true
+	}
+	if anonymous {
+		author.Username = AnonymousName
+	} else if len(prefs.DisplayName) > 0 {
+		author.Username = prefs.DisplayName
+	}
+	return &author
+}
+
+func (store *DataStore) GetPreferences(ctx context.Context, accountID string) (*Preferences, error) {
+	prefs := &Preferences{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		"SELECT display_name, anonymous, hide_nsfw, timezone, mute_mentions FROM preferences WHERE id = $1",
+		accountID,
+	).Scan(&prefs.DisplayName, &prefs.Anonymous, &prefs.HideNSFW, &prefs.Timezone, &prefs.MuteMentions)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return prefs, nil
+		}
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+func (store *DataStore) UpdatePreferences(ctx context.Context, accountID string, update *PreferencesUpdate) (*Preferences, error) {
+	prefs := &Preferences{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO preferences (id, display_name, anonymous, hide_nsfw, timezone, mute_mentions)
+		VALUES ($1, COALESCE($2, ''), COALESCE($3, false), COALESCE($4, false), COALESCE($5, ''), COALESCE($6, false))
+		ON CONFLICT (id) DO UPDATE SET
+		display_name = COALESCE($2, preferences.display_name),
+		anonymous = COALESCE($3, preferences.anonymous),
+		hide_nsfw = COALESCE($4, preferences.hide_nsfw),
+		timezone = COALESCE($5, preferences.timezone),
+		mute_mentions = COALESCE($6, preferences.mute_mentions)
+		RETURNING display_name, anonymous, hide_nsfw, timezone, mute_mentions`,
+		accountID,
+		update.DisplayName,
+		update.Anonymous,
+		update.HideNSFW,
+		update.Timezone,
+		update.MuteMentions,
+	).Scan(&prefs.DisplayName, &prefs.Anonymous, &prefs.HideNSFW, &prefs.Timezone, &prefs.MuteMentions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update preferences: %w", err)
+	}
+	return prefs, nil
+}
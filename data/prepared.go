@@ -0,0 +1,45 @@
+package data
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+)
+
+/*
+Hot queries, prepared on every connection the pool opens. They're prepared under their own text, which pgx looks up
+before its statement cache, so they're run the same way as any other query but planned once per connection up front.
+*/
+const (
+	stmtGetCategory = `SELECT slug, name, description, post_count, sort_order, featured, archived, version, updated_at,
+		max_file_bytes, allowed_types, max_files, storage_quota_bytes, prune_oldest, lock_inactive_days, username_policy
+		FROM cats WHERE tag = $1`
+
+	// Question threads pin their accepted answer under the thread.
+	stmtGetThreadBatch = `SELECT num, cat, content, subject, parent, username, created_at, locked, locked_reason, capcode,
+		best_answer, CASE WHEN parent = 0 THEN thread_type ELSE '' END, thread_type = 'question' AND best_answer != 0, nonce
+		FROM posts WHERE cat = $1 AND (num = $2 or parent = $2) AND NOT quarantined
+		ORDER BY num = $2 DESC,
+		COALESCE(num = (SELECT best_answer FROM posts WHERE cat = $1 AND num = $2 AND thread_type = 'question'), false) DESC,
+		num ASC
+		LIMIT $3 OFFSET $4`
+
+	stmtGetPostByNumber = `SELECT num, cat, content, subject, parent, username, created_at, locked
+		FROM posts WHERE cat = $1 AND num = $2 AND NOT quarantined`
+)
+
+var preparedStatements = []string{stmtGetCategory, stmtGetThreadBatch, stmtGetPostByNumber}
+
+// prepareStatements prepares the hot queries on a new connection.
+func prepareStatements(ctx context.Context, conn *pgx.Conn) error {
+	for _, sql := range preparedStatements {
+		_, err := conn.Prepare(ctx, sql, sql)
+		if err != nil {
+			// Tables don't exist until migrations have run, pgx prepares queries as they're first run instead.
+			log.Printf("Failed to prepare hot queries, leaving them to the statement cache: %v", err)
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var ErrRetentionDisabled = errors.New("post retention isn't enabled")
+
+// RetentionOptions configure keeping deleted posts around for abuse reports and legal requests.
+type RetentionOptions struct {
+	// AES-256 key retained posts are encrypted with.
+	Key []byte
+	// Days retained posts are kept for before being purged.
+	Days int
+}
+
+/*
+RetainedPost is a deleted post kept in retention, including poster details
+that are never exposed on live posts.
+*/
+type RetainedPost struct {
+	// Hash is the SHA-256 of the retained record, identifying it.
+	Hash      string    `json:"hash"`
+	Num       int       `json:"num"`
+	Cat       string    `json:"cat"`
+	Parent    int       `json:"parent"`
+	Subject   string    `json:"subject"`
+	Content   string    `json:"content"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"createdAt"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
+
+// retention encrypts posts into the retained_posts table.
+type retention struct {
+	aead cipher.AEAD
+	days int
+}
+
+func newRetention(opts RetentionOptions) (*retention, error) {
+	if opts.Days < 1 {
+		return nil, fmt.Errorf("retention must be at least a day, got %d", opts.Days)
+	}
+	block, err := aes.NewCipher(opts.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retention key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &retention{aead: aead, days: opts.Days}, nil
+}
+
+// seal returns the content hash of a post record, and the record encrypted.
+func (r *retention) seal(post *RetainedPost) (string, []byte, error) {
+	plain, err := json.Marshal(post)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(plain)
+
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(sum[:]), r.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// open decrypts a sealed post record.
+func (r *retention) open(sealed []byte) (*RetainedPost, error) {
+	if len(sealed) < r.aead.NonceSize() {
+		return nil, errors.New("retained post payload too short")
+	}
+	nonce, ciphertext := sealed[:r.aead.NonceSize()], sealed[r.aead.NonceSize():]
+	plain, err := r.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt retained post: %w", err)
+	}
+	post := &RetainedPost{}
+	if err := json.Unmarshal(plain, post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+/*
+retain copies a post, and its replies if it's a thread, into retention ahead of it being deleted.
+Their emails and IPs are decrypted with pii first, so retained posts only depend on the retention key.
+*/
+func (r *retention) retain(ctx context.Context, tx pgx.Tx, pii *piiCipher, categoryTag string, num int) error {
+	rows, err := tx.Query(
+		ctx,
+		"SELECT num, cat, parent, subject, content, username, email, ip, created_at FROM posts WHERE cat = $1 AND (num = $2 OR parent = $2)",
+		categoryTag,
+		num,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query posts to retain: %w", err)
+	}
+
+	var posts []*RetainedPost
+	for rows.Next() {
+		p := &RetainedPost{}
+		err := rows.Scan(&p.Num, &p.Cat, &p.Parent, &p.Subject, &p.Content, &p.Username, &p.Email, &p.IP, &p.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to parse a post to retain: %w", err)
+		}
+		posts = append(posts, p)
+	}
+	rows.Close()
+
+	for _, post := range posts {
+		if post.Email, err = pii.open(post.Email); err != nil {
+			return fmt.Errorf("failed to decrypt a post to retain: %w", err)
+		}
+		if post.IP, err = pii.open(post.IP); err != nil {
+			return fmt.Errorf("failed to decrypt a post to retain: %w", err)
+		}
+	}
+
+	for _, post := range posts {
+		hash, sealed, err := r.seal(post)
+		if err != nil {
+			return fmt.Errorf("failed to seal retained post: %w", err)
+		}
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO retained_posts (hash, cat, num, payload, purge_at, ip_hash, email_hash)
+			VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(days => $5), $6, $7)
+			ON CONFLICT (hash) DO NOTHING`,
+			hash,
+			post.Cat,
+			post.Num,
+			sealed,
+			r.days,
+			PosterHash(post.IP),
+			PosterHash(post.Email),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to retain post: %w", err)
+		}
+	}
+	return nil
+}
+
+// EnableRetention makes RemovePost move deleted posts into encrypted retention instead of discarding them.
+func (store *DataStore) EnableRetention(opts RetentionOptions) error {
+	r, err := newRetention(opts)
+	if err != nil {
+		return err
+	}
+	store.retention = r
+	return nil
+}
+
+func (store *DataStore) GetRetainedPosts(ctx context.Context, categoryTag string, num int) ([]*RetainedPost, error) {
+	if store.retention == nil {
+		return nil, ErrRetentionDisabled
+	}
+
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT hash, payload, deleted_at, purge_at FROM retained_posts WHERE cat = $1 AND num = $2 ORDER BY deleted_at ASC",
+		categoryTag,
+		num,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retained posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []*RetainedPost = make([]*RetainedPost, 0)
+	for rows.Next() {
+		var hash string
+		var sealed []byte
+		var deletedAt, purgeAt time.Time
+		err := rows.Scan(&hash, &sealed, &deletedAt, &purgeAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a retained post: %w", err)
+		}
+		post, err := store.retention.open(sealed)
+		if err != nil {
+			return nil, err
+		}
+		post.Hash = hash
+		post.DeletedAt = deletedAt
+		post.PurgeAt = purgeAt
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+func (store *DataStore) PurgeRetainedPosts(ctx context.Context) (int64, error) {
+	res, err := store.pgPool.Exec(ctx, "DELETE FROM retained_posts WHERE purge_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge retained posts: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRetentionSeal(t *testing.T) {
+	_, err := newRetention(RetentionOptions{Key: []byte("short"), Days: 1})
+	if err == nil {
+		t.Error("expected error on invalid key")
+	}
+
+	key := bytes.Repeat([]byte("k"), 32)
+	_, err = newRetention(RetentionOptions{Key: key, Days: 0})
+	if err == nil {
+		t.Error("expected error on zero days")
+	}
+
+	r, err := newRetention(RetentionOptions{Key: key, Days: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := &RetainedPost{
+		Num:       3,
+		Cat:       "cat",
+		Content:   "hello",
+		Email:     "a@example.com",
+		IP:        "127.0.0.1",
+		CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	hash, sealed, err := r.seal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte(post.Email)) {
+		t.Error("expected sealed payload to be encrypted")
+	}
+
+	// Same record, same address.
+	again, _, err := r.seal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != again {
+		t.Errorf("expected stable hash, got %s and %s", hash, again)
+	}
+
+	opened, err := r.open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Email != post.Email || opened.IP != post.IP || !opened.CreatedAt.Equal(post.CreatedAt) {
+		t.Errorf("unexpected opened post %+v", opened)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = r.open(sealed)
+	if err == nil {
+		t.Error("expected error opening tampered payload")
+	}
+}
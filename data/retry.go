@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RetryOptions configure how connections to backing services are retried.
+type RetryOptions struct {
+	// Attempts is the total number of tries, values below 1 are treated as 1.
+	Attempts int
+	// InitialBackoff is the wait after the first failure, doubled after each subsequent failure.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts. Zero means no cap.
+	MaxBackoff time.Duration
+}
+
+// NoRetry makes a single attempt.
+var NoRetry = RetryOptions{Attempts: 1}
+
+// backoff returns how long to wait after the given (zero-indexed) failed attempt.
+func (opts RetryOptions) backoff(attempt int) time.Duration {
+	wait := opts.InitialBackoff
+	for i := 0; i < attempt; i++ {
+		wait *= 2
+		if opts.MaxBackoff > 0 && wait >= opts.MaxBackoff {
+			return opts.MaxBackoff
+		}
+	}
+	if opts.MaxBackoff > 0 && wait > opts.MaxBackoff {
+		return opts.MaxBackoff
+	}
+	return wait
+}
+
+/*
+Retry calls fn until it succeeds, the attempts run out, or the context is cancelled,
+backing off exponentially between attempts. Returns the last error from fn.
+*/
+func Retry(ctx context.Context, opts RetryOptions, name string, fn func(ctx context.Context) error) error {
+	attempts := opts.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		err = fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts-1 {
+			break
+		}
+
+		wait := opts.backoff(attempt)
+		log.Printf("%s failed (attempt %d/%d), retrying in %s: %v", name, attempt+1, attempts, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	opts := RetryOptions{
+		Attempts:       10,
+		InitialBackoff: time.Millisecond * 100,
+		MaxBackoff:     time.Millisecond * 500,
+	}
+	tests := map[int]time.Duration{
+		0: time.Millisecond * 100,
+		1: time.Millisecond * 200,
+		2: time.Millisecond * 400,
+		3: time.Millisecond * 500,
+		8: time.Millisecond * 500,
+	}
+	for attempt, expected := range tests {
+		if got := opts.backoff(attempt); got != expected {
+			t.Errorf("attempt %d: expected backoff %s, got %s", attempt, expected, got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	opts := RetryOptions{
+		Attempts:       3,
+		InitialBackoff: time.Millisecond,
+	}
+
+	calls := 0
+	err := Retry(ctx, opts, "test", func(ctx context.Context) error {
+		calls++
+		if calls < 2 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected success on second attempt, got: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+
+	calls = 0
+	failure := errors.New("always")
+	err = Retry(ctx, opts, "test", func(ctx context.Context) error {
+		calls++
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected last error returned, got: %v", err)
+	}
+	if calls != opts.Attempts {
+		t.Errorf("expected %d calls, got %d", opts.Attempts, calls)
+	}
+
+	calls = 0
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = Retry(cancelled, RetryOptions{Attempts: 5, InitialBackoff: time.Hour}, "test", func(ctx context.Context) error {
+		calls++
+		return failure
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected cancelled context to stop retries after 1 call, got %d calls", calls)
+	}
+
+	calls = 0
+	err = Retry(ctx, NoRetry, "test", func(ctx context.Context) error {
+		calls++
+		return failure
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected a single attempt with NoRetry, got %d calls", calls)
+	}
+}
@@ -0,0 +1,230 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// RuleKind is what an autoban rule looks for.
+type RuleKind string
+
+const (
+	// RuleDeletedPosts matches posters with Threshold posts deleted by moderators within WindowHours.
+	RuleDeletedPosts RuleKind = "deleted_posts"
+	// RuleContentMatch matches new posts whose subject or content matches Pattern.
+	RuleContentMatch RuleKind = "content_match"
+)
+
+// ActionQuarantine hides a post until a moderator approves it.
+const ActionQuarantine ModerationAction = "quarantine"
+
+// Rule is an automatic moderation rule.
+type Rule struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Kind        RuleKind `json:"kind"`
+	Threshold   int      `json:"threshold,omitempty"`
+	WindowHours int      `json:"windowHours,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	// Match Pattern against the post folded to plain lowercase ASCII, so lookalike letters can't slip past it.
+	Fold bool `json:"fold,omitempty"`
+	// ActionBan or ActionQuarantine.
+	Action ModerationAction `json:"action"`
+	// How long bans last, zero bans permanently.
+	BanHours  int       `json:"banHours"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RuleAction is an audit record of a rule acting on a poster.
+type RuleAction struct {
+	RuleID     int              `json:"ruleId"`
+	RuleName   string           `json:"ruleName"`
+	PosterHash string           `json:"posterHash"`
+	Cat        string           `json:"cat"`
+	Num        int              `json:"num"`
+	Action     ModerationAction `json:"action"`
+	CreatedAt  time.Time        `json:"createdAt"`
+}
+
+func (store *DataStore) GetRules(ctx context.Context) ([]*Rule, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT id, name, kind, threshold, window_hours, pattern, fold, action, ban_hours, enabled, created_at FROM rules ORDER BY id ASC",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*Rule = make([]*Rule, 0)
+	for rows.Next() {
+		r := &Rule{}
+		err := rows.Scan(
+			&r.ID, &r.Name, &r.Kind, &r.Threshold, &r.WindowHours, &r.Pattern, &r.Fold, &r.Action, &r.BanHours, &r.Enabled, &r.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a rule: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (store *DataStore) WriteRule(ctx context.Context, rule *Rule) (int, error) {
+	var id int
+	err := store.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO rules (name, kind, threshold, window_hours, pattern, fold, action, ban_hours, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		rule.Name, string(rule.Kind), rule.Threshold, rule.WindowHours, rule.Pattern, rule.Fold, string(rule.Action), rule.BanHours, rule.Enabled,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write rule: %w", err)
+	}
+	return id, nil
+}
+
+func (store *DataStore) UpdateRule(ctx context.Context, rule *Rule) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		`UPDATE rules SET name = $2, kind = $3, threshold = $4, window_hours = $5, pattern = $6, fold = $7, action = $8, ban_hours = $9, enabled = $10
+		WHERE id = $1`,
+		rule.ID, rule.Name, string(rule.Kind), rule.Threshold, rule.WindowHours, rule.Pattern, rule.Fold, string(rule.Action), rule.BanHours, rule.Enabled,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update rule: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *DataStore) RemoveRule(ctx context.Context, id int) error {
+	res, err := store.pgPool.Exec(ctx, "DELETE FROM rules WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to remove rule: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// recordDeletion notes a moderator deleting a post, for rules counting a poster's deleted posts.
+func recordDeletion(ctx context.Context, tx pgx.Tx, categoryTag string, num int) error {
+	_, err := tx.Exec(
+		ctx,
+		"INSERT INTO mod_deletions (poster_hash, cat, num) SELECT ip_hash, cat, num FROM posts WHERE cat = $1 AND num = $2",
+		categoryTag,
+		num,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record deletion: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) CountPosterDeletions(ctx context.Context, categoryTag string, num int, since time.Time) (string, int, error) {
+	var posterHash string
+	var count int
+	err := store.pgPool.QueryRow(
+		ctx,
+		`WITH poster AS (
+			SELECT poster_hash FROM mod_deletions WHERE cat = $1 AND num = $2 ORDER BY deleted_at DESC LIMIT 1
+		)
+		SELECT poster.poster_hash, COUNT(d.id) FROM poster
+		LEFT JOIN mod_deletions d ON d.poster_hash = poster.poster_hash AND d.deleted_at > $3
+		GROUP BY poster.poster_hash`,
+		categoryTag,
+		num,
+		since,
+	).Scan(&posterHash, &count)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", 0, ErrNotFound
+		}
+		return "", 0, fmt.Errorf("failed to count poster's deletions: %w", err)
+	}
+	return posterHash, count, nil
+}
+
+func (store *DataStore) BanPoster(ctx context.Context, posterHash string, reason string, banFor time.Duration) error {
+	_, err := store.pgPool.Exec(
+		ctx,
+		"INSERT INTO bans (poster_hash, reason, expires_at) VALUES ($1, $2, $3)",
+		posterHash,
+		reason,
+		banExpiry(banFor),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to ban poster: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) QuarantinePost(ctx context.Context, categoryTag string, num int) error {
+	res, err := store.pgPool.Exec(ctx, "UPDATE posts SET quarantined = true WHERE cat = $1 AND num = $2", categoryTag, num)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine post: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *DataStore) WriteRuleAction(ctx context.Context, action *RuleAction) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin writing rule action: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		"INSERT INTO rule_actions (rule_id, rule_name, poster_hash, cat, num, action) VALUES ($1, $2, $3, $4, $5, $6)",
+		action.RuleID, action.RuleName, action.PosterHash, action.Cat, action.Num, string(action.Action),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write rule action: %w", err)
+	}
+	err = logAction(ctx, tx, action.Cat, action.Num, action.Action, action.RuleID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit rule action: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) GetRuleActions(ctx context.Context, limit int) ([]*RuleAction, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT rule_id, rule_name, poster_hash, cat, num, action, created_at FROM rule_actions ORDER BY created_at DESC LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rule actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []*RuleAction = make([]*RuleAction, 0)
+	for rows.Next() {
+		a := &RuleAction{}
+		err := rows.Scan(&a.RuleID, &a.RuleName, &a.PosterHash, &a.Cat, &a.Num, &a.Action, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a rule action: %w", err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
@@ -0,0 +1,249 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+/*
+Session is a login on a device, with the access tokens it's been issued, first by logging in and then by
+refreshing. Only the tokens' hashes are kept, so the table can't be used to log in as anyone, except for
+the refresh token, which is encrypted like emails since Auth0 needs it to revoke it.
+*/
+type Session struct {
+	ID     int    `json:"id"`
+	UserID string `json:"-"`
+	// Hash of the access token the session was looked up by, or its latest.
+	TokenHash string `json:"-"`
+	// Only read when the session's being refreshed or revoked, empty if the login wasn't given one.
+	RefreshToken string `json:"-"`
+	UserAgent    string `json:"userAgent"`
+	// PosterHash of the IP it logged in from.
+	IPHash     string    `json:"ipHash"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Revoked    bool      `json:"-"`
+	// When a two-factor code was entered on it, nil if one hasn't been.
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+	// Whether it's the session the request listing it was made with.
+	Current bool `json:"current"`
+}
+
+// Expired sessions are kept this long past expiring, then cleared when their user next logs in.
+const expiredSessionGrace = time.Hour * 24
+
+// refreshTokenHash is what a session's found by when it's refreshed, empty if it has no refresh token.
+func refreshTokenHash(refreshToken string) string {
+	if len(refreshToken) == 0 {
+		return ""
+	}
+	return TokenHash(refreshToken)
+}
+
+func (store *DataStore) WriteSession(ctx context.Context, session *Session) error {
+	refreshToken, err := store.pii.seal(session.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt refresh token: %w", err)
+	}
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin writing session: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		"DELETE FROM sessions WHERE user_id = $1 AND expires_at < $2",
+		session.UserID,
+		time.Now().Add(-expiredSessionGrace),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear expired sessions: %w", err)
+	}
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO sessions (user_id, token_hash, refresh_token, refresh_token_hash, user_agent, ip_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (token_hash) DO UPDATE SET last_seen_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at, last_seen_at`,
+		session.UserID,
+		session.TokenHash,
+		refreshToken,
+		refreshTokenHash(session.RefreshToken),
+		session.UserAgent,
+		session.IPHash,
+		session.ExpiresAt,
+	).Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	err = writeSessionToken(ctx, tx, session.ID, session.TokenHash)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit session: %w", err)
+	}
+	return nil
+}
+
+// writeSessionToken records an access token as issued to the session.
+func writeSessionToken(ctx context.Context, tx pgx.Tx, id int, tokenHash string) error {
+	_, err := tx.Exec(
+		ctx,
+		"INSERT INTO session_tokens (token_hash, session_id) VALUES ($1, $2) ON CONFLICT (token_hash) DO NOTHING",
+		tokenHash,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write session token: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) TouchSession(ctx context.Context, tokenHash string) (*Session, error) {
+	session := &Session{TokenHash: tokenHash}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`UPDATE sessions s SET last_seen_at = CURRENT_TIMESTAMP FROM session_tokens t
+		WHERE t.token_hash = $1 AND s.id = t.session_id
+		RETURNING s.id, s.user_id, s.user_agent, s.ip_hash, s.created_at, s.last_seen_at, s.expires_at, s.revoked,
+		s.verified_at`,
+		tokenHash,
+	).Scan(
+		&session.ID, &session.UserID, &session.UserAgent, &session.IPHash, &session.CreatedAt,
+		&session.LastSeenAt, &session.ExpiresAt, &session.Revoked, &session.VerifiedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to touch session: %w", err)
+	}
+	return session, nil
+}
+
+func (store *DataStore) GetSessions(ctx context.Context, userID string) ([]*Session, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT id, user_id, token_hash, user_agent, ip_hash, created_at, last_seen_at, expires_at, verified_at FROM sessions
+		WHERE user_id = $1 AND NOT revoked AND expires_at > CURRENT_TIMESTAMP ORDER BY last_seen_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		session := &Session{}
+		err := rows.Scan(
+			&session.ID, &session.UserID, &session.TokenHash, &session.UserAgent, &session.IPHash,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.VerifiedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// getSession reads a session found by the condition on sessions with the argument, decrypting its refresh token.
+func (store *DataStore) getSession(ctx context.Context, where string, args ...interface{}) (*Session, error) {
+	session := &Session{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT id, user_id, token_hash, refresh_token, user_agent, ip_hash, created_at, last_seen_at, expires_at,
+		revoked, verified_at FROM sessions WHERE `+where,
+		args...,
+	).Scan(
+		&session.ID, &session.UserID, &session.TokenHash, &session.RefreshToken, &session.UserAgent, &session.IPHash,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.Revoked, &session.VerifiedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	session.RefreshToken, err = store.pii.open(session.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+	return session, nil
+}
+
+func (store *DataStore) GetSession(ctx context.Context, userID string, id int) (*Session, error) {
+	return store.getSession(ctx, "id = $1 AND user_id = $2", id, userID)
+}
+
+func (store *DataStore) GetRefreshSession(ctx context.Context, refreshToken string) (*Session, error) {
+	if len(refreshToken) == 0 {
+		return nil, ErrNotFound
+	}
+	return store.getSession(ctx, "refresh_token_hash = $1", refreshTokenHash(refreshToken))
+}
+
+func (store *DataStore) RenewSession(ctx context.Context, id int, renewed *Session) error {
+	refreshToken, err := store.pii.seal(renewed.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt refresh token: %w", err)
+	}
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin renewing session: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Refresh tokens that aren't rotated keep the one the session has.
+	res, err := tx.Exec(
+		ctx,
+		`UPDATE sessions SET token_hash = $2, expires_at = $3, last_seen_at = CURRENT_TIMESTAMP,
+		refresh_token = CASE WHEN $4 = '' THEN refresh_token ELSE $4 END,
+		refresh_token_hash = CASE WHEN $5 = '' THEN refresh_token_hash ELSE $5 END
+		WHERE id = $1 AND NOT revoked`,
+		id,
+		renewed.TokenHash,
+		renewed.ExpiresAt,
+		refreshToken,
+		refreshTokenHash(renewed.RefreshToken),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to renew session: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	err = writeSessionToken(ctx, tx, id, renewed.TokenHash)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit renewed session: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) RevokeSession(ctx context.Context, userID string, id int) error {
+	// The refresh token's hash is kept, so refreshing with it is refused as revoked rather than unknown.
+	res, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE sessions SET revoked = true, refresh_token = '' WHERE id = $1 AND user_id = $2 AND NOT revoked",
+		id,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
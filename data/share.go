@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Attempts at a token that isn't taken before giving up.
+const shareTokenAttempts = 3
+
+// ShareLink is a short token standing in for a thread, and optionally a post in it.
+type ShareLink struct {
+	Token  string `json:"token"`
+	Cat    string `json:"cat"`
+	Thread int    `json:"thread"`
+	// Post in the thread to scroll to, zero for none.
+	Post int `json:"post,omitempty"`
+	// Current slug of the category, which may have been renamed since the link was made.
+	Slug   string `json:"slug"`
+	Clicks int    `json:"clicks"`
+}
+
+// newShareToken returns a random 8 character URL-safe token.
+func newShareToken() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (store *DataStore) WriteShareLink(ctx context.Context, categoryTag string, thread int, post int) (string, error) {
+	for attempt := 0; attempt < shareTokenAttempts; attempt++ {
+		token, err := newShareToken()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate share token: %w", err)
+		}
+		// Links to the same place are shared, so they're stable and don't pile up.
+		err = store.pgPool.QueryRow(
+			ctx,
+			`INSERT INTO share_links (token, cat, thread, post)
+			SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT FROM posts WHERE cat = $2 AND num = $3 AND parent = 0)
+			AND ($4 = 0 OR $4 = $3 OR EXISTS (SELECT FROM posts WHERE cat = $2 AND num = $4 AND parent = $3))
+			ON CONFLICT (cat, thread, post) DO UPDATE SET token = share_links.token
+			RETURNING token`,
+			token,
+			categoryTag,
+			thread,
+			post,
+		).Scan(&token)
+		if err == nil {
+			return token, nil
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+			return "", fmt.Errorf("failed to write share link: %w", err)
+		}
+	}
+	return "", errors.New("failed to find a free share token")
+}
+
+func (store *DataStore) FollowShareLink(ctx context.Context, token string) (*ShareLink, error) {
+	link := &ShareLink{Token: token}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`UPDATE share_links SET clicks = clicks + 1 FROM cats
+		WHERE share_links.token = $1 AND cats.tag = share_links.cat
+		RETURNING share_links.cat, share_links.thread, share_links.post, cats.slug, share_links.clicks`,
+		token,
+	).Scan(&link.Cat, &link.Thread, &link.Post, &link.Slug, &link.Clicks)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to follow share link: %w", err)
+	}
+	return link, nil
+}
@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+var ErrSlugTaken = errors.New("that slug is taken")
+
+func (store *DataStore) ResolveCategorySlug(ctx context.Context, slug string) (string, string, error) {
+	var tag, current string
+	// Current slugs win over slugs categories were renamed from.
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT tag, slug FROM cats WHERE slug = $1
+		UNION ALL
+		SELECT cats.tag, cats.slug FROM category_slugs JOIN cats ON cats.tag = category_slugs.cat WHERE category_slugs.slug = $1
+		LIMIT 1`,
+		slug,
+	).Scan(&tag, &current)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", "", ErrNotFound
+		}
+		return "", "", fmt.Errorf("failed to resolve category slug: %w", err)
+	}
+	return tag, current, nil
+}
+
+func (store *DataStore) SetCategorySlug(ctx context.Context, categoryTag string, slug string) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin setting category slug: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var old string
+	err = tx.QueryRow(ctx, "SELECT slug FROM cats WHERE tag = $1 FOR UPDATE", categoryTag).Scan(&old)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to query category slug: %w", err)
+	}
+	if old == slug {
+		return nil
+	}
+
+	// Categories can take back their own old slugs, but not anyone else's.
+	var taken bool
+	err = tx.QueryRow(
+		ctx,
+		`SELECT EXISTS (SELECT FROM cats WHERE slug = $2)
+		OR EXISTS (SELECT FROM category_slugs WHERE slug = $2 AND cat != $1)`,
+		categoryTag,
+		slug,
+	).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to check category slug: %w", err)
+	}
+	if taken {
+		return ErrSlugTaken
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM category_slugs WHERE slug = $1", slug)
+	if err != nil {
+		return fmt.Errorf("failed to reclaim category slug: %w", err)
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO category_slugs (slug, cat) VALUES ($1, $2)", old, categoryTag)
+	if err != nil {
+		return fmt.Errorf("failed to keep old category slug: %w", err)
+	}
+	_, err = tx.Exec(ctx, "UPDATE cats SET slug = $2, version = version + 1, updated_at = now() WHERE tag = $1", categoryTag, slug)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrSlugTaken
+		}
+		return fmt.Errorf("failed to set category slug: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit category slug: %w", err)
+	}
+	return nil
+}
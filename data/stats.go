@@ -0,0 +1,37 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Stats are instance-wide numbers safe to show publicly.
+type Stats struct {
+	Posts      int `json:"posts"`
+	PostsToday int `json:"postsToday"`
+	// Threads posted in over the last day.
+	ActiveThreads int `json:"activeThreads"`
+	Categories    int `json:"categories"`
+	// Threads across every category, only filled in from cached counts.
+	Threads int       `json:"threads,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+func (store *DataStore) GetStats(ctx context.Context) (*Stats, error) {
+	stats := &Stats{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT
+		(SELECT count(*) FROM posts),
+		(SELECT count(*) FROM posts WHERE created_at >= date_trunc('day', now())),
+		(SELECT count(DISTINCT (cat, CASE WHEN parent = 0 THEN num ELSE parent END)) FROM posts
+			WHERE created_at >= now() - interval '1 day'),
+		(SELECT count(*) FROM cats),
+		now()`,
+	).Scan(&stats.Posts, &stats.PostsToday, &stats.ActiveThreads, &stats.Categories, &stats.At)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+	return stats, nil
+}
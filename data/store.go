@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"spiritchat/validation"
 	"time"
 
 	"github.com/jackc/pgconn"
@@ -17,19 +18,80 @@ type Store interface {
 	// Cleanup cleans the underlying connection to the data store.
 	Cleanup(ctx context.Context) error
 
-	// WriteCategory adds a new category to the database.
+	/*
+		WriteCategory adds a new category to the database. Returns ErrCategoryExists if the tag's taken,
+		or ErrInvalidCategoryTag unless it's 1 to 12 lowercase letters or numbers.
+	*/
 	WriteCategory(ctx context.Context, categoryTag string, categoryName string) error
 
 	/*
-		Drops a category.
-		Returns affected rows.
+		Drops a category and its posts in one transaction, optionally archiving them first.
+		Dry runs count what would be removed without removing anything.
+	*/
+	RemoveCategory(ctx context.Context, categoryTag string, opts RemoveCategoryOptions) (*CategoryRemoval, error)
+
+	/*
+		ResolveCategorySlug returns the tag of the category at a URL slug, and the category's
+		current slug, which differs from the given one if the category's been renamed since.
+		Should return ErrNotFound if no category has or had the slug.
+	*/
+	ResolveCategorySlug(ctx context.Context, slug string) (string, string, error)
+
+	/*
+		SetCategorySlug renames a category's URL slug, keeping the old one to redirect from.
+		Should return ErrNotFound if no such category, or ErrSlugTaken if another category has or had the slug.
+	*/
+	SetCategorySlug(ctx context.Context, categoryTag string, slug string) error
+
+	/*
+		SetCategoryListing sets where a category is listed, and whether it's featured.
+		Should return ErrNotFound if no such category.
+	*/
+	SetCategoryListing(ctx context.Context, categoryTag string, sortOrder int, featured bool) error
+
+	/*
+		SetCategoryArchived archives a category, keeping its posts readable but taking no new ones, or unarchives it.
+		Should return ErrNotFound if no such category.
+	*/
+	SetCategoryArchived(ctx context.Context, categoryTag string, archived bool) error
+
+	/*
+		UpdateCategory applies an admin's edits to a category if it's still at the given version, returning the updated category.
+		Should return ErrNotFound if no such category, or ErrVersionConflict if it's been edited since.
+	*/
+	UpdateCategory(ctx context.Context, categoryTag string, version int, update *CategoryUpdate) (*Category, error)
+
+	/*
+		GetCategoryMasks returns the words masked in a category's posts.
+		Should return ErrNotFound if no such category.
+	*/
+	GetCategoryMasks(ctx context.Context, categoryTag string) ([]string, error)
+
+	/*
+		SetCategoryMasks replaces the words masked in a category's new posts.
+		Should return ErrNotFound if no such category.
+	*/
+	SetCategoryMasks(ctx context.Context, categoryTag string, words []string) error
+
+	/*
+		GetPostPolicy returns what a category accepts in new posts.
+		Should return ErrNotFound if no such category.
+	*/
+	GetPostPolicy(ctx context.Context, categoryTag string) (*PostPolicy, error)
+
+	/*
+		SetPostPolicy sets what a category accepts in new posts, besides its masked words.
+		Should return ErrNotFound if no such category.
 	*/
-	RemoveCategory(ctx context.Context, categoryTag string) (int64, error)
+	SetPostPolicy(ctx context.Context, categoryTag string, policy *PostPolicy) error
 
 	// GetThreadCount returns the number of threads in a category.
 	GetThreadCount(ctx context.Context, categoryTag string) (int, error)
 
-	// GetCategories returns all categories.
+	// GetReplyCounts returns how many replies each thread on a category has, by thread number, from the thread catalog.
+	GetReplyCounts(ctx context.Context, categoryTag string) (map[int]int, error)
+
+	// GetCategories returns all categories, featured categories first, then by sort order.
 	GetCategories(ctx context.Context) ([]*Category, error)
 
 	/*
@@ -45,6 +107,13 @@ type Store interface {
 	*/
 	GetThreadView(ctx context.Context, categoryTag string, threadNum int) (*ThreadView, error)
 
+	/*
+		StreamThreadView writes the page of a thread to w as it's read, in the order GetThreadView returns it,
+		stopping after the page's limit. Returns whether there were more posts than the limit.
+		Should return ErrNotFound, before writing anything, if GetThreadView would.
+	*/
+	StreamThreadView(ctx context.Context, categoryTag string, threadNum int, page ThreadPage, w ThreadWriter) (bool, error)
+
 	/*
 		GetCategory returns a single category. May return ErrNotFound if the given category
 		name is invalid.
@@ -52,43 +121,617 @@ type Store interface {
 	GetCategory(ctx context.Context, categoryTag string) (*Category, error)
 
 	/*
-		GetCategoryView returns information about a category, and all the threads on it.
+		GetCategoryView returns information about a category, and all the threads on it with their replies counted.
+		Counts come from the thread catalog, which can drift until it's next refreshed.
 		May return an ErrNotFound if the given category name is invalid.
 	*/
 	GetCategoryView(ctx context.Context, categoryTag string) (*CatView, error)
 
 	/*
-		Creates a post.
-		Optional parent thread can be provided if it's a reply.
-		Should return ErrNotFound if invalid post or category.
+		Creates a post, returning its number.
+		Optional parent thread can be provided if it's a reply, threadType is ignored on replies.
+		Attachments are the IDs of the author's unposted uploads to put on the post.
+		Should return ErrNotFound if invalid post or category, ErrThreadLocked if the thread is locked,
+		ErrCategoryArchived if the category is archived, or ErrAttachmentUnavailable if an attachment can't be posted.
+	*/
+	WritePost(
+		ctx context.Context, categoryTag string, parentThreadNumber int, subject string, content string,
+		author *Identity, capcode string, threadType ThreadType, attachments []AttachmentRef,
+	) (int, error)
+
+	// WriteAttachment records an uploaded file, returning its ID.
+	WriteAttachment(ctx context.Context, attachment *Attachment) (int, error)
+
+	/*
+		GetAttachment returns an uploaded file's record.
+		Should return ErrNotFound if no such attachment.
+	*/
+	GetAttachment(ctx context.Context, id int) (*Attachment, error)
+
+	/*
+		SetAttachmentTranscode records where an attachment transcoded to a normalized format was stored.
+		Should return ErrNotFound if no such attachment.
+	*/
+	SetAttachmentTranscode(ctx context.Context, id int, file string, mime string) error
+
+	/*
+		SetAttachmentFlags sets whether an attachment's a spoiler or NSFW, logging it if it's been posted.
+		Should return ErrNotFound if no such attachment.
+	*/
+	SetAttachmentFlags(ctx context.Context, id int, flags *AttachmentFlags) (*Attachment, error)
+
+	// GetStorageUsage returns how much storage attachments take up, overall and by category.
+	GetStorageUsage(ctx context.Context) (*StorageUsage, error)
+
+	// GetCategoryStorageUsage returns the bytes taken up by attachments on a category's posts.
+	GetCategoryStorageUsage(ctx context.Context, categoryTag string) (int64, error)
+
+	/*
+		PruneAttachments deletes the oldest attachments on a category's posts until the rest fit in the budget,
+		returning what was deleted so the files can be removed from storage.
 	*/
-	WritePost(ctx context.Context, categoryTag string, parentThreadNumber int, subject string, content string, username string, email string, ip string) error
+	PruneAttachments(ctx context.Context, categoryTag string, budget int64) ([]*Attachment, error)
+
+	/*
+		CollectOrphanedAttachments deletes attachments whose posts were deleted longer than grace ago,
+		and uploads that still weren't posted after it, returning them so the files can be removed from storage.
+	*/
+	CollectOrphanedAttachments(ctx context.Context, grace time.Duration) ([]*Attachment, error)
 
 	/*
 		Removes a post at the given category & number.
+		If retention is enabled, the post and any replies are kept in retention first.
 		Returns number of rows affected.
 	*/
 	RemovePost(ctx context.Context, categoryTag string, number int) (int, error)
 
 	/*
-		Returns whether the post at the given category & postNum has the given email.
+		GetRetainedPosts returns the decrypted retention records of a deleted post.
+		Should return ErrRetentionDisabled if retention isn't enabled.
+	*/
+	GetRetainedPosts(ctx context.Context, categoryTag string, number int) ([]*RetainedPost, error)
+
+	/*
+		PurgeRetainedPosts drops retained posts past their retention period.
+		Returns number of rows affected.
+	*/
+	PurgeRetainedPosts(ctx context.Context) (int64, error)
+
+	/*
+		GetPostOwnership returns who owns the post at the given category & number.
+		Should return ErrNotFound if no such post.
+	*/
+	GetPostOwnership(ctx context.Context, categoryTag string, postNum int) (*PostOwnership, error)
+
+	/*
+		CloseThread stops replies to a thread.
+		Should return ErrNotFound if no such thread.
 	*/
-	EmailMatches(ctx context.Context, categoryTag string, postNum int, email string) (bool, error)
+	CloseThread(ctx context.Context, categoryTag string, threadNum int) error
 
 	/*
-		Returns all posts that have the given email.
+		SetBestAnswer marks a reply as the thread's best answer, zero clears it.
+		Should return ErrNotFound if no such thread, or the reply isn't on it.
 	*/
-	GetPostsByEmail(ctx context.Context, email string) ([]*Post, error)
+	SetBestAnswer(ctx context.Context, categoryTag string, threadNum int, replyNum int) error
+
+	// GetPostsByAuthor returns all posts written by the given author ID.
+	GetPostsByAuthor(ctx context.Context, authorID string) ([]*Post, error)
+
+	/*
+		ClaimPosts moves posts owned by the author's email hash, written before posts
+		were keyed on the account ID, over to the author's ID. The author's email must be verified.
+		Returns number of rows affected.
+	*/
+	ClaimPosts(ctx context.Context, author *Identity) (int64, error)
+
+	// AccountFirstSeen returns when the account was first seen, recording it as now if it hasn't been before.
+	AccountFirstSeen(ctx context.Context, accountID string) (time.Time, error)
+
+	// GetPreferences returns the account's preferences, the defaults if it hasn't set any.
+	GetPreferences(ctx context.Context, accountID string) (*Preferences, error)
+
+	// UpdatePreferences applies the update to the account's preferences, returning them all.
+	UpdatePreferences(ctx context.Context, accountID string, update *PreferencesUpdate) (*Preferences, error)
+
+	// RecordAccount records the account's current username, so others can message it.
+	RecordAccount(ctx context.Context, accountID string, username string) error
+
+	/*
+		SendMessage sends a private message to the account seen with the recipient username,
+		starting a conversation with it if there isn't one. Should return ErrNotFound if no such account,
+		ErrAmbiguousUsername if more than one account has the username,
+		or ErrCantMessage if it's the sender's own or either has blocked the other.
+	*/
+	SendMessage(ctx context.Context, sender *Identity, recipient string, content string) (*Message, error)
+
+	// GetConversations returns the account's conversations, most recently active first.
+	GetConversations(ctx context.Context, accountID string) ([]*Conversation, error)
+
+	/*
+		GetMessages returns up to limit messages in one of the account's conversations, newest first,
+		only those before the message ID if it's not zero. Messages to the account are marked read.
+		Should return ErrNotFound if no such conversation, or it isn't the account's.
+	*/
+	GetMessages(ctx context.Context, accountID string, conversationID int, before int, limit int) ([]*Message, error)
+
+	// CountUnreadMessages returns the number of messages to the account it hasn't read.
+	CountUnreadMessages(ctx context.Context, accountID string) (int, error)
+
+	/*
+		WriteMentions notifies the accounts last seen with each username that the post mentioned them,
+		besides the post's author and accounts that have muted mentions. Returns number of accounts notified.
+	*/
+	WriteMentions(ctx context.Context, post *Post, authorID string, usernames []string) (int, error)
+
+	/*
+		GetNotifications returns up to limit of the account's notifications, newest first,
+		only those before the notification ID if it's not zero. They're marked read.
+	*/
+	GetNotifications(ctx context.Context, accountID string, before int, limit int) ([]*Notification, error)
+
+	// CountUnreadNotifications returns the number of the account's notifications it hasn't read.
+	CountUnreadNotifications(ctx context.Context, accountID string) (int, error)
+
+	/*
+		SetBookmark saves a post for the account, or forgets it.
+		Should return ErrNotFound if bookmarking a post that doesn't exist.
+	*/
+	SetBookmark(ctx context.Context, accountID string, categoryTag string, num int, bookmarked bool) error
+
+	/*
+		GetBookmarks returns up to limit of the account's bookmarked posts with their threads, newest first,
+		only those before the bookmark ID if it's not zero.
+	*/
+	GetBookmarks(ctx context.Context, accountID string, before int, limit int) ([]*Bookmark, error)
+
+	/*
+		WriteShareLink returns the token of a short link to the thread, scrolled to the post in it if it's not zero,
+		making one if there isn't one already. Should return ErrNotFound if no such thread, or post in it.
+	*/
+	WriteShareLink(ctx context.Context, categoryTag string, thread int, post int) (string, error)
+
+	/*
+		FollowShareLink returns where the short link goes, counting the click.
+		Should return ErrNotFound if no such link.
+	*/
+	FollowShareLink(ctx context.Context, token string) (*ShareLink, error)
+
+	/*
+		GetChanges returns up to limit posts created, edited or deleted after the since cursor, oldest first.
+		A since of zero reads from the start of the feed.
+	*/
+	GetChanges(ctx context.Context, since int64, limit int) ([]*PostChange, error)
+	// GetPostEvents returns every event recorded for a post, oldest first, even once it's been deleted.
+	GetPostEvents(ctx context.Context, categoryTag string, num int) ([]*PostEvent, error)
+
+	/*
+		WriteConfirmation issues a one-time token confirming the request with the given hash, made by the user,
+		until it expires.
+	*/
+	WriteConfirmation(ctx context.Context, userID string, requestHash string, expires time.Time) (string, error)
+	/*
+		UseConfirmation spends a confirmation token on the request it was issued for.
+		Should return ErrNotFound if it's expired, been used, or was issued to anyone or anything else.
+	*/
+	UseConfirmation(ctx context.Context, token string, userID string, requestHash string) error
+
+	// GetWebhooks returns every webhook, enabled or not, in the order they were added.
+	GetWebhooks(ctx context.Context) ([]*Webhook, error)
+
+	/*
+		WriteWebhook adds a webhook, setting its ID.
+		Should return ErrNotFound if it's limited to a category that doesn't exist.
+	*/
+	WriteWebhook(ctx context.Context, hook *Webhook) error
+
+	/*
+		UpdateWebhook replaces a webhook, keeping its secret if it isn't given a new one.
+		Should return ErrNotFound if there's no such webhook, or it's limited to a category that doesn't exist.
+	*/
+	UpdateWebhook(ctx context.Context, hook *Webhook) error
+
+	// RemoveWebhook removes a webhook. Should return ErrNotFound if there's no such webhook.
+	RemoveWebhook(ctx context.Context, id int) error
+
+	// WriteClientKey issues a new client key, returning it along with the key itself, which is only known here.
+	WriteClientKey(ctx context.Context, label string) (*ClientKey, string, error)
+
+	// GetClientKeys returns every client key issued, newest first, including revoked ones.
+	GetClientKeys(ctx context.Context) ([]*ClientKey, error)
+
+	// RevokeClientKey revokes a client key by its ID. Should return ErrNotFound if no such unrevoked key.
+	RevokeClientKey(ctx context.Context, id int) error
+
+	// IsClientKeyValid returns true if the ClientKeyHash belongs to a key that hasn't been revoked.
+	IsClientKeyValid(ctx context.Context, keyHash string) (bool, error)
+
+	/*
+		SetCategorySubscription subscribes the account to digests of a category, or unsubscribes it.
+		Should return ErrNotFound if subscribing to a category that doesn't exist.
+	*/
+	SetCategorySubscription(ctx context.Context, accountID string, categoryTag string, subscribed bool) error
+
+	// GetCategorySubscriptions returns the tags of the categories the account subscribes to.
+	GetCategorySubscriptions(ctx context.Context, accountID string) ([]string, error)
+
+	/*
+		WriteDigests writes each subscriber a digest of up to size of the most trending threads made in their
+		categories since their last digest, skipping subscribers with none. Returns number of digests written.
+	*/
+	WriteDigests(ctx context.Context, size int) (int, error)
+
+	/*
+		GetDigests returns up to limit of the account's digests, newest first,
+		only those before the digest ID if it's not zero.
+	*/
+	GetDigests(ctx context.Context, accountID string, before int, limit int) ([]*Digest, error)
+
+	// GetStats counts instance-wide posts, threads and categories.
+	GetStats(ctx context.Context) (*Stats, error)
+
+	// WriteImpersonation records an admin's grant to act as a user, returning the token to act with.
+	WriteImpersonation(ctx context.Context, imp *Impersonation) (string, error)
+
+	/*
+		GetImpersonationByToken returns the impersonation the token grants.
+		Should return ErrNotFound if no such token, or it's expired or been revoked.
+	*/
+	GetImpersonationByToken(ctx context.Context, token string) (*Impersonation, error)
+
+	// GetImpersonations returns up to limit impersonations, newest first.
+	GetImpersonations(ctx context.Context, limit int) ([]*Impersonation, error)
+
+	/*
+		RevokeImpersonation ends an impersonation before it expires.
+		Should return ErrNotFound if no such impersonation.
+	*/
+	RevokeImpersonation(ctx context.Context, id int) error
+
+	// LogImpersonationAction records a request made while impersonating a user, to the impersonation's audit trail.
+	LogImpersonationAction(ctx context.Context, id int, method string, path string) error
+
+	// GetImpersonationActions returns the requests made under an impersonation, oldest first.
+	GetImpersonationActions(ctx context.Context, id int) ([]*ImpersonationAction, error)
+
+	// GetBlocked returns the usernames of the accounts the account has blocked.
+	GetBlocked(ctx context.Context, accountID string) ([]string, error)
+
+	/*
+		SetBlocked blocks or unblocks the account seen with the username from messaging the account.
+		Should return ErrNotFound if no such account, or ErrAmbiguousUsername if more than one account has the username.
+	*/
+	SetBlocked(ctx context.Context, accountID string, username string, blocked bool) error
+
+	/*
+		SearchPosts returns up to limit posts matching a full text query, best matches first.
+		An empty categoryTag searches every category, unanswered only returns unsolved question threads.
+	*/
+	SearchPosts(ctx context.Context, query string, categoryTag string, unanswered bool, limit int) ([]*Post, error)
+
+	/*
+		GetPosterHistory returns every post by the poster with the given PosterHash of their IP or email,
+		newest first, and their number of bans. Deleted posts are only included if retention is enabled.
+	*/
+	GetPosterHistory(ctx context.Context, posterHash string) (*PosterHistory, error)
+
+	/*
+		WriteReport files a report against a post, putting it in the moderation queue.
+		Should return ErrNotFound if no such post.
+	*/
+	WriteReport(ctx context.Context, categoryTag string, num int, reason string, reporterHash string) error
+
+	/*
+		WriteComplaint records an abuse complaint or takedown notice, setting its ID, and files a priority report
+		against each of its posts that still exists. Returns how many posts were reported.
+	*/
+	WriteComplaint(ctx context.Context, complaint *Complaint) (int, error)
+
+	// GetComplaint returns a complaint by its ID. Should return ErrNotFound if no such complaint.
+	GetComplaint(ctx context.Context, id int) (*Complaint, error)
+
+	/*
+		GetModerationQueue returns up to limit posts with open reports or in quarantine,
+		posts reported by complaints first, then longest waiting first.
+	*/
+	GetModerationQueue(ctx context.Context, limit int) ([]*QueueItem, error)
+
+	/*
+		ResolveQueueItem closes a post's reports and applies the resolution's action to it in one transaction.
+		Should return ErrNotFound if no such post, or ErrUnknownAction.
+	*/
+	ResolveQueueItem(ctx context.Context, categoryTag string, num int, resolution Resolution) error
+
+	// IsBanned returns true if any of the poster hashes has a ban that hasn't expired.
+	IsBanned(ctx context.Context, posterHashes ...string) (bool, error)
+
+	/*
+		ApplyBulkActions bans posters, locks threads and deletes posts in one transaction.
+		Each action is applied independently, one failing doesn't roll back the others.
+	*/
+	ApplyBulkActions(ctx context.Context, actions BulkActions) (*BulkResults, error)
+
+	// GetRules returns every autoban rule, enabled or not.
+	GetRules(ctx context.Context) ([]*Rule, error)
+
+	// WriteRule adds an autoban rule, returning its ID.
+	WriteRule(ctx context.Context, rule *Rule) (int, error)
+
+	/*
+		UpdateRule replaces the autoban rule with the rule's ID.
+		Should return ErrNotFound if no such rule.
+	*/
+	UpdateRule(ctx context.Context, rule *Rule) error
+
+	/*
+		RemoveRule drops an autoban rule.
+		Should return ErrNotFound if no such rule.
+	*/
+	RemoveRule(ctx context.Context, id int) error
+
+	/*
+		CountPosterDeletions finds who made a post deleted by a moderator, and returns their
+		poster hash with how many of their posts moderators have deleted since the given time.
+		Should return ErrNotFound if the post wasn't deleted by a moderator.
+	*/
+	CountPosterDeletions(ctx context.Context, categoryTag string, num int, since time.Time) (string, int, error)
+
+	// BanPoster bans a poster hash, permanently if banFor is zero.
+	BanPoster(ctx context.Context, posterHash string, reason string, banFor time.Duration) error
+
+	/*
+		QuarantinePost hides a post until a moderator approves it.
+		Should return ErrNotFound if no such post.
+	*/
+	QuarantinePost(ctx context.Context, categoryTag string, num int) error
+
+	// WriteRuleAction records an action taken by an autoban rule.
+	WriteRuleAction(ctx context.Context, action *RuleAction) error
+
+	// GetRuleActions returns up to limit actions taken by autoban rules, newest first.
+	GetRuleActions(ctx context.Context, limit int) ([]*RuleAction, error)
+
+	/*
+		WriteNote adds a staff note on a post or poster, returning its ID.
+		Should return ErrNotFound if the note is on a post that doesn't exist.
+	*/
+	WriteNote(ctx context.Context, note *Note) (int, error)
+
+	/*
+		RemoveNote drops a staff note.
+		Should return ErrNotFound if no such note.
+	*/
+	RemoveNote(ctx context.Context, id int) error
+
+	// GetModLog returns up to limit public moderation log entries on a category, newest first.
+	GetModLog(ctx context.Context, categoryTag string, limit int) ([]*ModLogEntry, error)
+
+	// GetCategoryRules returns a category's rules in order.
+	GetCategoryRules(ctx context.Context, categoryTag string) ([]string, error)
+
+	/*
+		SetCategoryRules replaces a category's rules.
+		Should return ErrNotFound if no such category.
+	*/
+	SetCategoryRules(ctx context.Context, categoryTag string, rules []string) error
+
+	// MustAcceptRules returns true if a category has rules, and none of the poster hashes have accepted them.
+	MustAcceptRules(ctx context.Context, categoryTag string, posterHashes ...string) (bool, error)
+
+	/*
+		AcceptRules records each poster hash accepting a category's rules.
+		Should return ErrNotFound if no such category.
+	*/
+	AcceptRules(ctx context.Context, categoryTag string, posterHashes ...string) error
+
+	// GetPages returns every static page without its body, by slug.
+	GetPages(ctx context.Context) ([]*Page, error)
+
+	/*
+		GetPage returns a static page.
+		Should return ErrNotFound if no such page.
+	*/
+	GetPage(ctx context.Context, slug string) (*Page, error)
+
+	// WritePage adds a static page, or replaces the page with its slug.
+	WritePage(ctx context.Context, page *Page) error
+
+	/*
+		RemovePage drops a static page.
+		Should return ErrNotFound if no such page.
+	*/
+	RemovePage(ctx context.Context, slug string) error
+
+	// GetBlockedDomains returns every domain posts can't link to, in order, with how often posts have tried.
+	GetBlockedDomains(ctx context.Context) ([]*BlockedDomain, error)
+
+	// BlockDomain blocks links to a domain, or changes what happens to posts linking to a blocked one.
+	BlockDomain(ctx context.Context, domain string, action DomainAction) (*BlockedDomain, error)
+
+	/*
+		UnblockDomain lets posts link to a blocked domain again.
+		Should return ErrNotFound if it isn't blocked.
+	*/
+	UnblockDomain(ctx context.Context, domain string) error
+
+	// RecordBlockedLinks counts a post blocked for linking to each of the blocked domains.
+	RecordBlockedLinks(ctx context.Context, domains ...string) error
+
+	/*
+		WriteCanary adds a canary thread, setting its ID and taking the category's next number for it.
+		Should return ErrNotFound if no such category.
+	*/
+	WriteCanary(ctx context.Context, canary *Canary) error
+
+	/*
+		GetCanary returns the canary thread with the number on the category.
+		Should return ErrNotFound if it isn't one.
+	*/
+	GetCanary(ctx context.Context, categoryTag string, num int) (*Canary, error)
+
+	// GetCanaries returns every canary thread with how often it's been requested, newest first.
+	GetCanaries(ctx context.Context) ([]*Canary, error)
+
+	/*
+		RecordCanaryHit records a request for a canary thread, setting the hit's category, number and time.
+		Should return ErrNotFound if no such canary.
+	*/
+	RecordCanaryHit(ctx context.Context, hit *CanaryHit) error
+
+	// GetCanaryHits returns up to limit of the latest requests for canary threads, newest first.
+	GetCanaryHits(ctx context.Context, limit int) ([]*CanaryHit, error)
+
+	// LogPIIAccess records staff viewing emails or IPs, setting the record's ID and time.
+	LogPIIAccess(ctx context.Context, access *PIIAccess) error
+
+	// GetPIIAccesses returns up to limit of the latest records of staff viewing emails or IPs, newest first.
+	GetPIIAccesses(ctx context.Context, limit int) ([]*PIIAccess, error)
+
+	// WriteSession records a login's access and refresh tokens, setting the session's ID and times.
+	WriteSession(ctx context.Context, session *Session) error
+
+	/*
+		TouchSession returns the session the token with the hash was issued to, marking it seen now.
+		Should return ErrNotFound if the token wasn't issued by logging in or refreshing here.
+	*/
+	TouchSession(ctx context.Context, tokenHash string) (*Session, error)
+
+	// GetSession returns one of the user's sessions with its refresh token. Should return ErrNotFound if there's no such session.
+	GetSession(ctx context.Context, userID string, id int) (*Session, error)
+
+	/*
+		GetRefreshSession returns the session issued the refresh token, revoked or not.
+		Should return ErrNotFound if no session was.
+	*/
+	GetRefreshSession(ctx context.Context, refreshToken string) (*Session, error)
+
+	/*
+		RenewSession records the access token a refresh issued the session, with its expiry and any new refresh token.
+		Should return ErrNotFound if there's no such session, or it's been revoked.
+	*/
+	RenewSession(ctx context.Context, id int, renewed *Session) error
+
+	// GetSessions returns the user's unexpired, unrevoked sessions, most recently seen first.
+	GetSessions(ctx context.Context, userID string) ([]*Session, error)
+
+	/*
+		RevokeSession revokes one of the user's sessions, refusing every token it was issued from then on.
+		Should return ErrNotFound if the user has no such session, or it's already revoked.
+	*/
+	RevokeSession(ctx context.Context, userID string, id int) error
+
+	/*
+		WriteTOTP starts enrolling the user in two-factor authentication with the secret, replacing one they haven't confirmed.
+		Should return ErrTOTPEnrolled if they've confirmed one.
+	*/
+	WriteTOTP(ctx context.Context, userID string, secret string) error
+
+	// GetTOTP returns the user's two-factor secret. Should return ErrNotFound if they haven't started enrolling.
+	GetTOTP(ctx context.Context, userID string) (*TOTP, error)
+
+	/*
+		UseTOTP records a code for the step being used, confirming the user's secret if it wasn't.
+		Should return ErrTOTPReused if a code for the step or a later one has been used.
+	*/
+	UseTOTP(ctx context.Context, userID string, step int64) error
+
+	/*
+		RemoveTOTP turns off the user's two-factor authentication, unverifying their sessions.
+		Should return ErrNotFound if they haven't started enrolling.
+	*/
+	RemoveTOTP(ctx context.Context, userID string) error
+
+	// VerifySession marks the session with the token hash as having had a two-factor code entered. Should return ErrNotFound if there's no such session.
+	VerifySession(ctx context.Context, tokenHash string) error
+
+	/*
+		LockInactiveThreads locks the threads on categories with LockInactiveDays set that haven't been replied to
+		in that many days, giving them the LockedInactive reason. Returns number of threads locked.
+	*/
+	LockInactiveThreads(ctx context.Context) (int64, error)
+
+	/*
+		GetArchivedCategoryView returns up to limit of the threads archived from a category, newest first,
+		skipping the first offset. The category is as it was when it was removed, if it has been.
+		Should return ErrNotFound if there's no such category, live or removed.
+	*/
+	GetArchivedCategoryView(ctx context.Context, categoryTag string, offset int, limit int) (*CatView, error)
+
+	/*
+		GetArchivedThreadView returns an archived thread and its replies, in thread view order.
+		Should return ErrNotFound if there's no such category or archived thread.
+	*/
+	GetArchivedThreadView(ctx context.Context, categoryTag string, threadNum int) (*ThreadView, error)
+
+	/*
+		QueueAnonymization queues a job anonymizing the category's posts made before a time, counting how many it has to.
+		Should return ErrNotFound if there's no such category.
+	*/
+	QueueAnonymization(ctx context.Context, categoryTag string, before time.Time, queuedBy string) (*AnonymizeJob, error)
+
+	// GetAnonymizeJobs returns up to limit of the latest anonymization jobs, newest first.
+	GetAnonymizeJobs(ctx context.Context, limit int) ([]*AnonymizeJob, error)
+
+	// GetAnonymizeJob returns an anonymization job and its progress. Should return ErrNotFound if there's no such job.
+	GetAnonymizeJob(ctx context.Context, id int) (*AnonymizeJob, error)
+
+	/*
+		AnonymizeBatch anonymizes up to size posts and up to size archived posts for the oldest unfinished
+		anonymization job, recording its progress and marking it done once there's none left.
+		Returns the job as it is after the batch, and the live posts it anonymized as they are now.
+		Should return ErrNotFound if there are no unfinished jobs.
+	*/
+	AnonymizeBatch(ctx context.Context, size int) (*AnonymizeJob, []*Post, error)
 }
 
 var ErrNotFound = errors.New("not found")
+var ErrThreadLocked = errors.New("thread is locked")
+var ErrCategoryArchived = errors.New("category is archived and no longer takes new posts")
+var ErrVersionConflict = errors.New("edited by someone else since, reload and try again")
+var ErrCategoryExists = errors.New("a category with that tag or slug already exists")
+var ErrInvalidCategoryTag = errors.New("category tag must be 1 to 12 lowercase letters or numbers")
+
+// Raised by the database on replies to locked threads.
+const pgThreadLocked = "SC001"
+
+// Raised by the database on posts to archived categories.
+const pgCategoryArchived = "SC002"
 
 // Category contains JSON information describing a Category for posts.
 type Category struct {
-	Tag         string `json:"tag"`
+	Tag string `json:"tag"`
+	// Where the category is found in URLs, the tag unless it's been renamed.
+	Slug        string `json:"slug"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	PostCount   int    `json:"postCount"`
+	// How many threads the category has, only filled in from cached counts.
+	Threads int `json:"threads,omitempty"`
+	// Categories are listed by sort order, lowest first, after featured categories.
+	SortOrder int  `json:"sortOrder"`
+	Featured  bool `json:"featured"`
+	// Archived categories are readable but don't take new posts.
+	Archived bool `json:"archived"`
+	// Bumped on every admin edit, edits must name the version they were made against.
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// What can be attached to posts, so clients can check files before uploading them.
+	Uploads UploadPolicy `json:"uploads"`
+	// Threads with no replies for this many days are locked, zero to leave them open.
+	LockInactiveDays int `json:"lockInactiveDays"`
+	// Whether posters choose to show their usernames, so clients know whether to offer the choice.
+	Usernames UsernamePolicy `json:"usernames"`
+}
+
+// CategoryUpdate holds an admin's edits to a category, nil fields are left as they are.
+type CategoryUpdate struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	SortOrder   *int    `json:"sortOrder"`
+	Featured    *bool   `json:"featured"`
+	Archived    *bool   `json:"archived"`
+	// Zero stops locking inactive threads.
+	LockInactiveDays *int `json:"lockInactiveDays"`
 }
 
 // Post contains JSON information describing a thread, or reply to a thread.
@@ -100,6 +743,33 @@ type Post struct {
 	Content   string    `json:"content"`
 	Username  string    `json:"username"`
 	CreatedAt time.Time `json:"createdAt"`
+	// CreatedAt as seconds since the unix epoch, for clients that would rather not parse timestamps.
+	CreatedAtUnix int64 `json:"createdAtUnix"`
+	// Locked threads can't be replied to.
+	Locked bool `json:"locked,omitempty"`
+	// Why the thread was locked, empty if a moderator locked it.
+	LockedReason string `json:"lockedReason,omitempty"`
+	// Staff role the post was made with, empty for regular posts.
+	Capcode string `json:"capcode,omitempty"`
+	// Reply the thread's author marked as the best answer, zero if none.
+	BestAnswer int `json:"bestAnswer,omitempty"`
+	// Type of thread, empty on replies.
+	Type ThreadType `json:"type,omitempty"`
+	// Question threads are solved once they have a best answer.
+	Solved bool `json:"solved,omitempty"`
+	// How many replies a thread has and when it was last posted in, only filled in on category views.
+	Replies  int        `json:"replies,omitempty"`
+	BumpedAt *time.Time `json:"bumpedAt,omitempty"`
+	// Posts on other categories referenced in the content that exist.
+	CrossRefs   []CrossRef    `json:"crossRefs,omitempty"`
+	Attachments []*Attachment `json:"attachments,omitempty"`
+	// Safe web links in the content, for clients to link without parsing it themselves.
+	Links []validation.Link `json:"links,omitempty"`
+	// Content before words were masked, only filled in for staff.
+	Original string `json:"original,omitempty"`
+	// Random value set when the post's created, and the ContentHash covering it, on thread views.
+	Nonce string `json:"nonce,omitempty"`
+	Hash  string `json:"hash,omitempty"`
 }
 
 // IsReply returns true if this post has a parent.
@@ -107,9 +777,16 @@ func (post Post) IsReply() bool {
 	return post.Parent != 0
 }
 
-// CatView contains JSON information about a category, and all the threads on it.
+// derive fills in what's worked out from the post once it's been read: its unix timestamp and links.
+func (post *Post) derive() {
+	post.CreatedAtUnix = post.CreatedAt.Unix()
+	post.Links = validation.FindLinks(post.Content)
+}
+
+// CatView contains JSON information about a category, its rules, and all the threads on it.
 type CatView struct {
 	Category *Category `json:"category"`
+	Rules    []string  `json:"rules"`
 	Threads  []*Post   `json:"threads"`
 }
 
@@ -122,26 +799,87 @@ type ThreadView struct {
 	Posts    []*Post   `json:"posts"`
 }
 
+// ThreadWriter receives a thread as it's streamed, its category and then batches of its posts.
+type ThreadWriter interface {
+	WriteCategory(category *Category) error
+	WritePosts(posts []*Post) error
+}
+
+// threadCollector keeps a streamed thread in memory.
+type threadCollector struct {
+	view ThreadView
+}
+
+func (tc *threadCollector) WriteCategory(category *Category) error {
+	tc.view.Category = category
+	return nil
+}
+
+func (tc *threadCollector) WritePosts(posts []*Post) error {
+	tc.view.Posts = append(tc.view.Posts, posts...)
+	return nil
+}
+
+/*
+ThreadPage picks which of a thread's replies a view has, so long threads can be read a page at a time.
+The OP is always first, and the zero value is the whole thread.
+*/
+type ThreadPage struct {
+	// Replies skipped, in thread view order.
+	Offset int
+	// Most posts written, the OP included, zero for no limit.
+	Limit int
+	// Only the last this many replies, in place of Offset.
+	Last int
+}
+
+// Posts streamed at once, with their cross references and attachments loaded together.
+const threadStreamBatch = 100
+
 // NewDatastore creates a new data store, creating a connection.
 func NewDatastore(ctx context.Context, pgURL string, maxConns int32) (*DataStore, error) {
+	return NewDatastoreWithRetry(ctx, pgURL, maxConns, NoRetry)
+}
+
+/*
+NewDatastoreWithRetry creates a new data store, retrying the connection with backoff
+until Postgres accepts connections and answers a ping, or the retries run out.
+*/
+func NewDatastoreWithRetry(ctx context.Context, pgURL string, maxConns int32, retry RetryOptions) (*DataStore, error) {
 	conf, err := pgxpool.ParseConfig(pgURL)
 	if err != nil {
 		return nil, fmt.Errorf("pg config parsing failed: %w", err)
 	}
 
 	conf.MaxConns = maxConns
+	conf.AfterConnect = setUpConn
 
-	pgPool, err := pgxpool.ConnectConfig(ctx, conf)
+	var pgPool *pgxpool.Pool
+	err = Retry(ctx, retry, "pg connection", func(ctx context.Context) error {
+		pool, err := pgxpool.ConnectConfig(ctx, conf)
+		if err != nil {
+			return err
+		}
+		// Health gate, don't hand out a pool that can't run queries yet.
+		if err := pool.Ping(ctx); err != nil {
+			pool.Close()
+			return err
+		}
+		pgPool = pool
+		return nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("pg connection failed: %w", err)
 	}
 	return &DataStore{
-		pgPool: pgPool,
+		pgPool: &pool{Pool: pgPool},
 	}, nil
 }
 
 type DataStore struct {
-	pgPool *pgxpool.Pool
+	pgPool    *pool
+	retention *retention
+	pii       *piiCipher
 }
 
 func (store *DataStore) Cleanup(ctx context.Context) error {
@@ -149,36 +887,242 @@ func (store *DataStore) Cleanup(ctx context.Context) error {
 	return nil
 }
 
-func (store *DataStore) EmailMatches(ctx context.Context, categoryTag string, postNum int, email string) (bool, error) {
-	var outEmail string
-	err := store.pgPool.QueryRow(ctx, "SELECT email FROM posts WHERE cat = $1 AND num = $2", categoryTag, postNum).Scan(&outEmail)
+func (store *DataStore) GetPostOwnership(ctx context.Context, categoryTag string, postNum int) (*PostOwnership, error) {
+	ownership := &PostOwnership{}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`SELECT COALESCE(post.author_id, ''), COALESCE(thread.author_id, ''), post.parent FROM posts post
+		LEFT JOIN posts thread ON thread.cat = post.cat
+		AND thread.num = CASE WHEN post.parent = 0 THEN post.num ELSE post.parent END
+		WHERE post.cat = $1 AND post.num = $2`,
+		categoryTag, postNum,
+	).Scan(&ownership.AuthorID, &ownership.ThreadAuthorID, &ownership.Parent)
 	if err != nil {
-		return false, fmt.Errorf("failed to query post email: %w", err)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query post ownership: %w", err)
 	}
-	return outEmail == email, nil
+	return ownership, nil
 }
 
 func (store *DataStore) WriteCategory(ctx context.Context, categoryTag string, categoryName string) error {
-	_, err := store.pgPool.Exec(ctx, "INSERT INTO cats (tag, name) VALUES ($1, $2)", categoryTag, categoryName)
+	_, err := store.pgPool.Exec(ctx, "INSERT INTO cats (tag, name, slug) VALUES ($1, $2, $1)", categoryTag, categoryName)
 	if err != nil {
-		return err
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrCategoryExists
+		}
+		// The tag's format is checked by the cats_tag_format constraint.
+		if errors.As(err, &pgErr) && pgErr.Code == "23514" {
+			return ErrInvalidCategoryTag
+		}
+		return fmt.Errorf("failed to write category: %w", err)
 	}
 	return nil
 }
 
-func (store *DataStore) RemoveCategory(ctx context.Context, categoryTag string) (int64, error) {
-	tag, err := store.pgPool.Exec(ctx, "DELETE FROM cats WHERE tag = $1", categoryTag)
+// RemoveCategoryOptions control how a category's removed.
+type RemoveCategoryOptions struct {
+	// Counts what would be removed without removing anything.
+	DryRun bool
+	// Copies the category and its posts to the archive tables before removing them.
+	Archive bool
+}
+
+// CategoryRemoval counts what removing a category removed, or would have.
+type CategoryRemoval struct {
+	Categories int64 `json:"categories"`
+	Posts      int64 `json:"posts"`
+	Archived   bool  `json:"archived"`
+	DryRun     bool  `json:"dryRun"`
+	// Numbers of the threads removed, for publishing their removal. Empty on dry runs.
+	Threads []int `json:"-"`
+}
+
+func (store *DataStore) RemoveCategory(ctx context.Context, categoryTag string, opts RemoveCategoryOptions) (*CategoryRemoval, error) {
+	removal := &CategoryRemoval{Archived: opts.Archive, DryRun: opts.DryRun}
+	if opts.DryRun {
+		err := store.pgPool.QueryRow(
+			ctx,
+			"SELECT (SELECT COUNT(*) FROM cats WHERE tag = $1), (SELECT COUNT(*) FROM posts WHERE cat = $1)",
+			categoryTag,
+		).Scan(&removal.Categories, &removal.Posts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count category removal: %w", err)
+		}
+		return removal, nil
+	}
+
+	tx, err := store.pgPool.Begin(ctx)
 	if err != nil {
-		return tag.RowsAffected(), err
+		return nil, fmt.Errorf("failed to begin category removal: %w", err)
 	}
-	return tag.RowsAffected(), nil
+	defer tx.Rollback(ctx)
+
+	if opts.Archive {
+		_, err = tx.Exec(
+			ctx,
+			"INSERT INTO category_archive (tag, category) SELECT tag, to_jsonb(cats) FROM cats WHERE tag = $1",
+			categoryTag,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to archive category: %w", err)
+		}
+		_, err = tx.Exec(
+			ctx,
+			"INSERT INTO post_archive (cat, num, post) SELECT cat, num, to_jsonb(posts) FROM posts WHERE cat = $1",
+			categoryTag,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to archive category posts: %w", err)
+		}
+	} else if store.retention != nil {
+		// Posts that aren't archived are kept in retention like any other deleted post, a thread at a time.
+		rows, err := tx.Query(ctx, "SELECT num FROM posts WHERE cat = $1 AND parent = 0", categoryTag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query category threads to retain: %w", err)
+		}
+		var threads []int
+		for rows.Next() {
+			var num int
+			if err := rows.Scan(&num); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to parse a category thread to retain: %w", err)
+			}
+			threads = append(threads, num)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to query category threads to retain: %w", err)
+		}
+		for _, num := range threads {
+			if err := store.retention.retain(ctx, tx, store.pii, categoryTag, num); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows, err := tx.Query(ctx, "DELETE FROM posts WHERE cat = $1 RETURNING num, parent", categoryTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove category posts: %w", err)
+	}
+	for rows.Next() {
+		var num, parent int
+		if err := rows.Scan(&num, &parent); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to parse a removed category post: %w", err)
+		}
+		removal.Posts++
+		if parent == 0 {
+			removal.Threads = append(removal.Threads, num)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to remove category posts: %w", err)
+	}
+	res, err := tx.Exec(ctx, "DELETE FROM cats WHERE tag = $1", categoryTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove category: %w", err)
+	}
+	removal.Categories = res.RowsAffected()
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit category removal: %w", err)
+	}
+	return removal, nil
+}
+
+func (store *DataStore) SetCategoryListing(ctx context.Context, categoryTag string, sortOrder int, featured bool) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE cats SET sort_order = $2, featured = $3, version = version + 1, updated_at = now() WHERE tag = $1",
+		categoryTag,
+		sortOrder,
+		featured,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set category listing: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *DataStore) SetCategoryArchived(ctx context.Context, categoryTag string, archived bool) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE cats SET archived = $2, version = version + 1, updated_at = now() WHERE tag = $1",
+		categoryTag,
+		archived,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set category archived: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *DataStore) UpdateCategory(ctx context.Context, categoryTag string, version int, update *CategoryUpdate) (*Category, error) {
+	cat := &Category{Tag: categoryTag}
+	err := store.pgPool.QueryRow(
+		ctx,
+		`UPDATE cats SET
+		name = COALESCE($3, name),
+		description = COALESCE($4, description),
+		sort_order = COALESCE($5, sort_order),
+		featured = COALESCE($6, featured),
+		archived = COALESCE($7, archived),
+		lock_inactive_days = COALESCE($8, lock_inactive_days),
+		version = version + 1,
+		updated_at = now()
+		WHERE tag = $1 AND version = $2
+		RETURNING slug, name, description, post_count, sort_order, featured, archived, version, updated_at,
+		max_file_bytes, allowed_types, max_files, storage_quota_bytes, prune_oldest, lock_inactive_days,
+		username_policy`,
+		categoryTag,
+		version,
+		update.Name,
+		update.Description,
+		update.SortOrder,
+		update.Featured,
+		update.Archived,
+		update.LockInactiveDays,
+	).Scan(
+		&cat.Slug, &cat.Name, &cat.Description, &cat.PostCount, &cat.SortOrder,
+		&cat.Featured, &cat.Archived, &cat.Version, &cat.UpdatedAt,
+		&cat.Uploads.MaxFileBytes, &cat.Uploads.AllowedTypes, &cat.Uploads.MaxFiles,
+		&cat.Uploads.QuotaBytes, &cat.Uploads.PruneOldest, &cat.LockInactiveDays, &cat.Usernames,
+	)
+	if err == nil {
+		return cat, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to update category: %w", err)
+	}
+
+	// Nothing matched, either the category's gone or it's moved on from the version.
+	var exists bool
+	err = store.pgPool.QueryRow(ctx, "SELECT EXISTS (SELECT FROM cats WHERE tag = $1)", categoryTag).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check category for update: %w", err)
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return nil, ErrVersionConflict
 }
 
 func (store *DataStore) GetThreadCount(ctx context.Context, categoryTag string) (int, error) {
 	var count int
 	err := store.pgPool.QueryRow(
 		ctx,
-		"SELECT COUNT (*) FROM posts WHERE cat = $1 AND parent = 0",
+		"SELECT COUNT (*) FROM posts WHERE cat = $1 AND parent = 0 AND NOT quarantined",
 		categoryTag,
 	).Scan(&count)
 	if err != nil {
@@ -190,7 +1134,10 @@ func (store *DataStore) GetThreadCount(ctx context.Context, categoryTag string)
 func (store *DataStore) GetCategories(ctx context.Context) ([]*Category, error) {
 	rows, err := store.pgPool.Query(
 		ctx,
-		"SELECT tag, name, description, post_count FROM cats",
+		`SELECT tag, slug, name, description, post_count, sort_order, featured, archived, version, updated_at,
+		max_file_bytes, allowed_types, max_files, storage_quota_bytes, prune_oldest, lock_inactive_days,
+		username_policy
+		FROM cats ORDER BY featured DESC, sort_order ASC, tag ASC`,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query categories: %w", err)
@@ -200,7 +1147,12 @@ func (store *DataStore) GetCategories(ctx context.Context) ([]*Category, error)
 	var cats []*Category = make([]*Category, 0)
 	for rows.Next() {
 		var c Category
-		err := rows.Scan(&c.Tag, &c.Name, &c.Description, &c.PostCount)
+		err := rows.Scan(
+			&c.Tag, &c.Slug, &c.Name, &c.Description, &c.PostCount, &c.SortOrder,
+			&c.Featured, &c.Archived, &c.Version, &c.UpdatedAt,
+			&c.Uploads.MaxFileBytes, &c.Uploads.AllowedTypes, &c.Uploads.MaxFiles,
+			&c.Uploads.QuotaBytes, &c.Uploads.PruneOldest, &c.LockInactiveDays, &c.Usernames,
+		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse a queried category: %w", err)
 		}
@@ -210,67 +1162,154 @@ func (store *DataStore) GetCategories(ctx context.Context) ([]*Category, error)
 }
 
 func (store *DataStore) GetPostByNumber(ctx context.Context, categoryTag string, num int) (*Post, error) {
-	row := store.pgPool.QueryRow(
-		ctx,
-		"SELECT num, cat, content, subject, parent, username, created_at FROM posts WHERE cat = $1 AND num = $2",
-		categoryTag,
-		num,
-	)
+	row := store.pgPool.QueryRow(ctx, stmtGetPostByNumber, categoryTag, num)
 
 	var p Post
-	err := row.Scan(&p.Num, &p.Cat, &p.Content, &p.Subject, &p.Parent, &p.Username, &p.CreatedAt)
+	err := row.Scan(&p.Num, &p.Cat, &p.Content, &p.Subject, &p.Parent, &p.Username, &p.CreatedAt, &p.Locked)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("failed to parse a post by number: %w", err)
 	}
+	p.derive()
 	return &p, nil
 }
 
 func (store *DataStore) GetThreadView(ctx context.Context, categoryTag string, threadNum int) (*ThreadView, error) {
+	collector := &threadCollector{}
+	_, err := store.StreamThreadView(ctx, categoryTag, threadNum, ThreadPage{}, collector)
+	if err != nil {
+		return nil, err
+	}
+	return &collector.view, nil
+}
 
+/*
+StreamThreadView reads the thread a batch at a time, each batch's query finished before its cross references and
+attachments are loaded, so a stream never holds more than one of the pool's connections.
+*/
+func (store *DataStore) StreamThreadView(ctx context.Context, categoryTag string, threadNum int, page ThreadPage, w ThreadWriter) (bool, error) {
 	category, err := store.GetCategory(ctx, categoryTag)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	skip, err := store.threadPageSkip(ctx, category.Tag, threadNum, page)
+	if err != nil {
+		return false, err
+	}
+
+	limit := page.Limit
+	written := 0
+	for {
+		size := threadStreamBatch
+		// Reading one past the limit tells whether there's more.
+		if limit > 0 && limit-written+1 < size {
+			size = limit - written + 1
+		}
+		// The OP's read on its own when replies are skipped, the skipped replies being right after it.
+		offset := written
+		if written > 0 {
+			offset += skip
+		} else if skip > 0 {
+			size = 1
+		}
+		posts, err := store.getThreadBatch(ctx, category.Tag, threadNum, offset, size)
+		if err != nil {
+			return false, err
+		}
+		more := limit > 0 && written+len(posts) > limit
+		if more {
+			posts = posts[:limit-written]
+		}
+		if len(posts) == 0 {
+			if more {
+				return true, nil
+			}
+			break
+		}
+
+		if written == 0 {
+			err = w.WriteCategory(category)
+			if err != nil {
+				return false, err
+			}
+		}
+		err = store.attachCrossRefs(ctx, category.Tag, posts)
+		if err != nil {
+			return false, err
+		}
+		err = store.attachAttachments(ctx, category.Tag, posts)
+		if err != nil {
+			return false, err
+		}
+		err = w.WritePosts(posts)
+		if err != nil {
+			return false, err
+		}
+		written += len(posts)
+		if more {
+			return true, nil
+		}
+		if len(posts) < size {
+			break
+		}
+	}
+	if written == 0 {
+		return false, ErrNotFound
 	}
+	return false, nil
+}
 
-	replyRows, err := store.pgPool.Query(
+// threadPageSkip returns how many replies the page skips.
+func (store *DataStore) threadPageSkip(ctx context.Context, categoryTag string, threadNum int, page ThreadPage) (int, error) {
+	if page.Last <= 0 {
+		return page.Offset, nil
+	}
+	var replies int
+	err := store.pgPool.QueryRow(
 		ctx,
-		"select num, cat, content, subject, parent, username, created_at FROM posts WHERE cat = $1 AND (num = $2 or parent = $2) ORDER BY NUM ASC;",
-		category.Tag,
+		"SELECT count(*) FROM posts WHERE cat = $1 AND parent = $2 AND NOT quarantined",
+		categoryTag,
 		threadNum,
-	)
+	).Scan(&replies)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count thread replies: %w", err)
+	}
+	if replies <= page.Last {
+		return 0, nil
+	}
+	return replies - page.Last, nil
+}
+
+// getThreadBatch returns up to limit of a thread's posts, skipping the first offset, in thread view order.
+func (store *DataStore) getThreadBatch(ctx context.Context, categoryTag string, threadNum int, offset int, limit int) ([]*Post, error) {
+	rows, err := store.pgPool.Query(ctx, stmtGetThreadBatch, categoryTag, threadNum, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query thread: %w", err)
 	}
-	defer replyRows.Close()
+	defer rows.Close()
 
-	var posts []*Post = make([]*Post, 0)
-	for replyRows.Next() {
+	posts := make([]*Post, 0, limit)
+	for rows.Next() {
 		post := &Post{}
-		err := replyRows.Scan(&post.Num, &post.Cat, &post.Content, &post.Subject, &post.Parent, &post.Username, &post.CreatedAt)
+		err := rows.Scan(
+			&post.Num, &post.Cat, &post.Content, &post.Subject, &post.Parent, &post.Username,
+			&post.CreatedAt, &post.Locked, &post.LockedReason, &post.Capcode, &post.BestAnswer, &post.Type, &post.Solved,
+			&post.Nonce,
+		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse thread reply: %w", err)
 		}
+		post.derive()
+		post.Hash = post.ContentHash()
 		posts = append(posts, post)
 	}
-	if len(posts) == 0 {
-		return nil, ErrNotFound
-	}
-
-	return &ThreadView{
-		Category: category,
-		Posts:    posts,
-	}, nil
+	return posts, rows.Err()
 }
 
 func (store *DataStore) GetCategory(ctx context.Context, categoryTag string) (*Category, error) {
-	rows, err := store.pgPool.Query(
-		ctx,
-		"SELECT name, description, post_count FROM cats WHERE tag = $1",
-		categoryTag,
-	)
+	rows, err := store.pgPool.Query(ctx, stmtGetCategory, categoryTag)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query a category: %w", err)
 	}
@@ -280,7 +1319,12 @@ func (store *DataStore) GetCategory(ctx context.Context, categoryTag string) (*C
 		Tag: categoryTag,
 	}
 	if rows.Next() {
-		rows.Scan(&cat.Name, &cat.Description, &cat.PostCount)
+		rows.Scan(
+			&cat.Slug, &cat.Name, &cat.Description, &cat.PostCount, &cat.SortOrder,
+			&cat.Featured, &cat.Archived, &cat.Version, &cat.UpdatedAt,
+			&cat.Uploads.MaxFileBytes, &cat.Uploads.AllowedTypes, &cat.Uploads.MaxFiles,
+			&cat.Uploads.QuotaBytes, &cat.Uploads.PruneOldest, &cat.LockInactiveDays, &cat.Usernames,
+		)
 		return cat, nil
 	}
 	return nil, ErrNotFound
@@ -294,7 +1338,10 @@ func (store *DataStore) GetCategoryView(ctx context.Context, categoryTag string)
 
 	rows, err := store.pgPool.Query(
 		ctx,
-		"SELECT num, cat, content, subject, username, created_at FROM posts WHERE cat = $1 AND parent = 0 ORDER BY num ASC",
+		`SELECT p.num, p.cat, p.content, p.subject, p.username, p.created_at, p.locked, p.locked_reason, p.capcode,
+		p.best_answer, p.thread_type, p.thread_type = 'question' AND p.best_answer != 0, c.replies, c.bumped_at
+		FROM thread_catalog c JOIN posts p ON p.cat = c.cat AND p.num = c.num
+		WHERE c.cat = $1 AND NOT p.quarantined ORDER BY c.num ASC`,
 		categoryTag,
 	)
 	if err != nil {
@@ -305,14 +1352,34 @@ func (store *DataStore) GetCategoryView(ctx context.Context, categoryTag string)
 	var posts []*Post = make([]*Post, 0)
 	for rows.Next() {
 		post := &Post{}
-		err := rows.Scan(&post.Num, &post.Cat, &post.Content, &post.Subject, &post.Username, &post.CreatedAt)
+		err := rows.Scan(
+			&post.Num, &post.Cat, &post.Content, &post.Subject, &post.Username,
+			&post.CreatedAt, &post.Locked, &post.LockedReason, &post.Capcode, &post.BestAnswer, &post.Type, &post.Solved,
+			&post.Replies, &post.BumpedAt,
+		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse a queried category view: %w", err)
 		}
+		post.derive()
 		posts = append(posts, post)
 	}
+	rows.Close()
+
+	err = store.attachCrossRefs(ctx, categoryTag, posts)
+	if err != nil {
+		return nil, err
+	}
+	err = store.attachAttachments(ctx, categoryTag, posts)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := store.GetCategoryRules(ctx, categoryTag)
+	if err != nil {
+		return nil, err
+	}
 	return &CatView{
 		Threads:  posts,
+		Rules:    rules,
 		Category: cat,
 	}, nil
 }
@@ -323,51 +1390,136 @@ func (store *DataStore) WritePost(
 	parentThreadNumber int,
 	subject string,
 	content string,
-	username string,
-	email string,
-	ip string,
-) error {
-	_, err := store.pgPool.Exec(
+	author *Identity,
+	capcode string,
+	threadType ThreadType,
+	attachments []AttachmentRef,
+) (int, error) {
+	if len(threadType) == 0 || parentThreadNumber != 0 {
+		threadType = ThreadDiscussion
+	}
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin post write: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Missing categories are left to write_post to refuse.
+	var masks []string
+	err = tx.QueryRow(ctx, "SELECT masked_words FROM cats WHERE tag = $1", categoryTag).Scan(&masks)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("failed to query category masks: %w", err)
+	}
+	masked := MaskWords(content, masks)
+
+	email, err := store.pii.seal(author.Email)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt poster email: %w", err)
+	}
+	ip, err := store.pii.seal(author.IP)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt poster IP: %w", err)
+	}
+
+	var num int
+	err = tx.QueryRow(
 		ctx,
-		"CALL write_post($1, $2::int, $3, $4, $5, $6, $7)",
+		"SELECT write_post($1, $2::int, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
 		categoryTag,
 		parentThreadNumber,
-		content,
+		masked,
 		subject,
-		username,
+		author.Username,
 		email,
 		ip,
-	)
+		capcode,
+		author.ID,
+		string(threadType),
+		PosterHash(author.IP),
+		PosterHash(author.Email),
+	).Scan(&num)
 
 	// Catch foreign-key violations and return a human-readable message.
 	// Assumes all FK violations are invalid post categories.
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
-			return ErrNotFound
+			return 0, ErrNotFound
+		}
+		if errors.As(err, &pgErr) && pgErr.Code == pgThreadLocked {
+			return 0, ErrThreadLocked
 		}
-		return fmt.Errorf("failed to execute post write: %w", err)
+		if errors.As(err, &pgErr) && pgErr.Code == pgCategoryArchived {
+			return 0, ErrCategoryArchived
+		}
+		return 0, fmt.Errorf("failed to execute post write: %w", err)
 	}
-	return nil
+
+	if masked != content {
+		_, err = tx.Exec(ctx, "UPDATE posts SET original_content = $3 WHERE cat = $1 AND num = $2", categoryTag, num, content)
+		if err != nil {
+			return 0, fmt.Errorf("failed to keep original content: %w", err)
+		}
+	}
+
+	err = writeCrossRefs(ctx, tx, categoryTag, num, parseCrossRefs(masked))
+	if err != nil {
+		return 0, err
+	}
+	err = claimAttachments(ctx, tx, categoryTag, num, author.ID, attachments)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit post write: %w", err)
+	}
+	return num, nil
 }
 
 func (store *DataStore) RemovePost(ctx context.Context, categoryTag string, number int) (int, error) {
-	res, err := store.pgPool.Exec(ctx, "DELETE FROM posts WHERE cat = $1 AND num = $2", categoryTag, number)
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin post delete: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	removed, err := store.removePost(ctx, tx, categoryTag, number)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit post delete: %w", err)
+	}
+	return removed, nil
+
+}
+
+// removePost deletes a post inside a transaction, retaining it first if retention is enabled.
+func (store *DataStore) removePost(ctx context.Context, tx pgx.Tx, categoryTag string, number int) (int, error) {
+	if store.retention != nil {
+		err := store.retention.retain(ctx, tx, store.pii, categoryTag, number)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec(ctx, "DELETE FROM posts WHERE cat = $1 AND num = $2", categoryTag, number)
 	if err != nil {
 		return 0, fmt.Errorf("failed to delete post: %w", err)
 	}
 	return (int)(res.RowsAffected()), nil
-
 }
 
-func (store *DataStore) GetPostsByEmail(ctx context.Context, email string) ([]*Post, error) {
+func (store *DataStore) GetPostsByAuthor(ctx context.Context, authorID string) ([]*Post, error) {
 	rows, err := store.pgPool.Query(
 		ctx,
-		"SELECT num, cat, content, subject, username, created_at FROM posts WHERE email = $1",
-		email,
+		"SELECT num, cat, content, subject, username, created_at FROM posts WHERE author_id = $1",
+		authorID,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get posts by email: %w", err)
+		return nil, fmt.Errorf("failed to get posts by author: %w", err)
 	}
 
 	var posts []*Post = make([]*Post, 0)
@@ -377,6 +1529,54 @@ func (store *DataStore) GetPostsByEmail(ctx context.Context, email string) ([]*P
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse a queried category view: %w", err)
 		}
+		post.derive()
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+func (store *DataStore) ClaimPosts(ctx context.Context, author *Identity) (int64, error) {
+	if len(author.ID) == 0 {
+		return 0, nil
+	}
+	tag, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE posts SET author_id = $1 WHERE author_id = $2 AND email_hash = $2",
+		author.ID, author.EmailHash(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim posts: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (store *DataStore) SearchPosts(ctx context.Context, query string, categoryTag string, unanswered bool, limit int) ([]*Post, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		`SELECT num, cat, content, subject, parent, username, created_at FROM posts
+		WHERE to_tsvector('simple', subject || ' ' || content) @@ plainto_tsquery('simple', $1)
+		AND ($2 = '' OR cat = $2) AND NOT quarantined
+		AND (NOT $4 OR (parent = 0 AND thread_type = 'question' AND best_answer = 0))
+		ORDER BY ts_rank(to_tsvector('simple', subject || ' ' || content), plainto_tsquery('simple', $1)) DESC, created_at DESC
+		LIMIT $3`,
+		query,
+		categoryTag,
+		limit,
+		unanswered,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []*Post = make([]*Post, 0)
+	for rows.Next() {
+		post := &Post{}
+		err := rows.Scan(&post.Num, &post.Cat, &post.Content, &post.Subject, &post.Parent, &post.Username, &post.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a searched post: %w", err)
+		}
+		post.derive()
 		posts = append(posts, post)
 	}
 	return posts, nil
@@ -404,5 +1604,8 @@ func (store *DataStore) Migrate(ctx context.Context, up bool) error {
 	if err != nil {
 		return fmt.Errorf("failed to migrate db: %w", err)
 	}
+	if up {
+		return store.rehashPosters(ctx)
+	}
 	return nil
 }
@@ -1,11 +1,17 @@
 package data
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"spiritchat/config"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // Should return true if a post is a reply in the DB.
@@ -48,13 +54,61 @@ func TestIntegrations(t *testing.T) {
 	defer store.Cleanup(ctx)
 
 	integrationTests := map[string]func(context.Context, *DataStore) func(t *testing.T){
-		"Post writes":        integration_WritePosts,
-		"Get Category View":  integration_GetCategoryView,
-		"Get Categories":     integration_GetCategories,
-		"Get Post by Number": integration_GetPostByNumber,
-		"Get Thread View":    integration_GetThreadView,
-		"Remove Posts":       integration_RemovePost,
-		"Get Posts by Email": integration_GetPostsByEmail,
+		"Post writes":                  integration_WritePosts,
+		"Get Category View":            integration_GetCategoryView,
+		"Get Categories":               integration_GetCategories,
+		"Get Post by Number":           integration_GetPostByNumber,
+		"Get Thread View":              integration_GetThreadView,
+		"Remove Posts":                 integration_RemovePost,
+		"Get Posts by Author":          integration_GetPostsByAuthor,
+		"Search Posts":                 integration_SearchPosts,
+		"Retention":                    integration_Retention,
+		"Poster History":               integration_GetPosterHistory,
+		"Moderation Queue":             integration_ModerationQueue,
+		"Bulk Actions":                 integration_BulkActions,
+		"Rules":                        integration_Rules,
+		"Notes":                        integration_Notes,
+		"Category Rules":               integration_CategoryRules,
+		"Pages":                        integration_Pages,
+		"Post Ownership":               integration_PostOwnership,
+		"Thread Author":                integration_ThreadAuthor,
+		"Questions":                    integration_Questions,
+		"Cross References":             integration_CrossRefs,
+		"Category Slugs":               integration_CategorySlugs,
+		"Category Listing":             integration_CategoryListing,
+		"Category Archived":            integration_CategoryArchived,
+		"Remove Category":              integration_RemoveCategory,
+		"Category Versions":            integration_CategoryVersions,
+		"Category Masks":               integration_CategoryMasks,
+		"Post Policy":                  integration_PostPolicy,
+		"Attachments":                  integration_Attachments,
+		"Storage Usage":                integration_StorageUsage,
+		"Attachment Flags":             integration_AttachmentFlags,
+		"Collect Orphaned Attachments": integration_CollectOrphanedAttachments,
+		"Account First Seen":           integration_AccountFirstSeen,
+		"Preferences":                  integration_Preferences,
+		"Messages":                     integration_Messages,
+		"Mentions":                     integration_Mentions,
+		"Bookmarks":                    integration_Bookmarks,
+		"Share Links":                  integration_ShareLinks,
+		"Digests":                      integration_Digests,
+		"Stats":                        integration_Stats,
+		"Impersonation":                integration_Impersonation,
+		"Index Audit":                  integration_IndexAudit,
+		"Bulk Import and Prune":        integration_BulkImportPrune,
+		"Thread Catalog":               integration_ThreadCatalog,
+		"Check Post Counts":            integration_CheckPostCounts,
+		"Complaints":                   integration_Complaints,
+		"ClientKeys":                   integration_ClientKeys,
+		"Changes":                      integration_Changes,
+		"Webhooks":                     integration_Webhooks,
+		"Confirmations":                integration_Confirmations,
+		"Blocked Domains":              integration_BlockedDomains,
+		"Canaries":                     integration_Canaries,
+		"PIIEncryption":                integration_PIIEncryption,
+		"Anonymize":                    integration_Anonymize,
+		"Sessions":                     integration_Sessions,
+		"Rehash Posters":               integration_RehashPosters,
 	}
 
 	for name, fn := range integrationTests {
@@ -89,18 +143,19 @@ func integration_GetThreadView(ctx context.Context, store *DataStore) func(t *te
 			t.Errorf("expected ErrNotFound, got: %v", err)
 		}
 
-		testCategories := map[string]string{"bbb": "vvv", "vvv": "ccc", "ccc": "ddd"}
-		tests := map[string]int{
-			"bbb": 5,
-			"vvv": 15,
-			"ccc": 0,
+		tests := []struct {
+			tag     string
+			replies int
+		}{
+			{"bbb", 5},
+			{"vvv", 15},
+			{"ccc", 0},
 		}
-
-		err = createTestCategories(ctx, store, testCategories)
-		if err != nil {
-			t.Error(err)
+		var categories []*categoryFixture
+		for _, test := range tests {
+			categories = append(categories, newCategory(test.tag).WithThreads(3).WithReplies(test.replies))
 		}
-		defer removeTestCategories(ctx, store, testCategories)
+		written := setUpFixtures(ctx, t, store, categories...)
 
 		// invalid
 		_, err = store.GetThreadView(ctx, "nothing", 0)
@@ -108,31 +163,50 @@ func integration_GetThreadView(ctx context.Context, store *DataStore) func(t *te
 			t.Errorf("expected ErrNotFound, got: %v", err)
 		}
 
-		opCount := 3
-		for tag, replyCount := range tests {
-			// create OPs
-			for i := 0; i < opCount; i++ {
-				err := store.WritePost(ctx, tag, 0, "abc", "bdef", "a", "b", "c")
+		for _, test := range tests {
+			for _, thread := range written.Threads[test.tag] {
+				view, err := store.GetThreadView(ctx, test.tag, thread)
 				if err != nil {
-					t.Error(err)
+					t.Fatal(err)
 				}
-			}
-
-			opNum := opCount - 1
-			// create replies to an op
-			for i := 0; i < replyCount; i++ {
-				err := store.WritePost(ctx, tag, opNum, "abc", "bdef", "a", "b", "c")
-				if err != nil {
-					t.Error(err)
+				if len(view.Posts) != test.replies+1 {
+					t.Errorf("expected %d posts, got: %d", test.replies+1, len(view.Posts))
 				}
 			}
+		}
 
-			view, err := store.GetThreadView(ctx, tag, opNum)
+		// vvv's threads have 15 replies.
+		thread := written.Threads["vvv"][0]
+		full, err := store.GetThreadView(ctx, "vvv", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := map[string]struct {
+			page  ThreadPage
+			first int
+			posts int
+			more  bool
+		}{
+			"offset":         {ThreadPage{Offset: 10}, 11, 6, false},
+			"offset limit":   {ThreadPage{Offset: 4, Limit: 4}, 5, 4, true},
+			"last":           {ThreadPage{Last: 3}, 13, 4, false},
+			"last limit":     {ThreadPage{Last: 6, Limit: 2}, 10, 2, true},
+			"past the end":   {ThreadPage{Offset: 20}, 0, 1, false},
+			"more than last": {ThreadPage{Last: 30}, 1, 16, false},
+		}
+		for name, test := range pages {
+			collector := &threadCollector{}
+			more, err := store.StreamThreadView(ctx, "vvv", thread, test.page, collector)
 			if err != nil {
-				t.Error(err)
+				t.Fatalf("%s: %v", name, err)
+			}
+			posts := collector.view.Posts
+			if len(posts) != test.posts || more != test.more || posts[0].Num != thread {
+				t.Errorf("%s: expected the OP and %d more posts, more %v, got %d, more %v", name, test.posts-1, test.more, len(posts), more)
+				continue
 			}
-			if len(view.Posts) != replyCount+1 {
-				t.Errorf("expected %d posts, got: %d", replyCount+1, len(view.Posts))
+			if len(posts) > 1 && posts[1].Num != full.Posts[test.first].Num {
+				t.Errorf("%s: expected the page to start at post %d, got %d", name, full.Posts[test.first].Num, posts[1].Num)
 			}
 		}
 	}
@@ -140,26 +214,17 @@ func integration_GetThreadView(ctx context.Context, store *DataStore) func(t *te
 
 func integration_RemovePost(ctx context.Context, store *DataStore) func(t *testing.T) {
 	return func(t *testing.T) {
-		testCategories := map[string]string{
-			"beep": "boop",
-			"bonk": "fonk",
-		}
-
-		err := createTestCategories(ctx, store, testCategories)
-		if err != nil {
-			t.Error(err)
-		}
-		defer removeTestCategories(ctx, store, testCategories)
+		setUpFixtures(ctx, t, store, newCategory("beep").Named("boop"), newCategory("bonk").Named("fonk"))
 
 		// write parent
-		err = store.WritePost(ctx, "beep", 0, "subject", "content", "username", "email", "ip")
+		_, err := store.WritePost(ctx, "beep", 0, "subject", "content", &Identity{Username: "username", Email: "email", IP: "ip"}, "", "", nil)
 		if err != nil {
 			t.Error(err)
 		}
 
 		// write unrelated parent
 		expectSubject := "UNRELATED POST"
-		err = store.WritePost(ctx, "beep", 0, expectSubject, "content", "username", "email", "ip")
+		_, err = store.WritePost(ctx, "beep", 0, expectSubject, "content", &Identity{Username: "username", Email: "email", IP: "ip"}, "", "", nil)
 		if err != nil {
 			t.Error(err)
 		}
@@ -167,7 +232,7 @@ func integration_RemovePost(ctx context.Context, store *DataStore) func(t *testi
 		// write replies
 		replyCount := 20
 		for i := 0; i < replyCount; i++ {
-			err = store.WritePost(ctx, "beep", 1, "subject", "content", "username", "email", "ip")
+			_, err = store.WritePost(ctx, "beep", 1, "subject", "content", &Identity{Username: "username", Email: "email", IP: "ip"}, "", "", nil)
 			if err != nil {
 				t.Error(err)
 			}
@@ -202,20 +267,11 @@ func integration_RemovePost(ctx context.Context, store *DataStore) func(t *testi
 
 func integration_GetPostByNumber(ctx context.Context, store *DataStore) func(t *testing.T) {
 	return func(t *testing.T) {
-
-		testCategories := map[string]string{
-			"beep": "boop",
-			"bonk": "fonk",
-		}
-		err := createTestCategories(ctx, store, testCategories)
-		if err != nil {
-			t.Error(err)
-		}
-		defer removeTestCategories(ctx, store, testCategories)
+		setUpFixtures(ctx, t, store, newCategory("beep").Named("boop"), newCategory("bonk").Named("fonk"))
 
 		expectContent := "beepboop"
-		for tag := range testCategories {
-			err = store.WritePost(ctx, tag, 0, "hey", expectContent, "a", "b", "c")
+		for _, tag := range []string{"beep", "bonk"} {
+			_, err := store.WritePost(ctx, tag, 0, "hey", expectContent, &Identity{Username: "a", Email: "b", IP: "c"}, "", "", nil)
 			if err != nil {
 				t.Error(err)
 			}
@@ -230,7 +286,7 @@ func integration_GetPostByNumber(ctx context.Context, store *DataStore) func(t *
 		}
 
 		// test invalid post
-		_, err = store.GetPostByNumber(ctx, "i dont exist", 0)
+		_, err := store.GetPostByNumber(ctx, "i dont exist", 0)
 		if err == nil || !errors.Is(err, ErrNotFound) {
 			t.Errorf("expected ErrNotFound, got: %v", err)
 		}
@@ -239,22 +295,18 @@ func integration_GetPostByNumber(ctx context.Context, store *DataStore) func(t *
 
 func integration_GetCategories(ctx context.Context, store *DataStore) func(t *testing.T) {
 	return func(t *testing.T) {
-		tests := map[string]map[string]string{
+		tests := map[string][]*categoryFixture{
 			"Some categories": {
-				"xxxx": "zzzz",
-				"aaaa": "bbbb",
-				"vvvv": "eeeee",
+				newCategory("xxxx").Named("zzzz"),
+				newCategory("aaaa").Named("bbbb"),
+				newCategory("vvvv").Named("eeeee"),
 			},
 			"No categories": {},
 		}
 
 		for name, categories := range tests {
 			t.Run(name, func(t *testing.T) {
-				err := createTestCategories(ctx, store, categories)
-				if err != nil {
-					t.Error(err)
-				}
-				defer removeTestCategories(ctx, store, categories)
+				setUpFixtures(ctx, t, store, categories...)
 
 				cats, err := store.GetCategories(ctx)
 				if err != nil {
@@ -266,8 +318,8 @@ func integration_GetCategories(ctx context.Context, store *DataStore) func(t *te
 				for i := 0; i < len(cats); i++ {
 					has := false
 
-					for tag := range categories {
-						if cats[i].Tag == tag {
+					for _, cat := range categories {
+						if cats[i].Tag == cat.tag {
 							has = true
 						}
 					}
@@ -282,31 +334,11 @@ func integration_GetCategories(ctx context.Context, store *DataStore) func(t *te
 
 func integration_GetCategoryView(ctx context.Context, store *DataStore) func(t *testing.T) {
 	return func(t *testing.T) {
-
 		catName := "beep"
-		testCategories := map[string]string{catName: "best"}
 		threadCount := 5
 
-		// store a category
-		err := createTestCategories(ctx, store, testCategories)
-		if err != nil {
-			t.Error(err)
-		}
-		defer removeTestCategories(ctx, store, testCategories)
-
-		// write a thread into the category
-		for i := 0; i < threadCount; i++ {
-			err = store.WritePost(ctx, catName, 0, "beep", "boop", "a", "b", "c")
-			if err != nil {
-				t.Error(err)
-			}
-		}
-
-		// write a reply to that post
-		err = store.WritePost(ctx, catName, 1, "beep", "boop", "a", "b", "c")
-		if err != nil {
-			t.Error(err)
-		}
+		// replies shouldn't count as threads
+		setUpFixtures(ctx, t, store, newCategory(catName).Named("best").WithThreads(threadCount).WithReplies(1))
 
 		// GetCategoryView should return the category, the post, but no replies
 		view, err := store.GetCategoryView(ctx, catName)
@@ -325,28 +357,43 @@ func integration_GetCategoryView(ctx context.Context, store *DataStore) func(t *
 	}
 }
 
-func integration_GetPostsByEmail(ctx context.Context, store *DataStore) func(t *testing.T) {
+func integration_GetPostsByAuthor(ctx context.Context, store *DataStore) func(t *testing.T) {
 	return func(t *testing.T) {
-		testCategoryTag := "test-category"
-		testCategories := map[string]string{testCategoryTag: "test"}
+		testCategoryTag := "testcat"
+		expectID := "auth0|cool"
 		expectEmail := "coolemail@example.com"
 		expectContent := "beep"
-		createTestCategories(ctx, store, testCategories)
-		defer removeTestCategories(ctx, store, testCategories)
+		setUpFixtures(ctx, t, store, newCategory(testCategoryTag).Named("test"))
 
 		postCount := 15
-		err := store.WritePost(ctx, testCategoryTag, 0, "subject", "otherContent", "username", "another email", "ip")
+		_, err := store.WritePost(ctx, testCategoryTag, 0, "subject", "otherContent", &Identity{Username: "username", Email: "another email", IP: "ip"}, "", "", nil)
 		if err != nil {
 			t.Error(err)
 		}
 
 		for i := 0; i < postCount; i++ {
-			err := store.WritePost(ctx, testCategoryTag, 0, "subject", expectContent, "username", expectEmail, "ip")
+			_, err := store.WritePost(ctx, testCategoryTag, 0, "subject", expectContent, &Identity{ID: expectID, Username: "username", Email: expectEmail, IP: "ip"}, "", "", nil)
 			if err != nil {
 				t.Error(err)
 			}
 		}
-		posts, err := store.GetPostsByEmail(ctx, expectEmail)
+		// Legacy posts keyed on the email hash get claimed by the account.
+		_, err = store.pgPool.Exec(
+			ctx,
+			"UPDATE posts SET author_id = email_hash WHERE cat = $1 AND num = (SELECT MIN(num) FROM posts WHERE cat = $1 AND email = $2)",
+			testCategoryTag, expectEmail,
+		)
+		if err != nil {
+			t.Error(err)
+		}
+		claimed, err := store.ClaimPosts(ctx, &Identity{ID: expectID, Email: expectEmail})
+		if err != nil {
+			t.Error(err)
+		}
+		if claimed != 1 {
+			t.Errorf("expected 1 post claimed, got %d", claimed)
+		}
+		posts, err := store.GetPostsByAuthor(ctx, expectID)
 		if err != nil {
 			t.Error(err)
 		}
@@ -361,124 +408,2631 @@ func integration_GetPostsByEmail(ctx context.Context, store *DataStore) func(t *
 	}
 }
 
-func integration_ConcurrentThreadWrites(ctx context.Context, store *DataStore) func(t *testing.T) {
+func integration_SearchPosts(ctx context.Context, store *DataStore) func(t *testing.T) {
 	return func(t *testing.T) {
-		categoryThreadCountMap := map[string]int{
-			"test-1": 45,
-			"test-2": 22,
-			"test-3": 10,
+		setUpFixtures(ctx, t, store, newCategory("srch").Named("search"), newCategory("srch2").Named("search two"))
+
+		for _, tag := range []string{"srch", "srch2"} {
+			_, err := store.WritePost(ctx, tag, 0, "about giraffes", "long necks", &Identity{Username: "a", Email: "b", IP: "c"}, "", "", nil)
+			if err != nil {
+				t.Error(err)
+			}
+			_, err = store.WritePost(ctx, tag, 1, "", "short legs", &Identity{Username: "a", Email: "b", IP: "c"}, "", "", nil)
+			if err != nil {
+				t.Error(err)
+			}
 		}
-		testCategories := map[string]string{"test-1": "aa", "test-2": "bb", "test-3": "cc"}
 
-		err := createTestCategories(ctx, store, testCategories)
+		posts, err := store.SearchPosts(ctx, "giraffes", "", false, 10)
 		if err != nil {
 			t.Error(err)
 		}
-		defer removeTestCategories(ctx, store, testCategories)
+		if len(posts) != 2 {
+			t.Errorf("expected 2 matching posts, got %d", len(posts))
+		}
 
-		t.Run("Concurent thread writes", concurrentThreadWriteTest(ctx, store, categoryThreadCountMap))
+		posts, err = store.SearchPosts(ctx, "legs", "srch", false, 10)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(posts) != 1 || posts[0].Parent != 1 || posts[0].Cat != "srch" {
+			t.Errorf("expected 1 reply on srch, got %+v", posts)
+		}
 	}
 }
 
-/*
-*
-Test writing valid & invalid posts
-*/
-func integration_WritePosts(ctx context.Context, datastore *DataStore) func(t *testing.T) {
+func integration_Retention(ctx context.Context, store *DataStore) func(t *testing.T) {
 	return func(t *testing.T) {
-		t.Run("invalid category", func(t *testing.T) {
-			err := datastore.WritePost(ctx, "invalid-category", 0, "beep", "boop", "a", "b", "c")
-			if err == nil {
-				t.Errorf("expected writepost error, got: %v", err)
+		_, err := store.GetRetainedPosts(ctx, "rtn", 1)
+		if !errors.Is(err, ErrRetentionDisabled) {
+			t.Errorf("expected ErrRetentionDisabled, got: %v", err)
+		}
+
+		err = store.EnableRetention(RetentionOptions{Key: make([]byte, 32), Days: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { store.retention = nil }()
+
+		setUpFixtures(ctx, t, store, newCategory("rtn").Named("retention"))
+
+		_, err = store.WritePost(ctx, "rtn", 0, "subject", "content", &Identity{Username: "username", Email: "email", IP: "ip"}, "", "", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = store.WritePost(ctx, "rtn", 1, "", "reply", &Identity{Username: "username", Email: "email", IP: "ip"}, "", "", nil)
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = store.RemovePost(ctx, "rtn", 1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		for num, content := range map[int]string{1: "content", 2: "reply"} {
+			posts, err := store.GetRetainedPosts(ctx, "rtn", num)
+			if err != nil {
+				t.Error(err)
 			}
-			if !errors.Is(err, ErrNotFound) {
-				t.Errorf("expected an ErrNotFound from writepost, got: %v", err)
+			if len(posts) != 1 || posts[0].Content != content || posts[0].IP != "ip" {
+				t.Errorf("expected retained post %d, got %+v", num, posts)
 			}
-		})
+		}
 
-		t.Run("valid category, valid thread", func(t *testing.T) {
-			name := "BEEW"
-			testCategories := map[string]string{name: "meowmeow"}
-			err := createTestCategories(ctx, datastore, testCategories)
+		_, err = store.PurgeRetainedPosts(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		store.pgPool.Exec(ctx, "DELETE FROM retained_posts WHERE cat = 'rtn'")
+	}
+}
+
+func integration_GetPosterHistory(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		err := store.EnableRetention(RetentionOptions{Key: make([]byte, 32), Days: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { store.retention = nil }()
+
+		setUpFixtures(ctx, t, store, newCategory("hist").Named("history"), newCategory("hist2").Named("history two"))
+
+		for _, tag := range []string{"hist", "hist2"} {
+			_, err = store.WritePost(ctx, tag, 0, "subject", "content", &Identity{Username: "username", Email: "poster@history.com", IP: "10.0.0.1"}, "", "", nil)
 			if err != nil {
 				t.Error(err)
 			}
-			defer removeTestCategories(ctx, datastore, testCategories)
+		}
+		_, err = store.RemovePost(ctx, "hist2", 1)
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = store.pgPool.Exec(ctx, "INSERT INTO bans (poster_hash) VALUES ($1)", PosterHash("10.0.0.1"))
+		if err != nil {
+			t.Error(err)
+		}
 
-			err = datastore.WritePost(ctx, name, 0, "beep", "boop", "a", "b", "c")
+		// The ban was on the IP, not the email.
+		for id, bans := range map[string]int{"10.0.0.1": 1, "poster@history.com": 0} {
+			history, err := store.GetPosterHistory(ctx, PosterHash(id))
 			if err != nil {
-				t.Errorf("expected no error, got: %v", err)
+				t.Error(err)
 			}
-		})
+			if len(history.Posts) != 2 {
+				t.Errorf("expected 2 posts by %s, got %d", id, len(history.Posts))
+			}
+			deleted := 0
+			for _, post := range history.Posts {
+				if post.Deleted {
+					deleted++
+				}
+			}
+			if deleted != 1 {
+				t.Errorf("expected 1 deleted post by %s, got %d", id, deleted)
+			}
+			if history.Bans != bans {
+				t.Errorf("expected %d bans on %s, got %d", bans, id, history.Bans)
+			}
+		}
 
-		t.Run("valid category, invalid parent post", func(t *testing.T) {
-			name := "BEEW"
-			testCategories := map[string]string{name: "meow"}
-			createTestCategories(ctx, datastore, testCategories)
-			defer removeTestCategories(ctx, datastore, testCategories)
+		store.pgPool.Exec(ctx, "DELETE FROM bans WHERE poster_hash = $1", PosterHash("10.0.0.1"))
+		store.pgPool.Exec(ctx, "DELETE FROM retained_posts WHERE cat = 'hist2'")
+	}
+}
 
-			err := datastore.WritePost(ctx, name, 5, "beep", "boop", "a", "b", "c")
-			if err == nil || !errors.Is(err, ErrNotFound) {
-				t.Errorf("expected ErrNotFound, got: %v", err)
+func integration_ModerationQueue(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("queue"))
+
+		for i := 0; i < 3; i++ {
+			_, err := store.WritePost(ctx, "queue", 0, "subject", "content", &Identity{Username: "username", Email: "queue@queue.com", IP: "10.0.0.2"}, "", "", nil)
+			if err != nil {
+				t.Error(err)
 			}
-		})
+		}
+		err := store.WriteReport(ctx, "queue", 50, "missing", "reporter")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound reporting a missing post, got: %v", err)
+		}
+		for _, num := range []int{1, 1, 2} {
+			err = store.WriteReport(ctx, "queue", num, "spam", "reporter")
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		_, err = store.pgPool.Exec(ctx, "UPDATE posts SET quarantined = true WHERE cat = 'queue' AND num = 3")
+		if err != nil {
+			t.Error(err)
+		}
+
+		items, err := store.GetModerationQueue(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued := make(map[int]*QueueItem)
+		for _, item := range items {
+			if item.Post.Cat == "queue" {
+				queued[item.Post.Num] = item
+			}
+		}
+		if len(queued) != 3 || len(queued[1].Reports) != 2 || !queued[3].Quarantined {
+			t.Errorf("expected 3 queued posts, got %+v", queued)
+		}
+		if _, err := store.GetPostByNumber(ctx, "queue", 3); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected quarantined post hidden, got: %v", err)
+		}
+
+		resolutions := map[int]Resolution{
+			1: {Action: ActionApprove},
+			2: {Action: ActionDelete},
+			3: {Action: ActionBan, Reason: "spam", BanFor: time.Hour},
+		}
+		for num, resolution := range resolutions {
+			err := store.ResolveQueueItem(ctx, "queue", num, resolution)
+			if err != nil {
+				t.Errorf("failed to %s post %d: %v", resolution.Action, num, err)
+			}
+		}
+
+		items, err = store.GetModerationQueue(ctx, 10)
+		if err != nil {
+			t.Error(err)
+		}
+		for _, item := range items {
+			if item.Post.Cat == "queue" {
+				t.Errorf("expected resolved post out of the queue, got %+v", item)
+			}
+		}
+		banned, err := store.IsBanned(ctx, PosterHash("10.0.0.2"))
+		if err != nil || !banned {
+			t.Errorf("expected poster banned, got %v %v", banned, err)
+		}
+		store.pgPool.Exec(ctx, "DELETE FROM bans WHERE poster_hash = $1", PosterHash("10.0.0.2"))
 	}
 }
 
-func createTestCategories(ctx context.Context, datastore *DataStore, categorys map[string]string) error {
-	for tag, name := range categorys {
-		err := datastore.WriteCategory(ctx, tag, name)
+func integration_BulkActions(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("bulk"))
+
+		for i := 0; i < 3; i++ {
+			_, err := store.WritePost(ctx, "bulk", 0, "subject", "content", &Identity{Username: "username", Email: "bulk@bulk.com", IP: "10.0.0.3"}, "", "", nil)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		results, err := store.ApplyBulkActions(ctx, BulkActions{
+			DeletePosts: []PostRef{{Cat: "bulk", Num: 2}, {Cat: "bulk", Num: 50}},
+			BanPosters:  []string{PosterHash("10.0.0.3")},
+			LockThreads: []PostRef{{Cat: "bulk", Num: 1}, {Cat: "nothing", Num: 1}},
+			BanFor:      time.Hour,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !results.DeletePosts[0].OK || results.DeletePosts[1].OK {
+			t.Errorf("expected only the existing post deleted, got %+v", results.DeletePosts)
+		}
+		if !results.BanPosters[0].OK {
+			t.Errorf("expected ban, got %+v", results.BanPosters)
+		}
+		if !results.LockThreads[0].OK || results.LockThreads[1].OK {
+			t.Errorf("expected only the existing thread locked, got %+v", results.LockThreads)
+		}
+
+		modLog, err := store.GetModLog(ctx, "bulk", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions := make(map[ModerationAction]int)
+		for _, entry := range modLog {
+			actions[entry.Action] = entry.Num
+		}
+		if len(modLog) != 2 || actions[ActionLock] != 1 || actions[ActionDelete] != 2 {
+			t.Errorf("expected the lock and delete logged, got %+v", modLog)
+		}
+
+		_, err = store.GetPostByNumber(ctx, "bulk", 2)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected deleted post gone, got: %v", err)
+		}
+		_, err = store.WritePost(ctx, "bulk", 1, "", "reply", &Identity{Username: "username", Email: "bulk@bulk.com", IP: "10.0.0.3"}, "", "", nil)
+		if !errors.Is(err, ErrThreadLocked) {
+			t.Errorf("expected ErrThreadLocked replying to a locked thread, got: %v", err)
+		}
+		_, err = store.WritePost(ctx, "bulk", 3, "", "reply", &Identity{Username: "username", Email: "bulk@bulk.com", IP: "10.0.0.3"}, "", "", nil)
 		if err != nil {
-			return err
+			t.Errorf("expected reply to unlocked thread, got: %v", err)
 		}
+		store.pgPool.Exec(ctx, "DELETE FROM bans WHERE poster_hash = $1", PosterHash("10.0.0.3"))
 	}
-	return nil
 }
 
-func removeTestCategories(ctx context.Context, datastore *DataStore, tags map[string]string) error {
-	for tag := range tags {
-		_, err := datastore.RemoveCategory(ctx, tag)
+func integration_Rules(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		id, err := store.WriteRule(ctx, &Rule{
+			Name: "strikes", Kind: RuleDeletedPosts, Threshold: 2, WindowHours: 24, Action: ActionBan, Enabled: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.RemoveRule(ctx, id)
+
+		err = store.UpdateRule(ctx, &Rule{ID: id, Name: "two strikes", Kind: RuleDeletedPosts, Threshold: 2, WindowHours: 24, Action: ActionBan})
+		if err != nil {
+			t.Error(err)
+		}
+		rules, err := store.GetRules(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) == 0 || rules[len(rules)-1].Name != "two strikes" || rules[len(rules)-1].Enabled {
+			t.Errorf("expected updated rule, got %+v", rules)
+		}
+		if err := store.UpdateRule(ctx, &Rule{ID: id + 1000}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound updating a missing rule, got: %v", err)
+		}
+
+		folded, err := store.WriteRule(ctx, &Rule{
+			Name: "folded", Kind: RuleContentMatch, Pattern: "spam", Fold: true, Action: ActionQuarantine,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.RemoveRule(ctx, folded)
+		rules, err = store.GetRules(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) == 0 || rules[len(rules)-1].ID != folded || !rules[len(rules)-1].Fold {
+			t.Errorf("expected folded content rule, got %+v", rules)
+		}
+
+		setUpFixtures(ctx, t, store, newCategory("rules"))
+
+		for i := 0; i < 3; i++ {
+			_, err = store.WritePost(ctx, "rules", 0, "subject", "content", &Identity{Username: "username", Email: "rules@rules.com", IP: "10.0.0.4"}, "", "", nil)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		// Posts deleted by their poster don't count.
+		_, err = store.RemovePost(ctx, "rules", 3)
+		if err != nil {
+			t.Error(err)
+		}
+		_, _, err = store.CountPosterDeletions(ctx, "rules", 3, time.Now().Add(-time.Hour))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound counting a self deleted post, got: %v", err)
+		}
+
+		_, err = store.ApplyBulkActions(ctx, BulkActions{DeletePosts: []PostRef{{Cat: "rules", Num: 1}, {Cat: "rules", Num: 2}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		posterHash, count, err := store.CountPosterDeletions(ctx, "rules", 2, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if posterHash != PosterHash("10.0.0.4") || count != 2 {
+			t.Errorf("expected 2 deletions by the poster, got %d by %s", count, posterHash)
+		}
+
+		err = store.WriteRuleAction(ctx, &RuleAction{RuleID: id, RuleName: "two strikes", PosterHash: posterHash, Cat: "rules", Num: 2, Action: ActionBan})
 		if err != nil {
-			return err
+			t.Error(err)
+		}
+		actions, err := store.GetRuleActions(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actions) != 1 || actions[0].RuleID != id || actions[0].Action != ActionBan {
+			t.Errorf("expected recorded action, got %+v", actions)
 		}
+		store.pgPool.Exec(ctx, "DELETE FROM mod_deletions WHERE poster_hash = $1", posterHash)
+		store.pgPool.Exec(ctx, "DELETE FROM rule_actions WHERE rule_id = $1", id)
 	}
-	return nil
 }
 
-/*
-Takes a map of category names and their number of threads to create.
-Creates all categories, and then writes n threads to each category concurrently.
-*/
-func concurrentThreadWriteTest(ctx context.Context, datastore *DataStore, tests map[string]int) func(t *testing.T) {
+func integration_Notes(ctx context.Context, store *DataStore) func(t *testing.T) {
 	return func(t *testing.T) {
-		for categoryName, threadCount := range tests {
-			threadCount := threadCount
-			categoryName := categoryName
-			t.Run(categoryName, func(t *testing.T) {
-				t.Parallel()
-				// write n posts concurrently to a category
-				var wg sync.WaitGroup
-				categoryName := categoryName
-				for i := 0; i < threadCount; i++ {
-					wg.Add(1)
-					go func() {
-						defer wg.Done()
-						err := datastore.WritePost(ctx, categoryName, 0, "beep", "boop", "a", "b", "c")
-						if err != nil {
-							panic(err)
-						}
-					}()
-				}
-				wg.Wait()
+		setUpFixtures(ctx, t, store, newCategory("notes"))
 
-				count, err := datastore.GetThreadCount(ctx, categoryName)
-				if err != nil {
-					t.Errorf("failed to get thread count on category %s: %v", categoryName, err)
-				}
-				if count != threadCount {
-					t.Errorf("expected %d threads, got %d", threadCount, count)
-				}
-			})
+		posterHash := PosterHash("10.0.0.5")
+		_, err := store.WritePost(ctx, "notes", 0, "subject", "content", &Identity{Username: "username", Email: "notes@notes.com", IP: "10.0.0.5"}, "", "", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = store.WriteNote(ctx, &Note{Cat: "notes", Num: 1, Content: "on the post", Author: "mod"})
+		if err != nil {
+			t.Error(err)
+		}
+		posterNote, err := store.WriteNote(ctx, &Note{PosterHash: posterHash, Content: "on the poster", Author: "mod"})
+		if err != nil {
+			t.Error(err)
+		}
+		defer store.RemoveNote(ctx, posterNote)
+		_, err = store.WriteNote(ctx, &Note{Cat: "notes", Num: 50, Content: "on nothing", Author: "mod"})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound noting a missing post, got: %v", err)
+		}
+
+		history, err := store.GetPosterHistory(ctx, posterHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history.Notes) != 1 || history.Notes[0].Content != "on the poster" {
+			t.Errorf("expected poster note, got %+v", history.Notes)
+		}
+		if len(history.Posts) != 1 || len(history.Posts[0].Notes) != 1 || history.Posts[0].Notes[0].Content != "on the post" {
+			t.Errorf("expected post note on the poster's post, got %+v", history.Posts)
+		}
+
+		err = store.WriteReport(ctx, "notes", 1, "spam", PosterHash("reporter"))
+		if err != nil {
+			t.Error(err)
+		}
+		queue, err := store.GetModerationQueue(ctx, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range queue {
+			if item.Post.Cat == "notes" && len(item.Notes) != 2 {
+				t.Errorf("expected post and poster notes on the queued post, got %+v", item.Notes)
+			}
+		}
+
+		if err := store.RemoveNote(ctx, posterNote+1000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound removing a missing note, got: %v", err)
+		}
+	}
+}
+
+func integration_CategoryRules(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("rules"))
+
+		posterHash := PosterHash("10.0.0.6")
+		mustAccept, err := store.MustAcceptRules(ctx, "rules", posterHash)
+		if err != nil || mustAccept {
+			t.Errorf("expected no acceptance needed without rules, got %v: %v", mustAccept, err)
+		}
+
+		err = store.SetCategoryRules(ctx, "rules", []string{"first", "second", "third"})
+		if err != nil {
+			t.Error(err)
+		}
+		err = store.SetCategoryRules(ctx, "rules", []string{"second", "first"})
+		if err != nil {
+			t.Error(err)
+		}
+		if err := store.SetCategoryRules(ctx, "nothing", []string{"rule"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound setting rules on a missing category, got: %v", err)
+		}
+		view, err := store.GetCategoryView(ctx, "rules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(view.Rules) != 2 || view.Rules[0] != "second" || view.Rules[1] != "first" {
+			t.Errorf("expected replaced rules in order, got %q", view.Rules)
+		}
+
+		mustAccept, err = store.MustAcceptRules(ctx, "rules", posterHash)
+		if err != nil || !mustAccept {
+			t.Errorf("expected acceptance needed, got %v: %v", mustAccept, err)
+		}
+		err = store.AcceptRules(ctx, "rules", posterHash)
+		if err != nil {
+			t.Error(err)
+		}
+		mustAccept, err = store.MustAcceptRules(ctx, "rules", PosterHash("other"), posterHash)
+		if err != nil || mustAccept {
+			t.Errorf("expected rules accepted, got %v: %v", mustAccept, err)
+		}
+
+		history, err := store.GetPosterHistory(ctx, posterHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history.AcceptedRules) != 1 || history.AcceptedRules[0].Cat != "rules" {
+			t.Errorf("expected acceptance in poster history, got %+v", history.AcceptedRules)
+		}
+	}
+}
+
+func integration_Pages(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		err := store.WritePage(ctx, &Page{Slug: "test-faq", Title: "FAQ", Body: "first"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.RemovePage(ctx, "test-faq")
+		err = store.WritePage(ctx, &Page{Slug: "test-faq", Title: "FAQ", Body: "second"})
+		if err != nil {
+			t.Error(err)
+		}
+
+		page, err := store.GetPage(ctx, "test-faq")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Body != "second" {
+			t.Errorf("expected page replaced, got %+v", page)
+		}
+		pages, err := store.GetPages(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, page := range pages {
+			found = found || page.Slug == "test-faq"
+		}
+		if !found {
+			t.Errorf("expected page listed, got %+v", pages)
+		}
+
+		if _, err := store.GetPage(ctx, "nothing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound getting a missing page, got: %v", err)
+		}
+		if err := store.RemovePage(ctx, "nothing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound removing a missing page, got: %v", err)
+		}
+	}
+}
+
+func integration_PostOwnership(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("owned"))
+
+		author := NewIdentity("auth0|owner", "owner@owner.com", "owner", nil, "10.0.0.6")
+		num, err := store.WritePost(ctx, "owned", 0, "subject", "content", author, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ownership, err := store.GetPostOwnership(ctx, "owned", num)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ownership.IsAuthor(author) {
+			t.Errorf("expected post owned by %s, got %+v", author.ID, ownership)
+		}
+		if ownership.IsAuthor(NewIdentity("auth0|other", "owner@owner.com", "other", nil, "10.0.0.6")) {
+			t.Error("expected post not owned by another account")
+		}
+
+		if _, err := store.GetPostOwnership(ctx, "owned", 50); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound on a missing post, got: %v", err)
+		}
+	}
+}
+
+func integration_ThreadAuthor(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("opcat"))
+
+		op := NewIdentity("auth0|op", "op@op.com", "op", nil, "10.0.0.7")
+		replier := NewIdentity("auth0|replier", "replier@op.com", "replier", nil, "10.0.0.8")
+		thread, err := store.WritePost(ctx, "opcat", 0, "subject", "question", op, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := store.WritePost(ctx, "opcat", thread, "", "answer", replier, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ownership, err := store.GetPostOwnership(ctx, "opcat", reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ownership.IsAuthor(replier) || !ownership.IsThreadAuthor(op) {
+			t.Errorf("expected reply owned by its author on the OP's thread, got %+v", ownership)
+		}
+
+		if err := store.SetBestAnswer(ctx, "opcat", thread, 50); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound marking a missing reply, got: %v", err)
+		}
+		err = store.SetBestAnswer(ctx, "opcat", thread, reply)
+		if err != nil {
+			t.Error(err)
+		}
+		err = store.CloseThread(ctx, "opcat", thread)
+		if err != nil {
+			t.Error(err)
+		}
+		view, err := store.GetThreadView(ctx, "opcat", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !view.Posts[0].Locked || view.Posts[0].BestAnswer != reply {
+			t.Errorf("expected closed thread with best answer %d, got %+v", reply, view.Posts[0])
+		}
+
+		_, err = store.RemovePost(ctx, "opcat", reply)
+		if err != nil {
+			t.Error(err)
+		}
+		view, err = store.GetThreadView(ctx, "opcat", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.Posts[0].BestAnswer != 0 {
+			t.Errorf("expected deleted best answer cleared, got %d", view.Posts[0].BestAnswer)
+		}
+		if err := store.CloseThread(ctx, "opcat", reply); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound closing a missing thread, got: %v", err)
+		}
+	}
+}
+
+func integration_Questions(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("qna"))
+
+		asker := &Identity{ID: "auth0|asker", Username: "asker", Email: "asker@qna.com", IP: "10.0.0.9"}
+		question, err := store.WritePost(ctx, "qna", 0, "how do owls", "turn their heads", asker, "", ThreadQuestion, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		discussion, err := store.WritePost(ctx, "qna", 0, "owls", "owls turn their heads", asker, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var replies []int
+		for i := 0; i < 2; i++ {
+			reply, err := store.WritePost(ctx, "qna", question, "", "extra vertebrae", asker, "", ThreadQuestion, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replies = append(replies, reply)
+		}
+
+		posts, err := store.SearchPosts(ctx, "owls", "qna", true, 10)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(posts) != 1 || posts[0].Num != question {
+			t.Errorf("expected only the unanswered question, got %+v", posts)
+		}
+
+		err = store.SetBestAnswer(ctx, "qna", question, replies[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		view, err := store.GetThreadView(ctx, "qna", question)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(view.Posts) != 3 || view.Posts[0].Type != ThreadQuestion || !view.Posts[0].Solved {
+			t.Fatalf("expected solved question thread, got %+v", view.Posts)
+		}
+		if view.Posts[1].Num != replies[1] || len(view.Posts[1].Type) > 0 {
+			t.Errorf("expected accepted answer pinned under the question, got %+v", view.Posts[1])
+		}
+
+		catView, err := store.GetCategoryView(ctx, "qna")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, thread := range catView.Threads {
+			if thread.Num == discussion && (thread.Type != ThreadDiscussion || thread.Solved) {
+				t.Errorf("expected unsolved discussion, got %+v", thread)
+			}
+			if thread.Num == question && !thread.Solved {
+				t.Errorf("expected solved question in the catalog, got %+v", thread)
+			}
+		}
+
+		posts, err = store.SearchPosts(ctx, "owls", "qna", true, 10)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(posts) != 0 {
+			t.Errorf("expected no unanswered questions, got %+v", posts)
+		}
+	}
+}
+
+func integration_CrossRefs(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("xref1").Named("one"), newCategory("xref2").Named("two"))
+
+		poster := &Identity{Username: "x", Email: "x@xref.com", IP: "10.0.0.10"}
+		thread, err := store.WritePost(ctx, "xref2", 0, "target thread", "target", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := store.WritePost(ctx, "xref2", thread, "", "target reply", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := fmt.Sprintf(">>>/xref2/%d and >>>/xref2/500 and >>>/nothing/1", reply)
+		num, err := store.WritePost(ctx, "xref1", 0, "referencing", content, poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		view, err := store.GetThreadView(ctx, "xref1", num)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs := view.Posts[0].CrossRefs
+		if len(refs) != 1 || refs[0] != (CrossRef{Cat: "xref2", Num: reply, Thread: thread}) {
+			t.Errorf("expected only the existing reply referenced, got %+v", refs)
+		}
+
+		_, err = store.RemovePost(ctx, "xref2", reply)
+		if err != nil {
+			t.Error(err)
+		}
+		catView, err := store.GetCategoryView(ctx, "xref1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(catView.Threads) != 1 || len(catView.Threads[0].CrossRefs) != 0 {
+			t.Errorf("expected reference dropped with its target, got %+v", catView.Threads)
+		}
+	}
+}
+
+func integration_CategorySlugs(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("slug1").Named("one"), newCategory("slug2").Named("two"))
+
+		tag, current, err := store.ResolveCategorySlug(ctx, "slug1")
+		if err != nil || tag != "slug1" || current != "slug1" {
+			t.Errorf("expected the tag as the initial slug, got %s %s %v", tag, current, err)
+		}
+
+		err = store.SetCategorySlug(ctx, "slug1", "renamed-slug1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag, current, err = store.ResolveCategorySlug(ctx, "slug1")
+		if err != nil || tag != "slug1" || current != "renamed-slug1" {
+			t.Errorf("expected the old slug to resolve to the new one, got %s %s %v", tag, current, err)
+		}
+		category, err := store.GetCategory(ctx, "slug1")
+		if err != nil || category.Slug != "renamed-slug1" {
+			t.Errorf("expected category with its new slug, got %+v %v", category, err)
+		}
+
+		if err := store.SetCategorySlug(ctx, "slug2", "slug1"); !errors.Is(err, ErrSlugTaken) {
+			t.Errorf("expected ErrSlugTaken taking another category's old slug, got: %v", err)
+		}
+		if err := store.SetCategorySlug(ctx, "slug2", "renamed-slug1"); !errors.Is(err, ErrSlugTaken) {
+			t.Errorf("expected ErrSlugTaken taking another category's slug, got: %v", err)
+		}
+		// Categories can go back to their old slugs.
+		err = store.SetCategorySlug(ctx, "slug1", "slug1")
+		if err != nil {
+			t.Error(err)
+		}
+		tag, current, err = store.ResolveCategorySlug(ctx, "renamed-slug1")
+		if err != nil || tag != "slug1" || current != "slug1" {
+			t.Errorf("expected the renamed slug to redirect back, got %s %s %v", tag, current, err)
+		}
+
+		if err := store.SetCategorySlug(ctx, "nothing", "nothing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound renaming a missing category, got: %v", err)
+		}
+		if _, _, err := store.ResolveCategorySlug(ctx, "nothing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound resolving a missing slug, got: %v", err)
+		}
+	}
+}
+
+func integration_CategoryListing(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("lista").Named("a"), newCategory("listb").Named("b"), newCategory("listc").Named("c"))
+
+		for tag, listing := range map[string]struct {
+			sortOrder int
+			featured  bool
+		}{"lista": {2, false}, "listb": {1, false}, "listc": {3, true}} {
+			err := store.SetCategoryListing(ctx, tag, listing.sortOrder, listing.featured)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		if err := store.SetCategoryListing(ctx, "nothing", 0, false); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound listing a missing category, got: %v", err)
+		}
+
+		categories, err := store.GetCategories(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var order []string
+		for _, category := range categories {
+			if strings.HasPrefix(category.Tag, "list") {
+				order = append(order, category.Tag)
+			}
+		}
+		if strings.Join(order, ",") != "listc,listb,lista" {
+			t.Errorf("expected featured category first then by sort order, got %v", order)
+		}
+	}
+}
+
+func integration_CategoryArchived(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("archive"))
+
+		poster := &Identity{Username: "a", Email: "a@archive.com", IP: "10.0.0.11"}
+		thread, err := store.WritePost(ctx, "archive", 0, "old thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.SetCategoryArchived(ctx, "archive", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.WritePost(ctx, "archive", thread, "", "reply", poster, "", "", nil); !errors.Is(err, ErrCategoryArchived) {
+			t.Errorf("expected ErrCategoryArchived replying on an archived category, got: %v", err)
+		}
+		view, err := store.GetCategoryView(ctx, "archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !view.Category.Archived || len(view.Threads) != 1 {
+			t.Errorf("expected archived category to stay readable, got %+v", view)
+		}
+
+		err = store.SetCategoryArchived(ctx, "archive", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.WritePost(ctx, "archive", thread, "", "reply", poster, "", "", nil); err != nil {
+			t.Errorf("expected unarchived category to take posts, got: %v", err)
+		}
+		if err := store.SetCategoryArchived(ctx, "nothing", true); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound archiving a missing category, got: %v", err)
+		}
+	}
+}
+
+func integration_RemoveCategory(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("gone"))
+		defer store.pgPool.Exec(ctx, "DELETE FROM category_archive WHERE tag = 'gone'")
+		defer store.pgPool.Exec(ctx, "DELETE FROM post_archive WHERE cat = 'gone'")
+
+		poster := &Identity{Username: "a", Email: "a@gone.com", IP: "10.0.0.12"}
+		thread, err := store.WritePost(ctx, "gone", 0, "doomed thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.WritePost(ctx, "gone", thread, "", "reply", poster, "", "", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		removal, err := store.RemoveCategory(ctx, "gone", RemoveCategoryOptions{DryRun: true, Archive: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removal.Categories != 1 || removal.Posts != 2 || !removal.DryRun {
+			t.Errorf("expected dry run to count 1 category and 2 posts, got %+v", removal)
+		}
+		if _, err := store.GetCategoryView(ctx, "gone"); err != nil {
+			t.Errorf("expected dry run to leave the category in place, got: %v", err)
+		}
+
+		removal, err = store.RemoveCategory(ctx, "gone", RemoveCategoryOptions{Archive: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removal.Posts != 2 || !removal.Archived || len(removal.Threads) != 1 || removal.Threads[0] != thread {
+			t.Errorf("expected 2 archived posts under thread %d, got %+v", thread, removal)
+		}
+		if _, err := store.GetCategoryView(ctx, "gone"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected removed category to be gone, got: %v", err)
+		}
+		var archived int
+		err = store.pgPool.QueryRow(ctx, "SELECT COUNT(*) FROM post_archive WHERE cat = 'gone'").Scan(&archived)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if archived != 2 {
+			t.Errorf("expected 2 posts in the archive, got %d", archived)
+		}
+
+		view, err := store.GetArchivedCategoryView(ctx, "gone", 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !view.Category.Archived || len(view.Threads) != 1 || view.Threads[0].Num != thread ||
+			view.Threads[0].Subject != "doomed thread" || view.Threads[0].Replies != 1 {
+			t.Errorf("expected the removed category's thread and reply count browsable, got %+v", view)
+		}
+		threadView, err := store.GetArchivedThreadView(ctx, "gone", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(threadView.Posts) != 2 || threadView.Posts[1].Content != "reply" || threadView.Posts[0].Hash == "" {
+			t.Errorf("expected the archived thread with its reply, got %+v", threadView.Posts)
+		}
+		if _, err := store.GetArchivedThreadView(ctx, "gone", thread+1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound viewing a reply as an archived thread, got: %v", err)
+		}
+
+		removal, err = store.RemoveCategory(ctx, "gone", RemoveCategoryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removal.Categories != 0 {
+			t.Errorf("expected nothing to remove, got %+v", removal)
+		}
+	}
+}
+
+func integration_CategoryVersions(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("vers"))
+
+		category, err := store.GetCategory(ctx, "vers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := "Versioned"
+		updated, err := store.UpdateCategory(ctx, "vers", category.Version, &CategoryUpdate{Name: &name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Name != name || updated.Version != category.Version+1 {
+			t.Errorf("expected renamed category at the next version, got %+v", updated)
+		}
+
+		// A second admin editing from the version they loaded loses.
+		description := "stale"
+		if _, err := store.UpdateCategory(ctx, "vers", category.Version, &CategoryUpdate{Description: &description}); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("expected ErrVersionConflict editing a stale version, got: %v", err)
+		}
+
+		// Other admin edits move the version on too.
+		err = store.SetCategoryArchived(ctx, "vers", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.UpdateCategory(ctx, "vers", updated.Version, &CategoryUpdate{Description: &description}); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("expected ErrVersionConflict after archiving, got: %v", err)
+		}
+		if _, err := store.UpdateCategory(ctx, "nothing", 1, &CategoryUpdate{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound editing a missing category, got: %v", err)
+		}
+	}
+}
+
+func integration_CategoryMasks(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("masked"))
+
+		err := store.SetCategoryMasks(ctx, "masked", []string{"darn"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		words, err := store.GetCategoryMasks(ctx, "masked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(words) != 1 || words[0] != "darn" {
+			t.Errorf("expected masked words back, got %v", words)
+		}
+
+		poster := &Identity{Username: "a", Email: "a@masked.com", IP: "10.0.0.13"}
+		thread, err := store.WritePost(ctx, "masked", 0, "masked thread", "darn it", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		view, err := store.GetThreadView(ctx, "masked", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.Posts[0].Content != "**** it" || len(view.Posts[0].Original) > 0 {
+			t.Errorf("expected masked content without the original, got %+v", view.Posts[0])
+		}
+		history, err := store.GetPosterHistory(ctx, PosterHash(poster.IP))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history.Posts) != 1 || history.Posts[0].Original != "darn it" {
+			t.Errorf("expected original content for staff, got %+v", history.Posts)
+		}
+
+		if err := store.SetCategoryMasks(ctx, "nothing", nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound masking a missing category, got: %v", err)
+		}
+	}
+}
+
+func integration_PostPolicy(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("policy"))
+
+		err := store.SetCategoryMasks(ctx, "policy", []string{"darn"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.SetPostPolicy(ctx, "policy", &PostPolicy{
+			MaxLinks: 2, RejectLinkOnly: true, RequireAttachment: true,
+			Uploads:   UploadPolicy{MaxFileBytes: 1024, AllowedTypes: []string{"image/png"}, MaxFiles: 2},
+			Usernames: UsernamesAnonymous,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy, err := store.GetPostPolicy(ctx, "policy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy.MaxLinks != 2 || !policy.RejectLinkOnly || !policy.RequireAttachment ||
+			policy.AllowEmptyContentWithAttachment || len(policy.MaskedWords) != 1 || policy.Usernames != UsernamesAnonymous {
+			t.Errorf("expected policy with masked words, got %+v", policy)
+		}
+		if policy.Uploads.MaxFileBytes != 1024 || len(policy.Uploads.AllowedTypes) != 1 || policy.Uploads.MaxFiles != 2 {
+			t.Errorf("expected upload policy, got %+v", policy.Uploads)
+		}
+		category, err := store.GetCategory(ctx, "policy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !category.Uploads.Allows("image/png", 1024) || category.Uploads.Allows("image/png", 1025) || category.Uploads.Allows("image/gif", 1) {
+			t.Errorf("expected upload policy on the category, got %+v", category.Uploads)
+		}
+		if category.Usernames != UsernamesAnonymous {
+			t.Errorf("expected username policy on the category, got %q", category.Usernames)
+		}
+		if err := store.SetPostPolicy(ctx, "policy", &PostPolicy{}); err != nil {
+			t.Fatal(err)
+		}
+		if category, err := store.GetCategory(ctx, "policy"); err != nil || category.Usernames != UsernamesChoice {
+			t.Errorf("expected posters' choice by default, got %q %v", category.Usernames, err)
+		}
+
+		if _, err := store.GetPostPolicy(ctx, "nothing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound getting a missing category's policy, got: %v", err)
+		}
+		if err := store.SetPostPolicy(ctx, "nothing", &PostPolicy{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound setting a missing category's policy, got: %v", err)
+		}
+	}
+}
+
+func integration_Attachments(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("attached"))
+
+		poster := &Identity{ID: "uploader", Username: "a", Email: "a@attached.com", IP: "10.0.0.14"}
+		other := &Identity{ID: "someone else", Username: "b", Email: "b@attached.com", IP: "10.0.0.15"}
+		id, err := store.WriteAttachment(ctx, &Attachment{
+			Kind: AttachmentVideo, MIME: "video/webm", Size: 1024, File: "clip.webm", Poster: "clip.jpg",
+			Width: 640, Height: 480, DurationMS: 5000, UploaderID: poster.ID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.WritePost(ctx, "attached", 0, "stolen clip", "mine now", other, "", "", []AttachmentRef{{ID: id}})
+		if !errors.Is(err, ErrAttachmentUnavailable) {
+			t.Errorf("expected someone else's attachment to be unavailable, got: %v", err)
+		}
+		thread, err := store.WritePost(ctx, "attached", 0, "my clip", "look", poster, "", "", []AttachmentRef{{ID: id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.WritePost(ctx, "attached", thread, "", "again", poster, "", "", []AttachmentRef{{ID: id}})
+		if !errors.Is(err, ErrAttachmentUnavailable) {
+			t.Errorf("expected a posted attachment to be unavailable, got: %v", err)
+		}
+
+		if err := store.SetAttachmentTranscode(ctx, id, "clip.mp4", "video/mp4"); err != nil {
+			t.Fatal(err)
+		}
+		view, err := store.GetThreadView(ctx, "attached", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(view.Posts[0].Attachments) != 1 {
+			t.Fatalf("expected the thread's attachment, got %+v", view.Posts[0].Attachments)
+		}
+		attachment := view.Posts[0].Attachments[0]
+		if attachment.File != "clip.webm" || attachment.DurationMS != 5000 || attachment.Transcoded != "clip.mp4" {
+			t.Errorf("expected the transcoded attachment, got %+v", attachment)
+		}
+
+		attachment, err = store.GetAttachment(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attachment.Cat != "attached" || attachment.Num != thread || attachment.UploaderID != poster.ID {
+			t.Errorf("expected the attachment on the thread, got %+v", attachment)
+		}
+		if _, err := store.GetAttachment(ctx, -1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a missing attachment, got: %v", err)
+		}
+	}
+}
+
+func integration_StorageUsage(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("stored"))
+
+		poster := &Identity{ID: "hoarder", Username: "a", Email: "a@stored.com", IP: "10.0.0.16"}
+		var ids []AttachmentRef
+		for i := 0; i < 3; i++ {
+			id, err := store.WriteAttachment(ctx, &Attachment{
+				Kind: AttachmentImage, MIME: "image/png", Size: 100, File: fmt.Sprintf("stored%d.png", i), UploaderID: poster.ID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, AttachmentRef{ID: id})
+		}
+		thread, err := store.WritePost(ctx, "stored", 0, "stored thread", "files", poster, "", "", ids[:1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.WritePost(ctx, "stored", thread, "", "more files", poster, "", "", ids[1:]); err != nil {
+			t.Fatal(err)
+		}
+
+		used, err := store.GetCategoryStorageUsage(ctx, "stored")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != 300 {
+			t.Errorf("expected 300 bytes used, got %d", used)
+		}
+		usage, err := store.GetStorageUsage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, c := range usage.Categories {
+			if c.Cat == "stored" {
+				found = c.Bytes == 300 && c.Files == 3
+			}
+		}
+		if !found || usage.TotalBytes < 300 {
+			t.Errorf("expected the category's usage, got %+v", usage)
+		}
+
+		pruned, err := store.PruneAttachments(ctx, "stored", 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pruned) != 1 || pruned[0].ID != ids[0].ID || pruned[0].File != "stored0.png" {
+			t.Errorf("expected the oldest attachment pruned, got %+v", pruned)
+		}
+		if used, _ := store.GetCategoryStorageUsage(ctx, "stored"); used != 200 {
+			t.Errorf("expected 200 bytes left, got %d", used)
+		}
+	}
+}
+
+func integration_AttachmentFlags(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("spoiled"))
+
+		poster := &Identity{ID: "spoiler", Username: "a", Email: "a@spoiled.com", IP: "10.0.0.17"}
+		var refs []AttachmentRef
+		for i := 0; i < 2; i++ {
+			id, err := store.WriteAttachment(ctx, &Attachment{
+				Kind: AttachmentImage, MIME: "image/png", Size: 10, File: fmt.Sprintf("spoiled%d.png", i), UploaderID: poster.ID,
+				Thumbnails: []Thumbnail{{Size: "small", MIME: "image/jpeg", File: fmt.Sprintf("spoiled%d.jpg", i), Width: 1, Height: 1}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			refs = append(refs, AttachmentRef{ID: id})
+		}
+		refs[1].Spoiler = true
+		thread, err := store.WritePost(ctx, "spoiled", 0, "ending", "spoilers inside", poster, "", "", refs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		view, err := store.GetThreadView(ctx, "spoiled", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attachments := view.Posts[0].Attachments
+		if len(attachments) != 2 || attachments[0].Spoiler || attachments[0].Preview != "spoiled0.png" ||
+			!attachments[1].Spoiler || attachments[1].Preview != SpoilerPreview {
+			t.Errorf("expected the second attachment spoilered, got %+v %+v", attachments[0], attachments[1])
+		}
+
+		if len(attachments[0].Thumbnails) != 1 || attachments[0].Thumbnails[0].File != "spoiled0.jpg" {
+			t.Errorf("expected thumbnails to be kept, got %+v", attachments[0].Thumbnails)
+		}
+
+		nsfw := true
+		attachment, err := store.SetAttachmentFlags(ctx, refs[0].ID, &AttachmentFlags{NSFW: &nsfw})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attachment.NSFW || attachment.Spoiler || attachment.Preview != SpoilerPreview {
+			t.Errorf("expected the attachment forced NSFW, got %+v", attachment)
+		}
+		entries, err := store.GetModLog(ctx, "spoiled", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Action != ActionSpoiler || entries[0].Num != thread {
+			t.Errorf("expected the spoiler logged, got %+v", entries)
+		}
+		if _, err := store.SetAttachmentFlags(ctx, -1, &AttachmentFlags{NSFW: &nsfw}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound flagging a missing attachment, got: %v", err)
+		}
+	}
+}
+
+func integration_CollectOrphanedAttachments(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("orphans"))
+
+		poster := &Identity{ID: "orphaner", Username: "a", Email: "a@orphans.com", IP: "10.0.0.18"}
+		write := func(file string) int {
+			id, err := store.WriteAttachment(ctx, &Attachment{
+				Kind: AttachmentImage, MIME: "image/png", Size: 10, File: file, UploaderID: poster.ID,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+		posted, kept, unposted := write("orphan.png"), write("kept.png"), write("unposted.png")
+		thread, err := store.WritePost(ctx, "orphans", 0, "doomed", "going away", poster, "", "", []AttachmentRef{{ID: posted}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.WritePost(ctx, "orphans", 0, "staying", "not going anywhere", poster, "", "", []AttachmentRef{{ID: kept}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.RemovePost(ctx, "orphans", thread); err != nil {
+			t.Fatal(err)
+		}
+
+		collected, err := store.CollectOrphanedAttachments(ctx, time.Hour)
+		if err != nil || len(collected) != 0 {
+			t.Fatalf("expected nothing collected in the grace period, got %d %v", len(collected), err)
+		}
+		collected, err = store.CollectOrphanedAttachments(ctx, -time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make(map[int]bool)
+		for _, attachment := range collected {
+			ids[attachment.ID] = true
+		}
+		if !ids[posted] || !ids[unposted] || ids[kept] {
+			t.Errorf("expected the deleted post's and unposted attachments collected, got %+v", ids)
+		}
+		if _, err := store.GetAttachment(ctx, kept); err != nil {
+			t.Errorf("expected posted attachment to be kept, got %v", err)
+		}
+	}
+}
+
+func integration_AccountFirstSeen(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		first, err := store.AccountFirstSeen(ctx, "first-seen")
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := store.AccountFirstSeen(ctx, "first-seen")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !again.Equal(first) {
+			t.Errorf("expected first sighting %s to be kept, got %s", first, again)
+		}
+	}
+}
+
+func integration_Preferences(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		prefs, err := store.GetPreferences(ctx, "prefs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *prefs != (Preferences{}) {
+			t.Errorf("expected default preferences, got %+v", prefs)
+		}
+
+		name, timezone := "Spirit", "Europe/London"
+		_, err = store.UpdatePreferences(ctx, "prefs", &PreferencesUpdate{DisplayName: &name, Timezone: &timezone})
+		if err != nil {
+			t.Fatal(err)
+		}
+		anonymous := true
+		prefs, err = store.UpdatePreferences(ctx, "prefs", &PreferencesUpdate{Anonymous: &anonymous})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := Preferences{DisplayName: name, Anonymous: true, Timezone: timezone}
+		if *prefs != expected {
+			t.Errorf("expected update to keep unset preferences, got %+v", prefs)
+		}
+		if prefs, err = store.GetPreferences(ctx, "prefs"); err != nil || *prefs != expected {
+			t.Errorf("expected %+v, got %+v %v", expected, prefs, err)
+		}
+	}
+}
+
+func integration_Messages(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		alice := NewIdentity("dm-alice", "alice@gmail.com", "alice", nil, "127.0.0.1")
+		bob := NewIdentity("dm-bob", "bob@gmail.com", "bob", nil, "127.0.0.2")
+		for _, identity := range []*Identity{alice, bob} {
+			if err := store.RecordAccount(ctx, identity.ID, identity.Username); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := store.SendMessage(ctx, alice, "nobody", "hi"); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound for unknown recipient, got %v", err)
+		}
+		if _, err := store.SendMessage(ctx, alice, "alice", "hi"); err != ErrCantMessage {
+			t.Errorf("expected ErrCantMessage messaging self, got %v", err)
+		}
+
+		first, err := store.SendMessage(ctx, alice, "bob", "hi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := store.SendMessage(ctx, bob, "alice", "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Conversation != second.Conversation {
+			t.Errorf("expected replies in the same conversation, got %d and %d", first.Conversation, second.Conversation)
+		}
+
+		conversations, err := store.GetConversations(ctx, bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conversations) != 1 || conversations[0].With != "alice" || conversations[0].Unread != 1 {
+			t.Errorf("expected one conversation with alice with one unread, got %+v", conversations)
+		}
+
+		messages, err := store.GetMessages(ctx, bob.ID, first.Conversation, second.ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 1 || messages[0].ID != first.ID {
+			t.Errorf("expected only the message before %d, got %+v", second.ID, messages)
+		}
+		if unread, err := store.CountUnreadMessages(ctx, bob.ID); err != nil || unread != 0 {
+			t.Errorf("expected messages fetched to be read, got %d %v", unread, err)
+		}
+		if _, err := store.GetMessages(ctx, "dm-eve", first.Conversation, 0, 10); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound for others' conversation, got %v", err)
+		}
+
+		if err := store.SetBlocked(ctx, bob.ID, "alice", true); err != nil {
+			t.Fatal(err)
+		}
+		if blocked, err := store.GetBlocked(ctx, bob.ID); err != nil || len(blocked) != 1 || blocked[0] != "alice" {
+			t.Errorf("expected alice blocked, got %v %v", blocked, err)
+		}
+		if _, err := store.SendMessage(ctx, alice, "bob", "hi?"); err != ErrCantMessage {
+			t.Errorf("expected ErrCantMessage when blocked, got %v", err)
+		}
+		if err := store.SetBlocked(ctx, bob.ID, "alice", false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.SendMessage(ctx, alice, "bob", "hi!"); err != nil {
+			t.Errorf("expected message once unblocked, got %v", err)
+		}
+
+		// A second account taking bob's username leaves it unclear who's meant.
+		if err := store.RecordAccount(ctx, "dm-bob-2", "bob"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.SendMessage(ctx, alice, "bob", "which bob?"); err != ErrAmbiguousUsername {
+			t.Errorf("expected ErrAmbiguousUsername messaging a shared username, got %v", err)
+		}
+		if err := store.SetBlocked(ctx, alice.ID, "bob", true); err != ErrAmbiguousUsername {
+			t.Errorf("expected ErrAmbiguousUsername blocking a shared username, got %v", err)
+		}
+	}
+}
+
+func integration_Mentions(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		for id, username := range map[string]string{"mention-op": "mentioner", "mention-a": "Mentioned", "mention-b": "muted"} {
+			if err := store.RecordAccount(ctx, id, username); err != nil {
+				t.Fatal(err)
+			}
+		}
+		muted := true
+		if _, err := store.UpdatePreferences(ctx, "mention-b", &PreferencesUpdate{MuteMentions: &muted}); err != nil {
+			t.Fatal(err)
+		}
+
+		post := &Post{Cat: "test-1", Num: 2, Parent: 1, Username: "mentioner"}
+		notified, err := store.WriteMentions(ctx, post, "mention-op", []string{"mentioned", "muted", "mentioner", "nobody"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notified != 1 {
+			t.Errorf("expected only the unmuted account notified, got %d", notified)
+		}
+
+		if unread, err := store.CountUnreadNotifications(ctx, "mention-a"); err != nil || unread != 1 {
+			t.Errorf("expected 1 unread notification, got %d %v", unread, err)
+		}
+		notifications, err := store.GetNotifications(ctx, "mention-a", 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notifications) != 1 || notifications[0].From != "mentioner" || notifications[0].Num != 2 || notifications[0].Read {
+			t.Errorf("expected an unread mention from mentioner, got %+v", notifications)
+		}
+		if unread, err := store.CountUnreadNotifications(ctx, "mention-a"); err != nil || unread != 0 {
+			t.Errorf("expected notifications fetched to be read, got %d %v", unread, err)
+		}
+	}
+}
+
+func integration_Bookmarks(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("marks").Named("bookmarks"))
+
+		poster := &Identity{Username: "x", Email: "x@marks.com", IP: "10.0.0.11"}
+		thread, err := store.WritePost(ctx, "marks", 0, "saved thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := store.WritePost(ctx, "marks", thread, "", "saved reply", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.SetBookmark(ctx, "marker", "marks", 500, true); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound bookmarking a missing post, got %v", err)
+		}
+		for _, num := range []int{thread, reply, reply} {
+			if err := store.SetBookmark(ctx, "marker", "marks", num, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		bookmarks, err := store.GetBookmarks(ctx, "marker", 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bookmarks) != 2 {
+			t.Fatalf("expected 2 bookmarks, got %d", len(bookmarks))
+		}
+		if bookmarks[0].Post.Num != reply || bookmarks[0].Thread != thread || bookmarks[0].ThreadSubject != "saved thread" {
+			t.Errorf("expected newest bookmark to be the reply in its thread, got %+v", bookmarks[0])
+		}
+		older, err := store.GetBookmarks(ctx, "marker", bookmarks[0].ID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(older) != 1 || older[0].Post.Num != thread || older[0].Thread != thread {
+			t.Errorf("expected the thread bookmarked before, got %+v", older)
+		}
+
+		if err := store.SetBookmark(ctx, "marker", "marks", thread, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.RemovePost(ctx, "marks", reply); err != nil {
+			t.Fatal(err)
+		}
+		if bookmarks, err = store.GetBookmarks(ctx, "marker", 0, 10); err != nil || len(bookmarks) != 0 {
+			t.Errorf("expected no bookmarks left, got %d %v", len(bookmarks), err)
+		}
+	}
+}
+
+func integration_ShareLinks(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("shared"))
+
+		poster := &Identity{Username: "x", Email: "x@share.com", IP: "10.0.0.12"}
+		thread, err := store.WritePost(ctx, "shared", 0, "shared thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := store.WritePost(ctx, "shared", thread, "", "shared reply", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.WriteShareLink(ctx, "shared", reply, 0); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound linking a reply as a thread, got %v", err)
+		}
+		if _, err := store.WriteShareLink(ctx, "shared", thread, 500); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound linking a post not in the thread, got %v", err)
+		}
+		token, err := store.WriteShareLink(ctx, "shared", thread, reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := store.WriteShareLink(ctx, "shared", thread, reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != token {
+			t.Errorf("expected the same link to %d/%d, got %s and %s", thread, reply, token, again)
+		}
+
+		if err := store.SetCategorySlug(ctx, "shared", "renamed"); err != nil {
+			t.Fatal(err)
+		}
+		store.FollowShareLink(ctx, token)
+		link, err := store.FollowShareLink(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := ShareLink{Token: token, Cat: "shared", Thread: thread, Post: reply, Slug: "renamed", Clicks: 2}
+		if *link != expected {
+			t.Errorf("expected %+v, got %+v", expected, link)
+		}
+		if _, err := store.FollowShareLink(ctx, "missing"); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound for unknown token, got %v", err)
+		}
+	}
+}
+
+func integration_Digests(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("digest1").Named("one"), newCategory("digest2").Named("two"))
+
+		if err := store.SetCategorySubscription(ctx, "digester", "nope", true); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound subscribing to a missing category, got %v", err)
+		}
+		if err := store.SetCategorySubscription(ctx, "digester", "digest1", true); err != nil {
+			t.Fatal(err)
+		}
+		if subscriptions, err := store.GetCategorySubscriptions(ctx, "digester"); err != nil || len(subscriptions) != 1 {
+			t.Errorf("expected one subscription, got %v %v", subscriptions, err)
+		}
+
+		poster := &Identity{Username: "x", Email: "x@digest.com", IP: "10.0.0.13"}
+		quiet, err := store.WritePost(ctx, "digest1", 0, "quiet thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		busy, err := store.WritePost(ctx, "digest1", 0, "busy thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := store.WritePost(ctx, "digest1", busy, "", "reply", poster, "", "", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := store.WritePost(ctx, "digest2", 0, "unsubscribed", "content", poster, "", "", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.WriteDigests(ctx, 10); err != nil {
+			t.Fatal(err)
+		}
+		digests, err := store.GetDigests(ctx, "digester", 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(digests) != 1 || len(digests[0].Threads) != 2 {
+			t.Fatalf("expected one digest of the subscribed category's two threads, got %+v", digests)
+		}
+		threads := digests[0].Threads
+		if threads[0].Num != busy || threads[0].Replies != 3 || threads[1].Num != quiet {
+			t.Errorf("expected the busy thread first, got %d then %d", threads[0].Num, threads[1].Num)
+		}
+
+		// Nothing new since the last digest.
+		if _, err := store.WriteDigests(ctx, 10); err != nil {
+			t.Fatal(err)
+		}
+		if digests, err = store.GetDigests(ctx, "digester", 0, 10); err != nil || len(digests) != 1 {
+			t.Errorf("expected no empty digests, got %d %v", len(digests), err)
+		}
+	}
+}
+
+func integration_Stats(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		before, err := store.GetStats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		setUpFixtures(ctx, t, store, newCategory("stats"))
+
+		poster := &Identity{Username: "x", Email: "x@stats.com", IP: "10.0.0.14"}
+		thread, err := store.WritePost(ctx, "stats", 0, "counted thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.WritePost(ctx, "stats", thread, "", "counted reply", poster, "", "", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		after, err := store.GetStats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Posts != before.Posts+2 || after.PostsToday != before.PostsToday+2 {
+			t.Errorf("expected 2 more posts, got %+v then %+v", before, after)
+		}
+		if after.ActiveThreads != before.ActiveThreads+1 || after.Categories != before.Categories+1 {
+			t.Errorf("expected 1 more active thread and category, got %+v then %+v", before, after)
+		}
+	}
+}
+
+func integration_Impersonation(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		imp := &Impersonation{
+			Admin:    "admin@impersonation.com",
+			UserID:   "auth0|impersonated",
+			Username: "impersonated",
+			Email:    "x@impersonation.com",
+			Scope:    ScopeRead,
+			Reason:   "debugging",
+			Expires:  time.Now().Add(time.Minute),
+		}
+		token, err := store.WriteImpersonation(ctx, imp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.GetImpersonationByToken(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != imp.ID || got.UserID != imp.UserID || got.Scope != ScopeRead {
+			t.Errorf("unexpected impersonation: %+v", got)
+		}
+		if _, err := store.GetImpersonationByToken(ctx, "not a token"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected unknown token to be ErrNotFound, got: %v", err)
+		}
+
+		if err := store.LogImpersonationAction(ctx, imp.ID, "GET", "/v1/me"); err != nil {
+			t.Fatal(err)
+		}
+		actions, err := store.GetImpersonationActions(ctx, imp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actions) != 1 || actions[0].Method != "GET" || actions[0].Path != "/v1/me" {
+			t.Errorf("unexpected audit trail: %+v", actions)
+		}
+
+		if err := store.RevokeImpersonation(ctx, imp.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetImpersonationByToken(ctx, token); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected revoked token to be ErrNotFound, got: %v", err)
+		}
+		if err := store.RevokeImpersonation(ctx, -1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected revoking unknown impersonation to be ErrNotFound, got: %v", err)
+		}
+
+		expired := *imp
+		expired.Expires = time.Now().Add(-time.Minute)
+		token, err = store.WriteImpersonation(ctx, &expired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetImpersonationByToken(ctx, token); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected expired token to be ErrNotFound, got: %v", err)
+		}
+	}
+}
+
+func integration_IndexAudit(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		tx, err := store.pgPool.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback(ctx)
+		// Tables are tiny under test, so make sequential scans a last resort to see which indexes could be used.
+		_, err = tx.Exec(ctx, "SET LOCAL enable_seqscan = off")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		queries := map[string]string{
+			"category lookup":  "SELECT * FROM cats WHERE tag = 'audit'",
+			"post lookup":      "SELECT * FROM posts WHERE cat = 'audit' AND num = 1",
+			"reply lookup":     "SELECT * FROM posts WHERE cat = 'audit' AND parent = 1 ORDER BY num",
+			"category threads": "SELECT * FROM posts WHERE cat = 'audit' AND parent = 0 ORDER BY num DESC",
+		}
+		for name, query := range queries {
+			var plan strings.Builder
+			rows, err := tx.Query(ctx, "EXPLAIN "+query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rows.Next() {
+				var line string
+				if err := rows.Scan(&line); err != nil {
+					t.Fatal(err)
+				}
+				plan.WriteString(line + "\n")
+			}
+			rows.Close()
+			if strings.Contains(plan.String(), "Seq Scan") {
+				t.Errorf("%s isn't covered by an index:\n%s", name, plan.String())
+			}
+		}
+	}
+}
+
+func integration_BulkImportPrune(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("bulkio").WithThreads(1))
+		defer store.pgPool.Exec(ctx, "DELETE FROM post_archive WHERE cat = 'bulkio'")
+
+		old := time.Now().AddDate(-1, 0, 0)
+		var posts []*ImportedPost
+		for thread := 100; thread < 103; thread++ {
+			posts = append(posts, &ImportedPost{Num: thread, Subject: "imported", Content: "thread", CreatedAt: old})
+			for reply := 0; reply < 2; reply++ {
+				posts = append(posts, &ImportedPost{Num: thread*10 + reply, Parent: thread, Content: "reply", CreatedAt: old})
+			}
+		}
+		_, err := store.ImportPosts(ctx, "bulkio", []*ImportedPost{{Num: 1, Parent: 5}}, BulkOptions{})
+		if err == nil {
+			t.Error("expected an error importing a reply before its thread")
+		}
+
+		var batches int
+		imported, err := store.ImportPosts(ctx, "bulkio", posts, BulkOptions{BatchSize: 2, Progress: func(done int, total int) {
+			batches++
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imported != len(posts) || batches != 5 {
+			t.Errorf("expected %d posts in 5 batches, got %d in %d", len(posts), imported, batches)
+		}
+		// The fixture's thread is post 1, so the first imported thread is post 2.
+		view, err := store.GetThreadView(ctx, "bulkio", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(view.Posts) != 3 || view.Posts[1].Num != 3 || view.Posts[2].Num != 4 {
+			t.Errorf("expected imported thread renumbered with its replies, got: %v", view.Posts)
+		}
+		num, err := store.WritePost(ctx, "bulkio", 0, "after", "import", &Identity{IP: "127.0.0.1"}, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if num != 11 {
+			t.Errorf("expected posts to be numbered after the import, got: %d", num)
+		}
+
+		var exported []*ImportedPost
+		count, err := store.ExportPosts(ctx, "bulkio", func(post *ImportedPost) error {
+			exported = append(exported, post)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 11 || len(exported) != 11 || exported[2].Num != 3 || exported[2].Parent != 2 || exported[2].Content != "reply" {
+			t.Errorf("expected every post exported in order, got %d: %+v", count, exported)
+		}
+
+		pruned, err := store.PruneThreads(ctx, "bulkio", time.Now().AddDate(0, -1, 0), true, BulkOptions{BatchSize: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pruned.Threads != 3 || pruned.Posts != 9 {
+			t.Errorf("expected 3 imported threads with 9 posts pruned, got: %+v", pruned)
+		}
+		var archived int
+		err = store.pgPool.QueryRow(ctx, "SELECT COUNT(*) FROM post_archive WHERE cat = 'bulkio'").Scan(&archived)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if archived != 9 {
+			t.Errorf("expected 9 archived posts, got: %d", archived)
+		}
+		if _, err := store.GetThreadView(ctx, "bulkio", 1); err != nil {
+			t.Errorf("expected recent thread to be kept, got: %v", err)
+		}
+	}
+}
+
+func integration_ThreadCatalog(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		written := setUpFixtures(ctx, t, store, newCategory("catalog").WithThreads(2).WithReplies(2))
+		first := written.Threads["catalog"][0]
+
+		view, err := store.GetCategoryView(ctx, "catalog")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(view.Threads) != 2 {
+			t.Fatalf("expected 2 threads, got: %d", len(view.Threads))
+		}
+		for _, thread := range view.Threads {
+			if thread.Replies != 2 || thread.BumpedAt == nil || thread.BumpedAt.Before(thread.CreatedAt) {
+				t.Errorf("expected thread %d with 2 replies bumped after it was made, got: %+v", thread.Num, thread)
+			}
+		}
+
+		_, err = store.RemovePost(ctx, "catalog", first+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		view, err = store.GetCategoryView(ctx, "catalog")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.Threads[0].Replies != 1 {
+			t.Errorf("expected a removed reply to be uncounted, got: %d", view.Threads[0].Replies)
+		}
+
+		_, err = store.pgPool.Exec(ctx, "UPDATE thread_catalog SET replies = 9 WHERE cat = 'catalog'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixed, err := store.RefreshCatalog(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fixed < 2 {
+			t.Errorf("expected both drifted threads to be corrected, got: %d", fixed)
+		}
+		view, err = store.GetCategoryView(ctx, "catalog")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.Threads[0].Replies != 1 || view.Threads[1].Replies != 2 {
+			t.Errorf("expected the catalog recounted, got %d and %d", view.Threads[0].Replies, view.Threads[1].Replies)
+		}
+	}
+}
+
+func integration_CheckPostCounts(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("fsck").WithThreads(2).WithReplies(1))
+
+		_, err := store.pgPool.Exec(ctx, "UPDATE cats SET post_count = 3 WHERE tag = 'fsck'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.WritePost(ctx, "fsck", 0, "collides", "collides", &Identity{IP: "127.0.0.1"}, "", "", nil)
+		if err == nil {
+			t.Fatal("expected a drifted counter to fail the next post")
+		}
+
+		findDrift := func(drifted []*CounterDrift) *CounterDrift {
+			for _, drift := range drifted {
+				if drift.Tag == "fsck" {
+					return drift
+				}
+			}
+			return nil
+		}
+		drifted, err := store.CheckPostCounts(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drift := findDrift(drifted)
+		if drift == nil || drift.PostCount != 3 || drift.Highest != 4 {
+			t.Fatalf("expected the counter reported behind post 4, got: %+v", drift)
+		}
+		drifted, err = store.CheckPostCounts(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if findDrift(drifted) == nil {
+			t.Error("expected checking alone not to repair the counter")
+		}
+
+		_, err = store.CheckPostCounts(ctx, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		num, err := store.WritePost(ctx, "fsck", 0, "after", "after", &Identity{IP: "127.0.0.1"}, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if num != 5 {
+			t.Errorf("expected the next post after the highest, got: %d", num)
+		}
+
+		// Counters ahead of their posts are left alone.
+		_, err = store.RemovePost(ctx, "fsck", num)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drifted, err = store.CheckPostCounts(ctx, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if findDrift(drifted) != nil {
+			t.Error("expected a counter past a removed post not to be reported")
+		}
+	}
+}
+
+func integration_Complaints(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("abuse").WithThreads(2))
+
+		complaint := &Complaint{
+			Kind:         ComplaintDMCA,
+			Name:         "Owl",
+			Email:        "owl@example.com",
+			Details:      "these posts copy my book",
+			Work:         "My book",
+			Signature:    "Owl",
+			Posts:        []PostRef{{Cat: "abuse", Num: 2}, {Cat: "abuse", Num: 2}, {Cat: "abuse", Num: 99}},
+			ReporterHash: PosterHash("127.0.0.1"),
+		}
+		reported, err := store.WriteComplaint(ctx, complaint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if complaint.ID < 1 || reported != 1 {
+			t.Fatalf("expected the one existing post reported once, got %d on complaint %d", reported, complaint.ID)
+		}
+
+		// Older reports are still behind the complaint's.
+		err = store.WriteReport(ctx, "abuse", 1, "spam", PosterHash("127.0.0.2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.pgPool.Exec(ctx, "UPDATE reports SET created_at = created_at - interval '1 day' WHERE cat = 'abuse' AND num = 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		queue, err := store.GetModerationQueue(ctx, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(queue) == 0 || queue[0].Post.Cat != "abuse" || queue[0].Post.Num != 2 || !queue[0].Priority {
+			t.Fatalf("expected the complained about post first in the queue, got: %+v", queue)
+		}
+
+		got, err := store.GetComplaint(ctx, complaint.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Kind != ComplaintDMCA || got.Signature != "Owl" || len(got.Posts) != 3 || got.Posts[2].Num != 99 {
+			t.Errorf("expected the complaint back with every post it named, got: %+v", got)
+		}
+		if _, err := store.GetComplaint(ctx, complaint.ID+1000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a missing complaint to be ErrNotFound, got: %v", err)
+		}
+
+		err = store.ResolveQueueItem(ctx, "abuse", 2, Resolution{Action: ActionDelete})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.ResolveQueueItem(ctx, "abuse", 1, Resolution{Action: ActionApprove})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func integration_ClientKeys(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		clientKey, key, err := store.WriteClientKey(ctx, "archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clientKey.ID < 1 || len(key) == 0 {
+			t.Fatalf("expected a key to be issued, got %q for %+v", key, clientKey)
+		}
+
+		valid, err := store.IsClientKeyValid(ctx, ClientKeyHash(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid {
+			t.Error("expected the issued key to be valid")
+		}
+		valid, err = store.IsClientKeyValid(ctx, ClientKeyHash("made-up"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid {
+			t.Error("expected a key that was never issued not to be valid")
+		}
+
+		err = store.RevokeClientKey(ctx, clientKey.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RevokeClientKey(ctx, clientKey.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected revoking twice to be ErrNotFound, got: %v", err)
+		}
+		valid, err = store.IsClientKeyValid(ctx, ClientKeyHash(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid {
+			t.Error("expected the revoked key not to be valid")
+		}
+
+		keys, err := store.GetClientKeys(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) == 0 || keys[0].ID != clientKey.ID || keys[0].RevokedAt == nil {
+			t.Errorf("expected the revoked key listed first, got: %+v", keys)
+		}
+	}
+}
+
+func integration_Changes(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("changes"))
+
+		// Other tests' changes come first.
+		var since int64
+		for {
+			changes, err := store.GetChanges(ctx, since, 500)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) == 0 {
+				break
+			}
+			since = changes[len(changes)-1].Cursor
+		}
+
+		identity := &Identity{Username: "username", Email: "email", IP: "ip"}
+		thread, err := store.WritePost(ctx, "changes", 0, "subject", "content", identity, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := store.WritePost(ctx, "changes", thread, "", "reply", identity, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.RemovePost(ctx, "changes", reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changes, err := store.GetChanges(ctx, since, 500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 3 {
+			t.Fatalf("expected 3 changes, got %d", len(changes))
+		}
+		expected := []struct {
+			kind ChangeKind
+			num  int
+		}{{PostCreated, thread}, {PostCreated, reply}, {PostDeleted, reply}}
+		for i, change := range changes {
+			if change.Kind != expected[i].kind || change.Num != expected[i].num || change.Thread != thread {
+				t.Errorf("expected change %d to be post %d %s, got: %+v", i, expected[i].num, expected[i].kind, change)
+			}
+		}
+		if changes[0].Post == nil || changes[0].Post.Content != "content" || len(changes[0].Post.Hash) == 0 {
+			t.Errorf("expected the thread as it is now, got: %+v", changes[0].Post)
+		}
+		if changes[1].Post != nil {
+			t.Errorf("expected the deleted reply to be left out, got: %+v", changes[1].Post)
+		}
+
+		// Moderation is recorded with the moderator who took it.
+		_, err = store.pgPool.Exec(ctx, "UPDATE posts SET quarantined = true WHERE cat = 'changes' AND num = $1", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modCtx := WithIdentity(ctx, &Identity{ID: "auth0|mod", Email: "mod", IP: "ip"})
+		err = store.ResolveQueueItem(modCtx, "changes", thread, Resolution{Action: ActionApprove})
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes, err = store.GetChanges(ctx, changes[len(changes)-1].Cursor, 500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 2 || changes[0].Kind != PostModerated || changes[1].Kind != PostModerated {
+			t.Fatalf("expected 2 moderation changes, got: %+v", changes)
+		}
+		if changes[0].Post == nil {
+			t.Errorf("expected the approved thread as it is now, got: %+v", changes[0].Post)
+		}
+
+		events, err := store.GetPostEvents(ctx, "changes", thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 3 || events[0].Kind != PostCreated || events[1].Detail != "quarantined" ||
+			events[2].Detail != "approved" || events[2].Actor != "auth0|mod" || len(events[1].Actor) > 0 {
+			t.Errorf("expected the thread's creation, quarantine and approval by the moderator, got: %+v", events)
+		}
+		events, err = store.GetPostEvents(ctx, "changes", reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 || events[1].Kind != PostDeleted {
+			t.Errorf("expected the deleted reply's events kept, got: %+v", events)
+		}
+	}
+}
+
+func integration_Webhooks(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("hooked"))
+
+		if err := store.WriteWebhook(ctx, &Webhook{URL: "https://hooks.example", Cat: "unhooked", Format: WebhookJSON}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a webhook on a missing category to be ErrNotFound, got: %v", err)
+		}
+
+		hook := &Webhook{
+			URL:     "https://hooks.example",
+			Cat:     "hooked",
+			Events:  []string{"post.created"},
+			Format:  WebhookDiscord,
+			Secret:  "shh",
+			Enabled: true,
+		}
+		err := store.WriteWebhook(ctx, hook)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hook.ID < 1 || !hook.Signed {
+			t.Fatalf("expected a signed webhook, got: %+v", hook)
+		}
+
+		err = store.UpdateWebhook(ctx, &Webhook{ID: hook.ID, URL: "https://hooks.example/2", Format: WebhookJSON})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hooks, err := store.GetWebhooks(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got *Webhook
+		for _, h := range hooks {
+			if h.ID == hook.ID {
+				got = h
+			}
+		}
+		if got == nil || got.URL != "https://hooks.example/2" || len(got.Cat) > 0 || len(got.Events) > 0 || got.Secret != "shh" || got.Enabled {
+			t.Errorf("expected a disabled webhook on every category keeping its secret, got: %+v", got)
+		}
+
+		err = store.RemoveWebhook(ctx, hook.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateWebhook(ctx, hook); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected updating a removed webhook to be ErrNotFound, got: %v", err)
+		}
+	}
+}
+
+func integration_ConcurrentThreadWrites(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		categoryThreadCountMap := map[string]int{
+			"test1": 45,
+			"test2": 22,
+			"test3": 10,
+		}
+		setUpFixtures(ctx, t, store, newCategory("test1").Named("aa"), newCategory("test2").Named("bb"), newCategory("test3").Named("cc"))
+
+		t.Run("Concurent thread writes", concurrentThreadWriteTest(ctx, store, categoryThreadCountMap))
+	}
+}
+
+/*
+*
+Test writing valid & invalid posts
+*/
+func integration_WritePosts(ctx context.Context, datastore *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		t.Run("invalid category", func(t *testing.T) {
+			_, err := datastore.WritePost(ctx, "invalid-category", 0, "beep", "boop", &Identity{Username: "a", Email: "b", IP: "c"}, "", "", nil)
+			if err == nil {
+				t.Errorf("expected writepost error, got: %v", err)
+			}
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected an ErrNotFound from writepost, got: %v", err)
+			}
+		})
+
+		t.Run("valid category, valid thread", func(t *testing.T) {
+			name := "beew"
+			setUpFixtures(ctx, t, datastore, newCategory(name).Named("meowmeow"))
+
+			num, err := datastore.WritePost(ctx, name, 0, "beep", "boop", &Identity{Username: "a", Email: "b", IP: "c"}, "", "", nil)
+			if err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+			if num != 1 {
+				t.Errorf("expected first post number 1, got: %d", num)
+			}
+		})
+
+		t.Run("valid category, invalid parent post", func(t *testing.T) {
+			name := "beew"
+			setUpFixtures(ctx, t, datastore, newCategory(name).Named("meow"))
+
+			_, err := datastore.WritePost(ctx, name, 5, "beep", "boop", &Identity{Username: "a", Email: "b", IP: "c"}, "", "", nil)
+			if err == nil || !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got: %v", err)
+			}
+		})
+	}
+}
+
+/*
+Takes a map of category names and their number of threads to create.
+Creates all categories, and then writes n threads to each category concurrently.
+*/
+func concurrentThreadWriteTest(ctx context.Context, datastore *DataStore, tests map[string]int) func(t *testing.T) {
+	return func(t *testing.T) {
+		for categoryName, threadCount := range tests {
+			threadCount := threadCount
+			categoryName := categoryName
+			t.Run(categoryName, func(t *testing.T) {
+				t.Parallel()
+				// write n posts concurrently to a category
+				var wg sync.WaitGroup
+				categoryName := categoryName
+				for i := 0; i < threadCount; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						_, err := datastore.WritePost(ctx, categoryName, 0, "beep", "boop", &Identity{Username: "a", Email: "b", IP: "c"}, "", "", nil)
+						if err != nil {
+							panic(err)
+						}
+					}()
+				}
+				wg.Wait()
+
+				count, err := datastore.GetThreadCount(ctx, categoryName)
+				if err != nil {
+					t.Errorf("failed to get thread count on category %s: %v", categoryName, err)
+				}
+				if count != threadCount {
+					t.Errorf("expected %d threads, got %d", threadCount, count)
+				}
+			})
+		}
+	}
+}
+
+func integration_Confirmations(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		token, err := store.WriteConfirmation(ctx, "auth0|admin", "request", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UseConfirmation(ctx, token, "auth0|admin", "other request"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a token for another request to be ErrNotFound, got: %v", err)
+		}
+		if err := store.UseConfirmation(ctx, token, "auth0|other", "request"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a token issued to someone else to be ErrNotFound, got: %v", err)
+		}
+		if err := store.UseConfirmation(ctx, token, "auth0|admin", "request"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UseConfirmation(ctx, token, "auth0|admin", "request"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a used token to be ErrNotFound, got: %v", err)
+		}
+
+		expired, err := store.WriteConfirmation(ctx, "auth0|admin", "request", time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UseConfirmation(ctx, expired, "auth0|admin", "request"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected an expired token to be ErrNotFound, got: %v", err)
+		}
+	}
+}
+
+func integration_BlockedDomains(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		_, err := store.BlockDomain(ctx, "*.spam.example", DomainReject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.RecordBlockedLinks(ctx, "*.spam.example", "never.example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocked, err := store.BlockDomain(ctx, "*.spam.example", DomainStrip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocked.Action != DomainStrip || blocked.Blocked != 1 || blocked.LastBlockedAt == nil {
+			t.Errorf("expected the action changed and the count kept, got %+v", blocked)
+		}
+
+		domains, err := store.GetBlockedDomains(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(domains) != 1 || !domains[0].Matches("www.spam.example") || !domains[0].Matches("spam.example") ||
+			domains[0].Matches("notspam.example") {
+			t.Errorf("expected one wildcard domain, got %+v", domains)
+		}
+
+		err = store.UnblockDomain(ctx, "*.spam.example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UnblockDomain(ctx, "*.spam.example"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected unblocking twice to be ErrNotFound, got: %v", err)
+		}
+	}
+}
+
+func integration_Canaries(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("canary"))
+		poster := &Identity{Username: "a", Email: "a@canary.com", IP: "10.0.0.13"}
+
+		if err := store.WriteCanary(ctx, &Canary{Cat: "missing", Subject: "bait", Content: "bait"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a canary on a missing category to be ErrNotFound, got: %v", err)
+		}
+		canary := &Canary{Cat: "canary", Label: "sitemap", Subject: "bait thread", Content: "bait"}
+		if err := store.WriteCanary(ctx, canary); err != nil {
+			t.Fatal(err)
+		}
+		num, err := store.WritePost(ctx, "canary", 0, "real thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if num != canary.Num+1 {
+			t.Errorf("expected the next thread to be numbered after canary %d, got %d", canary.Num, num)
+		}
+		if _, err := store.GetThreadView(ctx, "canary", canary.Num); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected no thread at the canary's number, got: %v", err)
+		}
+
+		err = store.RecordCanaryHit(ctx, &CanaryHit{CanaryID: canary.ID, IP: "203.0.113.9", UserAgent: "scraper"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err := store.GetCanary(ctx, "canary", canary.Num)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Hits != 1 || found.LastHitAt == nil || found.Subject != "bait thread" {
+			t.Errorf("expected the canary with its hit counted, got %+v", found)
+		}
+		hits, err := store.GetCanaryHits(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) == 0 || hits[0].Num != canary.Num || hits[0].IP != "203.0.113.9" {
+			t.Errorf("expected the latest hit first, got %+v", hits)
+		}
+		if _, err := store.GetCanary(ctx, "canary", num); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected real threads not to be canaries, got: %v", err)
+		}
+	}
+}
+
+func integration_PIIEncryption(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		if _, err := store.RotatePIIKeys(ctx, 10); !errors.Is(err, ErrPIIDisabled) {
+			t.Errorf("expected ErrPIIDisabled, got: %v", err)
+		}
+		setUpFixtures(ctx, t, store, newCategory("pii"))
+		poster := &Identity{Username: "a", Email: "a@pii.com", IP: "10.0.0.14"}
+
+		// Written before encryption's enabled.
+		if _, err := store.WritePost(ctx, "pii", 0, "plain", "content", poster, "", "", nil); err != nil {
+			t.Fatal(err)
+		}
+		oldKey := bytes.Repeat([]byte("o"), 32)
+		if err := store.EnablePIIEncryption(PIIOptions{Keys: [][]byte{oldKey}}); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { store.pii = nil }()
+		if _, err := store.WritePost(ctx, "pii", 1, "", "sealed", poster, "", "", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		stored := func() map[string]string {
+			rows, err := store.pgPool.Query(ctx, "SELECT content, email, ip, email_hash FROM posts WHERE cat = 'pii'")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			emails := make(map[string]string)
+			for rows.Next() {
+				var content, email, ip, emailHash string
+				if err := rows.Scan(&content, &email, &ip, &emailHash); err != nil {
+					t.Fatal(err)
+				}
+				if emailHash != PosterHash(poster.Email) {
+					t.Errorf("expected %q's email hash of the plaintext, got %s", content, emailHash)
+				}
+				if strings.HasPrefix(email, piiPrefix) != strings.HasPrefix(ip, piiPrefix) {
+					t.Errorf("expected %q's email and IP sealed together, got %q and %q", content, email, ip)
+				}
+				emails[content] = email
+			}
+			return emails
+		}
+		emails := stored()
+		if emails["content"] != poster.Email || !strings.HasPrefix(emails["sealed"], store.pii.current()) {
+			t.Errorf("expected only the post written after enabling encryption sealed, got %v", emails)
+		}
+
+		history, err := store.GetPosterHistory(ctx, PosterHash(poster.IP))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history.Posts) != 2 {
+			t.Errorf("expected both posts found by the IP hash, got %d", len(history.Posts))
+		}
+
+		if err := store.EnablePIIEncryption(PIIOptions{Keys: [][]byte{bytes.Repeat([]byte("n"), 32), oldKey}}); err != nil {
+			t.Fatal(err)
+		}
+		rotated, err := store.RotatePIIKeys(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated["posts"] < 2 {
+			t.Errorf("expected both posts rekeyed, got %v", rotated)
+		}
+		for content, email := range stored() {
+			if !strings.HasPrefix(email, store.pii.current()) {
+				t.Errorf("expected %q sealed with the new key, got %q", content, email)
+			}
+		}
+		if again, err := store.RotatePIIKeys(ctx, 10); err != nil || again["posts"] != 0 {
+			t.Errorf("expected nothing left to rekey, got %v %v", again, err)
+		}
+
+		// The new key alone reads everything back, retaining removed posts decrypted.
+		if err := store.EnablePIIEncryption(PIIOptions{Keys: [][]byte{bytes.Repeat([]byte("n"), 32)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.EnableRetention(RetentionOptions{Key: make([]byte, 32), Days: 1}); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { store.retention = nil }()
+		if _, err := store.RemovePost(ctx, "pii", 1); err != nil {
+			t.Fatal(err)
+		}
+		retained, err := store.GetRetainedPosts(ctx, "pii", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(retained) != 1 || retained[0].Email != poster.Email || retained[0].IP != poster.IP {
+			t.Errorf("expected the reply retained with its email and IP decrypted, got %+v", retained)
+		}
+		store.pgPool.Exec(ctx, "DELETE FROM retained_posts WHERE cat = 'pii'")
+	}
+}
+
+func integration_Anonymize(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("anon"))
+		defer store.pgPool.Exec(ctx, "DELETE FROM anonymize_jobs WHERE cat = 'anon'")
+
+		defer store.pgPool.Exec(ctx, "DELETE FROM post_archive WHERE cat = 'anon'")
+
+		poster := &Identity{ID: "auth0|anon", Username: "a", Email: "a@anon.com", IP: "10.0.0.15"}
+		var old []int
+		for i := 0; i < 3; i++ {
+			num, err := store.WritePost(ctx, "anon", 0, "old thread", "content", poster, "", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			old = append(old, num)
+		}
+		_, err := store.pgPool.Exec(
+			ctx,
+			"INSERT INTO post_archive (cat, num, post) SELECT cat, num, to_jsonb(posts) FROM posts WHERE cat = 'anon' AND num = $1",
+			old[0],
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := time.Now()
+		recent, err := store.WritePost(ctx, "anon", 0, "new thread", "content", poster, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.QueueAnonymization(ctx, "nothing", before, "admin@anon.com"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound anonymizing a missing category, got: %v", err)
+		}
+		job, err := store.QueueAnonymization(ctx, "anon", before, "admin@anon.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Total != 4 || job.Status != JobQueued {
+			t.Errorf("expected 3 posts and an archived post queued for anonymizing, got %+v", job)
+		}
+
+		var anonymized []*Post
+		for job.Status != JobDone {
+			var posts []*Post
+			job, posts, err = store.AnonymizeBatch(ctx, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			anonymized = append(anonymized, posts...)
+		}
+		if job.Done != 4 || job.FinishedAt == nil {
+			t.Errorf("expected all 4 posts anonymized, got %+v", job)
+		}
+		if len(anonymized) != 3 {
+			t.Errorf("expected the 3 live posts returned for reindexing, got %d", len(anonymized))
+		}
+		for _, post := range anonymized {
+			if post.Username != AnonymousName {
+				t.Errorf("expected anonymized posts returned as they are now, got %d by %s", post.Num, post.Username)
+			}
+		}
+		if _, _, err := store.AnonymizeBatch(ctx, 2); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected no jobs left, got: %v", err)
+		}
+
+		view, err := store.GetCategoryView(ctx, "anon")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, thread := range view.Threads {
+			if (thread.Num == recent) != (thread.Username == poster.Username) {
+				t.Errorf("expected only posts from before the job anonymized, got %d by %s", thread.Num, thread.Username)
+			}
+		}
+		var emails int
+		err = store.pgPool.QueryRow(ctx, "SELECT count(*) FROM posts WHERE cat = 'anon' AND email != ''").Scan(&emails)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if emails != 1 {
+			t.Errorf("expected only the newer post's email kept, got %d", emails)
+		}
+		owned, err := store.GetPostsByAuthor(ctx, poster.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(owned) != 1 || owned[0].Num != recent {
+			t.Errorf("expected only the newer post kept on its author's account, got %d posts", len(owned))
+		}
+
+		archived, err := store.GetArchivedThreadView(ctx, "anon", old[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if archived.Posts[0].Username != AnonymousName {
+			t.Errorf("expected the archived post anonymized, got %s", archived.Posts[0].Username)
+		}
+		var archivedAccounts int
+		err = store.pgPool.QueryRow(
+			ctx,
+			"SELECT count(*) FROM post_archive WHERE cat = 'anon' AND (post->>'email' != '' OR post->>'author_id' IS NOT NULL)",
+		).Scan(&archivedAccounts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if archivedAccounts != 0 {
+			t.Errorf("expected the archived post's email and account cleared, got %d", archivedAccounts)
+		}
+	}
+}
+
+func integration_Sessions(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		run := fmt.Sprint(time.Now().UnixNano())
+		session := &Session{
+			UserID: "auth0|sessions", TokenHash: PosterHash("access" + run), RefreshToken: "refresh" + run,
+			UserAgent: "Firefox", IPHash: PosterHash("10.0.0.1"), ExpiresAt: time.Now().Add(time.Hour),
+		}
+		if err := store.WriteSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+		refreshing, err := store.GetRefreshSession(ctx, "refresh"+run)
+		if err != nil || refreshing.ID != session.ID || refreshing.RefreshToken != "refresh"+run {
+			t.Fatalf("expected the session found by its refresh token, got %+v %v", refreshing, err)
+		}
+		err = store.RenewSession(ctx, session.ID, &Session{TokenHash: PosterHash("refreshed" + run), ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, token := range []string{"access" + run, "refreshed" + run} {
+			touched, err := store.TouchSession(ctx, PosterHash(token))
+			if err != nil || touched.ID != session.ID {
+				t.Errorf("expected both of the session's access tokens tracked, got %+v %v", touched, err)
+			}
+		}
+		if kept, err := store.GetSession(ctx, session.UserID, session.ID); err != nil || kept.RefreshToken != "refresh"+run {
+			t.Errorf("expected the refresh token kept when it isn't rotated, got %+v %v", kept, err)
+		}
+
+		if err := store.RevokeSession(ctx, session.UserID, session.ID); err != nil {
+			t.Fatal(err)
+		}
+		if touched, err := store.TouchSession(ctx, PosterHash("refreshed"+run)); err != nil || !touched.Revoked {
+			t.Errorf("expected the refreshed token revoked with its session, got %+v %v", touched, err)
+		}
+		revoked, err := store.GetRefreshSession(ctx, "refresh"+run)
+		if err != nil || !revoked.Revoked || len(revoked.RefreshToken) > 0 {
+			t.Errorf("expected a revoked session found without its refresh token, got %+v %v", revoked, err)
+		}
+		err = store.RenewSession(ctx, session.ID, &Session{TokenHash: PosterHash("again" + run), ExpiresAt: time.Now()})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a revoked session not renewed, got: %v", err)
+		}
+	}
+}
+
+func integration_RehashPosters(ctx context.Context, store *DataStore) func(t *testing.T) {
+	return func(t *testing.T) {
+		setUpFixtures(ctx, t, store, newCategory("rehash"))
+		num, err := store.WritePost(ctx, "rehash", 0, "subject", "content", &Identity{Username: "username", Email: "rehash@rehash.com", IP: "10.0.0.9"}, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Hashed and banned from before hashes were keyed.
+		sum := sha256.Sum256([]byte("10.0.0.9"))
+		unkeyed := hex.EncodeToString(sum[:])
+		for _, statement := range []string{
+			"UPDATE posts SET ip_hash = $1 WHERE cat = 'rehash'",
+			"INSERT INTO bans (poster_hash) VALUES ($1)",
+		} {
+			if _, err := store.pgPool.Exec(ctx, statement, unkeyed); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := store.pgPool.Exec(ctx, "DELETE FROM poster_hash_key"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.rehashPosters(ctx); err != nil {
+			t.Fatal(err)
+		}
+		history, err := store.GetPosterHistory(ctx, PosterHash("10.0.0.9"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history.Posts) != 1 || history.Posts[0].Num != num || history.Bans != 1 {
+			t.Errorf("expected the post and ban re-hashed, got %+v", history)
+		}
+		// Already re-hashed, so there's nothing to do the second time.
+		if err := store.rehashPosters(ctx); err != nil {
+			t.Error(err)
 		}
+		store.pgPool.Exec(ctx, "DELETE FROM bans WHERE poster_hash = $1", PosterHash("10.0.0.9"))
 	}
 }
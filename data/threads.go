@@ -0,0 +1,55 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownThreadType = errors.New("unknown thread type")
+
+// ThreadType is how a thread is used.
+type ThreadType string
+
+const (
+	// ThreadDiscussion is a regular thread.
+	ThreadDiscussion ThreadType = "discussion"
+	// ThreadQuestion is a question, solved once its author accepts an answer.
+	ThreadQuestion ThreadType = "question"
+)
+
+func (store *DataStore) CloseThread(ctx context.Context, categoryTag string, threadNum int) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin closing thread: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = lockThread(ctx, tx, categoryTag, threadNum)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit closing thread: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) SetBestAnswer(ctx context.Context, categoryTag string, threadNum int, replyNum int) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		`UPDATE posts SET best_answer = $3 WHERE cat = $1 AND num = $2 AND parent = 0
+		AND ($3 = 0 OR EXISTS (SELECT FROM posts WHERE cat = $1 AND num = $3 AND parent = $2))`,
+		categoryTag,
+		threadNum,
+		replyNum,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set best answer: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
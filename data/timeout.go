@@ -0,0 +1,182 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ErrTimeout is returned when a query runs past its deadline, or its context is cancelled.
+var ErrTimeout = errors.New("query timed out")
+
+// Postgres cancelled the statement, such as for running past statement_timeout.
+const pgQueryCanceled = "57014"
+
+// timeoutError keeps the driver's error behind an ErrTimeout.
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string {
+	return ErrTimeout.Error() + ": " + e.err.Error()
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
+
+func (e *timeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// asTimeout turns errors from queries that ran out of time or were cancelled into ErrTimeouts.
+func asTimeout(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) || errors.Is(err, ErrTimeout) {
+		return err
+	}
+	var pgErr *pgconn.PgError
+	if ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) ||
+		(errors.As(err, &pgErr) && pgErr.Code == pgQueryCanceled) {
+		return &timeoutError{err: err}
+	}
+	return err
+}
+
+// querier runs statements, on the pool or in a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// withDeadline bounds a statement by timeout, if there is one.
+func withDeadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func execTimed(ctx context.Context, q querier, timeout time.Duration, sql string, args []interface{}) (pgconn.CommandTag, error) {
+	ctx, cancel := withDeadline(ctx, timeout)
+	defer cancel()
+	tag, err := q.Exec(ctx, sql, args...)
+	return tag, asTimeout(ctx, err)
+}
+
+func queryTimed(ctx context.Context, q querier, timeout time.Duration, sql string, args []interface{}) (pgx.Rows, error) {
+	ctx, cancel := withDeadline(ctx, timeout)
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		cancel()
+		return nil, asTimeout(ctx, err)
+	}
+	return &timedRows{Rows: rows, ctx: ctx, cancel: cancel}, nil
+}
+
+func queryRowTimed(ctx context.Context, q querier, timeout time.Duration, sql string, args []interface{}) pgx.Row {
+	ctx, cancel := withDeadline(ctx, timeout)
+	return &timedRow{row: q.QueryRow(ctx, sql, args...), ctx: ctx, cancel: cancel}
+}
+
+// timedRows holds their statement's deadline until they're closed.
+type timedRows struct {
+	pgx.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timedRows) Close() {
+	r.Rows.Close()
+	r.cancel()
+}
+
+func (r *timedRows) Scan(dest ...interface{}) error {
+	return asTimeout(r.ctx, r.Rows.Scan(dest...))
+}
+
+func (r *timedRows) Err() error {
+	return asTimeout(r.ctx, r.Rows.Err())
+}
+
+// timedRow holds its statement's deadline until it's scanned.
+type timedRow struct {
+	row    pgx.Row
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timedRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return asTimeout(r.ctx, r.row.Scan(dest...))
+}
+
+/*
+pool gives every statement run on it, or in transactions begun from it, its own deadline,
+so one slow query can't hold a request or a connection for longer than the timeout.
+*/
+type pool struct {
+	*pgxpool.Pool
+	// No timeout if zero, statements only end with their context.
+	timeout time.Duration
+}
+
+func (p *pool) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return execTimed(ctx, p.Pool, p.timeout, sql, arguments)
+}
+
+func (p *pool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return queryTimed(ctx, p.Pool, p.timeout, sql, args)
+}
+
+func (p *pool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return queryRowTimed(ctx, p.Pool, p.timeout, sql, args)
+}
+
+func (p *pool) Begin(ctx context.Context) (pgx.Tx, error) {
+	beginCtx, cancel := withDeadline(ctx, p.timeout)
+	defer cancel()
+	tx, err := p.Pool.Begin(beginCtx)
+	if err != nil {
+		return nil, asTimeout(beginCtx, err)
+	}
+	timed := &timedTx{Tx: tx, timeout: p.timeout}
+	if err := tagActor(ctx, timed); err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return timed, nil
+}
+
+// timedTx gives each statement in a transaction its own deadline.
+type timedTx struct {
+	pgx.Tx
+	timeout time.Duration
+}
+
+func (tx *timedTx) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return execTimed(ctx, tx.Tx, tx.timeout, sql, arguments)
+}
+
+func (tx *timedTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return queryTimed(ctx, tx.Tx, tx.timeout, sql, args)
+}
+
+func (tx *timedTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return queryRowTimed(ctx, tx.Tx, tx.timeout, sql, args)
+}
+
+func (tx *timedTx) Commit(ctx context.Context) error {
+	commitCtx, cancel := withDeadline(ctx, tx.timeout)
+	defer cancel()
+	return asTimeout(commitCtx, tx.Tx.Commit(commitCtx))
+}
+
+// SetQueryTimeout bounds every statement the store runs, zero lifts the bound. Set it before the store's used.
+func (store *DataStore) SetQueryTimeout(timeout time.Duration) {
+	store.pgPool.timeout = timeout
+}
@@ -0,0 +1,46 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+func TestAsTimeout(t *testing.T) {
+	ctx := context.Background()
+	expired, cancel := context.WithCancel(ctx)
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		err     error
+		timeout bool
+	}{
+		{"nil", ctx, nil, false},
+		{"other error", ctx, errors.New("syntax error"), false},
+		{"no rows after the deadline", expired, pgx.ErrNoRows, false},
+		{"deadline exceeded", ctx, fmt.Errorf("read: %w", context.DeadlineExceeded), true},
+		{"cancelled", ctx, context.Canceled, true},
+		{"statement timeout", ctx, &pgconn.PgError{Code: pgQueryCanceled}, true},
+		{"any error once the context is done", expired, errors.New("conn closed"), true},
+	}
+	for _, test := range tests {
+		err := asTimeout(test.ctx, test.err)
+		if errors.Is(err, ErrTimeout) != test.timeout {
+			t.Errorf("%s: expected timeout %v, got: %v", test.name, test.timeout, err)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: expected the original error kept, got: %v", test.name, err)
+		}
+	}
+
+	wrapped := fmt.Errorf("failed to get thread: %w", asTimeout(ctx, context.DeadlineExceeded))
+	if !errors.Is(wrapped, ErrTimeout) {
+		t.Errorf("expected ErrTimeout through wrapping, got: %v", wrapped)
+	}
+}
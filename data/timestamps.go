@@ -0,0 +1,38 @@
+package data
+
+import (
+	"context"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+)
+
+/*
+utcTimestamptz reads timestamps in UTC. pgx reads them in the server's local timezone otherwise,
+so the API would send whatever offset the host happened to be set to.
+*/
+type utcTimestamptz struct {
+	pgtype.Timestamptz
+}
+
+func (dst *utcTimestamptz) DecodeText(ci *pgtype.ConnInfo, src []byte) error {
+	err := dst.Timestamptz.DecodeText(ci, src)
+	dst.Time = dst.Time.UTC()
+	return err
+}
+
+func (dst *utcTimestamptz) DecodeBinary(ci *pgtype.ConnInfo, src []byte) error {
+	err := dst.Timestamptz.DecodeBinary(ci, src)
+	dst.Time = dst.Time.UTC()
+	return err
+}
+
+// setUpConn reads timestamps on a new connection in UTC, and prepares the hot queries on it.
+func setUpConn(ctx context.Context, conn *pgx.Conn) error {
+	conn.ConnInfo().RegisterDataType(pgtype.DataType{
+		Value: &utcTimestamptz{},
+		Name:  "timestamptz",
+		OID:   pgtype.TimestamptzOID,
+	})
+	return prepareStatements(ctx, conn)
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var ErrTOTPEnrolled = errors.New("two-factor authentication is already set up, turn it off first to change it")
+var ErrTOTPReused = errors.New("that code's already been used, wait for the next one")
+
+// TOTP is a user's authenticator app secret, only Confirmed once they've entered a code from it.
+type TOTP struct {
+	UserID    string
+	Secret    string
+	Confirmed bool
+	// Step of the last code used, codes for it or earlier steps can't be used again.
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+// WriteTOTP starts enrolling a user in two-factor authentication, replacing any secret they haven't confirmed.
+func (store *DataStore) WriteTOTP(ctx context.Context, userID string, secret string) error {
+	// Secrets are as good as the codes they make, so they're encrypted like emails.
+	sealed, err := store.pii.seal(secret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+	}
+	res, err := store.pgPool.Exec(
+		ctx,
+		`INSERT INTO totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_step = 0, created_at = CURRENT_TIMESTAMP
+		WHERE NOT totp.confirmed`,
+		userID,
+		sealed,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write TOTP secret: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrTOTPEnrolled
+	}
+	return nil
+}
+
+func (store *DataStore) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	totp := &TOTP{UserID: userID}
+	err := store.pgPool.QueryRow(
+		ctx,
+		"SELECT secret, confirmed, last_step, created_at FROM totp WHERE user_id = $1",
+		userID,
+	).Scan(&totp.Secret, &totp.Confirmed, &totp.LastStep, &totp.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get TOTP secret: %w", err)
+	}
+	totp.Secret, err = store.pii.open(totp.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	return totp, nil
+}
+
+func (store *DataStore) UseTOTP(ctx context.Context, userID string, step int64) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		"UPDATE totp SET last_step = $2, confirmed = true WHERE user_id = $1 AND last_step < $2",
+		userID,
+		step,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use TOTP code: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrTOTPReused
+	}
+	return nil
+}
+
+func (store *DataStore) RemoveTOTP(ctx context.Context, userID string) error {
+	tx, err := store.pgPool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin removing TOTP secret: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, "DELETE FROM totp WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove TOTP secret: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	// Sessions verified with it aren't anymore.
+	_, err = tx.Exec(ctx, "UPDATE sessions SET verified_at = NULL WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to unverify sessions: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit removing TOTP secret: %w", err)
+	}
+	return nil
+}
+
+func (store *DataStore) VerifySession(ctx context.Context, tokenHash string) error {
+	res, err := store.pgPool.Exec(
+		ctx,
+		`UPDATE sessions SET verified_at = CURRENT_TIMESTAMP
+		WHERE id = (SELECT session_id FROM session_tokens WHERE token_hash = $1) AND NOT revoked`,
+		tokenHash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to verify session: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// WebhookFormat is how a webhook's payload is written.
+type WebhookFormat string
+
+const (
+	// WebhookJSON posts the event as spiritchat's own JSON.
+	WebhookJSON WebhookFormat = "json"
+	// WebhookDiscord posts a Discord embed, so Discord channel webhooks can be used as they are.
+	WebhookDiscord WebhookFormat = "discord"
+	// WebhookTemplate posts the webhook's Template, executed against the event.
+	WebhookTemplate WebhookFormat = "template"
+)
+
+// Webhook posts events to a URL, optionally only those on one category.
+type Webhook struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// Tag of the category events are sent from, empty for every category.
+	Cat string `json:"cat,omitempty"`
+	// Event kinds sent, like post.created, empty for every kind webhooks can be sent.
+	Events   []string      `json:"events"`
+	Format   WebhookFormat `json:"format"`
+	Template string        `json:"template,omitempty"`
+	// Signs payloads when set, and is never shown again once it is.
+	Secret    string    `json:"-"`
+	Signed    bool      `json:"signed"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Wants returns true if the webhook wants events of the kind on the category.
+func (hook *Webhook) Wants(kind string, categoryTag string) bool {
+	if !hook.Enabled || (len(hook.Cat) > 0 && hook.Cat != categoryTag) {
+		return false
+	}
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, event := range hook.Events {
+		if event == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *DataStore) GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	rows, err := store.pgPool.Query(
+		ctx,
+		"SELECT id, url, COALESCE(cat, ''), events, format, template, secret, enabled, created_at FROM webhooks ORDER BY id ASC",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	hooks := make([]*Webhook, 0)
+	for rows.Next() {
+		hook := &Webhook{}
+		var format string
+		err := rows.Scan(
+			&hook.ID, &hook.URL, &hook.Cat, &hook.Events, &format, &hook.Template, &hook.Secret, &hook.Enabled, &hook.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a webhook: %w", err)
+		}
+		hook.Format = WebhookFormat(format)
+		hook.Signed = len(hook.Secret) > 0
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// webhookErr maps a webhook on a category that doesn't exist to ErrNotFound.
+func webhookErr(err error, action string) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return ErrNotFound
+	}
+	return fmt.Errorf("failed to %s webhook: %w", action, err)
+}
+
+func (store *DataStore) WriteWebhook(ctx context.Context, hook *Webhook) error {
+	err := store.pgPool.QueryRow(
+		ctx,
+		`INSERT INTO webhooks (url, cat, events, format, template, secret, enabled)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7) RETURNING id, created_at`,
+		hook.URL, hook.Cat, hook.Events, string(hook.Format), hook.Template, hook.Secret, hook.Enabled,
+	).Scan(&hook.ID, &hook.CreatedAt)
+	if err != nil {
+		return webhookErr(err, "write")
+	}
+	hook.Signed = len(hook.Secret) > 0
+	return nil
+}
+
+func (store *DataStore) UpdateWebhook(ctx context.Context, hook *Webhook) error {
+	// An empty secret keeps the one already set, since it's never shown to be sent back.
+	err := store.pgPool.QueryRow(
+		ctx,
+		`UPDATE webhooks SET url = $2, cat = NULLIF($3, ''), events = $4, format = $5, template = $6,
+		secret = COALESCE(NULLIF($7, ''), secret), enabled = $8
+		WHERE id = $1 RETURNING secret != '', created_at`,
+		hook.ID, hook.URL, hook.Cat, hook.Events, string(hook.Format), hook.Template, hook.Secret, hook.Enabled,
+	).Scan(&hook.Signed, &hook.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return webhookErr(err, "update")
+	}
+	return nil
+}
+
+func (store *DataStore) RemoveWebhook(ctx context.Context, id int) error {
+	res, err := store.pgPool.Exec(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to remove webhook: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
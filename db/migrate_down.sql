@@ -1,5 +1,61 @@
 DROP TRIGGER IF EXISTS check_reply ON posts;
 DROP FUNCTION IF EXISTS check_reply();
-DROP PROCEDURE IF EXISTS write_post;
+DROP TRIGGER IF EXISTS orphan_attachments ON posts;
+DROP FUNCTION IF EXISTS orphan_attachments();
+DROP ROUTINE IF EXISTS write_post;
+DROP TRIGGER IF EXISTS catalog_post ON posts;
+DROP FUNCTION IF EXISTS catalog_post();
+DROP TRIGGER IF EXISTS uncatalog_post ON posts;
+DROP FUNCTION IF EXISTS uncatalog_post();
+DROP TRIGGER IF EXISTS record_post_event ON posts;
+DROP FUNCTION IF EXISTS record_post_event();
+DROP TRIGGER IF EXISTS append_only_post_events ON post_events;
+DROP FUNCTION IF EXISTS reject_post_event_change();
+DROP FUNCTION IF EXISTS refresh_thread_catalog();
+DROP TABLE IF EXISTS thread_catalog;
+DROP TABLE IF EXISTS impersonation_actions;
+DROP TABLE IF EXISTS impersonations;
+DROP FUNCTION IF EXISTS trending_score(BIGINT, TIMESTAMP);
+DROP TABLE IF EXISTS digest_threads;
+DROP TABLE IF EXISTS digests;
+DROP TABLE IF EXISTS category_subscriptions;
+DROP TABLE IF EXISTS share_links;
+DROP TABLE IF EXISTS bookmarks;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS blocks;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS preferences;
+DROP TABLE IF EXISTS accounts_seen;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS post_archive;
+DROP TABLE IF EXISTS category_archive;
+DROP TABLE IF EXISTS category_slugs;
+DROP TABLE IF EXISTS cross_refs;
+DROP TABLE IF EXISTS pages;
+DROP TABLE IF EXISTS rules_acceptances;
+DROP TABLE IF EXISTS category_rules;
+DROP TABLE IF EXISTS mod_log;
+DROP TABLE IF EXISTS notes;
+DROP TABLE IF EXISTS rule_actions;
+DROP TABLE IF EXISTS rules;
+DROP TABLE IF EXISTS mod_deletions;
+DROP TABLE IF EXISTS reports;
+DROP TABLE IF EXISTS abuse_complaints;
+DROP TABLE IF EXISTS client_keys;
+DROP TABLE IF EXISTS post_events;
+DROP TABLE IF EXISTS confirmations;
+DROP TABLE IF EXISTS pii_access;
+DROP TABLE IF EXISTS session_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS totp;
+DROP TABLE IF EXISTS anonymize_jobs;
+DROP TABLE IF EXISTS blocked_domains;
+DROP TABLE IF EXISTS canary_hits;
+DROP TABLE IF EXISTS canaries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS bans;
+DROP TABLE IF EXISTS retained_posts;
 DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS poster_hash_key;
 DROP TABLE IF EXISTS cats;
\ No newline at end of file
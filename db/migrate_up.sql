@@ -23,13 +23,495 @@ CREATE TABLE IF NOT EXISTS posts (
     FOREIGN KEY (cat)       REFERENCES cats (tag)         
 );
 
+-- Deleted posts kept for abuse reports and legal requests, encrypted by the app.
+-- Keyed by the hash of the retained record.
+CREATE TABLE IF NOT EXISTS retained_posts (
+    hash                    text,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    payload                 bytea NOT NULL,
+    deleted_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    purge_at                timestamp NOT NULL,
+    CONSTRAINT retained_hash PRIMARY KEY(hash)
+);
+CREATE INDEX IF NOT EXISTS retained_posts_cat_num ON retained_posts (cat, num);
+CREATE INDEX IF NOT EXISTS retained_posts_purge_at ON retained_posts (purge_at);
+
+-- Posters are identified to moderators by hashes of their IP and email.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS ip_hash text
+    GENERATED ALWAYS AS (encode(sha256(convert_to(ip, 'UTF8')), 'hex')) STORED;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS email_hash text
+    GENERATED ALWAYS AS (encode(sha256(convert_to(email, 'UTF8')), 'hex')) STORED;
+CREATE INDEX IF NOT EXISTS posts_ip_hash ON posts (ip_hash);
+CREATE INDEX IF NOT EXISTS posts_email_hash ON posts (email_hash);
+ALTER TABLE retained_posts ADD COLUMN IF NOT EXISTS ip_hash text;
+ALTER TABLE retained_posts ADD COLUMN IF NOT EXISTS email_hash text;
+CREATE INDEX IF NOT EXISTS retained_posts_ip_hash ON retained_posts (ip_hash);
+CREATE INDEX IF NOT EXISTS retained_posts_email_hash ON retained_posts (email_hash);
+
+-- Bans on posters, by the hash of their IP or email.
+CREATE TABLE IF NOT EXISTS bans (
+    id                      serial,
+    poster_hash             text NOT NULL,
+    reason                  text NOT NULL DEFAULT '',
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at              timestamp,
+    CONSTRAINT ban_id       PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS bans_poster_hash ON bans (poster_hash);
+
+-- Quarantined posts are hidden until a moderator approves them.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS quarantined boolean NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS posts_quarantined ON posts (cat, num) WHERE quarantined;
+
+-- Reports on posts, open until resolved by a moderator.
+CREATE TABLE IF NOT EXISTS reports (
+    id                      serial,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    reason                  text NOT NULL,
+    reporter_hash           text NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at             timestamp,
+    resolution              text,
+    CONSTRAINT report_id    PRIMARY KEY(id),
+    FOREIGN KEY (num, cat)  REFERENCES posts (num, cat) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS reports_open ON reports (cat, num) WHERE resolved_at IS NULL;
+
+-- Locked threads can't be replied to.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS locked boolean NOT NULL DEFAULT false;
+
+-- Posts deleted by moderators, counted by autoban rules.
+CREATE TABLE IF NOT EXISTS mod_deletions (
+    id                      serial,
+    poster_hash             text NOT NULL,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    deleted_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT mod_deletion_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS mod_deletions_poster ON mod_deletions (poster_hash, deleted_at);
+CREATE INDEX IF NOT EXISTS mod_deletions_post ON mod_deletions (cat, num);
+
+-- Automatic moderation rules.
+CREATE TABLE IF NOT EXISTS rules (
+    id                      serial,
+    name                    text NOT NULL,
+    kind                    text NOT NULL,
+    threshold               integer NOT NULL DEFAULT 0,
+    window_hours            integer NOT NULL DEFAULT 0,
+    pattern                 text NOT NULL DEFAULT '',
+    action                  text NOT NULL,
+    ban_hours               integer NOT NULL DEFAULT 0,
+    enabled                 boolean NOT NULL DEFAULT true,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT rule_id      PRIMARY KEY(id)
+);
+
+-- Audit log of actions taken by rules. Kept when the rule is removed.
+CREATE TABLE IF NOT EXISTS rule_actions (
+    id                      serial,
+    rule_id                 integer NOT NULL,
+    rule_name               text NOT NULL,
+    poster_hash             text NOT NULL,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    action                  text NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT rule_action_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS rule_actions_created_at ON rule_actions (created_at);
+
+-- Private staff notes on a post, or on a poster by their hash.
+CREATE TABLE IF NOT EXISTS notes (
+    id                      serial,
+    cat                     text,
+    num                     integer,
+    poster_hash             text,
+    content                 text NOT NULL,
+    author                  text NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT note_id      PRIMARY KEY(id),
+    CONSTRAINT note_target  CHECK ((cat IS NOT NULL AND num IS NOT NULL) <> (poster_hash IS NOT NULL)),
+    FOREIGN KEY (num, cat)  REFERENCES posts (num, cat) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS notes_post ON notes (cat, num) WHERE cat IS NOT NULL;
+CREATE INDEX IF NOT EXISTS notes_poster ON notes (poster_hash) WHERE poster_hash IS NOT NULL;
+
+-- Staff role shown on posts made by staff who opted in, empty for regular posts.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS capcode text NOT NULL DEFAULT '';
+
+-- Moderation actions on posts, shown publicly without who took them or why.
+CREATE TABLE IF NOT EXISTS mod_log (
+    id                      serial,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    thread                  integer NOT NULL,
+    action                  text NOT NULL,
+    -- Autoban rule that took the action, 0 for moderators.
+    rule_id                 integer NOT NULL DEFAULT 0,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT mod_log_id   PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS mod_log_cat ON mod_log (cat, created_at);
+
+-- Each category's rules, in order.
+CREATE TABLE IF NOT EXISTS category_rules (
+    cat                     text NOT NULL,
+    position                integer NOT NULL,
+    content                 text NOT NULL,
+    CONSTRAINT category_rule PRIMARY KEY(cat, position),
+    FOREIGN KEY (cat)       REFERENCES cats (tag) ON DELETE CASCADE
+);
+
+-- Posters, by IP or email hash, who've accepted a category's rules.
+CREATE TABLE IF NOT EXISTS rules_acceptances (
+    cat                     text NOT NULL,
+    poster_hash             text NOT NULL,
+    accepted_at             timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT rules_acceptance PRIMARY KEY(cat, poster_hash),
+    FOREIGN KEY (cat)       REFERENCES cats (tag) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS rules_acceptances_poster ON rules_acceptances (poster_hash);
+
+-- Static pages such as the FAQ, with markdown bodies.
+CREATE TABLE IF NOT EXISTS pages (
+    slug                    text,
+    title                   text NOT NULL,
+    body                    text NOT NULL,
+    updated_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT page_slug    PRIMARY KEY(slug)
+);
+
+-- Account that wrote each post, by Auth0 subject. Posts from before authors had IDs are
+-- owned by their email hash until the account claims them.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS author_id text;
+UPDATE posts SET author_id = email_hash WHERE author_id IS NULL;
+CREATE INDEX IF NOT EXISTS posts_author_id ON posts (author_id);
+
+-- Reply a thread's author marked as the best answer, set on the thread, zero if none.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS best_answer integer NOT NULL DEFAULT 0;
+
+-- Type of thread, either discussion or question. Questions with a best answer are solved.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS thread_type text NOT NULL DEFAULT 'discussion';
+CREATE INDEX IF NOT EXISTS posts_unanswered ON posts (cat) WHERE thread_type = 'question' AND best_answer = 0;
+
+-- References in posts' content to posts on other categories, such as >>>/tech/123.
+CREATE TABLE IF NOT EXISTS cross_refs (
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    target_cat              text NOT NULL,
+    target_num              integer NOT NULL,
+    CONSTRAINT cross_ref    PRIMARY KEY(cat, num, target_cat, target_num),
+    FOREIGN KEY (num, cat)  REFERENCES posts (num, cat) ON DELETE CASCADE,
+    FOREIGN KEY (target_num, target_cat) REFERENCES posts (num, cat) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS cross_refs_target ON cross_refs (target_cat, target_num);
+
+-- Where categories are found in URLs, separate from their tags so they can be renamed.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS slug text;
+UPDATE cats SET slug = tag WHERE slug IS NULL;
+ALTER TABLE cats ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS cats_slug ON cats (slug);
+
+-- Slugs categories were renamed from, redirected to their current slug.
+CREATE TABLE IF NOT EXISTS category_slugs (
+    slug                    text,
+    cat                     text NOT NULL,
+    CONSTRAINT category_slug PRIMARY KEY(slug),
+    FOREIGN KEY (cat)       REFERENCES cats (tag) ON DELETE CASCADE
+);
+
+-- Categories are listed featured first, then by sort order.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS sort_order integer NOT NULL DEFAULT 0;
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS featured boolean NOT NULL DEFAULT false;
+
+-- Archived categories stay readable but don't take new posts.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS archived boolean NOT NULL DEFAULT false;
+
+-- Removed categories and their posts, kept as JSON so they can be recovered by hand.
+CREATE TABLE IF NOT EXISTS category_archive (
+    id                      serial,
+    tag                     text NOT NULL,
+    category                jsonb NOT NULL,
+    archived_at             timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT category_archive_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS category_archive_tag ON category_archive (tag);
+CREATE TABLE IF NOT EXISTS post_archive (
+    id                      serial,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    post                    jsonb NOT NULL,
+    archived_at             timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT post_archive_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS post_archive_cat ON post_archive (cat, num);
+
+-- Bumped on every admin edit to a category, so concurrent edits can't clobber each other.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- Content rules can match against posts folded to plain ASCII.
+ALTER TABLE rules ADD COLUMN IF NOT EXISTS fold boolean NOT NULL DEFAULT false;
+
+-- Words masked with asterisks in a category's posts, the unmasked content is kept for staff.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS masked_words text[] NOT NULL DEFAULT '{}';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS original_content text NOT NULL DEFAULT '';
+
+-- Links allowed in a category's posts, zero for no limit, and whether posts can be nothing but links.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS max_links integer NOT NULL DEFAULT 0;
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS reject_link_only boolean NOT NULL DEFAULT false;
+
+-- Whether a category's threads need an attachment, and whether posts with one can leave out content.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS require_attachment boolean NOT NULL DEFAULT false;
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS allow_empty_content boolean NOT NULL DEFAULT false;
+
+-- What can be attached to a category's posts, zero or empty for the server's limits.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS max_file_bytes bigint NOT NULL DEFAULT 0;
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS allowed_types text[] NOT NULL DEFAULT '{}';
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS max_files integer NOT NULL DEFAULT 0;
+
+-- Storage a category's attachments can take up, zero for no quota, and whether the oldest are pruned to make room.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS storage_quota_bytes bigint NOT NULL DEFAULT 0;
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS prune_oldest boolean NOT NULL DEFAULT false;
+
+-- Uploaded files, which belong to no post until they're posted. Outlive their posts until they're collected.
+CREATE TABLE IF NOT EXISTS attachments (
+    id                      serial,
+    kind                    text NOT NULL,
+    mime                    text NOT NULL,
+    size                    bigint NOT NULL,
+    file                    text NOT NULL,
+    poster                  text NOT NULL DEFAULT '',
+    width                   integer NOT NULL DEFAULT 0,
+    height                  integer NOT NULL DEFAULT 0,
+    duration_ms             integer NOT NULL DEFAULT 0,
+    transcoded              text NOT NULL DEFAULT '',
+    transcoded_mime         text NOT NULL DEFAULT '',
+    uploader_id             text NOT NULL,
+    cat                     text,
+    num                     integer,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT attachment_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS attachments_post ON attachments (cat, num);
+
+-- Attachments hidden behind a placeholder, set by their poster or forced by moderators.
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS spoiler boolean NOT NULL DEFAULT false;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS nsfw boolean NOT NULL DEFAULT false;
+
+-- Scaled down variants of images and video posters, as a JSON array of sizes and formats.
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnails jsonb NOT NULL DEFAULT '[]';
+
+-- When an attachment's post was deleted, its files are collected after a grace period.
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS orphaned_at timestamp;
+UPDATE attachments SET orphaned_at = CURRENT_TIMESTAMP WHERE orphaned_at IS NULL AND cat IS NOT NULL
+    AND NOT EXISTS (SELECT FROM posts WHERE posts.cat = attachments.cat AND posts.num = attachments.num);
+
+-- When accounts were first seen, timing the grace period unverified accounts get.
+CREATE TABLE IF NOT EXISTS accounts_seen (
+    id                      text NOT NULL,
+    first_seen              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT accounts_seen_id PRIMARY KEY(id)
+);
+
+-- Last username seen on the account, for addressing private messages.
+ALTER TABLE accounts_seen ADD COLUMN IF NOT EXISTS username text;
+CREATE INDEX IF NOT EXISTS accounts_seen_username ON accounts_seen (username);
+
+-- Private conversations between two accounts, first_id sorting before second_id.
+CREATE TABLE IF NOT EXISTS conversations (
+    id                      serial,
+    first_id                text NOT NULL,
+    second_id               text NOT NULL,
+    updated_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT conversations_id PRIMARY KEY(id),
+    CONSTRAINT conversations_pair UNIQUE(first_id, second_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id                      serial,
+    conversation_id         integer NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    sender_id               text NOT NULL,
+    sender_name             text NOT NULL,
+    content                 text NOT NULL,
+    read                    boolean NOT NULL DEFAULT false,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT messages_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS messages_conversation ON messages (conversation_id, id);
+
+-- Accounts users won't exchange private messages with.
+CREATE TABLE IF NOT EXISTS blocks (
+    blocker_id              text NOT NULL,
+    blocked_id              text NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT blocks_pair PRIMARY KEY(blocker_id, blocked_id)
+);
+
+CREATE TABLE IF NOT EXISTS preferences (
+    id                      text NOT NULL,
+    display_name            text NOT NULL DEFAULT '',
+    anonymous               boolean NOT NULL DEFAULT false,
+    hide_nsfw               boolean NOT NULL DEFAULT false,
+    timezone                text NOT NULL DEFAULT '',
+    CONSTRAINT preferences_id PRIMARY KEY(id)
+);
+ALTER TABLE preferences ADD COLUMN IF NOT EXISTS mute_mentions boolean NOT NULL DEFAULT false;
+
+-- Notifications for users about posts that concern them, like mentions.
+CREATE TABLE IF NOT EXISTS notifications (
+    id                      serial,
+    account_id              text NOT NULL,
+    kind                    text NOT NULL,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    parent                  integer NOT NULL DEFAULT 0,
+    from_name               text NOT NULL,
+    read                    boolean NOT NULL DEFAULT false,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT notifications_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS notifications_account ON notifications (account_id, id);
+CREATE INDEX IF NOT EXISTS accounts_seen_username_lower ON accounts_seen (lower(username));
+
+-- Posts users saved, dropped with the post.
+CREATE TABLE IF NOT EXISTS bookmarks (
+    id                      serial,
+    account_id              text NOT NULL,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT bookmarks_id PRIMARY KEY(id),
+    CONSTRAINT bookmarks_post UNIQUE(account_id, cat, num),
+    FOREIGN KEY (num, cat)  REFERENCES posts (num, cat) ON DELETE CASCADE
+);
+
+-- Short links to threads, keyed on the category's tag so they survive renames.
+CREATE TABLE IF NOT EXISTS share_links (
+    token                   text NOT NULL,
+    cat                     text NOT NULL,
+    thread                  integer NOT NULL,
+    post                    integer NOT NULL DEFAULT 0,
+    clicks                  integer NOT NULL DEFAULT 0,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT share_links_token PRIMARY KEY(token),
+    CONSTRAINT share_links_target UNIQUE(cat, thread, post),
+    FOREIGN KEY (thread, cat) REFERENCES posts (num, cat) ON DELETE CASCADE
+);
+
+-- Categories users get digests of.
+CREATE TABLE IF NOT EXISTS category_subscriptions (
+    account_id              text NOT NULL,
+    cat                     text NOT NULL REFERENCES cats(tag) ON DELETE CASCADE,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT category_subscriptions_pair PRIMARY KEY(account_id, cat)
+);
+
+-- Periodic digests of the top new threads in the categories users subscribe to.
+CREATE TABLE IF NOT EXISTS digests (
+    id                      serial,
+    account_id              text NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT digests_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS digests_account ON digests (account_id, id);
+
+CREATE TABLE IF NOT EXISTS digest_threads (
+    digest_id               integer NOT NULL REFERENCES digests(id) ON DELETE CASCADE,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    position                integer NOT NULL,
+    CONSTRAINT digest_threads_thread PRIMARY KEY(digest_id, cat, num),
+    FOREIGN KEY (num, cat)  REFERENCES posts (num, cat) ON DELETE CASCADE
+);
+
+-- Full text search over posts, used when no external search engine is configured.
+CREATE INDEX IF NOT EXISTS posts_search ON posts USING GIN (to_tsvector('simple', subject || ' ' || content));
+
+-- Admins' time limited grants to act as users, and every request made under them.
+CREATE TABLE IF NOT EXISTS impersonations (
+    id                      serial,
+    token_hash              text NOT NULL,
+    admin                   text NOT NULL,
+    user_id                 text NOT NULL,
+    username                text NOT NULL,
+    email                   text NOT NULL,
+    scope                   text NOT NULL,
+    reason                  text NOT NULL DEFAULT '',
+    expires                 timestamp NOT NULL,
+    revoked                 boolean NOT NULL DEFAULT false,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT impersonations_id PRIMARY KEY(id),
+    CONSTRAINT impersonations_token UNIQUE(token_hash)
+);
+
+CREATE TABLE IF NOT EXISTS impersonation_actions (
+    id                      serial,
+    impersonation_id        integer NOT NULL REFERENCES impersonations(id),
+    method                  text NOT NULL,
+    path                    text NOT NULL,
+    at                      timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT impersonation_actions_id PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS impersonation_actions_impersonation ON impersonation_actions (impersonation_id, id);
+
+-- Tags are used raw in URLs, so new ones must be short and alphanumeric.
+-- Categories made before the check are left as they are and warned about, it's only validated once there are none.
+DO $cats_tag_format$
+    DECLARE
+        invalid TEXT;
+    BEGIN
+        IF NOT EXISTS (SELECT FROM pg_constraint WHERE conname = 'cats_tag_format') THEN
+            ALTER TABLE cats ADD CONSTRAINT cats_tag_format CHECK (tag ~ '^[a-z0-9]{1,12}$') NOT VALID;
+        END IF;
+        SELECT string_agg(quote_literal(tag), ', ') INTO invalid FROM cats WHERE tag !~ '^[a-z0-9]{1,12}$';
+        IF invalid IS NULL THEN
+            ALTER TABLE cats VALIDATE CONSTRAINT cats_tag_format;
+        ELSE
+            RAISE WARNING 'Categories with tags that are no longer allowed, which should be recreated: %', invalid;
+        END IF;
+    END
+$cats_tag_format$;
+
+-- The primary key leads with num, so lookups and scans within a category need their own indexes.
+-- Replies are looked up by their thread, in order, for thread views.
+CREATE INDEX IF NOT EXISTS posts_cat_num ON posts (cat, num);
+CREATE INDEX IF NOT EXISTS posts_cat_parent ON posts (cat, parent, num);
+
+-- Each thread's reply count and last bump, kept up by triggers on posts so category views don't count replies.
+-- Removing a reply doesn't wind back the bump, refresh_thread_catalog() puts that and any other drift right.
+CREATE TABLE IF NOT EXISTS thread_catalog (
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    replies                 integer NOT NULL DEFAULT 0,
+    bumped_at               timestamp NOT NULL,
+    CONSTRAINT thread_catalog_cat_num PRIMARY KEY(cat, num)
+);
+INSERT INTO thread_catalog (cat, num, replies, bumped_at)
+    SELECT t.cat, t.num, count(r.num), GREATEST(t.created_at, max(r.created_at))
+    FROM posts t LEFT JOIN posts r ON r.cat = t.cat AND r.parent = t.num
+    WHERE t.parent = 0 AND NOT EXISTS (SELECT FROM thread_catalog)
+    GROUP BY t.cat, t.num;
+
+-- How hot a thread is, its replies decaying with its age in hours.
+CREATE OR REPLACE FUNCTION trending_score(replies BIGINT, created_at TIMESTAMP) RETURNS DOUBLE PRECISION AS $trending_score$
+    SELECT (replies + 1) / power(extract(epoch FROM now() - created_at) / 3600 + 2, 1.5);
+$trending_score$ LANGUAGE sql STABLE;
+
 -- If the post has a parent, check the parent exists, and only in the same category.
+-- New replies also need the parent to be unlocked, raising SC001 if it isn't.
 CREATE OR REPLACE FUNCTION check_reply() RETURNS trigger AS $check_reply$
     BEGIN
         IF NOT NEW.parent = 0 THEN
             IF NOT EXISTS (SELECT FROM posts WHERE num = NEW.parent AND cat = NEW.cat) THEN
                 RAISE EXCEPTION 'Nonexistent parent --> % on %', NEW.parent, NEW.cat USING ERRCODE = 23503;
             END IF;
+            IF TG_OP = 'INSERT' AND EXISTS (SELECT FROM posts WHERE num = NEW.parent AND cat = NEW.cat AND locked) THEN
+                RAISE EXCEPTION 'Locked thread --> % on %', NEW.parent, NEW.cat USING ERRCODE = 'SC001';
+            END IF;
         END IF;
         RETURN NEW;
     END;
@@ -41,31 +523,41 @@ CREATE OR REPLACE TRIGGER check_reply BEFORE INSERT OR UPDATE ON posts
 
 
 -- Create a new post, generating a category-specific number for it 
--- based on the most recent category number.
--- args: category, parent, content, subject, username, email, ip
+-- based on the most recent category number. Returns the new post's number.
+-- args: category, parent, content, subject, username, email, ip, capcode, author_id, thread_type, ip_hash, email_hash
 -- Don't touch the ordering of this or it deadlocks under concurrent load.
-CREATE OR REPLACE PROCEDURE write_post(TEXT, INTEGER, TEXT, TEXT, TEXT, TEXT, TEXT) AS $write_post$
+DROP ROUTINE IF EXISTS write_post(TEXT, INTEGER, TEXT, TEXT, TEXT, TEXT, TEXT);
+DROP ROUTINE IF EXISTS write_post(TEXT, INTEGER, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT);
+DROP ROUTINE IF EXISTS write_post(TEXT, INTEGER, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT);
+DROP ROUTINE IF EXISTS write_post(TEXT, INTEGER, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT);
+CREATE OR REPLACE FUNCTION write_post(TEXT, INTEGER, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT) RETURNS INTEGER AS $write_post$
     DECLARE
         post_num INTEGER;
+        is_archived BOOLEAN;
     BEGIN
-        SELECT post_count INTO post_num FROM cats WHERE tag = $1 FOR UPDATE;
+        SELECT post_count, archived INTO post_num, is_archived FROM cats WHERE tag = $1 FOR UPDATE;
         IF post_num IS NULL THEN
             RAISE EXCEPTION 'Nonexistent category --> %', $1 USING ERRCODE = 23503;
         END IF;
-        INSERT INTO posts (cat, parent, content, num, subject, username, email, ip) VALUES (
-            $1, $2, $3, post_num, $4, $5, $6, $7
+        IF is_archived THEN
+            RAISE EXCEPTION 'Archived category --> %', $1 USING ERRCODE = 'SC002';
+        END IF;
+        INSERT INTO posts (cat, parent, content, num, subject, username, email, ip, capcode, author_id, thread_type, ip_hash, email_hash) VALUES (
+            $1, $2, $3, post_num, $4, $5, $6, $7, $8, $9, $10, $11, $12
         );
         UPDATE cats SET post_count = post_num + 1 WHERE tag = $1;
+        RETURN post_num;
     END
 $write_post$ LANGUAGE plpgsql;
 
--- Drop posts that don't have a parent anymore
+-- Drop posts that don't have a parent anymore, and clear deleted best answers.
 CREATE OR REPLACE FUNCTION drop_orphans() RETURNS trigger as $drop_orphans$
     BEGIN
         IF OLD.parent = 0 THEN
             DELETE FROM posts WHERE cat = OLD.cat AND parent = old.num;
             RETURN OLD;
         END IF;
+        UPDATE posts SET best_answer = 0 WHERE cat = OLD.cat AND num = OLD.parent AND best_answer = OLD.num;
         RETURN NULL;
     END
 $drop_orphans$ LANGUAGE plpgsql;
@@ -74,6 +566,18 @@ CREATE OR REPLACE TRIGGER drop_orphans
     AFTER DELETE ON posts
     FOR EACH ROW EXECUTE FUNCTION drop_orphans();
 
+-- Mark a deleted post's attachments as orphaned, so their files can be collected.
+CREATE OR REPLACE FUNCTION orphan_attachments() RETURNS trigger as $orphan_attachments$
+    BEGIN
+        UPDATE attachments SET orphaned_at = CURRENT_TIMESTAMP WHERE cat = OLD.cat AND num = OLD.num;
+        RETURN NULL;
+    END
+$orphan_attachments$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER orphan_attachments
+    AFTER DELETE ON posts
+    FOR EACH ROW EXECUTE FUNCTION orphan_attachments();
+
 -- Drop all posts on a category
 CREATE OR REPLACE FUNCTION drop_category_posts() RETURNS TRIGGER as $drop_category_posts$
     BEGIN
@@ -84,4 +588,301 @@ $drop_category_posts$ LANGUAGE plpgsql;
 
 CREATE OR REPLACE TRIGGER drop_category_posts
     BEFORE DELETE ON cats
-    FOR EACH ROW EXECUTE FUNCTION drop_category_posts();
\ No newline at end of file
+    FOR EACH ROW EXECUTE FUNCTION drop_category_posts();
+
+-- Add new threads to the catalog, and count and bump for new replies.
+CREATE OR REPLACE FUNCTION catalog_post() RETURNS trigger as $catalog_post$
+    BEGIN
+        IF NEW.parent = 0 THEN
+            INSERT INTO thread_catalog (cat, num, bumped_at) VALUES (NEW.cat, NEW.num, NEW.created_at)
+                ON CONFLICT DO NOTHING;
+        ELSE
+            UPDATE thread_catalog SET replies = replies + 1, bumped_at = GREATEST(bumped_at, NEW.created_at)
+                WHERE cat = NEW.cat AND num = NEW.parent;
+        END IF;
+        RETURN NULL;
+    END
+$catalog_post$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER catalog_post
+    AFTER INSERT ON posts
+    FOR EACH ROW EXECUTE FUNCTION catalog_post();
+
+-- Take removed threads out of the catalog, and stop counting removed replies.
+CREATE OR REPLACE FUNCTION uncatalog_post() RETURNS trigger as $uncatalog_post$
+    BEGIN
+        IF OLD.parent = 0 THEN
+            DELETE FROM thread_catalog WHERE cat = OLD.cat AND num = OLD.num;
+        ELSE
+            UPDATE thread_catalog SET replies = GREATEST(replies - 1, 0) WHERE cat = OLD.cat AND num = OLD.parent;
+        END IF;
+        RETURN NULL;
+    END
+$uncatalog_post$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER uncatalog_post
+    AFTER DELETE ON posts
+    FOR EACH ROW EXECUTE FUNCTION uncatalog_post();
+
+-- Recount the catalog from posts, returning how many threads were wrong or missing.
+CREATE OR REPLACE FUNCTION refresh_thread_catalog() RETURNS INTEGER AS $refresh_thread_catalog$
+    DECLARE
+        fixed INTEGER;
+        dropped INTEGER;
+    BEGIN
+        WITH actual AS (
+            SELECT t.cat, t.num, count(r.num) AS replies, GREATEST(t.created_at, max(r.created_at)) AS bumped_at
+            FROM posts t LEFT JOIN posts r ON r.cat = t.cat AND r.parent = t.num
+            WHERE t.parent = 0
+            GROUP BY t.cat, t.num
+        )
+        INSERT INTO thread_catalog (cat, num, replies, bumped_at)
+            SELECT cat, num, replies, bumped_at FROM actual
+            ON CONFLICT (cat, num) DO UPDATE SET replies = EXCLUDED.replies, bumped_at = EXCLUDED.bumped_at
+            WHERE (thread_catalog.replies, thread_catalog.bumped_at) IS DISTINCT FROM (EXCLUDED.replies, EXCLUDED.bumped_at);
+        GET DIAGNOSTICS fixed = ROW_COUNT;
+        DELETE FROM thread_catalog c WHERE NOT EXISTS (
+            SELECT FROM posts WHERE cat = c.cat AND num = c.num AND parent = 0
+        );
+        GET DIAGNOSTICS dropped = ROW_COUNT;
+        RETURN fixed + dropped;
+    END
+$refresh_thread_catalog$ LANGUAGE plpgsql;
+
+-- Abuse complaints and takedown notices filed from outside, by people who may not have accounts.
+CREATE TABLE IF NOT EXISTS abuse_complaints (
+    id                      serial,
+    kind                    text NOT NULL,
+    name                    text NOT NULL,
+    email                   text NOT NULL,
+    details                 text NOT NULL,
+    -- Takedown notices name the copyrighted work and are signed.
+    work                    text NOT NULL DEFAULT '',
+    signature               text NOT NULL DEFAULT '',
+    -- Posts complained about, by category and number. They're kept here even once the posts are gone.
+    post_cats               text[] NOT NULL,
+    post_nums               integer[] NOT NULL,
+    reporter_hash           text NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT complaint_id PRIMARY KEY(id)
+);
+
+-- Reports filed from complaints come first in the moderation queue.
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS priority boolean NOT NULL DEFAULT false;
+ALTER TABLE reports ADD COLUMN IF NOT EXISTS complaint_id integer REFERENCES abuse_complaints (id) ON DELETE SET NULL;
+
+-- Random per post, hashed along with its content so mirrors can check their copies.
+-- The default's volatile, so existing posts are each given their own.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS nonce text NOT NULL DEFAULT md5(random()::text || clock_timestamp()::text);
+
+-- Keys issued to anonymous clients, like archivers, for a higher read quota. Only the key's hash is kept.
+CREATE TABLE IF NOT EXISTS client_keys (
+    id                      serial,
+    key_hash                text NOT NULL,
+    label                   text NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at              timestamp,
+    CONSTRAINT client_key_id PRIMARY KEY(id),
+    CONSTRAINT client_key_hash UNIQUE(key_hash)
+);
+
+-- Every post created, edited, deleted or moderated, in order, written in the same transaction as the change.
+-- Feeds mirrors, post histories for moderators, and anything else derived from posts' lifecycles.
+-- The ID is the changes feed's cursor. Kept even once the post's gone, so mirrors hear about deletions.
+ALTER TABLE IF EXISTS post_changes RENAME TO post_events;
+CREATE TABLE IF NOT EXISTS post_events (
+    id                      bigserial,
+    kind                    text NOT NULL,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    parent                  integer NOT NULL,
+    -- Transaction that made the change, so changes still being committed aren't skipped past.
+    tx                      bigint NOT NULL DEFAULT txid_current(),
+    changed_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT post_change_id PRIMARY KEY(id)
+);
+-- Account that made the change, set by the app as spiritchat.actor, empty for anonymous and automatic changes.
+ALTER TABLE post_events ADD COLUMN IF NOT EXISTS actor text NOT NULL DEFAULT '';
+-- What a moderation event did, like quarantined or locked.
+ALTER TABLE post_events ADD COLUMN IF NOT EXISTS detail text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS post_events_post ON post_events (cat, num);
+
+DROP TRIGGER IF EXISTS record_post_change ON posts;
+DROP FUNCTION IF EXISTS record_post_change();
+
+CREATE OR REPLACE FUNCTION record_post_event() RETURNS trigger as $record_post_event$
+    DECLARE
+        who text := COALESCE(current_setting('spiritchat.actor', true), '');
+    BEGIN
+        IF TG_OP = 'INSERT' THEN
+            INSERT INTO post_events (kind, cat, num, parent, actor) VALUES ('created', NEW.cat, NEW.num, NEW.parent, who);
+        ELSIF TG_OP = 'DELETE' THEN
+            INSERT INTO post_events (kind, cat, num, parent, actor) VALUES ('deleted', OLD.cat, OLD.num, OLD.parent, who);
+        ELSE
+            IF (NEW.subject, NEW.content) IS DISTINCT FROM (OLD.subject, OLD.content) THEN
+                INSERT INTO post_events (kind, cat, num, parent, actor) VALUES ('edited', NEW.cat, NEW.num, NEW.parent, who);
+            END IF;
+            IF NEW.quarantined IS DISTINCT FROM OLD.quarantined THEN
+                INSERT INTO post_events (kind, cat, num, parent, actor, detail) VALUES (
+                    'moderated', NEW.cat, NEW.num, NEW.parent, who,
+                    CASE WHEN NEW.quarantined THEN 'quarantined' ELSE 'approved' END
+                );
+            END IF;
+            IF NEW.locked IS DISTINCT FROM OLD.locked THEN
+                INSERT INTO post_events (kind, cat, num, parent, actor, detail) VALUES (
+                    'moderated', NEW.cat, NEW.num, NEW.parent, who,
+                    CASE WHEN NEW.locked THEN 'locked' ELSE 'unlocked' END
+                );
+            END IF;
+        END IF;
+        RETURN NULL;
+    END
+$record_post_event$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER record_post_event
+    AFTER INSERT OR DELETE OR UPDATE OF subject, content, quarantined, locked ON posts
+    FOR EACH ROW EXECUTE FUNCTION record_post_event();
+
+-- Events are only ever appended.
+CREATE OR REPLACE FUNCTION reject_post_event_change() RETURNS trigger as $reject_post_event_change$
+    BEGIN
+        RAISE EXCEPTION 'post events are append only';
+    END
+$reject_post_event_change$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER append_only_post_events
+    BEFORE UPDATE OR DELETE ON post_events
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_post_event_change();
+
+-- One-time tokens confirming destructive admin requests, bound to who prepared them and a hash of the request.
+CREATE TABLE IF NOT EXISTS confirmations (
+    token_hash              text,
+    user_id                 text NOT NULL,
+    request_hash            text NOT NULL,
+    expires_at              timestamp NOT NULL,
+    used_at                 timestamp,
+    CONSTRAINT confirmation_token PRIMARY KEY(token_hash)
+);
+CREATE INDEX IF NOT EXISTS confirmations_expires_at ON confirmations (expires_at);
+
+-- Domains posts can't link to, and how many posts have been blocked for linking to them.
+CREATE TABLE IF NOT EXISTS blocked_domains (
+    domain                  text,
+    action                  text NOT NULL,
+    blocked                 integer NOT NULL DEFAULT 0,
+    last_blocked_at         timestamp,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT blocked_domain PRIMARY KEY(domain)
+);
+
+-- Threads never listed or linked to, and who requested them anyway.
+CREATE TABLE IF NOT EXISTS canaries (
+    id                      serial,
+    cat                     text NOT NULL,
+    num                     integer NOT NULL,
+    label                   text NOT NULL,
+    subject                 text NOT NULL,
+    content                 text NOT NULL,
+    hits                    integer NOT NULL DEFAULT 0,
+    last_hit_at             timestamp,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT canary_id PRIMARY KEY(id),
+    CONSTRAINT canary_post UNIQUE(cat, num),
+    CONSTRAINT canary_cat FOREIGN KEY(cat) REFERENCES cats(tag) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS canary_hits (
+    id                      serial,
+    canary_id               integer NOT NULL,
+    ip                      text NOT NULL,
+    user_agent              text NOT NULL,
+    at                      timestamp NOT NULL,
+    CONSTRAINT canary_hit_id PRIMARY KEY(id),
+    CONSTRAINT canary_hit_canary FOREIGN KEY(canary_id) REFERENCES canaries(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS canary_hits_at ON canary_hits (at);
+
+-- Poster hashes are written by the app, since emails and IPs can be encrypted before they get here.
+ALTER TABLE posts ALTER COLUMN ip_hash DROP EXPRESSION IF EXISTS;
+ALTER TABLE posts ALTER COLUMN email_hash DROP EXPRESSION IF EXISTS;
+ALTER TABLE canary_hits ADD COLUMN IF NOT EXISTS ip_hash text;
+CREATE INDEX IF NOT EXISTS canary_hits_ip_hash ON canary_hits (ip_hash);
+
+-- Staff viewing emails or IPs, and why.
+CREATE TABLE IF NOT EXISTS pii_access (
+    id                      serial,
+    staff                   text NOT NULL,
+    method                  text NOT NULL,
+    path                    text NOT NULL,
+    reason                  text NOT NULL,
+    at                      timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT pii_access_id PRIMARY KEY(id)
+);
+
+-- Access tokens issued by logging in, by the hash of the token, so users can revoke them.
+CREATE TABLE IF NOT EXISTS sessions (
+    id                      serial,
+    user_id                 text NOT NULL,
+    token_hash              text NOT NULL,
+    user_agent              text NOT NULL,
+    ip_hash                 text NOT NULL,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at            timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at              timestamp NOT NULL,
+    revoked                 boolean NOT NULL DEFAULT false,
+    CONSTRAINT session_id   PRIMARY KEY(id),
+    CONSTRAINT session_token UNIQUE(token_hash)
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions (user_id);
+
+-- Users' authenticator app secrets for two-factor authentication, encrypted like emails.
+CREATE TABLE IF NOT EXISTS totp (
+    user_id                 text NOT NULL,
+    secret                  text NOT NULL,
+    confirmed               boolean NOT NULL DEFAULT false,
+    last_step               bigint NOT NULL DEFAULT 0,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT totp_user    PRIMARY KEY(user_id)
+);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS verified_at timestamp;
+
+-- Threads with no replies for this many days are locked by a periodic job, zero to leave them open.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS lock_inactive_days integer NOT NULL DEFAULT 0;
+-- Why a thread was locked, empty for moderator locks.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS locked_reason text NOT NULL DEFAULT '';
+
+-- Archived threads are browsed by their parent, which is only kept in the archived JSON.
+CREATE INDEX IF NOT EXISTS post_archive_parent ON post_archive (cat, ((post->>'parent')::integer));
+
+-- Admin queued jobs anonymizing a category's older posts, worked through a batch at a time.
+CREATE TABLE IF NOT EXISTS anonymize_jobs (
+    id                      serial,
+    cat                     text NOT NULL,
+    before                  timestamp NOT NULL,
+    queued_by               text NOT NULL,
+    status                  text NOT NULL,
+    total                   integer NOT NULL,
+    done                    integer NOT NULL DEFAULT 0,
+    created_at              timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at             timestamp,
+    CONSTRAINT anonymize_job_id PRIMARY KEY(id)
+);
+
+-- Whether posts are shown under their posters' usernames: 'choice' leaves it to their preferences, 'always' and 'anonymous' decide for them.
+ALTER TABLE cats ADD COLUMN IF NOT EXISTS username_policy text NOT NULL DEFAULT 'choice';
+
+-- Every access token a session has been issued, refreshes included, so revoking it refuses them all.
+CREATE TABLE IF NOT EXISTS session_tokens (
+    token_hash              text NOT NULL,
+    session_id              integer NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    CONSTRAINT session_token_hash PRIMARY KEY(token_hash)
+);
+CREATE INDEX IF NOT EXISTS session_tokens_session_id ON session_tokens (session_id);
+INSERT INTO session_tokens (token_hash, session_id) SELECT token_hash, id FROM sessions ON CONFLICT DO NOTHING;
+-- Refresh tokens are encrypted like emails, since Auth0 needs the token itself to revoke it, and found by their hash.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_token text NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_token_hash text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS sessions_refresh_token_hash ON sessions (refresh_token_hash) WHERE refresh_token_hash != '';
+-- Which secret poster hashes were last keyed with, by a hash made with it. Migrating re-hashes them when it changes.
+CREATE TABLE IF NOT EXISTS poster_hash_key (
+    id                      text NOT NULL
+);
\ No newline at end of file
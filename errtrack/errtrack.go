@@ -0,0 +1,158 @@
+/*
+Package errtrack reports errors to a Sentry compatible error tracker,
+so production incidents come with the request they happened in.
+*/
+package errtrack
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Event is an error worth reporting, and the request it happened in.
+type Event struct {
+	Message string
+	// Level is "error" or "fatal", defaulting to error.
+	Level string
+	// Stack is the goroutine's stack trace, for panics.
+	Stack string
+	// Tags like the route and request ID, searchable in the tracker.
+	Tags   map[string]string
+	Method string
+	URL    string
+}
+
+// Tracker reports events without blocking, dropping them if it's falling behind.
+type Tracker interface {
+	Capture(event *Event)
+}
+
+// Most reports sent at once, events past this are dropped.
+const maxInFlight = 10
+
+// Sentry reports events to a Sentry compatible store endpoint.
+type Sentry struct {
+	endpoint    string
+	auth        string
+	environment string
+	client      *http.Client
+	inFlight    chan struct{}
+}
+
+/*
+NewSentry creates a tracker from a DSN like https://key@sentry.example.com/42,
+tagging events with the environment.
+*/
+func NewSentry(dsn string, environment string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DSN: %w", err)
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || len(u.User.Username()) == 0 || len(project) == 0 {
+		return nil, errors.New("DSN needs a key and project")
+	}
+	key := u.User.Username()
+	u.User = nil
+	u.Path = "/api/" + project + "/store/"
+	return &Sentry{
+		endpoint:    u.String(),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=spiritchat/1.0, sentry_key=%s", key),
+		environment: environment,
+		client:      &http.Client{Timeout: time.Second * 5},
+		inFlight:    make(chan struct{}, maxInFlight),
+	}, nil
+}
+
+// sentryEvent is an event in Sentry's store format.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+func (s *Sentry) encode(event *Event) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate event ID: %w", err)
+	}
+	level := event.Level
+	if len(level) == 0 {
+		level = "error"
+	}
+	se := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "spiritchat",
+		Environment: s.environment,
+		Message:     event.Message,
+		Tags:        event.Tags,
+	}
+	if len(event.Method) > 0 {
+		se.Request = &sentryRequest{Method: event.Method, URL: event.URL}
+	}
+	if len(event.Stack) > 0 {
+		se.Extra = map[string]string{"stack": event.Stack}
+	}
+	return json.Marshal(se)
+}
+
+func (s *Sentry) Capture(event *Event) {
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		log.Printf("Dropped error report, too many in flight: %s", event.Message)
+		return
+	}
+	go func() {
+		defer func() { <-s.inFlight }()
+		if err := s.send(event); err != nil {
+			log.Printf("Failed to report error: %v", err)
+		}
+	}()
+}
+
+func (s *Sentry) send(event *Event) error {
+	body, err := s.encode(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("tracker responded %s", res.Status)
+	}
+	return nil
+}
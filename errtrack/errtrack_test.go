@@ -0,0 +1,55 @@
+package errtrack
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSentry(t *testing.T) {
+	received := make(chan sentryEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/42/store/" {
+			t.Errorf("unexpected path %s", req.URL.Path)
+		}
+		if !strings.Contains(req.Header.Get("X-Sentry-Auth"), "sentry_key=key") {
+			t.Errorf("expected key in auth header, got %s", req.Header.Get("X-Sentry-Auth"))
+		}
+		var event sentryEvent
+		json.NewDecoder(req.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+
+	tracker, err := NewSentry(strings.Replace(server.URL, "://", "://key@", 1)+"/42", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Capture(&Event{
+		Message: "Failed to get thread: down",
+		Tags:    map[string]string{"route": "GET /v1/categories/:cat/:thread", "request_id": "abc"},
+		Method:  "GET",
+		URL:     "/v1/categories/cat/1",
+	})
+
+	event := <-received
+	if event.Level != "error" || event.Environment != "test" || event.Message != "Failed to get thread: down" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.Tags["request_id"] != "abc" || event.Request.URL != "/v1/categories/cat/1" {
+		t.Errorf("expected request context in event %+v", event)
+	}
+	if len(event.EventID) != 32 {
+		t.Errorf("expected a 32 character event ID, got %s", event.EventID)
+	}
+}
+
+func TestNewSentryInvalidDSN(t *testing.T) {
+	for _, dsn := range []string{"https://sentry.example.com/42", "https://key@sentry.example.com", "::"} {
+		if _, err := NewSentry(dsn, ""); err == nil {
+			t.Errorf("expected %q to be invalid", dsn)
+		}
+	}
+}
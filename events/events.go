@@ -0,0 +1,176 @@
+package events
+
+import (
+	"context"
+	"log"
+	"spiritchat/data"
+	"sync"
+	"time"
+)
+
+// Kind identifies a type of event.
+type Kind string
+
+const (
+	PostCreated  Kind = "post.created"
+	PostDeleted  Kind = "post.deleted"
+	ThreadLocked Kind = "thread.locked"
+	ReportFiled  Kind = "report.filed"
+	// AttachmentUploaded is published once an uploaded file's stored and recorded.
+	AttachmentUploaded Kind = "attachment.uploaded"
+	// ComplaintFiled is published once an abuse complaint or takedown notice is recorded.
+	ComplaintFiled Kind = "complaint.filed"
+	// PostAnonymized is published for each post an anonymization job rewrites, carrying the post as it is now.
+	PostAnonymized Kind = "post.anonymized"
+)
+
+// Event describes something that happened to a post or thread.
+type Event struct {
+	Kind     Kind
+	Category string
+	// Number of the post the event is about.
+	Num int
+	// Thread the post belongs to, 0 if the post is a thread.
+	Parent int
+	// Post is set on events that carry post content, like PostCreated.
+	Post *data.Post
+	// Attachment is set on AttachmentUploaded.
+	Attachment *data.Attachment
+	// Complaint is set on ComplaintFiled.
+	Complaint *data.Complaint
+	// Poster is the PosterHash of the post's IP, if known.
+	Poster string
+	// Author is the account ID of the post's author, if known.
+	Author string
+	At     time.Time
+}
+
+// relayedKinds are the kinds of events relayed to other instances, about posts clients can already see.
+var relayedKinds = map[Kind]bool{
+	PostCreated:    true,
+	PostDeleted:    true,
+	ThreadLocked:   true,
+	PostAnonymized: true,
+}
+
+// relayed returns the event as it's relayed to other instances, without who posted it.
+func (event Event) relayed() Event {
+	event.Poster = ""
+	event.Author = ""
+	event.Complaint = nil
+	return event
+}
+
+// Handler reacts to an event. Handlers run outside of the request that published the event.
+type Handler func(ctx context.Context, event Event)
+
+/*
+Bus fans published events out to subscribers, so handlers can publish lifecycle events
+without knowing what side effects hang off of them.
+*/
+type Bus struct {
+	mut         sync.RWMutex
+	subscribers map[Kind][]Handler
+	// Live subscribers also receive events relayed from other instances.
+	live    map[Kind][]Handler
+	relay   Relay
+	pending sync.WaitGroup
+}
+
+// NewBus creates an event bus with no subscribers.
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[Kind][]Handler),
+		live:        make(map[Kind][]Handler),
+	}
+}
+
+/*
+SubscribeLive registers a handler for events of the given kinds published on any instance sharing the bus's relay,
+for pushing updates to clients. Side effects that should only happen once per event belong in Subscribe.
+*/
+func (bus *Bus) SubscribeLive(handler Handler, kinds ...Kind) {
+	bus.mut.Lock()
+	defer bus.mut.Unlock()
+	for _, kind := range kinds {
+		bus.live[kind] = append(bus.live[kind], handler)
+	}
+}
+
+// Relay sends events published on this instance to other instances, and delivers theirs to live subscribers until ctx is done.
+func (bus *Bus) Relay(ctx context.Context, relay Relay) {
+	bus.mut.Lock()
+	bus.relay = relay
+	bus.mut.Unlock()
+	go relay.Listen(ctx, bus.deliverRelayed)
+}
+
+func (bus *Bus) deliverRelayed(event Event) {
+	bus.mut.RLock()
+	handlers := bus.live[event.Kind]
+	bus.mut.RUnlock()
+
+	for _, handler := range handlers {
+		bus.pending.Add(1)
+		go bus.deliver(handler, event)
+	}
+}
+
+// Subscribe registers a handler for events of the given kinds.
+func (bus *Bus) Subscribe(handler Handler, kinds ...Kind) {
+	bus.mut.Lock()
+	defer bus.mut.Unlock()
+	for _, kind := range kinds {
+		bus.subscribers[kind] = append(bus.subscribers[kind], handler)
+	}
+}
+
+/*
+Publish delivers an event to each of its subscribers in their own goroutine,
+returning immediately. Sets the event time if it's missing.
+Events about posts are relayed to other instances without their posters' details, other kinds stay on this one.
+*/
+func (bus *Bus) Publish(event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	bus.mut.RLock()
+	handlers := make([]Handler, 0, len(bus.subscribers[event.Kind])+len(bus.live[event.Kind]))
+	handlers = append(handlers, bus.subscribers[event.Kind]...)
+	handlers = append(handlers, bus.live[event.Kind]...)
+	relay := bus.relay
+	bus.mut.RUnlock()
+	if !relayedKinds[event.Kind] {
+		relay = nil
+	}
+
+	for _, handler := range handlers {
+		bus.pending.Add(1)
+		go bus.deliver(handler, event)
+	}
+	if relay != nil {
+		bus.pending.Add(1)
+		go func() {
+			defer bus.pending.Done()
+			if err := relay.Send(context.Background(), event.relayed()); err != nil {
+				log.Printf("Failed to relay %s event: %v", event.Kind, err)
+			}
+		}()
+	}
+}
+
+func (bus *Bus) deliver(handler Handler, event Event) {
+	defer bus.pending.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("event handler for %s panicked: %v", event.Kind, r)
+		}
+	}()
+	handler(context.Background(), event)
+}
+
+// Wait blocks until every published event has been handled.
+func (bus *Bus) Wait() {
+	bus.pending.Wait()
+}
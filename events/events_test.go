@@ -0,0 +1,185 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"spiritchat/data"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+
+	var mut sync.Mutex
+	received := map[Kind]int{}
+	handler := func(ctx context.Context, event Event) {
+		mut.Lock()
+		defer mut.Unlock()
+		received[event.Kind]++
+		if event.At.IsZero() {
+			t.Error("expected event time to be set")
+		}
+	}
+
+	bus.Subscribe(handler, PostCreated, PostDeleted)
+	bus.Subscribe(handler, PostCreated)
+
+	bus.Publish(Event{Kind: PostCreated, Category: "cat", Num: 1})
+	bus.Publish(Event{Kind: PostDeleted, Category: "cat", Num: 1})
+	bus.Publish(Event{Kind: ThreadLocked, Category: "cat", Num: 1})
+	bus.Wait()
+
+	expected := map[Kind]int{
+		PostCreated:  2,
+		PostDeleted:  1,
+		ThreadLocked: 0,
+	}
+	for kind, count := range expected {
+		if received[kind] != count {
+			t.Errorf("expected %d %s deliveries, got %d", count, kind, received[kind])
+		}
+	}
+}
+
+func TestBusRecoversPanics(t *testing.T) {
+	bus := NewBus()
+	delivered := false
+	bus.Subscribe(func(ctx context.Context, event Event) {
+		panic("oops")
+	}, ReportFiled)
+	bus.Subscribe(func(ctx context.Context, event Event) {
+		delivered = true
+	}, ReportFiled)
+
+	bus.Publish(Event{Kind: ReportFiled})
+	bus.Wait()
+	if !delivered {
+		t.Error("expected other handlers to still receive the event")
+	}
+}
+
+// pairedRelay relays events straight to another bus's relay listener.
+type pairedRelay struct {
+	mut     sync.Mutex
+	deliver func(Event)
+	other   *pairedRelay
+	ready   chan struct{}
+}
+
+func (p *pairedRelay) Send(ctx context.Context, event Event) error {
+	<-p.other.ready
+	p.other.mut.Lock()
+	defer p.other.mut.Unlock()
+	p.other.deliver(event)
+	return nil
+}
+
+func (p *pairedRelay) Listen(ctx context.Context, deliver func(Event)) {
+	p.mut.Lock()
+	p.deliver = deliver
+	p.mut.Unlock()
+	close(p.ready)
+}
+
+func TestBusRelay(t *testing.T) {
+	a, b := &pairedRelay{ready: make(chan struct{})}, &pairedRelay{ready: make(chan struct{})}
+	a.other, b.other = b, a
+	busA, busB := NewBus(), NewBus()
+	busA.Relay(context.Background(), a)
+	busB.Relay(context.Background(), b)
+
+	var mut sync.Mutex
+	received := map[string]int{}
+	count := func(name string) Handler {
+		return func(ctx context.Context, event Event) {
+			mut.Lock()
+			defer mut.Unlock()
+			received[name]++
+		}
+	}
+	busA.Subscribe(count("a"), PostCreated)
+	busA.SubscribeLive(count("a live"), PostCreated)
+	busB.Subscribe(count("b"), PostCreated)
+	busB.SubscribeLive(count("b live"), PostCreated)
+
+	busA.Publish(Event{Kind: PostCreated, Category: "cat", Num: 1})
+	busA.Wait()
+	busB.Wait()
+
+	expected := map[string]int{"a": 1, "a live": 1, "b": 0, "b live": 1}
+	for name, count := range expected {
+		if received[name] != count {
+			t.Errorf("expected %d deliveries to %s, got %d", count, name, received[name])
+		}
+	}
+}
+
+func TestBusRelayPublicOnly(t *testing.T) {
+	a, b := &pairedRelay{ready: make(chan struct{})}, &pairedRelay{ready: make(chan struct{})}
+	a.other, b.other = b, a
+	busA, busB := NewBus(), NewBus()
+	busA.Relay(context.Background(), a)
+	busB.Relay(context.Background(), b)
+
+	var mut sync.Mutex
+	var received []Event
+	busB.SubscribeLive(func(ctx context.Context, event Event) {
+		mut.Lock()
+		defer mut.Unlock()
+		received = append(received, event)
+	}, PostCreated, ComplaintFiled)
+
+	busA.Publish(Event{Kind: ComplaintFiled, Complaint: &data.Complaint{Email: "someone@example.com"}})
+	busA.Publish(Event{Kind: PostCreated, Category: "cat", Num: 1, Poster: "hash", Author: "account"})
+	busA.Wait()
+	busB.Wait()
+
+	if len(received) != 1 {
+		t.Fatalf("expected only the post to be relayed, got %d events", len(received))
+	}
+	if event := received[0]; event.Kind != PostCreated || event.Poster != "" || event.Author != "" {
+		t.Errorf("expected the post relayed without its poster, got %+v", event)
+	}
+}
+
+func TestRedisRelayReceive(t *testing.T) {
+	relay, err := NewRedisRelay(nil, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered := 0
+	deliver := func(event Event) {
+		delivered++
+	}
+	encode := func(instance string, seq uint64) []byte {
+		payload, err := json.Marshal(envelope{Instance: instance, Seq: seq, Event: Event{Kind: PostCreated}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payload
+	}
+
+	relay.receive(encode("other", 1), deliver)
+	relay.receive(encode("other", 1), deliver)
+	relay.receive(encode("other", 2), deliver)
+	relay.receive(encode(relay.instance, 1), deliver)
+	relay.receive([]byte("garbage"), deliver)
+	if delivered != 2 {
+		t.Errorf("expected own events and duplicates to be dropped, delivered %d", delivered)
+	}
+}
+
+func TestDedupeForgetsOldest(t *testing.T) {
+	d := newDedupe()
+	for i := 0; i < dedupeSize+1; i++ {
+		d.first(strconv.Itoa(i))
+	}
+	if !d.first("0") {
+		t.Error("expected the oldest ID to be forgotten")
+	}
+	if d.first(strconv.Itoa(dedupeSize)) {
+		t.Error("expected the newest ID to be remembered")
+	}
+}
@@ -0,0 +1,181 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Relay carries events between instances, so live subscribers hear about events published on any of them.
+type Relay interface {
+	// Send relays an event published on this instance to the others.
+	Send(ctx context.Context, event Event) error
+	// Listen delivers events relayed from other instances until ctx is done.
+	Listen(ctx context.Context, deliver func(Event))
+}
+
+// envelope is a relayed event, identified by the instance it came from and its sequence there.
+type envelope struct {
+	Instance string `json:"instance"`
+	Seq      uint64 `json:"seq"`
+	Event    Event  `json:"event"`
+}
+
+// Number of recently relayed events remembered to drop duplicates of.
+const dedupeSize = 1024
+
+// dedupe remembers the most recently seen envelopes, dropping repeats of them.
+type dedupe struct {
+	mut  sync.Mutex
+	seen map[string]bool
+	ring [dedupeSize]string
+	next int
+}
+
+func newDedupe() *dedupe {
+	return &dedupe{seen: make(map[string]bool, dedupeSize)}
+}
+
+// first returns true the first time it's given an ID, forgetting the oldest once it's full.
+func (d *dedupe) first(id string) bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.seen[id] {
+		return false
+	}
+	delete(d.seen, d.ring[d.next])
+	d.ring[d.next] = id
+	d.next = (d.next + 1) % dedupeSize
+	d.seen[id] = true
+	return true
+}
+
+/*
+RedisRelay relays events over a Redis pub/sub channel. Each instance has a random ID,
+so it can ignore its own events coming back, and duplicates of an event are only delivered once.
+Events carry posts as clients see them, and the bus only relays them without their posters' details.
+*/
+type RedisRelay struct {
+	pool     *redis.Pool
+	channel  string
+	instance string
+	seq      uint64
+	dedupe   *dedupe
+}
+
+// NewRedisRelay creates a relay over the given channel, using connections from the pool.
+func NewRedisRelay(pool *redis.Pool, channel string) (*RedisRelay, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate instance ID: %w", err)
+	}
+	return &RedisRelay{
+		pool:     pool,
+		channel:  channel,
+		instance: hex.EncodeToString(b),
+		dedupe:   newDedupe(),
+	}, nil
+}
+
+func (r *RedisRelay) Send(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(envelope{Instance: r.instance, Seq: atomic.AddUint64(&r.seq, 1), Event: event})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("PUBLISH", r.channel, payload)
+	if err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// Time between pings keeping the subscription's reads from timing out, and between reconnection attempts.
+const relayPingEvery = time.Second
+
+func (r *RedisRelay) Listen(ctx context.Context, deliver func(Event)) {
+	for {
+		err := r.listen(ctx, deliver)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Event relay subscription lost, resubscribing: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relayPingEvery):
+		}
+	}
+}
+
+// listen subscribes to the channel, delivering events until the subscription fails or ctx is done.
+func (r *RedisRelay) listen(ctx context.Context, deliver func(Event)) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.Subscribe(r.channel); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(relayPingEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// Unsubscribing ends the receive loop.
+				psc.Unsubscribe()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		switch msg := psc.Receive().(type) {
+		case error:
+			return msg
+		case redis.Subscription:
+			if msg.Count == 0 {
+				return nil
+			}
+		case redis.Message:
+			r.receive(msg.Data, deliver)
+		}
+	}
+}
+
+func (r *RedisRelay) receive(payload []byte, deliver func(Event)) {
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		log.Printf("Failed to decode relayed event: %v", err)
+		return
+	}
+	if env.Instance == r.instance || !r.dedupe.first(fmt.Sprintf("%s:%d", env.Instance, env.Seq)) {
+		return
+	}
+	deliver(env.Event)
+}
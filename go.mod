@@ -3,9 +3,10 @@ module spiritchat
 go 1.14
 
 require (
-	github.com/auth0/go-auth0 v1.4.1 // indirect
+	github.com/auth0/go-auth0 v1.4.1
 	github.com/gomodule/redigo v1.8.1
 	github.com/jackc/pgconn v1.5.0
+	github.com/jackc/pgtype v1.3.0
 	github.com/jackc/pgx/v4 v4.6.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/kr/pretty v0.3.0 // indirect
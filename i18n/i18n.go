@@ -0,0 +1,166 @@
+/*
+Package i18n translates the API's messages into the languages operators have catalogs for.
+
+Messages are identified by codes, like content_length. The English catalog is the default,
+and messages without a translation, or without a code, are left in English.
+*/
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// English is the language messages are written in, served when nothing else is asked for or available.
+const English = "en"
+
+// Catalog holds a language's messages by code.
+type Catalog map[string]string
+
+/*
+Translator translates English messages into other languages by their codes,
+so messages can be translated wherever they're written without knowing their codes.
+Catalogs are added before it's used, it isn't safe to add them while translating.
+*/
+type Translator struct {
+	english Catalog
+	// Codes of English messages.
+	codes    map[string]string
+	catalogs map[string]Catalog
+}
+
+// NewTranslator returns a translator falling back to the English catalog.
+func NewTranslator(english Catalog) *Translator {
+	codes := make(map[string]string, len(english))
+	for code, message := range english {
+		codes[message] = code
+	}
+	return &Translator{english: english, codes: codes, catalogs: make(map[string]Catalog)}
+}
+
+// Add adds a catalog for a language tag like fr or pt-br, replacing any it already has.
+func (t *Translator) Add(lang string, catalog Catalog) {
+	t.catalogs[strings.ToLower(lang)] = catalog
+}
+
+// Languages returns the tags of every language there's a catalog for, English first.
+func (t *Translator) Languages() []string {
+	langs := make([]string, 0, len(t.catalogs)+1)
+	for lang := range t.catalogs {
+		if lang != English {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return append([]string{English}, langs...)
+}
+
+// has returns whether lang can be served.
+func (t *Translator) has(lang string) bool {
+	_, ok := t.catalogs[lang]
+	return ok || lang == English
+}
+
+/*
+Negotiate picks the language to respond in from an Accept-Language header, like "fr-CA,fr;q=0.9,en;q=0.5".
+Tags are tried most preferred first, each falling back to its primary language, so fr-ca is served by fr.
+English is returned when none of them have a catalog.
+*/
+func (t *Translator) Negotiate(acceptLanguage string) string {
+	type preference struct {
+		lang    string
+		quality float64
+	}
+	var prefs []preference
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(lang) == 0 {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			prefs = append(prefs, preference{lang, quality})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].quality > prefs[j].quality })
+
+	for _, pref := range prefs {
+		if t.has(pref.lang) {
+			return pref.lang
+		}
+		if i := strings.IndexByte(pref.lang, '-'); i > 0 && t.has(pref.lang[:i]) {
+			return pref.lang[:i]
+		}
+	}
+	return English
+}
+
+// Message returns the message with the code in lang, falling back to English, and to the code if there isn't one.
+func (t *Translator) Message(lang string, code string) string {
+	if message, ok := t.catalogs[lang][code]; ok {
+		return message
+	}
+	if message, ok := t.english[code]; ok {
+		return message
+	}
+	return code
+}
+
+// Translate returns an English message in lang, unchanged if it has no code or no translation.
+func (t *Translator) Translate(lang string, message string) string {
+	code, ok := t.codes[message]
+	if !ok {
+		return message
+	}
+	if translated, ok := t.catalogs[lang][code]; ok {
+		return translated
+	}
+	return message
+}
+
+// LoadCatalog reads a catalog from a JSON object of codes to messages.
+func LoadCatalog(path string) (Catalog, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	catalog := Catalog{}
+	err = json.Unmarshal(b, &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog %s: %w", path, err)
+	}
+	return catalog, nil
+}
+
+// LoadCatalogs reads every catalog in a directory, named after their language like fr.json, by language.
+func LoadCatalogs(dir string) (map[string]Catalog, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	catalogs := make(map[string]Catalog, len(paths))
+	for _, path := range paths {
+		catalog, err := LoadCatalog(path)
+		if err != nil {
+			return nil, err
+		}
+		lang := strings.ToLower(strings.TrimSuffix(filepath.Base(path), ".json"))
+		catalogs[lang] = catalog
+	}
+	return catalogs, nil
+}
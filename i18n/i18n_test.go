@@ -0,0 +1,92 @@
+package i18n
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestTranslator() *Translator {
+	translator := NewTranslator(Catalog{
+		"content_length": "content must be between 2 and 300 characters",
+		"not_found":      "not found",
+	})
+	translator.Add("fr", Catalog{"content_length": "le contenu doit faire entre 2 et 300 caractères"})
+	translator.Add("pt-BR", Catalog{"not_found": "não encontrado"})
+	return translator
+}
+
+func TestNegotiate(t *testing.T) {
+	translator := newTestTranslator()
+	tests := map[string]string{
+		"":                          English,
+		"fr":                        "fr",
+		"fr-CA,fr;q=0.9,en;q=0.5":   "fr",
+		"de,en;q=0.8":               English,
+		"de, fr;q=0.2, pt-BR;q=0.5": "pt-br",
+		"en, fr;q=0.9":              English,
+		"fr;q=0, de":                English,
+		"*":                         English,
+	}
+	for header, expected := range tests {
+		if lang := translator.Negotiate(header); lang != expected {
+			t.Errorf("%q: expected %s, got %s", header, expected, lang)
+		}
+	}
+	if langs := translator.Languages(); !reflect.DeepEqual(langs, []string{"en", "fr", "pt-br"}) {
+		t.Errorf("expected English first then the rest in order, got %v", langs)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	translator := newTestTranslator()
+
+	if message := translator.Translate("fr", "content must be between 2 and 300 characters"); message != "le contenu doit faire entre 2 et 300 caractères" {
+		t.Errorf("expected French message, got %q", message)
+	}
+	if message := translator.Translate("fr", "not found"); message != "not found" {
+		t.Errorf("expected English without a translation, got %q", message)
+	}
+	if message := translator.Translate("fr", "something else"); message != "something else" {
+		t.Errorf("expected messages without codes unchanged, got %q", message)
+	}
+	if message := translator.Message("pt-br", "not_found"); message != "não encontrado" {
+		t.Errorf("expected message by code, got %q", message)
+	}
+	if message := translator.Message("pt-br", "content_length"); message != "content must be between 2 and 300 characters" {
+		t.Errorf("expected English fallback by code, got %q", message)
+	}
+	if message := translator.Message("fr", "nope"); message != "nope" {
+		t.Errorf("expected unknown codes returned as is, got %q", message)
+	}
+}
+
+func TestLoadCatalogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "FR.json"), []byte(`{"not_found": "introuvable"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogs, err := LoadCatalogs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(catalogs, map[string]Catalog{"fr": {"not_found": "introuvable"}}) {
+		t.Errorf("unexpected catalogs %v", catalogs)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "de.json"), []byte(`["not", "an", "object"]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCatalogs(dir); err == nil {
+		t.Error("expected invalid catalogs to fail")
+	}
+}
@@ -0,0 +1,39 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+// NewOwner generates an owner name for this instance, unique between instances sharing a locker.
+func NewOwner() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock owner: %w", err)
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s", host, hex.EncodeToString(b)), nil
+}
+
+/*
+Once runs fn while owner holds the lock on key, for work only one instance should be doing at a time.
+If someone else holds the lock, fn isn't run and ran is false. The lock is released once fn returns,
+and expires after ttl if this instance dies first. fn is given the lock's fencing token.
+*/
+func Once(ctx context.Context, locker Locker, key string, owner string, ttl time.Duration, fn func(ctx context.Context, token int64) error) (ran bool, err error) {
+	token, acquired, err := locker.Fence(ctx, key, owner, ttl)
+	if err != nil || !acquired {
+		return false, err
+	}
+	defer func() {
+		// Released even if the job's context has been cancelled, so the next run isn't held up by ttl.
+		if releaseErr := locker.Release(context.Background(), key, owner); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}()
+	return true, fn(ctx, token)
+}
@@ -0,0 +1,223 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Locker hands out expiring, owned locks on keys, such as a moderator's claim on a queue item.
+type Locker interface {
+	/*
+		Acquire takes the lock on key for owner until ttl passes, extending it if owner already holds it.
+		Returns the lock's holder, which is someone else if it couldn't be acquired.
+	*/
+	Acquire(ctx context.Context, key string, owner string, ttl time.Duration) (holder string, acquired bool, err error)
+	/*
+		Fence acquires the lock like Acquire, returning its fencing token if acquired.
+		Tokens for a key only increase, and change whenever the lock changes hands, so anything written
+		under the lock can be tagged with one and writes carrying an older token rejected.
+	*/
+	Fence(ctx context.Context, key string, owner string, ttl time.Duration) (token int64, acquired bool, err error)
+	// Release drops owner's lock on key. Locks held by anyone else are left alone.
+	Release(ctx context.Context, key string, owner string) error
+	// Holder returns who holds the lock on key, or an empty string if nobody does.
+	Holder(ctx context.Context, key string) (string, error)
+}
+
+/*
+Take the lock if it's free or already ours, returning the holder either way along with the fencing token.
+The token only moves on when the lock changes hands, so renewing a lock keeps its token.
+*/
+var acquireScript = redis.NewScript(2, `
+local holder = redis.call("GET", KEYS[1])
+if holder and holder ~= ARGV[1] then
+	return {holder, 0}
+end
+local token = holder and tonumber(redis.call("GET", KEYS[2]))
+if not token then
+	token = redis.call("INCR", KEYS[2])
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return {ARGV[1], token}
+`)
+
+// Only delete the lock if it's ours, so an expired and retaken lock isn't dropped.
+var releaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Redis is a Locker shared between every instance using the same Redis.
+type Redis struct {
+	pool *redis.Pool
+}
+
+// NewRedis creates a locker using connections from the given pool.
+func NewRedis(pool *redis.Pool) *Redis {
+	return &Redis{pool: pool}
+}
+
+func redisKey(key string) string {
+	return "lock:" + key
+}
+
+// Fencing tokens are kept without expiry, so they keep increasing after their lock expires.
+func redisFenceKey(key string) string {
+	return "lock:fence:" + key
+}
+
+// acquire runs the acquire script, returning the holder and the fencing token if owner holds the lock.
+func (r *Redis) acquire(ctx context.Context, key string, owner string, ttl time.Duration) (string, int64, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	reply, err := redis.Values(acquireScript.Do(conn, redisKey(key), redisFenceKey(key), owner, ttl.Milliseconds()))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	var holder string
+	var token int64
+	if _, err := redis.Scan(reply, &holder, &token); err != nil {
+		return "", 0, fmt.Errorf("failed to read lock: %w", err)
+	}
+	return holder, token, nil
+}
+
+func (r *Redis) Acquire(ctx context.Context, key string, owner string, ttl time.Duration) (string, bool, error) {
+	holder, _, err := r.acquire(ctx, key, owner, ttl)
+	if err != nil {
+		return "", false, err
+	}
+	return holder, holder == owner, nil
+}
+
+func (r *Redis) Fence(ctx context.Context, key string, owner string, ttl time.Duration) (int64, bool, error) {
+	holder, token, err := r.acquire(ctx, key, owner, ttl)
+	if err != nil || holder != owner {
+		return 0, false, err
+	}
+	return token, true, nil
+}
+
+func (r *Redis) Release(ctx context.Context, key string, owner string) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = releaseScript.Do(conn, redisKey(key), owner)
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+func (r *Redis) Holder(ctx context.Context, key string) (string, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	holder, err := redis.String(conn.Do("GET", redisKey(key)))
+	if errors.Is(err, redis.ErrNil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get lock holder: %w", err)
+	}
+	return holder, nil
+}
+
+type memoryLock struct {
+	holder  string
+	token   int64
+	expires time.Time
+}
+
+// Memory is a Locker held in process, for running a single instance without Redis.
+type Memory struct {
+	mut    sync.Mutex
+	locks  map[string]memoryLock
+	fences map[string]int64
+	now    func() time.Time
+}
+
+// NewMemory creates an in process locker.
+func NewMemory() *Memory {
+	return &Memory{
+		locks:  make(map[string]memoryLock),
+		fences: make(map[string]int64),
+		now:    time.Now,
+	}
+}
+
+// held returns the unexpired lock on key. Must be called with the mutex held.
+func (m *Memory) held(key string) (memoryLock, bool) {
+	lock, ok := m.locks[key]
+	if ok && !m.now().Before(lock.expires) {
+		delete(m.locks, key)
+		return memoryLock{}, false
+	}
+	return lock, ok
+}
+
+// acquire takes or renews owner's lock on key, returning the lock as it's now held.
+func (m *Memory) acquire(key string, owner string, ttl time.Duration) memoryLock {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	lock, ok := m.held(key)
+	if ok && lock.holder != owner {
+		return lock
+	}
+	if !ok {
+		m.fences[key]++
+		lock = memoryLock{holder: owner, token: m.fences[key]}
+	}
+	lock.expires = m.now().Add(ttl)
+	m.locks[key] = lock
+	return lock
+}
+
+func (m *Memory) Acquire(ctx context.Context, key string, owner string, ttl time.Duration) (string, bool, error) {
+	lock := m.acquire(key, owner, ttl)
+	return lock.holder, lock.holder == owner, nil
+}
+
+func (m *Memory) Fence(ctx context.Context, key string, owner string, ttl time.Duration) (int64, bool, error) {
+	lock := m.acquire(key, owner, ttl)
+	if lock.holder != owner {
+		return 0, false, nil
+	}
+	return lock.token, true, nil
+}
+
+func (m *Memory) Release(ctx context.Context, key string, owner string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if lock, ok := m.held(key); ok && lock.holder == owner {
+		delete(m.locks, key)
+	}
+	return nil
+}
+
+func (m *Memory) Holder(ctx context.Context, key string) (string, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	lock, _ := m.held(key)
+	return lock.holder, nil
+}
@@ -0,0 +1,121 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	locker := NewMemory()
+	locker.now = func() time.Time { return now }
+
+	holder, acquired, _ := locker.Acquire(ctx, "item", "alice", time.Minute)
+	if !acquired || holder != "alice" {
+		t.Fatalf("expected alice to acquire the lock, got %s", holder)
+	}
+
+	holder, acquired, _ = locker.Acquire(ctx, "item", "bob", time.Minute)
+	if acquired || holder != "alice" {
+		t.Errorf("expected lock held by alice, got %s (acquired %v)", holder, acquired)
+	}
+
+	// Releasing someone else's lock does nothing.
+	locker.Release(ctx, "item", "bob")
+	if holder, _ := locker.Holder(ctx, "item"); holder != "alice" {
+		t.Errorf("expected lock still held by alice, got %q", holder)
+	}
+
+	// Reacquiring extends the lock.
+	now = now.Add(time.Second * 50)
+	locker.Acquire(ctx, "item", "alice", time.Minute)
+	now = now.Add(time.Second * 50)
+	if holder, _ := locker.Holder(ctx, "item"); holder != "alice" {
+		t.Errorf("expected extended lock held by alice, got %q", holder)
+	}
+
+	now = now.Add(time.Minute)
+	if holder, _ := locker.Holder(ctx, "item"); holder != "" {
+		t.Errorf("expected lock to expire, held by %q", holder)
+	}
+	holder, acquired, _ = locker.Acquire(ctx, "item", "bob", time.Minute)
+	if !acquired || holder != "bob" {
+		t.Errorf("expected bob to acquire expired lock, got %s", holder)
+	}
+
+	locker.Release(ctx, "item", "bob")
+	if holder, _ := locker.Holder(ctx, "item"); holder != "" {
+		t.Errorf("expected lock released, held by %q", holder)
+	}
+}
+
+func TestMemoryFence(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	locker := NewMemory()
+	locker.now = func() time.Time { return now }
+
+	first, acquired, _ := locker.Fence(ctx, "item", "alice", time.Minute)
+	if !acquired {
+		t.Fatal("expected alice to acquire the lock")
+	}
+	if _, acquired, _ := locker.Fence(ctx, "item", "bob", time.Minute); acquired {
+		t.Error("expected bob not to acquire a held lock")
+	}
+
+	// Renewing keeps the token.
+	renewed, _, _ := locker.Fence(ctx, "item", "alice", time.Minute)
+	if renewed != first {
+		t.Errorf("expected renewed lock to keep token %d, got %d", first, renewed)
+	}
+
+	// The token moves on once the lock expires and changes hands.
+	now = now.Add(time.Minute * 2)
+	second, acquired, _ := locker.Fence(ctx, "item", "bob", time.Minute)
+	if !acquired || second <= first {
+		t.Errorf("expected bob to acquire with a token past %d, got %d (acquired %v)", first, second, acquired)
+	}
+
+	// Even after a release.
+	locker.Release(ctx, "item", "bob")
+	third, _, _ := locker.Fence(ctx, "item", "alice", time.Minute)
+	if third <= second {
+		t.Errorf("expected token past %d, got %d", second, third)
+	}
+}
+
+func TestOnce(t *testing.T) {
+	ctx := context.Background()
+	locker := NewMemory()
+
+	ran, err := Once(ctx, locker, "job", "alice", time.Minute, func(ctx context.Context, token int64) error {
+		// Another instance can't run the job while it's running.
+		nested, _ := Once(ctx, locker, "job", "bob", time.Minute, func(ctx context.Context, token int64) error {
+			return nil
+		})
+		if nested {
+			t.Error("expected bob not to run the job while alice is")
+		}
+		return nil
+	})
+	if !ran || err != nil {
+		t.Fatalf("expected alice to run the job, got %v", err)
+	}
+	if holder, _ := locker.Holder(ctx, "job"); holder != "" {
+		t.Errorf("expected lock released after the job, held by %q", holder)
+	}
+
+	failed := errors.New("failed")
+	_, err = Once(ctx, locker, "job", "bob", time.Minute, func(ctx context.Context, token int64) error {
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("expected job's error, got %v", err)
+	}
+	if holder, _ := locker.Holder(ctx, "job"); holder != "" {
+		t.Errorf("expected lock released after a failed job, held by %q", holder)
+	}
+}
@@ -2,60 +2,678 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"spiritchat/auth"
+	"spiritchat/autoban"
 	"spiritchat/config"
+	"spiritchat/counters"
 	"spiritchat/data"
+	"spiritchat/errtrack"
+	"spiritchat/events"
+	"spiritchat/i18n"
+	"spiritchat/lock"
+	"spiritchat/media"
+	"spiritchat/notify"
+	"spiritchat/ratelimit"
+	"spiritchat/reputation"
+	"spiritchat/search"
 	"spiritchat/serve"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
 )
 
-func isMigration() bool {
-	return len(os.Args) > 2 && os.Args[1] == "migrate" && (os.Args[2] == "up" || os.Args[2] == "down")
+// Returns the configured external search backend, or nil if there isn't one.
+func getSearchBackend(conf *config.SpiritConfig) search.Backend {
+	switch conf.SearchBackend {
+	case "":
+		return nil
+	case "meilisearch":
+		return search.NewMeilisearch(conf.SearchURL, conf.SearchAPIKey, conf.SearchIndex)
+	case "opensearch":
+		return search.NewOpenSearch(conf.SearchURL, conf.SearchUsername, conf.SearchPassword, conf.SearchIndex)
+	default:
+		log.Fatalf("Unknown search backend %q", conf.SearchBackend)
+		return nil
+	}
 }
 
-// true = up false = down
-func getMigrationType() bool {
-	return os.Args[2] == "up"
+// Returns where rate limits are kept, Redis if there's a pool and no backend's configured.
+func getRateLimitBackend(conf *config.SpiritConfig, redisPool *redis.Pool) string {
+	if len(conf.RateLimitBackend) > 0 {
+		return conf.RateLimitBackend
+	}
+	if redisPool != nil {
+		return "redis"
+	}
+	return "memory"
 }
 
-func main() {
-	conf := config.ParseEnv()
+// Returns the configured rate limiter, or nil if posts aren't rate limited.
+func getRateLimiter(conf *config.SpiritConfig, redisPool *redis.Pool) ratelimit.Limiter {
+	backend := getRateLimitBackend(conf, redisPool)
+	switch backend {
+	case "none":
+		log.Println("Rate limiting disabled, posts won't be rate limited")
+		return nil
+	case "memory":
+		return ratelimit.NewMemory(ratelimit.MemoryOptions{Burst: conf.RateLimitBurst})
+	case "redis":
+		if redisPool == nil {
+			log.Fatal("Redis rate limiting needs SPIRITCHAT_REDIS_URL")
+		}
+		policy := ratelimit.FailOpen
+		if conf.RateLimitFailClosed {
+			policy = ratelimit.FailClosed
+		}
+		return ratelimit.NewRedis(redisPool, ratelimit.NewBreaker(ratelimit.BreakerOptions{
+			Threshold: 5,
+			OpenFor:   time.Second * 30,
+			Policy:    policy,
+		}))
+	default:
+		log.Fatalf("Unknown rate limit backend %q", backend)
+		return nil
+	}
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// Returns the quota anonymous reads are counted against, or nil if they aren't limited.
+func getReadQuota(conf *config.SpiritConfig, redisPool *redis.Pool) ratelimit.Quota {
+	if conf.ReadsPerMinute < 1 {
+		return nil
+	}
+	// Unknown backends have already been refused by getRateLimiter.
+	switch getRateLimitBackend(conf, redisPool) {
+	case "memory":
+		return ratelimit.NewMemoryQuota()
+	case "redis":
+		return ratelimit.NewRedisQuota(redisPool)
+	default:
+		return nil
+	}
+}
 
-	log.Println("Establishing database connection")
-	store, err := data.NewDatastore(ctx, conf.PGURL, 15)
-	if err != nil {
-		log.Fatalf("Failed to initalize database: %+v", err)
+// Relays live events between instances through the configured transport.
+func relayEvents(ctx context.Context, conf *config.SpiritConfig, bus *events.Bus, redisPool *redis.Pool) {
+	transport := conf.EventRelay
+	if len(transport) == 0 {
+		transport = "none"
+		if redisPool != nil {
+			transport = "redis"
+		}
+	}
+	switch transport {
+	case "none":
 		return
+	case "redis":
+		if redisPool == nil {
+			log.Fatal("Relaying events through Redis needs SPIRITCHAT_REDIS_URL")
+		}
+		relay, err := events.NewRedisRelay(redisPool, "spiritchat:events")
+		if err != nil {
+			log.Fatalf("Failed to create event relay: %v", err)
+		}
+		bus.Relay(ctx, relay)
+	default:
+		log.Fatalf("Unknown event relay %q", transport)
 	}
-	defer store.Cleanup(ctx)
+}
+
+// Returns a progress callback logging how far through a bulk operation it is.
+func logProgress(verb string, noun string) func(int, int) {
+	return func(done int, total int) {
+		log.Printf("%s %d of %d %s", verb, done, total, noun)
+	}
+}
 
-	if isMigration() {
-		migrationType := getMigrationType()
-		if migrationType {
-			log.Println("Migrating up")
+// Returns the configured IP reputation policies, exiting on unknown policies.
+func getReputationPolicies(conf *config.SpiritConfig) reputation.Policies {
+	policies := reputation.Policies{
+		Default:    reputation.Allow,
+		Categories: make(map[string]reputation.Policy),
+	}
+	for cat, name := range conf.ReputationPolicies {
+		policy, err := reputation.ParsePolicy(name)
+		if err != nil {
+			log.Fatalf("Invalid reputation policy for %s: %v", cat, err)
+		}
+		if cat == "*" {
+			policies.Default = policy
 		} else {
-			log.Println("Migrating down")
+			policies.Categories[cat] = policy
 		}
-		err := store.Migrate(ctx, migrationType)
-		if err != nil {
-			log.Fatal(err)
+	}
+	return policies
+}
+
+// Returns the configured IP reputation sources, if any.
+func getReputationSources(conf *config.SpiritConfig) []reputation.Source {
+	var sources []reputation.Source
+	for _, zone := range conf.DNSBLZones {
+		sources = append(sources, reputation.NewDNSBL(zone))
+	}
+	if len(conf.TorExitListURL) > 0 {
+		sources = append(sources, reputation.NewTorExits(conf.TorExitListURL, time.Hour))
+	}
+	if len(conf.ReputationAPIURL) > 0 {
+		sources = append(sources, reputation.NewAPI(conf.ReputationAPIURL, conf.ReputationAPIFields))
+	}
+	return sources
+}
+
+// Returns the configured upload options, with uploads disabled if there's no upload directory.
+func getUploadOptions(conf *config.SpiritConfig) serve.UploadOptions {
+	opts := serve.UploadOptions{
+		MaxImageBytes:    int64(conf.MaxImageMB) << 20,
+		MaxMediaBytes:    int64(conf.MaxMediaMB) << 20,
+		MaxMediaDuration: time.Duration(conf.MaxMediaSeconds) * time.Second,
+		ReencodeImages:   conf.ReencodeImages,
+		MaxStorageBytes:  int64(conf.StorageQuotaMB) << 20,
+	}
+	if len(conf.UploadDir) == 0 {
+		log.Println("No upload directory configured, attachments are disabled")
+		return opts
+	}
+	storage, err := media.NewDisk(conf.UploadDir)
+	if err != nil {
+		log.Fatalf("Failed to set up uploads: %v", err)
+	}
+	opts.Storage = storage
+
+	if _, err := exec.LookPath(conf.FFmpegPath); err == nil {
+		opts.ImageEncoder = &media.FFmpeg{ProbePath: conf.FFprobePath, FFmpegPath: conf.FFmpegPath}
+		for _, format := range conf.ThumbnailFormats {
+			opts.ThumbnailFormats = append(opts.ThumbnailFormats, "image/"+format)
 		}
+	}
+	if _, err := exec.LookPath(conf.FFprobePath); err != nil {
+		log.Printf("%s not found, only images can be uploaded", conf.FFprobePath)
 	} else {
-		log.Println("Establishing OAuth API")
-		auth, err := auth.NewOAuth(ctx, conf.AuthConfig)
+		opts.Prober = &media.FFmpeg{ProbePath: conf.FFprobePath, FFmpegPath: conf.FFmpegPath}
+	}
+	return opts
+}
+
+// jobLock runs periodic jobs under a lock, so instances sharing a locker take turns rather than repeating them.
+type jobLock struct {
+	locker lock.Locker
+	owner  string
+}
+
+// Runs job if no other instance is running it, holding the lock for at most ttl.
+func (jobs jobLock) run(ctx context.Context, name string, ttl time.Duration, job func(ctx context.Context)) {
+	_, err := lock.Once(ctx, jobs.locker, "job:"+name, jobs.owner, ttl, func(ctx context.Context, token int64) error {
+		job(ctx)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to lock %s job: %v", name, err)
+	}
+}
+
+// Periodically drops retained posts past their retention period, until the context is cancelled.
+func purgeRetainedPosts(ctx context.Context, store data.Store, jobs jobLock) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		jobs.run(ctx, "purge", time.Hour, func(ctx context.Context) {
+			purged, err := store.PurgeRetainedPosts(ctx)
+			if err != nil {
+				log.Printf("Failed to purge retained posts: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d retained posts", purged)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Periodically locks threads that have gone without replies on categories that lock them, until the context is cancelled.
+func lockInactiveThreads(ctx context.Context, store data.Store, jobs jobLock) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		jobs.run(ctx, "lock-inactive", time.Hour, func(ctx context.Context) {
+			locked, err := store.LockInactiveThreads(ctx)
+			if err != nil {
+				log.Printf("Failed to lock inactive threads: %v", err)
+			} else if locked > 0 {
+				log.Printf("Locked %d inactive threads", locked)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Posts anonymized per transaction by anonymization jobs.
+const anonymizeBatchSize = 500
+
+/*
+Periodically works through anonymization jobs queued by admins a batch at a time, logging their progress and
+publishing the posts each batch rewrote, until the context is cancelled. Jobs carry on from their last batch if an
+instance stops partway.
+*/
+func runAnonymizeJobs(ctx context.Context, store data.Store, jobs jobLock, bus *events.Bus) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		jobs.run(ctx, "anonymize", time.Minute*10, func(ctx context.Context) {
+			for ctx.Err() == nil {
+				job, posts, err := store.AnonymizeBatch(ctx, anonymizeBatchSize)
+				if errors.Is(err, data.ErrNotFound) {
+					return
+				}
+				if err != nil {
+					log.Printf("Failed to anonymize posts: %v", err)
+					return
+				}
+				for _, post := range posts {
+					bus.Publish(events.Event{
+						Kind: events.PostAnonymized, Category: post.Cat, Num: post.Num, Parent: post.Parent, Post: post,
+					})
+				}
+				log.Printf("Anonymized %d of %d posts on %s for job %d", job.Done, job.Total, job.Cat, job.ID)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Periodically collects orphaned attachments and removes their files, until the context is cancelled.
+func collectOrphans(ctx context.Context, store data.Store, storage media.Storage, grace time.Duration, jobs jobLock) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		jobs.run(ctx, "gc", time.Hour, func(ctx context.Context) {
+			reclaimed, err := media.CollectOrphans(ctx, store, storage, grace)
+			if err != nil {
+				log.Printf("Failed to collect orphaned attachments: %v", err)
+			} else if reclaimed.Attachments > 0 {
+				log.Printf(
+					"Collected %d orphaned attachments, removing %d files and reclaiming %d bytes",
+					reclaimed.Attachments, reclaimed.Files, reclaimed.Bytes,
+				)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Describes a category whose post counter had fallen behind its posts.
+func describeDrift(drift *data.CounterDrift, repaired bool) string {
+	if repaired {
+		return fmt.Sprintf("Moved post counter of %s from %d past its post %d", drift.Tag, drift.PostCount, drift.Highest)
+	}
+	return fmt.Sprintf("Post counter of %s is %d, behind its post %d", drift.Tag, drift.PostCount, drift.Highest)
+}
+
+// Logs categories whose post counters had fallen behind their posts.
+func logCounterDrift(drifted []*data.CounterDrift, repaired bool) {
+	for _, drift := range drifted {
+		log.Println(describeDrift(drift, repaired))
+	}
+}
+
+// Periodically repairs category post counters that have fallen behind, until the context is cancelled.
+func repairPostCounts(ctx context.Context, store *data.DataStore, jobs jobLock) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		jobs.run(ctx, "fsck", time.Hour, func(ctx context.Context) {
+			drifted, err := store.CheckPostCounts(ctx, true)
+			if err != nil {
+				log.Printf("Failed to check post counters: %v", err)
+			} else {
+				logCounterDrift(drifted, true)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Periodically resets cached thread and reply counts from the thread catalog, until the context is cancelled.
+func reconcileCounts(ctx context.Context, store data.Store, counter counters.Counter) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		_, err := counters.Reconcile(ctx, store, counter)
 		if err != nil {
-			log.Fatalf("Failed to initialize OAuth API: %+v", err)
+			log.Printf("Failed to reconcile thread counts: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Periodically recounts the thread catalog, until the context is cancelled.
+func refreshCatalog(ctx context.Context, store *data.DataStore, every time.Duration, jobs jobLock) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
-		server := serve.NewServer(store, auth, serve.ServerOptions{
-			Address:         conf.HTTPAddress,
-			CorsOriginAllow: conf.CORSAllow,
+
+		jobs.run(ctx, "catalog", every, func(ctx context.Context) {
+			fixed, err := store.RefreshCatalog(ctx)
+			if err != nil {
+				log.Printf("Failed to refresh thread catalog: %v", err)
+			} else if fixed > 0 {
+				log.Printf("Corrected %d threads in the catalog", fixed)
+			}
+		})
+	}
+}
+
+// Periodically writes digests of subscribed categories, until the context is cancelled.
+func writeDigests(ctx context.Context, store data.Store, every time.Duration, size int, jobs jobLock) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		jobs.run(ctx, "digests", every, func(ctx context.Context) {
+			written, err := store.WriteDigests(ctx, size)
+			if err != nil {
+				log.Printf("Failed to write digests: %v", err)
+			} else if written > 0 {
+				log.Printf("Wrote %d digests", written)
+			}
+		})
+	}
+}
+
+// Connects to the database, enabling post retention if there's a key for it.
+func openStore(ctx context.Context, conf *config.SpiritConfig) (*data.DataStore, error) {
+	if len(conf.PosterHashKey) == 0 {
+		return nil, errors.New("SPIRITCHAT_POSTER_HASH_KEY must be set")
+	}
+	data.SetPosterHashKey([]byte(conf.PosterHashKey))
+
+	log.Println("Establishing database connection")
+	store, err := data.NewDatastoreWithRetry(ctx, conf.PGURL, 15, data.RetryOptions{
+		Attempts:       conf.PGConnectAttempts,
+		InitialBackoff: conf.PGConnectBackoff,
+		MaxBackoff:     conf.PGConnectMaxBackoff,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initalize database: %w", err)
+	}
+
+	if len(conf.RetentionKey) > 0 {
+		key, err := base64.StdEncoding.DecodeString(conf.RetentionKey)
+		if err != nil {
+			store.Cleanup(ctx)
+			return nil, fmt.Errorf("failed to decode retention key: %w", err)
+		}
+		err = store.EnableRetention(data.RetentionOptions{Key: key, Days: conf.RetentionDays})
+		if err != nil {
+			store.Cleanup(ctx)
+			return nil, fmt.Errorf("failed to enable post retention: %w", err)
+		}
+		log.Printf("Keeping deleted posts in retention for %d days", conf.RetentionDays)
+	}
+
+	if len(conf.PIIKeys) > 0 {
+		keys := make([][]byte, len(conf.PIIKeys))
+		for i, encoded := range conf.PIIKeys {
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				store.Cleanup(ctx)
+				return nil, fmt.Errorf("failed to decode PII key %d: %w", i+1, err)
+			}
+			keys[i] = key
+		}
+		err = store.EnablePIIEncryption(data.PIIOptions{Keys: keys})
+		if err != nil {
+			store.Cleanup(ctx)
+			return nil, fmt.Errorf("failed to enable PII encryption: %w", err)
+		}
+		log.Println("Encrypting emails and IPs")
+	}
+	return store, nil
+}
+
+/*
+Pings Redis until it answers, since it may still be starting alongside spirit. Spirit serves without it,
+falling back like it does when Redis goes down while serving, if it never does.
+*/
+func waitForRedis(ctx context.Context, conf *config.SpiritConfig, pool *redis.Pool) {
+	retry := data.RetryOptions{
+		Attempts:       conf.RedisConnectAttempts,
+		InitialBackoff: conf.RedisConnectBackoff,
+		MaxBackoff:     conf.RedisConnectMaxBackoff,
+	}
+	err := data.Retry(ctx, retry, "Redis ping", func(ctx context.Context) error {
+		conn, err := pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Do("PING")
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to reach Redis, starting without it: %v", err)
+	}
+}
+
+// Serves the API until the context is cancelled.
+func serveAPI(ctx context.Context, conf *config.SpiritConfig, store *data.DataStore) error {
+	// Only bounded while serving, so imports and prunes can take their time.
+	store.SetQueryTimeout(conf.PGQueryTimeout)
+	log.Println("Establishing OAuth API")
+	auth, err := auth.NewOAuth(ctx, conf.AuthConfig)
+	if err != nil {
+		return fmt.Errorf("failed to initialize OAuth API: %w", err)
+	}
+
+	var redisPool *redis.Pool
+	jobs := jobLock{locker: lock.NewMemory()}
+	if len(conf.RedisURL) > 0 {
+		redisPool = ratelimit.NewPool(conf.RedisURL)
+		defer redisPool.Close()
+		waitForRedis(ctx, conf, redisPool)
+		jobs.locker = lock.NewRedis(redisPool)
+	}
+	jobs.owner, err = lock.NewOwner()
+	if err != nil {
+		return err
+	}
+	if len(conf.RetentionKey) > 0 {
+		go purgeRetainedPosts(ctx, store, jobs)
+	}
+	go lockInactiveThreads(ctx, store, jobs)
+
+	zone, err := time.LoadLocation(conf.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone: %w", err)
+	}
+
+	bus := events.NewBus()
+	defer bus.Wait()
+	autoban.Subscribe(bus, autoban.NewEngine(store))
+	notify.Subscribe(bus, store)
+	notify.SubscribeWebhooks(bus, notify.NewWebhooks(store, notify.WebhookOptions{
+		SiteURL:   conf.SiteURL,
+		BoardName: conf.BoardName,
+		Timezone:  zone,
+	}))
+	if len(conf.SMTPAddress) > 0 && len(conf.AbuseEmails) > 0 {
+		mailer := notify.NewSMTP(notify.SMTPOptions{
+			Address:  conf.SMTPAddress,
+			Username: conf.SMTPUsername,
+			Password: conf.SMTPPassword,
+			From:     conf.SMTPFrom,
 		})
-		log.Printf("Starting server on %s, allowing %s CORS", conf.HTTPAddress, conf.CORSAllow)
-		log.Println(server.Listen(ctx))
+		notify.SubscribeComplaints(bus, mailer, conf.AbuseEmails, zone)
+	} else {
+		log.Println("No SMTP server or abuse email set, complaints will only be queued for moderators")
+	}
+
+	uploads := getUploadOptions(conf)
+	if conf.Transcode && uploads.Prober != nil {
+		transcoder := &media.FFmpeg{ProbePath: conf.FFprobePath, FFmpegPath: conf.FFmpegPath}
+		media.SubscribeTranscoder(bus, store, uploads.Storage, transcoder)
+	}
+	if uploads.Storage != nil {
+		grace := time.Duration(conf.AttachmentGraceHours) * time.Hour
+		go collectOrphans(ctx, store, uploads.Storage, grace, jobs)
+	}
+	if conf.DigestHours > 0 {
+		go writeDigests(ctx, store, time.Duration(conf.DigestHours)*time.Hour, conf.DigestSize, jobs)
+	}
+	go repairPostCounts(ctx, store, jobs)
+	if conf.CatalogRefreshMinutes > 0 {
+		go refreshCatalog(ctx, store, time.Duration(conf.CatalogRefreshMinutes)*time.Minute, jobs)
+	}
+
+	opts := serve.ServerOptions{
+		Address:                conf.HTTPAddress,
+		AdminAddress:           conf.AdminAddress,
+		CorsOriginAllow:        conf.CORSAllow,
+		PostCooldownSeconds:    conf.PostCooldownSeconds,
+		AccountCooldownSeconds: conf.AccountCooldownSeconds,
+		UnverifiedGrace:        time.Duration(conf.UnverifiedGraceHours) * time.Hour,
+		Social: serve.SocialOptions{
+			Providers:   conf.AuthConfig.SocialProviders,
+			RedirectURI: conf.AuthConfig.SocialRedirectURI,
+		},
+		Events:                 bus,
+		ReservedNames:          conf.ReservedNames,
+		TrustedOrigins:         conf.TrustedOrigins,
+		PublicModLog:           conf.PublicModLog,
+		LinkRedirect:           conf.LinkRedirect,
+		RequireRulesAcceptance: conf.RequireRulesAcceptance,
+		OPDeleteReplies:        conf.OPDeleteReplies,
+		StaffTwoFactor:         conf.StaffTwoFactor,
+		Maintenance: serve.MaintenanceOptions{
+			ReadOnly: conf.ReadOnly,
+			Message:  conf.ReadOnlyMessage,
+		},
+		Branding: serve.Branding{
+			Name:         conf.BoardName,
+			Description:  conf.BoardDescription,
+			ContactEmail: conf.ContactEmail,
+			URL:          conf.SiteURL,
+			Timezone:     zone.String(),
+		},
+		Uploads: uploads,
+		Antibot: serve.AntibotOptions{
+			Honeypot:      conf.AntibotHoneypot,
+			MinReplyDelay: conf.AntibotMinReplyDelay,
+			MaxSpamScore:  conf.AntibotMaxScore,
+		},
+	}
+	opts.Locker = jobs.locker
+	if redisPool != nil {
+		opts.Login = serve.LoginOptions{
+			Accounts: ratelimit.NewRedisAttempts(redisPool, ratelimit.AttemptsOptions{}),
+			IPs:      ratelimit.NewRedisAttempts(redisPool, ratelimit.AttemptsOptions{Free: serve.LoginIPFreeAttempts}),
+		}
+	}
+	opts.RateLimiter = getRateLimiter(conf, redisPool)
+	opts.ReadLimits = serve.ReadLimitOptions{
+		Quota:              getReadQuota(conf, redisPool),
+		PerMinute:          conf.ReadsPerMinute,
+		ClientKeyPerMinute: conf.ClientKeyReadsPerMinute,
 	}
+	opts.Counter = counters.NewMemory()
+	if redisPool != nil {
+		opts.Counter = counters.NewRedis(redisPool)
+	}
+	counters.Subscribe(bus, opts.Counter)
+	go reconcileCounts(ctx, store, opts.Counter)
+	relayEvents(ctx, conf, bus, redisPool)
+	if sources := getReputationSources(conf); len(sources) > 0 {
+		opts.Reputation = reputation.NewCached(
+			sources, redisPool, time.Minute*time.Duration(conf.ReputationCacheMinutes),
+		)
+		opts.ReputationPolicies = getReputationPolicies(conf)
+	}
+	if len(conf.CaptchaVerifyURL) > 0 {
+		opts.Captcha = reputation.NewSiteVerify(conf.CaptchaVerifyURL, conf.CaptchaSecret)
+	}
+	if backend := getSearchBackend(conf); backend != nil {
+		err := backend.EnsureIndex(ctx)
+		if err != nil {
+			log.Printf("Failed to set up %s index, searches may fail over to Postgres: %v", conf.SearchBackend, err)
+		}
+		search.Subscribe(bus, backend)
+		opts.Search = backend
+	}
+
+	if len(conf.SentryDSN) > 0 {
+		tracker, err := errtrack.NewSentry(conf.SentryDSN, conf.SentryEnvironment)
+		if err != nil {
+			return fmt.Errorf("failed to create Sentry tracker: %w", err)
+		}
+		opts.ErrorTracker = tracker
+	}
+
+	if len(conf.LocalesDir) > 0 {
+		opts.Locales, err = i18n.LoadCatalogs(conf.LocalesDir)
+		if err != nil {
+			return fmt.Errorf("failed to load locales: %w", err)
+		}
+		log.Printf("Loaded %d locales", len(opts.Locales))
+	}
+
+	if len(opts.Social.Providers) > 0 && len(opts.Social.RedirectURI) == 0 {
+		return errors.New("social login needs SPIRITCHAT_SOCIAL_REDIRECT_URI")
+	}
+
+	// Started once everything's subscribed, so anonymized posts are reindexed.
+	go runAnonymizeJobs(ctx, store, jobs, bus)
+
+	server := serve.NewServer(store, auth, opts)
+	log.Printf("Starting server on %s, allowing %s CORS", conf.HTTPAddress, conf.CORSAllow)
+	if len(conf.AdminAddress) > 0 {
+		log.Printf("Serving admin routes on %s", conf.AdminAddress)
+	}
+	return server.Listen(ctx)
+}
+
+func main() {
+	os.Exit(runCLI(os.Args[1:], os.Stdout))
 }
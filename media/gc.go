@@ -0,0 +1,55 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"log"
+	"spiritchat/data"
+	"time"
+)
+
+// OrphanStore is the subset of data.Store orphaned attachments are collected from.
+type OrphanStore interface {
+	CollectOrphanedAttachments(ctx context.Context, grace time.Duration) ([]*data.Attachment, error)
+}
+
+// Reclaimed is what collecting orphaned attachments freed.
+type Reclaimed struct {
+	Attachments int   `json:"attachments"`
+	Files       int   `json:"files"`
+	Bytes       int64 `json:"bytes"`
+	// Files that couldn't be removed, and are left in storage.
+	Failed int `json:"failed"`
+}
+
+/*
+CollectOrphans deletes attachments whose posts were deleted, or that were never posted, longer than grace ago,
+and removes their files from storage. Files that can't be removed are logged and counted, not retried,
+since their records are already gone.
+*/
+func CollectOrphans(ctx context.Context, store OrphanStore, storage Storage, grace time.Duration) (*Reclaimed, error) {
+	orphans, err := store.CollectOrphanedAttachments(ctx, grace)
+	if err != nil {
+		return nil, err
+	}
+	reclaimed := &Reclaimed{Attachments: len(orphans)}
+	for _, attachment := range orphans {
+		for _, key := range attachment.Files() {
+			size, err := storage.Size(ctx, key)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err == nil {
+				err = storage.Remove(ctx, key)
+			}
+			if err != nil {
+				log.Printf("failed to remove orphaned file %s: %v", key, err)
+				reclaimed.Failed++
+				continue
+			}
+			reclaimed.Files++
+			reclaimed.Bytes += size
+		}
+	}
+	return reclaimed, nil
+}
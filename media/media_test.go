@@ -0,0 +1,391 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSniff(t *testing.T) {
+	tests := map[string]struct {
+		header       []byte
+		expectedMIME string
+		expectedKind data.AttachmentKind
+		expectedErr  error
+	}{
+		"PNG":   {[]byte("\x89PNG\x0d\x0a\x1a\x0a"), "image/png", data.AttachmentImage, nil},
+		"WebM":  {[]byte("\x1a\x45\xdf\xa3"), "video/webm", data.AttachmentVideo, nil},
+		"MP3":   {[]byte("ID3\x03"), "audio/mpeg", data.AttachmentAudio, nil},
+		"HTML":  {[]byte("<html><script>"), "", "", ErrUnsupported},
+		"Empty": {nil, "", "", ErrUnsupported},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mime, kind, err := Sniff(test.header)
+			if mime != test.expectedMIME || kind != test.expectedKind || !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected %s %s %v, got %s %s %v", test.expectedMIME, test.expectedKind, test.expectedErr, mime, kind, err)
+			}
+		})
+	}
+}
+
+func TestProbeImage(t *testing.T) {
+	var b bytes.Buffer
+	if err := gif.Encode(&b, image.NewPaletted(image.Rect(0, 0, 5, 7), palette.Plan9), nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ProbeImage(&b)
+	if err != nil || info.Width != 5 || info.Height != 7 {
+		t.Errorf("expected 5x7, got %+v %v", info, err)
+	}
+	if _, err := ProbeImage(strings.NewReader("GIF89a\x05")); err == nil {
+		t.Error("expected broken image to fail")
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	info, err := parseProbe([]byte(`{
+		"streams": [{"codec_type": "audio"}, {"codec_type": "video", "width": 1280, "height": 720}],
+		"format": {"duration": "12.500000"}
+	}`))
+	if err != nil || !info.HasAudio || !info.HasVideo || info.Width != 1280 || info.Duration != time.Millisecond*12500 {
+		t.Errorf("expected probed video, got %+v %v", info, err)
+	}
+	if _, err := parseProbe([]byte(`{"streams": [{"codec_type": "data"}], "format": {}}`)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected files without audio or video to be unsupported, got %v", err)
+	}
+}
+
+func TestDisk(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "spiritchat-media-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	disk, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := NewKey(".txt")
+	if err := disk.Put(ctx, key, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := disk.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(content) != "hello" {
+		t.Errorf("expected stored content, got %q", content)
+	}
+
+	if err := disk.Remove(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := disk.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected removed file to be gone, got %v", err)
+	}
+	for _, key := range []string{"../escape", "..", "a/b", ""} {
+		if err := disk.Put(ctx, key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected key %q to be refused, got %v", key, err)
+		}
+	}
+}
+
+type mockStore struct {
+	id   int
+	file string
+	mime string
+}
+
+func (ms *mockStore) SetAttachmentTranscode(ctx context.Context, id int, file string, mime string) error {
+	ms.id, ms.file, ms.mime = id, file, mime
+	return nil
+}
+
+type mockTranscoder struct{}
+
+func (mt *mockTranscoder) Transcode(ctx context.Context, src string, dst string, kind data.AttachmentKind) (string, error) {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	return "video/mp4", ioutil.WriteFile(dst, append([]byte("transcoded "), content...), 0644)
+}
+
+func TestTranscoder(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "spiritchat-media-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	disk, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Put(ctx, "original.webm", strings.NewReader("video")); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &mockStore{}
+	bus := events.NewBus()
+	SubscribeTranscoder(bus, store, disk, &mockTranscoder{})
+	bus.Publish(events.Event{Kind: events.AttachmentUploaded, Attachment: &data.Attachment{
+		ID: 3, Kind: data.AttachmentImage, MIME: "image/png", File: "original.png",
+	}})
+	bus.Wait()
+	if store.id != 0 {
+		t.Errorf("expected images not to be transcoded, got %+v", store)
+	}
+
+	bus.Publish(events.Event{Kind: events.AttachmentUploaded, Attachment: &data.Attachment{
+		ID: 4, Kind: data.AttachmentVideo, MIME: "video/webm", File: "original.webm",
+	}})
+	bus.Wait()
+	if store.id != 4 || store.mime != "video/mp4" || !strings.HasSuffix(store.file, ".mp4") {
+		t.Fatalf("expected transcode to be recorded, got %+v", store)
+	}
+	f, err := disk.Open(ctx, store.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var transcoded bytes.Buffer
+	io.Copy(&transcoded, f)
+	if transcoded.String() != "transcoded video" {
+		t.Errorf("expected transcoded file to be stored, got %q", transcoded.String())
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)
+	var jpegFile, pngFile, gifFile bytes.Buffer
+	if err := jpeg.Encode(&jpegFile, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngFile, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifFile, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	secret := "GPS 51.5007N 0.1246W"
+
+	// EXIF segment right after the SOI marker.
+	exif := append([]byte("Exif\x00\x00"), secret...)
+	withExif := append([]byte{0xff, 0xd8, 0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	withExif = append(withExif, jpegFile.Bytes()[2:]...)
+
+	// Text chunk right after IHDR, CRCs aren't checked when stripping.
+	text := append([]byte("tEXt"), secret...)
+	chunk := append([]byte{0, 0, 0, byte(len(secret))}, append(text, 0, 0, 0, 0)...)
+	ihdrEnd := 8 + 12 + 13
+	withText := append(append(append([]byte{}, pngFile.Bytes()[:ihdrEnd]...), chunk...), pngFile.Bytes()[ihdrEnd:]...)
+
+	// Comment extension before the trailer.
+	comment := append([]byte{0x21, 0xfe, byte(len(secret))}, append([]byte(secret), 0)...)
+	gifBytes := gifFile.Bytes()
+	withComment := append(append(append([]byte{}, gifBytes[:len(gifBytes)-1]...), comment...), 0x3b)
+
+	tests := map[string]struct {
+		mime string
+		file []byte
+	}{
+		"JPEG EXIF":   {"image/jpeg", withExif},
+		"PNG text":    {"image/png", withText},
+		"GIF comment": {"image/gif", withComment},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			stripped, err := StripMetadata(test.mime, test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(stripped, []byte(secret)) {
+				t.Error("expected metadata to be stripped")
+			}
+			if _, _, err := image.Decode(bytes.NewReader(stripped)); err != nil {
+				t.Errorf("expected stripped image to decode, got %v", err)
+			}
+			if _, err := StripMetadata(test.mime, append(test.file, "PK\x03\x04zip"...)); !errors.Is(err, ErrPolyglot) {
+				t.Errorf("expected trailing data to be a polyglot, got %v", err)
+			}
+			if _, err := StripMetadata(test.mime, test.file[:len(test.file)/2]); !errors.Is(err, ErrMalformed) {
+				t.Errorf("expected truncated image to be malformed, got %v", err)
+			}
+			reencoded, err := Reencode(test.mime, stripped)
+			if err != nil || bytes.Contains(reencoded, []byte(secret)) {
+				t.Errorf("expected re-encoded image without metadata, got %v", err)
+			}
+		})
+	}
+
+	padded := append(append([]byte{}, pngFile.Bytes()...), 0, 0, 0)
+	if _, err := StripMetadata("image/png", padded); err != nil {
+		t.Errorf("expected zero padding to be allowed, got %v", err)
+	}
+}
+
+func TestMatchesDeclared(t *testing.T) {
+	tests := map[string]struct {
+		declared string
+		sniffed  string
+		expected bool
+	}{
+		"Nothing declared": {"", "image/png", true},
+		"Binary":           {"application/octet-stream", "image/gif", true},
+		"Same":             {"image/png", "image/png", true},
+		"Alias":            {"image/jpg; charset=binary", "image/jpeg", true},
+		"Other image":      {"image/png", "image/gif", false},
+		"Image as video":   {"video/mp4", "image/png", false},
+		"Audio container":  {"audio/webm", "video/webm", true},
+		"HTML":             {"text/html", "image/png", false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if MatchesDeclared(test.declared, test.sniffed) != test.expected {
+				t.Errorf("expected %s declared as %s to be %v", test.sniffed, test.declared, test.expected)
+			}
+		})
+	}
+}
+
+func TestContainsMarkup(t *testing.T) {
+	// Split across the read size so it's only found if reads overlap.
+	split := strings.Repeat("x", 1<<16-3) + "<ScRiPt>"
+	for content, expected := range map[string]bool{
+		"GIF89a<?php system($_GET[0]); ?>": true,
+		split:                              true,
+		"\x89PNG plain bytes":              false,
+	} {
+		found, err := ContainsMarkup(strings.NewReader(content))
+		if err != nil || found != expected {
+			t.Errorf("expected markup %v in %.20q, got %v %v", expected, content, found, err)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	scaled := Scale(img, 150)
+	if scaled.Bounds().Dx() != 150 || scaled.Bounds().Dy() != 75 {
+		t.Errorf("expected 150x75, got %v", scaled.Bounds())
+	}
+	if r, _, _, _ := scaled.At(10, 10).RGBA(); r != 0xffff {
+		t.Errorf("expected averaged white, got %d", r)
+	}
+	small := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	if Scale(small, 150) != image.Image(small) {
+		t.Error("expected images that fit to be returned as they are")
+	}
+}
+
+type mockEncoder struct{}
+
+func (me *mockEncoder) EncodeImage(ctx context.Context, src io.Reader, mime string, w io.Writer) error {
+	if mime == "image/avif" {
+		return errors.New("no encoder")
+	}
+	_, err := w.Write([]byte("RIFF"))
+	return err
+}
+
+func TestThumbnails(t *testing.T) {
+	ctx := context.Background()
+	img := image.NewRGBA(image.Rect(0, 0, 300, 1000))
+	variants, err := Thumbnails(ctx, img, &mockEncoder{}, []string{"image/webp", "image/avif"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []data.Thumbnail{
+		{Size: "small", MIME: "image/webp", Width: 45, Height: 150},
+		{Size: "small", MIME: "image/jpeg", Width: 45, Height: 150},
+		{Size: "medium", MIME: "image/webp", Width: 144, Height: 480},
+		{Size: "medium", MIME: "image/jpeg", Width: 144, Height: 480},
+	}
+	if len(variants) != len(expected) {
+		t.Fatalf("expected %d variants without AVIF, got %d", len(expected), len(variants))
+	}
+	for i, variant := range variants {
+		thumbnail := variant.Thumbnail
+		thumbnail.File = ""
+		if thumbnail != expected[i] || len(variant.Content) == 0 || len(variant.File) == 0 {
+			t.Errorf("expected %+v, got %+v", expected[i], variant.Thumbnail)
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(variants[1].Content)); err != nil {
+		t.Errorf("expected JPEG fallback, got %v", err)
+	}
+
+	variants, err = Thumbnails(ctx, image.NewRGBA(image.Rect(0, 0, 100, 100)), nil, nil)
+	if err != nil || len(variants) != 1 || variants[0].Width != 100 {
+		t.Errorf("expected only a small JPEG of an image that fits it, got %d %v", len(variants), err)
+	}
+}
+
+type mockOrphanStore struct {
+	orphans []*data.Attachment
+	grace   time.Duration
+}
+
+func (ms *mockOrphanStore) CollectOrphanedAttachments(ctx context.Context, grace time.Duration) ([]*data.Attachment, error) {
+	ms.grace = grace
+	return ms.orphans, nil
+}
+
+func TestCollectOrphans(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "spiritchat-media-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	disk, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, content := range map[string]string{"a.png": "image", "a.jpg": "thumb", "kept.png": "kept"} {
+		if err := disk.Put(ctx, key, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := &mockOrphanStore{orphans: []*data.Attachment{
+		{ID: 1, File: "a.png", Thumbnails: []data.Thumbnail{{File: "a.jpg"}}},
+		// Already gone, which isn't a failure.
+		{ID: 2, File: "gone.png"},
+	}}
+	reclaimed, err := CollectOrphans(ctx, store, disk, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Reclaimed{Attachments: 2, Files: 2, Bytes: 10}
+	if *reclaimed != expected || store.grace != time.Hour {
+		t.Errorf("expected %+v reclaimed, got %+v", expected, *reclaimed)
+	}
+	for key, expected := range map[string]error{"a.png": ErrNotFound, "a.jpg": ErrNotFound, "kept.png": nil} {
+		if _, err := disk.Size(ctx, key); !errors.Is(err, expected) {
+			t.Errorf("expected %s to be %v, got %v", key, expected, err)
+		}
+	}
+}
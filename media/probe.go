@@ -0,0 +1,219 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os/exec"
+	"spiritchat/data"
+	"strconv"
+	"time"
+)
+
+var ErrUnsupported = errors.New("unsupported file type")
+
+// Types accepted for upload by their sniffed MIME type, audio and video are told apart by probing.
+var accepted = map[string]data.AttachmentKind{
+	"image/jpeg":      data.AttachmentImage,
+	"image/png":       data.AttachmentImage,
+	"image/gif":       data.AttachmentImage,
+	"video/webm":      data.AttachmentVideo,
+	"video/mp4":       data.AttachmentVideo,
+	"application/ogg": data.AttachmentVideo,
+	"audio/mpeg":      data.AttachmentAudio,
+	"audio/wave":      data.AttachmentAudio,
+}
+
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"video/webm":      ".webm",
+	"video/mp4":       ".mp4",
+	"application/ogg": ".ogg",
+	"audio/mpeg":      ".mp3",
+	"audio/wave":      ".wav",
+}
+
+/*
+Sniff returns the MIME type and kind of a file from its first bytes, ignoring whatever the uploader
+claimed it was. Returns ErrUnsupported for anything that can't be uploaded.
+*/
+func Sniff(header []byte) (string, data.AttachmentKind, error) {
+	mime := http.DetectContentType(header)
+	kind, ok := accepted[mime]
+	if !ok {
+		return "", "", ErrUnsupported
+	}
+	return mime, kind, nil
+}
+
+// Accepts reports whether files of the sniffed MIME type can be uploaded at all.
+func Accepts(mime string) bool {
+	_, ok := accepted[mime]
+	return ok
+}
+
+// Extension returns the file extension files of the MIME type are stored with.
+func Extension(mime string) string {
+	return extensions[mime]
+}
+
+// Info is what's known about an uploaded file's contents.
+type Info struct {
+	Width    int
+	Height   int
+	Duration time.Duration
+	HasVideo bool
+	HasAudio bool
+}
+
+// ProbeImage reads the dimensions of a JPEG, PNG or GIF.
+func ProbeImage(r io.Reader) (*Info, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return &Info{Width: config.Width, Height: config.Height}, nil
+}
+
+// Prober reads audio and video files.
+type Prober interface {
+	// Probe reads the streams and length of the file at path.
+	Probe(ctx context.Context, path string) (*Info, error)
+
+	// PosterFrame writes a JPEG still from the start of the video at path.
+	PosterFrame(ctx context.Context, path string, w io.Writer) error
+}
+
+// Transcoder converts audio and video to a format every browser can play.
+type Transcoder interface {
+	// Transcode writes the file at src to dst, returning the MIME type of what it wrote.
+	Transcode(ctx context.Context, src string, dst string, kind data.AttachmentKind) (string, error)
+}
+
+// FFmpeg probes and transcodes with the ffprobe and ffmpeg binaries.
+type FFmpeg struct {
+	// Defaults to ffprobe on the PATH.
+	ProbePath string
+	// Defaults to ffmpeg on the PATH.
+	FFmpegPath string
+}
+
+func (f *FFmpeg) probePath() string {
+	if len(f.ProbePath) == 0 {
+		return "ffprobe"
+	}
+	return f.ProbePath
+}
+
+func (f *FFmpeg) ffmpegPath() string {
+	if len(f.FFmpegPath) == 0 {
+		return "ffmpeg"
+	}
+	return f.FFmpegPath
+}
+
+// run runs a command, including its stderr in the error if it fails.
+func run(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", cmd.Path, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// parseProbe reads ffprobe's JSON output.
+func parseProbe(output []byte) (*Info, error) {
+	var probed ffprobeOutput
+	if err := json.Unmarshal(output, &probed); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	info := &Info{}
+	for _, stream := range probed.Streams {
+		switch stream.CodecType {
+		case "video":
+			if !info.HasVideo {
+				info.HasVideo = true
+				info.Width, info.Height = stream.Width, stream.Height
+			}
+		case "audio":
+			info.HasAudio = true
+		}
+	}
+	if !info.HasVideo && !info.HasAudio {
+		return nil, ErrUnsupported
+	}
+	if len(probed.Format.Duration) > 0 {
+		seconds, err := strconv.ParseFloat(probed.Format.Duration, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration: %w", err)
+		}
+		info.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return info, nil
+}
+
+func (f *FFmpeg) Probe(ctx context.Context, path string) (*Info, error) {
+	cmd := exec.CommandContext(
+		ctx, f.probePath(), "-v", "error", "-print_format", "json", "-show_streams", "-show_format", path,
+	)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := run(cmd); err != nil {
+		return nil, err
+	}
+	return parseProbe(stdout.Bytes())
+}
+
+func (f *FFmpeg) PosterFrame(ctx context.Context, path string, w io.Writer) error {
+	cmd := exec.CommandContext(
+		ctx, f.ffmpegPath(), "-v", "error", "-i", path,
+		"-frames:v", "1", "-vf", "scale='min(480,iw)':-2", "-f", "image2", "-c:v", "mjpeg", "pipe:1",
+	)
+	cmd.Stdout = w
+	return run(cmd)
+}
+
+// Video is transcoded to H.264 and AAC in MP4, audio to MP3.
+func (f *FFmpeg) Transcode(ctx context.Context, src string, dst string, kind data.AttachmentKind) (string, error) {
+	args := []string{"-v", "error", "-y", "-i", src}
+	var mime string
+	switch kind {
+	case data.AttachmentVideo:
+		args = append(args,
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-movflags", "+faststart", "-f", "mp4", dst,
+		)
+		mime = "video/mp4"
+	case data.AttachmentAudio:
+		args = append(args, "-vn", "-c:a", "libmp3lame", "-q:a", "4", "-f", "mp3", dst)
+		mime = "audio/mpeg"
+	default:
+		return "", ErrUnsupported
+	}
+	if err := run(exec.CommandContext(ctx, f.ffmpegPath(), args...)); err != nil {
+		return "", err
+	}
+	return mime, nil
+}
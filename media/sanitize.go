@@ -0,0 +1,342 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+var ErrPolyglot = errors.New("file hides other content")
+var ErrMalformed = errors.New("malformed image")
+
+// Declared types that mean the same as a sniffed type.
+var declaredAliases = map[string]string{
+	"image/jpg":   "image/jpeg",
+	"image/pjpeg": "image/jpeg",
+	"audio/mp3":   "audio/mpeg",
+	"audio/wav":   "audio/wave",
+	"audio/x-wav": "audio/wave",
+	"audio/ogg":   "application/ogg",
+	"video/ogg":   "application/ogg",
+}
+
+/*
+MatchesDeclared reports whether the type an uploader declared agrees with the sniffed type.
+Nothing declared, or a generic binary type, agrees with anything. Audio and video containers
+are only checked for being audio or video, since browsers disagree on what to call them.
+*/
+func MatchesDeclared(declared string, sniffed string) bool {
+	declared = strings.ToLower(strings.TrimSpace(declared))
+	if i := strings.IndexByte(declared, ';'); i >= 0 {
+		declared = strings.TrimSpace(declared[:i])
+	}
+	if len(declared) == 0 || declared == "application/octet-stream" {
+		return true
+	}
+	if alias, ok := declaredAliases[declared]; ok {
+		declared = alias
+	}
+	if declared == sniffed {
+		return true
+	}
+	isMedia := func(mime string) bool {
+		return strings.HasPrefix(mime, "audio/") || strings.HasPrefix(mime, "video/") || mime == "application/ogg"
+	}
+	return isMedia(declared) && isMedia(sniffed)
+}
+
+// Markup browsers or interpreters would run if a file were ever served as something else.
+var markup = [][]byte{
+	[]byte("<script"), []byte("<html"), []byte("<svg"), []byte("<?php"), []byte("<iframe"), []byte("<!doctype"),
+}
+
+// ContainsMarkup reports whether the file has HTML, SVG or PHP in it, which is how polyglots smuggle scripts.
+func ContainsMarkup(r io.Reader) (bool, error) {
+	longest := 0
+	for _, m := range markup {
+		if len(m) > longest {
+			longest = len(m)
+		}
+	}
+	br := bufio.NewReaderSize(r, 1<<16)
+	buf := make([]byte, 0, 1<<16+longest)
+	chunk := make([]byte, 1<<16)
+	for {
+		n, err := br.Read(chunk)
+		buf = append(buf, bytes.ToLower(chunk[:n])...)
+		for _, m := range markup {
+			if bytes.Contains(buf, m) {
+				return true, nil
+			}
+		}
+		// Keep the tail so markup split across reads is still found.
+		if len(buf) > longest {
+			buf = append(buf[:0], buf[len(buf)-longest:]...)
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+/*
+StripMetadata returns a JPEG, PNG or GIF without its EXIF, XMP, text and comment metadata,
+which can give away where and on what a photo was taken. EXIF orientation is lost with it.
+Anything after the end of the image is rejected with ErrPolyglot.
+*/
+func StripMetadata(mime string, file []byte) ([]byte, error) {
+	switch mime {
+	case "image/jpeg":
+		return stripJPEG(file)
+	case "image/png":
+		return stripPNG(file)
+	case "image/gif":
+		return stripGIF(file)
+	}
+	return nil, ErrUnsupported
+}
+
+/*
+Reencode decodes and re-encodes a JPEG, PNG or GIF, leaving nothing of the original file but its pixels.
+It's slower and lossy for JPEGs, but nothing hidden in the image's structure survives it.
+*/
+func Reencode(mime string, file []byte) ([]byte, error) {
+	var out bytes.Buffer
+	switch mime {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(file))
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: 90})
+		if err != nil {
+			return nil, err
+		}
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(file))
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		err = png.Encode(&out, img)
+		if err != nil {
+			return nil, err
+		}
+	case "image/gif":
+		img, err := gif.DecodeAll(bytes.NewReader(file))
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		err = gif.EncodeAll(&out, img)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+	return out.Bytes(), nil
+}
+
+// onlyPadding reports whether trailing bytes are zero padding, which some cameras add.
+func onlyPadding(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// JPEG segments that are kept, besides the ones needed to decode: JFIF, ICC profiles and Adobe color transforms.
+func keepJPEGSegment(marker byte) bool {
+	switch marker {
+	case 0xe0, 0xe2, 0xee:
+		return true
+	}
+	// Other application segments and comments.
+	return !(marker >= 0xe1 && marker <= 0xef) && marker != 0xfe
+}
+
+func stripJPEG(file []byte) ([]byte, error) {
+	if len(file) < 4 || file[0] != 0xff || file[1] != 0xd8 {
+		return nil, ErrMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(file)))
+	out.Write(file[:2])
+	i := 2
+	for {
+		if i >= len(file) || file[i] != 0xff {
+			return nil, ErrMalformed
+		}
+		for i < len(file) && file[i] == 0xff {
+			i++
+		}
+		if i >= len(file) {
+			return nil, ErrMalformed
+		}
+		marker := file[i]
+		i++
+		switch {
+		case marker == 0xd9:
+			out.Write([]byte{0xff, 0xd9})
+			if !onlyPadding(file[i:]) {
+				return nil, ErrPolyglot
+			}
+			return out.Bytes(), nil
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			out.Write([]byte{0xff, marker})
+			continue
+		}
+		if i+2 > len(file) {
+			return nil, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint16(file[i:]))
+		if length < 2 || i+length > len(file) {
+			return nil, ErrMalformed
+		}
+		segment := file[i : i+length]
+		i += length
+		if keepJPEGSegment(marker) {
+			out.Write([]byte{0xff, marker})
+			out.Write(segment)
+		}
+		if marker != 0xda {
+			continue
+		}
+		// Entropy-coded data runs until a marker that isn't a stuffed byte or a restart.
+		start := i
+		for i+1 < len(file) {
+			if file[i] == 0xff {
+				next := file[i+1]
+				if next != 0 && next != 0xff && !(next >= 0xd0 && next <= 0xd7) {
+					break
+				}
+			}
+			i++
+		}
+		out.Write(file[start:i])
+	}
+}
+
+// PNG chunks holding text, EXIF or timestamps.
+var pngMetadata = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+func stripPNG(file []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(file) < len(signature) || string(file[:len(signature)]) != signature {
+		return nil, ErrMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(file)))
+	out.WriteString(signature)
+	i := len(signature)
+	for {
+		if i+8 > len(file) {
+			return nil, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint32(file[i:]))
+		kind := string(file[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(file) || end < i {
+			return nil, ErrMalformed
+		}
+		if !pngMetadata[kind] {
+			out.Write(file[i:end])
+		}
+		i = end
+		if kind == "IEND" {
+			if !onlyPadding(file[i:]) {
+				return nil, ErrPolyglot
+			}
+			return out.Bytes(), nil
+		}
+	}
+}
+
+// skipSubBlocks returns the index after a GIF's data sub-blocks starting at i.
+func skipSubBlocks(file []byte, i int) (int, error) {
+	for {
+		if i >= len(file) {
+			return 0, ErrMalformed
+		}
+		size := int(file[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
+
+// GIF application extensions that are kept, for looping animations.
+var gifApplications = map[string]bool{"NETSCAPE2.0": true, "ANIMEXTS1.0": true}
+
+func stripGIF(file []byte) ([]byte, error) {
+	if len(file) < 13 || (string(file[:6]) != "GIF87a" && string(file[:6]) != "GIF89a") {
+		return nil, ErrMalformed
+	}
+	i := 13
+	if flags := file[10]; flags&0x80 != 0 {
+		i += 3 << ((flags & 7) + 1)
+	}
+	if i > len(file) {
+		return nil, ErrMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(file)))
+	out.Write(file[:i])
+	for {
+		if i >= len(file) {
+			return nil, ErrMalformed
+		}
+		start := i
+		switch file[i] {
+		case 0x3b:
+			out.WriteByte(0x3b)
+			if !onlyPadding(file[i+1:]) {
+				return nil, ErrPolyglot
+			}
+			return out.Bytes(), nil
+		case 0x2c:
+			i += 10
+			if i > len(file) {
+				return nil, ErrMalformed
+			}
+			if flags := file[i-1]; flags&0x80 != 0 {
+				i += 3 << ((flags & 7) + 1)
+			}
+			// LZW minimum code size, then the image data.
+			end, err := skipSubBlocks(file, i+1)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(file[start:end])
+			i = end
+		case 0x21:
+			if i+2 > len(file) {
+				return nil, ErrMalformed
+			}
+			label := file[i+1]
+			end, err := skipSubBlocks(file, i+2)
+			if err != nil {
+				return nil, err
+			}
+			keep := label == 0xf9
+			if label == 0xff && i+14 <= end {
+				keep = gifApplications[string(file[i+3:i+14])]
+			}
+			if keep {
+				out.Write(file[start:end])
+			}
+			i = end
+		default:
+			return nil, ErrMalformed
+		}
+	}
+}
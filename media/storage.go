@@ -0,0 +1,127 @@
+package media
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("file not found")
+var ErrInvalidKey = errors.New("invalid file key")
+
+// Storage holds uploaded files by key.
+type Storage interface {
+	// Put stores the file under key, replacing any file already there.
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	/*
+		Open returns the file stored under key, the caller must close it.
+		Returns ErrNotFound if there isn't one.
+	*/
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Remove deletes the file under key, if there is one.
+	Remove(ctx context.Context, key string) error
+
+	/*
+		Size returns the size in bytes of the file stored under key.
+		Returns ErrNotFound if there isn't one.
+	*/
+	Size(ctx context.Context, key string) (int64, error)
+}
+
+// NewKey returns a random storage key with the extension, like ".png".
+func NewKey(ext string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b) + ext
+}
+
+// validKey reports whether key is a plain file name, so it can't reach outside the storage directory.
+func validKey(key string) bool {
+	return len(key) > 0 && key != "." && key != ".." && !strings.ContainsAny(key, `/\`) && filepath.Base(key) == key
+}
+
+// Disk stores files in a directory.
+type Disk struct {
+	dir string
+}
+
+// NewDisk stores files in dir, creating it if it doesn't exist.
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return &Disk{dir: dir}, nil
+}
+
+func (disk *Disk) Put(ctx context.Context, key string, r io.Reader) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	// Written to a temporary file first so readers never see half a file.
+	tmp, err := ioutil.TempFile(disk.dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(disk.dir, key)); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return nil
+}
+
+func (disk *Disk) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(filepath.Join(disk.dir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return f, nil
+}
+
+func (disk *Disk) Remove(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(filepath.Join(disk.dir, key))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	return nil
+}
+
+func (disk *Disk) Size(ctx context.Context, key string) (int64, error) {
+	if !validKey(key) {
+		return 0, ErrNotFound
+	}
+	info, err := os.Stat(filepath.Join(disk.dir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return info.Size(), nil
+}
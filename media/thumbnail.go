@@ -0,0 +1,159 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"log"
+	"os/exec"
+	"spiritchat/data"
+)
+
+// ThumbnailSize is a box thumbnails are scaled down to fit in.
+type ThumbnailSize struct {
+	Name string
+	Max  int
+}
+
+// Thumbnail sizes, smallest first: small for the catalog, medium for thread views.
+var ThumbnailSizes = []ThumbnailSize{{Name: "small", Max: 150}, {Name: "medium", Max: 480}}
+
+// Thumbnail formats other than JPEG, which are encoded with an ImageEncoder.
+var thumbnailExtensions = map[string]string{
+	"image/webp": ".webp",
+	"image/avif": ".avif",
+}
+
+// ImageEncoder encodes images in formats the standard library can't.
+type ImageEncoder interface {
+	// EncodeImage re-encodes the JPEG read from src as the MIME type.
+	EncodeImage(ctx context.Context, src io.Reader, mime string, w io.Writer) error
+}
+
+// Variant is an encoded thumbnail, waiting to be stored under its File key.
+type Variant struct {
+	data.Thumbnail
+	Content []byte
+}
+
+/*
+Scale returns the image scaled down by averaging to fit in a max by max box, keeping its aspect ratio.
+Images that already fit are returned as they are.
+*/
+func Scale(img image.Image, max int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	dw, dh := max, h*max/w
+	if h > w {
+		dw, dh = w*max/h, max
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, (y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, (x+1)*w/dw
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := y*dst.Stride + x*4
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+/*
+Thumbnails scales the image to each of the ThumbnailSizes and encodes it as a JPEG, and in each of formats
+with the encoder if there is one. Sizes the image already fits a smaller size of are skipped.
+Formats the encoder fails on are logged and left out, since the JPEG is always there to fall back on.
+*/
+func Thumbnails(ctx context.Context, img image.Image, encoder ImageEncoder, formats []string) ([]*Variant, error) {
+	variants := make([]*Variant, 0)
+	bounds := img.Bounds()
+	previous := 0
+	for _, size := range ThumbnailSizes {
+		longest := bounds.Dx()
+		if bounds.Dy() > longest {
+			longest = bounds.Dy()
+		}
+		if previous > 0 && longest <= previous {
+			break
+		}
+		previous = size.Max
+
+		scaled := Scale(img, size.Max)
+		var fallback bytes.Buffer
+		if err := jpeg.Encode(&fallback, scaled, &jpeg.Options{Quality: 80}); err != nil {
+			return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		thumbnail := data.Thumbnail{
+			Size:   size.Name,
+			Width:  scaled.Bounds().Dx(),
+			Height: scaled.Bounds().Dy(),
+		}
+		if encoder != nil {
+			for _, mime := range formats {
+				ext, ok := thumbnailExtensions[mime]
+				if !ok {
+					continue
+				}
+				var encoded bytes.Buffer
+				err := encoder.EncodeImage(ctx, bytes.NewReader(fallback.Bytes()), mime, &encoded)
+				if err != nil {
+					log.Printf("failed to encode %s thumbnail as %s: %v", size.Name, mime, err)
+					continue
+				}
+				variant := &Variant{Thumbnail: thumbnail, Content: encoded.Bytes()}
+				variant.MIME, variant.File = mime, NewKey(ext)
+				variants = append(variants, variant)
+			}
+		}
+		variant := &Variant{Thumbnail: thumbnail, Content: fallback.Bytes()}
+		variant.MIME, variant.File = "image/jpeg", NewKey(".jpg")
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+// Encodes WebP with libwebp and AVIF with libaom, either may be missing from ffmpeg builds.
+func (f *FFmpeg) EncodeImage(ctx context.Context, src io.Reader, mime string, w io.Writer) error {
+	args := []string{"-v", "error", "-f", "image2pipe", "-c:v", "mjpeg", "-i", "pipe:0"}
+	switch mime {
+	case "image/webp":
+		args = append(args, "-c:v", "libwebp", "-quality", "75", "-f", "webp", "pipe:1")
+	case "image/avif":
+		args = append(args, "-c:v", "libaom-av1", "-still-picture", "1", "-crf", "35", "-f", "avif", "pipe:1")
+	default:
+		return ErrUnsupported
+	}
+	cmd := exec.CommandContext(ctx, f.ffmpegPath(), args...)
+	cmd.Stdin = src
+	cmd.Stdout = w
+	return run(cmd)
+}
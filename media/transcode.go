@@ -0,0 +1,80 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"spiritchat/data"
+	"spiritchat/events"
+)
+
+// Store is the subset of data.Store transcodes are recorded in.
+type Store interface {
+	SetAttachmentTranscode(ctx context.Context, id int, file string, mime string) error
+}
+
+/*
+SubscribeTranscoder transcodes audio and video attachments published on the bus in the background,
+recording the transcoded file on the attachment once it's stored. The original's kept either way.
+*/
+func SubscribeTranscoder(bus *events.Bus, store Store, storage Storage, transcoder Transcoder) {
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		attachment := event.Attachment
+		if attachment == nil || attachment.Kind == data.AttachmentImage {
+			return
+		}
+		err := transcode(ctx, store, storage, transcoder, attachment)
+		if err != nil {
+			log.Printf("failed to transcode attachment %d: %v", attachment.ID, err)
+		}
+	}, events.AttachmentUploaded)
+}
+
+func transcode(ctx context.Context, store Store, storage Storage, transcoder Transcoder, attachment *data.Attachment) error {
+	dir, err := ioutil.TempDir("", "spiritchat-transcode-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := os.Create(filepath.Join(dir, "src"+Extension(attachment.MIME)))
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	original, err := storage.Open(ctx, attachment.File)
+	if err != nil {
+		src.Close()
+		return err
+	}
+	_, err = io.Copy(src, original)
+	original.Close()
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy original: %w", err)
+	}
+
+	dst := filepath.Join(dir, "dst")
+	mime, err := transcoder.Transcode(ctx, src.Name(), dst, attachment.Kind)
+	if err != nil {
+		return err
+	}
+	transcoded, err := os.Open(dst)
+	if err != nil {
+		return fmt.Errorf("failed to open transcoded file: %w", err)
+	}
+	defer transcoded.Close()
+
+	key := NewKey(Extension(mime))
+	if err := storage.Put(ctx, key, transcoded); err != nil {
+		return err
+	}
+	if err := store.SetAttachmentTranscode(ctx, attachment.ID, key, mime); err != nil {
+		storage.Remove(ctx, key)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/smtp"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+	"time"
+)
+
+// How long sending a notification email can take.
+const mailTimeout = time.Second * 30
+
+// Mailer sends plain text email.
+type Mailer interface {
+	Send(ctx context.Context, to []string, subject string, body string) error
+}
+
+// SMTPOptions configure sending mail through an SMTP server.
+type SMTPOptions struct {
+	// Host and port of the server, like smtp.example.com:587.
+	Address string
+	// Optional, mail is sent without authenticating if unset.
+	Username string
+	Password string
+	// Address mail is sent from.
+	From string
+}
+
+// SMTP is a Mailer sending through an SMTP server, upgrading to TLS when the server offers it.
+type SMTP struct {
+	opts SMTPOptions
+}
+
+// NewSMTP creates a mailer sending through the configured server.
+func NewSMTP(opts SMTPOptions) *SMTP {
+	return &SMTP{opts: opts}
+}
+
+// Headers can't be given a line break, or they could add headers of their own.
+var headerBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+func (s *SMTP) Send(ctx context.Context, to []string, subject string, body string) error {
+	host, _, err := net.SplitHostPort(s.opts.Address)
+	if err != nil {
+		return fmt.Errorf("invalid smtp address: %w", err)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.opts.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to greet smtp server: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+	if len(s.opts.Username) > 0 {
+		err = client.Auth(smtp.PlainAuth("", s.opts.Username, s.opts.Password, host))
+		if err != nil {
+			return fmt.Errorf("failed to authenticate with smtp server: %w", err)
+		}
+	}
+	err = client.Mail(s.opts.From)
+	if err != nil {
+		return fmt.Errorf("failed to set sender: %w", err)
+	}
+	for _, recipient := range to {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return fmt.Errorf("failed to add recipient %s: %w", recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %w", err)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", headerBreaks.Replace(s.opts.From))
+	fmt.Fprintf(&msg, "To: %s\r\n", headerBreaks.Replace(strings.Join(to, ", ")))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", headerBreaks.Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	_, err = w.Write([]byte(msg.String()))
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
+
+// complaintEmail writes the email telling the abuse contact about a complaint.
+func complaintEmail(complaint *data.Complaint, zone *time.Location) (string, string) {
+	subject := fmt.Sprintf("Abuse complaint #%d", complaint.ID)
+	if complaint.Kind == data.ComplaintDMCA {
+		subject = fmt.Sprintf("Takedown notice #%d", complaint.ID)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s filed %s.\n\n", html.UnescapeString(complaint.Name), strings.ToLower(subject))
+	fmt.Fprintf(&body, "From: %s <%s>\n", html.UnescapeString(complaint.Name), complaint.Email)
+	fmt.Fprintf(&body, "Filed: %s\n", complaint.CreatedAt.In(zone).Format(time.RFC1123))
+	body.WriteString("Posts:\n")
+	for _, ref := range complaint.Posts {
+		fmt.Fprintf(&body, "  /%s/%d\n", ref.Cat, ref.Num)
+	}
+	if len(complaint.Work) > 0 {
+		fmt.Fprintf(&body, "\nCopyrighted work:\n%s\n", html.UnescapeString(complaint.Work))
+	}
+	fmt.Fprintf(&body, "\nDetails:\n%s\n", html.UnescapeString(complaint.Details))
+	if len(complaint.Signature) > 0 {
+		fmt.Fprintf(&body, "\nSigned: %s\n", html.UnescapeString(complaint.Signature))
+	}
+	body.WriteString("\nThe posts that still exist are at the top of the moderation queue.\n")
+	return subject, body.String()
+}
+
+// SubscribeComplaints emails the abuse contacts about complaints published on the bus, with times in the zone.
+func SubscribeComplaints(bus *events.Bus, mailer Mailer, to []string, zone *time.Location) {
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		if event.Complaint == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, mailTimeout)
+		defer cancel()
+		subject, body := complaintEmail(event.Complaint, zone)
+		err := mailer.Send(ctx, to, subject, body)
+		if err != nil {
+			log.Printf("failed to email complaint #%d: %v", event.Complaint.ID, err)
+		}
+	}, events.ComplaintFiled)
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"reflect"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockMailer struct {
+	to      []string
+	subject string
+	body    string
+}
+
+func (mm *mockMailer) Send(ctx context.Context, to []string, subject string, body string) error {
+	mm.to, mm.subject, mm.body = to, subject, body
+	return nil
+}
+
+func TestSubscribeComplaints(t *testing.T) {
+	mailer := &mockMailer{}
+	bus := events.NewBus()
+	SubscribeComplaints(bus, mailer, []string{"abuse@spirit.example"}, time.FixedZone("NZST", 12*60*60))
+
+	bus.Publish(events.Event{
+		Kind: events.ComplaintFiled,
+		Complaint: &data.Complaint{
+			ID:        7,
+			Kind:      data.ComplaintDMCA,
+			Name:      "Owl &amp; Co",
+			Email:     "owl@example.com",
+			Details:   "these posts copy my book",
+			Work:      "My book",
+			Signature: "Owl",
+			Posts:     []data.PostRef{{Cat: "tech", Num: 1}},
+			CreatedAt: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+	})
+	bus.Wait()
+
+	if !reflect.DeepEqual(mailer.to, []string{"abuse@spirit.example"}) || mailer.subject != "Takedown notice #7" {
+		t.Fatalf("expected takedown notice #7 sent to the abuse contact, got %q to %v", mailer.subject, mailer.to)
+	}
+	for _, expected := range []string{"Owl & Co <owl@example.com>", "/tech/1", "My book", "Signed: Owl", "Filed: Mon, 01 Jun 2020 12:00:00 NZST"} {
+		if !strings.Contains(mailer.body, expected) {
+			t.Errorf("expected the email to contain %q, got:\n%s", expected, mailer.body)
+		}
+	}
+}
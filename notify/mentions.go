@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+)
+
+// Most users a single post can notify, so a post can't be used to ping everyone.
+const MaxMentions = 10
+
+// Store is the subset of data.Store mentions are recorded in.
+type Store interface {
+	WriteMentions(ctx context.Context, post *data.Post, authorID string, usernames []string) (int, error)
+}
+
+// An @ not following a word character, then a username.
+var mention = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.-]+[A-Za-z0-9_])`)
+
+// Mentions returns the distinct usernames mentioned in content, in order, up to MaxMentions.
+func Mentions(content string) []string {
+	usernames := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range mention.FindAllStringSubmatch(content, -1) {
+		username := match[1]
+		if seen[strings.ToLower(username)] {
+			continue
+		}
+		seen[strings.ToLower(username)] = true
+		usernames = append(usernames, username)
+		if len(usernames) == MaxMentions {
+			break
+		}
+	}
+	return usernames
+}
+
+// Subscribe notifies users mentioned in new posts published on the bus.
+func Subscribe(bus *events.Bus, store Store) {
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		if event.Post == nil {
+			return
+		}
+		usernames := Mentions(event.Post.Content)
+		if len(usernames) == 0 {
+			return
+		}
+		_, err := store.WriteMentions(ctx, event.Post, event.Author, usernames)
+		if err != nil {
+			log.Printf("failed to notify mentions in %s/%d: %v", event.Category, event.Num, err)
+		}
+	}, events.PostCreated)
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"context"
+	"reflect"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+	"testing"
+)
+
+type mockStore struct {
+	authorID  string
+	usernames []string
+}
+
+func (ms *mockStore) WriteMentions(ctx context.Context, post *data.Post, authorID string, usernames []string) (int, error) {
+	ms.authorID, ms.usernames = authorID, usernames
+	return len(usernames), nil
+}
+
+func TestMentions(t *testing.T) {
+	tests := map[string][]string{
+		"no mentions here":             {},
+		"@spirit hello":                {"spirit"},
+		"hi @spirit, and @Owl_2.":      {"spirit", "Owl_2"},
+		"@spirit @SPIRIT @spirit":      {"spirit"},
+		"mail me at owl@example.com":   {},
+		"@@spirit":                     {},
+		"line one\n@spirit line two":   {"spirit"},
+		strings.Repeat("@a1 @b2 ", 10): {"a1", "b2"},
+	}
+
+	for input, expected := range tests {
+		t.Run(input, func(t *testing.T) {
+			if got := Mentions(input); !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+		})
+	}
+
+	var many strings.Builder
+	for i := 0; i < MaxMentions+5; i++ {
+		many.WriteString("@user" + strings.Repeat("x", i) + " ")
+	}
+	if got := Mentions(many.String()); len(got) != MaxMentions {
+		t.Errorf("expected mentions capped at %d, got %d", MaxMentions, len(got))
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	store := &mockStore{}
+	bus := events.NewBus()
+	Subscribe(bus, store)
+
+	bus.Publish(events.Event{
+		Kind:   events.PostCreated,
+		Author: "auth0|op",
+		Post:   &data.Post{Content: "thanks @helper"},
+	})
+	bus.Wait()
+	if store.authorID != "auth0|op" || !reflect.DeepEqual(store.usernames, []string{"helper"}) {
+		t.Errorf("expected helper mentioned by auth0|op, got %v by %s", store.usernames, store.authorID)
+	}
+}
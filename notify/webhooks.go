@@ -0,0 +1,273 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// WebhookKinds are the events webhooks can be sent.
+var WebhookKinds = []events.Kind{events.PostCreated, events.PostDeleted, events.ThreadLocked}
+
+// Longest post content put in a Discord embed, well under Discord's own limit.
+const maxEmbedContent = 2000
+
+// WebhookStore is the subset of data.Store webhooks are read from.
+type WebhookStore interface {
+	GetWebhooks(ctx context.Context) ([]*data.Webhook, error)
+	GetCategory(ctx context.Context, categoryTag string) (*data.Category, error)
+}
+
+// WebhookOptions configure webhook payloads.
+type WebhookOptions struct {
+	// Board's site, posts are linked to from payloads when set.
+	SiteURL string
+	// Name Discord messages are posted under, defaults to spiritchat.
+	BoardName string
+	// Board-local timezone templates can show times in with local, defaults to UTC.
+	Timezone *time.Location
+}
+
+/*
+Webhooks posts events to the webhooks in the store that want them.
+Webhooks are read on every event, so changes through the admin API apply immediately.
+*/
+type Webhooks struct {
+	store     WebhookStore
+	opts      WebhookOptions
+	client    *http.Client
+	mut       sync.Mutex
+	templates map[string]*template.Template
+}
+
+// NewWebhooks creates a sender for the webhooks in the store.
+func NewWebhooks(store WebhookStore, opts WebhookOptions) *Webhooks {
+	if len(opts.BoardName) == 0 {
+		opts.BoardName = "spiritchat"
+	}
+	if opts.Timezone == nil {
+		opts.Timezone = time.UTC
+	}
+	return &Webhooks{
+		store:     store,
+		opts:      opts,
+		client:    &http.Client{Timeout: time.Second * 5},
+		templates: make(map[string]*template.Template),
+	}
+}
+
+// SubscribeWebhooks sends events published on the bus to webhooks.
+func SubscribeWebhooks(bus *events.Bus, hooks *Webhooks) {
+	bus.Subscribe(hooks.Send, WebhookKinds...)
+}
+
+// WebhookPayload is what json webhooks are sent, and what templates are executed against.
+type WebhookPayload struct {
+	Event string `json:"event"`
+	Cat   string `json:"cat"`
+	// Current slug of the category.
+	Slug   string `json:"slug"`
+	Num    int    `json:"num"`
+	Thread int    `json:"thread"`
+	// Link to the post on the board's site, if there's one.
+	URL string `json:"url,omitempty"`
+	// When the event happened, in UTC.
+	At   time.Time  `json:"at"`
+	Post *data.Post `json:"post,omitempty"`
+}
+
+var templateFuncs = template.FuncMap{
+	// json writes a value as JSON, so templates can put post content in strings safely.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// text undoes the HTML escaping posts are stored with.
+	"text":     html.UnescapeString,
+	"truncate": truncate,
+	// local puts a time in the board's timezone, like {{ (local .At).Format "Jan 2 15:04 MST" }}.
+	"local": func(t time.Time) time.Time { return t.UTC() },
+}
+
+// truncate cuts s down to length characters, ending it with an ellipsis if it's cut.
+func truncate(length int, s string) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+// ParseWebhookTemplate parses a webhook payload template, which is executed against a WebhookPayload.
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// template returns the parsed template, parsing it once.
+func (w *Webhooks) template(text string) (*template.Template, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if tmpl, ok := w.templates[text]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := ParseWebhookTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Funcs(template.FuncMap{"local": func(t time.Time) time.Time { return t.In(w.opts.Timezone) }})
+	w.templates[text] = tmpl
+	return tmpl, nil
+}
+
+// payload describes the event, linking to the post under its category's current slug.
+func (w *Webhooks) payload(ctx context.Context, event events.Event) *WebhookPayload {
+	payload := &WebhookPayload{
+		Event:  string(event.Kind),
+		Cat:    event.Category,
+		Slug:   event.Category,
+		Num:    event.Num,
+		Thread: event.Parent,
+		At:     event.At.UTC(),
+		Post:   event.Post,
+	}
+	if payload.Thread == 0 {
+		payload.Thread = event.Num
+	}
+	category, err := w.store.GetCategory(ctx, event.Category)
+	if err == nil {
+		payload.Slug = category.Slug
+	}
+	if len(w.opts.SiteURL) > 0 {
+		payload.URL = fmt.Sprintf("%s/%s/%d", strings.TrimSuffix(w.opts.SiteURL, "/"), payload.Slug, payload.Thread)
+		if payload.Num != payload.Thread {
+			payload.URL += fmt.Sprintf("#%d", payload.Num)
+		}
+	}
+	return payload
+}
+
+type discordEmbed struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Timestamp   string `json:"timestamp"`
+	Footer      struct {
+		Text string `json:"text"`
+	} `json:"footer"`
+}
+
+type discordMessage struct {
+	Username string         `json:"username"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+// discord writes the event as a Discord message with a single embed.
+func (w *Webhooks) discord(payload *WebhookPayload) discordMessage {
+	embed := discordEmbed{URL: payload.URL, Timestamp: payload.At.Format(time.RFC3339)}
+	embed.Footer.Text = fmt.Sprintf("/%s/%d", payload.Slug, payload.Num)
+	switch events.Kind(payload.Event) {
+	case events.PostCreated:
+		embed.Title = fmt.Sprintf("New reply in /%s/%d", payload.Slug, payload.Thread)
+		if payload.Num == payload.Thread {
+			embed.Title = fmt.Sprintf("New thread on /%s/", payload.Slug)
+		}
+		if payload.Post != nil {
+			if len(payload.Post.Subject) > 0 {
+				embed.Title = html.UnescapeString(payload.Post.Subject)
+			}
+			embed.Description = truncate(maxEmbedContent, html.UnescapeString(payload.Post.Content))
+		}
+	case events.PostDeleted:
+		embed.Title = fmt.Sprintf("Post /%s/%d deleted", payload.Slug, payload.Num)
+	case events.ThreadLocked:
+		embed.Title = fmt.Sprintf("Thread /%s/%d locked", payload.Slug, payload.Thread)
+	default:
+		embed.Title = payload.Event
+	}
+	return discordMessage{Username: w.opts.BoardName, Embeds: []discordEmbed{embed}}
+}
+
+// render writes the payload in the webhook's format.
+func (w *Webhooks) render(hook *data.Webhook, payload *WebhookPayload) ([]byte, error) {
+	switch hook.Format {
+	case data.WebhookDiscord:
+		return json.Marshal(w.discord(payload))
+	case data.WebhookTemplate:
+		tmpl, err := w.template(hook.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		var body bytes.Buffer
+		err = tmpl.Execute(&body, payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute template: %w", err)
+		}
+		return body.Bytes(), nil
+	default:
+		return json.Marshal(payload)
+	}
+}
+
+// post sends a payload to the webhook, signing it with the webhook's secret if it has one.
+func (w *Webhooks) post(ctx context.Context, hook *data.Webhook, kind events.Kind, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", w.opts.BoardName+" webhooks")
+	req.Header.Set("X-Spiritchat-Event", string(kind))
+	if len(hook.Secret) > 0 {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Spiritchat-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("responded %s", res.Status)
+	}
+	return nil
+}
+
+// Send posts the event to every webhook that wants it. Failed deliveries are logged, and not retried.
+func (w *Webhooks) Send(ctx context.Context, event events.Event) {
+	hooks, err := w.store.GetWebhooks(ctx)
+	if err != nil {
+		log.Printf("webhooks: failed to get webhooks: %v", err)
+		return
+	}
+	var payload *WebhookPayload
+	for _, hook := range hooks {
+		if !hook.Wants(string(event.Kind), event.Category) {
+			continue
+		}
+		if payload == nil {
+			payload = w.payload(ctx, event)
+		}
+		body, err := w.render(hook, payload)
+		if err == nil {
+			err = w.post(ctx, hook, event.Kind, body)
+		}
+		if err != nil {
+			log.Printf("webhooks: failed to send %s to webhook %d: %v", event.Kind, hook.ID, err)
+		}
+	}
+}
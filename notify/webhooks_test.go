@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockWebhookStore struct {
+	hooks []*data.Webhook
+}
+
+func (ms *mockWebhookStore) GetWebhooks(ctx context.Context) ([]*data.Webhook, error) {
+	return ms.hooks, nil
+}
+
+func (ms *mockWebhookStore) GetCategory(ctx context.Context, categoryTag string) (*data.Category, error) {
+	return &data.Category{Tag: categoryTag, Slug: categoryTag + "-slug"}, nil
+}
+
+type delivery struct {
+	path      string
+	body      []byte
+	signature string
+}
+
+func TestWebhooks(t *testing.T) {
+	var mut sync.Mutex
+	delivered := make(map[string]delivery)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mut.Lock()
+		delivered[req.URL.Path] = delivery{req.URL.Path, body, req.Header.Get("X-Spiritchat-Signature")}
+		mut.Unlock()
+	}))
+	defer receiver.Close()
+
+	store := &mockWebhookStore{hooks: []*data.Webhook{
+		{ID: 1, URL: receiver.URL + "/all", Format: data.WebhookJSON, Secret: "shh", Enabled: true},
+		{ID: 2, URL: receiver.URL + "/tech", Cat: "tech", Format: data.WebhookDiscord, Enabled: true},
+		{ID: 3, URL: receiver.URL + "/art", Cat: "art", Format: data.WebhookJSON, Enabled: true},
+		{ID: 4, URL: receiver.URL + "/deletions", Events: []string{string(events.PostDeleted)}, Format: data.WebhookJSON, Enabled: true},
+		{ID: 5, URL: receiver.URL + "/disabled", Format: data.WebhookJSON},
+		{ID: 6, URL: receiver.URL + "/template", Format: data.WebhookTemplate, Enabled: true,
+			Template: `{"text": {{ json (printf "%s: %s" .Slug (text .Post.Content)) }}, "at": {{ json ((local .At).Format "15:04 MST") }}}`},
+	}}
+	bus := events.NewBus()
+	SubscribeWebhooks(bus, NewWebhooks(store, WebhookOptions{
+		SiteURL:   "https://spirit.example/",
+		BoardName: "Spirit",
+		Timezone:  time.FixedZone("NZST", 12*60*60),
+	}))
+	bus.Publish(events.Event{
+		Kind:     events.PostCreated,
+		Category: "tech",
+		Num:      2,
+		Parent:   1,
+		At:       time.Date(2020, 6, 1, 3, 30, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		Post:     &data.Post{Num: 2, Cat: "tech", Parent: 1, Content: "fish &amp; \"chips\""},
+	})
+	bus.Wait()
+
+	for _, path := range []string{"/art", "/deletions", "/disabled"} {
+		if _, ok := delivered[path]; ok {
+			t.Errorf("expected %s not to be sent the reply", path)
+		}
+	}
+
+	all, ok := delivered["/all"]
+	if !ok {
+		t.Fatal("expected the webhook on every category to be sent the reply")
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(all.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "post.created" || payload.Slug != "tech-slug" || payload.Thread != 1 || payload.URL != "https://spirit.example/tech-slug/1#2" {
+		t.Errorf("expected the reply linked under its category's slug, got: %+v", payload)
+	}
+	if !strings.Contains(string(all.body), `"at":"2020-06-01T01:30:00Z"`) {
+		t.Errorf("expected the event's time in UTC, got %s", all.body)
+	}
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write(all.body)
+	if all.signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("expected the payload to be signed with the secret, got %q", all.signature)
+	}
+
+	var message discordMessage
+	if err := json.Unmarshal(delivered["/tech"].body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Username != "Spirit" || len(message.Embeds) != 1 || message.Embeds[0].Description != `fish & "chips"` ||
+		message.Embeds[0].Title != "New reply in /tech-slug/1" {
+		t.Errorf("expected a Discord embed of the reply, got: %+v", message)
+	}
+	if len(delivered["/tech"].signature) > 0 {
+		t.Error("expected webhooks without a secret not to be signed")
+	}
+
+	var templated struct{ Text, At string }
+	if err := json.Unmarshal(delivered["/template"].body, &templated); err != nil {
+		t.Fatalf("expected the template to write valid JSON, got %s: %v", delivered["/template"].body, err)
+	}
+	if templated.Text != `tech-slug: fish & "chips"` {
+		t.Errorf("expected the template to be executed against the reply, got %q", templated.Text)
+	}
+	if templated.At != "13:30 NZST" {
+		t.Errorf("expected local to put times in the board's timezone, got %q", templated.At)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate(5, "hello"); got != "hello" {
+		t.Errorf("expected text that fits to be left alone, got %q", got)
+	}
+	if got := truncate(4, "héllo"); got != "hél…" {
+		t.Errorf("expected text cut to 4 characters, got %q", got)
+	}
+}
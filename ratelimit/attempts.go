@@ -0,0 +1,247 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+Attempts tracks attempts on keys, such as logins to an account. Attempts are counted before they're made, so
+concurrent ones can't all slip in under the limit. Once a key's free attempts are used up, each further attempt
+locks it out for twice as long as the last, up to the longest lockout.
+*/
+type Attempts interface {
+	/*
+		Attempt counts an attempt on the key, returning whether it may go ahead and when the key's lockout ends,
+		or the zero time if it isn't locked out. Attempts refused while locked out aren't counted.
+	*/
+	Attempt(ctx context.Context, key string) (lockedUntil time.Time, allowed bool, err error)
+	// Forgive uncounts a successful attempt, keeping the key's failed ones.
+	Forgive(ctx context.Context, key string) error
+	// Reset forgets the key's attempts, after a successful one.
+	Reset(ctx context.Context, key string) error
+}
+
+// AttemptsOptions configure how quickly failed attempts are locked out.
+type AttemptsOptions struct {
+	// Failures allowed before lockouts start, defaults to 3.
+	Free int
+	// How long failures are remembered after the last one, defaults to 15 minutes.
+	Window time.Duration
+	// Longest a key is locked out for, defaults to 15 minutes.
+	MaxLockout time.Duration
+}
+
+func (opts *AttemptsOptions) setDefaults() {
+	if opts.Free < 1 {
+		opts.Free = 3
+	}
+	if opts.Window <= 0 {
+		opts.Window = time.Minute * 15
+	}
+	if opts.MaxLockout <= 0 {
+		opts.MaxLockout = time.Minute * 15
+	}
+}
+
+// lockout returns how long a key is locked out for after its nth attempt, starting at a second.
+func (opts *AttemptsOptions) lockout(failures int) time.Duration {
+	over := failures - opts.Free
+	if over < 1 {
+		return 0
+	}
+	lockout := time.Second
+	for i := 1; i < over && lockout < opts.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > opts.MaxLockout {
+		return opts.MaxLockout
+	}
+	return lockout
+}
+
+/*
+Refuse the attempt if the key's locked out, otherwise count it, push back when it's forgotten and lock the key out
+if it's past its free attempts, as AttemptsOptions.lockout does.
+*/
+var attemptScript = redis.NewScript(2, `
+local ttl = redis.call("PTTL", KEYS[2])
+if ttl > 0 then
+	return {0, ttl}
+end
+local attempts = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+local over = attempts - tonumber(ARGV[2])
+if over < 1 then
+	return {1, 0}
+end
+local lockout = math.min(1000 * 2 ^ (over - 1), tonumber(ARGV[3]))
+redis.call("SET", KEYS[2], attempts, "PX", lockout)
+return {1, lockout}
+`)
+
+// Uncount an attempt, without going below none.
+var forgiveScript = redis.NewScript(1, `
+if tonumber(redis.call("GET", KEYS[1]) or "0") > 0 then
+	redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+// RedisAttempts tracks attempts in Redis, shared between every instance using the same Redis.
+type RedisAttempts struct {
+	pool *redis.Pool
+	opts AttemptsOptions
+}
+
+// NewRedisAttempts creates an attempt tracker using connections from the given pool.
+func NewRedisAttempts(pool *redis.Pool, opts AttemptsOptions) *RedisAttempts {
+	opts.setDefaults()
+	return &RedisAttempts{pool: pool, opts: opts}
+}
+
+func failuresKey(key string) string {
+	return "attempts:" + key
+}
+
+func lockoutKey(key string) string {
+	return "lockout:" + key
+}
+
+func (r *RedisAttempts) Attempt(ctx context.Context, key string) (time.Time, bool, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	// Whether the attempt's allowed, and how long the key's locked out for in milliseconds.
+	reply, err := redis.Int64s(attemptScript.Do(
+		conn, failuresKey(key), lockoutKey(key),
+		r.opts.Window.Milliseconds(), r.opts.Free, r.opts.MaxLockout.Milliseconds(),
+	))
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to count attempt: %w", err)
+	}
+	if len(reply) != 2 {
+		return time.Time{}, false, fmt.Errorf("unexpected reply counting attempt: %v", reply)
+	}
+	allowed := reply[0] == 1
+	if reply[1] <= 0 {
+		return time.Time{}, allowed, nil
+	}
+	return time.Now().Add(time.Duration(reply[1]) * time.Millisecond), allowed, nil
+}
+
+func (r *RedisAttempts) Forgive(ctx context.Context, key string) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := forgiveScript.Do(conn, failuresKey(key)); err != nil {
+		return fmt.Errorf("failed to forgive attempt: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisAttempts) Reset(ctx context.Context, key string) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", failuresKey(key), lockoutKey(key))
+	if err != nil {
+		return fmt.Errorf("failed to reset attempts: %w", err)
+	}
+	return nil
+}
+
+type memoryAttempt struct {
+	attempts    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// MemoryAttempts tracks attempts in process, for running a single instance without Redis.
+type MemoryAttempts struct {
+	mut      sync.Mutex
+	attempts map[string]*memoryAttempt
+	opts     AttemptsOptions
+	now      func() time.Time
+	calls    int
+}
+
+// NewMemoryAttempts creates an in process attempt tracker.
+func NewMemoryAttempts(opts AttemptsOptions) *MemoryAttempts {
+	opts.setDefaults()
+	return &MemoryAttempts{
+		attempts: make(map[string]*memoryAttempt),
+		opts:     opts,
+		now:      time.Now,
+	}
+}
+
+// attempt returns the key's remembered failures, forgetting them once the window's passed. Must be called with the mutex held.
+func (m *MemoryAttempts) attempt(key string) *memoryAttempt {
+	attempt, ok := m.attempts[key]
+	if ok && m.now().Sub(attempt.last) >= m.opts.Window && !m.now().Before(attempt.lockedUntil) {
+		delete(m.attempts, key)
+		return nil
+	}
+	return attempt
+}
+
+func (m *MemoryAttempts) Attempt(ctx context.Context, key string) (time.Time, bool, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.calls++
+	if m.calls%sweepEvery == 0 {
+		for k := range m.attempts {
+			m.attempt(k)
+		}
+	}
+
+	attempt := m.attempt(key)
+	if attempt == nil {
+		attempt = &memoryAttempt{}
+		m.attempts[key] = attempt
+	}
+	if m.now().Before(attempt.lockedUntil) {
+		return attempt.lockedUntil, false, nil
+	}
+	attempt.attempts++
+	attempt.last = m.now()
+	lockout := m.opts.lockout(attempt.attempts)
+	if lockout == 0 {
+		return time.Time{}, true, nil
+	}
+	attempt.lockedUntil = attempt.last.Add(lockout)
+	return attempt.lockedUntil, true, nil
+}
+
+func (m *MemoryAttempts) Forgive(ctx context.Context, key string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if attempt := m.attempt(key); attempt != nil && attempt.attempts > 0 {
+		attempt.attempts--
+	}
+	return nil
+}
+
+func (m *MemoryAttempts) Reset(ctx context.Context, key string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
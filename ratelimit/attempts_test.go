@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAttemptsLockout(t *testing.T) {
+	opts := AttemptsOptions{Free: 2, MaxLockout: time.Second * 5}
+	opts.setDefaults()
+	expected := []time.Duration{0, 0, time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5}
+	for i, lockout := range expected {
+		if got := opts.lockout(i + 1); got != lockout {
+			t.Errorf("failure %d: expected %s lockout, got %s", i+1, lockout, got)
+		}
+	}
+}
+
+func TestMemoryAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	attempts := NewMemoryAttempts(AttemptsOptions{Free: 2, Window: time.Minute})
+	attempts.now = func() time.Time { return now }
+
+	attempt := func(key string) (time.Time, bool) {
+		t.Helper()
+		until, allowed, err := attempts.Attempt(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return until, allowed
+	}
+
+	for i := 0; i < 2; i++ {
+		if until, allowed := attempt("a"); !allowed || !until.IsZero() {
+			t.Errorf("expected free attempt %d not to lock out", i+1)
+		}
+	}
+	if until, allowed := attempt("a"); !allowed || !until.Equal(now.Add(time.Second)) {
+		t.Errorf("expected a second's lockout after the attempt, got until %s", until)
+	}
+	if until, allowed := attempt("a"); allowed || !until.Equal(now.Add(time.Second)) {
+		t.Error("expected a to be refused while locked out")
+	}
+	if _, allowed := attempt("b"); !allowed {
+		t.Error("expected b not to be locked out")
+	}
+
+	// Refused attempts aren't counted towards the next lockout.
+	now = now.Add(time.Second)
+	if until, allowed := attempt("a"); !allowed || !until.Equal(now.Add(time.Second*2)) {
+		t.Errorf("expected lockout to double, got until %s", until)
+	}
+
+	// Attempts are forgotten after the window.
+	now = now.Add(time.Minute)
+	if until, allowed := attempt("a"); !allowed || !until.IsZero() {
+		t.Error("expected attempts to be forgotten after the window")
+	}
+
+	// Forgiven attempts don't count towards a lockout.
+	if err := attempts.Forgive(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	attempt("a")
+	if until, _ := attempt("a"); !until.IsZero() {
+		t.Error("expected the forgiven attempt not to count")
+	}
+
+	if err := attempts.Reset(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	attempt("a")
+	if until, _ := attempt("a"); !until.IsZero() {
+		t.Error("expected reset to forget attempts")
+	}
+}
@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned while the breaker is open and the policy is to fail closed.
+var ErrUnavailable = errors.New("rate limiting is temporarily unavailable")
+
+// Policy decides what happens to rate limit checks while the limiter backend is down.
+type Policy int
+
+const (
+	// FailOpen skips rate limiting while the backend is unavailable.
+	FailOpen Policy = iota
+	// FailClosed rejects checks with ErrUnavailable while the backend is unavailable.
+	FailClosed
+)
+
+// BreakerOptions configure a circuit breaker.
+type BreakerOptions struct {
+	// Consecutive failures before the breaker opens.
+	Threshold int
+	// How long the breaker stays open before letting a trial request through.
+	OpenFor time.Duration
+	Policy  Policy
+}
+
+/*
+Breaker is a circuit breaker guarding calls to a limiter backend.
+After Threshold consecutive failures it opens, and calls are short-circuited
+according to its Policy until OpenFor has passed. A single trial call is then let through,
+closing the breaker on success or reopening it on failure.
+*/
+type Breaker struct {
+	opts BreakerOptions
+	now  func() time.Time
+
+	mut       sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+	skipped   int
+}
+
+// NewBreaker creates a closed breaker.
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.Threshold < 1 {
+		opts.Threshold = 1
+	}
+	return &Breaker{
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// Allow returns true if a call to the backend should be attempted.
+func (b *Breaker) Allow() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.failures < b.opts.Threshold {
+		return true
+	}
+	if b.now().Before(b.openUntil) || b.trial {
+		b.skipped++
+		return false
+	}
+	// Half open, let one call through to see if the backend came back.
+	b.trial = true
+	return true
+}
+
+// Success records a successful backend call, closing the breaker.
+func (b *Breaker) Success() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.failures >= b.opts.Threshold {
+		log.Printf("rate limit backend recovered, %d checks were short-circuited", b.skipped)
+	}
+	b.failures = 0
+	b.trial = false
+	b.skipped = 0
+}
+
+// Failure records a failed backend call, opening the breaker once the threshold is hit.
+func (b *Breaker) Failure(err error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.failures >= b.opts.Threshold {
+		if b.failures == b.opts.Threshold {
+			log.Printf("rate limit backend failing, opening circuit for %s: %v", b.opts.OpenFor, err)
+		}
+		b.openUntil = b.now().Add(b.opts.OpenFor)
+	}
+}
+
+// Reject returns the result a short-circuited check should give, per the breaker's policy.
+func (b *Breaker) Reject() (bool, error) {
+	if b.opts.Policy == FailClosed {
+		return true, ErrUnavailable
+	}
+	return false, nil
+}
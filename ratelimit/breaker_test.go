@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(BreakerOptions{
+		Threshold: 3,
+		OpenFor:   time.Minute,
+		Policy:    FailOpen,
+	})
+	breaker.now = func() time.Time { return now }
+
+	failure := errors.New("down")
+	for i := 0; i < 2; i++ {
+		breaker.Failure(failure)
+		if !breaker.Allow() {
+			t.Fatalf("expected breaker closed below threshold, failure %d", i+1)
+		}
+	}
+
+	breaker.Failure(failure)
+	if breaker.Allow() {
+		t.Fatal("expected breaker open after threshold")
+	}
+
+	// Half open after the open period, one trial call only.
+	now = now.Add(time.Minute * 2)
+	if !breaker.Allow() {
+		t.Fatal("expected a trial call after open period")
+	}
+	if breaker.Allow() {
+		t.Fatal("expected only one trial call while half open")
+	}
+
+	breaker.Failure(failure)
+	if breaker.Allow() {
+		t.Fatal("expected breaker reopened after failed trial")
+	}
+
+	now = now.Add(time.Minute * 2)
+	if !breaker.Allow() {
+		t.Fatal("expected a trial call after open period")
+	}
+	breaker.Success()
+	if !breaker.Allow() || !breaker.Allow() {
+		t.Fatal("expected breaker closed after successful trial")
+	}
+}
+
+func TestBreakerPolicy(t *testing.T) {
+	tests := map[Policy]bool{
+		FailOpen:   false,
+		FailClosed: true,
+	}
+	for policy, expectLimited := range tests {
+		breaker := NewBreaker(BreakerOptions{Threshold: 1, Policy: policy})
+		limited, err := breaker.Reject()
+		if limited != expectLimited {
+			t.Errorf("policy %d: expected limited %v, got %v", policy, expectLimited, limited)
+		}
+		if policy == FailClosed && !errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected ErrUnavailable failing closed, got: %v", err)
+		}
+		if policy == FailOpen && err != nil {
+			t.Errorf("expected no error failing open, got: %v", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Number of independently locked shards keys are spread over.
+const memoryShards = 32
+
+// Calls to a shard between sweeps of its refilled buckets.
+const sweepEvery = 1024
+
+// bucket holds a key's tokens as of last, refilling one per cooldown.
+type bucket struct {
+	tokens   float64
+	last     time.Time
+	cooldown time.Duration
+}
+
+type shard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+/*
+Memory is a Limiter holding a token bucket per key in memory, for single instance deployments without Redis.
+Each key holds up to Burst tokens, refilling one per cooldown, and is limited once it has none left.
+Keys are spread over shards so unrelated keys don't contend for the same lock.
+*/
+type Memory struct {
+	burst  float64
+	now    func() time.Time
+	shards [memoryShards]shard
+}
+
+// MemoryOptions configure an in-memory limiter.
+type MemoryOptions struct {
+	// Posts allowed in a row before the cooldown applies, defaults to 1.
+	Burst int
+}
+
+// NewMemory creates an in-memory limiter.
+func NewMemory(opts MemoryOptions) *Memory {
+	m := &Memory{burst: float64(opts.Burst), now: time.Now}
+	if m.burst < 1 {
+		m.burst = 1
+	}
+	for i := range m.shards {
+		m.shards[i].buckets = make(map[string]*bucket)
+	}
+	return m
+}
+
+func (m *Memory) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &m.shards[h.Sum32()%memoryShards]
+}
+
+// refill adds the tokens earned since the bucket was last used, up to the burst.
+func (m *Memory) refill(b *bucket, now time.Time, cooldown time.Duration) {
+	b.cooldown = cooldown
+	if cooldown <= 0 {
+		b.tokens = m.burst
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(elapsed) / float64(cooldown)
+		if b.tokens > m.burst {
+			b.tokens = m.burst
+		}
+	}
+	b.last = now
+}
+
+func (m *Memory) IsRateLimited(ctx context.Context, key string, cooldown time.Duration) (bool, error) {
+	now := m.now()
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls >= sweepEvery {
+		s.calls = 0
+		m.sweep(s, now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: m.burst, last: now}
+		s.buckets[key] = b
+	}
+	m.refill(b, now, cooldown)
+	if b.tokens < 1 {
+		return true, nil
+	}
+	b.tokens--
+	return false, nil
+}
+
+func (m *Memory) Peek(ctx context.Context, key string, cooldown time.Duration) (bool, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		return false, nil
+	}
+	// Refilling a copy leaves the bucket as it was.
+	peeked := *b
+	m.refill(&peeked, m.now(), cooldown)
+	return peeked.tokens < 1, nil
+}
+
+// sweep drops buckets that have refilled, which behave the same as no bucket at all.
+func (m *Memory) sweep(s *shard, now time.Time) {
+	for key, b := range s.buckets {
+		if b.cooldown <= 0 || float64(now.Sub(b.last)) >= (m.burst-b.tokens)*float64(b.cooldown) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := NewMemory(MemoryOptions{Burst: 2})
+	limiter.now = func() time.Time { return now }
+
+	check := func(key string, expected bool) {
+		t.Helper()
+		limited, err := limiter.IsRateLimited(ctx, key, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limited != expected {
+			t.Errorf("expected %s limited %v at %s", key, expected, now)
+		}
+	}
+
+	check("a", false)
+	check("a", false)
+	check("a", true)
+	check("b", false)
+
+	// Half a cooldown only refills half a token.
+	now = now.Add(time.Second * 30)
+	check("a", true)
+	now = now.Add(time.Second * 30)
+	check("a", false)
+	check("a", true)
+
+	// Tokens don't build up past the burst.
+	now = now.Add(time.Hour)
+	check("a", false)
+	check("a", false)
+	check("a", true)
+}
+
+func TestMemoryPeek(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := NewMemory(MemoryOptions{})
+	limiter.now = func() time.Time { return now }
+
+	peek := func(expected bool) {
+		t.Helper()
+		limited, err := limiter.Peek(ctx, "a", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limited != expected {
+			t.Errorf("expected peek limited %v at %s", expected, now)
+		}
+	}
+
+	// Peeking never uses up a token.
+	peek(false)
+	peek(false)
+	if limited, _ := limiter.IsRateLimited(ctx, "a", time.Minute); limited {
+		t.Fatal("expected peeking not to start a cooldown")
+	}
+	peek(true)
+	now = now.Add(time.Minute)
+	peek(false)
+	peek(false)
+}
+
+func TestMemorySweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := NewMemory(MemoryOptions{})
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		limiter.IsRateLimited(ctx, fmt.Sprint(i), time.Minute)
+	}
+	now = now.Add(time.Minute)
+	limiter.IsRateLimited(ctx, "fresh", time.Minute)
+	total := 0
+	for i := range limiter.shards {
+		limiter.sweep(&limiter.shards[i], now)
+		total += len(limiter.shards[i].buckets)
+	}
+	if total != 1 {
+		t.Errorf("expected only the cooling down bucket left, got %d", total)
+	}
+}
+
+func TestMemoryConcurrent(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewMemory(MemoryOptions{Burst: 5})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limited, _ := limiter.IsRateLimited(ctx, "shared", time.Hour)
+			if !limited {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 5 {
+		t.Errorf("expected exactly the burst allowed, got %d", allowed)
+	}
+}
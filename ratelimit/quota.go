@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+Quota counts requests on keys in fixed windows, such as reads from an IP each minute.
+Unlike a Limiter's cooldowns, a key can make up to the limit in a row before it's held back.
+*/
+type Quota interface {
+	// Take counts a request on the key, returning how many it has left in the window and when the window ends.
+	// Remaining is negative once the key's over its limit.
+	Take(ctx context.Context, key string, limit int, window time.Duration) (remaining int, reset time.Time, err error)
+}
+
+// Count the request, starting the window on the first, and return the count with the window's time left.
+var takeScript = redis.NewScript(1, `
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// RedisQuota counts requests in Redis, shared between every instance using the same Redis.
+type RedisQuota struct {
+	pool *redis.Pool
+}
+
+// NewRedisQuota creates a quota using connections from the given pool.
+func NewRedisQuota(pool *redis.Pool) *RedisQuota {
+	return &RedisQuota{pool: pool}
+}
+
+func (r *RedisQuota) Take(ctx context.Context, key string, limit int, window time.Duration) (int, time.Time, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	values, err := redis.Int64s(takeScript.Do(conn, "quota:"+key, window.Milliseconds()))
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to count request: %w", err)
+	}
+	if len(values) != 2 {
+		return 0, time.Time{}, fmt.Errorf("unexpected quota reply %v", values)
+	}
+	ttl := time.Duration(values[1]) * time.Millisecond
+	if ttl < 0 {
+		ttl = window
+	}
+	return limit - int(values[0]), time.Now().Add(ttl), nil
+}
+
+type memoryWindow struct {
+	count int
+	reset time.Time
+}
+
+// MemoryQuota counts requests in process, for running a single instance without Redis.
+type MemoryQuota struct {
+	mut     sync.Mutex
+	windows map[string]*memoryWindow
+	now     func() time.Time
+	calls   int
+}
+
+// NewMemoryQuota creates an in process quota.
+func NewMemoryQuota() *MemoryQuota {
+	return &MemoryQuota{
+		windows: make(map[string]*memoryWindow),
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryQuota) Take(ctx context.Context, key string, limit int, window time.Duration) (int, time.Time, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	now := m.now()
+	m.calls++
+	if m.calls%sweepEvery == 0 {
+		for k, w := range m.windows {
+			if !now.Before(w.reset) {
+				delete(m.windows, k)
+			}
+		}
+	}
+
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &memoryWindow{reset: now.Add(window)}
+		m.windows[key] = w
+	}
+	w.count++
+	return limit - w.count, w.reset, nil
+}
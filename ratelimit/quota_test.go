@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryQuota(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	quota := NewMemoryQuota()
+	quota.now = func() time.Time { return now }
+
+	take := func(key string) (int, time.Time) {
+		t.Helper()
+		remaining, reset, err := quota.Take(ctx, key, 2, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return remaining, reset
+	}
+
+	if remaining, reset := take("a"); remaining != 1 || !reset.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected 1 left until the window ends, got %d until %s", remaining, reset)
+	}
+	if remaining, _ := take("a"); remaining != 0 {
+		t.Errorf("expected the limit to be reached, got %d left", remaining)
+	}
+	if remaining, _ := take("a"); remaining >= 0 {
+		t.Errorf("expected the key to be over its limit, got %d left", remaining)
+	}
+	if remaining, _ := take("b"); remaining != 1 {
+		t.Errorf("expected other keys to have their own windows, got %d left", remaining)
+	}
+
+	now = now.Add(time.Minute)
+	if remaining, _ := take("a"); remaining != 1 {
+		t.Errorf("expected a new window once the last ended, got %d left", remaining)
+	}
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Limiter tracks cooldowns on keys, such as a poster's IP.
+type Limiter interface {
+	/*
+		IsRateLimited returns true if the key is still cooling down.
+		If it isn't, a new cooldown of the given length is started for it.
+	*/
+	IsRateLimited(ctx context.Context, key string, cooldown time.Duration) (bool, error)
+	// Peek returns true if IsRateLimited would, without starting a cooldown.
+	Peek(ctx context.Context, key string, cooldown time.Duration) (bool, error)
+}
+
+// Redis is a Limiter backed by Redis keys with expiries, guarded by a circuit breaker.
+type Redis struct {
+	pool    *redis.Pool
+	breaker *Breaker
+}
+
+/*
+NewPool creates a Redis connection pool. Connections are dialled lazily, and idle connections
+are pinged before reuse so a restarted Redis is reconnected to transparently.
+*/
+func NewPool(redisURL string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: time.Minute * 5,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.DialURL(
+				redisURL,
+				redis.DialConnectTimeout(time.Second*2),
+				redis.DialReadTimeout(time.Second*2),
+				redis.DialWriteTimeout(time.Second*2),
+			)
+			return conn, err
+		},
+		TestOnBorrow: func(conn redis.Conn, idleSince time.Time) error {
+			if time.Since(idleSince) < time.Second*30 {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+}
+
+// NewRedis creates a limiter using connections from the given pool.
+func NewRedis(pool *redis.Pool, breaker *Breaker) *Redis {
+	return &Redis{
+		breaker: breaker,
+		pool:    pool,
+	}
+}
+
+func (r *Redis) IsRateLimited(ctx context.Context, key string, cooldown time.Duration) (bool, error) {
+	return r.guard(func() (bool, error) {
+		return r.setCooldown(ctx, key, cooldown)
+	})
+}
+
+func (r *Redis) Peek(ctx context.Context, key string, cooldown time.Duration) (bool, error) {
+	return r.guard(func() (bool, error) {
+		return r.hasCooldown(ctx, key)
+	})
+}
+
+// guard runs a check behind the breaker, giving the breaker's result instead while Redis is failing.
+func (r *Redis) guard(check func() (bool, error)) (bool, error) {
+	if !r.breaker.Allow() {
+		return r.breaker.Reject()
+	}
+
+	limited, err := check()
+	if err != nil {
+		r.breaker.Failure(err)
+		limited, rejectErr := r.breaker.Reject()
+		if rejectErr != nil {
+			return limited, fmt.Errorf("%w: %v", rejectErr, err)
+		}
+		return limited, nil
+	}
+	r.breaker.Success()
+	return limited, nil
+}
+
+func (r *Redis) setCooldown(ctx context.Context, key string, cooldown time.Duration) (bool, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	// SET NX only succeeds if there's no cooldown running already.
+	_, err = redis.String(
+		conn.Do("SET", "ratelimit:"+key, 1, "PX", cooldown.Milliseconds(), "NX"),
+	)
+	if errors.Is(err, redis.ErrNil) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to set cooldown: %w", err)
+	}
+	return false, nil
+}
+
+func (r *Redis) hasCooldown(ctx context.Context, key string) (bool, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer conn.Close()
+
+	running, err := redis.Bool(conn.Do("EXISTS", "ratelimit:"+key))
+	if err != nil {
+		return false, fmt.Errorf("failed to check cooldown: %w", err)
+	}
+	return running, nil
+}
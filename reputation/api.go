@@ -0,0 +1,62 @@
+package reputation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+API is a Source backed by an IP intelligence HTTP API, such as a commercial VPN detection service.
+The URL template has {ip} replaced with the IP being checked, and should include any API key.
+The response must be a JSON object, and the IP is listed if any of the given fields are true.
+*/
+type API struct {
+	urlTemplate string
+	fields      []string
+	client      *http.Client
+}
+
+// NewAPI creates a source requesting urlTemplate, listing IPs with any of fields set true.
+func NewAPI(urlTemplate string, fields []string) *API {
+	return &API{
+		urlTemplate: urlTemplate,
+		fields:      fields,
+		client:      &http.Client{Timeout: time.Second * 5},
+	}
+}
+
+func (a *API) Name() string {
+	return "api"
+}
+
+func (a *API) Listed(ctx context.Context, ip string) (bool, error) {
+	reqURL := strings.ReplaceAll(a.urlTemplate, "{ip}", url.QueryEscape(ip))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("reputation api request failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("reputation api request failed: status %d", res.StatusCode)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return false, fmt.Errorf("failed to decode reputation api response: %w", err)
+	}
+	for _, field := range a.fields {
+		if flagged, _ := body[field].(bool); flagged {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,63 @@
+package reputation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNSBL is a Source backed by a DNS blocklist zone, such as dnsbl.dronebl.org.
+type DNSBL struct {
+	zone       string
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+// NewDNSBL creates a source querying the given zone with the system resolver.
+func NewDNSBL(zone string) *DNSBL {
+	return &DNSBL{
+		zone:       strings.Trim(zone, "."),
+		lookupHost: net.DefaultResolver.LookupHost,
+	}
+}
+
+func (d *DNSBL) Name() string {
+	return d.zone
+}
+
+// Listed returns true if the IP's entry in the zone resolves.
+func (d *DNSBL) Listed(ctx context.Context, ip string) (bool, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false, fmt.Errorf("invalid ip %q", ip)
+	}
+
+	_, err := d.lookupHost(ctx, reverseIP(parsed)+"."+d.zone)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+/*
+reverseIP returns the IP in DNSBL query order, reversed octets for IPv4
+(1.2.3.4 -> 4.3.2.1), and reversed nibbles for IPv6.
+*/
+func reverseIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d", v4[3], v4[2], v4[1], v4[0])
+	}
+
+	const hex = "0123456789abcdef"
+	v6 := ip.To16()
+	nibbles := make([]string, 0, len(v6)*2)
+	for i := len(v6) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, string(hex[v6[i]&0xf]), string(hex[v6[i]>>4]))
+	}
+	return strings.Join(nibbles, ".")
+}
@@ -0,0 +1,183 @@
+package reputation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Source is a list of IPs with a bad reputation, such as a DNSBL or Tor exit list.
+type Source interface {
+	// Name identifies the source in results and logs.
+	Name() string
+	Listed(ctx context.Context, ip string) (bool, error)
+}
+
+// Result is the outcome of checking an IP against every source.
+type Result struct {
+	// Names of the sources listing the IP.
+	Sources []string
+}
+
+// Listed returns true if any source listed the IP.
+func (r Result) Listed() bool {
+	return len(r.Sources) > 0
+}
+
+// Checker looks up the reputation of IPs.
+type Checker interface {
+	Check(ctx context.Context, ip string) (Result, error)
+}
+
+/*
+Cached is a Checker querying each of its sources in turn, caching results in Redis if given a pool.
+A source failing is logged and skipped, only an error from every source fails the check.
+Results with failed sources aren't cached, so they're retried next time.
+*/
+type Cached struct {
+	sources []Source
+	pool    *redis.Pool
+	ttl     time.Duration
+}
+
+// NewCached creates a Checker over sources, caching results for ttl in Redis if pool isn't nil.
+func NewCached(sources []Source, pool *redis.Pool, ttl time.Duration) *Cached {
+	return &Cached{
+		sources: sources,
+		pool:    pool,
+		ttl:     ttl,
+	}
+}
+
+func (c *Cached) Check(ctx context.Context, ip string) (Result, error) {
+	if result, ok := c.cached(ctx, ip); ok {
+		return result, nil
+	}
+
+	var result Result
+	failed := 0
+	for _, source := range c.sources {
+		listed, err := source.Listed(ctx, ip)
+		if err != nil {
+			log.Printf("Failed to check %s against %s: %v", ip, source.Name(), err)
+			failed++
+			continue
+		}
+		if listed {
+			result.Sources = append(result.Sources, source.Name())
+		}
+	}
+	if failed > 0 && failed == len(c.sources) {
+		return Result{}, errors.New("every reputation source failed")
+	}
+	if failed == 0 {
+		c.cache(ctx, ip, result)
+	}
+	return result, nil
+}
+
+func cacheKey(ip string) string {
+	return "reputation:" + ip
+}
+
+// cached returns a cached result for ip, if there is one.
+func (c *Cached) cached(ctx context.Context, ip string) (Result, bool) {
+	if c.pool == nil {
+		return Result{}, false
+	}
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		log.Printf("Failed to get redis connection for reputation cache: %v", err)
+		return Result{}, false
+	}
+	defer conn.Close()
+
+	val, err := redis.String(conn.Do("GET", cacheKey(ip)))
+	if err != nil {
+		if !errors.Is(err, redis.ErrNil) {
+			log.Printf("Failed to read reputation cache: %v", err)
+		}
+		return Result{}, false
+	}
+	if len(val) == 0 {
+		return Result{}, true
+	}
+	return Result{Sources: strings.Split(val, ",")}, true
+}
+
+func (c *Cached) cache(ctx context.Context, ip string, result Result) {
+	if c.pool == nil || c.ttl <= 0 {
+		return
+	}
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		log.Printf("Failed to get redis connection for reputation cache: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", cacheKey(ip), strings.Join(result.Sources, ","), "PX", c.ttl.Milliseconds())
+	if err != nil {
+		log.Printf("Failed to write reputation cache: %v", err)
+	}
+}
+
+// Policy is what happens to posts from IPs with a bad reputation.
+type Policy string
+
+const (
+	// Allow posts regardless of reputation, the IP isn't checked.
+	Allow Policy = "allow"
+	// Block posts from listed IPs.
+	Block Policy = "block"
+	// RequireLogin requires listed IPs to be logged in to post.
+	RequireLogin Policy = "login"
+	// RequireCaptcha requires listed IPs to solve a captcha to post.
+	RequireCaptcha Policy = "captcha"
+)
+
+// ParsePolicy returns the policy with the given name.
+func ParsePolicy(name string) (Policy, error) {
+	switch policy := Policy(name); policy {
+	case Allow, Block, RequireLogin, RequireCaptcha:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown reputation policy %q", name)
+	}
+}
+
+// Policies map categories to the policy applied to their posts.
+type Policies struct {
+	// Default applies to categories without a policy of their own. Allow if unset.
+	Default    Policy
+	Categories map[string]Policy
+}
+
+// Uses returns true if any category, or the default, has the policy.
+func (p Policies) Uses(policy Policy) bool {
+	if p.For("") == policy {
+		return true
+	}
+	for _, categoryPolicy := range p.Categories {
+		if categoryPolicy == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// For returns the policy for posts to a category.
+func (p Policies) For(categoryTag string) Policy {
+	if policy, ok := p.Categories[categoryTag]; ok {
+		return policy
+	}
+	if len(p.Default) == 0 {
+		return Allow
+	}
+	return p.Default
+}
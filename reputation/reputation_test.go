@@ -0,0 +1,204 @@
+package reputation
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockSource struct {
+	name   string
+	listed bool
+	err    error
+	calls  int
+}
+
+func (ms *mockSource) Name() string { return ms.name }
+
+func (ms *mockSource) Listed(ctx context.Context, ip string) (bool, error) {
+	ms.calls++
+	return ms.listed, ms.err
+}
+
+func TestCheck(t *testing.T) {
+	tests := map[string]struct {
+		sources   []Source
+		expected  []string
+		expectErr bool
+	}{
+		"Clean": {
+			sources:  []Source{&mockSource{name: "a"}, &mockSource{name: "b"}},
+			expected: nil,
+		},
+		"Listed": {
+			sources:  []Source{&mockSource{name: "a", listed: true}, &mockSource{name: "b"}, &mockSource{name: "c", listed: true}},
+			expected: []string{"a", "c"},
+		},
+		"Some sources failed": {
+			sources:  []Source{&mockSource{name: "a", err: errors.New("down")}, &mockSource{name: "b", listed: true}},
+			expected: []string{"b"},
+		},
+		"All sources failed": {
+			sources:   []Source{&mockSource{name: "a", err: errors.New("down")}},
+			expectErr: true,
+		},
+	}
+
+	for testName, test := range tests {
+		checker := NewCached(test.sources, nil, time.Minute)
+		result, err := checker.Check(context.Background(), "1.2.3.4")
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", testName)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", testName, err)
+		}
+		if len(result.Sources) != len(test.expected) || result.Listed() != (len(test.expected) > 0) {
+			t.Errorf("%s: expected sources %v, got %v", testName, test.expected, result.Sources)
+			continue
+		}
+		for i := range test.expected {
+			if result.Sources[i] != test.expected[i] {
+				t.Errorf("%s: expected sources %v, got %v", testName, test.expected, result.Sources)
+			}
+		}
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	policies := Policies{Categories: map[string]Policy{"tech": Block}}
+	if policies.For("tech") != Block {
+		t.Errorf("expected category policy, got %s", policies.For("tech"))
+	}
+	if policies.For("random") != Allow {
+		t.Errorf("expected unset default to allow, got %s", policies.For("random"))
+	}
+	if !policies.Uses(Block) || !policies.Uses(Allow) || policies.Uses(RequireCaptcha) {
+		t.Error("expected block and allow policies in use")
+	}
+	policies.Default = RequireCaptcha
+	if policies.For("random") != RequireCaptcha {
+		t.Errorf("expected default policy, got %s", policies.For("random"))
+	}
+	if !policies.Uses(RequireCaptcha) || policies.Uses(Allow) {
+		t.Error("expected captcha default in use")
+	}
+
+	if _, err := ParsePolicy("login"); err != nil {
+		t.Errorf("expected login to parse, got %v", err)
+	}
+	if _, err := ParsePolicy("banish"); err == nil {
+		t.Error("expected unknown policy to fail")
+	}
+}
+
+func TestReverseIP(t *testing.T) {
+	tests := map[string]string{
+		"1.2.3.4":     "4.3.2.1",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2",
+	}
+	for ip, expected := range tests {
+		if got := reverseIP(net.ParseIP(ip)); got != expected {
+			t.Errorf("%s: expected %s, got %s", ip, expected, got)
+		}
+	}
+}
+
+func TestDNSBL(t *testing.T) {
+	var queried string
+	dnsbl := NewDNSBL("bl.example.com.")
+	dnsbl.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		queried = host
+		if host == "4.3.2.1.bl.example.com" {
+			return []string{"127.0.0.2"}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	listed, err := dnsbl.Listed(context.Background(), "1.2.3.4")
+	if err != nil || !listed {
+		t.Errorf("expected listed, got %v %v (queried %s)", listed, err, queried)
+	}
+	listed, err = dnsbl.Listed(context.Background(), "5.6.7.8")
+	if err != nil || listed {
+		t.Errorf("expected not listed, got %v %v", listed, err)
+	}
+	if _, err = dnsbl.Listed(context.Background(), "nonsense"); err == nil {
+		t.Error("expected invalid ip to fail")
+	}
+}
+
+func TestTorExits(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fetches++
+		rw.Write([]byte("# exits\n1.2.3.4\n\n5.6.7.8\n"))
+	}))
+	defer server.Close()
+
+	tor := NewTorExits(server.URL, time.Hour)
+	for _, ip := range []string{"1.2.3.4", "5.6.7.8"} {
+		listed, err := tor.Listed(context.Background(), ip)
+		if err != nil || !listed {
+			t.Errorf("expected %s listed, got %v %v", ip, listed, err)
+		}
+	}
+	listed, _ := tor.Listed(context.Background(), "9.9.9.9")
+	if listed {
+		t.Error("expected 9.9.9.9 not listed")
+	}
+	if fetches != 1 {
+		t.Errorf("expected list fetched once, got %d", fetches)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("ip") == "1.2.3.4" {
+			rw.Write([]byte(`{"vpn": true, "proxy": false}`))
+			return
+		}
+		rw.Write([]byte(`{"vpn": false, "proxy": false}`))
+	}))
+	defer server.Close()
+
+	api := NewAPI(server.URL+"?ip={ip}", []string{"proxy", "vpn"})
+	listed, err := api.Listed(context.Background(), "1.2.3.4")
+	if err != nil || !listed {
+		t.Errorf("expected listed, got %v %v", listed, err)
+	}
+	listed, err = api.Listed(context.Background(), "5.6.7.8")
+	if err != nil || listed {
+		t.Errorf("expected not listed, got %v %v", listed, err)
+	}
+}
+
+func TestSiteVerify(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		if req.PostForm.Get("secret") != "secret" || req.PostForm.Get("remoteip") != "1.2.3.4" {
+			t.Errorf("unexpected verification form %v", req.PostForm)
+		}
+		if req.PostForm.Get("response") == "good" {
+			rw.Write([]byte(`{"success": true}`))
+			return
+		}
+		rw.Write([]byte(`{"success": false}`))
+	}))
+	defer server.Close()
+
+	captcha := NewSiteVerify(server.URL, "secret")
+	if ok, err := captcha.Verify(context.Background(), "good", "1.2.3.4"); err != nil || !ok {
+		t.Errorf("expected good token verified, got %v %v", ok, err)
+	}
+	if ok, err := captcha.Verify(context.Background(), "bad", "1.2.3.4"); err != nil || ok {
+		t.Errorf("expected bad token rejected, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,91 @@
+package reputation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TorExitListURL is the Tor Project's list of current exit node IPs.
+const TorExitListURL = "https://check.torproject.org/torbulkexitlist"
+
+/*
+TorExits is a Source listing Tor exit nodes, downloaded from a plain text list of IPs.
+The list is refetched once it's older than its refresh interval; if that fails the old list keeps being used.
+*/
+type TorExits struct {
+	listURL      string
+	refreshEvery time.Duration
+	client       *http.Client
+
+	mut       sync.Mutex
+	exits     map[string]struct{}
+	fetchedAt time.Time
+}
+
+// NewTorExits creates a source for the exit list at listURL, refetched every refreshEvery.
+func NewTorExits(listURL string, refreshEvery time.Duration) *TorExits {
+	return &TorExits{
+		listURL:      listURL,
+		refreshEvery: refreshEvery,
+		client:       &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (t *TorExits) Name() string {
+	return "tor"
+}
+
+func (t *TorExits) Listed(ctx context.Context, ip string) (bool, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if time.Since(t.fetchedAt) > t.refreshEvery {
+		exits, err := t.fetch(ctx)
+		if err != nil {
+			if t.exits == nil {
+				return false, err
+			}
+			log.Printf("Failed to refresh Tor exit list, using the previous one: %v", err)
+		} else {
+			t.exits = exits
+			t.fetchedAt = time.Now()
+		}
+	}
+	_, listed := t.exits[ip]
+	return listed, nil
+}
+
+func (t *TorExits) fetch(ctx context.Context) (map[string]struct{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tor exit list: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch tor exit list: status %d", res.StatusCode)
+	}
+
+	exits := make(map[string]struct{})
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exits[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tor exit list: %w", err)
+	}
+	return exits, nil
+}
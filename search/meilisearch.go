@@ -0,0 +1,139 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"spiritchat/data"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time between checks on a queued task, and the longest a task's waited on.
+const (
+	taskPollInterval = time.Millisecond * 50
+	taskTimeout      = time.Second * 30
+)
+
+// Meilisearch is a Backend storing posts in a Meilisearch index.
+type Meilisearch struct {
+	client *client
+	index  string
+}
+
+// NewMeilisearch creates a backend for the Meilisearch instance at baseURL, using the given API key if any.
+func NewMeilisearch(baseURL string, apiKey string, index string) *Meilisearch {
+	headers := http.Header{}
+	if len(apiKey) > 0 {
+		headers.Set("Authorization", "Bearer "+apiKey)
+	}
+	return &Meilisearch{
+		client: newClient(strings.TrimRight(baseURL, "/"), headers),
+		index:  url.PathEscape(index),
+	}
+}
+
+func (m *Meilisearch) EnsureIndex(ctx context.Context) error {
+	err := m.client.do(ctx, http.MethodPost, "/indexes", map[string]string{
+		"uid":        m.index,
+		"primaryKey": "id",
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create meilisearch index: %w", err)
+	}
+
+	var queued task
+	err = m.client.do(ctx, http.MethodPatch, "/indexes/"+m.index+"/settings", map[string]interface{}{
+		"searchableAttributes": []string{"subject", "content"},
+		// Removing a thread filters on its number along with its replies' parent.
+		"filterableAttributes": []string{"cat", "num", "parent"},
+		"sortableAttributes":   []string{"createdAt"},
+	}, &queued)
+	if err != nil {
+		return fmt.Errorf("failed to update meilisearch index settings: %w", err)
+	}
+	if err := m.waitForTask(ctx, queued.UID); err != nil {
+		return fmt.Errorf("failed to update meilisearch index settings: %w", err)
+	}
+	return nil
+}
+
+func (m *Meilisearch) Index(ctx context.Context, post *data.Post) error {
+	return m.client.do(ctx, http.MethodPost, "/indexes/"+m.index+"/documents", []document{newDocument(post)}, nil)
+}
+
+// Remove waits for the posts to be deleted, since Meilisearch only queues it and a bad filter fails the task.
+func (m *Meilisearch) Remove(ctx context.Context, categoryTag string, num int) error {
+	var queued task
+	err := m.client.do(ctx, http.MethodPost, "/indexes/"+m.index+"/documents/delete", map[string]string{
+		"filter": fmt.Sprintf("cat = %s AND (num = %d OR parent = %d)", strconv.Quote(categoryTag), num, num),
+	}, &queued)
+	if err != nil {
+		return err
+	}
+	return m.waitForTask(ctx, queued.UID)
+}
+
+func (m *Meilisearch) Search(ctx context.Context, query string, categoryTag string, limit int) ([]*data.Post, error) {
+	body := map[string]interface{}{
+		"q":     query,
+		"limit": limit,
+	}
+	if len(categoryTag) > 0 {
+		body["filter"] = "cat = " + strconv.Quote(categoryTag)
+	}
+
+	var res struct {
+		Hits []document `json:"hits"`
+	}
+	err := m.client.do(ctx, http.MethodPost, "/indexes/"+m.index+"/search", body, &res)
+	if err != nil {
+		return nil, fmt.Errorf("meilisearch query failed: %w", err)
+	}
+
+	posts := make([]*data.Post, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		posts = append(posts, hit.post())
+	}
+	return posts, nil
+}
+
+// task is an operation Meilisearch has queued rather than done.
+type task struct {
+	UID int `json:"taskUid"`
+}
+
+// waitForTask polls a queued task until it's finished, returning an error if it didn't succeed.
+func (m *Meilisearch) waitForTask(ctx context.Context, uid int) error {
+	ctx, cancel := context.WithTimeout(ctx, taskTimeout)
+	defer cancel()
+	for {
+		var status struct {
+			Status string `json:"status"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		err := m.client.do(ctx, http.MethodGet, "/tasks/"+strconv.Itoa(uid), nil, &status)
+		if err != nil {
+			return fmt.Errorf("failed to check meilisearch task %d: %w", uid, err)
+		}
+		switch status.Status {
+		case "succeeded":
+			return nil
+		case "failed", "canceled":
+			if status.Error != nil {
+				return fmt.Errorf("meilisearch task %d %s: %s", uid, status.Status, status.Error.Message)
+			}
+			return fmt.Errorf("meilisearch task %d %s", uid, status.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("meilisearch task %d still %s: %w", uid, status.Status, ctx.Err())
+		case <-time.After(taskPollInterval):
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"spiritchat/data"
+	"strings"
+)
+
+// OpenSearch is a Backend storing posts in an OpenSearch (or Elasticsearch) index.
+type OpenSearch struct {
+	client *client
+	index  string
+}
+
+// NewOpenSearch creates a backend for the cluster at baseURL, using basic auth if a username is given.
+func NewOpenSearch(baseURL string, username string, password string, index string) *OpenSearch {
+	headers := http.Header{}
+	if len(username) > 0 {
+		req := http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+	return &OpenSearch{
+		client: newClient(strings.TrimRight(baseURL, "/"), headers),
+		index:  url.PathEscape(index),
+	}
+}
+
+func (o *OpenSearch) EnsureIndex(ctx context.Context) error {
+	// Exact matches on category and parent, everything else analyzed as text.
+	err := o.client.do(ctx, http.MethodPut, "/"+o.index, map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"id":        map[string]string{"type": "keyword"},
+				"cat":       map[string]string{"type": "keyword"},
+				"num":       map[string]string{"type": "integer"},
+				"parent":    map[string]string{"type": "integer"},
+				"subject":   map[string]string{"type": "text"},
+				"content":   map[string]string{"type": "text"},
+				"username":  map[string]string{"type": "keyword"},
+				"createdAt": map[string]string{"type": "long"},
+			},
+		},
+	}, nil, http.StatusBadRequest) // 400 when the index already exists
+	if err != nil {
+		return fmt.Errorf("failed to create opensearch index: %w", err)
+	}
+	return nil
+}
+
+func (o *OpenSearch) Index(ctx context.Context, post *data.Post) error {
+	doc := newDocument(post)
+	return o.client.do(ctx, http.MethodPut, "/"+o.index+"/_doc/"+url.PathEscape(doc.ID), doc, nil)
+}
+
+func (o *OpenSearch) Remove(ctx context.Context, categoryTag string, num int) error {
+	return o.client.do(ctx, http.MethodPost, "/"+o.index+"/_delete_by_query", map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"cat": categoryTag}},
+				},
+				"should": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"num": num}},
+					map[string]interface{}{"term": map[string]interface{}{"parent": num}},
+				},
+				"minimum_should_match": 1,
+			},
+		},
+	}, nil)
+}
+
+func (o *OpenSearch) Search(ctx context.Context, query string, categoryTag string, limit int) ([]*data.Post, error) {
+	boolQuery := map[string]interface{}{
+		"must": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":     query,
+				"fields":    []string{"subject^2", "content"},
+				"fuzziness": "AUTO",
+			},
+		},
+	}
+	if len(categoryTag) > 0 {
+		boolQuery["filter"] = []interface{}{
+			map[string]interface{}{"term": map[string]interface{}{"cat": categoryTag}},
+		}
+	}
+
+	var res struct {
+		Hits struct {
+			Hits []struct {
+				Source document `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	err := o.client.do(ctx, http.MethodPost, "/"+o.index+"/_search", map[string]interface{}{
+		"size":  limit,
+		"query": map[string]interface{}{"bool": boolQuery},
+	}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("opensearch query failed: %w", err)
+	}
+
+	posts := make([]*data.Post, 0, len(res.Hits.Hits))
+	for _, hit := range res.Hits.Hits {
+		posts = append(posts, hit.Source.post())
+	}
+	return posts, nil
+}
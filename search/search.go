@@ -0,0 +1,166 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/events"
+	"spiritchat/validation"
+	"time"
+)
+
+/*
+Backend is an external search engine holding a copy of posts.
+Posts are indexed as they're written, so it only knows about posts
+made since it was configured.
+*/
+type Backend interface {
+	// EnsureIndex creates or updates the index settings the backend relies on.
+	EnsureIndex(ctx context.Context) error
+
+	// Index adds or replaces a post in the index.
+	Index(ctx context.Context, post *data.Post) error
+
+	// Remove removes a post from the index, along with its replies if it's a thread.
+	Remove(ctx context.Context, categoryTag string, num int) error
+
+	/*
+		Search returns up to limit posts matching the query, best matches first.
+		An empty categoryTag searches every category.
+	*/
+	Search(ctx context.Context, query string, categoryTag string, limit int) ([]*data.Post, error)
+}
+
+/*
+Subscribe keeps the backend's index in sync with post lifecycle events published on the bus.
+Anonymized posts are indexed again, replacing their documents with ones without their usernames.
+*/
+func Subscribe(bus *events.Bus, backend Backend) {
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		if event.Post == nil {
+			return
+		}
+		if err := backend.Index(ctx, event.Post); err != nil {
+			log.Printf("failed to index post %s/%d: %v", event.Category, event.Num, err)
+		}
+	}, events.PostCreated, events.PostAnonymized)
+
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		if err := backend.Remove(ctx, event.Category, event.Num); err != nil {
+			log.Printf("failed to remove post %s/%d from index: %v", event.Category, event.Num, err)
+		}
+	}, events.PostDeleted)
+}
+
+// document is the shape posts are stored as in search engines.
+type document struct {
+	ID        string `json:"id"`
+	Num       int    `json:"num"`
+	Cat       string `json:"cat"`
+	Parent    int    `json:"parent"`
+	Subject   string `json:"subject"`
+	Content   string `json:"content"`
+	Username  string `json:"username"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+func documentID(categoryTag string, num int) string {
+	return fmt.Sprintf("%s-%d", categoryTag, num)
+}
+
+func newDocument(post *data.Post) document {
+	return document{
+		ID:        documentID(post.Cat, post.Num),
+		Num:       post.Num,
+		Cat:       post.Cat,
+		Parent:    post.Parent,
+		Subject:   post.Subject,
+		Content:   post.Content,
+		Username:  post.Username,
+		CreatedAt: post.CreatedAt.Unix(),
+	}
+}
+
+func (doc document) post() *data.Post {
+	return &data.Post{
+		Num:       doc.Num,
+		Cat:       doc.Cat,
+		Parent:    doc.Parent,
+		Subject:   doc.Subject,
+		Content:   doc.Content,
+		Username:  doc.Username,
+		CreatedAt: time.Unix(doc.CreatedAt, 0).UTC(),
+		// Indexed to the second, so this is exact.
+		CreatedAtUnix: doc.CreatedAt,
+		Links:         validation.FindLinks(doc.Content),
+	}
+}
+
+// client makes JSON requests against a search engine's HTTP API.
+type client struct {
+	http    *http.Client
+	baseURL string
+	headers http.Header
+}
+
+func newClient(baseURL string, headers http.Header) *client {
+	return &client{
+		http:    &http.Client{Timeout: time.Second * 5},
+		baseURL: baseURL,
+		headers: headers,
+	}
+}
+
+/*
+do sends body as JSON and decodes the response into out if it isn't nil.
+Responses with a status in ignore aren't treated as errors.
+*/
+func (c *client) do(ctx context.Context, method string, path string, body interface{}, out interface{}, ignore ...int) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.headers {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		for _, status := range ignore {
+			if res.StatusCode == status {
+				return nil
+			}
+		}
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s %s: %d %s", method, path, res.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeilisearchSearch(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/indexes/posts/search" {
+			t.Errorf("unexpected path %s", req.URL.Path)
+		}
+		if req.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("expected api key header, got %s", req.Header.Get("Authorization"))
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		rw.Write([]byte(`{"hits": [{"id": "cat-2", "num": 2, "cat": "cat", "parent": 1, "content": "hello", "createdAt": 100}]}`))
+	}))
+	defer server.Close()
+
+	backend := NewMeilisearch(server.URL+"/", "key", "posts")
+	posts, err := backend.Search(context.Background(), "helo", "cat", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["q"] != "helo" || body["filter"] != `cat = "cat"` {
+		t.Errorf("unexpected search request %+v", body)
+	}
+	if len(posts) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(posts))
+	}
+	if posts[0].Num != 2 || posts[0].Parent != 1 || posts[0].Content != "hello" || posts[0].CreatedAt.Unix() != 100 {
+		t.Errorf("unexpected post %+v", posts[0])
+	}
+}
+
+func TestMeilisearchRemove(t *testing.T) {
+	var body map[string]string
+	statuses := []string{"enqueued", "processing", "failed"}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/indexes/posts/documents/delete":
+			json.NewDecoder(req.Body).Decode(&body)
+			rw.WriteHeader(http.StatusAccepted)
+			rw.Write([]byte(`{"taskUid": 7, "status": "enqueued"}`))
+		case "/tasks/7":
+			status := statuses[0]
+			if len(statuses) > 1 {
+				statuses = statuses[1:]
+			}
+			rw.Write([]byte(`{"uid": 7, "status": "` + status + `", "error": {"message": "attribute num is not filterable"}}`))
+		default:
+			t.Errorf("unexpected path %s", req.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	backend := NewMeilisearch(server.URL, "", "posts")
+	err := backend.Remove(context.Background(), "cat", 3)
+	if err == nil || !strings.Contains(err.Error(), "not filterable") {
+		t.Errorf("expected the failed task's error, got: %v", err)
+	}
+	if body["filter"] != `cat = "cat" AND (num = 3 OR parent = 3)` {
+		t.Errorf("unexpected delete request %+v", body)
+	}
+
+	statuses = []string{"processing", "succeeded"}
+	if err := backend.Remove(context.Background(), "cat", 3); err != nil {
+		t.Errorf("expected removal once the task succeeds, got: %v", err)
+	}
+}
+
+func TestOpenSearchSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/posts/_search" {
+			t.Errorf("unexpected path %s", req.URL.Path)
+		}
+		if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			t.Error("expected basic auth credentials")
+		}
+		rw.Write([]byte(`{"hits": {"hits": [{"_source": {"id": "cat-1", "num": 1, "cat": "cat", "subject": "hi"}}]}}`))
+	}))
+	defer server.Close()
+
+	backend := NewOpenSearch(server.URL, "user", "pass", "posts")
+	posts, err := backend.Search(context.Background(), "hi", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].Subject != "hi" {
+		t.Errorf("unexpected posts %+v", posts)
+	}
+}
+
+func TestBackendErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	backends := map[string]Backend{
+		"meilisearch": NewMeilisearch(server.URL, "", "posts"),
+		"opensearch":  NewOpenSearch(server.URL, "", "", "posts"),
+	}
+	for name, backend := range backends {
+		_, err := backend.Search(context.Background(), "hi", "", 10)
+		if err == nil {
+			t.Errorf("%s: expected error on failed request", name)
+		}
+	}
+}
+
+type mockBackend struct {
+	indexed []*data.Post
+	removed []int
+}
+
+func (mb *mockBackend) EnsureIndex(ctx context.Context) error { return nil }
+
+func (mb *mockBackend) Index(ctx context.Context, post *data.Post) error {
+	mb.indexed = append(mb.indexed, post)
+	return nil
+}
+
+func (mb *mockBackend) Remove(ctx context.Context, categoryTag string, num int) error {
+	mb.removed = append(mb.removed, num)
+	return nil
+}
+
+func (mb *mockBackend) Search(ctx context.Context, query string, categoryTag string, limit int) ([]*data.Post, error) {
+	return nil, nil
+}
+
+func TestSubscribe(t *testing.T) {
+	bus := events.NewBus()
+	backend := &mockBackend{}
+	Subscribe(bus, backend)
+
+	bus.Publish(events.Event{
+		Kind:     events.PostCreated,
+		Category: "cat",
+		Num:      3,
+		Post:     &data.Post{Num: 3, Cat: "cat", CreatedAt: time.Now()},
+	})
+	bus.Wait()
+	bus.Publish(events.Event{Kind: events.PostDeleted, Category: "cat", Num: 3})
+	bus.Wait()
+
+	if len(backend.indexed) != 1 || backend.indexed[0].Num != 3 {
+		t.Errorf("expected post 3 indexed, got %+v", backend.indexed)
+	}
+	if len(backend.removed) != 1 || backend.removed[0] != 3 {
+		t.Errorf("expected post 3 removed, got %+v", backend.removed)
+	}
+}
@@ -0,0 +1,95 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strconv"
+	"time"
+)
+
+// Cooldown between complaints from the same IP.
+const complaintCooldown = time.Minute
+
+// complaintFiled tells the complainant what happened to their complaint.
+type complaintFiled struct {
+	ID int `json:"id"`
+	// How many of the posts still exist, and were put in front of moderators.
+	Reported int `json:"reported"`
+}
+
+/*
+handleFileComplaint handles a POST request from anyone filing an abuse complaint or takedown notice against posts.
+Posts that still exist are put at the top of the moderation queue, and the abuse contact is emailed if one's set.
+*/
+func (server *Server) handleFileComplaint(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingComplaint(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	if server.limiter != nil {
+		limited, err := server.limiter.IsRateLimited(ctx, "abuse:"+req.ip, complaintCooldown)
+		if err != nil {
+			res.Respond(http.StatusServiceUnavailable, nil, genericFailMessage)
+			log.Printf("Failed to check complaint rate limit: %s", err)
+			return
+		}
+		if limited {
+			res.Respond(http.StatusTooManyRequests, nil, "please wait before filing another complaint")
+			return
+		}
+	}
+
+	complaint := &data.Complaint{
+		Kind:         data.ComplaintKind(incoming.Kind),
+		Name:         incoming.Name,
+		Email:        incoming.Email,
+		Details:      incoming.Details,
+		Work:         incoming.Work,
+		Signature:    incoming.Signature,
+		Posts:        incoming.Posts,
+		ReporterHash: data.PosterHash(req.ip),
+	}
+	reported, err := server.store.WriteComplaint(ctx, complaint)
+	if err != nil {
+		res.Fail("Failed to save complaint", err)
+		return
+	}
+	log.Printf("Complaint #%d (%s) filed against %d posts, %d still up", complaint.ID, complaint.Kind, len(complaint.Posts), reported)
+
+	server.events.Publish(events.Event{
+		Kind:      events.ComplaintFiled,
+		Complaint: complaint,
+	})
+	res.Respond(http.StatusCreated, complaintFiled{ID: complaint.ID, Reported: reported}, "")
+}
+
+// handleGetComplaint handles a GET request from a moderator for a complaint, referenced by the reports filed from it.
+func (server *Server) handleGetComplaint(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil || id < 1 {
+		res.Respond(http.StatusBadRequest, nil, "invalid complaint ID")
+		return
+	}
+
+	complaint, err := server.store.GetComplaint(ctx, id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to get complaint", err)
+		return
+	}
+	res.Respond(http.StatusOK, complaint, "")
+}
@@ -0,0 +1,90 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/data"
+	"spiritchat/events"
+	"testing"
+)
+
+func TestFileComplaint(t *testing.T) {
+	mockStore := &MockStore{}
+	limiter := &MockLimiter{}
+	bus := events.NewBus()
+	var filed []*data.Complaint
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		filed = append(filed, event.Complaint)
+	}, events.ComplaintFiled)
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{RateLimiter: limiter, Events: bus})
+
+	client := newTestClient(server, mockAuth).as(nil)
+	do := func(body string) *httptest.ResponseRecorder {
+		rr := client.do("POST", "/v1/abuse", body)
+		bus.Wait()
+		return rr
+	}
+
+	for _, body := range []string{
+		`{"kind": "spam", "name": "Owl", "email": "owl@example.com", "posts": [{"cat": "tech", "num": 1}], "details": "this post has my address"}`,
+		`{"kind": "abuse", "name": "Owl", "email": "owl@example.com", "posts": [], "details": "this post has my address"}`,
+		`{"kind": "abuse", "name": "Owl", "email": "owl", "posts": [{"cat": "tech", "num": 1}], "details": "this post has my address"}`,
+		`{"kind": "abuse", "name": "", "email": "owl@example.com", "posts": [{"cat": "tech", "num": 1}], "details": "this post has my address"}`,
+		`{"kind": "dmca", "name": "Owl", "email": "owl@example.com", "posts": [{"cat": "tech", "num": 1}], "details": "this post copies my book",
+			"work": "My book, chapter one", "signature": "Owl", "goodFaith": true}`,
+	} {
+		if rr := do(body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected a bad request, got: %d", body, rr.Code)
+		}
+	}
+	if len(mockStore.complaints) > 0 {
+		t.Fatal("expected invalid complaints not to be saved")
+	}
+
+	rr := do(`{"kind": "dmca", "name": "Owl", "email": "owl@example.com", "posts": [{"cat": "tech", "num": 1}, {"cat": "art", "num": 4}],
+		"details": "these posts copy my book", "work": "My book, chapter one", "signature": "Owl", "goodFaith": true, "accurate": true}`)
+	if rr.Code != http.StatusCreated || len(mockStore.complaints) != 1 {
+		t.Fatalf("expected the takedown notice to be filed, got: %d", rr.Code)
+	}
+	complaint := mockStore.complaints[0]
+	if complaint.Kind != data.ComplaintDMCA || len(complaint.Posts) != 2 || len(complaint.ReporterHash) == 0 {
+		t.Errorf("expected a takedown notice on 2 posts with the reporter's hash, got: %+v", complaint)
+	}
+	if len(filed) != 1 || filed[0] != complaint {
+		t.Errorf("expected the complaint to be published, got: %v", filed)
+	}
+
+	limiter.limited = true
+	rr = do(`{"kind": "abuse", "name": "Owl", "email": "owl@example.com", "posts": [{"cat": "tech", "num": 1}], "details": "this post has my address"}`)
+	if rr.Code != http.StatusTooManyRequests || len(mockStore.complaints) != 1 {
+		t.Errorf("expected rate limited complaints to be refused, got: %d", rr.Code)
+	}
+}
+
+func TestGetComplaint(t *testing.T) {
+	mockStore := &MockStore{complaints: []*data.Complaint{{ID: 1, Kind: data.ComplaintAbuse}}}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	mockAuth.user = moderator("mod@gmail.com")
+	if code := client.do("GET", "/v1/admin/complaints/1", "").Code; code != http.StatusBadRequest {
+		t.Errorf("expected complaints refused without a reason, got: %d", code)
+	}
+	if code := client.do("GET", "/v1/admin/complaints/1?reason=reviewing+complaint", "").Code; code != http.StatusOK {
+		t.Errorf("expected moderators to see complaints, got: %d", code)
+	}
+	if code := client.do("GET", "/v1/admin/complaints/2?reason=reviewing+complaint", "").Code; code != http.StatusNotFound {
+		t.Errorf("expected a missing complaint to 404, got: %d", code)
+	}
+	mockAuth.user.Roles = nil
+	if code := client.do("GET", "/v1/admin/complaints/1", "").Code; code != http.StatusForbidden {
+		t.Errorf("expected users not to see complaints, got: %d", code)
+	}
+	if len(mockStore.piiAccesses) != 2 || mockStore.piiAccesses[0].Reason != "reviewing complaint" {
+		t.Errorf("expected complaints viewed with a reason to be logged, got %+v", mockStore.piiAccesses)
+	}
+}
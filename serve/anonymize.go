@@ -0,0 +1,68 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"strconv"
+)
+
+// Anonymization jobs listed at once.
+const maxAnonymizeJobs = 50
+
+/*
+handleQueueAnonymization handles a POST request from an admin queuing a job that strips the usernames and emails
+from a category's posts made before a time, for categories going anonymous. It's worked through in the background.
+*/
+func (server *Server) handleQueueAnonymization(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingAnonymization(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	job, err := server.store.QueueAnonymization(ctx, req.params.ByName("cat"), incoming.Before, req.user.Email)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such category")
+			return
+		}
+		res.Fail("Failed to queue anonymization", err)
+		return
+	}
+	log.Printf(
+		"Anonymization of %d posts on %s before %s queued by %s",
+		job.Total, job.Cat, job.Before.Format("2006-01-02 15:04:05"), req.user.Email,
+	)
+	res.Respond(http.StatusAccepted, job, "")
+}
+
+// handleGetAnonymizeJobs handles a GET request from an admin for the latest anonymization jobs and their progress.
+func (server *Server) handleGetAnonymizeJobs(ctx context.Context, req *request, res *response) {
+	jobs, err := server.store.GetAnonymizeJobs(ctx, maxAnonymizeJobs)
+	if err != nil {
+		res.Fail("Failed to get anonymization jobs", err)
+		return
+	}
+	res.Respond(http.StatusOK, jobs, "")
+}
+
+// handleGetAnonymizeJob handles a GET request from an admin for an anonymization job's progress.
+func (server *Server) handleGetAnonymizeJob(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid job ID")
+		return
+	}
+	job, err := server.store.GetAnonymizeJob(ctx, id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such job")
+			return
+		}
+		res.Fail("Failed to get anonymization job", err)
+		return
+	}
+	res.Respond(http.StatusOK, job, "")
+}
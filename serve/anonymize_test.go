@@ -0,0 +1,63 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"strings"
+	"testing"
+)
+
+func TestAnonymization(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: admin}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	queue := func(body string) *httptest.ResponseRecorder {
+		return serveConfirmed(t, server, func() *http.Request {
+			req := httptest.NewRequest("POST", "/v1/admin/categories/tech/anonymize", strings.NewReader(body))
+			req.Header.Add("Authorization", "ok")
+			return req
+		})
+	}
+	client := newTestClient(server, mockAuth)
+
+	mockAuth.user = moderator("mod@gmail.com")
+	if rr := queue(`{"before": "2024-01-01T00:00:00Z"}`); rr.Code != http.StatusForbidden || len(mockStore.anonymizeJobs) != 0 {
+		t.Errorf("expected moderators not to anonymize categories, got: %d", rr.Code)
+	}
+	mockAuth.user = admin
+	if rr := queue(`{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected anonymizing without a time to be refused, got: %d", rr.Code)
+	}
+
+	rr := queue(`{"before": "2024-01-01T00:00:00Z"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected the job queued, got: %d", rr.Code)
+	}
+	var job data.AnonymizeJob
+	if err := json.NewDecoder(rr.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Cat != "tech" || job.Before.Year() != 2024 || job.QueuedBy != "admin@gmail.com" || job.Status != data.JobQueued {
+		t.Errorf("expected a queued job for the category, got %+v", job)
+	}
+
+	rr = client.do("GET", "/v1/admin/anonymize-jobs", "")
+	var jobs []*data.AnonymizeJob
+	if err := json.NewDecoder(rr.Body).Decode(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("expected the job listed, got: %d %+v", rr.Code, jobs)
+	}
+	if rr := client.do("GET", "/v1/admin/anonymize-jobs/1", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the job's progress, got: %d", rr.Code)
+	}
+	if rr := client.do("GET", "/v1/admin/anonymize-jobs/2", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a missing job to 404, got: %d", rr.Code)
+	}
+}
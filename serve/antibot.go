@@ -0,0 +1,159 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max request body read when checking the honeypot, anything bigger is left for the handler to reject.
+const maxAntibotBody = 1 << 16
+
+// AntibotOptions configure heuristics scoring posts from likely bots.
+type AntibotOptions struct {
+	// Honeypot is a JSON field real clients never fill in, e.g. an input hidden with CSS. Empty disables it.
+	Honeypot string
+	// MinReplyDelay is the least time expected between viewing a thread or category and posting to it.
+	MinReplyDelay time.Duration
+	// MaxSpamScore is the highest spam score a post can have before it's rejected. Zero disables antibot checks.
+	MaxSpamScore int
+}
+
+// Points added to a request's spam score by each antibot check.
+const (
+	spamHoneypot         = 10
+	spamNoUserAgent      = 3
+	spamNoAcceptLanguage = 1
+	spamNoAccept         = 1
+	spamUnviewed         = 1
+	spamImpossibleTiming = 5
+)
+
+const viewTrackerTTL = time.Hour
+const viewTrackerPruneEvery = time.Minute
+
+// viewTracker remembers when IPs last viewed threads and categories.
+type viewTracker struct {
+	mut        sync.Mutex
+	views      map[string]time.Time
+	lastPruned time.Time
+}
+
+func newViewTracker() *viewTracker {
+	return &viewTracker{
+		views:      make(map[string]time.Time),
+		lastPruned: time.Now(),
+	}
+}
+
+func viewKey(ip string, categoryTag string, threadNumber int) string {
+	return fmt.Sprintf("%s|%s|%d", ip, categoryTag, threadNumber)
+}
+
+// record notes that ip viewed a thread, or category if threadNumber is 0.
+func (vt *viewTracker) record(ip string, categoryTag string, threadNumber int) {
+	vt.mut.Lock()
+	defer vt.mut.Unlock()
+
+	now := time.Now()
+	vt.views[viewKey(ip, categoryTag, threadNumber)] = now
+	if now.Sub(vt.lastPruned) > viewTrackerPruneEvery {
+		for key, at := range vt.views {
+			if now.Sub(at) > viewTrackerTTL {
+				delete(vt.views, key)
+			}
+		}
+		vt.lastPruned = now
+	}
+}
+
+// lastViewed returns when ip last viewed a thread or category, if it did within the tracker's TTL.
+func (vt *viewTracker) lastViewed(ip string, categoryTag string, threadNumber int) (time.Time, bool) {
+	vt.mut.Lock()
+	defer vt.mut.Unlock()
+	at, ok := vt.views[viewKey(ip, categoryTag, threadNumber)]
+	if !ok || time.Since(at) > viewTrackerTTL {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// honeypotFilled returns true if the request body is JSON with the honeypot field set, restoring the body after.
+func honeypotFilled(req *request, field string) bool {
+	if req.rawRequest.Body == nil {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(req.rawRequest.Body, maxAntibotBody))
+	req.rawRequest.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.rawRequest.Body))
+	if err != nil {
+		return false
+	}
+
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) != nil {
+		return false
+	}
+	val, ok := fields[field]
+	if !ok || val == nil {
+		return false
+	}
+	if str, isString := val.(string); isString && len(strings.TrimSpace(str)) == 0 {
+		return false
+	}
+	return true
+}
+
+/*
+middlewareAntibot scores a post request on how bot-like it looks, rejecting it if the score's too high.
+Checks the honeypot field, missing browser headers, and how soon after viewing the thread the post came.
+*/
+func (s *Server) middlewareAntibot(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		if s.antibot.MaxSpamScore < 1 {
+			next(ctx, req, res)
+			return
+		}
+
+		var reasons []string
+		score := func(points int, reason string) {
+			req.spamScore += points
+			reasons = append(reasons, reason)
+		}
+
+		if len(s.antibot.Honeypot) > 0 && honeypotFilled(req, s.antibot.Honeypot) {
+			score(spamHoneypot, "honeypot filled")
+		}
+		if len(req.header.Get("User-Agent")) == 0 {
+			score(spamNoUserAgent, "no user agent")
+		}
+		if len(req.header.Get("Accept-Language")) == 0 {
+			score(spamNoAcceptLanguage, "no accept language")
+		}
+		if len(req.header.Get("Accept")) == 0 {
+			score(spamNoAccept, "no accept")
+		}
+
+		if params, err := getReplyParameters(req); err == nil {
+			viewed, ok := s.threadViews.lastViewed(req.ip, params.categoryTag, params.threadNumber)
+			if !ok {
+				score(spamUnviewed, "posted without viewing")
+			} else if time.Since(viewed) < s.antibot.MinReplyDelay {
+				score(spamImpossibleTiming, "posted too soon after viewing")
+			}
+		}
+
+		if req.spamScore > s.antibot.MaxSpamScore {
+			log.Printf("Rejected likely bot post from %s, score %d: %s", req.ip, req.spamScore, strings.Join(reasons, ", "))
+			res.Respond(http.StatusBadRequest, nil, "your post looks like spam, please try again")
+			return
+		}
+		next(ctx, req, res)
+	}
+}
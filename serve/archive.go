@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"spiritchat/data"
+	"strconv"
+)
+
+// Archived threads listed per page.
+const archivePageThreads = 50
+
+/*
+handleGetArchive handles a GET request for a page of the threads archived from a category, numbered from one,
+shaped like a live category view.
+*/
+func (server *Server) handleGetArchive(ctx context.Context, req *request, res *response) {
+	page := 1
+	if raw := req.rawRequest.URL.Query().Get("page"); len(raw) > 0 {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			res.Respond(http.StatusBadRequest, nil, "page must be a number from 1")
+			return
+		}
+		page = parsed
+	}
+	view, err := server.store.GetArchivedCategoryView(
+		ctx, req.params.ByName("cat"), (page-1)*archivePageThreads, archivePageThreads,
+	)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to get archived category view", err)
+		return
+	}
+	res.Respond(http.StatusOK, view, "")
+}
+
+// handleGetArchivedThread handles a GET request for an archived thread, shaped like a live thread view.
+func (server *Server) handleGetArchivedThread(ctx context.Context, req *request, res *response) {
+	threadNum, err := strconv.Atoi(req.params.ByName("thread"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "Invalid thread number")
+		return
+	}
+	view, err := server.store.GetArchivedThreadView(ctx, req.params.ByName("cat"), threadNum)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to get archived thread view", err)
+		return
+	}
+	res.Respond(http.StatusOK, view, "")
+}
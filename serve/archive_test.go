@@ -0,0 +1,58 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	mockStore := &MockStore{
+		getCategoryView: &data.CatView{Category: &data.Category{Tag: "tech"}, Threads: []*data.Post{{Num: 5, Cat: "tech"}}},
+		slugs:           map[string]string{"tech": "technology"},
+	}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth).as(nil)
+
+	rr := client.do("GET", "/v1/archive/technology?page=2", "")
+	if rr.Code != http.StatusOK || mockStore.archiveOffset != archivePageThreads {
+		t.Fatalf("expected the second page of the archive, got: %d from %d", rr.Code, mockStore.archiveOffset)
+	}
+	var view data.CatView
+	if err := json.NewDecoder(rr.Body).Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	if len(view.Threads) != 1 || view.Threads[0].Num != 5 {
+		t.Errorf("expected archived threads shaped like a category view, got %+v", view)
+	}
+	if rr := client.do("GET", "/v1/archive/technology?page=0", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected page zero to be refused, got: %d", rr.Code)
+	}
+
+	if rr := client.do("GET", "/v1/archive/technology/5", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a thread that was never archived to 404, got: %d", rr.Code)
+	}
+	mockStore.getThreadView = &data.ThreadView{Posts: []*data.Post{{Num: 5, Cat: "tech"}, {Num: 6, Cat: "tech", Parent: 5}}}
+	rr = client.do("GET", "/v1/archive/technology/5", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the archived thread, got: %d", rr.Code)
+	}
+	var thread data.ThreadView
+	if err := json.NewDecoder(rr.Body).Decode(&thread); err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Posts) != 2 {
+		t.Errorf("expected the archived thread and its reply, got %+v", thread)
+	}
+	if rr := client.do("GET", "/v1/archive/technology/five", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad thread number to be refused, got: %d", rr.Code)
+	}
+
+	rr = client.do("GET", "/v1/archive/tech/5", "")
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/v1/archive/technology/5" {
+		t.Errorf("expected redirect to the new slug, got: %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,49 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"spiritchat/data"
+	"strconv"
+)
+
+// handleSetBookmark handles a PUT request bookmarking a post, or a DELETE forgetting it.
+func (server *Server) handleSetBookmark(ctx context.Context, req *request, res *response) {
+	// Any post can be bookmarked, not only threads, so the thread parameter is the post's number.
+	num, err := strconv.Atoi(req.params.ByName("thread"))
+	if err != nil || num < 1 {
+		res.Respond(http.StatusBadRequest, nil, "invalid post number")
+		return
+	}
+
+	bookmarked := req.rawRequest.Method != http.MethodDelete
+	err = server.store.SetBookmark(ctx, req.user.ID, req.params.ByName("cat"), num, bookmarked)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such post")
+			return
+		}
+		res.Fail("Failed to set bookmark", err)
+		return
+	}
+	if bookmarked {
+		res.Respond(http.StatusOK, nil, "post bookmarked")
+		return
+	}
+	res.Respond(http.StatusOK, nil, "bookmark removed")
+}
+
+/*
+handleGetBookmarks handles a GET request for a page of the user's bookmarks, newest first.
+Older pages are fetched by passing the ID of the oldest bookmark seen as before.
+*/
+func (server *Server) handleGetBookmarks(ctx context.Context, req *request, res *response) {
+	before, limit := getPage(req)
+	bookmarks, err := server.store.GetBookmarks(ctx, req.user.ID, before, limit)
+	if err != nil {
+		res.Fail("Failed to get bookmarks", err)
+		return
+	}
+	res.Respond(http.StatusOK, bookmarks, "")
+}
@@ -0,0 +1,34 @@
+package serve
+
+import (
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestBookmarks(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: &auth.UserData{ID: "auth0|spirit", Username: "spirit", Email: "spirit@gmail.com", IsVerified: true}}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("PUT", "/v1/categories/cat/0/bookmark", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected post 0 to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/categories/cat/3/bookmark", ""); rr.Code != http.StatusOK || !mockStore.bookmarks["cat/3"] {
+		t.Errorf("expected post to be bookmarked, got: %d", rr.Code)
+	}
+	if rr := client.do("GET", "/v1/me/bookmarks?limit=10", ""); rr.Code != http.StatusOK || mockStore.pageLimit != 10 {
+		t.Errorf("expected a page of 10 bookmarks, got: %d of %d", rr.Code, mockStore.pageLimit)
+	}
+	if rr := client.do("DELETE", "/v1/categories/cat/3/bookmark", ""); rr.Code != http.StatusOK || mockStore.bookmarks["cat/3"] {
+		t.Errorf("expected bookmark to be removed, got: %d", rr.Code)
+	}
+
+	mockStore.err = data.ErrNotFound
+	if rr := client.do("PUT", "/v1/categories/cat/9/bookmark", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected missing post to 404, got: %d", rr.Code)
+	}
+}
@@ -0,0 +1,103 @@
+package serve
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+How long the category list is served from memory before it's read again. Admin edits clear it straight
+away, this only bounds how stale post and thread counts get, and edits made on other instances.
+*/
+const categoryListTTL = time.Minute
+
+// cacheMetrics count how a cache has been used since the server started.
+type cacheMetrics struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Times the cache was cleared by a write.
+	Invalidations int64 `json:"invalidations"`
+	// Responses that were skipped because the client's copy was current.
+	NotModified int64 `json:"notModified"`
+}
+
+func (metrics *cacheMetrics) snapshot() cacheMetrics {
+	return cacheMetrics{
+		Hits:          atomic.LoadInt64(&metrics.Hits),
+		Misses:        atomic.LoadInt64(&metrics.Misses),
+		Invalidations: atomic.LoadInt64(&metrics.Invalidations),
+		NotModified:   atomic.LoadInt64(&metrics.NotModified),
+	}
+}
+
+// responseCache holds a serialized response and its ETag, so every page load doesn't read and encode it again.
+type responseCache struct {
+	mut     sync.Mutex
+	body    []byte
+	etag    string
+	expires time.Time
+	metrics cacheMetrics
+}
+
+/*
+get returns the cached body and its ETag, filling the cache from fill if it's empty or expired.
+Writes that invalidate the cache wait for a fill in progress, so a stale read can't be cached over them.
+*/
+func (rc *responseCache) get(ctx context.Context, fill func(ctx context.Context) (interface{}, error)) ([]byte, string, error) {
+	rc.mut.Lock()
+	defer rc.mut.Unlock()
+	if rc.body != nil && time.Now().Before(rc.expires) {
+		atomic.AddInt64(&rc.metrics.Hits, 1)
+		return rc.body, rc.etag, nil
+	}
+	atomic.AddInt64(&rc.metrics.Misses, 1)
+
+	obj, err := fill(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	rc.body, rc.etag, rc.expires = body, `"`+hex.EncodeToString(sum[:16])+`"`, time.Now().Add(categoryListTTL)
+	return rc.body, rc.etag, nil
+}
+
+// invalidate clears the cache, for the next request to fill it again.
+func (rc *responseCache) invalidate() {
+	rc.mut.Lock()
+	defer rc.mut.Unlock()
+	rc.body, rc.etag = nil, ""
+	atomic.AddInt64(&rc.metrics.Invalidations, 1)
+}
+
+// respond writes a cached body, or Not Modified if the client already has it.
+func (rc *responseCache) respond(req *request, res *response, body []byte, etag string) {
+	res.rw.Header().Set("Cache-Control", "public, max-age=60")
+	res.rw.Header().Set("ETag", etag)
+	if req.header.Get("If-None-Match") == etag {
+		atomic.AddInt64(&rc.metrics.NotModified, 1)
+		res.status = http.StatusNotModified
+		res.rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	res.status = http.StatusOK
+	res.rw.Header().Set("content-type", "application/json")
+	res.rw.WriteHeader(http.StatusOK)
+	res.rw.Write(append(body, '\n'))
+}
+
+// handleGetCacheMetrics handles a GET request from an admin for how the response caches are being used.
+func (server *Server) handleGetCacheMetrics(ctx context.Context, req *request, res *response) {
+	res.Respond(http.StatusOK, map[string]cacheMetrics{
+		"categories": server.categoryList.metrics.snapshot(),
+	}, "")
+}
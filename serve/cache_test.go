@@ -0,0 +1,62 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestCategoryListCache(t *testing.T) {
+	mockStore := &MockStore{getCategories: []*data.Category{{Tag: "tech", Name: "Technology"}}}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	list := func(etag string) ([]*data.Category, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("GET", "/v1/categories", nil)
+		if len(etag) > 0 {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		var categories []*data.Category
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&categories); err != nil {
+				t.Fatalf("expected a valid category list, got: %v", err)
+			}
+		}
+		return categories, rr
+	}
+
+	categories, rr := list("")
+	etag := rr.Header().Get("ETag")
+	if len(categories) != 1 || len(etag) == 0 || rr.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Fatalf("expected one category with caching headers, got %d: %v", len(categories), rr.Header())
+	}
+
+	// The store isn't read again until a category's written.
+	mockStore.getCategories = append(mockStore.getCategories, &data.Category{Tag: "art", Name: "Art"})
+	if categories, _ := list(""); len(categories) != 1 {
+		t.Errorf("expected the cached list, got %d categories", len(categories))
+	}
+	if _, rr := list(etag); rr.Code != http.StatusNotModified || rr.Body.Len() > 0 {
+		t.Errorf("expected a current ETag to be not modified, got: %d", rr.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/admin/categories", bytes.NewBufferString(`{"tag": "art", "name": "Art"}`))
+	req.Header.Add("Authorization", "ok")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	categories, rr = list(etag)
+	if rr.Code != http.StatusOK || len(categories) != 2 || rr.Header().Get("ETag") == etag {
+		t.Errorf("expected the list to be read again after a write, got %d with %d categories", rr.Code, len(categories))
+	}
+
+	metrics := server.categoryList.metrics.snapshot()
+	if metrics.Hits != 2 || metrics.Misses != 2 || metrics.Invalidations != 1 || metrics.NotModified != 1 {
+		t.Errorf("expected 2 hits, 2 misses, 1 invalidation and 1 not modified, got: %+v", metrics)
+	}
+}
@@ -0,0 +1,61 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+)
+
+// Requests for canary threads returned to admins.
+const maxCanaryHits = 200
+
+// canaryReport is every canary thread and who's been requesting them.
+type canaryReport struct {
+	Canaries []*data.Canary    `json:"canaries"`
+	Hits     []*data.CanaryHit `json:"hits"`
+}
+
+/*
+serveCanary answers a request for a thread that doesn't exist if it's a canary, recording who asked and
+serving it like any other thread so they can't tell. Returns false if it isn't one, to 404 as usual.
+*/
+func (server *Server) serveCanary(ctx context.Context, req *request, res *response, categoryTag string, num int) bool {
+	canary, err := server.store.GetCanary(ctx, categoryTag, num)
+	if err != nil {
+		if !errors.Is(err, data.ErrNotFound) {
+			log.Printf("Failed to check for canary: %s", err)
+		}
+		return false
+	}
+	category, err := server.store.GetCategory(ctx, categoryTag)
+	if err != nil {
+		log.Printf("Failed to get canary's category: %s", err)
+		return false
+	}
+
+	userAgent := req.header.Get("User-Agent")
+	log.Printf("Canary thread /%s/%d (%s) requested by %s with %q", categoryTag, num, canary.Label, req.ip, userAgent)
+	err = server.store.RecordCanaryHit(ctx, &data.CanaryHit{CanaryID: canary.ID, IP: req.ip, UserAgent: userAgent})
+	if err != nil {
+		log.Printf("Failed to record canary hit: %s", err)
+	}
+	res.Respond(http.StatusOK, data.ThreadView{Category: category, Posts: []*data.Post{canary.Post()}}, "")
+	return true
+}
+
+// handleGetCanaries handles a GET request from an admin for every canary thread and the latest requests for them.
+func (server *Server) handleGetCanaries(ctx context.Context, req *request, res *response) {
+	canaries, err := server.store.GetCanaries(ctx)
+	if err != nil {
+		res.Fail("Failed to get canaries", err)
+		return
+	}
+	hits, err := server.store.GetCanaryHits(ctx, maxCanaryHits)
+	if err != nil {
+		res.Fail("Failed to get canary hits", err)
+		return
+	}
+	res.Respond(http.StatusOK, canaryReport{Canaries: canaries, Hits: hits}, "")
+}
@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestCanaries(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{
+		getCategory: &data.Category{Tag: "tech", Slug: "tech"},
+		canaries:    []*data.Canary{{ID: 1, Cat: "tech", Num: 42, Label: "sitemap", Subject: "Old thread", Content: "hello"}},
+	}
+	server := CreateTestServer(mockStore, &MockAuth{user: admin}, ServerOptions{})
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/v1/categories/tech/42", map[string]string{"User-Agent": "scraper/1.0", "X-Real-IP": "203.0.113.9"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the canary served like a thread, got: %d", rr.Code)
+	}
+	var view data.ThreadView
+	if err := json.NewDecoder(rr.Body).Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	if len(view.Posts) != 1 || view.Posts[0].Num != 42 || view.Posts[0].Subject != "Old thread" {
+		t.Errorf("expected the canary's post, got %+v", view.Posts)
+	}
+	if rr := get("/v1/categories/tech/43", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected other missing threads to 404, got: %d", rr.Code)
+	}
+
+	rr = get("/v1/admin/canaries?reason=scraper+report", map[string]string{"Authorization": "ok"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	var report canaryReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Canaries) != 1 || report.Canaries[0].Hits != 1 {
+		t.Errorf("expected the canary counted once, got %+v", report.Canaries)
+	}
+	if len(report.Hits) != 1 || report.Hits[0].IP != "203.0.113.9" || report.Hits[0].UserAgent != "scraper/1.0" {
+		t.Errorf("expected the hit recorded with who made it, got %+v", report.Hits)
+	}
+}
@@ -0,0 +1,352 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/*
+middlewareCategorySlug swaps the :cat URL slug for the category's tag, and redirects
+requests for slugs the category was renamed from. Unknown slugs are passed through as is.
+*/
+func (s *Server) middlewareCategorySlug(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		slug := req.params.ByName("cat")
+		tag, current, err := s.store.ResolveCategorySlug(ctx, slug)
+		if err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				next(ctx, req, res)
+				return
+			}
+			res.Fail("Failed to resolve category slug", err)
+			return
+		}
+
+		if current != slug {
+			location := *req.rawRequest.URL
+			location.Path = replaceCategorySegment(req.route, location.Path, current)
+			// Only GETs can be safely downgraded, anything else keeps its method and body.
+			status := http.StatusPermanentRedirect
+			if req.rawRequest.Method == http.MethodGet {
+				status = http.StatusMovedPermanently
+			}
+			res.rw.Header().Set("Location", location.String())
+			res.Respond(status, nil, "category moved to "+current)
+			return
+		}
+
+		params := make(httprouter.Params, len(req.params))
+		for i, param := range req.params {
+			if param.Key == "cat" {
+				param.Value = tag
+			}
+			params[i] = param
+		}
+		req.params = params
+		next(ctx, req, res)
+	}
+}
+
+// replaceCategorySegment swaps the segment of path matched by :cat in the route for the slug.
+func replaceCategorySegment(route string, path string, slug string) string {
+	pattern := strings.Split(strings.SplitN(route, " ", 2)[1], "/")
+	segments := strings.Split(path, "/")
+	for i, segment := range pattern {
+		if segment == ":cat" && i < len(segments) {
+			segments[i] = slug
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// categoryTag returns the tag of the category at a slug, current or renamed from, or the slug itself if there's none.
+func (server *Server) categoryTag(ctx context.Context, slug string) (string, error) {
+	tag, _, err := server.store.ResolveCategorySlug(ctx, slug)
+	if errors.Is(err, data.ErrNotFound) {
+		return slug, nil
+	}
+	return tag, err
+}
+
+// handleSetCategoryListing handles a PUT request from an admin ordering or featuring a category.
+func (server *Server) handleSetCategoryListing(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingCategoryListing(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.store.SetCategoryListing(ctx, req.params.ByName("cat"), incoming.SortOrder, incoming.Featured)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to set category listing", err)
+		return
+	}
+	server.categoryList.invalidate()
+	res.Respond(http.StatusOK, incoming, "")
+}
+
+// handleSetCategoryArchived handles a PUT request from an admin archiving or unarchiving a category.
+func (server *Server) handleSetCategoryArchived(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingCategoryArchived(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.store.SetCategoryArchived(ctx, req.params.ByName("cat"), incoming.Archived)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to set category archived", err)
+		return
+	}
+	server.categoryList.invalidate()
+	log.Printf("Archived set to %t on %s by %s", incoming.Archived, req.params.ByName("cat"), req.user.Email)
+	res.Respond(http.StatusOK, incoming, "")
+}
+
+/*
+handleUpdateCategory handles a PATCH request from an admin editing a category's settings. The edit must name the
+version it was made against, in an If-Match header or the body, and is rejected with a 409 if the category's moved on.
+*/
+func (server *Server) handleUpdateCategory(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingCategoryUpdate(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	if match := req.header.Get("If-Match"); len(match) > 0 {
+		version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(match, "W/"), `"`))
+		if err != nil {
+			res.Respond(http.StatusBadRequest, nil, "If-Match must be a category version")
+			return
+		}
+		incoming.Version = &version
+	}
+	if incoming.Version == nil {
+		res.Respond(http.StatusPreconditionRequired, nil, "edits must include the category version, in If-Match or the body")
+		return
+	}
+
+	category, err := server.store.UpdateCategory(ctx, req.params.ByName("cat"), *incoming.Version, &incoming.CategoryUpdate)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		if errors.Is(err, data.ErrVersionConflict) {
+			res.Respond(http.StatusConflict, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to update category", err)
+		return
+	}
+	server.categoryList.invalidate()
+	log.Printf("Category %s updated to version %d by %s", category.Tag, category.Version, req.user.Email)
+	res.rw.Header().Set("ETag", fmt.Sprintf(`"%d"`, category.Version))
+	res.Respond(http.StatusOK, category, "")
+}
+
+/*
+handleRemoveCategory handles a DELETE request from an admin removing a category and its posts.
+Set dryRun=true to count what would be removed, and archive=true to keep a copy in the archive tables.
+*/
+func (server *Server) handleRemoveCategory(ctx context.Context, req *request, res *response) {
+	values := req.rawRequest.URL.Query()
+	opts := data.RemoveCategoryOptions{
+		DryRun:  req.dryRun,
+		Archive: values.Get("archive") == "true",
+	}
+	categoryTag := req.params.ByName("cat")
+
+	removal, err := server.store.RemoveCategory(ctx, categoryTag, opts)
+	if err != nil {
+		res.Fail("Failed to remove category", err)
+		return
+	}
+	if removal.Categories == 0 {
+		res.Respond(http.StatusNotFound, nil, "no such category")
+		return
+	}
+	if !opts.DryRun {
+		server.categoryList.invalidate()
+		log.Printf("Category %s removed by %s, archived: %t", categoryTag, req.user.Email, opts.Archive)
+		// Each thread's removal takes its replies out of search and the counters along with it.
+		for _, num := range removal.Threads {
+			server.events.Publish(events.Event{Kind: events.PostDeleted, Category: categoryTag, Num: num})
+		}
+	}
+	res.Respond(http.StatusOK, removal, "")
+}
+
+// handleGetPostPolicy handles a GET request from an admin for what a category accepts in new posts.
+func (server *Server) handleGetPostPolicy(ctx context.Context, req *request, res *response) {
+	policy, err := server.store.GetPostPolicy(ctx, req.params.ByName("cat"))
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to get post policy", err)
+		return
+	}
+	res.Respond(http.StatusOK, policy, "")
+}
+
+// handleSetPostPolicy handles a PUT request from an admin setting what a category accepts in new posts.
+func (server *Server) handleSetPostPolicy(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingPostPolicy(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.store.SetPostPolicy(ctx, req.params.ByName("cat"), &data.PostPolicy{
+		MaxLinks:                        incoming.MaxLinks,
+		RejectLinkOnly:                  incoming.RejectLinkOnly,
+		RequireAttachment:               incoming.RequireAttachment,
+		AllowEmptyContentWithAttachment: incoming.AllowEmptyContentWithAttachment,
+		Uploads:                         incoming.Uploads,
+		Usernames:                       incoming.Usernames,
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to set post policy", err)
+		return
+	}
+	// Categories are listed with their upload and username policies.
+	server.categoryList.invalidate()
+	log.Printf("Post policy on %s set by %s", req.params.ByName("cat"), req.user.Email)
+	res.Respond(http.StatusOK, incoming, "")
+}
+
+// handleGetCategoryMasks handles a GET request from an admin for the words masked in a category.
+func (server *Server) handleGetCategoryMasks(ctx context.Context, req *request, res *response) {
+	words, err := server.store.GetCategoryMasks(ctx, req.params.ByName("cat"))
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to get category masks", err)
+		return
+	}
+	res.Respond(http.StatusOK, words, "")
+}
+
+// handleSetCategoryMasks handles a PUT request from an admin replacing the words masked in a category's new posts.
+func (server *Server) handleSetCategoryMasks(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingCategoryMasks(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.store.SetCategoryMasks(ctx, req.params.ByName("cat"), incoming.Words)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to set category masks", err)
+		return
+	}
+	log.Printf("Masked words on %s set by %s", req.params.ByName("cat"), req.user.Email)
+	res.Respond(http.StatusOK, incoming.Words, "")
+}
+
+// handleCreateCategory handles a POST request from an admin adding a category.
+func (server *Server) handleCreateCategory(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingCategory(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.store.WriteCategory(ctx, incoming.Tag, incoming.Name)
+	if err != nil {
+		if errors.Is(err, data.ErrCategoryExists) {
+			res.Respond(http.StatusConflict, nil, err.Error())
+			return
+		}
+		if errors.Is(err, data.ErrInvalidCategoryTag) {
+			res.Respond(http.StatusBadRequest, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to create category", err)
+		return
+	}
+	server.categoryList.invalidate()
+	log.Printf("Category %s created by %s", incoming.Tag, req.user.Email)
+	res.Respond(http.StatusCreated, incoming, "")
+}
+
+// handleSetCategorySlug handles a PUT request from an admin renaming a category's URL slug.
+func (server *Server) handleSetCategorySlug(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingCategorySlug(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.store.SetCategorySlug(ctx, req.params.ByName("cat"), incoming.Slug)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		if errors.Is(err, data.ErrSlugTaken) {
+			res.Respond(http.StatusConflict, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to set category slug", err)
+		return
+	}
+	server.categoryList.invalidate()
+	log.Printf("Slug of %s set to %s by %s", req.params.ByName("cat"), incoming.Slug, req.user.Email)
+	res.Respond(http.StatusOK, incoming, "")
+}
@@ -0,0 +1,360 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strings"
+	"testing"
+)
+
+func TestCreateCategory(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	for _, body := range []string{
+		`{"tag": "; DROP TABLE posts", "name": "Tech"}`,
+		`{"tag": "retro-games", "name": "Retro"}`,
+		`{"tag": "admin", "name": "Admin"}`,
+		`{"tag": "tech", "name": ""}`,
+	} {
+		if rr := client.do("POST", "/v1/admin/categories", body); rr.Code != http.StatusBadRequest || len(mockStore.wroteCategory) > 0 {
+			t.Errorf("%s: expected a bad request, got: %d", body, rr.Code)
+		}
+	}
+
+	if rr := client.do("POST", "/v1/admin/categories", `{"tag": "tech", "name": "Technology"}`); rr.Code != http.StatusCreated || mockStore.wroteCategory != "tech" {
+		t.Errorf("expected tech to be created, got: %d", rr.Code)
+	}
+
+	mockStore.err = data.ErrCategoryExists
+	if rr := client.do("POST", "/v1/admin/categories", `{"tag": "tech", "name": "Technology"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected a taken tag to conflict, got: %d", rr.Code)
+	}
+}
+
+func TestCategorySlugs(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{getCategoryView: &data.CatView{}}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.as(moderator("mod@gmail.com")).do("PUT", "/v1/admin/categories/tech/slug", `{"slug": "technology"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected moderators not to rename categories, got: %d", rr.Code)
+	}
+	if rr := client.as(admin).do("PUT", "/v1/admin/categories/tech/slug", `{"slug": "Tech/1"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected bad slug to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.as(admin).do("PUT", "/v1/admin/categories/tech/slug", `{"slug": "technology"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected category to be renamed, got: %d", rr.Code)
+	}
+
+	rr := client.as(nil).do("GET", "/v1/categories/tech?page=2", "")
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/v1/categories/technology?page=2" {
+		t.Errorf("expected redirect to the new slug, got: %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	rr = client.as(nil).do("POST", "/v1/categories/tech/1", `{"content": "hello!"}`)
+	if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Location") != "/v1/categories/technology/1" {
+		t.Errorf("expected method preserving redirect to the new slug, got: %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := client.as(nil).do("GET", "/v1/categories/technology", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the new slug to be served, got: %d", rr.Code)
+	}
+
+	mockStore.err = data.ErrSlugTaken
+	if rr := client.as(admin).do("PUT", "/v1/admin/categories/art/slug", `{"slug": "technology"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected taken slug to conflict, got: %d", rr.Code)
+	}
+}
+
+func TestCategoryListing(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	rr := newTestClient(server, mockAuth).do("PUT", "/v1/admin/categories/tech/listing", `{"sortOrder": 3, "featured": true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if mockStore.listing == nil || mockStore.listing.Tag != "tech" || mockStore.listing.SortOrder != 3 || !mockStore.listing.Featured {
+		t.Errorf("expected listing written, got %+v", mockStore.listing)
+	}
+}
+
+func TestCategoryArchived(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("PUT", "/v1/admin/categories/tech/archived", `{"archived": true}`); rr.Code != http.StatusOK || !mockStore.archived {
+		t.Fatalf("expected category archived, got: %d", rr.Code)
+	}
+
+	mockStore.err = data.ErrCategoryArchived
+	if rr := client.do("POST", "/v1/categories/tech/0", `{"subject": "about owls", "content": "hello!"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected posts to an archived category to conflict, got: %d", rr.Code)
+	}
+}
+
+func TestRemoveCategory(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{}
+	bus := events.NewBus()
+	var deleted []int
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		deleted = append(deleted, event.Num)
+	}, events.PostDeleted)
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{Events: bus})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.as(moderator("mod@gmail.com")).do("DELETE", "/v1/admin/categories/tech", ""); rr.Code != http.StatusForbidden || mockStore.removeCategory != nil {
+		t.Errorf("expected moderators not to remove categories, got: %d", rr.Code)
+	}
+
+	rr := client.as(admin).do("DELETE", "/v1/admin/categories/tech?dryRun=true&archive=true", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if *mockStore.removeCategory != (data.RemoveCategoryOptions{DryRun: true, Archive: true}) {
+		t.Errorf("expected dry run archiving removal, got %+v", mockStore.removeCategory)
+	}
+	var removal data.CategoryRemoval
+	if err := json.NewDecoder(rr.Body).Decode(&removal); err != nil {
+		t.Fatal(err)
+	}
+	if removal.Posts != 3 || !removal.DryRun {
+		t.Errorf("expected dry run counts, got %+v", removal)
+	}
+	bus.Wait()
+	if len(deleted) != 0 {
+		t.Errorf("expected dry run not to publish deletes, got %v", deleted)
+	}
+
+	mockStore.removeCategory = nil
+	if rr := client.as(admin).do("DELETE", "/v1/admin/categories/tech", ""); rr.Code != http.StatusPreconditionRequired || mockStore.removeCategory != nil {
+		t.Errorf("expected removal to need confirming, got: %d", rr.Code)
+	}
+	rr = serveConfirmed(t, server, func() *http.Request {
+		req := httptest.NewRequest("DELETE", "/v1/admin/categories/tech", nil)
+		req.Header.Add("Authorization", "ok")
+		return req
+	})
+	if rr.Code != http.StatusOK || *mockStore.removeCategory != (data.RemoveCategoryOptions{}) {
+		t.Errorf("expected category removed, got: %d %+v", rr.Code, mockStore.removeCategory)
+	}
+	bus.Wait()
+	if len(deleted) != 2 {
+		t.Errorf("expected each removed thread's delete published, got %v", deleted)
+	}
+}
+
+func TestUpdateCategory(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: admin}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+	patch := func(body string, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/v1/admin/categories/tech", strings.NewReader(body))
+		if len(ifMatch) > 0 {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		return client.send(req)
+	}
+
+	if rr := patch(`{"name": "Technology"}`, ""); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected edits without a version to be refused with %d, got: %d", http.StatusPreconditionRequired, rr.Code)
+	}
+	if rr := patch(`{"name": ""}`, `"3"`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected empty name to be refused with %d, got: %d", http.StatusBadRequest, rr.Code)
+	}
+
+	rr := patch(`{"name": "Technology", "featured": true}`, `"3"`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if mockStore.updateVersion != 3 || *mockStore.categoryUpdate.Name != "Technology" ||
+		!*mockStore.categoryUpdate.Featured || mockStore.categoryUpdate.Description != nil {
+		t.Errorf("expected partial update at version 3, got %d %+v", mockStore.updateVersion, mockStore.categoryUpdate)
+	}
+	if etag := rr.Header().Get("ETag"); etag != `"4"` {
+		t.Errorf("expected ETag of the new version, got: %s", etag)
+	}
+
+	if rr := patch(`{"version": 7, "archived": true}`, ""); rr.Code != http.StatusOK || mockStore.updateVersion != 7 {
+		t.Errorf("expected version to be taken from the body, got: %d %d", rr.Code, mockStore.updateVersion)
+	}
+	if rr := patch(`{"lockInactiveDays": -1}`, `"3"`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected negative days before locking to be refused with %d, got: %d", http.StatusBadRequest, rr.Code)
+	}
+	rr = patch(`{"lockInactiveDays": 30}`, `"3"`)
+	if rr.Code != http.StatusOK || mockStore.categoryUpdate.LockInactiveDays == nil || *mockStore.categoryUpdate.LockInactiveDays != 30 {
+		t.Errorf("expected inactive threads to be locked after 30 days, got: %d %+v", rr.Code, mockStore.categoryUpdate)
+	}
+
+	mockStore.err = data.ErrVersionConflict
+	if rr := patch(`{"name": "Tech"}`, `"3"`); rr.Code != http.StatusConflict {
+		t.Errorf("expected conflicting edit to be refused with %d, got: %d", http.StatusConflict, rr.Code)
+	}
+}
+
+func TestCategoryMasks(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	bus := events.NewBus()
+	var created events.Event
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		created = event
+	}, events.PostCreated)
+
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: admin}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{Events: bus})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("PUT", "/v1/admin/categories/tech/masks", `{"words": [""]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected empty masked word to be refused, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/admin/categories/tech/masks", `{"words": [" Darn "]}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if len(mockStore.masks) != 1 || mockStore.masks[0] != "darn" {
+		t.Errorf("expected sanitized masked words, got %v", mockStore.masks)
+	}
+
+	rr := client.do("POST", "/v1/categories/tech/5", `{"content": "darn it"}`)
+	bus.Wait()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if created.Post == nil || created.Post.Content != "**** it" || created.Post.Original != "darn it" {
+		t.Errorf("expected masked content on PostCreated event, got: %+v", created.Post)
+	}
+}
+
+func TestPostPolicy(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: admin}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("PUT", "/v1/admin/categories/tech/policy", `{"maxLinks": -1}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected negative max links to be refused, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/admin/categories/tech/policy", `{"maxLinks": 1, "rejectLinkOnly": true}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if mockStore.postPolicy.MaxLinks != 1 || !mockStore.postPolicy.RejectLinkOnly || mockStore.postPolicy.Usernames != data.UsernamesChoice {
+		t.Errorf("expected policy set, got %+v", mockStore.postPolicy)
+	}
+
+	tests := map[string]struct {
+		content      string
+		expectedCode int
+		expectedErr  string
+	}{
+		"Too many links": {"see https://a.com and https://b.com", http.StatusBadRequest, "too_many_links"},
+		"Link only":      {"https://a.com", http.StatusBadRequest, "link_only"},
+		"Fine":           {"see https://a.com", http.StatusOK, ""},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"content": test.content})
+			rr := client.do("POST", "/v1/categories/tech/5", string(body))
+			if rr.Code != test.expectedCode {
+				t.Fatalf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if len(test.expectedErr) == 0 {
+				return
+			}
+			var rejected rejection
+			if err := json.NewDecoder(rr.Body).Decode(&rejected); err != nil {
+				t.Fatal(err)
+			}
+			if rejected.Code != test.expectedErr || len(rejected.Message) == 0 {
+				t.Errorf("expected %s rejection, got %+v", test.expectedErr, rejected)
+			}
+		})
+	}
+}
+
+func TestAttachmentPolicy(t *testing.T) {
+	mockStore := &MockStore{postPolicy: &data.PostPolicy{RequireAttachment: true, AllowEmptyContentWithAttachment: true}}
+	mockAuth := &MockAuth{user: &auth.UserData{Username: "test user", Email: "test@gmail.com", IsVerified: true}}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	rr := client.do("POST", "/v1/categories/pics/0", `{"subject": "image dump", "content": "look at these"}`)
+	var rejected rejection
+	if err := json.NewDecoder(rr.Body).Decode(&rejected); err != nil || rr.Code != http.StatusBadRequest || rejected.Code != "attachment_required" {
+		t.Errorf("expected thread without an attachment to be refused, got: %d %+v", rr.Code, rejected)
+	}
+	if rr := client.do("POST", "/v1/categories/pics/5", `{"content": "nice"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected replies without attachments to be allowed, got: %d", rr.Code)
+	}
+	if rr := client.do("POST", "/v1/categories/pics/5", `{"content": ""}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected empty content without an attachment to be refused, got: %d", rr.Code)
+	}
+
+	reply := &incomingReply{Subject: "image dump", Content: "  ", Attachments: []int{1}}
+	if err := reply.Sanitize(true, mockStore.postPolicy); err != nil || len(reply.Content) > 0 {
+		t.Errorf("expected empty content to be allowed with an attachment, got %q %v", reply.Content, err)
+	}
+}
+
+func TestUsernamePolicy(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: admin}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("PUT", "/v1/admin/categories/tech/policy", `{"usernames": "sometimes"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown username policy to be refused, got: %d", rr.Code)
+	}
+
+	tests := map[string]struct {
+		usernames data.UsernamePolicy
+		anonymous bool
+		expected  string
+	}{
+		"Choice named":        {data.UsernamesChoice, false, "admin"},
+		"Choice anonymous":    {data.UsernamesChoice, true, data.AnonymousName},
+		"Always":              {data.UsernamesAlways, true, "admin"},
+		"Anonymous":           {data.UsernamesAnonymous, false, data.AnonymousName},
+		"Anonymous preferred": {data.UsernamesAnonymous, true, data.AnonymousName},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"usernames": string(test.usernames)})
+			if rr := client.do("PUT", "/v1/admin/categories/tech/policy", string(body)); rr.Code != http.StatusOK {
+				t.Fatalf("expected policy set, got: %d", rr.Code)
+			}
+			mockStore.preferences = &data.Preferences{Anonymous: test.anonymous}
+			if rr := client.do("POST", "/v1/categories/tech/5", `{"content": "hello"}`); rr.Code != http.StatusOK {
+				t.Fatalf("expected post to be accepted, got: %d", rr.Code)
+			}
+			if mockStore.author.Username != test.expected || mockStore.author.ID != admin.ID {
+				t.Errorf("expected post under %q, got: %+v", test.expected, mockStore.author)
+			}
+		})
+	}
+}
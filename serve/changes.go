@@ -0,0 +1,72 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+	"spiritchat/data"
+	"strconv"
+)
+
+// Changes returned per page of the feed, by default and at most.
+const (
+	defaultChangesPage = 100
+	maxChangesPage     = 500
+)
+
+// changesPage is a page of the changes feed, and the cursor to read the next from.
+type changesPage struct {
+	Changes []*data.PostChange `json:"changes"`
+	Cursor  int64              `json:"cursor"`
+	// More changes are waiting after this page, so mirrors can read on without waiting.
+	More bool `json:"more"`
+}
+
+/*
+handleGetChanges handles a GET request for posts created, edited or deleted after the since cursor, oldest first,
+so mirrors can keep up without crawling every thread. Mirrors pass back the cursor from the last page to read on.
+*/
+func (server *Server) handleGetChanges(ctx context.Context, req *request, res *response) {
+	query := req.rawRequest.URL.Query()
+	var since int64
+	if val := query.Get("since"); len(val) > 0 {
+		var err error
+		since, err = strconv.ParseInt(val, 10, 64)
+		if err != nil || since < 0 {
+			res.Respond(http.StatusBadRequest, nil, "invalid since")
+			return
+		}
+	}
+	limit := defaultChangesPage
+	if requested, err := strconv.Atoi(query.Get("limit")); err == nil && requested > 0 {
+		limit = requested
+		if limit > maxChangesPage {
+			limit = maxChangesPage
+		}
+	}
+
+	changes, err := server.store.GetChanges(ctx, since, limit)
+	if err != nil {
+		res.Fail("Failed to get changes", err)
+		return
+	}
+	page := changesPage{Changes: changes, Cursor: since, More: len(changes) == limit}
+	if len(changes) > 0 {
+		page.Cursor = changes[len(changes)-1].Cursor
+	}
+	res.Respond(http.StatusOK, page, "")
+}
+
+// handleGetPostEvents handles a GET request from a moderator for everything that's happened to a post, and who did it.
+func (server *Server) handleGetPostEvents(ctx context.Context, req *request, res *response) {
+	categoryTag, num, err := getQueueParameters(req)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	events, err := server.store.GetPostEvents(ctx, categoryTag, num)
+	if err != nil {
+		res.Fail("Failed to get post events", err)
+		return
+	}
+	res.Respond(http.StatusOK, events, "")
+}
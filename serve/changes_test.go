@@ -0,0 +1,76 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestGetChanges(t *testing.T) {
+	mockStore := &MockStore{changes: []*data.PostChange{
+		{Cursor: 1, Kind: data.PostCreated, Cat: "tech", Num: 1, Thread: 1, Post: &data.Post{Num: 1, Cat: "tech"}},
+		{Cursor: 2, Kind: data.PostCreated, Cat: "tech", Num: 2, Thread: 1},
+		{Cursor: 3, Kind: data.PostDeleted, Cat: "tech", Num: 2, Thread: 1},
+	}}
+	server := CreateTestServer(mockStore, &MockAuth{}, ServerOptions{})
+
+	get := func(query string) (changesPage, int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/changes"+query, nil))
+		var page changesPage
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return page, rr.Code
+	}
+
+	if _, code := get("?since=-1"); code != http.StatusBadRequest {
+		t.Errorf("expected a negative cursor to 400, got: %d", code)
+	}
+
+	page, _ := get("?limit=2")
+	if len(page.Changes) != 2 || page.Cursor != 2 || !page.More {
+		t.Fatalf("expected the first 2 changes with more waiting, got: %+v", page)
+	}
+	page, _ = get("?since=2&limit=2")
+	if len(page.Changes) != 1 || page.Changes[0].Kind != data.PostDeleted || page.Cursor != 3 || page.More {
+		t.Fatalf("expected the deletion and no more, got: %+v", page)
+	}
+	page, _ = get("?since=3")
+	if len(page.Changes) != 0 || page.Cursor != 3 {
+		t.Errorf("expected no changes and the same cursor back, got: %+v", page)
+	}
+}
+
+func TestGetPostEvents(t *testing.T) {
+	mockStore := &MockStore{postEvents: []*data.PostEvent{
+		{ID: 1, Kind: data.PostCreated, Actor: "auth0|poster"},
+		{ID: 2, Kind: data.PostModerated, Actor: "auth0|mod", Detail: "quarantined"},
+	}}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	mockAuth.user = moderator("mod@gmail.com")
+	if rr := client.do("GET", "/v1/admin/posts/tech/x/events", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid post number to 400, got: %d", rr.Code)
+	}
+	rr := client.do("GET", "/v1/admin/posts/tech/1/events", "")
+	var events []*data.PostEvent
+	if err := json.NewDecoder(rr.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Detail != "quarantined" || events[1].Actor != "auth0|mod" {
+		t.Errorf("expected the post's events with who made them, got: %+v", events)
+	}
+	mockAuth.user.Roles = nil
+	if rr := client.do("GET", "/v1/admin/posts/tech/1/events", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected users not to see post events, got: %d", rr.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"time"
+)
+
+// Header a destructive request carries the token confirming it in.
+const confirmationHeader = "X-Confirmation-Token"
+
+// How long a confirmation token can be used for after the request's prepared.
+const confirmationTTL = time.Minute * 2
+
+// Largest request body hashed for a confirmation. Anything past it isn't covered by the token.
+const maxConfirmedBody = 1 << 20
+
+// confirmationRequired is the response to a destructive request made without a token, preparing it.
+type confirmationRequired struct {
+	Message   string    `json:"message"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// requestHash hashes a request's method, path, query and body, restoring the body after.
+func requestHash(req *request) (string, error) {
+	hash := sha256.New()
+	io.WriteString(hash, req.rawRequest.Method+" "+req.rawRequest.URL.Path+"?"+req.rawRequest.URL.Query().Encode()+"\n")
+	if req.rawRequest.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(req.rawRequest.Body, maxConfirmedBody))
+		req.rawRequest.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.rawRequest.Body))
+		if err != nil {
+			return "", err
+		}
+		hash.Write(body)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+/*
+middlewareRequireConfirmation stops destructive requests going through by accident or being replayed.
+Without a token, the request only prepares a short lived one-time token for the same user to send it again with.
+Dry runs change nothing, so go straight through. Must run after middlewareRequireLogin, and after middlewareDryRun
+on routes allowing dry runs.
+*/
+func (s *Server) middlewareRequireConfirmation(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		if req.dryRun {
+			next(ctx, req, res)
+			return
+		}
+		hash, err := requestHash(req)
+		if err != nil {
+			res.Respond(http.StatusBadRequest, nil, "failed to read request")
+			return
+		}
+
+		token := req.header.Get(confirmationHeader)
+		if len(token) == 0 {
+			expires := time.Now().Add(confirmationTTL)
+			token, err := s.store.WriteConfirmation(ctx, req.user.ID, hash, expires)
+			if err != nil {
+				res.Fail("Failed to prepare confirmation", err)
+				return
+			}
+			res.Respond(http.StatusPreconditionRequired, confirmationRequired{
+				Message:   "send the same request again with this token in " + confirmationHeader + " to confirm it",
+				Token:     token,
+				ExpiresAt: expires,
+			}, "")
+			return
+		}
+
+		err = s.store.UseConfirmation(ctx, token, req.user.ID, hash)
+		if err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				res.Respond(http.StatusPreconditionFailed, nil, "confirmation token is invalid, used or expired, or for a different request")
+				return
+			}
+			res.Fail("Failed to check confirmation", err)
+			return
+		}
+		log.Printf("%s confirmed %s %s", req.user.Email, req.rawRequest.Method, req.rawRequest.URL.Path)
+		next(ctx, req, res)
+	}
+}
+
+// middlewareDryRun marks requests with dryRun=true as dry runs, for routes whose handler only reports what it would do.
+func (s *Server) middlewareDryRun(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		req.dryRun = req.rawRequest.URL.Query().Get("dryRun") == "true"
+		next(ctx, req, res)
+	}
+}
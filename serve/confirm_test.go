@@ -0,0 +1,78 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"strings"
+	"testing"
+)
+
+// serveConfirmed sends a request made by newRequest to prepare it, then again with the token to confirm it.
+func serveConfirmed(t *testing.T, server *Server, newRequest func() *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, newRequest())
+	if rr.Code != http.StatusPreconditionRequired {
+		return rr
+	}
+	var prepared confirmationRequired
+	if err := json.NewDecoder(rr.Body).Decode(&prepared); err != nil {
+		t.Fatal(err)
+	}
+	req := newRequest()
+	req.Header.Set(confirmationHeader, prepared.Token)
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRequireConfirmation(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: moderator("mod@gmail.com")}
+	mockAuth.user.ID = "auth0|mod"
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	body := `{"deletePosts": [{"cat": "cat", "num": 2}]}`
+	client := newTestClient(server, mockAuth)
+	act := func(body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/admin/actions", strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Set(confirmationHeader, token)
+		}
+		return client.send(req)
+	}
+
+	rr := act(body, "")
+	if rr.Code != http.StatusPreconditionRequired || mockStore.bulkActions != nil {
+		t.Fatalf("expected an unconfirmed request to only be prepared, got: %d", rr.Code)
+	}
+	var prepared confirmationRequired
+	if err := json.NewDecoder(rr.Body).Decode(&prepared); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := act(`{"deletePosts": [{"cat": "cat", "num": 3}]}`, prepared.Token); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected the token to be refused for a different request, got: %d", rr.Code)
+	}
+	mockAuth.user = &auth.UserData{ID: "auth0|other", Email: "other@gmail.com", IsVerified: true, Roles: []auth.Role{auth.RoleModerator}}
+	if rr := act(body, prepared.Token); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected the token to be refused for a different user, got: %d", rr.Code)
+	}
+	mockAuth.user = moderator("mod@gmail.com")
+	mockAuth.user.ID = "auth0|mod"
+	if rr := act(body, prepared.Token); rr.Code != http.StatusOK || mockStore.bulkActions == nil {
+		t.Fatalf("expected the confirmed request to go through, got: %d", rr.Code)
+	}
+	mockStore.bulkActions = nil
+	if rr := act(body, prepared.Token); rr.Code != http.StatusPreconditionFailed || mockStore.bulkActions != nil {
+		t.Errorf("expected a replayed token to be refused, got: %d", rr.Code)
+	}
+
+	// Bulk actions don't support dry runs, so asking for one doesn't skip confirming.
+	req := httptest.NewRequest("POST", "/v1/admin/actions?dryRun=true", strings.NewReader(body))
+	if rr := client.send(req); rr.Code != http.StatusPreconditionRequired || mockStore.bulkActions != nil {
+		t.Errorf("expected a dry run of a route without them to still need confirming, got: %d", rr.Code)
+	}
+}
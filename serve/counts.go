@@ -0,0 +1,36 @@
+package serve
+
+import (
+	"context"
+	"log"
+	"spiritchat/data"
+)
+
+// countThreads fills in how many threads each category has from the counter, leaving them out if they aren't counted.
+func (server *Server) countThreads(ctx context.Context, categories ...*data.Category) {
+	tags := make([]string, len(categories))
+	for i, category := range categories {
+		tags[i] = category.Tag
+	}
+	threads, err := server.counter.Threads(ctx, tags)
+	if err != nil {
+		log.Printf("Failed to get thread counts: %v", err)
+		return
+	}
+	for _, category := range categories {
+		category.Threads = threads[category.Tag]
+	}
+}
+
+// countReplies returns how many replies a thread has from the counter, or -1 if it isn't counted.
+func (server *Server) countReplies(ctx context.Context, categoryTag string, thread int) int {
+	replies, ok, err := server.counter.Replies(ctx, categoryTag, thread)
+	if err != nil {
+		log.Printf("Failed to get reply count: %v", err)
+		return -1
+	}
+	if !ok {
+		return -1
+	}
+	return replies
+}
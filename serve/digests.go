@@ -0,0 +1,57 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"spiritchat/data"
+)
+
+// handleSetCategorySubscription handles a PUT request subscribing the user to a category's digests, or a DELETE unsubscribing.
+func (server *Server) handleSetCategorySubscription(ctx context.Context, req *request, res *response) {
+	// Subscriptions are kept by tag, so they outlive renames.
+	tag, err := server.categoryTag(ctx, req.params.ByName("cat"))
+	if err != nil {
+		res.Fail("Failed to resolve category slug", err)
+		return
+	}
+	subscribed := req.rawRequest.Method != http.MethodDelete
+	err = server.store.SetCategorySubscription(ctx, req.user.ID, tag, subscribed)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such category")
+			return
+		}
+		res.Fail("Failed to set category subscription", err)
+		return
+	}
+	if subscribed {
+		res.Respond(http.StatusOK, nil, "subscribed")
+		return
+	}
+	res.Respond(http.StatusOK, nil, "unsubscribed")
+}
+
+// handleGetCategorySubscriptions handles a GET request for the tags of the categories the user subscribes to.
+func (server *Server) handleGetCategorySubscriptions(ctx context.Context, req *request, res *response) {
+	subscriptions, err := server.store.GetCategorySubscriptions(ctx, req.user.ID)
+	if err != nil {
+		res.Fail("Failed to get category subscriptions", err)
+		return
+	}
+	res.Respond(http.StatusOK, subscriptions, "")
+}
+
+/*
+handleGetDigests handles a GET request for a page of the user's digests, newest first.
+Older pages are fetched by passing the ID of the oldest digest seen as before.
+*/
+func (server *Server) handleGetDigests(ctx context.Context, req *request, res *response) {
+	before, limit := getPage(req)
+	digests, err := server.store.GetDigests(ctx, req.user.ID, before, limit)
+	if err != nil {
+		res.Fail("Failed to get digests", err)
+		return
+	}
+	res.Respond(http.StatusOK, digests, "")
+}
@@ -0,0 +1,34 @@
+package serve
+
+import (
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestCategorySubscriptions(t *testing.T) {
+	mockStore := &MockStore{slugs: map[string]string{"old": "new"}}
+	mockAuth := &MockAuth{user: &auth.UserData{ID: "auth0|spirit", Username: "spirit", Email: "spirit@gmail.com"}}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("PUT", "/v1/me/subscriptions/cat", ""); rr.Code != http.StatusOK || !mockStore.subscriptions["cat"] {
+		t.Errorf("expected subscription, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/me/subscriptions/old", ""); rr.Code != http.StatusOK || !mockStore.subscriptions["old"] {
+		t.Errorf("expected renamed category's slug to be accepted, got: %d", rr.Code)
+	}
+	if rr := client.do("GET", "/v1/me/digests?before=3", ""); rr.Code != http.StatusOK || mockStore.pageBefore != 3 {
+		t.Errorf("expected digests before 3, got: %d before %d", rr.Code, mockStore.pageBefore)
+	}
+	if rr := client.do("DELETE", "/v1/me/subscriptions/cat", ""); rr.Code != http.StatusOK || mockStore.subscriptions["cat"] {
+		t.Errorf("expected unsubscription, got: %d", rr.Code)
+	}
+
+	mockStore.err = data.ErrNotFound
+	if rr := client.do("PUT", "/v1/me/subscriptions/nope", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected missing category to 404, got: %d", rr.Code)
+	}
+}
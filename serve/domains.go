@@ -0,0 +1,137 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"spiritchat/data"
+	"spiritchat/validation"
+	"strings"
+)
+
+var errBlockedDomain = errors.New("your post links to a site that isn't allowed here")
+
+/*
+filterLinks checks the links in a sanitized post against the blocked domains. Links to domains that strip
+them are removed from the content, and errBlockedDomain is returned for links to domains that reject posts,
+links in the subject, which can't be stripped without leaving it short, or content left with nothing else.
+Returns the blocked domains the post linked to, for counting against them.
+*/
+func filterLinks(reply *incomingReply, blocked []*data.BlockedDomain) ([]string, error) {
+	matched := make(map[string]bool)
+	var rejected bool
+	match := func(link validation.Link) *data.BlockedDomain {
+		for _, domain := range blocked {
+			if domain.Matches(link.Host) {
+				matched[domain.Domain] = true
+				return domain
+			}
+		}
+		return nil
+	}
+
+	for _, link := range validation.FindLinks(reply.Subject) {
+		if match(link) != nil {
+			rejected = true
+		}
+	}
+	content, err := validation.RemoveLinks(reply.Content, func(link validation.Link) bool {
+		domain := match(link)
+		if domain != nil && domain.Action != data.DomainStrip {
+			rejected = true
+		}
+		return domain != nil
+	})
+
+	domains := make([]string, 0, len(matched))
+	for domain := range matched {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	if rejected || err != nil {
+		return domains, errBlockedDomain
+	}
+	reply.Content = content
+	return domains, nil
+}
+
+/*
+checkLinks filters the links in a sanitized post, counting the post against any blocked domains it linked to
+if it's being written. Only posts with links look up the blocked domains.
+*/
+func (server *Server) checkLinks(ctx context.Context, reply *incomingReply, writing bool) error {
+	if len(validation.FindLinks(reply.Subject)) == 0 && len(validation.FindLinks(reply.Content)) == 0 {
+		return nil
+	}
+	blocked, err := server.store.GetBlockedDomains(ctx)
+	if err != nil {
+		return err
+	}
+	domains, err := filterLinks(reply, blocked)
+	if writing && len(domains) > 0 {
+		log.Printf("Post linked to blocked domains %s", strings.Join(domains, ", "))
+		if err := server.store.RecordBlockedLinks(ctx, domains...); err != nil {
+			log.Printf("Failed to record blocked links: %s", err)
+		}
+	}
+	return err
+}
+
+// handleGetBlockedDomains handles a GET request from an admin for every blocked domain and how often it's been blocked.
+func (server *Server) handleGetBlockedDomains(ctx context.Context, req *request, res *response) {
+	domains, err := server.store.GetBlockedDomains(ctx)
+	if err != nil {
+		res.Fail("Failed to get blocked domains", err)
+		return
+	}
+	res.Respond(http.StatusOK, domains, "")
+}
+
+// handleBlockDomain handles a PUT request from an admin blocking links to a domain, or changing what happens to them.
+func (server *Server) handleBlockDomain(ctx context.Context, req *request, res *response) {
+	domain, err := validation.ValidateDomain(req.params.ByName("domain"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	incoming, err := getIncomingBlockedDomain(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	blocked, err := server.store.BlockDomain(ctx, domain, data.DomainAction(incoming.Action))
+	if err != nil {
+		res.Fail("Failed to block domain", err)
+		return
+	}
+	log.Printf("Links to %s set to %s by %s", domain, incoming.Action, req.user.Email)
+	res.Respond(http.StatusOK, blocked, "")
+}
+
+// handleUnblockDomain handles a DELETE request from an admin letting posts link to a blocked domain again.
+func (server *Server) handleUnblockDomain(ctx context.Context, req *request, res *response) {
+	domain, err := validation.ValidateDomain(req.params.ByName("domain"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = server.store.UnblockDomain(ctx, domain)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to unblock domain", err)
+		return
+	}
+	log.Printf("Links to %s unblocked by %s", domain, req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "unblocked"}, "")
+}
@@ -0,0 +1,78 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/auth"
+	"testing"
+)
+
+func TestBlockedDomains(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: admin}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("PUT", "/v1/admin/domains/*.spam.example", `{"action": "strip"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected wildcard domain blocked, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/admin/domains/Evil.Example", `{}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected domain blocked, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/admin/domains/-bad-.example", `{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid domain refused, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/admin/domains/ok.example", `{"action": "shrug"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown action refused, got: %d", rr.Code)
+	}
+
+	posts := []struct {
+		content      string
+		expectedCode int
+		// Content expected to be written, when it's accepted.
+		written string
+	}{
+		{"see https://evil.example/x", http.StatusBadRequest, ""},
+		{"buy at https://www.spam.example/buy now", http.StatusOK, "buy at  now"},
+		{"https://spam.example/", http.StatusBadRequest, ""},
+		{"see https://www.evil.example and https://fine.example", http.StatusOK, "see https://www.evil.example and https://fine.example"},
+	}
+	for _, post := range posts {
+		rr := client.do("POST", "/v1/categories/cat/1", `{"content": "`+post.content+`"}`)
+		if rr.Code != post.expectedCode {
+			t.Fatalf("%q: expected status %d, got: %d", post.content, post.expectedCode, rr.Code)
+		}
+		if rr.Code != http.StatusOK {
+			var rejected rejection
+			if err := json.NewDecoder(rr.Body).Decode(&rejected); err != nil || rejected.Code != "blocked_domain" {
+				t.Errorf("%q: expected blocked_domain, got %+v %v", post.content, rejected, err)
+			}
+			continue
+		}
+		if mockStore.wroteContent != post.written {
+			t.Errorf("%q: expected %q written, got %q", post.content, post.written, mockStore.wroteContent)
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, blocked := range mockStore.blockedDomains {
+		counts[blocked.Domain] = blocked.Blocked
+	}
+	if counts["*.spam.example"] != 2 || counts["evil.example"] != 1 {
+		t.Errorf("expected blocked posts counted against their domains, got %v", counts)
+	}
+
+	if rr := client.do("DELETE", "/v1/admin/domains/evil.example", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected domain unblocked, got: %d", rr.Code)
+	}
+	if rr := client.do("DELETE", "/v1/admin/domains/evil.example", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected unblocking twice to 404, got: %d", rr.Code)
+	}
+
+	mockAuth.user = moderator("mod@gmail.com")
+	if rr := client.do("PUT", "/v1/admin/domains/other.example", `{}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected moderators refused, got: %d", rr.Code)
+	}
+}
@@ -3,16 +3,51 @@ package serve
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"spiritchat/data"
+	"spiritchat/media"
+	"spiritchat/notify"
 	"spiritchat/validation"
+	"strings"
+	"time"
 )
 
 var errNoData = errors.New("no data provided")
 var errBadJson = errors.New("bad JSON")
+var errAttachmentRequired = errors.New("threads here need an attachment")
+var errTooManyAttachments = errors.New("posts here can't have that many attachments")
+var errAttachmentNotAllowed = errors.New("attachment is too large or of a type this category doesn't take")
+var errInvalidPost = errors.New("invalid post")
+var errReportTarget = errors.New("report must be on a post")
+var errNoteTarget = errors.New("note must be on either a post or a poster")
+var errNegativeBan = errors.New("ban can't be negative")
+var errNegativeLockDays = errors.New("days before locking inactive threads can't be negative")
+var errUsernamePolicy = errors.New("usernames must be choice, always or anonymous")
+
+// Most attachments a single post can carry.
+const maxPostAttachments = 4
+
+// Largest reply body read, far more than the longest valid post needs.
+const maxReplyBody = 1 << 16
 
 type incomingReply struct {
 	Subject string `json:"subject"`
 	Content string `json:"content"`
+	// Staff role to post with, if any.
+	Capcode string `json:"capcode"`
+	// Set by clients once the poster's accepted the category's rules.
+	AcceptedRules bool `json:"acceptedRules"`
+	// Type of thread, defaults to a discussion.
+	Type string `json:"type"`
+	// IDs of the poster's uploads to attach.
+	Attachments []int `json:"attachments"`
+	// IDs of the attachments to hide behind a placeholder.
+	Spoilers []int `json:"spoilers"`
+	NSFW     []int `json:"nsfw"`
 }
 
 func getIncomingReply(body io.ReadCloser) (*incomingReply, error) {
@@ -20,36 +55,175 @@ func getIncomingReply(body io.ReadCloser) (*incomingReply, error) {
 		return nil, errNoData
 	}
 	ir := &incomingReply{}
-	err := json.NewDecoder(body).Decode(ir)
+	err := json.NewDecoder(io.LimitReader(body, maxReplyBody)).Decode(ir)
 	if err != nil {
 		return nil, errBadJson
 	}
 	return ir, nil
 }
 
-func (ir *incomingReply) Sanitize(isThread bool) error {
+/*
+Sanitize validates the reply against the category's post policy, and drops repeated attachments.
+Content can be left empty if the policy allows it and there's an attachment.
+*/
+func (ir *incomingReply) Sanitize(isThread bool, policy *data.PostPolicy) error {
+	seen := make(map[int]bool, len(ir.Attachments))
+	unique := ir.Attachments[:0]
+	for _, id := range ir.Attachments {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	ir.Attachments = unique
+	if len(ir.Attachments) > maxPostAttachments {
+		return fmt.Errorf("posts can't have more than %d attachments", maxPostAttachments)
+	}
+	for _, id := range append(append([]int{}, ir.Spoilers...), ir.NSFW...) {
+		if !seen[id] {
+			return errors.New("only the post's own attachments can be spoilers or NSFW")
+		}
+	}
+	attachments := len(ir.Attachments)
+	if policy.Uploads.MaxFiles > 0 && attachments > policy.Uploads.MaxFiles {
+		return errTooManyAttachments
+	}
+
+	if isThread && policy.RequireAttachment && attachments == 0 {
+		return errAttachmentRequired
+	}
+
 	subject, err := validation.ValidateReplySubject(ir.Subject, isThread)
 	if err != nil {
 		return err
 	}
 
-	content, err := validation.ValidateReplyContent(ir.Content)
+	var content string
+	if !policy.AllowEmptyContentWithAttachment || attachments == 0 || len(strings.TrimSpace(ir.Content)) > 0 {
+		content, err = validation.ValidateReplyContent(ir.Content)
+		if err != nil {
+			return err
+		}
+	}
+	err = validation.ValidateLinks(content, policy.MaxLinks, policy.RejectLinkOnly)
 	if err != nil {
 		return err
 	}
 
+	switch data.ThreadType(ir.Type) {
+	case "", data.ThreadDiscussion:
+	case data.ThreadQuestion:
+		if !isThread {
+			return errors.New("only threads can be questions")
+		}
+	default:
+		return data.ErrUnknownThreadType
+	}
+
 	ir.Subject = subject
 	ir.Content = content
 	return nil
 }
 
+// attachmentRefs returns the reply's attachments along with how they're to be shown.
+func (ir *incomingReply) attachmentRefs() []data.AttachmentRef {
+	refs := make([]data.AttachmentRef, len(ir.Attachments))
+	for i, id := range ir.Attachments {
+		refs[i].ID = id
+		for _, spoiler := range ir.Spoilers {
+			refs[i].Spoiler = refs[i].Spoiler || spoiler == id
+		}
+		for _, nsfw := range ir.NSFW {
+			refs[i].NSFW = refs[i].NSFW || nsfw == id
+		}
+	}
+	return refs
+}
+
+func getIncomingAttachmentFlags(body io.ReadCloser) (*data.AttachmentFlags, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+	flags := &data.AttachmentFlags{}
+	err := json.NewDecoder(body).Decode(flags)
+	if err != nil {
+		return nil, errBadJson
+	}
+	if flags.Spoiler == nil && flags.NSFW == nil {
+		return nil, errNoData
+	}
+	return flags, nil
+}
+
+// incomingSocialCallback is the authorization code a social login came back with.
+type incomingSocialCallback struct {
+	Code string `json:"code"`
+}
+
+func getIncomingSocialCallback(body io.ReadCloser) (*incomingSocialCallback, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+	callback := &incomingSocialCallback{}
+	err := json.NewDecoder(body).Decode(callback)
+	if err != nil {
+		return nil, errBadJson
+	}
+	if len(callback.Code) == 0 {
+		return nil, errNoData
+	}
+	return callback, nil
+}
+
+// incomingLogin is a username or email and password to log in with.
+type incomingLogin struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func getIncomingLogin(body io.ReadCloser) (*incomingLogin, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+	login := &incomingLogin{}
+	err := json.NewDecoder(body).Decode(login)
+	if err != nil {
+		return nil, errBadJson
+	}
+	login.Username = strings.TrimSpace(login.Username)
+	if len(login.Username) == 0 || len(login.Password) == 0 {
+		return nil, errNoData
+	}
+	return login, nil
+}
+
+// incomingRefresh is a refresh token to exchange for a new access token.
+type incomingRefresh struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func getIncomingRefresh(body io.ReadCloser) (*incomingRefresh, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+	refresh := &incomingRefresh{}
+	err := json.NewDecoder(body).Decode(refresh)
+	if err != nil {
+		return nil, errBadJson
+	}
+	if len(refresh.RefreshToken) == 0 {
+		return nil, errNoData
+	}
+	return refresh, nil
+}
+
 type incomingSignup struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Email    string `json:"email"`
 }
 
-func (is *incomingSignup) Sanitize() error {
+func (is *incomingSignup) Sanitize(reserved *validation.ReservedNames) error {
 	email, err := validation.ValidateEmail(is.Email)
 	if err != nil {
 		return err
@@ -58,7 +232,7 @@ func (is *incomingSignup) Sanitize() error {
 	if err != nil {
 		return err
 	}
-	username, err := validation.ValidateUsername(is.Username)
+	username, err := validation.ValidateUsername(is.Username, reserved)
 	if err != nil {
 		return err
 	}
@@ -80,3 +254,963 @@ func getIncomingSignup(body io.ReadCloser) (*incomingSignup, error) {
 	}
 	return is, nil
 }
+
+type incomingPreferences struct {
+	data.PreferencesUpdate
+}
+
+func (ip *incomingPreferences) Sanitize(reserved *validation.ReservedNames) error {
+	if ip.DisplayName != nil {
+		name, err := validation.ValidateDisplayName(*ip.DisplayName, reserved)
+		if err != nil {
+			return err
+		}
+		ip.DisplayName = &name
+	}
+	if ip.Timezone != nil {
+		timezone, err := validation.ValidateTimezone(*ip.Timezone)
+		if err != nil {
+			return err
+		}
+		ip.Timezone = &timezone
+	}
+	return nil
+}
+
+func getIncomingPreferences(body io.ReadCloser) (*incomingPreferences, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ip := &incomingPreferences{}
+	err := json.NewDecoder(body).Decode(ip)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ip, nil
+}
+
+type incomingMessage struct {
+	// Username of the recipient.
+	To      string `json:"to"`
+	Content string `json:"content"`
+}
+
+func (im *incomingMessage) Sanitize() error {
+	im.To = strings.TrimSpace(im.To)
+	if len(im.To) == 0 {
+		return errors.New("message needs a recipient")
+	}
+	content, err := validation.ValidateMessage(im.Content)
+	if err != nil {
+		return err
+	}
+	im.Content = content
+	return nil
+}
+
+func getIncomingMessage(body io.ReadCloser) (*incomingMessage, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	im := &incomingMessage{}
+	err := json.NewDecoder(body).Decode(im)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return im, nil
+}
+
+type incomingShareLink struct {
+	// Slug of the thread's category.
+	Cat    string `json:"cat"`
+	Thread int    `json:"thread"`
+	Post   int    `json:"post"`
+}
+
+func (is *incomingShareLink) Sanitize() error {
+	if len(is.Cat) == 0 || is.Thread < 1 || is.Post < 0 {
+		return errors.New("share links need a category and thread, and optionally a post")
+	}
+	return nil
+}
+
+func getIncomingShareLink(body io.ReadCloser) (*incomingShareLink, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	is := &incomingShareLink{}
+	err := json.NewDecoder(body).Decode(is)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return is, nil
+}
+
+type incomingClientKey struct {
+	// Who the key's for, like the archive it's issued to.
+	Label string `json:"label"`
+}
+
+func (ic *incomingClientKey) Sanitize() error {
+	ic.Label = strings.TrimSpace(ic.Label)
+	if len(ic.Label) == 0 || len(ic.Label) > 100 {
+		return errors.New("client keys need a label of up to 100 characters")
+	}
+	return nil
+}
+
+func getIncomingClientKey(body io.ReadCloser) (*incomingClientKey, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ic := &incomingClientKey{}
+	err := json.NewDecoder(body).Decode(ic)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ic, nil
+}
+
+type incomingBlockedDomain struct {
+	// reject or strip, defaults to reject.
+	Action string `json:"action"`
+}
+
+func (ib *incomingBlockedDomain) Sanitize() error {
+	if len(ib.Action) == 0 {
+		ib.Action = string(data.DomainReject)
+	}
+	if ib.Action != string(data.DomainReject) && ib.Action != string(data.DomainStrip) {
+		return errors.New("action must be reject or strip")
+	}
+	return nil
+}
+
+func getIncomingBlockedDomain(body io.ReadCloser) (*incomingBlockedDomain, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ib := &incomingBlockedDomain{}
+	err := json.NewDecoder(body).Decode(ib)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ib, nil
+}
+
+type incomingImpersonation struct {
+	UserID string `json:"userId"`
+	// read or write, defaults to read.
+	Scope   string `json:"scope"`
+	Minutes int    `json:"minutes"`
+	// Why the user's being impersonated, kept in the audit trail.
+	Reason string `json:"reason"`
+}
+
+func (ii *incomingImpersonation) Sanitize() error {
+	ii.UserID = strings.TrimSpace(ii.UserID)
+	if len(ii.UserID) == 0 {
+		return errors.New("no user to impersonate")
+	}
+	switch ii.Scope {
+	case "":
+		ii.Scope = data.ScopeRead
+	case data.ScopeRead, data.ScopeWrite:
+	default:
+		return errors.New("scope must be read or write")
+	}
+	if ii.Minutes == 0 {
+		ii.Minutes = defaultImpersonationMinutes
+	}
+	if ii.Minutes < 1 || ii.Minutes > maxImpersonationMinutes {
+		return fmt.Errorf("impersonations last between 1 and %d minutes", maxImpersonationMinutes)
+	}
+	reason, err := validation.ValidateNote(ii.Reason)
+	if err != nil {
+		return err
+	}
+	ii.Reason = reason
+	return nil
+}
+
+func getIncomingImpersonation(body io.ReadCloser) (*incomingImpersonation, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ii := &incomingImpersonation{}
+	err := json.NewDecoder(body).Decode(ii)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ii, nil
+}
+
+type incomingReport struct {
+	Cat    string `json:"cat"`
+	Num    int    `json:"num"`
+	Reason string `json:"reason"`
+}
+
+func (ir *incomingReport) Sanitize() error {
+	if len(ir.Cat) == 0 || ir.Num < 1 {
+		return errReportTarget
+	}
+	reason, err := validation.ValidateReportReason(ir.Reason)
+	if err != nil {
+		return err
+	}
+	ir.Reason = reason
+	return nil
+}
+
+func getIncomingReport(body io.ReadCloser) (*incomingReport, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ir := &incomingReport{}
+	err := json.NewDecoder(body).Decode(ir)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ir, nil
+}
+
+// Most rules a category can have.
+const maxCategoryRules = 50
+const maxMaskedWords = 200
+
+type incomingCategoryRules struct {
+	Rules []string `json:"rules"`
+}
+
+func (icr *incomingCategoryRules) Sanitize() error {
+	if len(icr.Rules) > maxCategoryRules {
+		return fmt.Errorf("categories can have at most %d rules", maxCategoryRules)
+	}
+	for i, rule := range icr.Rules {
+		rule, err := validation.ValidateCategoryRule(rule)
+		if err != nil {
+			return err
+		}
+		icr.Rules[i] = rule
+	}
+	return nil
+}
+
+func getIncomingCategoryRules(body io.ReadCloser) (*incomingCategoryRules, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	icr := &incomingCategoryRules{}
+	err := json.NewDecoder(body).Decode(icr)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return icr, nil
+}
+
+type incomingCategoryMasks struct {
+	Words []string `json:"words"`
+}
+
+func (icm *incomingCategoryMasks) Sanitize() error {
+	if len(icm.Words) > maxMaskedWords {
+		return fmt.Errorf("categories can have at most %d masked words", maxMaskedWords)
+	}
+	for i, word := range icm.Words {
+		word, err := validation.ValidateMaskedWord(word)
+		if err != nil {
+			return err
+		}
+		icm.Words[i] = word
+	}
+	return nil
+}
+
+func getIncomingCategoryMasks(body io.ReadCloser) (*incomingCategoryMasks, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	icm := &incomingCategoryMasks{}
+	err := json.NewDecoder(body).Decode(icm)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return icm, nil
+}
+
+type incomingPostPolicy struct {
+	MaxLinks                        int                 `json:"maxLinks"`
+	RejectLinkOnly                  bool                `json:"rejectLinkOnly"`
+	RequireAttachment               bool                `json:"requireAttachment"`
+	AllowEmptyContentWithAttachment bool                `json:"allowEmptyContentWithAttachment"`
+	Uploads                         data.UploadPolicy   `json:"uploads"`
+	Usernames                       data.UsernamePolicy `json:"usernames"`
+}
+
+func (ipp *incomingPostPolicy) Sanitize() error {
+	if ipp.MaxLinks < 0 {
+		return errors.New("max links can't be negative")
+	}
+	if ipp.Uploads.MaxFileBytes < 0 || ipp.Uploads.QuotaBytes < 0 {
+		return errors.New("file sizes and quotas can't be negative")
+	}
+	if ipp.Uploads.MaxFiles < 0 || ipp.Uploads.MaxFiles > maxPostAttachments {
+		return fmt.Errorf("max files must be between 0 and %d", maxPostAttachments)
+	}
+	allowed := make([]string, 0, len(ipp.Uploads.AllowedTypes))
+	seen := make(map[string]bool, len(ipp.Uploads.AllowedTypes))
+	for _, mime := range ipp.Uploads.AllowedTypes {
+		mime = strings.ToLower(strings.TrimSpace(mime))
+		if !media.Accepts(mime) {
+			return fmt.Errorf("%q can't be uploaded", mime)
+		}
+		if !seen[mime] {
+			seen[mime] = true
+			allowed = append(allowed, mime)
+		}
+	}
+	ipp.Uploads.AllowedTypes = allowed
+	if len(ipp.Usernames) == 0 {
+		ipp.Usernames = data.UsernamesChoice
+	}
+	if !ipp.Usernames.Valid() {
+		return errUsernamePolicy
+	}
+	return nil
+}
+
+func getIncomingPostPolicy(body io.ReadCloser) (*incomingPostPolicy, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ipp := &incomingPostPolicy{}
+	err := json.NewDecoder(body).Decode(ipp)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ipp, nil
+}
+
+type incomingCategorySlug struct {
+	Slug string `json:"slug"`
+}
+
+func (ics *incomingCategorySlug) Sanitize() error {
+	slug, err := validation.ValidateCategorySlug(ics.Slug)
+	if err != nil {
+		return err
+	}
+	ics.Slug = slug
+	return nil
+}
+
+func getIncomingCategorySlug(body io.ReadCloser) (*incomingCategorySlug, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ics := &incomingCategorySlug{}
+	err := json.NewDecoder(body).Decode(ics)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ics, nil
+}
+
+type incomingCategory struct {
+	Tag  string `json:"tag"`
+	Name string `json:"name"`
+}
+
+func (ic *incomingCategory) Sanitize() error {
+	tag, err := validation.ValidateCategoryTag(ic.Tag)
+	if err != nil {
+		return err
+	}
+	name, err := validation.ValidateCategoryName(ic.Name)
+	if err != nil {
+		return err
+	}
+	ic.Tag, ic.Name = tag, name
+	return nil
+}
+
+func getIncomingCategory(body io.ReadCloser) (*incomingCategory, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ic := &incomingCategory{}
+	err := json.NewDecoder(body).Decode(ic)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ic, nil
+}
+
+type incomingCategoryListing struct {
+	SortOrder int  `json:"sortOrder"`
+	Featured  bool `json:"featured"`
+}
+
+func getIncomingCategoryListing(body io.ReadCloser) (*incomingCategoryListing, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	icl := &incomingCategoryListing{}
+	err := json.NewDecoder(body).Decode(icl)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return icl, nil
+}
+
+// incomingCategoryUpdate is a partial edit to a category, made against the version it names.
+type incomingCategoryUpdate struct {
+	data.CategoryUpdate
+	Version *int `json:"version"`
+}
+
+func (icu *incomingCategoryUpdate) Sanitize() error {
+	if icu.Name != nil {
+		name, err := validation.ValidateCategoryName(*icu.Name)
+		if err != nil {
+			return err
+		}
+		icu.Name = &name
+	}
+	if icu.Description != nil {
+		description, err := validation.ValidateCategoryDescription(*icu.Description)
+		if err != nil {
+			return err
+		}
+		icu.Description = &description
+	}
+	if icu.LockInactiveDays != nil && *icu.LockInactiveDays < 0 {
+		return errNegativeLockDays
+	}
+	return nil
+}
+
+func getIncomingCategoryUpdate(body io.ReadCloser) (*incomingCategoryUpdate, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	icu := &incomingCategoryUpdate{}
+	err := json.NewDecoder(body).Decode(icu)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return icu, nil
+}
+
+// incomingAnonymization is when a category's posts are anonymized up to.
+type incomingAnonymization struct {
+	Before time.Time `json:"before"`
+}
+
+func getIncomingAnonymization(body io.ReadCloser) (*incomingAnonymization, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ia := &incomingAnonymization{}
+	err := json.NewDecoder(body).Decode(ia)
+	if err != nil {
+		return nil, errBadJson
+	}
+	if ia.Before.IsZero() {
+		return nil, errors.New("before must be set to when posts are anonymized up to")
+	}
+	return ia, nil
+}
+
+type incomingCategoryArchived struct {
+	Archived bool `json:"archived"`
+}
+
+func getIncomingCategoryArchived(body io.ReadCloser) (*incomingCategoryArchived, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ica := &incomingCategoryArchived{}
+	err := json.NewDecoder(body).Decode(ica)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ica, nil
+}
+
+type incomingPage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+func (ip *incomingPage) Sanitize() error {
+	title, body, err := validation.ValidatePage(ip.Title, ip.Body)
+	if err != nil {
+		return err
+	}
+	ip.Title = title
+	ip.Body = body
+	return nil
+}
+
+func getIncomingPage(body io.ReadCloser) (*incomingPage, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ip := &incomingPage{}
+	err := json.NewDecoder(body).Decode(ip)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ip, nil
+}
+
+// Most posts a single complaint can name.
+const maxComplaintPosts = 50
+
+type incomingComplaint struct {
+	Kind    string         `json:"kind"`
+	Name    string         `json:"name"`
+	Email   string         `json:"email"`
+	Posts   []data.PostRef `json:"posts"`
+	Details string         `json:"details"`
+	// Takedown notices also describe the copyrighted work, and are signed.
+	Work      string `json:"work"`
+	Signature string `json:"signature"`
+	// Takedown notices must state the use isn't authorized in good faith, and that the notice is accurate
+	// and they're acting for the owner under penalty of perjury.
+	GoodFaith bool `json:"goodFaith"`
+	Accurate  bool `json:"accurate"`
+}
+
+func (ic *incomingComplaint) Sanitize() error {
+	kind := data.ComplaintKind(ic.Kind)
+	if kind != data.ComplaintAbuse && kind != data.ComplaintDMCA {
+		return errors.New("complaint kind must be abuse or dmca")
+	}
+	if len(ic.Posts) == 0 || len(ic.Posts) > maxComplaintPosts {
+		return fmt.Errorf("complaints must name between 1 and %d posts", maxComplaintPosts)
+	}
+	for _, ref := range ic.Posts {
+		if len(ref.Cat) == 0 || ref.Num < 1 {
+			return errInvalidPost
+		}
+	}
+	name, err := validation.ValidateComplainantName(ic.Name)
+	if err != nil {
+		return err
+	}
+	ic.Name = name
+	email, err := validation.ValidateEmail(strings.TrimSpace(ic.Email))
+	if err != nil {
+		return err
+	}
+	ic.Email = email
+	details, err := validation.ValidateComplaint(ic.Details)
+	if err != nil {
+		return err
+	}
+	ic.Details = details
+
+	if kind != data.ComplaintDMCA {
+		ic.Work, ic.Signature = "", ""
+		return nil
+	}
+	work, err := validation.ValidateComplaint(ic.Work)
+	if err != nil {
+		return fmt.Errorf("copyrighted work: %w", err)
+	}
+	ic.Work = work
+	signature, err := validation.ValidateComplainantName(ic.Signature)
+	if err != nil {
+		return errors.New("takedown notices must be signed")
+	}
+	ic.Signature = signature
+	if !ic.GoodFaith || !ic.Accurate {
+		return errors.New("takedown notices must state they're made in good faith, and are accurate under penalty of perjury")
+	}
+	return nil
+}
+
+func getIncomingComplaint(body io.ReadCloser) (*incomingComplaint, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ic := &incomingComplaint{}
+	err := json.NewDecoder(body).Decode(ic)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ic, nil
+}
+
+type incomingNote struct {
+	// Post to note, or the poster hash to note.
+	Cat        string `json:"cat"`
+	Num        int    `json:"num"`
+	PosterHash string `json:"posterHash"`
+	Content    string `json:"content"`
+}
+
+func (in *incomingNote) Sanitize() error {
+	onPost := len(in.Cat) > 0 || in.Num != 0
+	if onPost == (len(in.PosterHash) > 0) {
+		return errNoteTarget
+	}
+	if onPost && (len(in.Cat) == 0 || in.Num < 1) {
+		return errInvalidPost
+	}
+	if !onPost {
+		posterHash, err := validation.ValidatePosterHash(in.PosterHash)
+		if err != nil {
+			return err
+		}
+		in.PosterHash = posterHash
+	}
+	content, err := validation.ValidateNote(in.Content)
+	if err != nil {
+		return err
+	}
+	in.Content = content
+	return nil
+}
+
+func getIncomingNote(body io.ReadCloser) (*incomingNote, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	in := &incomingNote{}
+	err := json.NewDecoder(body).Decode(in)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return in, nil
+}
+
+type incomingBestAnswer struct {
+	// Reply to mark, zero clears the best answer.
+	Num int `json:"num"`
+}
+
+func (in *incomingBestAnswer) Sanitize() error {
+	if in.Num < 0 {
+		return errors.New("invalid reply")
+	}
+	return nil
+}
+
+func getIncomingBestAnswer(body io.ReadCloser) (*incomingBestAnswer, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	in := &incomingBestAnswer{}
+	err := json.NewDecoder(body).Decode(in)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return in, nil
+}
+
+type incomingResolution struct {
+	Action string `json:"action"`
+	// Ban reason and length, zero days bans permanently.
+	Reason  string `json:"reason"`
+	BanDays int    `json:"banDays"`
+}
+
+func (ir *incomingResolution) Sanitize() error {
+	switch data.ModerationAction(ir.Action) {
+	case data.ActionApprove, data.ActionDelete, data.ActionBan:
+	default:
+		return data.ErrUnknownAction
+	}
+	if ir.BanDays < 0 {
+		return errNegativeBan
+	}
+	ir.Reason = strings.TrimSpace(ir.Reason)
+	return nil
+}
+
+func (ir *incomingResolution) resolution() data.Resolution {
+	return data.Resolution{
+		Action: data.ModerationAction(ir.Action),
+		Reason: ir.Reason,
+		BanFor: time.Duration(ir.BanDays) * time.Hour * 24,
+	}
+}
+
+func getIncomingResolution(body io.ReadCloser) (*incomingResolution, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ir := &incomingResolution{}
+	err := json.NewDecoder(body).Decode(ir)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ir, nil
+}
+
+// Most actions accepted in one bulk request.
+const maxBulkActions = 500
+
+type incomingBulkActions struct {
+	DeletePosts []data.PostRef `json:"deletePosts"`
+	// IPs, or poster hashes of IPs or emails.
+	BanIPs      []string       `json:"banIPs"`
+	LockThreads []data.PostRef `json:"lockThreads"`
+	BanReason   string         `json:"banReason"`
+	BanDays     int            `json:"banDays"`
+}
+
+func (iba *incomingBulkActions) Sanitize() error {
+	count := len(iba.DeletePosts) + len(iba.BanIPs) + len(iba.LockThreads)
+	if count == 0 {
+		return errNoData
+	}
+	if count > maxBulkActions {
+		return fmt.Errorf("at most %d actions can be taken at once", maxBulkActions)
+	}
+	if iba.BanDays < 0 {
+		return errNegativeBan
+	}
+	iba.BanReason = strings.TrimSpace(iba.BanReason)
+	return nil
+}
+
+// actions returns the batch for the store, hashing any IPs given to ban.
+func (iba *incomingBulkActions) actions() (data.BulkActions, error) {
+	actions := data.BulkActions{
+		DeletePosts: iba.DeletePosts,
+		LockThreads: iba.LockThreads,
+		BanReason:   iba.BanReason,
+		BanFor:      time.Duration(iba.BanDays) * time.Hour * 24,
+	}
+	for _, target := range iba.BanIPs {
+		if net.ParseIP(target) != nil {
+			actions.BanPosters = append(actions.BanPosters, data.PosterHash(target))
+			continue
+		}
+		posterHash, err := validation.ValidatePosterHash(target)
+		if err != nil {
+			return data.BulkActions{}, fmt.Errorf("can't ban %q: %w", target, err)
+		}
+		actions.BanPosters = append(actions.BanPosters, posterHash)
+	}
+	return actions, nil
+}
+
+func getIncomingBulkActions(body io.ReadCloser) (*incomingBulkActions, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	iba := &incomingBulkActions{}
+	err := json.NewDecoder(body).Decode(iba)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return iba, nil
+}
+
+type incomingRule struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Threshold   int    `json:"threshold"`
+	WindowHours int    `json:"windowHours"`
+	Pattern     string `json:"pattern"`
+	Fold        bool   `json:"fold"`
+	Action      string `json:"action"`
+	// Zero bans permanently.
+	BanHours int  `json:"banHours"`
+	Enabled  bool `json:"enabled"`
+}
+
+func (ir *incomingRule) Sanitize() error {
+	ir.Name = strings.TrimSpace(ir.Name)
+	if len(ir.Name) == 0 || len(ir.Name) > 100 {
+		return errors.New("rule name must be between 1 and 100 characters")
+	}
+	if ir.BanHours < 0 {
+		return errNegativeBan
+	}
+
+	switch data.RuleKind(ir.Kind) {
+	case data.RuleDeletedPosts:
+		if data.ModerationAction(ir.Action) != data.ActionBan {
+			return errors.New("deleted posts rules can only ban")
+		}
+		if ir.Threshold < 1 || ir.WindowHours < 1 {
+			return errors.New("deleted posts rules need a threshold and window")
+		}
+		ir.Pattern, ir.Fold = "", false
+	case data.RuleContentMatch:
+		switch data.ModerationAction(ir.Action) {
+		case data.ActionBan, data.ActionQuarantine:
+		default:
+			return data.ErrUnknownAction
+		}
+		if len(ir.Pattern) == 0 {
+			return errors.New("content match rules need a pattern")
+		}
+		if _, err := regexp.Compile(ir.Pattern); err != nil {
+			return fmt.Errorf("bad pattern: %w", err)
+		}
+		ir.Threshold, ir.WindowHours = 0, 0
+	default:
+		return errors.New("unknown rule kind")
+	}
+	return nil
+}
+
+func (ir *incomingRule) rule() *data.Rule {
+	return &data.Rule{
+		Name:        ir.Name,
+		Kind:        data.RuleKind(ir.Kind),
+		Threshold:   ir.Threshold,
+		WindowHours: ir.WindowHours,
+		Pattern:     ir.Pattern,
+		Fold:        ir.Fold,
+		Action:      data.ModerationAction(ir.Action),
+		BanHours:    ir.BanHours,
+		Enabled:     ir.Enabled,
+	}
+}
+
+func getIncomingRule(body io.ReadCloser) (*incomingRule, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ir := &incomingRule{}
+	err := json.NewDecoder(body).Decode(ir)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return ir, nil
+}
+
+// Longest webhook payload template.
+const maxWebhookTemplate = 4000
+
+type incomingWebhook struct {
+	URL string `json:"url"`
+	// Slug of the only category events are sent from, empty for every category.
+	Cat    string   `json:"cat"`
+	Events []string `json:"events"`
+	// json, discord or template, defaults to json.
+	Format   string `json:"format"`
+	Template string `json:"template"`
+	// Signs payloads when set. Left empty on updates, the webhook keeps its secret.
+	Secret  string `json:"secret"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (iw *incomingWebhook) Sanitize() error {
+	u, err := url.Parse(iw.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return errors.New("webhooks need an http or https URL")
+	}
+	if len(iw.Secret) > 200 {
+		return errors.New("webhook secrets can't be longer than 200 characters")
+	}
+
+	seen := make(map[string]bool)
+	kinds := make([]string, 0, len(iw.Events))
+	for _, kind := range iw.Events {
+		known := false
+		for _, webhookKind := range notify.WebhookKinds {
+			known = known || kind == string(webhookKind)
+		}
+		if !known {
+			return fmt.Errorf("webhooks can't be sent %q events", kind)
+		}
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	iw.Events = kinds
+
+	if len(iw.Format) == 0 {
+		iw.Format = string(data.WebhookJSON)
+	}
+	switch data.WebhookFormat(iw.Format) {
+	case data.WebhookJSON, data.WebhookDiscord:
+		iw.Template = ""
+	case data.WebhookTemplate:
+		if len(iw.Template) == 0 || len(iw.Template) > maxWebhookTemplate {
+			return fmt.Errorf("template webhooks need a template of up to %d characters", maxWebhookTemplate)
+		}
+		if _, err := notify.ParseWebhookTemplate(iw.Template); err != nil {
+			return fmt.Errorf("bad template: %w", err)
+		}
+	default:
+		return errors.New("unknown webhook format")
+	}
+	return nil
+}
+
+func (iw *incomingWebhook) webhook() *data.Webhook {
+	return &data.Webhook{
+		URL:      iw.URL,
+		Events:   iw.Events,
+		Format:   data.WebhookFormat(iw.Format),
+		Template: iw.Template,
+		Secret:   iw.Secret,
+		Enabled:  iw.Enabled,
+	}
+}
+
+func getIncomingWebhook(body io.ReadCloser) (*incomingWebhook, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	iw := &incomingWebhook{}
+	err := json.NewDecoder(body).Decode(iw)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return iw, nil
+}
+
+type incomingTOTPCode struct {
+	Code string `json:"code"`
+}
+
+func getIncomingTOTPCode(body io.ReadCloser) (*incomingTOTPCode, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	ic := &incomingTOTPCode{}
+	err := json.NewDecoder(body).Decode(ic)
+	if err != nil {
+		return nil, errBadJson
+	}
+	ic.Code = strings.ReplaceAll(ic.Code, " ", "")
+	return ic, nil
+}
@@ -0,0 +1,48 @@
+package serve
+
+import (
+	"io"
+	"spiritchat/data"
+	"strings"
+	"testing"
+)
+
+func TestGetIncomingReplyTooLarge(t *testing.T) {
+	body := `{"content": "` + strings.Repeat("a", maxReplyBody) + `"}`
+	_, err := getIncomingReply(io.NopCloser(strings.NewReader(body)))
+	if err != errBadJson {
+		t.Errorf("expected errBadJson, got: %v", err)
+	}
+}
+
+func FuzzGetIncomingReply(f *testing.F) {
+	for _, seed := range []string{
+		`{"subject": "hello there", "content": "general kenobi"}`,
+		`{"content": "hi", "attachments": [1, 1, 2], "spoilers": [2], "nsfw": [1]}`,
+		`{"content": "", "attachments": [5], "type": "question"}`,
+		`{"content": 5}`,
+		`[`,
+	} {
+		f.Add(seed, true)
+	}
+	policy := &data.PostPolicy{MaxLinks: 2, RejectLinkOnly: true, AllowEmptyContentWithAttachment: true}
+	f.Fuzz(func(t *testing.T, body string, isThread bool) {
+		ir, err := getIncomingReply(io.NopCloser(strings.NewReader(body)))
+		if err != nil {
+			return
+		}
+		if ir.Sanitize(isThread, policy) != nil {
+			return
+		}
+		if len(ir.Attachments) > maxPostAttachments {
+			t.Errorf("%d attachments", len(ir.Attachments))
+		}
+		if !isThread && len(ir.Subject) > 0 {
+			t.Errorf("reply kept subject %q", ir.Subject)
+		}
+		if len(ir.Content) == 0 && len(ir.Attachments) == 0 {
+			t.Error("empty post without attachments")
+		}
+		ir.attachmentRefs()
+	})
+}
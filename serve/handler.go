@@ -2,12 +2,17 @@ package serve
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"regexp"
 	"spiritchat/auth"
+	"spiritchat/data"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -18,14 +23,53 @@ type request struct {
 	header     http.Header
 	ip         string // Priority: X-Forwarded-For > X-Real-IP -> Remote Addr
 	user       *auth.UserData
+	// Login session the request's token was issued for, nil if it wasn't issued by logging in here.
+	session   *data.Session
+	spamScore int
+	// Identifies the request in logs and error reports, sent back in X-Request-ID.
+	id string
+	// Method and path pattern of the route handling the request.
+	route string
+	// Whether the request only asks what it would do, set on routes whose handler supports dry runs.
+	dryRun bool
 }
 
 type response struct {
 	rw http.ResponseWriter
+	// Added to server error messages when set.
+	contactEmail string
+	// Translates messages into the language the request asked for, messages are left in English when nil.
+	translate func(message string) string
+	status    int
+	// Why the request failed, reported to the error tracker.
+	err error
+}
+
+/*
+Fail responds with a generic server error, logging and keeping the error that caused it, like "Failed to get thread".
+Queries that ran out of time get a gateway timeout instead.
+*/
+func (r *response) Fail(what string, err error) {
+	if errors.Is(err, data.ErrTimeout) {
+		r.Respond(http.StatusGatewayTimeout, nil, timeoutFailMessage)
+	} else {
+		r.Respond(http.StatusInternalServerError, nil, genericFailMessage)
+	}
+	log.Printf("%s: %s", what, err)
+	r.err = fmt.Errorf("%s: %w", what, err)
 }
 
 func (r *response) Respond(status int, jsonObj interface{}, message string) {
+	r.status = status
+	translate := r.translate
+	if translate == nil {
+		translate = func(message string) string { return message }
+	}
 	if jsonObj == nil {
+		message = translate(message)
+		if status >= http.StatusInternalServerError && len(r.contactEmail) > 0 {
+			message = message + " " + fmt.Sprintf(translate(contactMessage), r.contactEmail)
+		}
 		r.rw.Header().Set("content-type", "text/plain")
 		r.rw.WriteHeader(status)
 		_, err := fmt.Fprintln(r.rw, message)
@@ -35,6 +79,9 @@ func (r *response) Respond(status int, jsonObj interface{}, message string) {
 		return
 	}
 
+	if messages, ok := jsonObj.(localized); ok {
+		jsonObj = messages.localize(translate)
+	}
 	r.rw.Header().Set("content-type", "application/json")
 	r.rw.WriteHeader(status)
 	err := json.NewEncoder(r.rw).Encode(jsonObj)
@@ -43,6 +90,15 @@ func (r *response) Respond(status int, jsonObj interface{}, message string) {
 	}
 }
 
+// Request IDs passed in by a proxy are kept if they look safe to log.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // Simplified HTTP handler function
 type handlerFunc func(ctx context.Context, req *request, respond *response)
 
@@ -59,7 +115,13 @@ func makeHandler(handler handlerFunc) httprouter.Handle {
 			}
 		}
 
-		log.Printf("Request %s: %s from %s agent :%s", req.Method, req.URL.Path, ip, req.UserAgent())
+		id := req.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		rw.Header().Set("X-Request-ID", id)
+
+		log.Printf("Request %s %s: %s from %s agent :%s", id, req.Method, req.URL.Path, ip, req.UserAgent())
 
 		handler(
 			req.Context(),
@@ -68,6 +130,7 @@ func makeHandler(handler handlerFunc) httprouter.Handle {
 				params:     params,
 				rawRequest: req,
 				ip:         ip,
+				id:         id,
 			},
 			&response{
 				rw: rw,
@@ -3,8 +3,11 @@ package serve
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"spiritchat/data"
 	"testing"
 
 	"github.com/julienschmidt/httprouter"
@@ -48,6 +51,26 @@ func TestGenHandler(t *testing.T) {
 	}
 }
 
+func TestResponseFail(t *testing.T) {
+	tests := map[string]struct {
+		err    error
+		status int
+	}{
+		"Error":   {errors.New("conn closed"), http.StatusInternalServerError},
+		"Timeout": {fmt.Errorf("failed to get thread: %w", data.ErrTimeout), http.StatusGatewayTimeout},
+	}
+	for name, test := range tests {
+		recorder := httptest.NewRecorder()
+		makeHandler(func(ctx context.Context, req *request, res *response) {
+			res.Fail("Failed to get thread", test.err)
+		})(recorder, httptest.NewRequest("GET", "/", nil), nil)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got: %d", name, test.status, recorder.Code)
+		}
+	}
+}
+
 func TestHandlerIP(t *testing.T) {
 	var tests = map[string]string{
 		"X-FORWARDED-FOR": "44.5.512334.5",
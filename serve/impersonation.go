@@ -0,0 +1,149 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"strconv"
+	"time"
+)
+
+// Impersonations expire after the requested minutes, never more than the max.
+const defaultImpersonationMinutes = 15
+const maxImpersonationMinutes = 60
+
+// Scheme prefixing impersonation tokens in the Authorization header.
+const impersonationScheme = "Impersonate "
+
+// Most impersonations listed to admins.
+const maxImpersonations = 100
+
+// impersonationToken is an issued impersonation and the one-time view of its token.
+type impersonationToken struct {
+	*data.Impersonation
+	Token string `json:"token"`
+}
+
+// handleCreateImpersonation handles a POST request from an admin for a token to act as a user with.
+func (server *Server) handleCreateImpersonation(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingImpersonation(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	if incoming.UserID == req.user.ID {
+		res.Respond(http.StatusBadRequest, nil, "you can't impersonate yourself")
+		return
+	}
+
+	user, err := server.auth.GetUser(ctx, incoming.UserID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to look up user to impersonate", err)
+		return
+	}
+
+	imp := &data.Impersonation{
+		Admin:    req.user.Email,
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Scope:    incoming.Scope,
+		Reason:   incoming.Reason,
+		Expires:  time.Now().Add(time.Duration(incoming.Minutes) * time.Minute),
+	}
+	token, err := server.store.WriteImpersonation(ctx, imp)
+	if err != nil {
+		res.Fail("Failed to write impersonation", err)
+		return
+	}
+	log.Printf(
+		"Impersonation %d of %s (%s) issued to %s for %d minutes", imp.ID, user.Username, imp.Scope, imp.Admin, incoming.Minutes,
+	)
+	res.Respond(http.StatusOK, impersonationToken{Impersonation: imp, Token: token}, "")
+}
+
+// handleGetImpersonations handles a GET request from an admin for the latest impersonations.
+func (server *Server) handleGetImpersonations(ctx context.Context, req *request, res *response) {
+	impersonations, err := server.store.GetImpersonations(ctx, maxImpersonations)
+	if err != nil {
+		res.Fail("Failed to get impersonations", err)
+		return
+	}
+	res.Respond(http.StatusOK, impersonations, "")
+}
+
+// handleGetImpersonationActions handles a GET request from an admin for the requests made under an impersonation.
+func (server *Server) handleGetImpersonationActions(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid impersonation ID")
+		return
+	}
+
+	actions, err := server.store.GetImpersonationActions(ctx, id)
+	if err != nil {
+		res.Fail("Failed to get impersonation actions", err)
+		return
+	}
+	res.Respond(http.StatusOK, actions, "")
+}
+
+// handleRevokeImpersonation handles a DELETE request from an admin ending an impersonation early.
+func (server *Server) handleRevokeImpersonation(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid impersonation ID")
+		return
+	}
+
+	err = server.store.RevokeImpersonation(ctx, id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to revoke impersonation", err)
+		return
+	}
+	log.Printf("Impersonation %d revoked by %s", id, req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "revoked"}, "")
+}
+
+/*
+impersonatedUser looks up the user an impersonation token acts as, logging the request to its audit trail.
+Read scoped impersonations can only make GET requests. Impersonated users never carry staff roles.
+*/
+func (server *Server) impersonatedUser(ctx context.Context, req *request, token string) (*auth.UserData, int, string) {
+	imp, err := server.store.GetImpersonationByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			return nil, http.StatusUnauthorized, "impersonation expired or revoked"
+		}
+		log.Printf("Failed to look up impersonation: %s", err)
+		return nil, http.StatusInternalServerError, genericFailMessage
+	}
+	method := req.rawRequest.Method
+	if imp.Scope != data.ScopeWrite && method != http.MethodGet {
+		return nil, http.StatusForbidden, "this impersonation is read only"
+	}
+	// Nothing happens under an impersonation without it being audited.
+	err = server.store.LogImpersonationAction(ctx, imp.ID, method, req.rawRequest.URL.Path)
+	if err != nil {
+		log.Printf("Failed to audit impersonated request: %s", err)
+		return nil, http.StatusInternalServerError, genericFailMessage
+	}
+	log.Printf("Impersonated %s %s by %s as %s", method, req.rawRequest.URL.Path, imp.Admin, imp.Username)
+	return &auth.UserData{ID: imp.UserID, Username: imp.Username, Email: imp.Email, IsVerified: true}, 0, ""
+}
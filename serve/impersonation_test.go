@@ -0,0 +1,68 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"testing"
+)
+
+func TestImpersonation(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth).withToken("admin-token")
+	create := func(body string) *httptest.ResponseRecorder {
+		return client.do("POST", "/v1/admin/impersonations", body)
+	}
+
+	if rr := create(`{"userId": "auth0|target", "reason": "debugging"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected admins without the impersonate role to be forbidden, got: %d", rr.Code)
+	}
+	mockAuth.user.Roles = append(mockAuth.user.Roles, auth.RoleImpersonate)
+
+	if rr := create(`{"userId": "auth0|target", "reason": "debugging", "minutes": 61}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected impersonations past the hard expiry to be rejected, got: %d", rr.Code)
+	}
+	if rr := create(`{"userId": "auth0|target"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected impersonations without a reason to be rejected, got: %d", rr.Code)
+	}
+	if rr := create(`{"userId": "auth0|nobody", "reason": "debugging"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected impersonating an unknown user to 404, got: %d", rr.Code)
+	}
+
+	rr := create(`{"userId": "auth0|target", "reason": "debugging"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected impersonation to be issued, got: %d %s", rr.Code, rr.Body.String())
+	}
+	var issued impersonationToken
+	if err := json.NewDecoder(rr.Body).Decode(&issued); err != nil {
+		t.Fatal(err)
+	}
+	if issued.Username != "target" || issued.Admin != "admin@gmail.com" || issued.Scope != "read" {
+		t.Errorf("unexpected impersonation: %+v", issued.Impersonation)
+	}
+	impersonated := client.withToken(impersonationScheme + issued.Token)
+
+	if rr := impersonated.do("GET", "/v1/notifications", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected impersonated GET to succeed, got: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := impersonated.do("POST", "/v1/dm", `{"to": "someone", "content": "hi"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected read only impersonation to be forbidden from writing, got: %d", rr.Code)
+	}
+	if rr := impersonated.do("GET", "/v1/admin/impersonations", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected impersonated users to have no staff roles, got: %d", rr.Code)
+	}
+	if len(mockStore.audited) != 2 || mockStore.audited[0] != "GET /v1/notifications" {
+		t.Errorf("expected impersonated requests to be audited, got: %v", mockStore.audited)
+	}
+
+	if rr := client.do("DELETE", "/v1/admin/impersonations/1", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected impersonation to be revoked, got: %d", rr.Code)
+	}
+	if rr := impersonated.do("GET", "/v1/notifications", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked impersonation to be refused, got: %d", rr.Code)
+	}
+}
@@ -0,0 +1,32 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"spiritchat/data"
+	"strconv"
+)
+
+// handleGetThreadDigest handles a GET request for a thread's post hashes and their Merkle root, for mirrors to check against.
+func (server *Server) handleGetThreadDigest(ctx context.Context, req *request, res *response) {
+	threadNum, err := strconv.Atoi(req.params.ByName("thread"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "Invalid thread number")
+		return
+	}
+	view, err := server.store.GetThreadView(ctx, req.params.ByName("cat"), threadNum)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to get thread digest", err)
+		return
+	}
+	if view == nil || len(view.Posts) == 0 {
+		res.Respond(http.StatusNotFound, nil, data.ErrNotFound.Error())
+		return
+	}
+	res.Respond(http.StatusOK, data.NewThreadDigest(view.Posts[0].Cat, threadNum, view.Posts), "")
+}
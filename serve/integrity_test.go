@@ -0,0 +1,40 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestGetThreadDigest(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth).as(nil)
+
+	if rr := client.do("GET", "/v1/categories/cat/x/digest", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid thread number to 400, got: %d", rr.Code)
+	}
+	if rr := client.do("GET", "/v1/categories/cat/1/digest", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a missing thread to 404, got: %d", rr.Code)
+	}
+
+	posts := []*data.Post{{Num: 1, Cat: "cat", Nonce: "a"}, {Num: 2, Cat: "cat", Parent: 1, Nonce: "b"}}
+	for _, post := range posts {
+		post.Hash = post.ContentHash()
+	}
+	mockStore.getThreadView = &data.ThreadView{Category: &data.Category{Tag: "cat"}, Posts: posts}
+	rr := client.do("GET", "/v1/categories/cat/1/digest", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the digest, got: %d", rr.Code)
+	}
+	var digest data.ThreadDigest
+	if err := json.NewDecoder(rr.Body).Decode(&digest); err != nil {
+		t.Fatal(err)
+	}
+	if digest.Thread != 1 || len(digest.Posts) != 2 || digest.Posts[1].Hash != posts[1].Hash || len(digest.Root) != 64 {
+		t.Errorf("expected both posts' hashes and a root, got: %+v", digest)
+	}
+}
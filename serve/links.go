@@ -0,0 +1,80 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"spiritchat/validation"
+)
+
+// Path of the page warning people they're following a link off the board.
+const linkRedirectPath = "/out"
+
+// Messages on the warning page, translated like any other.
+const (
+	leavingSiteTitle   = "You're leaving %s"
+	leavingSiteMessage = "This link goes to %s, which isn't part of this board. Only continue if you trust it."
+	leavingSiteButton  = "Continue to %s"
+)
+
+var leavingSitePage = template.Must(template.New("out").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+<p><a href="{{.URL}}" rel="noopener noreferrer nofollow">{{.Button}}</a></p>
+</body>
+</html>
+`))
+
+/*
+handleFollowLink handles a GET request for the page warning that a link in a post leaves the board,
+with the link to continue to. Links are only ever followed by clicking through, so it can't be used
+to redirect anyone anywhere, and the page sends no referrer so the site doesn't learn which thread it was linked from.
+*/
+func (server *Server) handleFollowLink(ctx context.Context, req *request, res *response) {
+	if !server.linkRedirect {
+		res.Respond(http.StatusNotFound, nil, "links aren't redirected")
+		return
+	}
+	u, err := validation.ValidateLink(req.rawRequest.URL.Query().Get("url"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	translate := res.translate
+	if translate == nil {
+		translate = func(message string) string { return message }
+	}
+	page := struct {
+		Lang    string
+		Title   string
+		Message string
+		Button  string
+		URL     string
+	}{
+		// Picked from Accept-Language by the CORS middleware.
+		Lang:    res.rw.Header().Get("Content-Language"),
+		Title:   fmt.Sprintf(translate(leavingSiteTitle), server.branding.Name),
+		Message: fmt.Sprintf(translate(leavingSiteMessage), u.Hostname()),
+		Button:  fmt.Sprintf(translate(leavingSiteButton), u.Hostname()),
+		URL:     u.String(),
+	}
+	res.rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.rw.Header().Set("Referrer-Policy", "no-referrer")
+	res.rw.Header().Set("X-Content-Type-Options", "nosniff")
+	res.rw.Header().Set("Cache-Control", "no-store")
+	res.rw.WriteHeader(http.StatusOK)
+	if err := leavingSitePage.Execute(res.rw, page); err != nil {
+		log.Printf("Failed to write link warning: %s", err)
+	}
+}
@@ -0,0 +1,50 @@
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFollowLink(t *testing.T) {
+	tests := []struct {
+		name         string
+		redirect     bool
+		link         string
+		expectedCode int
+	}{
+		{"disabled", false, "https://example.com", http.StatusNotFound},
+		{"https", true, "https://example.com/page?a=1", http.StatusOK},
+		{"www", true, "www.example.com", http.StatusOK},
+		{"javascript", true, "javascript:alert(1)", http.StatusBadRequest},
+		{"user info", true, "https://example.com@evil.example", http.StatusBadRequest},
+		{"missing", true, "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := CreateTestServer(&MockStore{}, &MockAuth{}, ServerOptions{LinkRedirect: test.redirect})
+			req, err := http.NewRequest("GET", "/out?url="+url.QueryEscape(test.link), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Fatalf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			if policy := rr.Header().Get("Referrer-Policy"); policy != "no-referrer" {
+				t.Errorf("expected no referrer, got %q", policy)
+			}
+			body := rr.Body.String()
+			if !strings.Contains(body, `rel="noopener noreferrer nofollow"`) || !strings.Contains(body, "example.com") {
+				t.Errorf("expected a warning linking on, got %s", body)
+			}
+		})
+	}
+}
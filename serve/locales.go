@@ -0,0 +1,118 @@
+package serve
+
+import (
+	"spiritchat/data"
+	"spiritchat/i18n"
+	"spiritchat/validation"
+)
+
+// Added to server error messages when there's a contact email to point at, formatted with the email.
+const contactMessage = "If this keeps happening, contact %s"
+
+/*
+englishMessages are the messages locale catalogs translate, by code.
+Messages in responses are translated when they match one of these exactly, anything else is left in English.
+*/
+var englishMessages = i18n.Catalog{
+	"server_error":     genericFailMessage,
+	"post_failed":      postFailMessage,
+	"timeout":          timeoutFailMessage,
+	"contact":          contactMessage,
+	"no_data":          errNoData.Error(),
+	"bad_json":         errBadJson.Error(),
+	"not_found":        data.ErrNotFound.Error(),
+	"version_conflict": data.ErrVersionConflict.Error(),
+
+	// Posting.
+	"content_length":         validation.ErrInvalidContentLen.Error(),
+	"subject_length":         validation.ErrInvalidSubjectLen.Error(),
+	"too_many_links":         validation.ErrTooManyLinks.Error(),
+	"link_only":              validation.ErrLinkOnly.Error(),
+	"unsafe_link":            validation.ErrUnsafeLink.Error(),
+	"blocked_domain":         errBlockedDomain.Error(),
+	"attachment_required":    errAttachmentRequired.Error(),
+	"too_many_attachments":   errTooManyAttachments.Error(),
+	"attachment_not_allowed": errAttachmentNotAllowed.Error(),
+	"attachments_disabled":   errAttachmentsDisabled.Error(),
+	"attachment_unavailable": data.ErrAttachmentUnavailable.Error(),
+	"quota_exceeded":         quotaRejection.Message,
+	"unverified_thread":      errUnverifiedThread.Error(),
+	"capcode_not_allowed":    errCapcodeNotAllowed.Error(),
+	"banned":                 errBannedPoster.Error(),
+	"rules_not_accepted":     errRulesNotAccepted.Error(),
+	"post_cooldown":          errPostCooldown.Error(),
+	"bad_thread_number":      errBadThreadNumber.Error(),
+	"thread_locked":          data.ErrThreadLocked.Error(),
+	"category_archived":      data.ErrCategoryArchived.Error(),
+	"cant_message":           data.ErrCantMessage.Error(),
+	"message_length":         validation.ErrInvalidMessageLen.Error(),
+	"search_length":          validation.ErrInvalidSearchLen.Error(),
+
+	// Accounts.
+	"invalid_email":       validation.ErrInvalidEmail.Error(),
+	"invalid_username":    validation.ErrInvalidUsername.Error(),
+	"reserved_username":   validation.ErrReservedUsername.Error(),
+	"invalid_password":    validation.ErrInvalidPassword.Error(),
+	"display_name_length": validation.ErrInvalidDisplayName.Error(),
+	"invalid_timezone":    validation.ErrInvalidTimezone.Error(),
+	"complaint_length":    validation.ErrInvalidComplaintLen.Error(),
+	"complainant_length":  validation.ErrInvalidComplainantNameLen.Error(),
+	"session_revoked":     errSessionRevoked.Error(),
+	"two_factor_required": errTwoFactorRequired.Error(),
+	"two_factor_disabled": errTwoFactorDisabled.Error(),
+	"two_factor_enrolled": data.ErrTOTPEnrolled.Error(),
+	"wrong_totp_code":     errWrongTOTPCode.Error(),
+	"totp_reused":         data.ErrTOTPReused.Error(),
+
+	// Moderation.
+	"report_length":      validation.ErrInvalidReportLen.Error(),
+	"report_target":      errReportTarget.Error(),
+	"note_length":        validation.ErrInvalidNoteLen.Error(),
+	"note_target":        errNoteTarget.Error(),
+	"invalid_post":       errInvalidPost.Error(),
+	"bad_post_number":    errBadPostNumber.Error(),
+	"invalid_poster":     validation.ErrInvalidPosterHash.Error(),
+	"negative_ban":       errNegativeBan.Error(),
+	"unknown_action":     data.ErrUnknownAction.Error(),
+	"retention_disabled": data.ErrRetentionDisabled.Error(),
+	"reason_required":    errAccessReasonRequired.Error(),
+
+	// Categories and pages.
+	"category_name_length":        validation.ErrInvalidCategoryNameLen.Error(),
+	"category_description_length": validation.ErrInvalidCategoryDescriptionLen.Error(),
+	"category_rule_length":        validation.ErrInvalidCategoryRuleLen.Error(),
+	"category_tag":                validation.ErrInvalidCategoryTag.Error(),
+	"category_slug":               validation.ErrInvalidCategorySlug.Error(),
+	"category_exists":             data.ErrCategoryExists.Error(),
+	"reserved_category_name":      validation.ErrReservedCategoryName.Error(),
+	"slug_taken":                  data.ErrSlugTaken.Error(),
+	"masked_word_length":          validation.ErrInvalidMaskedWordLen.Error(),
+	"invalid_domain":              validation.ErrInvalidDomain.Error(),
+	"page_slug":                   validation.ErrInvalidPageSlug.Error(),
+	"page_title_length":           validation.ErrInvalidPageTitleLen.Error(),
+	"page_body_length":            validation.ErrInvalidPageBodyLen.Error(),
+
+	// The page warning people following links off the board.
+	"leaving_site_title":   leavingSiteTitle,
+	"leaving_site_message": leavingSiteMessage,
+	"leaving_site_button":  leavingSiteButton,
+}
+
+// localized is a JSON response carrying messages, translated before it's written.
+type localized interface {
+	localize(translate func(message string) string) interface{}
+}
+
+func (r rejection) localize(translate func(message string) string) interface{} {
+	r.Message = translate(r.Message)
+	return r
+}
+
+func (report *postValidation) localize(translate func(message string) string) interface{} {
+	for _, check := range report.Checks {
+		if len(check.Message) > 0 {
+			check.Message = translate(check.Message)
+		}
+	}
+	return report
+}
@@ -0,0 +1,79 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"spiritchat/auth"
+	"spiritchat/i18n"
+	"strings"
+	"testing"
+)
+
+func TestLocalizedMessages(t *testing.T) {
+	mockAuth := &MockAuth{user: &auth.UserData{Username: "someone", IsVerified: true}}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+		Locales: map[string]i18n.Catalog{
+			"fr": {"report_target": "le signalement doit porter sur un message"},
+		},
+	})
+
+	report := func(acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/reports", bytes.NewBufferString(`{"reason": "spam"}`))
+		req.Header.Add("Authorization", "ok")
+		if len(acceptLanguage) > 0 {
+			req.Header.Add("Accept-Language", acceptLanguage)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := report("fr-CA,en;q=0.5")
+	if rr.Code != http.StatusBadRequest || strings.TrimSpace(rr.Body.String()) != "le signalement doit porter sur un message" {
+		t.Errorf("expected French message, got %d %q", rr.Code, rr.Body.String())
+	}
+	if lang := rr.Header().Get("Content-Language"); lang != "fr" {
+		t.Errorf("expected Content-Language fr, got %q", lang)
+	}
+
+	for _, acceptLanguage := range []string{"", "de, en;q=0.8"} {
+		rr = report(acceptLanguage)
+		if strings.TrimSpace(rr.Body.String()) != errReportTarget.Error() {
+			t.Errorf("%q: expected English message, got %q", acceptLanguage, rr.Body.String())
+		}
+		if lang := rr.Header().Get("Content-Language"); lang != i18n.English {
+			t.Errorf("%q: expected Content-Language en, got %q", acceptLanguage, lang)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/config", nil))
+	config := &ConfigResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(config); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Features.Languages, []string{"en", "fr"}) {
+		t.Errorf("expected languages en and fr, got %v", config.Features.Languages)
+	}
+}
+
+func TestLocalizedRejections(t *testing.T) {
+	translate := func(message string) string { return "[" + message + "]" }
+
+	rej := rejection{Code: "too_many_links", Message: "too many links"}.localize(translate).(rejection)
+	if rej.Message != "[too many links]" || rej.Code != "too_many_links" {
+		t.Errorf("expected only the message translated, got %+v", rej)
+	}
+
+	report := &postValidation{Checks: []*postCheck{{Check: "content", Message: "too short"}, {Check: "cooldown", Passed: true}}}
+	report.localize(translate)
+	if report.Checks[0].Message != "[too short]" {
+		t.Errorf("expected failed check's message translated, got %q", report.Checks[0].Message)
+	}
+	if report.Checks[1].Message != "" {
+		t.Errorf("expected passed check left without a message, got %q", report.Checks[1].Message)
+	}
+}
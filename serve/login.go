@@ -0,0 +1,134 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/ratelimit"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LoginIPFreeAttempts is how many failed logins an IP gets before lockouts start, more than an account as many users can share one.
+const LoginIPFreeAttempts = 20
+
+// LoginOptions configure brute-force protection on password logins.
+type LoginOptions struct {
+	// Failed logins to each account and from each IP, tracked in process if unset.
+	Accounts ratelimit.Attempts
+	IPs      ratelimit.Attempts
+}
+
+// loginMetrics count failed logins since the server started.
+type loginMetrics struct {
+	Failures int64 `json:"failures"`
+	// Failures that locked out an account or IP.
+	Lockouts int64 `json:"lockouts"`
+	// Logins refused while locked out, without being tried.
+	Refused int64 `json:"refused"`
+}
+
+// loginLocked tells clients when they can next try logging in.
+type loginLocked struct {
+	Message     string    `json:"message"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+func loginAccountKey(username string) string {
+	return "login:" + strings.ToLower(username)
+}
+
+func loginIPKey(ip string) string {
+	return "login-ip:" + ip
+}
+
+/*
+attemptLogin counts a login to the account from the IP before it's tried, returning whether it may go ahead
+and the later of the account and IP's lockouts, the zero time if neither is locked out.
+*/
+func (server *Server) attemptLogin(ctx context.Context, username string, ip string) (time.Time, bool) {
+	var lockedUntil time.Time
+	allowed := true
+	for _, check := range []struct {
+		attempts ratelimit.Attempts
+		key      string
+	}{{server.login.Accounts, loginAccountKey(username)}, {server.login.IPs, loginIPKey(ip)}} {
+		until, ok, err := check.attempts.Attempt(ctx, check.key)
+		if err != nil {
+			// Auth0 has its own brute-force protection to fall back on.
+			log.Printf("Failed to count login attempt: %s", err)
+			continue
+		}
+		allowed = allowed && ok
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+	return lockedUntil, allowed
+}
+
+func respondLoginLocked(res *response, lockedUntil time.Time, message string) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	res.rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	res.Respond(http.StatusTooManyRequests, loginLocked{Message: message, LockedUntil: lockedUntil}, "")
+}
+
+/*
+handleLogin handles a POST request logging in with a username or email and password. Logins are counted before
+they're tried, and lock out the account and IP for longer each time once their free attempts are used up,
+reporting when they can try again.
+*/
+func (server *Server) handleLogin(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingLogin(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	lockedUntil, allowed := server.attemptLogin(ctx, incoming.Username, req.ip)
+	if !allowed {
+		atomic.AddInt64(&server.loginMetrics.Refused, 1)
+		respondLoginLocked(res, lockedUntil, "too many failed logins, try again later")
+		return
+	}
+
+	tokens, err := server.auth.Login(ctx, incoming.Username, incoming.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			atomic.AddInt64(&server.loginMetrics.Failures, 1)
+			if !lockedUntil.IsZero() {
+				atomic.AddInt64(&server.loginMetrics.Lockouts, 1)
+				respondLoginLocked(res, lockedUntil, err.Error())
+				return
+			}
+			res.Respond(http.StatusUnauthorized, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to log in", err)
+		return
+	}
+
+	// The IP's failures are kept, so logging into one account doesn't reset guesses at others.
+	if err := server.login.Accounts.Reset(ctx, loginAccountKey(incoming.Username)); err != nil {
+		log.Printf("Failed to reset failed logins: %s", err)
+	}
+	if err := server.login.IPs.Forgive(ctx, loginIPKey(req.ip)); err != nil {
+		log.Printf("Failed to forgive login: %s", err)
+	}
+	server.recordSession(ctx, req, tokens)
+	res.Respond(http.StatusOK, tokens, "")
+}
+
+// handleGetLoginMetrics handles a GET request from an admin for counts of failed logins.
+func (server *Server) handleGetLoginMetrics(ctx context.Context, req *request, res *response) {
+	res.Respond(http.StatusOK, loginMetrics{
+		Failures: atomic.LoadInt64(&server.loginMetrics.Failures),
+		Lockouts: atomic.LoadInt64(&server.loginMetrics.Lockouts),
+		Refused:  atomic.LoadInt64(&server.loginMetrics.Refused),
+	}, "")
+}
@@ -0,0 +1,76 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	mockAuth := &MockAuth{
+		user: staffUser(auth.RoleAdmin),
+	}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{})
+
+	login := func(username string, password string, ip string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(incomingLogin{Username: username, Password: password})
+		req, err := http.NewRequest("POST", "/v1/login", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Real-IP", ip)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := login("", "hunter2", "10.0.0.1"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected login without a username to 400, got: %d", rr.Code)
+	}
+	if rr := login("bob", "hunter2", "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Errorf("expected login to succeed, got: %d", rr.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := login("bob", "wrong", "10.0.0.1"); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected free failure %d to 401, got: %d", i+1, rr.Code)
+		}
+	}
+	rr := login("bob", "wrong", "10.0.0.1")
+	if rr.Code != http.StatusTooManyRequests || len(rr.Header().Get("Retry-After")) == 0 {
+		t.Fatalf("expected failure past the free attempts to lock out, got: %d", rr.Code)
+	}
+	var locked loginLocked
+	if err := json.NewDecoder(rr.Body).Decode(&locked); err != nil {
+		t.Fatal(err)
+	}
+	if locked.LockedUntil.IsZero() {
+		t.Error("expected lockout to say when it ends")
+	}
+
+	// Locked out accounts can't log in even with the right password, from anywhere.
+	if rr := login("Bob", "hunter2", "10.0.0.2"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected locked out account to be refused, got: %d", rr.Code)
+	}
+	if rr := login("alice", "hunter2", "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Errorf("expected other accounts from the IP to log in, got: %d", rr.Code)
+	}
+
+	req, err := http.NewRequest("GET", "/v1/admin/login-metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "token")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	var metrics loginMetrics
+	if err := json.NewDecoder(rr.Body).Decode(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Failures != 4 || metrics.Lockouts != 1 || metrics.Refused != 1 {
+		t.Errorf("unexpected login metrics: %+v", metrics)
+	}
+}
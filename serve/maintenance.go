@@ -0,0 +1,138 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Route that toggles maintenance, exempt from it so it can be turned off.
+const maintenancePath = "/v1/admin/maintenance"
+
+const maxMaintenanceMessageLen = 500
+
+// MaintenanceOptions configure read-only mode.
+type MaintenanceOptions struct {
+	// Start in read-only mode.
+	ReadOnly bool
+	// Shown to clients whose writes are rejected.
+	Message string
+	// Sent in Retry-After on rejected writes, 300 if unset.
+	RetryAfter time.Duration
+}
+
+// MaintenanceState is whether the API is read-only, and why.
+type MaintenanceState struct {
+	ReadOnly bool       `json:"readOnly"`
+	Message  string     `json:"message,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+/*
+maintenance holds this instance's read-only switch. It isn't shared between instances,
+operators running several need to toggle each.
+*/
+type maintenance struct {
+	mut        sync.RWMutex
+	state      MaintenanceState
+	retryAfter time.Duration
+}
+
+func newMaintenance(opts MaintenanceOptions) *maintenance {
+	m := &maintenance{retryAfter: opts.RetryAfter}
+	if m.retryAfter <= 0 {
+		m.retryAfter = time.Minute * 5
+	}
+	m.set(opts.ReadOnly, opts.Message)
+	return m
+}
+
+func (m *maintenance) get() MaintenanceState {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.state
+}
+
+func (m *maintenance) set(readOnly bool, message string) MaintenanceState {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if readOnly && !m.state.ReadOnly {
+		now := time.Now()
+		m.state.Since = &now
+	}
+	if !readOnly {
+		m.state.Since = nil
+		message = ""
+	}
+	m.state.ReadOnly = readOnly
+	m.state.Message = message
+	return m.state
+}
+
+/*
+rejectsWrite responds with 503 and returns true if the request writes
+while the API is read-only.
+*/
+func (m *maintenance) rejectsWrite(req *request, res *response) bool {
+	if req.rawRequest.Method == http.MethodGet || req.rawRequest.URL.Path == maintenancePath {
+		return false
+	}
+	state := m.get()
+	if !state.ReadOnly {
+		return false
+	}
+
+	message := "spiritchat is read-only for maintenance, please try again later"
+	if len(state.Message) > 0 {
+		message = state.Message
+	}
+	res.rw.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+	res.Respond(http.StatusServiceUnavailable, nil, message)
+	return true
+}
+
+type incomingMaintenance struct {
+	ReadOnly bool   `json:"readOnly"`
+	Message  string `json:"message"`
+}
+
+func getIncomingMaintenance(body io.ReadCloser) (*incomingMaintenance, error) {
+	if body == nil {
+		return nil, errNoData
+	}
+
+	im := &incomingMaintenance{}
+	err := json.NewDecoder(body).Decode(im)
+	if err != nil {
+		return nil, errBadJson
+	}
+	return im, nil
+}
+
+// handleGetMaintenance handles a GET request for whether the API is read-only.
+func (server *Server) handleGetMaintenance(ctx context.Context, req *request, res *response) {
+	res.Respond(http.StatusOK, server.maintenance.get(), "")
+}
+
+// handleSetMaintenance handles a PUT request making the API read-only, or writable again.
+func (server *Server) handleSetMaintenance(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingMaintenance(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	if len([]rune(incoming.Message)) > maxMaintenanceMessageLen {
+		res.Respond(http.StatusBadRequest, nil, fmt.Sprintf("message must be at most %d characters", maxMaintenanceMessageLen))
+		return
+	}
+
+	state := server.maintenance.set(incoming.ReadOnly, incoming.Message)
+	log.Printf("Read-only mode set to %v by %s", state.ReadOnly, req.user.Email)
+	res.Respond(http.StatusOK, state, "")
+}
@@ -0,0 +1,41 @@
+package serve
+
+import (
+	"net/http"
+	"spiritchat/auth"
+	"strings"
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockAuth := &MockAuth{user: admin}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+		Maintenance: MaintenanceOptions{ReadOnly: true, Message: "migrating"},
+	})
+
+	client := newTestClient(server, mockAuth)
+
+	rr := client.do("POST", "/v1/categories/cat/1", `{"content": "hello!"}`)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "300" {
+		t.Errorf("expected write rejected with retry hint, got %d (Retry-After %q)", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(rr.Body.String(), "migrating") {
+		t.Errorf("expected configured message, got %q", rr.Body.String())
+	}
+	if rr := client.do("GET", "/v1/categories", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected reads to work, got: %d", rr.Code)
+	}
+
+	if rr := client.do("PUT", maintenancePath, `{"readOnly": false}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected admin to leave read-only mode, got: %d", rr.Code)
+	}
+	if rr := client.do("POST", "/v1/categories/cat/1", `{"content": "hello!"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected writes after leaving read-only mode, got: %d", rr.Code)
+	}
+
+	mockAuth.user = moderator("mod@gmail.com")
+	if rr := client.do("PUT", maintenancePath, `{"readOnly": true}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected moderators not to toggle read-only mode, got: %d", rr.Code)
+	}
+}
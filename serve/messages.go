@@ -0,0 +1,159 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"strconv"
+	"time"
+)
+
+// Time between private messages sent by the same account.
+const messageCooldown = time.Second * 3
+
+// Messages, notifications, bookmarks or digests returned per page, by default and at most.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// getPage reads the ID to page back from and the page size from the query, both optional.
+func getPage(req *request) (int, int) {
+	values := req.rawRequest.URL.Query()
+	before, _ := strconv.Atoi(values.Get("before"))
+	if before < 0 {
+		before = 0
+	}
+	limit := defaultPageSize
+	if requested, err := strconv.Atoi(values.Get("limit")); err == nil && requested > 0 {
+		limit = requested
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+	}
+	return before, limit
+}
+
+// recordAccount keeps the user's username current so others can message them, failing quietly.
+func (server *Server) recordAccount(ctx context.Context, req *request) {
+	err := server.store.RecordAccount(ctx, req.user.ID, req.user.Username)
+	if err != nil {
+		log.Printf("Failed to record account: %s", err)
+	}
+}
+
+// handleGetConversations handles a GET request for the user's private conversations.
+func (server *Server) handleGetConversations(ctx context.Context, req *request, res *response) {
+	server.recordAccount(ctx, req)
+	conversations, err := server.store.GetConversations(ctx, req.user.ID)
+	if err != nil {
+		res.Fail("Failed to get conversations", err)
+		return
+	}
+	res.Respond(http.StatusOK, conversations, "")
+}
+
+/*
+handleGetMessages handles a GET request for a page of messages in one of the user's conversations, newest first.
+Older pages are fetched by passing the ID of the oldest message seen as before.
+*/
+func (server *Server) handleGetMessages(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid conversation ID")
+		return
+	}
+	before, limit := getPage(req)
+	messages, err := server.store.GetMessages(ctx, req.user.ID, id, before, limit)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such conversation")
+			return
+		}
+		res.Fail("Failed to get messages", err)
+		return
+	}
+	res.Respond(http.StatusOK, messages, "")
+}
+
+// handleSendMessage handles a POST request sending a private message to another user by their username.
+func (server *Server) handleSendMessage(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingMessage(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	if server.limiter != nil {
+		limited, err := server.limiter.IsRateLimited(ctx, "dm:"+req.user.ID, messageCooldown)
+		if err != nil {
+			res.Respond(http.StatusServiceUnavailable, nil, genericFailMessage)
+			log.Printf("Failed to check message rate limit: %s", err)
+			return
+		}
+		if limited {
+			res.Respond(http.StatusTooManyRequests, nil, "please wait before sending another message")
+			return
+		}
+	}
+
+	server.recordAccount(ctx, req)
+	message, err := server.store.SendMessage(ctx, data.IdentityFrom(ctx), incoming.To, incoming.Content)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such user")
+			return
+		}
+		if errors.Is(err, data.ErrAmbiguousUsername) {
+			res.Respond(http.StatusConflict, nil, err.Error())
+			return
+		}
+		if errors.Is(err, data.ErrCantMessage) {
+			res.Respond(http.StatusForbidden, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to send message", err)
+		return
+	}
+	res.Respond(http.StatusOK, message, "")
+}
+
+// handleGetBlocked handles a GET request for the usernames the user has blocked.
+func (server *Server) handleGetBlocked(ctx context.Context, req *request, res *response) {
+	blocked, err := server.store.GetBlocked(ctx, req.user.ID)
+	if err != nil {
+		res.Fail("Failed to get blocks", err)
+		return
+	}
+	res.Respond(http.StatusOK, blocked, "")
+}
+
+// handleSetBlocked handles a PUT request blocking a user from messaging the user, or a DELETE unblocking them.
+func (server *Server) handleSetBlocked(ctx context.Context, req *request, res *response) {
+	blocked := req.rawRequest.Method != http.MethodDelete
+	err := server.store.SetBlocked(ctx, req.user.ID, req.params.ByName("username"), blocked)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such user")
+			return
+		}
+		if errors.Is(err, data.ErrAmbiguousUsername) {
+			res.Respond(http.StatusConflict, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to set block", err)
+		return
+	}
+	if blocked {
+		res.Respond(http.StatusOK, nil, "user blocked")
+		return
+	}
+	res.Respond(http.StatusOK, nil, "user unblocked")
+}
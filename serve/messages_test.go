@@ -0,0 +1,80 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	user := &auth.UserData{
+		ID:         "auth0|spirit",
+		Username:   "spirit",
+		Email:      "spirit@gmail.com",
+		IsVerified: true,
+	}
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: user}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("POST", "/v1/dm", `{"to": "other", "content": " "}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected empty message to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.do("POST", "/v1/dm", `{"content": "hi"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected message without recipient to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.do("POST", "/v1/dm", `{"to": "other", "content": "hi"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected message to be sent, got: %d", rr.Code)
+	}
+	if mockStore.recorded != user.Username || mockStore.messages[0].From != user.Username {
+		t.Errorf("expected message from %s, got: %+v", user.Username, mockStore.messages[0])
+	}
+
+	if rr := client.do("PUT", "/v1/blocks/other", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected block, got: %d", rr.Code)
+	}
+	if rr := client.do("POST", "/v1/dm", `{"to": "other", "content": "hi again"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected message to blocked user to be refused, got: %d", rr.Code)
+	}
+	if rr := client.do("DELETE", "/v1/blocks/other", ""); rr.Code != http.StatusOK || len(mockStore.blocked) != 0 {
+		t.Errorf("expected unblock, got: %d", rr.Code)
+	}
+
+	if rr := client.do("GET", "/v1/dm/1?before=9&limit=500", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected messages, got: %d", rr.Code)
+	}
+	if mockStore.pageBefore != 9 || mockStore.pageLimit != maxPageSize {
+		t.Errorf("expected page before 9 of %d, got before %d of %d", maxPageSize, mockStore.pageBefore, mockStore.pageLimit)
+	}
+	if rr := client.do("GET", "/v1/dm/2", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected others' conversations to 404, got: %d", rr.Code)
+	}
+
+	rr := client.do("GET", "/v1/me", "")
+	var got struct {
+		UnreadMessages int `json:"unreadMessages"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.UnreadMessages != 1 {
+		t.Errorf("expected 1 unread message, got: %d", got.UnreadMessages)
+	}
+
+	mockStore.err = data.ErrNotFound
+	if rr := client.do("POST", "/v1/dm", `{"to": "nobody", "content": "hi"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected unknown recipient to 404, got: %d", rr.Code)
+	}
+
+	mockStore.err = data.ErrAmbiguousUsername
+	if rr := client.do("POST", "/v1/dm", `{"to": "shared", "content": "hi"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected shared username to be refused, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/blocks/shared", ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected blocking a shared username to be refused, got: %d", rr.Code)
+	}
+}
@@ -3,38 +3,293 @@ package serve
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"spiritchat/errtrack"
+	"spiritchat/reputation"
+	"strings"
+	"time"
 )
 
+// Header clients send captcha solutions in.
+const captchaHeader = "X-Captcha-Token"
+
 func (s *Server) middlewareCORS(next handlerFunc, allowedOrigin string) handlerFunc {
 	return func(ctx context.Context, req *request, res *response) {
 		res.rw.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-		res.rw.Header().Set("Access-Control-Allow-Headers", "Authorization")
+		res.rw.Header().Set("Access-Control-Allow-Headers", "Authorization,"+captchaHeader+","+clientKeyHeader)
+		// Every route passes through here, so enforce read limits.
+		if s.rejectsRead(ctx, req, res) {
+			return
+		}
+		next(ctx, req, res)
+	}
+}
+
+// middlewareSetupResponse points error messages at the operator, in the request's language.
+func (s *Server) middlewareSetupResponse(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		res.contactEmail = s.branding.ContactEmail
+		lang := s.translator.Negotiate(req.header.Get("Accept-Language"))
+		res.rw.Header().Set("Content-Language", lang)
+		res.rw.Header().Add("Vary", "Accept-Language")
+		res.translate = func(message string) string { return s.translator.Translate(lang, message) }
+		next(ctx, req, res)
+	}
+}
+
+// middlewareMaintenance rejects writes while the API is read-only.
+func (s *Server) middlewareMaintenance(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		if s.maintenance.rejectsWrite(req, res) {
+			return
+		}
+		next(ctx, req, res)
+	}
+}
+
+// middlewareRejectCrossSite rejects writes from pages on untrusted origins.
+func (s *Server) middlewareRejectCrossSite(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		if s.trustedOrigins.rejectsCrossSite(req, res) {
+			return
+		}
 		next(ctx, req, res)
 	}
 }
 
+/*
+middlewareTrackErrors reports requests that failed with a server error to the error tracker,
+tagged with the route and request ID so they can be found in the logs.
+*/
+func (s *Server) middlewareTrackErrors(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		next(ctx, req, res)
+		if s.tracker == nil || (res.err == nil && res.status < http.StatusInternalServerError) {
+			return
+		}
+		event := &errtrack.Event{
+			Message: fmt.Sprintf("%s responded %d", req.route, res.status),
+			Tags:    map[string]string{"route": req.route, "request_id": req.id},
+			Method:  req.rawRequest.Method,
+			URL:     req.rawRequest.URL.String(),
+		}
+		if res.err != nil {
+			event.Message = res.err.Error()
+		}
+		s.tracker.Capture(event)
+	}
+}
+
+// unverifiedAccess is how a route treats accounts that haven't verified their email.
+type unverifiedAccess int
+
+const (
+	rejectUnverified unverifiedAccess = iota
+	// Unverified accounts are let through during their grace period.
+	graceUnverified
+	allowUnverified
+)
+
+// middlewareRequireLogin rejects requests without a verified account.
 func (s *Server) middlewareRequireLogin(next handlerFunc) handlerFunc {
+	return s.requireLogin(next, rejectUnverified)
+}
+
+/*
+middlewareRequireLoginGrace rejects requests without an account, letting unverified accounts through
+while they're in their grace period. Handlers check req.user.IsVerified for anything they can't do yet.
+*/
+func (s *Server) middlewareRequireLoginGrace(next handlerFunc) handlerFunc {
+	return s.requireLogin(next, graceUnverified)
+}
+
+// middlewareRequireAccount rejects requests without an account, verified or not.
+func (s *Server) middlewareRequireAccount(next handlerFunc) handlerFunc {
+	return s.requireLogin(next, allowUnverified)
+}
+
+func (s *Server) requireLogin(next handlerFunc, access unverifiedAccess) handlerFunc {
 	return func(ctx context.Context, req *request, res *response) {
 		token := req.header.Get("Authorization")
 		if len(token) < 1 {
 			res.Respond(http.StatusUnauthorized, nil, "no access token")
 			return
 		}
-		user, err := s.auth.GetUserFromToken(ctx, token)
-		if err != nil {
-			res.Respond(http.StatusUnauthorized, nil, fmt.Sprintf("look up user failure: %s", err))
-			return
+		var user *auth.UserData
+		var session *data.Session
+		if strings.HasPrefix(token, impersonationScheme) {
+			var status int
+			var message string
+			user, status, message = s.impersonatedUser(ctx, req, strings.TrimPrefix(token, impersonationScheme))
+			if user == nil {
+				res.Respond(status, nil, message)
+				return
+			}
+		} else {
+			session = s.loginSession(ctx, token)
+			if session != nil && session.Revoked {
+				res.Respond(http.StatusUnauthorized, nil, errSessionRevoked.Error())
+				return
+			}
+			var err error
+			user, err = s.auth.GetUserFromToken(ctx, token)
+			if err != nil {
+				res.Respond(http.StatusUnauthorized, nil, fmt.Sprintf("look up user failure: %s", err))
+				return
+			}
 		}
 		if user == nil {
 			res.Respond(http.StatusNotFound, nil, "no user")
 			return
 		}
-		if !user.IsVerified {
-			res.Respond(http.StatusUnauthorized, nil, "please verify your account")
+		if !user.IsVerified && access != allowUnverified {
+			inGrace := false
+			if access == graceUnverified && s.unverifiedGrace > 0 {
+				firstSeen, err := s.store.AccountFirstSeen(ctx, user.ID)
+				if err != nil {
+					res.Fail("Failed to check account grace period", err)
+					return
+				}
+				inGrace = time.Since(firstSeen) < s.unverifiedGrace
+			}
+			if !inGrace {
+				res.Respond(http.StatusUnauthorized, nil, "please verify your account")
+				return
+			}
+		}
+		scope := auth.ScopePost
+		if req.rawRequest.Method == http.MethodGet || req.rawRequest.Method == http.MethodHead {
+			scope = auth.ScopeRead
+		}
+		if !user.HasScope(scope) {
+			respondMissingScope(res, scope)
 			return
 		}
 		req.user = user
+		req.session = session
+		roles := make([]string, len(user.Roles))
+		for i, role := range user.Roles {
+			roles[i] = string(role)
+		}
+		next(data.WithIdentity(ctx, data.NewIdentity(user.ID, user.Email, user.Username, roles, req.ip)), req, res)
+	}
+}
+
+// middlewareRequireRole rejects users without the given role. Must run after middlewareRequireLogin.
+func (s *Server) middlewareRequireRole(role auth.Role, next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		if !s.checkRole(req, res, role) {
+			return
+		}
+		next(ctx, req, res)
+	}
+}
+
+/*
+checkRole reports whether the request can act with the role: its user has the role, its token the role's scope and,
+when staff need two-factor, its session has had a code entered. Refused requests are responded to, unless res is nil.
+*/
+func (s *Server) checkRole(req *request, res *response, role auth.Role) bool {
+	if req.user == nil || !req.user.HasRole(role) {
+		if res != nil {
+			res.Respond(http.StatusForbidden, nil, "you don't have access to that")
+		}
+		return false
+	}
+	if scope := auth.RoleScope(role); !req.user.HasScope(scope) {
+		if res != nil {
+			respondMissingScope(res, scope)
+		}
+		return false
+	}
+	if s.staffTwoFactor && (req.session == nil || req.session.VerifiedAt == nil) {
+		if res != nil {
+			res.Respond(http.StatusForbidden, nil, errTwoFactorRequired.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// canUseRole reports whether the request can act with the role, for handlers letting staff do more than other users.
+func (s *Server) canUseRole(req *request, role auth.Role) bool {
+	return s.checkRole(req, nil, role)
+}
+
+// respondMissingScope rejects a request with a token lacking the scope, as described in RFC 6750.
+func respondMissingScope(res *response, scope auth.Scope) {
+	res.rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+	res.Respond(http.StatusForbidden, nil, fmt.Sprintf("your token needs the %s scope for that", scope))
+}
+
+/*
+middlewareReputation applies the category's reputation policy to posts from IPs
+listed by the reputation checker. Checks that fail let the post through.
+*/
+func (s *Server) middlewareReputation(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		if s.reputation == nil {
+			next(ctx, req, res)
+			return
+		}
+		params, err := getReplyParameters(req)
+		if err != nil {
+			next(ctx, req, res)
+			return
+		}
+		policy := s.reputationPolicies.For(params.categoryTag)
+		if policy == reputation.Allow {
+			next(ctx, req, res)
+			return
+		}
+
+		result, err := s.reputation.Check(ctx, req.ip)
+		if err != nil {
+			log.Printf("Failed to check reputation of %s: %v", req.ip, err)
+			next(ctx, req, res)
+			return
+		}
+		if !result.Listed() {
+			next(ctx, req, res)
+			return
+		}
+
+		switch policy {
+		case reputation.RequireLogin:
+			// Posting is open to accounts in their grace period, so this needs a verified one.
+			if req.user == nil || !req.user.IsVerified {
+				res.Respond(http.StatusForbidden, nil, "please log in with a verified account to post from your network")
+				return
+			}
+		case reputation.RequireCaptcha:
+			token := req.header.Get(captchaHeader)
+			if len(token) == 0 {
+				res.Respond(http.StatusForbidden, nil, "please complete the captcha to post from your network")
+				return
+			}
+			if s.captcha == nil {
+				log.Printf("Category %s requires a captcha but none is configured", params.categoryTag)
+				res.Respond(http.StatusForbidden, nil, "posting from your network isn't allowed here")
+				return
+			}
+			solved, err := s.captcha.Verify(ctx, token, req.ip)
+			if err != nil {
+				res.Respond(http.StatusServiceUnavailable, nil, postFailMessage)
+				log.Printf("Failed to verify captcha: %v", err)
+				return
+			}
+			if !solved {
+				res.Respond(http.StatusForbidden, nil, "captcha failed, please try again")
+				return
+			}
+		default:
+			log.Printf("Blocked post from %s listed by %s", req.ip, strings.Join(result.Sources, ", "))
+			res.Respond(http.StatusForbidden, nil, "posting from your network isn't allowed here")
+			return
+		}
 		next(ctx, req, res)
 	}
 }
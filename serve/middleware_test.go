@@ -3,10 +3,17 @@ package serve
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"spiritchat/auth"
+	"spiritchat/data"
+	"spiritchat/errtrack"
+	"spiritchat/ratelimit"
+	"spiritchat/reputation"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -14,7 +21,7 @@ import (
 func TestMiddlewareCors(t *testing.T) {
 	mockStore := &MockStore{}
 	mockAuth := &MockAuth{}
-	server := CreateTestServer(mockStore, mockAuth)
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
 
 	allowedOrigin := "example.net"
 	okHandler := func(ctx context.Context, req *request, res *response) {
@@ -41,11 +48,16 @@ func TestMiddlewareCors(t *testing.T) {
 func TestMiddleware(t *testing.T) {
 	mockStore := &MockStore{}
 	mockAuth := &MockAuth{}
-	server := CreateTestServer(mockStore, mockAuth)
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
 
 	nextStatus := http.StatusTeapot
 	okText := "ok"
 	okHandler := func(ctx context.Context, req *request, res *response) {
+		identity := data.IdentityFrom(ctx)
+		if identity == nil || identity.Username != req.user.Username || !identity.HasRole(string(auth.RoleModerator)) {
+			res.Respond(http.StatusInternalServerError, nil, "bad identity")
+			return
+		}
 		res.Respond(nextStatus, nil, okText)
 	}
 
@@ -82,6 +94,7 @@ func TestMiddleware(t *testing.T) {
 					Username:   "beep",
 					Email:      "boop",
 					IsVerified: true,
+					Roles:      []auth.Role{auth.RoleModerator},
 				}
 			},
 		},
@@ -105,3 +118,415 @@ func TestMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestMiddlewareAntibot(t *testing.T) {
+	browserHeaders := func(req *http.Request) {
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Accept-Language", "en")
+	}
+
+	tests := map[string]struct {
+		opts         AntibotOptions
+		body         string
+		setup        func(server *Server, req *http.Request)
+		expectedCode int
+	}{
+		"Disabled": {
+			opts:         AntibotOptions{},
+			body:         `{"website": "spam.com"}`,
+			expectedCode: http.StatusTeapot,
+		},
+		"Honeypot filled": {
+			opts: AntibotOptions{Honeypot: "website", MaxSpamScore: 5},
+			body: `{"content": "hi", "website": "spam.com"}`,
+			setup: func(server *Server, req *http.Request) {
+				browserHeaders(req)
+				server.threadViews.record("1.1.1.1", "cat", 1)
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		"Honeypot empty": {
+			opts: AntibotOptions{Honeypot: "website", MaxSpamScore: 5},
+			body: `{"content": "hi", "website": ""}`,
+			setup: func(server *Server, req *http.Request) {
+				browserHeaders(req)
+				server.threadViews.record("1.1.1.1", "cat", 1)
+			},
+			expectedCode: http.StatusTeapot,
+		},
+		"Missing headers": {
+			opts:         AntibotOptions{MaxSpamScore: 5},
+			body:         `{"content": "hi"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Posted too soon": {
+			opts: AntibotOptions{MinReplyDelay: time.Hour, MaxSpamScore: 4},
+			body: `{"content": "hi"}`,
+			setup: func(server *Server, req *http.Request) {
+				browserHeaders(req)
+				server.threadViews.record("1.1.1.1", "cat", 1)
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		"Posted without viewing": {
+			opts: AntibotOptions{MinReplyDelay: time.Second, MaxSpamScore: 4},
+			body: `{"content": "hi"}`,
+			setup: func(server *Server, req *http.Request) {
+				browserHeaders(req)
+			},
+			expectedCode: http.StatusTeapot,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			server := CreateTestServer(&MockStore{}, &MockAuth{}, ServerOptions{Antibot: test.opts})
+
+			var body string
+			okHandler := func(ctx context.Context, req *request, res *response) {
+				read, _ := io.ReadAll(req.rawRequest.Body)
+				body = string(read)
+				res.Respond(http.StatusTeapot, nil, "")
+			}
+			router := httprouter.New()
+			router.POST("/v1/categories/:cat/:thread", makeHandler(server.middlewareAntibot(okHandler)))
+
+			req, err := http.NewRequest("POST", "/v1/categories/cat/1", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Real-IP", "1.1.1.1")
+			if test.setup != nil {
+				test.setup(server, req)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Errorf("expected status code %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if rr.Code == http.StatusTeapot && body != test.body {
+				t.Errorf("expected body passed on intact, got %s", body)
+			}
+		})
+	}
+}
+
+type MockReputation struct {
+	result reputation.Result
+	err    error
+}
+
+func (mr *MockReputation) Check(ctx context.Context, ip string) (reputation.Result, error) {
+	return mr.result, mr.err
+}
+
+type MockCaptcha struct{}
+
+func (mc *MockCaptcha) Verify(ctx context.Context, token string, ip string) (bool, error) {
+	return token == "solved", nil
+}
+
+func TestMiddlewareReputation(t *testing.T) {
+	listed := &MockReputation{result: reputation.Result{Sources: []string{"tor"}}}
+
+	tests := map[string]struct {
+		checker      reputation.Checker
+		policy       reputation.Policy
+		captcha      reputation.Captcha
+		token        string
+		user         *auth.UserData
+		expectedCode int
+	}{
+		"No checker": {
+			policy:       reputation.Block,
+			expectedCode: http.StatusTeapot,
+		},
+		"Allowed": {
+			checker:      listed,
+			policy:       reputation.Allow,
+			expectedCode: http.StatusTeapot,
+		},
+		"Not listed": {
+			checker:      &MockReputation{},
+			policy:       reputation.Block,
+			expectedCode: http.StatusTeapot,
+		},
+		"Check failed": {
+			checker:      &MockReputation{err: errors.New("down")},
+			policy:       reputation.Block,
+			expectedCode: http.StatusTeapot,
+		},
+		"Blocked": {
+			checker:      listed,
+			policy:       reputation.Block,
+			expectedCode: http.StatusForbidden,
+		},
+		"Login required": {
+			checker:      listed,
+			policy:       reputation.RequireLogin,
+			expectedCode: http.StatusForbidden,
+		},
+		"Unverified": {
+			checker:      listed,
+			policy:       reputation.RequireLogin,
+			user:         &auth.UserData{Username: "user"},
+			expectedCode: http.StatusForbidden,
+		},
+		"Logged in": {
+			checker:      listed,
+			policy:       reputation.RequireLogin,
+			user:         &auth.UserData{Username: "user", IsVerified: true},
+			expectedCode: http.StatusTeapot,
+		},
+		"Captcha missing": {
+			checker:      listed,
+			policy:       reputation.RequireCaptcha,
+			captcha:      &MockCaptcha{},
+			expectedCode: http.StatusForbidden,
+		},
+		"Captcha failed": {
+			checker:      listed,
+			policy:       reputation.RequireCaptcha,
+			captcha:      &MockCaptcha{},
+			token:        "wrong",
+			expectedCode: http.StatusForbidden,
+		},
+		"Captcha solved": {
+			checker:      listed,
+			policy:       reputation.RequireCaptcha,
+			captcha:      &MockCaptcha{},
+			token:        "solved",
+			expectedCode: http.StatusTeapot,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			server := CreateTestServer(&MockStore{}, &MockAuth{}, ServerOptions{
+				Reputation: test.checker,
+				ReputationPolicies: reputation.Policies{
+					Default:    reputation.Allow,
+					Categories: map[string]reputation.Policy{"cat": test.policy},
+				},
+				Captcha: test.captcha,
+			})
+
+			okHandler := func(ctx context.Context, req *request, res *response) {
+				res.Respond(http.StatusTeapot, nil, "")
+			}
+			withUser := func(ctx context.Context, req *request, res *response) {
+				req.user = test.user
+				server.middlewareReputation(okHandler)(ctx, req, res)
+			}
+			router := httprouter.New()
+			router.POST("/v1/categories/:cat/:thread", makeHandler(withUser))
+
+			req, err := http.NewRequest("POST", "/v1/categories/cat/0", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(test.token) > 0 {
+				req.Header.Set(captchaHeader, test.token)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Errorf("expected status code %d, got: %d", test.expectedCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestUnverifiedGrace(t *testing.T) {
+	unverified := &auth.UserData{ID: "new", Username: "new user", Email: "new@gmail.com"}
+	tests := map[string]struct {
+		grace        time.Duration
+		firstSeen    time.Time
+		route        string
+		expectedCode int
+	}{
+		"Reply in grace":      {time.Hour, time.Now().Add(-time.Minute), "/v1/categories/cat/1", http.StatusOK},
+		"Thread in grace":     {time.Hour, time.Now().Add(-time.Minute), "/v1/categories/cat/0", http.StatusUnauthorized},
+		"Reply after grace":   {time.Hour, time.Now().Add(-time.Hour * 2), "/v1/categories/cat/1", http.StatusUnauthorized},
+		"Reply without grace": {0, time.Now(), "/v1/categories/cat/1", http.StatusUnauthorized},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			server := CreateTestServer(&MockStore{firstSeen: test.firstSeen}, &MockAuth{user: unverified}, ServerOptions{
+				UnverifiedGrace: test.grace,
+			})
+			req, err := http.NewRequest("POST", test.route, strings.NewReader(`{"subject": "a subject", "content": "hello!"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Authorization", "ok")
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Errorf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestClaimPosts(t *testing.T) {
+	unverified := &auth.UserData{ID: "new", Username: "new user", Email: "new@gmail.com"}
+	mockStore := &MockStore{firstSeen: time.Now()}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{UnverifiedGrace: time.Hour})
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.as(unverified).do("POST", "/v1/yours/claim", ""); rr.Code != http.StatusUnauthorized || len(mockStore.claimedBy) > 0 {
+		t.Errorf("expected unverified accounts in their grace period not to claim posts, got: %d", rr.Code)
+	}
+	verified := *unverified
+	verified.IsVerified = true
+	if rr := client.as(&verified).do("GET", "/v1/yours", ""); rr.Code != http.StatusNotFound || len(mockStore.claimedBy) > 0 {
+		t.Errorf("expected listing posts not to claim any, got: %d claimed by %q", rr.Code, mockStore.claimedBy)
+	}
+	if rr := client.do("POST", "/v1/yours/claim", ""); rr.Code != http.StatusOK || mockStore.claimedBy != "new" {
+		t.Errorf("expected verified accounts to claim their posts, got: %d claimed by %q", rr.Code, mockStore.claimedBy)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	user := &auth.UserData{ID: "new", Username: "new user", Email: "new@gmail.com"}
+	mockAuth := &MockAuth{user: user}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+		RateLimiter: ratelimit.NewMemory(ratelimit.MemoryOptions{}),
+	})
+	resend := func() int {
+		req, err := http.NewRequest("POST", "/v1/verify/resend", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Authorization", "ok")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := resend(); code != http.StatusOK || mockAuth.resentTo != "new" {
+		t.Fatalf("expected verification email resent, got: %d", code)
+	}
+	if code := resend(); code != http.StatusTooManyRequests {
+		t.Errorf("expected resends to be rate limited, got: %d", code)
+	}
+	user.IsVerified = true
+	if code := resend(); code != http.StatusConflict {
+		t.Errorf("expected verified accounts to be refused, got: %d", code)
+	}
+}
+
+func TestMiddlewareScopes(t *testing.T) {
+	mockAuth := &MockAuth{
+		user: &auth.UserData{
+			Username:   "moderator",
+			Email:      "moderator@gmail.com",
+			IsVerified: true,
+			Roles:      []auth.Role{auth.RoleModerator},
+			Scopes:     []auth.Scope{auth.ScopeRead},
+		},
+	}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{})
+	okHandler := func(ctx context.Context, req *request, res *response) {
+		res.Respond(http.StatusTeapot, nil, "")
+	}
+
+	router := httprouter.New()
+	router.GET("/read", makeHandler(server.middlewareRequireLogin(okHandler)))
+	router.POST("/post", makeHandler(server.middlewareRequireLogin(okHandler)))
+	router.GET(
+		"/moderate",
+		makeHandler(server.middlewareRequireLogin(server.middlewareRequireRole(auth.RoleModerator, okHandler))),
+	)
+
+	tests := []struct {
+		scopes []auth.Scope
+		method string
+		route  string
+		status int
+	}{
+		{[]auth.Scope{auth.ScopeRead}, "GET", "/read", http.StatusTeapot},
+		{[]auth.Scope{auth.ScopeRead}, "POST", "/post", http.StatusForbidden},
+		{[]auth.Scope{auth.ScopeRead}, "GET", "/moderate", http.StatusForbidden},
+		{[]auth.Scope{auth.ScopePost}, "POST", "/post", http.StatusTeapot},
+		{[]auth.Scope{auth.ScopeModerate}, "GET", "/moderate", http.StatusTeapot},
+		{[]auth.Scope{}, "GET", "/read", http.StatusForbidden},
+		{nil, "GET", "/moderate", http.StatusTeapot},
+	}
+	for _, test := range tests {
+		mockAuth.user.Scopes = test.scopes
+		req, err := http.NewRequest(test.method, test.route, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "token")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != test.status {
+			t.Errorf("%s %s with scopes %v: expected %d, got %d", test.method, test.route, test.scopes, test.status, rr.Code)
+		}
+		if rr.Code == http.StatusForbidden && !strings.Contains(rr.Header().Get("WWW-Authenticate"), "insufficient_scope") {
+			t.Errorf("%s %s: expected insufficient_scope challenge", test.method, test.route)
+		}
+	}
+}
+
+type mockTracker struct {
+	events []*errtrack.Event
+}
+
+func (mt *mockTracker) Capture(event *errtrack.Event) {
+	mt.events = append(mt.events, event)
+}
+
+func TestMiddlewareTrackErrors(t *testing.T) {
+	tracker := &mockTracker{}
+	store := &MockStore{err: errors.New("connection refused")}
+	server := CreateTestServer(store, &MockAuth{}, ServerOptions{ErrorTracker: tracker})
+
+	req := httptest.NewRequest("GET", "/v1/categories", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+	if rr.Header().Get("X-Request-ID") != "abc-123" {
+		t.Errorf("expected request ID to be passed through, got: %s", rr.Header().Get("X-Request-ID"))
+	}
+	if len(tracker.events) != 1 {
+		t.Fatalf("expected 1 event, got: %d", len(tracker.events))
+	}
+	event := tracker.events[0]
+	if event.Tags["route"] != "GET /v1/categories" || event.Tags["request_id"] != "abc-123" {
+		t.Errorf("expected route and request ID tags, got: %v", event.Tags)
+	}
+	if !strings.Contains(event.Message, "connection refused") {
+		t.Errorf("expected the cause in the message, got: %s", event.Message)
+	}
+
+	store.err = nil
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/categories", nil))
+	if len(tracker.events) != 1 {
+		t.Errorf("expected successful requests not to be reported, got %d events", len(tracker.events))
+	}
+
+	rr = httptest.NewRecorder()
+	rr.Header().Set("X-Request-ID", "def-456")
+	server.handlePanic(rr, httptest.NewRequest("GET", "/v1/categories", nil), "oh no")
+	if len(tracker.events) != 2 {
+		t.Fatalf("expected panic to be reported, got %d events", len(tracker.events))
+	}
+	event = tracker.events[1]
+	if event.Level != "fatal" || len(event.Stack) == 0 || event.Tags["request_id"] != "def-456" {
+		t.Errorf("expected fatal event with a stack and request ID, got: %+v", event)
+	}
+}
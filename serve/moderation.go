@@ -0,0 +1,331 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strconv"
+	"time"
+)
+
+const maxQueueItems = 100
+
+const maxModLogEntries = 100
+
+var errBadPostNumber = errors.New("invalid post number")
+
+// How long a moderator's claim on a queue item lasts without being renewed.
+const queueClaimTTL = time.Minute * 5
+
+// queueItem is a queued post, and the moderator who's claimed it if any.
+type queueItem struct {
+	*data.QueueItem
+	ClaimedBy string `json:"claimedBy,omitempty"`
+}
+
+func queueClaimKey(categoryTag string, num int) string {
+	return fmt.Sprintf("queue:%s/%d", categoryTag, num)
+}
+
+// Returns route parameters for a queued post.
+func getQueueParameters(req *request) (string, int, error) {
+	num, err := strconv.Atoi(req.params.ByName("num"))
+	if err != nil {
+		return "", 0, errBadPostNumber
+	}
+	return req.params.ByName("cat"), num, nil
+}
+
+// handleCreateReport handles a POST request reporting a post to moderators.
+func (server *Server) handleCreateReport(ctx context.Context, req *request, res *response) {
+	report, err := getIncomingReport(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = report.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.store.WriteReport(ctx, report.Cat, report.Num, report.Reason, data.PosterHash(req.ip))
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to save report", err)
+		return
+	}
+
+	server.events.Publish(events.Event{
+		Kind:     events.ReportFiled,
+		Category: report.Cat,
+		Num:      report.Num,
+	})
+	res.Respond(http.StatusOK, ok{Message: "report submitted"}, "")
+}
+
+// handleCreateNote handles a POST request from a moderator adding a private note on a post or poster.
+func (server *Server) handleCreateNote(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingNote(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	note := &data.Note{
+		Cat:        incoming.Cat,
+		Num:        incoming.Num,
+		PosterHash: incoming.PosterHash,
+		Content:    incoming.Content,
+		Author:     req.user.Email,
+		CreatedAt:  time.Now(),
+	}
+	note.ID, err = server.store.WriteNote(ctx, note)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to save note", err)
+		return
+	}
+	res.Respond(http.StatusOK, note, "")
+}
+
+// handleRemoveNote handles a DELETE request from a moderator dropping a note.
+func (server *Server) handleRemoveNote(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid note ID")
+		return
+	}
+
+	err = server.store.RemoveNote(ctx, id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to remove note", err)
+		return
+	}
+	log.Printf("Note %d removed by %s", id, req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "removed"}, "")
+}
+
+// handleGetQueue handles a GET request for posts awaiting moderation.
+func (server *Server) handleGetQueue(ctx context.Context, req *request, res *response) {
+	items, err := server.store.GetModerationQueue(ctx, maxQueueItems)
+	if err != nil {
+		res.Fail("Failed to get moderation queue", err)
+		return
+	}
+
+	queue := make([]queueItem, 0, len(items))
+	for _, item := range items {
+		claimedBy, err := server.locker.Holder(ctx, queueClaimKey(item.Post.Cat, item.Post.Num))
+		if err != nil {
+			log.Printf("Failed to look up queue claim: %v", err)
+		}
+		queue = append(queue, queueItem{QueueItem: item, ClaimedBy: claimedBy})
+	}
+	res.Respond(http.StatusOK, queue, "")
+}
+
+// handleClaimQueueItem handles a POST request from a moderator claiming, or renewing their claim on, a queued post.
+func (server *Server) handleClaimQueueItem(ctx context.Context, req *request, res *response) {
+	categoryTag, num, err := getQueueParameters(req)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	holder, acquired, err := server.locker.Acquire(ctx, queueClaimKey(categoryTag, num), req.user.Email, queueClaimTTL)
+	if err != nil {
+		res.Respond(http.StatusServiceUnavailable, nil, genericFailMessage)
+		log.Printf("Failed to claim queue item: %v", err)
+		return
+	}
+	if !acquired {
+		res.Respond(http.StatusConflict, nil, fmt.Sprintf("already claimed by %s", holder))
+		return
+	}
+	res.Respond(http.StatusOK, ok{Message: "claimed"}, "")
+}
+
+// handleUnclaimQueueItem handles a DELETE request from a moderator giving up their claim on a queued post.
+func (server *Server) handleUnclaimQueueItem(ctx context.Context, req *request, res *response) {
+	categoryTag, num, err := getQueueParameters(req)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.locker.Release(ctx, queueClaimKey(categoryTag, num), req.user.Email)
+	if err != nil {
+		res.Respond(http.StatusServiceUnavailable, nil, genericFailMessage)
+		log.Printf("Failed to release queue claim: %v", err)
+		return
+	}
+	res.Respond(http.StatusOK, ok{Message: "unclaimed"}, "")
+}
+
+/*
+handleResolveQueueItem handles a POST request approving, deleting, or banning the poster of a queued post.
+The moderator claims the post for the length of the request, so it can't be resolved twice.
+*/
+func (server *Server) handleResolveQueueItem(ctx context.Context, req *request, res *response) {
+	categoryTag, num, err := getQueueParameters(req)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	resolution, err := getIncomingResolution(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = resolution.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	claimKey := queueClaimKey(categoryTag, num)
+	holder, acquired, err := server.locker.Acquire(ctx, claimKey, req.user.Email, queueClaimTTL)
+	if err != nil {
+		res.Respond(http.StatusServiceUnavailable, nil, genericFailMessage)
+		log.Printf("Failed to claim queue item: %v", err)
+		return
+	}
+	if !acquired {
+		res.Respond(http.StatusConflict, nil, fmt.Sprintf("already claimed by %s", holder))
+		return
+	}
+	defer func() {
+		if err := server.locker.Release(context.Background(), claimKey, req.user.Email); err != nil {
+			log.Printf("Failed to release queue claim: %v", err)
+		}
+	}()
+
+	err = server.store.ResolveQueueItem(ctx, categoryTag, num, resolution.resolution())
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to resolve queued post", err)
+		return
+	}
+	log.Printf("Queued post %s/%d resolved by %s: %s", categoryTag, num, req.user.Email, resolution.Action)
+
+	if resolution.Action != string(data.ActionApprove) {
+		server.events.Publish(events.Event{
+			Kind:     events.PostDeleted,
+			Category: categoryTag,
+			Num:      num,
+		})
+	}
+	res.Respond(http.StatusOK, ok{Message: "resolved"}, "")
+}
+
+// handleGetModLog handles a GET request for a category's recent moderation actions, public if the log is.
+func (server *Server) handleGetModLog(ctx context.Context, req *request, res *response) {
+	tag, err := server.categoryTag(ctx, req.params.ByName("cat"))
+	if err != nil {
+		res.Fail("Failed to resolve category slug", err)
+		return
+	}
+	entries, err := server.store.GetModLog(ctx, tag, maxModLogEntries)
+	if err != nil {
+		res.Fail("Failed to get moderation log", err)
+		return
+	}
+	res.Respond(http.StatusOK, entries, "")
+}
+
+type postActionResult struct {
+	data.PostRef
+	data.ActionResult
+}
+
+type banActionResult struct {
+	Target string `json:"target"`
+	data.ActionResult
+}
+
+// bulkActionsResult reports the outcome of every action in a bulk request, in the order they were given.
+type bulkActionsResult struct {
+	DeletePosts []postActionResult `json:"deletePosts"`
+	BanIPs      []banActionResult  `json:"banIPs"`
+	LockThreads []postActionResult `json:"lockThreads"`
+}
+
+func postActionResults(posts []data.PostRef, results []data.ActionResult) []postActionResult {
+	combined := make([]postActionResult, len(posts))
+	for i, post := range posts {
+		combined[i] = postActionResult{PostRef: post, ActionResult: results[i]}
+	}
+	return combined
+}
+
+// handleBulkActions handles a POST request deleting posts, banning IPs, and locking threads in bulk.
+func (server *Server) handleBulkActions(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingBulkActions(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	actions, err := incoming.actions()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	results, err := server.store.ApplyBulkActions(ctx, actions)
+	if err != nil {
+		res.Fail("Failed to apply bulk actions", err)
+		return
+	}
+	log.Printf(
+		"Bulk actions by %s: %d deletes, %d bans, %d locks",
+		req.user.Email, len(actions.DeletePosts), len(actions.BanPosters), len(actions.LockThreads),
+	)
+
+	result := bulkActionsResult{
+		DeletePosts: postActionResults(actions.DeletePosts, results.DeletePosts),
+		BanIPs:      make([]banActionResult, len(incoming.BanIPs)),
+		LockThreads: postActionResults(actions.LockThreads, results.LockThreads),
+	}
+	for i, target := range incoming.BanIPs {
+		result.BanIPs[i] = banActionResult{Target: target, ActionResult: results.BanPosters[i]}
+	}
+	for i, thread := range actions.LockThreads {
+		if results.LockThreads[i].OK {
+			server.events.Publish(events.Event{Kind: events.ThreadLocked, Category: thread.Cat, Num: thread.Num})
+		}
+	}
+	for i, post := range actions.DeletePosts {
+		if results.DeletePosts[i].OK {
+			server.events.Publish(events.Event{Kind: events.PostDeleted, Category: post.Cat, Num: post.Num})
+		}
+	}
+	res.Respond(http.StatusOK, result, "")
+}
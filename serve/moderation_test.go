@@ -0,0 +1,375 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"strings"
+	"testing"
+	"time"
+)
+
+func moderator(email string) *auth.UserData {
+	return &auth.UserData{
+		Username:   email,
+		Email:      email,
+		IsVerified: true,
+		Roles:      []auth.Role{auth.RoleModerator},
+	}
+}
+
+// withScopes returns a copy of the user whose token was only granted the scopes.
+func withScopes(user *auth.UserData, scopes ...auth.Scope) *auth.UserData {
+	scoped := *user
+	scoped.Scopes = scopes
+	return &scoped
+}
+
+func TestQueueClaims(t *testing.T) {
+	mockStore := &MockStore{
+		queue: []*data.QueueItem{{Post: &data.Post{Num: 1, Cat: "cat"}, Thread: 1, Reports: []string{"spam"}}},
+	}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+	alice, bob := moderator("alice@gmail.com"), moderator("bob@gmail.com")
+
+	if rr := client.as(alice).do("POST", "/v1/admin/queue/cat/1/claim", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected alice's claim to succeed, got: %d", rr.Code)
+	}
+	if rr := client.as(bob).do("POST", "/v1/admin/queue/cat/1/claim", ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected bob's claim to conflict, got: %d", rr.Code)
+	}
+	if rr := client.as(bob).do("POST", "/v1/admin/queue/cat/1", `{"action": "delete"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected bob's resolution to conflict, got: %d", rr.Code)
+	}
+
+	rr := client.as(bob).do("GET", "/v1/admin/queue", "")
+	var queue []queueItem
+	if err := json.NewDecoder(rr.Body).Decode(&queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].ClaimedBy != alice.Email {
+		t.Errorf("expected queued post claimed by alice, got %+v", queue)
+	}
+
+	if rr := client.as(alice).do("POST", "/v1/admin/queue/cat/1", `{"action": "ban", "reason": "spam", "banDays": 7}`); rr.Code != http.StatusOK {
+		t.Errorf("expected alice's resolution to succeed, got: %d", rr.Code)
+	}
+	if len(mockStore.resolved) != 1 || mockStore.resolved[0].Action != data.ActionBan || mockStore.resolved[0].BanFor != time.Hour*24*7 {
+		t.Errorf("expected a 7 day ban, got %+v", mockStore.resolved)
+	}
+
+	// Resolving releases the claim.
+	if rr := client.as(bob).do("POST", "/v1/admin/queue/cat/1/claim", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected bob's claim to succeed after resolution, got: %d", rr.Code)
+	}
+	if rr := client.as(bob).do("DELETE", "/v1/admin/queue/cat/1/claim", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected bob to unclaim, got: %d", rr.Code)
+	}
+	if rr := client.as(alice).do("POST", "/v1/admin/queue/cat/1", `{"action": "smite"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown action to be rejected, got: %d", rr.Code)
+	}
+}
+
+func TestBannedPoster(t *testing.T) {
+	mockAuth := &MockAuth{
+		user: &auth.UserData{
+			Username:   "test user",
+			Email:      "test@gmail.com",
+			IsVerified: true,
+		},
+	}
+	server := CreateTestServer(&MockStore{banned: true}, mockAuth, ServerOptions{})
+
+	req, err := http.NewRequest("POST", "/v1/categories/cat/1", bytes.NewReader([]byte(`{"Content": "hello!"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "ok")
+
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got: %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestBulkActions(t *testing.T) {
+	tests := map[string]struct {
+		body         string
+		expectedCode int
+	}{
+		"No actions": {
+			body:         `{}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Bad ban target": {
+			body:         `{"banIPs": ["nonsense"]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Too many actions": {
+			body:         `{"deletePosts": [` + strings.Repeat(`{"cat": "cat", "num": 1},`, maxBulkActions) + `{"cat": "cat", "num": 1}]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Valid": {
+			body:         `{"deletePosts": [{"cat": "cat", "num": 2}, {"cat": "fail", "num": 1}], "banIPs": ["10.0.0.1"], "lockThreads": [{"cat": "cat", "num": 1}], "banDays": 1}`,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockStore := &MockStore{}
+			mockAuth := &MockAuth{user: moderator("mod@gmail.com")}
+			server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+			rr := serveConfirmed(t, server, func() *http.Request {
+				req := httptest.NewRequest("POST", "/v1/admin/actions", bytes.NewReader([]byte(test.body)))
+				req.Header.Add("Authorization", "ok")
+				return req
+			})
+			if rr.Code != test.expectedCode {
+				t.Fatalf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			if mockStore.bulkActions.BanPosters[0] != data.PosterHash("10.0.0.1") {
+				t.Errorf("expected banned IP hashed, got %s", mockStore.bulkActions.BanPosters[0])
+			}
+			var result bulkActionsResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if len(result.DeletePosts) != 2 || !result.DeletePosts[0].OK || result.DeletePosts[1].OK {
+				t.Errorf("expected second delete to fail, got %+v", result.DeletePosts)
+			}
+			if len(result.BanIPs) != 1 || result.BanIPs[0].Target != "10.0.0.1" || !result.BanIPs[0].OK {
+				t.Errorf("expected ban reported against the given IP, got %+v", result.BanIPs)
+			}
+			if len(result.LockThreads) != 1 || result.LockThreads[0].Num != 1 {
+				t.Errorf("expected lock result, got %+v", result.LockThreads)
+			}
+		})
+	}
+}
+
+func TestNotes(t *testing.T) {
+	tests := map[string]struct {
+		method       string
+		route        string
+		body         string
+		expectedCode int
+	}{
+		"Post note": {
+			method:       "POST",
+			route:        "/v1/admin/notes",
+			body:         `{"cat": "cat", "num": 1, "content": "warned previously for spam"}`,
+			expectedCode: http.StatusOK,
+		},
+		"Poster note": {
+			method:       "POST",
+			route:        "/v1/admin/notes",
+			body:         `{"posterHash": "` + data.PosterHash("10.0.0.1") + `", "content": "ban evader"}`,
+			expectedCode: http.StatusOK,
+		},
+		"Both targets": {
+			method:       "POST",
+			route:        "/v1/admin/notes",
+			body:         `{"cat": "cat", "num": 1, "posterHash": "` + data.PosterHash("10.0.0.1") + `", "content": "hi"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"No target": {
+			method:       "POST",
+			route:        "/v1/admin/notes",
+			body:         `{"content": "hi"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Empty": {
+			method:       "POST",
+			route:        "/v1/admin/notes",
+			body:         `{"cat": "cat", "num": 1, "content": " "}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Missing post": {
+			method:       "POST",
+			route:        "/v1/admin/notes",
+			body:         `{"cat": "missing", "num": 1, "content": "hi"}`,
+			expectedCode: http.StatusNotFound,
+		},
+		"Remove missing": {
+			method:       "DELETE",
+			route:        "/v1/admin/notes/5",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockStore := &MockStore{}
+			server := CreateTestServer(mockStore, &MockAuth{user: moderator("mod@gmail.com")}, ServerOptions{})
+
+			req, err := http.NewRequest(test.method, test.route, bytes.NewReader([]byte(test.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Authorization", "ok")
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Fatalf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if rr.Code == http.StatusOK && test.method == "POST" {
+				if len(mockStore.notes) != 1 || mockStore.notes[0].Author != "mod@gmail.com" {
+					t.Errorf("expected note written by the moderator, got %+v", mockStore.notes)
+				}
+			}
+		})
+	}
+}
+
+func TestCapcode(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin, auth.RoleRetention)
+	tests := map[string]struct {
+		user         *auth.UserData
+		capcode      string
+		expectedCode int
+	}{
+		"No capcode": {
+			user:         moderator("mod@gmail.com"),
+			expectedCode: http.StatusOK,
+		},
+		"Moderator": {
+			user:         moderator("mod@gmail.com"),
+			capcode:      "moderator",
+			expectedCode: http.StatusOK,
+		},
+		"Moderator without the moderate scope": {
+			user:         withScopes(moderator("mod@gmail.com"), auth.ScopePost),
+			capcode:      "moderator",
+			expectedCode: http.StatusForbidden,
+		},
+		"Moderator as admin": {
+			user:         moderator("mod@gmail.com"),
+			capcode:      "admin",
+			expectedCode: http.StatusForbidden,
+		},
+		"Admin": {
+			user:         admin,
+			capcode:      "admin",
+			expectedCode: http.StatusOK,
+		},
+		"Not a capcode role": {
+			user:         admin,
+			capcode:      "retention",
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockStore := &MockStore{}
+			server := CreateTestServer(mockStore, &MockAuth{user: test.user}, ServerOptions{})
+
+			body := `{"content": "hello!", "capcode": "` + test.capcode + `"}`
+			req, err := http.NewRequest("POST", "/v1/categories/cat/1", bytes.NewReader([]byte(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Authorization", "ok")
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Fatalf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if rr.Code == http.StatusOK && mockStore.capcode != test.capcode {
+				t.Errorf("expected post written with capcode %q, got %q", test.capcode, mockStore.capcode)
+			}
+		})
+	}
+}
+
+func TestModLog(t *testing.T) {
+	for _, test := range []struct {
+		route        string
+		public       bool
+		auth         string
+		expectedCode int
+	}{
+		{"/v1/modlog/cat", true, "", http.StatusOK},
+		{"/v1/modlog/cat", false, "", http.StatusNotFound},
+		{"/v1/admin/categories/cat/modlog", false, "ok", http.StatusOK},
+		{"/v1/admin/categories/cat/modlog", true, "", http.StatusUnauthorized},
+	} {
+		mockAuth := &MockAuth{user: staffUser(auth.RoleModerator)}
+		server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{PublicModLog: test.public})
+		req, err := http.NewRequest("GET", test.route, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(test.auth) > 0 {
+			req.Header.Add("Authorization", test.auth)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Fatalf("%s: expected status %d, got: %d", test.route, test.expectedCode, rr.Code)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+		var entries []data.ModLogEntry
+		if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Rule != 3 {
+			t.Errorf("expected moderation log entry, got %+v", entries)
+		}
+	}
+}
+
+func TestCategoryRules(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{RequireRulesAcceptance: true})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.as(moderator("mod@gmail.com")).do("PUT", "/v1/admin/categories/cat/rules", `{"rules": ["Be nice"]}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected moderators not to set rules, got: %d", rr.Code)
+	}
+	if rr := client.as(admin).do("PUT", "/v1/admin/categories/cat/rules", `{"rules": ["Be nice", ""]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected empty rule to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.as(admin).do("PUT", "/v1/admin/categories/cat/rules", `{"rules": [" Be nice ", "No spam"]}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected rules to be set, got: %d", rr.Code)
+	}
+	if len(mockStore.categoryRules) != 2 || mockStore.categoryRules[0] != "Be nice" {
+		t.Errorf("expected sanitized rules, got %q", mockStore.categoryRules)
+	}
+
+	poster := &auth.UserData{Username: "poster", Email: "poster@gmail.com", IsVerified: true}
+	if rr := client.as(poster).do("POST", "/v1/categories/cat/1", `{"content": "hello!"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected post without accepting rules to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.as(poster).do("POST", "/v1/categories/cat/1", `{"content": "hello!", "acceptedRules": true}`); rr.Code != http.StatusOK {
+		t.Errorf("expected post accepting rules to succeed, got: %d", rr.Code)
+	}
+	if len(mockStore.acceptedRules) != 2 || mockStore.acceptedRules[1] != data.PosterHash("poster@gmail.com") {
+		t.Errorf("expected acceptance recorded by IP and email, got %v", mockStore.acceptedRules)
+	}
+	if rr := client.as(poster).do("POST", "/v1/categories/cat/1", `{"content": "hello again!"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected later posts not to need acceptance, got: %d", rr.Code)
+	}
+}
@@ -0,0 +1,21 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+)
+
+/*
+handleGetNotifications handles a GET request for a page of the user's notifications, newest first,
+marking them read. Older pages are fetched by passing the ID of the oldest notification seen as before.
+*/
+func (server *Server) handleGetNotifications(ctx context.Context, req *request, res *response) {
+	server.recordAccount(ctx, req)
+	before, limit := getPage(req)
+	notifications, err := server.store.GetNotifications(ctx, req.user.ID, before, limit)
+	if err != nil {
+		res.Fail("Failed to get notifications", err)
+		return
+	}
+	res.Respond(http.StatusOK, notifications, "")
+}
@@ -0,0 +1,33 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestNotifications(t *testing.T) {
+	mockStore := &MockStore{notifications: []*data.Notification{{ID: 4, Kind: data.NotificationMention, Cat: "cat", Num: 2, From: "op"}}}
+	mockAuth := &MockAuth{user: &auth.UserData{ID: "auth0|spirit", Username: "spirit", Email: "spirit@gmail.com"}}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	rr := client.do("GET", "/v1/me", "")
+	var got struct {
+		UnreadNotifications int `json:"unreadNotifications"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.UnreadNotifications != 1 {
+		t.Errorf("expected 1 unread notification, got: %d", got.UnreadNotifications)
+	}
+
+	// Unverified accounts can still see who mentioned them.
+	if rr := client.do("GET", "/v1/notifications?before=5", ""); rr.Code != http.StatusOK || mockStore.pageBefore != 5 || mockStore.pageLimit != defaultPageSize {
+		t.Errorf("expected notifications before 5, got: %d before %d", rr.Code, mockStore.pageBefore)
+	}
+}
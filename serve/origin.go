@@ -0,0 +1,65 @@
+package serve
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+trustedOrigins are the sites browsers can send writes from. Tokens kept in browser storage aren't sent
+by other sites the way cookies are, so this only backs that up, in case one leaks into a page that can.
+Nil trusts every origin.
+*/
+type trustedOrigins map[string]bool
+
+// originOf reduces a URL to its scheme and host, empty if it has neither.
+func originOf(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+func newTrustedOrigins(origins []string) trustedOrigins {
+	if len(origins) == 0 {
+		return nil
+	}
+	trusted := make(trustedOrigins, len(origins))
+	for _, origin := range origins {
+		if normalized := originOf(origin); len(normalized) > 0 {
+			trusted[normalized] = true
+		} else {
+			log.Printf("Ignoring trusted origin %q, it needs a scheme and host", origin)
+		}
+	}
+	return trusted
+}
+
+/*
+rejectsCrossSite responds with an error and returns true if a write came from a page on an untrusted origin,
+going by its Origin header, or its Referer without one. Requests with neither, from clients that aren't browsers
+or browsers that withheld them, are let through.
+*/
+func (trusted trustedOrigins) rejectsCrossSite(req *request, res *response) bool {
+	if trusted == nil || req.rawRequest.Method == http.MethodGet || req.rawRequest.Method == http.MethodHead {
+		return false
+	}
+
+	source := req.header.Get("Origin")
+	if len(source) == 0 {
+		source = req.header.Get("Referer")
+		if len(source) == 0 {
+			return false
+		}
+	}
+	// Opaque origins like "null" come out empty, and aren't trusted.
+	if trusted[originOf(source)] {
+		return false
+	}
+	log.Printf("Refused cross-site %s %s from %q", req.rawRequest.Method, req.rawRequest.URL.Path, source)
+	res.Respond(http.StatusForbidden, nil, "requests from other sites aren't allowed")
+	return true
+}
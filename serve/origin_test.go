@@ -0,0 +1,75 @@
+package serve
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"testing"
+)
+
+func TestTrustedOrigins(t *testing.T) {
+	tests := map[string]struct {
+		trusted      []string
+		method       string
+		origin       string
+		referer      string
+		expectedCode int
+	}{
+		"No trusted origins": {
+			method: "POST", origin: "https://evil.example", expectedCode: http.StatusOK,
+		},
+		"Trusted origin": {
+			trusted: []string{"https://spirit.example/"}, method: "POST", origin: "https://SPIRIT.example", expectedCode: http.StatusOK,
+		},
+		"Untrusted origin": {
+			trusted: []string{"https://spirit.example"}, method: "POST", origin: "https://evil.example", expectedCode: http.StatusForbidden,
+		},
+		"Origin on another port": {
+			trusted: []string{"https://spirit.example"}, method: "DELETE", origin: "https://spirit.example:8443", expectedCode: http.StatusForbidden,
+		},
+		"Opaque origin": {
+			trusted: []string{"https://spirit.example"}, method: "POST", origin: "null", expectedCode: http.StatusForbidden,
+		},
+		"Trusted referer": {
+			trusted: []string{"https://spirit.example"}, method: "POST", referer: "https://spirit.example/tech/1", expectedCode: http.StatusOK,
+		},
+		"Untrusted referer": {
+			trusted: []string{"https://spirit.example"}, method: "POST", referer: "http://spirit.example/tech/1", expectedCode: http.StatusForbidden,
+		},
+		"Neither": {
+			trusted: []string{"https://spirit.example"}, method: "POST", expectedCode: http.StatusOK,
+		},
+		"Reads aren't checked": {
+			trusted: []string{"https://spirit.example"}, method: "GET", origin: "https://evil.example", expectedCode: http.StatusOK,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockAuth := &MockAuth{user: &auth.UserData{Username: "test user", Email: "test@gmail.com", IsVerified: true}}
+			server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+				TrustedOrigins: test.trusted,
+			})
+
+			route := "/v1/categories/cat/1"
+			if test.method == "GET" {
+				route = "/v1/categories"
+			}
+			req := httptest.NewRequest(test.method, route, bytes.NewReader([]byte(`{"content": "hello!"}`)))
+			req.Header.Add("Authorization", "ok")
+			if len(test.origin) > 0 {
+				req.Header.Set("Origin", test.origin)
+			}
+			if len(test.referer) > 0 {
+				req.Header.Set("Referer", test.referer)
+			}
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Errorf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/validation"
+	"time"
+)
+
+// handleGetPages handles a GET request listing static pages.
+func (server *Server) handleGetPages(ctx context.Context, req *request, res *response) {
+	pages, err := server.store.GetPages(ctx)
+	if err != nil {
+		res.Fail("Failed to get pages", err)
+		return
+	}
+	res.Respond(http.StatusOK, pages, "")
+}
+
+// handleGetPage handles a GET request for a static page by its slug.
+func (server *Server) handleGetPage(ctx context.Context, req *request, res *response) {
+	page, err := server.store.GetPage(ctx, req.params.ByName("slug"))
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to get page", err)
+		return
+	}
+	res.Respond(http.StatusOK, page, "")
+}
+
+// handleWritePage handles a PUT request creating or replacing a static page.
+func (server *Server) handleWritePage(ctx context.Context, req *request, res *response) {
+	slug, err := validation.ValidatePageSlug(req.params.ByName("slug"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	incoming, err := getIncomingPage(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	page := &data.Page{
+		Slug:      slug,
+		Title:     incoming.Title,
+		Body:      incoming.Body,
+		UpdatedAt: time.Now(),
+	}
+	err = server.store.WritePage(ctx, page)
+	if err != nil {
+		res.Fail("Failed to write page", err)
+		return
+	}
+	log.Printf("Page %s written by %s", slug, req.user.Email)
+	res.Respond(http.StatusOK, page, "")
+}
+
+// handleRemovePage handles a DELETE request dropping a static page.
+func (server *Server) handleRemovePage(ctx context.Context, req *request, res *response) {
+	slug := req.params.ByName("slug")
+	err := server.store.RemovePage(ctx, slug)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to remove page", err)
+		return
+	}
+	log.Printf("Page %s removed by %s", slug, req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "removed"}, "")
+}
@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestPages(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.as(moderator("mod@gmail.com")).do("PUT", "/v1/admin/pages/faq", `{"title": "FAQ", "body": "Ask away"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected moderators not to write pages, got: %d", rr.Code)
+	}
+	if rr := client.as(admin).do("PUT", "/v1/admin/pages/FAQ!", `{"title": "FAQ", "body": "Ask away"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected bad slug to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.as(admin).do("PUT", "/v1/admin/pages/faq", `{"title": "FAQ"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected empty body to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.as(admin).do("PUT", "/v1/admin/pages/faq", `{"title": "FAQ", "body": "# Questions\n\nAsk away"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected page to be written, got: %d", rr.Code)
+	}
+
+	rr := client.as(nil).do("GET", "/v1/pages/faq", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected page, got: %d", rr.Code)
+	}
+	var page data.Page
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "FAQ" || page.Body != "# Questions\n\nAsk away" {
+		t.Errorf("expected written page, got %+v", page)
+	}
+
+	rr = client.as(nil).do("GET", "/v1/pages", "")
+	var pages []data.Page
+	if err := json.NewDecoder(rr.Body).Decode(&pages); err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Slug != "faq" || len(pages[0].Body) != 0 {
+		t.Errorf("expected page listed without its body, got %+v", pages)
+	}
+
+	if rr := client.as(admin).do("DELETE", "/v1/admin/pages/faq", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected page to be removed, got: %d", rr.Code)
+	}
+	if rr := client.as(nil).do("GET", "/v1/pages/faq", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected removed page to 404, got: %d", rr.Code)
+	}
+}
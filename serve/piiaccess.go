@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/validation"
+	"strings"
+)
+
+// Records of staff viewing emails or IPs returned to admins.
+const maxPIIAccesses = 200
+
+var errAccessReasonRequired = errors.New("give a reason for viewing personal data in the reason query parameter")
+
+/*
+middlewareRequirePIIReason makes staff say why they're viewing emails or IPs in the reason query parameter,
+recording who viewed what and why before the request goes through. Requests that can't be recorded aren't served.
+Must run after middlewareRequireLogin.
+*/
+func (s *Server) middlewareRequirePIIReason(next handlerFunc) handlerFunc {
+	return func(ctx context.Context, req *request, res *response) {
+		reason := strings.TrimSpace(req.rawRequest.URL.Query().Get("reason"))
+		if len(reason) == 0 {
+			res.Respond(http.StatusBadRequest, nil, errAccessReasonRequired.Error())
+			return
+		}
+		reason, err := validation.ValidateNote(reason)
+		if err != nil {
+			res.Respond(http.StatusBadRequest, nil, err.Error())
+			return
+		}
+
+		access := &data.PIIAccess{
+			Staff:  req.user.Email,
+			Method: req.rawRequest.Method,
+			Path:   req.rawRequest.URL.Path,
+			Reason: reason,
+		}
+		if err := s.store.LogPIIAccess(ctx, access); err != nil {
+			res.Fail("Failed to log personal data access", err)
+			return
+		}
+		log.Printf("%s viewed personal data at %s %s: %s", access.Staff, access.Method, access.Path, access.Reason)
+		next(ctx, req, res)
+	}
+}
+
+// handleGetPIIAccesses handles a GET request from an admin for the latest records of staff viewing emails or IPs.
+func (server *Server) handleGetPIIAccesses(ctx context.Context, req *request, res *response) {
+	accesses, err := server.store.GetPIIAccesses(ctx, maxPIIAccesses)
+	if err != nil {
+		res.Fail("Failed to get personal data accesses", err)
+		return
+	}
+	res.Respond(http.StatusOK, accesses, "")
+}
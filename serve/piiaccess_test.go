@@ -0,0 +1,45 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"strings"
+	"testing"
+)
+
+func TestPIIAccessLog(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin, auth.RoleImpersonate)
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: admin}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	for _, path := range []string{"/v1/admin/impersonations", "/v1/admin/canaries"} {
+		rr := client.do("GET", path, "")
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errAccessReasonRequired.Error()) {
+			t.Errorf("%s: expected a reason required, got: %d %s", path, rr.Code, rr.Body.String())
+		}
+	}
+	if len(mockStore.piiAccesses) != 0 {
+		t.Fatalf("expected refused requests not to be logged, got %+v", mockStore.piiAccesses)
+	}
+	if rr := client.do("GET", "/v1/admin/impersonations?reason=%20%20support%20ticket%2042", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected impersonations with a reason, got: %d", rr.Code)
+	}
+
+	rr := client.do("GET", "/v1/admin/pii-access", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	var accesses []*data.PIIAccess
+	if err := json.NewDecoder(rr.Body).Decode(&accesses); err != nil {
+		t.Fatal(err)
+	}
+	if len(accesses) != 1 || accesses[0].Staff != "admin@gmail.com" || accesses[0].Path != "/v1/admin/impersonations" ||
+		accesses[0].Reason != "support ticket 42" {
+		t.Errorf("expected who viewed what and why, got %+v", accesses)
+	}
+}
@@ -0,0 +1,148 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/events"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long a long poll waits for new posts by default, and at most.
+const defaultPollTimeout = time.Second * 30
+const maxPollTimeout = time.Second * 60
+
+// pollHub wakes long polls on a thread when a post is made in it, on this instance or any it hears from.
+type pollHub struct {
+	mut     sync.Mutex
+	waiters map[string]map[chan struct{}]bool
+}
+
+func newPollHub(bus *events.Bus) *pollHub {
+	hub := &pollHub{waiters: make(map[string]map[chan struct{}]bool)}
+	bus.SubscribeLive(hub.handlePostCreated, events.PostCreated)
+	return hub
+}
+
+func pollKey(categoryTag string, thread int) string {
+	return fmt.Sprintf("%s/%d", categoryTag, thread)
+}
+
+// wait returns a channel closed on the next post in the thread, and a func to stop waiting.
+func (hub *pollHub) wait(categoryTag string, thread int) (<-chan struct{}, func()) {
+	key := pollKey(categoryTag, thread)
+	ch := make(chan struct{})
+
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	if hub.waiters[key] == nil {
+		hub.waiters[key] = make(map[chan struct{}]bool)
+	}
+	hub.waiters[key][ch] = true
+
+	return ch, func() {
+		hub.mut.Lock()
+		defer hub.mut.Unlock()
+		// Woken channels are already gone.
+		if hub.waiters[key][ch] {
+			delete(hub.waiters[key], ch)
+			if len(hub.waiters[key]) == 0 {
+				delete(hub.waiters, key)
+			}
+		}
+	}
+}
+
+func (hub *pollHub) handlePostCreated(ctx context.Context, event events.Event) {
+	thread := event.Parent
+	if thread == 0 {
+		thread = event.Num
+	}
+	key := pollKey(event.Category, thread)
+
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+	for ch := range hub.waiters[key] {
+		close(ch)
+	}
+	delete(hub.waiters, key)
+}
+
+// newPosts returns the posts in a thread after the given post number.
+func (server *Server) newPosts(ctx context.Context, categoryTag string, thread int, since int) ([]*data.Post, error) {
+	view, err := server.store.GetThreadView(ctx, categoryTag, thread)
+	if err != nil {
+		return nil, err
+	}
+	posts := make([]*data.Post, 0)
+	for _, post := range view.Posts {
+		if post.Num > since {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+/*
+handlePollThread handles a GET request for posts in a thread after since, waiting up to timeout for one to be made
+if there aren't any yet. Responds with no posts if none were made in time, for clients to poll again.
+*/
+func (server *Server) handlePollThread(ctx context.Context, req *request, res *response) {
+	thread, err := strconv.Atoi(req.params.ByName("thread"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid thread number")
+		return
+	}
+	query := req.rawRequest.URL.Query()
+	since := 0
+	if val := query.Get("since"); len(val) > 0 {
+		since, err = strconv.Atoi(val)
+		if err != nil || since < 0 {
+			res.Respond(http.StatusBadRequest, nil, "invalid since")
+			return
+		}
+	}
+	timeout := defaultPollTimeout
+	if val := query.Get("timeout"); len(val) > 0 {
+		timeout, err = time.ParseDuration(val)
+		if err != nil || timeout <= 0 {
+			res.Respond(http.StatusBadRequest, nil, "invalid timeout")
+			return
+		}
+		if timeout > maxPollTimeout {
+			timeout = maxPollTimeout
+		}
+	}
+	cat := req.params.ByName("cat")
+	res.rw.Header().Set("Cache-Control", "no-store")
+
+	// Wait before looking, so a post made in between isn't missed.
+	woken, stop := server.polls.wait(cat, thread)
+	defer stop()
+
+	posts, err := server.newPosts(ctx, cat, thread, since)
+	if err == nil && len(posts) == 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-woken:
+			posts, err = server.newPosts(ctx, cat, thread, since)
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to poll thread", err)
+		return
+	}
+	res.Respond(http.StatusOK, posts, "")
+}
@@ -0,0 +1,77 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/data"
+	"spiritchat/events"
+	"testing"
+	"time"
+)
+
+func TestPollThread(t *testing.T) {
+	mockStore := &MockStore{
+		getThreadView: &data.ThreadView{
+			Posts: []*data.Post{{Num: 1, Cat: "cat"}, {Num: 2, Cat: "cat"}},
+		},
+	}
+	bus := events.NewBus()
+	server := CreateTestServer(mockStore, &MockAuth{}, ServerOptions{Events: bus})
+
+	poll := func(query string) ([]*data.Post, *httptest.ResponseRecorder) {
+		t.Helper()
+		req, err := http.NewRequest("GET", "/v1/categories/cat/1/poll"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		var posts []*data.Post
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&posts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return posts, rr
+	}
+
+	if _, rr := poll("?since=x"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid since to 400, got: %d", rr.Code)
+	}
+	if _, rr := poll("?timeout=soon"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid timeout to 400, got: %d", rr.Code)
+	}
+	if posts, _ := poll("?since=1"); len(posts) != 1 || posts[0].Num != 2 {
+		t.Errorf("expected posts after since straight away, got: %v", posts)
+	}
+	if posts, rr := poll("?since=2&timeout=10ms"); rr.Code != http.StatusOK || len(posts) != 0 {
+		t.Errorf("expected no posts after timing out, got: %d %v", rr.Code, posts)
+	}
+
+	done := make(chan time.Time)
+	go func() {
+		poll("?since=2&timeout=10s")
+		done <- time.Now()
+	}()
+	// Wait for the poll to start waiting before posting.
+	for {
+		server.polls.mut.Lock()
+		waiting := len(server.polls.waiters["cat/1"])
+		server.polls.mut.Unlock()
+		if waiting > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	posted := time.Now()
+	bus.Publish(events.Event{Kind: events.PostCreated, Category: "cat", Num: 3, Parent: 1})
+	select {
+	case woken := <-done:
+		if woken.Sub(posted) > time.Second {
+			t.Error("expected poll to wake on a new post")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("poll wasn't woken by a new post")
+	}
+}
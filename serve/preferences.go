@@ -0,0 +1,66 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+)
+
+// me is the signed in user's account, as they see it.
+type me struct {
+	*auth.UserData
+	Verified    bool              `json:"verified"`
+	Preferences *data.Preferences `json:"preferences"`
+	// Private messages to the user they haven't read.
+	UnreadMessages      int `json:"unreadMessages"`
+	UnreadNotifications int `json:"unreadNotifications"`
+}
+
+// handleGetMe handles a GET request for the signed in user's account, preferences and unread messages and notifications.
+func (server *Server) handleGetMe(ctx context.Context, req *request, res *response) {
+	server.recordAccount(ctx, req)
+	prefs, err := server.store.GetPreferences(ctx, req.user.ID)
+	if err != nil {
+		res.Fail("Failed to get preferences", err)
+		return
+	}
+	unread, err := server.store.CountUnreadMessages(ctx, req.user.ID)
+	if err != nil {
+		res.Fail("Failed to count unread messages", err)
+		return
+	}
+	notifications, err := server.store.CountUnreadNotifications(ctx, req.user.ID)
+	if err != nil {
+		res.Fail("Failed to count unread notifications", err)
+		return
+	}
+	res.Respond(http.StatusOK, me{
+		UserData:            req.user,
+		Verified:            req.user.IsVerified,
+		Preferences:         prefs,
+		UnreadMessages:      unread,
+		UnreadNotifications: notifications,
+	}, "")
+}
+
+// handleUpdatePreferences handles a PATCH request changing some of the signed in user's preferences.
+func (server *Server) handleUpdatePreferences(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingPreferences(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize(server.reservedNames)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	prefs, err := server.store.UpdatePreferences(ctx, req.user.ID, &incoming.PreferencesUpdate)
+	if err != nil {
+		res.Fail("Failed to update preferences", err)
+		return
+	}
+	res.Respond(http.StatusOK, prefs, "")
+}
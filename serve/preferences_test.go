@@ -0,0 +1,60 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestPreferences(t *testing.T) {
+	user := &auth.UserData{
+		ID:         "auth0|spirit",
+		Username:   "spirit",
+		Email:      "spirit@gmail.com",
+		IsVerified: true,
+	}
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: user}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("PATCH", "/v1/me/preferences", `{"timezone": "../etc"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected bad timezone to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.do("PATCH", "/v1/me/preferences", `{"displayName": " Spirit ", "timezone": "Europe/London"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected preferences to update, got: %d", rr.Code)
+	}
+
+	rr := client.do("GET", "/v1/me", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got: %d", rr.Code)
+	}
+	var got struct {
+		Username    string           `json:"username"`
+		Verified    bool             `json:"verified"`
+		Preferences data.Preferences `json:"preferences"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	expected := data.Preferences{DisplayName: "Spirit", Timezone: "Europe/London"}
+	if got.Username != user.Username || !got.Verified || got.Preferences != expected {
+		t.Errorf("unexpected account: %+v", got)
+	}
+
+	if rr := client.do("POST", "/v1/categories/cat/0", `{"subject": "hello", "content": "hello!"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected post to be accepted, got: %d", rr.Code)
+	}
+	if mockStore.author.Username != "Spirit" || mockStore.author.ID != user.ID {
+		t.Errorf("expected post under display name, got: %+v", mockStore.author)
+	}
+
+	client.do("PATCH", "/v1/me/preferences", `{"anonymous": true}`)
+	client.do("POST", "/v1/categories/cat/0", `{"subject": "hello", "content": "hello!"}`)
+	if mockStore.author.Username != data.AnonymousName {
+		t.Errorf("expected anonymous post, got: %s", mockStore.author.Username)
+	}
+}
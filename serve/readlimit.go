@@ -0,0 +1,196 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"spiritchat/ratelimit"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Header anonymous clients send their client key in.
+const clientKeyHeader = "X-Client-Key"
+
+// Reads are counted per minute.
+const readWindow = time.Minute
+
+// How long a client key's looked up validity is trusted for, so a revoked key stops working within this long.
+const clientKeyCacheTTL = time.Minute
+
+// ReadLimitOptions configure soft limits on anonymous reads, to keep scrapers from hammering the database.
+type ReadLimitOptions struct {
+	// Optional, reads aren't limited without one.
+	Quota ratelimit.Quota
+	// Reads each IP can make a minute without a client key, defaults to 600.
+	PerMinute int
+	// Reads each client key can make a minute, defaults to 6000.
+	ClientKeyPerMinute int
+}
+
+type cachedClientKey struct {
+	valid   bool
+	expires time.Time
+}
+
+// readLimits counts GET requests made without logging in, per client key if one's sent or per IP otherwise.
+type readLimits struct {
+	opts ReadLimitOptions
+	mut  sync.Mutex
+	// Whether each key hash was valid when last looked up.
+	keys map[string]cachedClientKey
+}
+
+func newReadLimits(opts ReadLimitOptions) *readLimits {
+	if opts.PerMinute < 1 {
+		opts.PerMinute = 600
+	}
+	if opts.ClientKeyPerMinute < 1 {
+		opts.ClientKeyPerMinute = 6000
+	}
+	return &readLimits{opts: opts, keys: make(map[string]cachedClientKey)}
+}
+
+// isClientKeyValid looks up whether a client key's hash is valid, trusting the last lookup for a while.
+func (s *Server) isClientKeyValid(ctx context.Context, keyHash string) (bool, error) {
+	limits := s.readLimits
+	limits.mut.Lock()
+	cached, ok := limits.keys[keyHash]
+	limits.mut.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.valid, nil
+	}
+
+	valid, err := s.store.IsClientKeyValid(ctx, keyHash)
+	if err != nil {
+		return false, err
+	}
+	limits.mut.Lock()
+	defer limits.mut.Unlock()
+	for hash, cached := range limits.keys {
+		if !time.Now().Before(cached.expires) {
+			delete(limits.keys, hash)
+		}
+	}
+	limits.keys[keyHash] = cachedClientKey{valid: valid, expires: time.Now().Add(clientKeyCacheTTL)}
+	return valid, nil
+}
+
+// forgetClientKeys drops every looked up key, after one's revoked.
+func (limits *readLimits) forgetClientKeys() {
+	limits.mut.Lock()
+	defer limits.mut.Unlock()
+	limits.keys = make(map[string]cachedClientKey)
+}
+
+/*
+rejectsRead counts a GET request made without logging in against its client key's or IP's quota, responding
+429 once it's used up. The limits are soft: reads are let through if they can't be counted.
+*/
+func (s *Server) rejectsRead(ctx context.Context, req *request, res *response) bool {
+	quota := s.readLimits.opts.Quota
+	if quota == nil || (req.rawRequest.Method != http.MethodGet && req.rawRequest.Method != http.MethodHead) {
+		return false
+	}
+	if len(req.header.Get("Authorization")) > 0 {
+		return false
+	}
+
+	key, limit := "read:ip:"+req.ip, s.readLimits.opts.PerMinute
+	if clientKey := req.header.Get(clientKeyHeader); len(clientKey) > 0 {
+		keyHash := data.ClientKeyHash(clientKey)
+		valid, err := s.isClientKeyValid(ctx, keyHash)
+		if err != nil {
+			log.Printf("Failed to look up client key, limiting by IP: %s", err)
+		} else if !valid {
+			res.Respond(http.StatusUnauthorized, nil, "unknown client key")
+			return true
+		} else {
+			key, limit = "read:key:"+keyHash, s.readLimits.opts.ClientKeyPerMinute
+		}
+	}
+
+	remaining, reset, err := quota.Take(ctx, key, limit, readWindow)
+	if err != nil {
+		log.Printf("Failed to count read: %s", err)
+		return false
+	}
+	header := res.rw.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if remaining >= 0 {
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		return false
+	}
+	header.Set("X-RateLimit-Remaining", "0")
+	retryAfter := int(time.Until(reset).Seconds() + 1)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	header.Set("Retry-After", strconv.Itoa(retryAfter))
+	res.Respond(http.StatusTooManyRequests, nil, "too many requests, slow down or ask for a client key")
+	return true
+}
+
+// issuedClientKey is a newly issued client key, the only time the key itself is shown.
+type issuedClientKey struct {
+	*data.ClientKey
+	Key string `json:"key"`
+}
+
+// handleGetClientKeys handles a GET request from an admin for every client key issued.
+func (server *Server) handleGetClientKeys(ctx context.Context, req *request, res *response) {
+	keys, err := server.store.GetClientKeys(ctx)
+	if err != nil {
+		res.Fail("Failed to get client keys", err)
+		return
+	}
+	res.Respond(http.StatusOK, keys, "")
+}
+
+// handleCreateClientKey handles a POST request from an admin issuing a client key, labelled with who it's for.
+func (server *Server) handleCreateClientKey(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingClientKey(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	clientKey, key, err := server.store.WriteClientKey(ctx, incoming.Label)
+	if err != nil {
+		res.Fail("Failed to write client key", err)
+		return
+	}
+	log.Printf("Client key %d (%s) issued by %s", clientKey.ID, clientKey.Label, req.user.Email)
+	res.Respond(http.StatusCreated, issuedClientKey{ClientKey: clientKey, Key: key}, "")
+}
+
+// handleRevokeClientKey handles a DELETE request from an admin revoking a client key.
+func (server *Server) handleRevokeClientKey(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil || id < 1 {
+		res.Respond(http.StatusBadRequest, nil, "invalid client key ID")
+		return
+	}
+
+	err = server.store.RevokeClientKey(ctx, id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to revoke client key", err)
+		return
+	}
+	server.readLimits.forgetClientKeys()
+	log.Printf("Client key %d revoked by %s", id, req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "revoked"}, "")
+}
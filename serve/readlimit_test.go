@@ -0,0 +1,104 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/ratelimit"
+	"testing"
+)
+
+func TestReadLimits(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{
+		ReadLimits: ReadLimitOptions{Quota: ratelimit.NewMemoryQuota(), PerMinute: 2, ClientKeyPerMinute: 3},
+	})
+	_, key, _ := mockStore.WriteClientKey(context.Background(), "archive")
+
+	read := func(method string, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/categories", nil)
+		req.RemoteAddr = remoteAddr
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := read("GET", "10.0.0.1:1", nil); rr.Code != http.StatusOK {
+			t.Fatalf("expected reads within the quota to be served, got: %d", rr.Code)
+		}
+	}
+	rr := read("GET", "10.0.0.1:1", nil)
+	if rr.Code != http.StatusTooManyRequests || len(rr.Header().Get("Retry-After")) == 0 {
+		t.Errorf("expected reads over the quota to be limited with a Retry-After, got: %d", rr.Code)
+	}
+	if rr := read("GET", "10.0.0.2:1", nil); rr.Code != http.StatusOK || rr.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Errorf("expected other IPs to have their own quota, got: %d with %s left", rr.Code, rr.Header().Get("X-RateLimit-Remaining"))
+	}
+	if rr := read("GET", "10.0.0.1:1", http.Header{"Authorization": {"ok"}}); rr.Code == http.StatusTooManyRequests {
+		t.Error("expected logged in reads not to be limited")
+	}
+	if rr := read("POST", "10.0.0.1:1", nil); rr.Code == http.StatusTooManyRequests {
+		t.Error("expected writes not to be counted as reads")
+	}
+
+	withKey := http.Header{clientKeyHeader: {key}}
+	for i := 0; i < 3; i++ {
+		if rr := read("GET", "10.0.0.1:1", withKey); rr.Code != http.StatusOK {
+			t.Fatalf("expected the client key's higher quota to serve read %d, got: %d", i+1, rr.Code)
+		}
+	}
+	if rr := read("GET", "10.0.0.1:1", withKey); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected reads over the client key's quota to be limited, got: %d", rr.Code)
+	}
+	if rr := read("GET", "10.0.0.3:1", http.Header{clientKeyHeader: {"made-up"}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown client key to be refused, got: %d", rr.Code)
+	}
+
+	mockStore.RevokeClientKey(context.Background(), 1)
+	server.readLimits.forgetClientKeys()
+	if rr := read("GET", "10.0.0.3:1", withKey); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a revoked client key to be refused, got: %d", rr.Code)
+	}
+}
+
+func TestClientKeys(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.do("POST", "/v1/admin/client-keys", `{"label": "  "}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a key without a label to be refused, got: %d", rr.Code)
+	}
+	rr := client.do("POST", "/v1/admin/client-keys", `{"label": "Internet Archive"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected the key to be issued, got: %d", rr.Code)
+	}
+	var issued issuedClientKey
+	if err := json.NewDecoder(rr.Body).Decode(&issued); err != nil {
+		t.Fatal(err)
+	}
+	if issued.ID != 1 || issued.Label != "Internet Archive" || len(issued.Key) == 0 {
+		t.Errorf("expected the issued key to be shown, got: %+v", issued)
+	}
+
+	if rr := client.do("DELETE", "/v1/admin/client-keys/1", ""); rr.Code != http.StatusOK || mockStore.clientKeys[0].RevokedAt == nil {
+		t.Errorf("expected the key to be revoked, got: %d", rr.Code)
+	}
+	if rr := client.do("DELETE", "/v1/admin/client-keys/1", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected revoking a revoked key to 404, got: %d", rr.Code)
+	}
+
+	mockAuth.user.Roles = nil
+	if rr := client.do("GET", "/v1/admin/client-keys", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected users not to see client keys, got: %d", rr.Code)
+	}
+}
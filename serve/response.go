@@ -1,5 +1,38 @@
 package serve
 
+import (
+	"spiritchat/data"
+	"spiritchat/validation"
+)
+
 type ok struct {
 	Message string `json:"message"`
 }
+
+// rejection explains why a request was refused, with a code clients can give their own explanation for.
+type rejection struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Codes of the post policy errors clients are expected to explain.
+var rejectionCodes = map[error]string{
+	validation.ErrTooManyLinks: "too_many_links",
+	validation.ErrLinkOnly:     "link_only",
+	errBlockedDomain:           "blocked_domain",
+	errAttachmentRequired:      "attachment_required",
+	errTooManyAttachments:      "too_many_attachments",
+	errAttachmentNotAllowed:    "attachment_not_allowed",
+}
+
+type storageUsage struct {
+	*data.StorageUsage
+	// The server's quota on all attachments, zero if there isn't one.
+	QuotaBytes int64 `json:"quotaBytes"`
+}
+
+type searchResult struct {
+	*data.Post
+	// Thread the post belongs to, its own number if it's a thread.
+	Thread int `json:"thread"`
+}
@@ -0,0 +1,283 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+	"spiritchat/auth"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// middleware wraps a handler, named so tests can assert which routes it wraps.
+type middleware struct {
+	name string
+	wrap func(handlerFunc) handlerFunc
+}
+
+/*
+routeGroup registers routes under a path prefix, wrapping each in the group's middleware, outermost first.
+Routes can add their own middleware, which runs inside the group's.
+*/
+type routeGroup struct {
+	router *httprouter.Router
+	prefix string
+	stack  []middleware
+	// Names of the middleware wrapping each route, by method and path.
+	chains map[string][]string
+}
+
+// group returns a group of routes under this one, wrapped in this group's middleware and then the given middleware.
+func (g *routeGroup) group(prefix string, stack ...middleware) *routeGroup {
+	return &routeGroup{
+		router: g.router,
+		prefix: g.prefix + prefix,
+		stack:  append(append([]middleware{}, g.stack...), stack...),
+		chains: g.chains,
+	}
+}
+
+func (g *routeGroup) handle(method string, path string, handler handlerFunc, stack ...middleware) {
+	chain := append(append([]middleware{}, g.stack...), stack...)
+	route := method + " " + g.prefix + path
+	names := make([]string, len(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i].wrap(handler)
+		names[i] = chain[i].name
+	}
+	g.chains[route] = names
+	g.router.Handle(method, g.prefix+path, makeHandler(func(ctx context.Context, req *request, res *response) {
+		req.route = route
+		handler(ctx, req, res)
+	}))
+}
+
+func (g *routeGroup) GET(path string, handler handlerFunc, stack ...middleware) {
+	g.handle(http.MethodGet, path, handler, stack...)
+}
+
+func (g *routeGroup) POST(path string, handler handlerFunc, stack ...middleware) {
+	g.handle(http.MethodPost, path, handler, stack...)
+}
+
+func (g *routeGroup) PUT(path string, handler handlerFunc, stack ...middleware) {
+	g.handle(http.MethodPut, path, handler, stack...)
+}
+
+func (g *routeGroup) PATCH(path string, handler handlerFunc, stack ...middleware) {
+	g.handle(http.MethodPatch, path, handler, stack...)
+}
+
+func (g *routeGroup) DELETE(path string, handler handlerFunc, stack ...middleware) {
+	g.handle(http.MethodDelete, path, handler, stack...)
+}
+
+func (s *Server) withErrorTracking() middleware {
+	return middleware{"errors", s.middlewareTrackErrors}
+}
+
+func (s *Server) withResponseSetup() middleware {
+	return middleware{"response", s.middlewareSetupResponse}
+}
+
+func (s *Server) withCORS(allowedOrigin string) middleware {
+	return middleware{"cors", func(next handlerFunc) handlerFunc { return s.middlewareCORS(next, allowedOrigin) }}
+}
+
+func (s *Server) withCrossSiteCheck() middleware {
+	return middleware{"cross-site", s.middlewareRejectCrossSite}
+}
+
+func (s *Server) withMaintenance() middleware {
+	return middleware{"maintenance", s.middlewareMaintenance}
+}
+
+func (s *Server) withLogin() middleware {
+	return middleware{"login", s.middlewareRequireLogin}
+}
+
+func (s *Server) withLoginGrace() middleware {
+	return middleware{"login-grace", s.middlewareRequireLoginGrace}
+}
+
+func (s *Server) withAccount() middleware {
+	return middleware{"account", s.middlewareRequireAccount}
+}
+
+func (s *Server) withRole(role auth.Role) middleware {
+	return middleware{"role:" + string(role), func(next handlerFunc) handlerFunc { return s.middlewareRequireRole(role, next) }}
+}
+
+func (s *Server) withDryRun() middleware {
+	return middleware{"dry-run", s.middlewareDryRun}
+}
+
+func (s *Server) withConfirmation() middleware {
+	return middleware{"confirm", s.middlewareRequireConfirmation}
+}
+
+func (s *Server) withPIIReason() middleware {
+	return middleware{"pii-reason", s.middlewareRequirePIIReason}
+}
+
+func (s *Server) withCategorySlug() middleware {
+	return middleware{"category-slug", s.middlewareCategorySlug}
+}
+
+func (s *Server) withAntibot() middleware {
+	return middleware{"antibot", s.middlewareAntibot}
+}
+
+func (s *Server) withReputation() middleware {
+	return middleware{"reputation", s.middlewareReputation}
+}
+
+/*
+registerRoutes wires every route into the router, and admin routes into adminRouter, which can be the same router.
+Returns the middleware wrapping each route.
+*/
+func (server *Server) registerRoutes(router *httprouter.Router, adminRouter *httprouter.Router, allowedOrigin string) map[string][]string {
+	stack := []middleware{
+		server.withErrorTracking(),
+		server.withResponseSetup(),
+		server.withCORS(allowedOrigin),
+		server.withCrossSiteCheck(),
+		server.withMaintenance(),
+	}
+	root := &routeGroup{router: router, stack: stack, chains: make(map[string][]string)}
+	adminRoot := &routeGroup{router: adminRouter, stack: root.stack, chains: root.chains}
+
+	v1 := root.group("/v1")
+	v1.GET("/categories", server.handleGetCategories)
+	v1.POST("/signup", server.handleSignUp)
+	v1.POST("/login", server.handleLogin)
+	v1.POST("/login/refresh", server.handleRefreshLogin)
+	v1.GET("/auth/social", server.handleGetSocialProviders)
+	v1.GET("/auth/social/:provider", server.handleSocialAuthorize)
+	v1.POST("/auth/social/callback", server.handleSocialCallback)
+	v1.POST("/verify/resend", server.handleResendVerification, server.withAccount())
+	v1.GET("/yours", server.handleGetUsersPosts, server.withLogin())
+	v1.POST("/yours/claim", server.handleClaimPosts, server.withLogin())
+	v1.GET("/search", server.handleSearch)
+	v1.GET("/changes", server.handleGetChanges)
+	v1.POST("/reports", server.handleCreateReport, server.withLogin())
+	v1.POST("/abuse", server.handleFileComplaint)
+	v1.GET("/notifications", server.handleGetNotifications, server.withAccount())
+	v1.GET("/pages", server.handleGetPages)
+	v1.GET("/pages/:slug", server.handleGetPage)
+	v1.POST("/uploads", server.handleUpload, server.withLogin())
+	v1.GET("/media/:file", server.handleGetMedia)
+	v1.POST("/share", server.handleWriteShareLink)
+	v1.GET("/stats", server.handleGetStats)
+	v1.GET("/config", server.handleGetConfig)
+	if server.publicModLog {
+		v1.GET("/modlog/:cat", server.handleGetModLog)
+	}
+	root.GET("/s/:token", server.handleFollowShareLink)
+	root.GET(linkRedirectPath, server.handleFollowLink)
+
+	// Categories are addressed by slug, redirecting from old slugs and resolving to their tag.
+	cat := v1.group("/categories/:cat", server.withCategorySlug())
+	cat.GET("", server.handleGetCategoryView)
+	cat.GET("/:thread", server.handleGetThreadView)
+	cat.POST("/:thread", server.handleCreatePost, server.withLoginGrace(), server.withAntibot(), server.withReputation())
+	cat.POST("/:thread/validate", server.handleValidatePost, server.withLoginGrace())
+	cat.DELETE("/:thread", server.handleRemovePost, server.withLogin())
+	cat.POST("/:thread/close", server.handleCloseThread, server.withLogin())
+	cat.PUT("/:thread/answer", server.handleSetBestAnswer, server.withLogin())
+	cat.GET("/:thread/poll", server.handlePollThread)
+	cat.GET("/:thread/digest", server.handleGetThreadDigest)
+	cat.PUT("/:thread/bookmark", server.handleSetBookmark, server.withAccount())
+	cat.DELETE("/:thread/bookmark", server.handleSetBookmark, server.withAccount())
+
+	archive := v1.group("/archive/:cat", server.withCategorySlug())
+	archive.GET("", server.handleGetArchive)
+	archive.GET("/:thread", server.handleGetArchivedThread)
+
+	me := v1.group("/me", server.withAccount())
+	me.GET("", server.handleGetMe)
+	me.GET("/bookmarks", server.handleGetBookmarks)
+	me.GET("/subscriptions", server.handleGetCategorySubscriptions)
+	me.PUT("/subscriptions/:cat", server.handleSetCategorySubscription)
+	me.DELETE("/subscriptions/:cat", server.handleSetCategorySubscription)
+	me.GET("/digests", server.handleGetDigests)
+	me.PATCH("/preferences", server.handleUpdatePreferences)
+	me.GET("/sessions", server.handleGetSessions)
+	me.DELETE("/sessions/:id", server.handleRevokeSession)
+	me.GET("/2fa", server.handleGetTwoFactor)
+	me.POST("/2fa", server.handleEnrollTwoFactor)
+	me.POST("/2fa/verify", server.handleVerifyTwoFactor)
+	me.DELETE("/2fa", server.handleDisableTwoFactor)
+
+	loggedIn := v1.group("", server.withLogin())
+	loggedIn.GET("/dm", server.handleGetConversations)
+	loggedIn.POST("/dm", server.handleSendMessage)
+	loggedIn.GET("/dm/:id", server.handleGetMessages)
+	loggedIn.GET("/blocks", server.handleGetBlocked)
+	loggedIn.PUT("/blocks/:username", server.handleSetBlocked)
+	loggedIn.DELETE("/blocks/:username", server.handleSetBlocked)
+
+	staff := adminRoot.group("/v1/admin", server.withLogin())
+	staff.GET("/retention/:cat/:num", server.handleGetRetainedPosts, server.withRole(auth.RoleRetention), server.withPIIReason())
+
+	mods := staff.group("", server.withRole(auth.RoleModerator))
+	mods.GET("/posters/:id/posts", server.handleGetPosterHistory)
+	mods.GET("/posts/:cat/:num/events", server.handleGetPostEvents)
+	mods.GET("/queue", server.handleGetQueue)
+	mods.GET("/categories/:cat/modlog", server.handleGetModLog)
+	mods.GET("/complaints/:id", server.handleGetComplaint, server.withPIIReason())
+	mods.POST("/queue/:cat/:num", server.handleResolveQueueItem)
+	mods.POST("/queue/:cat/:num/claim", server.handleClaimQueueItem)
+	mods.DELETE("/queue/:cat/:num/claim", server.handleUnclaimQueueItem)
+	mods.POST("/actions", server.handleBulkActions, server.withConfirmation())
+	mods.POST("/notes", server.handleCreateNote)
+	mods.DELETE("/notes/:id", server.handleRemoveNote)
+	mods.GET("/rule-actions", server.handleGetRuleActions)
+	mods.PUT("/attachments/:id/flags", server.handleSetAttachmentFlags)
+
+	admins := staff.group("", server.withRole(auth.RoleAdmin))
+	admins.GET("/login-metrics", server.handleGetLoginMetrics)
+	admins.GET("/cache-metrics", server.handleGetCacheMetrics)
+	admins.POST("/categories", server.handleCreateCategory)
+	admins.PUT("/categories/:cat/rules", server.handleSetCategoryRules)
+	admins.GET("/categories/:cat/policy", server.handleGetPostPolicy)
+	admins.PUT("/categories/:cat/policy", server.handleSetPostPolicy)
+	admins.GET("/categories/:cat/masks", server.handleGetCategoryMasks)
+	admins.PUT("/categories/:cat/masks", server.handleSetCategoryMasks)
+	admins.PUT("/categories/:cat/slug", server.handleSetCategorySlug)
+	admins.PUT("/categories/:cat/listing", server.handleSetCategoryListing)
+	admins.PATCH("/categories/:cat", server.handleUpdateCategory)
+	admins.DELETE("/categories/:cat", server.handleRemoveCategory, server.withDryRun(), server.withConfirmation())
+	admins.PUT("/categories/:cat/archived", server.handleSetCategoryArchived)
+	admins.POST("/categories/:cat/anonymize", server.handleQueueAnonymization, server.withConfirmation())
+	admins.GET("/anonymize-jobs", server.handleGetAnonymizeJobs)
+	admins.GET("/anonymize-jobs/:id", server.handleGetAnonymizeJob)
+	admins.GET("/rules", server.handleGetRules)
+	admins.POST("/rules", server.handleCreateRule)
+	admins.PUT("/rules/:id", server.handleUpdateRule)
+	admins.DELETE("/rules/:id", server.handleRemoveRule)
+	admins.GET("/webhooks", server.handleGetWebhooks)
+	admins.POST("/webhooks", server.handleCreateWebhook)
+	admins.PUT("/webhooks/:id", server.handleUpdateWebhook)
+	admins.DELETE("/webhooks/:id", server.handleRemoveWebhook)
+	admins.PUT("/pages/:slug", server.handleWritePage)
+	admins.DELETE("/pages/:slug", server.handleRemovePage)
+	admins.GET("/storage", server.handleGetStorageUsage)
+	admins.GET("/domains", server.handleGetBlockedDomains)
+	admins.PUT("/domains/:domain", server.handleBlockDomain)
+	admins.DELETE("/domains/:domain", server.handleUnblockDomain)
+	admins.GET("/canaries", server.handleGetCanaries, server.withPIIReason())
+	admins.GET("/pii-access", server.handleGetPIIAccesses)
+	admins.GET("/client-keys", server.handleGetClientKeys)
+	admins.POST("/client-keys", server.handleCreateClientKey)
+	admins.DELETE("/client-keys/:id", server.handleRevokeClientKey)
+	adminRoot.GET(maintenancePath, server.handleGetMaintenance, server.withLogin(), server.withRole(auth.RoleAdmin))
+	adminRoot.PUT(maintenancePath, server.handleSetMaintenance, server.withLogin(), server.withRole(auth.RoleAdmin))
+
+	impersonators := admins.group("/impersonations", server.withRole(auth.RoleImpersonate))
+	impersonators.POST("", server.handleCreateImpersonation)
+	impersonators.GET("", server.handleGetImpersonations, server.withPIIReason())
+	impersonators.GET("/:id/actions", server.handleGetImpersonationActions)
+	impersonators.DELETE("/:id", server.handleRevokeImpersonation)
+
+	return root.chains
+}
@@ -0,0 +1,78 @@
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"spiritchat/auth"
+	"strings"
+	"testing"
+)
+
+// rootChain is the middleware wrapping every route, outermost first.
+var rootChain = []string{"errors", "response", "cors", "cross-site", "maintenance"}
+
+func TestRouteChains(t *testing.T) {
+	server := CreateTestServer(&MockStore{}, &MockAuth{}, ServerOptions{})
+
+	for route, chain := range server.routes {
+		if len(chain) < len(rootChain) || !reflect.DeepEqual(chain[:len(rootChain)], rootChain) {
+			t.Errorf("%s: expected %v outermost, got %v", route, rootChain, chain)
+			continue
+		}
+		path := strings.SplitN(route, " ", 2)[1]
+		inner := chain[len(rootChain):]
+		if strings.HasPrefix(path, "/v1/admin/") {
+			if len(inner) < 2 || inner[0] != "login" || !strings.HasPrefix(inner[1], "role:") {
+				t.Errorf("%s: expected admin route to need a login and role, got %v", route, chain)
+			}
+		}
+		if strings.HasPrefix(path, "/v1/categories/:cat") && (len(inner) == 0 || inner[0] != "category-slug") {
+			t.Errorf("%s: expected category routes to resolve slugs first, got %v", route, chain)
+		}
+	}
+
+	expected := map[string][]string{
+		"GET /v1/categories/:cat/:thread":   {"category-slug"},
+		"POST /v1/categories/:cat/:thread":  {"category-slug", "login-grace", "antibot", "reputation"},
+		"GET /v1/me":                        {"account"},
+		"POST /v1/admin/impersonations":     {"login", "role:admin", "role:impersonate"},
+		"GET /s/:token":                     {},
+		"DELETE /v1/admin/categories/:cat":  {"login", "role:admin", "dry-run", "confirm"},
+		"GET /v1/admin/retention/:cat/:num": {"login", "role:retention", "pii-reason"},
+	}
+	for route, inner := range expected {
+		chain := append(append([]string{}, rootChain...), inner...)
+		if !reflect.DeepEqual(server.routes[route], chain) {
+			t.Errorf("%s: expected %v, got %v", route, chain, server.routes[route])
+		}
+	}
+}
+
+func TestAdminListener(t *testing.T) {
+	mockAuth := &MockAuth{user: &auth.UserData{Username: "admin", IsVerified: true, Roles: []auth.Role{auth.RoleAdmin}}}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{Address: "0.0.0.0:3000", AdminAddress: "127.0.0.1:3001"})
+
+	get := func(serveHTTP func(http.ResponseWriter, *http.Request), path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Add("Authorization", "ok")
+		rr := httptest.NewRecorder()
+		serveHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, path := range []string{"/v1/admin/cache-metrics", maintenancePath} {
+		if code := get(server.ServeHTTP, path); code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 on the public listener, got %d", path, code)
+		}
+		if code := get(server.ServeAdminHTTP, path); code != http.StatusOK {
+			t.Errorf("%s: expected 200 on the admin listener, got %d", path, code)
+		}
+	}
+	if code := get(server.ServeHTTP, "/v1/config"); code != http.StatusOK {
+		t.Errorf("expected public routes on the public listener, got %d", code)
+	}
+	if code := get(server.ServeAdminHTTP, "/v1/config"); code != http.StatusNotFound {
+		t.Errorf("expected public routes kept off the admin listener, got %d", code)
+	}
+}
@@ -0,0 +1,109 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"strconv"
+)
+
+const maxRuleActions = 200
+
+// handleGetRules handles a GET request for every autoban rule.
+func (server *Server) handleGetRules(ctx context.Context, req *request, res *response) {
+	rules, err := server.store.GetRules(ctx)
+	if err != nil {
+		res.Fail("Failed to get rules", err)
+		return
+	}
+	res.Respond(http.StatusOK, rules, "")
+}
+
+// handleCreateRule handles a POST request adding an autoban rule.
+func (server *Server) handleCreateRule(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingRule(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	rule := incoming.rule()
+	rule.ID, err = server.store.WriteRule(ctx, rule)
+	if err != nil {
+		res.Fail("Failed to write rule", err)
+		return
+	}
+	log.Printf("Rule %d (%s) created by %s", rule.ID, rule.Name, req.user.Email)
+	res.Respond(http.StatusOK, rule, "")
+}
+
+// handleUpdateRule handles a PUT request replacing an autoban rule.
+func (server *Server) handleUpdateRule(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid rule ID")
+		return
+	}
+	incoming, err := getIncomingRule(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	rule := incoming.rule()
+	rule.ID = id
+	err = server.store.UpdateRule(ctx, rule)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to update rule", err)
+		return
+	}
+	log.Printf("Rule %d (%s) updated by %s", rule.ID, rule.Name, req.user.Email)
+	res.Respond(http.StatusOK, rule, "")
+}
+
+// handleRemoveRule handles a DELETE request dropping an autoban rule.
+func (server *Server) handleRemoveRule(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid rule ID")
+		return
+	}
+
+	err = server.store.RemoveRule(ctx, id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to remove rule", err)
+		return
+	}
+	log.Printf("Rule %d removed by %s", id, req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "removed"}, "")
+}
+
+// handleGetRuleActions handles a GET request for the latest actions taken by autoban rules.
+func (server *Server) handleGetRuleActions(ctx context.Context, req *request, res *response) {
+	actions, err := server.store.GetRuleActions(ctx, maxRuleActions)
+	if err != nil {
+		res.Fail("Failed to get rule actions", err)
+		return
+	}
+	res.Respond(http.StatusOK, actions, "")
+}
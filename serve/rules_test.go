@@ -0,0 +1,123 @@
+package serve
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	admin := staffUser(auth.RoleAdmin)
+	tests := map[string]struct {
+		user         *auth.UserData
+		method       string
+		route        string
+		body         string
+		expectedCode int
+	}{
+		"Moderator can't create": {
+			user:         moderator("mod@gmail.com"),
+			method:       "POST",
+			route:        "/v1/admin/rules",
+			body:         `{"name": "spam", "kind": "content_match", "pattern": "spam", "action": "ban"}`,
+			expectedCode: http.StatusForbidden,
+		},
+		"Unknown kind": {
+			user:         admin,
+			method:       "POST",
+			route:        "/v1/admin/rules",
+			body:         `{"name": "spam", "kind": "vibes", "action": "ban"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Bad pattern": {
+			user:         admin,
+			method:       "POST",
+			route:        "/v1/admin/rules",
+			body:         `{"name": "spam", "kind": "content_match", "pattern": "(", "action": "ban"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Deleted posts quarantine": {
+			user:         admin,
+			method:       "POST",
+			route:        "/v1/admin/rules",
+			body:         `{"name": "strikes", "kind": "deleted_posts", "threshold": 3, "windowHours": 24, "action": "quarantine"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Deleted posts no threshold": {
+			user:         admin,
+			method:       "POST",
+			route:        "/v1/admin/rules",
+			body:         `{"name": "strikes", "kind": "deleted_posts", "windowHours": 24, "action": "ban"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Create": {
+			user:         admin,
+			method:       "POST",
+			route:        "/v1/admin/rules",
+			body:         `{"name": "strikes", "kind": "deleted_posts", "threshold": 3, "windowHours": 24, "action": "ban", "banHours": 72, "enabled": true}`,
+			expectedCode: http.StatusOK,
+		},
+		"Update": {
+			user:         admin,
+			method:       "PUT",
+			route:        "/v1/admin/rules/1",
+			body:         `{"name": "spam", "kind": "content_match", "pattern": "(?i)spam", "action": "quarantine"}`,
+			expectedCode: http.StatusOK,
+		},
+		"Update missing": {
+			user:         admin,
+			method:       "PUT",
+			route:        "/v1/admin/rules/2",
+			body:         `{"name": "spam", "kind": "content_match", "pattern": "(?i)spam", "action": "quarantine"}`,
+			expectedCode: http.StatusNotFound,
+		},
+		"Remove": {
+			user:         admin,
+			method:       "DELETE",
+			route:        "/v1/admin/rules/1",
+			expectedCode: http.StatusOK,
+		},
+		"Remove bad ID": {
+			user:         admin,
+			method:       "DELETE",
+			route:        "/v1/admin/rules/x",
+			expectedCode: http.StatusBadRequest,
+		},
+		"Moderator reads actions": {
+			user:         moderator("mod@gmail.com"),
+			method:       "GET",
+			route:        "/v1/admin/rule-actions",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockStore := &MockStore{}
+			server := CreateTestServer(mockStore, &MockAuth{user: test.user}, ServerOptions{})
+
+			req, err := http.NewRequest(test.method, test.route, bytes.NewReader([]byte(test.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Authorization", "ok")
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Fatalf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if rr.Code == http.StatusOK && test.method != "GET" && test.method != "DELETE" {
+				if len(mockStore.rules) != 1 || mockStore.rules[0].Kind == "" {
+					t.Errorf("expected rule to be saved, got %+v", mockStore.rules)
+				}
+				if mockStore.rules[0].Kind == data.RuleContentMatch && mockStore.rules[0].Threshold != 0 {
+					t.Errorf("expected content rule threshold cleared")
+				}
+			}
+		})
+	}
+}
@@ -3,10 +3,21 @@ package serve
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"spiritchat/auth"
+	"spiritchat/counters"
 	"spiritchat/data"
+	"spiritchat/errtrack"
+	"spiritchat/events"
+	"spiritchat/i18n"
+	"spiritchat/lock"
+	"spiritchat/ratelimit"
+	"spiritchat/reputation"
+	"spiritchat/search"
+	"spiritchat/validation"
 	"strconv"
 	"time"
 
@@ -15,9 +26,22 @@ import (
 
 const postFailMessage = "Sorry, an error occurred while saving your post"
 const genericFailMessage = "Sorry, an error occurred while handling your request."
+const timeoutFailMessage = "Sorry, that took too long. Try again in a moment."
+
+const maxSearchResults = 50
 
 var errBadThreadNumber = errors.New("invalid thread number")
 
+// Reasons posts are refused, given by posting and by checking a post before it's submitted.
+var (
+	errUnverifiedThread    = errors.New("please verify your account to start threads")
+	errAttachmentsDisabled = errors.New("attachments aren't enabled")
+	errCapcodeNotAllowed   = errors.New("you can't post with that capcode")
+	errBannedPoster        = errors.New("you're banned from posting")
+	errRulesNotAccepted    = errors.New("you must accept the category's rules before posting")
+	errPostCooldown        = errors.New("please wait before posting again")
+)
+
 type ReplyParameters struct {
 	categoryTag  string
 	threadNumber int
@@ -42,34 +66,99 @@ func getReplyParameters(req *request) (*ReplyParameters, error) {
 
 // Server stub todo
 type Server struct {
-	store      data.Store
-	auth       auth.Auth
+	store        data.Store
+	auth         auth.Auth
+	limiter      ratelimit.Limiter
+	postCooldown time.Duration
+	// Cooldown between posts by the same account, from any IP.
+	accountCooldown time.Duration
+	// How long unverified accounts can reply for before they have to verify.
+	unverifiedGrace time.Duration
+	social          SocialOptions
+	login           LoginOptions
+	loginMetrics    loginMetrics
+	events          *events.Bus
+	search          search.Backend
+	antibot         AntibotOptions
+	threadViews     *viewTracker
+	stats           *statsCache
+	categoryList    *responseCache
+	polls           *pollHub
+	tracker         errtrack.Tracker
+	translator      *i18n.Translator
+	// Names of the middleware wrapping each route, by method and path.
+	routes     map[string][]string
 	httpServer http.Server
+	// Serves the admin routes on their own address, nil if they're served with the rest.
+	adminServer *http.Server
+
+	reputation         reputation.Checker
+	reputationPolicies reputation.Policies
+	captcha            reputation.Captcha
+
+	locker  lock.Locker
+	counter counters.Counter
+
+	publicModLog           bool
+	linkRedirect           bool
+	requireRulesAcceptance bool
+	opDeleteReplies        bool
+	staffTwoFactor         bool
+	branding               Branding
+	reservedNames          *validation.ReservedNames
+	maintenance            *maintenance
+	trustedOrigins         trustedOrigins
+	readLimits             *readLimits
+	uploads                UploadOptions
 }
 
 func (server *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	server.httpServer.Handler.ServeHTTP(rw, req)
 }
 
+// ServeAdminHTTP serves a request to the admin listener, or to the server if there isn't one.
+func (server *Server) ServeAdminHTTP(rw http.ResponseWriter, req *http.Request) {
+	if server.adminServer == nil {
+		server.ServeHTTP(rw, req)
+		return
+	}
+	server.adminServer.Handler.ServeHTTP(rw, req)
+}
+
 // Listen starts the server listening process until the context is cancelled (blocks).
 func (server *Server) Listen(ctx context.Context) error {
 	go server.httpServer.ListenAndServe()
+	if server.adminServer != nil {
+		go server.adminServer.ListenAndServe()
+	}
 	<-ctx.Done()
+	if server.adminServer != nil {
+		err := server.adminServer.Shutdown(context.Background())
+		if err != nil {
+			log.Printf("Failed to shut down the admin listener: %v", err)
+		}
+	}
 	return server.httpServer.Shutdown(context.Background())
 }
 
-// handleGetCategories handles a GET request for information on categories.
+/*
+handleGetCategories handles a GET request for information on categories.
+The list is served from memory, cleared whenever an admin edits a category.
+*/
 func (server *Server) handleGetCategories(ctx context.Context, req *request, res *response) {
-	categories, err := server.store.GetCategories(ctx)
+	body, etag, err := server.categoryList.get(ctx, func(ctx context.Context) (interface{}, error) {
+		categories, err := server.store.GetCategories(ctx)
+		if err != nil {
+			return nil, err
+		}
+		server.countThreads(ctx, categories...)
+		return categories, nil
+	})
 	if err != nil {
-		res.Respond(
-			http.StatusInternalServerError, nil, genericFailMessage,
-		)
-		log.Println(err)
+		res.Fail("Failed to get categories", err)
 		return
 	}
-
-	res.Respond(http.StatusOK, categories, "")
+	server.categoryList.respond(req, res, body, etag)
 }
 
 // handleGetCategoryView handles a GET request for information on a single category.
@@ -83,13 +172,14 @@ func (server *Server) handleGetCategoryView(ctx context.Context, req *request, r
 			)
 			return
 		}
-		res.Respond(
-			http.StatusInternalServerError, nil, genericFailMessage,
-		)
-		log.Println(err)
+		res.Fail("Failed to get category view", err)
 		return
 	}
 
+	if view.Category != nil {
+		server.countThreads(ctx, view.Category)
+	}
+	server.threadViews.record(req.ip, req.params.ByName("cat"), 0)
 	res.Respond(http.StatusOK, view, "")
 }
 
@@ -100,18 +190,42 @@ func (server *Server) handleGetThreadView(ctx context.Context, req *request, res
 		res.Respond(http.StatusBadRequest, nil, "Invalid thread number")
 		return
 	}
-	threadView, err := server.store.GetThreadView(ctx, req.params.ByName("cat"), threadNum)
+	page, err := getThreadPage(req.rawRequest.URL.Query())
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	stream := newThreadStream(res.rw)
+	more, err := server.store.StreamThreadView(ctx, req.params.ByName("cat"), threadNum, page, stream)
 	if err != nil {
+		if stream.started {
+			// Too late to change the status, the client sees the response cut short instead.
+			res.status = http.StatusInternalServerError
+			res.err = fmt.Errorf("Failed to stream thread view: %w", err)
+			log.Println(res.err)
+			return
+		}
 		if errors.Is(err, data.ErrNotFound) {
+			if server.serveCanary(ctx, req, res, req.params.ByName("cat"), threadNum) {
+				return
+			}
 			res.Respond(http.StatusNotFound, nil, err.Error())
 			return
 		}
-		res.Respond(http.StatusInternalServerError, nil, genericFailMessage)
-		log.Println(err)
+		res.Fail("Failed to get thread view", err)
 		return
 	}
+	res.status = http.StatusOK
 
-	res.Respond(http.StatusOK, threadView, "")
+	server.threadViews.record(req.ip, req.params.ByName("cat"), threadNum)
+	replies := -1
+	if more || page != (data.ThreadPage{Limit: maxThreadViewPosts}) {
+		replies = server.countReplies(ctx, req.params.ByName("cat"), threadNum)
+	}
+	err = stream.finish(more, replies)
+	if err != nil {
+		log.Printf("Failed to finish thread view: %s", err)
+	}
 }
 
 // HandleSignUp handles a POST request for a sign up.
@@ -121,7 +235,7 @@ func (server *Server) handleSignUp(ctx context.Context, req *request, res *respo
 		res.Respond(http.StatusBadRequest, nil, err.Error())
 		return
 	}
-	err = incSignUp.Sanitize()
+	err = incSignUp.Sanitize(server.reservedNames)
 	if err != nil {
 		res.Respond(http.StatusBadRequest, nil, err.Error())
 		return
@@ -135,6 +249,36 @@ func (server *Server) handleSignUp(ctx context.Context, req *request, res *respo
 	res.Respond(http.StatusOK, data, "success")
 }
 
+// Time between verification emails sent to the same account.
+const verificationResendCooldown = time.Minute * 5
+
+// handleResendVerification handles a POST request from an unverified account for another verification email.
+func (server *Server) handleResendVerification(ctx context.Context, req *request, res *response) {
+	if req.user.IsVerified {
+		res.Respond(http.StatusConflict, nil, "your account is already verified")
+		return
+	}
+	if server.limiter != nil {
+		limited, err := server.limiter.IsRateLimited(ctx, "verify:"+req.user.ID, verificationResendCooldown)
+		if err != nil {
+			res.Respond(http.StatusServiceUnavailable, nil, genericFailMessage)
+			log.Printf("Failed to check verification rate limit: %s", err)
+			return
+		}
+		if limited {
+			res.Respond(http.StatusTooManyRequests, nil, "please wait before asking for another verification email")
+			return
+		}
+	}
+	err := server.auth.ResendVerification(ctx, req.user.ID)
+	if err != nil {
+		res.Respond(http.StatusBadGateway, nil, "failed to send verification email")
+		log.Printf("Failed to resend verification email: %s", err)
+		return
+	}
+	res.Respond(http.StatusOK, nil, "verification email sent")
+}
+
 // handleRemovePost handles a DELETE request to remove a post.
 func (server *Server) handleRemovePost(ctx context.Context, req *request, res *response) {
 	params, err := getReplyParameters(req)
@@ -143,23 +287,107 @@ func (server *Server) handleRemovePost(ctx context.Context, req *request, res *r
 		return
 	}
 
-	match, err := server.store.EmailMatches(ctx, params.categoryTag, params.threadNumber, req.user.Email)
+	identity := data.IdentityFrom(ctx)
+	ownership, err := server.store.GetPostOwnership(ctx, params.categoryTag, params.threadNumber)
 	if err != nil {
-		res.Respond(http.StatusInternalServerError, nil, "internal server error")
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such post")
+			return
+		}
+		res.Fail("Failed to get post ownership", err)
 		return
 	}
-	if !match {
-		res.Respond(http.StatusUnauthorized, nil, "you can't delete that post")
+	// Authors can delete their own posts, and thread authors replies to their thread if enabled.
+	allowed := ownership.IsAuthor(identity) || (server.opDeleteReplies && ownership.IsThreadAuthor(identity))
+	if allowed {
+		_, err = server.store.RemovePost(ctx, params.categoryTag, params.threadNumber)
+	} else if server.canUseRole(req, auth.RoleModerator) {
+		// Moderators can delete anyone's, which is logged and counted like deleting it from the queue.
+		err = server.store.ResolveQueueItem(ctx, params.categoryTag, params.threadNumber, data.Resolution{Action: data.ActionDelete})
+		if err == nil {
+			log.Printf("Post %s/%d deleted by %s", params.categoryTag, params.threadNumber, req.user.Email)
+		}
+	} else {
+		res.Respond(http.StatusForbidden, nil, "you can't delete that post")
 		return
 	}
-	_, err = server.store.RemovePost(ctx, params.categoryTag, params.threadNumber)
 	if err != nil {
-		res.Respond(http.StatusInternalServerError, nil, "internal server error")
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such post")
+			return
+		}
+		res.Fail("Failed to remove post", err)
 		return
 	}
+	server.events.Publish(events.Event{
+		Kind:     events.PostDeleted,
+		Category: params.categoryTag,
+		Num:      params.threadNumber,
+		Parent:   ownership.Parent,
+	})
 	res.Respond(http.StatusOK, nil, "post removed")
 }
 
+/*
+isRateLimited checks the poster's IP and account against their cooldowns, limiting them if either is
+still cooling down. Accounts are keyed separately so they're limited across IPs, and IPs shared by
+many accounts can be given a shorter cooldown. Peeking checks without starting either cooldown.
+*/
+func (server *Server) isRateLimited(ctx context.Context, ip string, identity *data.Identity, peek bool) (bool, error) {
+	check := server.limiter.IsRateLimited
+	if peek {
+		check = server.limiter.Peek
+	}
+	if server.postCooldown > 0 {
+		limited, err := check(ctx, ip, server.postCooldown)
+		if err != nil || limited {
+			return limited, err
+		}
+	}
+	if server.accountCooldown > 0 && len(identity.ID) > 0 {
+		return check(ctx, "account:"+identity.ID, server.accountCooldown)
+	}
+	return false, nil
+}
+
+// errCategoryQuota is returned when a post's attachments would put its category over its storage quota.
+var errCategoryQuota = errors.New(quotaRejection.Message)
+
+/*
+checkAttachments checks the attachments against the category's upload policy, returning ErrAttachmentUnavailable
+if one doesn't exist, errAttachmentNotAllowed if one isn't allowed, or errCategoryQuota if there's no room for them.
+*/
+func (server *Server) checkAttachments(ctx context.Context, categoryTag string, uploads *data.UploadPolicy, ids []int) error {
+	if len(ids) == 0 || (uploads.MaxFileBytes == 0 && len(uploads.AllowedTypes) == 0 && uploads.QuotaBytes == 0) {
+		return nil
+	}
+	var size int64
+	for _, id := range ids {
+		attachment, err := server.store.GetAttachment(ctx, id)
+		if err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				return data.ErrAttachmentUnavailable
+			}
+			return fmt.Errorf("failed to get attachment: %w", err)
+		}
+		if !uploads.Allows(attachment.MIME, attachment.Size) {
+			return errAttachmentNotAllowed
+		}
+		size += attachment.Size
+	}
+	// The server's quota was checked on upload, the category's is only known now.
+	if uploads.QuotaBytes > 0 && !uploads.PruneOldest {
+		used, err := server.store.GetCategoryStorageUsage(ctx, categoryTag)
+		if err != nil {
+			return fmt.Errorf("failed to get category storage usage: %w", err)
+		}
+		if used+size > uploads.QuotaBytes {
+			return errCategoryQuota
+		}
+	}
+	return nil
+}
+
 // handleCreatePost handles a POST request to post a new post.
 func (server *Server) handleCreatePost(ctx context.Context, req *request, res *response) {
 
@@ -168,6 +396,11 @@ func (server *Server) handleCreatePost(ctx context.Context, req *request, res *r
 		res.Respond(http.StatusBadRequest, nil, err.Error())
 		return
 	}
+	// Unverified accounts in their grace period can only reply.
+	if params.isThread() && !req.user.IsVerified {
+		res.Respond(http.StatusUnauthorized, nil, errUnverifiedThread.Error())
+		return
+	}
 
 	incomingReply, err := getIncomingReply(req.rawRequest.Body)
 	if err != nil {
@@ -175,27 +408,143 @@ func (server *Server) handleCreatePost(ctx context.Context, req *request, res *r
 		return
 	}
 
-	err = incomingReply.Sanitize(params.isThread())
+	// Missing categories are refused by the store when the post's written.
+	policy, err := server.store.GetPostPolicy(ctx, params.categoryTag)
+	if err != nil {
+		if !errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusInternalServerError, nil, postFailMessage)
+			log.Printf("Failed to get post policy: %s", err)
+			return
+		}
+		policy = &data.PostPolicy{}
+	}
+
+	err = incomingReply.Sanitize(params.isThread(), policy)
 	if err != nil {
+		if code, ok := rejectionCodes[err]; ok {
+			res.Respond(http.StatusBadRequest, rejection{Code: code, Message: err.Error()}, "")
+			return
+		}
 		res.Respond(http.StatusBadRequest, nil, err.Error())
 		return
 	}
+	err = server.checkLinks(ctx, incomingReply, true)
+	if err != nil {
+		if errors.Is(err, errBlockedDomain) {
+			res.Respond(http.StatusBadRequest, rejection{Code: rejectionCodes[err], Message: err.Error()}, "")
+			return
+		}
+		res.Respond(http.StatusInternalServerError, nil, postFailMessage)
+		log.Printf("Failed to check links: %s", err)
+		return
+	}
+	if len(incomingReply.Attachments) > 0 && server.uploads.Storage == nil {
+		res.Respond(http.StatusBadRequest, nil, errAttachmentsDisabled.Error())
+		return
+	}
+	// Files may have been uploaded without naming the category, so they're checked against it here.
+	uploads := &policy.Uploads
+	err = server.checkAttachments(ctx, params.categoryTag, uploads, incomingReply.Attachments)
+	if err != nil {
+		if errors.Is(err, data.ErrAttachmentUnavailable) {
+			res.Respond(http.StatusBadRequest, nil, err.Error())
+			return
+		}
+		if errors.Is(err, errAttachmentNotAllowed) {
+			res.Respond(http.StatusBadRequest, rejection{Code: rejectionCodes[err], Message: err.Error()}, "")
+			return
+		}
+		if errors.Is(err, errCategoryQuota) {
+			res.Respond(http.StatusInsufficientStorage, quotaRejection, "")
+			return
+		}
+		res.Respond(http.StatusInternalServerError, nil, postFailMessage)
+		log.Printf("Failed to check attachments: %s", err)
+		return
+	}
+
+	identity := data.IdentityFrom(ctx)
+
+	// Only staff can post with a capcode, and only with a role they have.
+	if len(incomingReply.Capcode) > 0 {
+		role := auth.Role(incomingReply.Capcode)
+		if (role != auth.RoleModerator && role != auth.RoleAdmin) || !server.canUseRole(req, role) {
+			res.Respond(http.StatusForbidden, nil, errCapcodeNotAllowed.Error())
+			return
+		}
+	}
 
-	err = server.store.WritePost(
+	banned, err := server.store.IsBanned(ctx, identity.PosterHashes()...)
+	if err != nil {
+		res.Respond(http.StatusInternalServerError, nil, postFailMessage)
+		log.Printf("Failed to check bans: %s", err)
+		return
+	}
+	if banned {
+		res.Respond(http.StatusForbidden, nil, errBannedPoster.Error())
+		return
+	}
+
+	mustAcceptRules, err := server.store.MustAcceptRules(ctx, params.categoryTag, identity.PosterHashes()...)
+	if err != nil {
+		res.Respond(http.StatusInternalServerError, nil, postFailMessage)
+		log.Printf("Failed to check rules acceptance: %s", err)
+		return
+	}
+	if mustAcceptRules && server.requireRulesAcceptance && !incomingReply.AcceptedRules {
+		res.Respond(http.StatusForbidden, nil, errRulesNotAccepted.Error())
+		return
+	}
+
+	if server.limiter != nil {
+		limited, err := server.isRateLimited(ctx, req.ip, identity, false)
+		if err != nil {
+			res.Respond(http.StatusServiceUnavailable, nil, postFailMessage)
+			log.Printf("Failed to check post rate limit: %s", err)
+			return
+		}
+		if limited {
+			res.Respond(http.StatusTooManyRequests, nil, errPostCooldown.Error())
+			return
+		}
+	}
+
+	prefs, err := server.store.GetPreferences(ctx, identity.ID)
+	if err != nil {
+		res.Respond(http.StatusInternalServerError, nil, postFailMessage)
+		log.Printf("Failed to get preferences: %s", err)
+		return
+	}
+	author := prefs.PostAs(identity, policy.Usernames)
+
+	num, err := server.store.WritePost(
 		ctx,
 		params.categoryTag,
 		params.threadNumber,
 		incomingReply.Subject,
 		incomingReply.Content,
-		req.user.Username,
-		req.user.Email,
-		req.ip,
+		author,
+		incomingReply.Capcode,
+		data.ThreadType(incomingReply.Type),
+		incomingReply.attachmentRefs(),
 	)
 	if err != nil {
+		if errors.Is(err, data.ErrAttachmentUnavailable) {
+			res.Respond(http.StatusBadRequest, nil, err.Error())
+			return
+		}
 		if errors.Is(err, data.ErrNotFound) {
 			res.Respond(http.StatusNotFound, nil, err.Error())
 			return
 		}
+		if errors.Is(err, data.ErrThreadLocked) {
+			res.Respond(http.StatusForbidden, nil, err.Error())
+			return
+		}
+		if errors.Is(err, data.ErrCategoryArchived) {
+			res.Respond(http.StatusConflict, nil, err.Error())
+			return
+		}
 		res.Respond(
 			http.StatusInternalServerError, nil, postFailMessage,
 		)
@@ -203,14 +552,54 @@ func (server *Server) handleCreatePost(ctx context.Context, req *request, res *r
 		return
 	}
 
+	if len(incomingReply.Attachments) > 0 && uploads.QuotaBytes > 0 && uploads.PruneOldest {
+		server.pruneAttachments(ctx, params.categoryTag, uploads.QuotaBytes)
+	}
+
+	if mustAcceptRules && incomingReply.AcceptedRules {
+		err := server.store.AcceptRules(ctx, params.categoryTag, identity.PosterHashes()...)
+		if err != nil {
+			log.Printf("Failed to record rules acceptance: %s", err)
+		}
+	}
+
+	// The store masks words itself, subscribers get the same content.
+	content := data.MaskWords(incomingReply.Content, policy.MaskedWords)
+	var original string
+	if content != incomingReply.Content {
+		original = incomingReply.Content
+	}
+	createdAt := time.Now().UTC()
+	server.events.Publish(events.Event{
+		Kind:     events.PostCreated,
+		Category: params.categoryTag,
+		Num:      num,
+		Parent:   params.threadNumber,
+		Poster:   identity.IPHash,
+		Author:   identity.ID,
+		Post: &data.Post{
+			Num:           num,
+			Cat:           params.categoryTag,
+			Parent:        params.threadNumber,
+			Subject:       incomingReply.Subject,
+			Content:       content,
+			Original:      original,
+			Username:      author.Username,
+			CreatedAt:     createdAt,
+			CreatedAtUnix: createdAt.Unix(),
+			Capcode:       incomingReply.Capcode,
+		},
+	})
+
 	res.Respond(http.StatusOK, ok{Message: "post submitted"}, "")
 }
 
-// handles fetching the user's posts by their email
+// handles fetching the user's posts by their account
 func (server *Server) handleGetUsersPosts(ctx context.Context, req *request, res *response) {
-	posts, err := server.store.GetPostsByEmail(ctx, req.user.Email)
+	identity := data.IdentityFrom(ctx)
+	posts, err := server.store.GetPostsByAuthor(ctx, identity.ID)
 	if err != nil {
-		res.Respond(http.StatusInternalServerError, nil, "internal server error")
+		res.Fail("Failed to get posts by author", err)
 		return
 	}
 	if len(posts) == 0 {
@@ -221,125 +610,436 @@ func (server *Server) handleGetUsersPosts(ctx context.Context, req *request, res
 	res.Respond(http.StatusOK, posts, "")
 }
 
+// claimedPosts is how many posts a user claimed.
+type claimedPosts struct {
+	Claimed int64 `json:"claimed"`
+}
+
+/*
+handleClaimPosts handles a POST request from a user taking ownership of posts written under their email before posts
+were keyed on accounts. Only verified accounts can, so an account can't take posts written with an email it doesn't own.
+*/
+func (server *Server) handleClaimPosts(ctx context.Context, req *request, res *response) {
+	if !req.user.IsVerified {
+		res.Respond(http.StatusUnauthorized, nil, "please verify your account")
+		return
+	}
+	claimed, err := server.store.ClaimPosts(ctx, data.IdentityFrom(ctx))
+	if err != nil {
+		res.Fail("Failed to claim posts", err)
+		return
+	}
+	res.Respond(http.StatusOK, claimedPosts{Claimed: claimed}, "")
+}
+
+// handleSearch handles a GET request to search posts, optionally within a category.
+func (server *Server) handleSearch(ctx context.Context, req *request, res *response) {
+	values := req.rawRequest.URL.Query()
+	query, err := validation.ValidateSearchQuery(values.Get("q"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	limit := maxSearchResults
+	if requested, err := strconv.Atoi(values.Get("limit")); err == nil && requested > 0 && requested < limit {
+		limit = requested
+	}
+	categoryTag := values.Get("cat")
+	// Only the database knows which questions are solved.
+	unanswered := values.Get("unanswered") == "true"
+
+	var posts []*data.Post
+	if server.search != nil && !unanswered {
+		posts, err = server.search.Search(ctx, query, categoryTag, limit)
+		if err != nil {
+			log.Printf("Search backend failed, falling back to SQL: %s", err)
+		}
+	}
+	if server.search == nil || unanswered || err != nil {
+		posts, err = server.store.SearchPosts(ctx, query, categoryTag, unanswered, limit)
+		if err != nil {
+			res.Fail("Failed to search posts", err)
+			return
+		}
+	}
+
+	results := make([]searchResult, 0, len(posts))
+	for _, post := range posts {
+		thread := post.Parent
+		if !post.IsReply() {
+			thread = post.Num
+		}
+		results = append(results, searchResult{Post: post, Thread: thread})
+	}
+	res.Respond(http.StatusOK, results, "")
+}
+
+// handleGetRetainedPosts handles a GET request for a deleted post held in retention.
+func (server *Server) handleGetRetainedPosts(ctx context.Context, req *request, res *response) {
+	num, err := strconv.Atoi(req.params.ByName("num"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "Invalid post number")
+		return
+	}
+	posts, err := server.store.GetRetainedPosts(ctx, req.params.ByName("cat"), num)
+	if err != nil {
+		if errors.Is(err, data.ErrRetentionDisabled) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to get retained posts", err)
+		return
+	}
+	if len(posts) == 0 {
+		res.Respond(http.StatusNotFound, nil, "no retained posts")
+		return
+	}
+	log.Printf("Retained post %s/%d accessed by %s", req.params.ByName("cat"), num, req.user.Email)
+	res.Respond(http.StatusOK, posts, "")
+}
+
+// handleGetPosterHistory handles a GET request for a poster's history, by the hash of their IP or email.
+func (server *Server) handleGetPosterHistory(ctx context.Context, req *request, res *response) {
+	posterHash, err := validation.ValidatePosterHash(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	history, err := server.store.GetPosterHistory(ctx, posterHash)
+	if err != nil {
+		res.Fail("Failed to get poster history", err)
+		return
+	}
+	log.Printf("Poster %s history accessed by %s", posterHash, req.user.Email)
+	res.Respond(http.StatusOK, history, "")
+}
+
+// handleSetCategoryRules handles a PUT request replacing a category's rules.
+func (server *Server) handleSetCategoryRules(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingCategoryRules(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	err = server.store.SetCategoryRules(ctx, req.params.ByName("cat"), incoming.Rules)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to set category rules", err)
+		return
+	}
+	log.Printf("Rules on %s set by %s", req.params.ByName("cat"), req.user.Email)
+	res.Respond(http.StatusOK, incoming.Rules, "")
+}
+
+// Branding describes the board to clients and in error messages.
+type Branding struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	ContactEmail string `json:"contactEmail,omitempty"`
+	// Where the board's site is served, short links redirect there.
+	URL string `json:"url,omitempty"`
+	// IANA name of the board's local timezone, for clients that show times as the board sees them.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Features tells clients what the server supports, so they don't have to hardcode it.
+type Features struct {
+	Attachments bool `json:"attachments"`
+	// Captcha solutions may be required on posts from flagged IPs.
+	Captcha          bool `json:"captcha"`
+	AnonymousPosting bool `json:"anonymousPosting"`
+	MaxContentLength int  `json:"maxContentLength"`
+	MaxSubjectLength int  `json:"maxSubjectLength"`
+	CooldownSeconds  int  `json:"cooldownSeconds"`
+	// Threads are followed by long polling them, there's no websocket endpoint yet.
+	Websockets bool `json:"websockets"`
+	// Searches go to a full-text search backend, rather than falling back to the database's.
+	Search          bool `json:"search"`
+	RulesAcceptance bool `json:"rulesAcceptance"`
+	PublicModLog    bool `json:"publicModLog"`
+	// Thread authors can delete replies to their threads.
+	OPDeleteReplies bool `json:"opDeleteReplies"`
+	// Writes are rejected while the API is read-only for maintenance.
+	ReadOnly bool `json:"readOnly"`
+	// Name of the honeypot field posts must leave empty, if any.
+	Honeypot string `json:"honeypot,omitempty"`
+	// Hours unverified accounts can reply for before they have to verify.
+	UnverifiedGraceHours int `json:"unverifiedGraceHours"`
+	// Languages messages can be served in, picked with Accept-Language.
+	Languages []string `json:"languages"`
+	// Page to send links in posts through, with the link as its url query parameter, if there is one.
+	LinkRedirect string `json:"linkRedirect,omitempty"`
+	// Staff have to enter a two-factor code on their session before using their roles.
+	StaffTwoFactor bool `json:"staffTwoFactor"`
+}
+
+// ConfigResponse describes the instance to clients.
 type ConfigResponse struct {
+	Branding
+	Features Features `json:"features"`
+}
+
+// postingNeedsAccount returns whether the route posts are written through turns away requests without an account.
+func (server *Server) postingNeedsAccount() bool {
+	for _, name := range server.routes[http.MethodPost+" /v1/categories/:cat/:thread"] {
+		if name == "login" || name == "login-grace" || name == "account" {
+			return true
+		}
+	}
+	return false
 }
 
 func (server *Server) handleGetConfig(ctx context.Context, req *request, res *response) {
-	res.Respond(http.StatusOK, ConfigResponse{}, "")
+	features := Features{
+		Captcha:              server.reputation != nil && server.captcha != nil && server.reputationPolicies.Uses(reputation.RequireCaptcha),
+		MaxContentLength:     validation.MaxContentLen,
+		MaxSubjectLength:     validation.MaxSubjectLen,
+		AnonymousPosting:     !server.postingNeedsAccount(),
+		Websockets:           false,
+		Search:               server.search != nil,
+		Attachments:          server.uploads.Storage != nil,
+		RulesAcceptance:      server.requireRulesAcceptance,
+		PublicModLog:         server.publicModLog,
+		OPDeleteReplies:      server.opDeleteReplies,
+		StaffTwoFactor:       server.staffTwoFactor,
+		ReadOnly:             server.maintenance.get().ReadOnly,
+		UnverifiedGraceHours: int(server.unverifiedGrace.Hours()),
+		Languages:            server.translator.Languages(),
+	}
+	// Posts are only rate limited with a limiter, and wait out the stricter cooldown.
+	if server.limiter != nil {
+		cooldown := server.postCooldown
+		if server.accountCooldown > cooldown {
+			cooldown = server.accountCooldown
+		}
+		features.CooldownSeconds = int(cooldown.Seconds())
+	}
+	if server.antibot.MaxSpamScore > 0 {
+		features.Honeypot = server.antibot.Honeypot
+	}
+	if server.linkRedirect {
+		features.LinkRedirect = linkRedirectPath
+	}
+	res.Respond(http.StatusOK, ConfigResponse{Branding: server.branding, Features: features}, "")
+}
+
+// handleNotFound responds to requests for routes that don't exist.
+func (server *Server) handleNotFound(rw http.ResponseWriter, req *http.Request) {
+	res := &response{rw: rw, contactEmail: server.branding.ContactEmail}
+	res.Respond(http.StatusNotFound, nil, fmt.Sprintf("%s has nothing at %s", server.branding.Name, req.URL.Path))
+}
+
+// handlePanic responds to requests whose handler panicked, instead of dropping the connection.
+func (server *Server) handlePanic(rw http.ResponseWriter, req *http.Request, recovered interface{}) {
+	log.Printf("Panic handling %s %s: %v", req.Method, req.URL.Path, recovered)
+	if server.tracker != nil {
+		server.tracker.Capture(&errtrack.Event{
+			Message: fmt.Sprintf("panic: %v", recovered),
+			Level:   "fatal",
+			Stack:   string(debug.Stack()),
+			Tags:    map[string]string{"request_id": rw.Header().Get("X-Request-ID")},
+			Method:  req.Method,
+			URL:     req.URL.String(),
+		})
+	}
+	res := &response{rw: rw, contactEmail: server.branding.ContactEmail}
+	res.Respond(http.StatusInternalServerError, nil, genericFailMessage)
 }
 
-// Handle handleCORSPreflight pre-flighting
+// Handle handleCORSPreflight pre-flighting, allowing the methods the router found routes for in the Allow header.
 func handleCORSPreflight(allowedOrigin string) http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-		rw.Header().Set("Access-Control-Allow-Methods", "GET,POST,DELETE")
-		rw.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+		rw.Header().Set("Access-Control-Allow-Methods", rw.Header().Get("Allow"))
+		rw.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,If-Match,"+captchaHeader+","+clientKeyHeader)
 		rw.WriteHeader(http.StatusNoContent)
 	}
 }
 
+// handleMethodNotAllowed responds to requests for routes that exist, but not for their method, which the router lists in Allow.
+func (server *Server) handleMethodNotAllowed(allowedOrigin string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+		res := &response{rw: rw, contactEmail: server.branding.ContactEmail}
+		res.Respond(
+			http.StatusMethodNotAllowed,
+			nil,
+			fmt.Sprintf("%s isn't allowed at %s, try %s", req.Method, req.URL.Path, rw.Header().Get("Allow")),
+		)
+	}
+}
+
 // ServerOptions configure the server.
 type ServerOptions struct {
-	Address             string
+	Address string
+	// Optional, moves every /v1/admin route onto a listener of its own at this address, like an internal-only port.
+	AdminAddress        string
 	CorsOriginAllow     string
 	PostCooldownSeconds int
+	// Zero doesn't rate limit accounts, only IPs.
+	AccountCooldownSeconds int
+	// Lets unverified accounts reply, but not start threads, for this long after they're first seen.
+	UnverifiedGrace time.Duration
+	// Optional, users can only log in through the client's own Auth0 setup without providers.
+	Social SocialOptions
+	// Optional, posts aren't rate limited without one.
+	RateLimiter ratelimit.Limiter
+	// Optional, post lifecycle events are published here for subsystems to react to.
+	Events *events.Bus
+	// Optional, searches are run against the database without one.
+	Search search.Backend
+	// Antibot checks are skipped unless MaxSpamScore is set.
+	Antibot AntibotOptions
+	// Optional, posters' IPs aren't checked without one.
+	Reputation         reputation.Checker
+	ReputationPolicies reputation.Policies
+	// Optional, required by the captcha reputation policy.
+	Captcha reputation.Captcha
+	// Optional, moderators' claims are held in process without one.
+	Locker lock.Locker
+	// Optional, thread and reply counts are held in process without one.
+	Counter counters.Counter
+	Login   LoginOptions
+	// Serves each category's moderation log publicly at /v1/modlog/:cat when set, moderators can always see it.
+	PublicModLog bool
+	// Serves a page at /out warning people that links in posts leave the board, for clients to send links through.
+	LinkRedirect bool
+	// Rejects first posts to categories with rules unless the poster accepts them.
+	RequireRulesAcceptance bool
+	// Lets thread authors delete replies to their threads.
+	OPDeleteReplies bool
+	// Refuses staff roles to sessions that haven't had a two-factor code entered.
+	StaffTwoFactor bool
+	// Usernames and display names nobody can take, or anything that looks like them. Defaults to validation.DefaultReservedNames.
+	ReservedNames []string
+	// Returned by /v1/config, Name defaults to spiritchat.
+	Branding Branding
+	// Read-only mode rejects everything but GET requests, admins can toggle it at runtime.
+	Maintenance MaintenanceOptions
+	// Optional, writes from browsers on other origins are refused when set, like https://example.com.
+	TrustedOrigins []string
+	// Soft limits on GET requests made without logging in, off unless ReadLimits.Quota is set.
+	ReadLimits ReadLimitOptions
+	// Attachments are disabled unless Uploads.Storage is set.
+	Uploads UploadOptions
+	// Optional, server errors and panics are only logged without one.
+	ErrorTracker errtrack.Tracker
+	// Message catalogs by language tag like fr or pt-br, picked by Accept-Language. Messages are English without them.
+	Locales map[string]i18n.Catalog
 }
 
 // NewServer stub todo
-func NewServer(store data.Store, auth auth.Auth, opts ServerOptions) *Server {
+func NewServer(store data.Store, authenticator auth.Auth, opts ServerOptions) *Server {
+
+	bus := opts.Events
+	if bus == nil {
+		bus = events.NewBus()
+	}
+	locker := opts.Locker
+	if locker == nil {
+		locker = lock.NewMemory()
+	}
+	counter := opts.Counter
+	if counter == nil {
+		counter = counters.NewMemory()
+	}
+	login := opts.Login
+	if login.Accounts == nil {
+		login.Accounts = ratelimit.NewMemoryAttempts(ratelimit.AttemptsOptions{})
+	}
+	if login.IPs == nil {
+		login.IPs = ratelimit.NewMemoryAttempts(ratelimit.AttemptsOptions{Free: LoginIPFreeAttempts})
+	}
+	reservedNames := opts.ReservedNames
+	if reservedNames == nil {
+		reservedNames = validation.DefaultReservedNames
+	}
+	branding := opts.Branding
+	if len(branding.Name) == 0 {
+		branding.Name = "spiritchat"
+	}
+	translator := i18n.NewTranslator(englishMessages)
+	for lang, catalog := range opts.Locales {
+		translator.Add(lang, catalog)
+	}
 
 	server := &Server{
-		store: store,
+		store:           store,
+		events:          bus,
+		search:          opts.Search,
+		antibot:         opts.Antibot,
+		threadViews:     newViewTracker(),
+		stats:           &statsCache{},
+		categoryList:    &responseCache{},
+		polls:           newPollHub(bus),
+		tracker:         opts.ErrorTracker,
+		translator:      translator,
+		limiter:         opts.RateLimiter,
+		postCooldown:    time.Second * time.Duration(opts.PostCooldownSeconds),
+		accountCooldown: time.Second * time.Duration(opts.AccountCooldownSeconds),
+		unverifiedGrace: opts.UnverifiedGrace,
+		social:          opts.Social,
+		login:           login,
+
+		reputation:             opts.Reputation,
+		reputationPolicies:     opts.ReputationPolicies,
+		captcha:                opts.Captcha,
+		locker:                 locker,
+		counter:                counter,
+		publicModLog:           opts.PublicModLog,
+		linkRedirect:           opts.LinkRedirect,
+		requireRulesAcceptance: opts.RequireRulesAcceptance,
+		opDeleteReplies:        opts.OPDeleteReplies,
+		staffTwoFactor:         opts.StaffTwoFactor,
+		branding:               branding,
+		reservedNames:          validation.NewReservedNames(reservedNames),
+		trustedOrigins:         newTrustedOrigins(opts.TrustedOrigins),
+		maintenance:            newMaintenance(opts.Maintenance),
+		readLimits:             newReadLimits(opts.ReadLimits),
+		uploads:                opts.Uploads,
 		httpServer: http.Server{
 			Addr:              opts.Address,
 			IdleTimeout:       time.Minute * 10,
 			ReadHeaderTimeout: time.Second * 10,
 		},
-		auth: auth,
+		auth: authenticator,
 	}
 
-	router := httprouter.New()
-	router.GlobalOPTIONS = http.HandlerFunc(
-		handleCORSPreflight(opts.CorsOriginAllow),
-	)
-
-	router.GET(
-		"/v1/categories",
-		makeHandler(
-			server.middlewareCORS(
-				server.handleGetCategories,
-				opts.CorsOriginAllow,
-			),
-		),
-	)
-	router.GET(
-		"/v1/categories/:cat",
-		makeHandler(
-			server.middlewareCORS(
-				server.handleGetCategoryView, opts.CorsOriginAllow,
-			),
-		),
-	)
-	router.POST(
-		"/v1/categories/:cat/:thread",
-		makeHandler(
-			server.middlewareCORS(
-				server.middlewareRequireLogin(
-					server.handleCreatePost),
-				opts.CorsOriginAllow,
-			),
-		),
-	)
-	router.DELETE(
-		"/v1/categories/:cat/:thread",
-		makeHandler(
-			server.middlewareCORS(
-				server.middlewareRequireLogin(server.handleRemovePost),
-				opts.CorsOriginAllow,
-			),
-		),
-	)
-	router.GET(
-		"/v1/categories/:cat/:thread",
-		makeHandler(
-			server.middlewareCORS(
-				server.handleGetThreadView,
-				opts.CorsOriginAllow,
-			),
-		),
-	)
-
-	router.POST(
-		"/v1/signup",
-		makeHandler(
-			server.middlewareCORS(
-				server.handleSignUp,
-				opts.CorsOriginAllow,
-			),
-		),
-	)
-
-	router.GET("/v1/yours",
-		makeHandler(
-			server.middlewareCORS(
-				server.middlewareRequireLogin(
-					server.handleGetUsersPosts,
-				),
-				opts.CorsOriginAllow,
-			),
-		),
-	)
+	router := server.newRouter(opts.CorsOriginAllow)
+	adminRouter := router
+	if len(opts.AdminAddress) > 0 {
+		adminRouter = server.newRouter(opts.CorsOriginAllow)
+		server.adminServer = &http.Server{
+			Addr:              opts.AdminAddress,
+			Handler:           adminRouter,
+			IdleTimeout:       server.httpServer.IdleTimeout,
+			ReadHeaderTimeout: server.httpServer.ReadHeaderTimeout,
+		}
+	}
 
-	router.GET(
-		"/v1/config",
-		makeHandler(
-			server.middlewareCORS(
-				server.handleGetConfig,
-				opts.CorsOriginAllow,
-			),
-		),
-	)
+	server.routes = server.registerRoutes(router, adminRouter, opts.CorsOriginAllow)
 
 	server.httpServer.Handler = router
 	return server
 }
+
+// newRouter returns a router that pre-flights, and handles unknown routes and panics, like every listener should.
+func (server *Server) newRouter(allowedOrigin string) *httprouter.Router {
+	router := httprouter.New()
+	router.GlobalOPTIONS = http.HandlerFunc(
+		handleCORSPreflight(allowedOrigin),
+	)
+	router.NotFound = http.HandlerFunc(server.handleNotFound)
+	router.MethodNotAllowed = server.handleMethodNotAllowed(allowedOrigin)
+	router.PanicHandler = server.handlePanic
+	return router
+}
@@ -3,20 +3,113 @@ package serve
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"spiritchat/auth"
 	"spiritchat/data"
+	"spiritchat/data/datatest"
+	"spiritchat/events"
+	"spiritchat/ratelimit"
+	"strings"
 	"testing"
+	"time"
 )
 
+// Fails to compile when MockStore falls behind the interface handlers are written against.
+var _ data.Store = (*MockStore)(nil)
+
 type MockStore struct {
-	err             error
-	getThreadView   *data.ThreadView
-	getCategories   []*data.Category
-	getCategory     *data.Category
-	getCategoryView *data.CatView
+	err              error
+	getThreadView    *data.ThreadView
+	getCategories    []*data.Category
+	getCategory      *data.Category
+	getCategoryView  *data.CatView
+	getPost          *data.Post
+	replyCounts      map[int]int
+	wroteCategory    string
+	searchPosts      []*data.Post
+	getRetainedPosts []*data.RetainedPost
+	posterHistory    *data.PosterHistory
+	queue            []*data.QueueItem
+	resolved         []data.Resolution
+	complaints       []*data.Complaint
+	clientKeys       []*data.ClientKey
+	changes          []*data.PostChange
+	postEvents       []*data.PostEvent
+	webhooks         []*data.Webhook
+	// Hashes of the client keys issued, by ID.
+	clientKeyHashes map[int]string
+	banned          bool
+	bulkActions     *data.BulkActions
+	rules           []*data.Rule
+	notes           []*data.Note
+	capcode         string
+	categoryRules   []string
+	acceptedRules   []string
+	pages           []*data.Page
+	postOwnership   *data.PostOwnership
+	closed          bool
+	bestAnswer      int
+	threadType      data.ThreadType
+	unanswered      bool
+	// Category slugs renamed, from old to new.
+	slugs           map[string]string
+	listing         *data.Category
+	archived        bool
+	removeCategory  *data.RemoveCategoryOptions
+	categoryUpdate  *data.CategoryUpdate
+	masks           []string
+	postPolicy      *data.PostPolicy
+	blockedDomains  []*data.BlockedDomain
+	canaries        []*data.Canary
+	canaryHits      []*data.CanaryHit
+	piiAccesses     []*data.PIIAccess
+	sessions        []*data.Session
+	sessionTokens   map[string]*data.Session
+	totp            map[string]*data.TOTP
+	archiveOffset   int
+	anonymizeJobs   []*data.AnonymizeJob
+	threadPage      data.ThreadPage
+	attachments     []*data.Attachment
+	postAttachments []data.AttachmentRef
+	pruneBudget     int64
+	pruned          []*data.Attachment
+	updateVersion   int
+	firstSeen       time.Time
+	preferences     *data.Preferences
+	// Users and hashes of the requests confirmation tokens were issued for, by token, until they're used.
+	confirmations map[string]string
+	// Identity the last post was written as, and its content.
+	author       *data.Identity
+	wroteContent string
+	// Username last recorded for an account.
+	recorded string
+	// Account posts were last claimed by.
+	claimedBy string
+	messages  []*data.Message
+	blocked   map[string]bool
+	// Bounds of the last page of messages, notifications or bookmarks asked for.
+	pageBefore    int
+	pageLimit     int
+	notifications []*data.Notification
+	// Posts bookmarked, as cat/num.
+	bookmarks map[string]bool
+	shareLink *data.ShareLink
+	// Categories subscribed to.
+	subscriptions map[string]bool
+	// Times stats were counted.
+	statsCounted int
+	// Impersonation granted by the token "imp-token", and the requests audited under it.
+	impersonation *data.Impersonation
+	audited       []string
+}
+
+// MockStore is held to the same contract as the real store for what it doesn't have, so handlers see the same errors.
+func TestMockStoreContract(t *testing.T) {
+	datatest.MissingContract(context.Background(), &MockStore{})(t)
 }
 
 func (ms *MockStore) Cleanup(ctx context.Context) error {
@@ -24,57 +117,1021 @@ func (ms *MockStore) Cleanup(ctx context.Context) error {
 }
 
 func (ms *MockStore) WriteCategory(ctx context.Context, tag string, name string) error {
-	panic("not implemented") // TODO: Implement
+	if ms.err == nil {
+		ms.wroteCategory = tag
+	}
+	return ms.err
 }
 
-func (ms *MockStore) RemoveCategory(ctx context.Context, catName string) (int64, error) {
-	panic("not implemented") // TODO: Implement
+func (ms *MockStore) RemoveCategory(ctx context.Context, catName string, opts data.RemoveCategoryOptions) (*data.CategoryRemoval, error) {
+	ms.removeCategory = &opts
+	removal := &data.CategoryRemoval{Categories: 1, Posts: 3, Archived: opts.Archive, DryRun: opts.DryRun}
+	if !opts.DryRun {
+		removal.Threads = []int{1, 2}
+	}
+	return removal, ms.err
 }
 
 func (ms *MockStore) GetThreadCount(ctx context.Context, catName string) (int, error) {
 	panic("not implemented") // TODO: Implement
 }
 
+func (ms *MockStore) GetReplyCounts(ctx context.Context, categoryTag string) (map[int]int, error) {
+	return ms.replyCounts, ms.err
+}
+
 func (ms *MockStore) GetCategories(ctx context.Context) ([]*data.Category, error) {
 	return ms.getCategories, ms.err
 }
 
 func (ms *MockStore) GetPostByNumber(ctx context.Context, catName string, num int) (*data.Post, error) {
-	panic("not implemented") // TODO: Implement
+	if ms.getPost == nil {
+		return nil, data.ErrNotFound
+	}
+	return ms.getPost, nil
+}
+
+func (ms *MockStore) GetThreadView(ctx context.Context, catName string, threadNum int) (*data.ThreadView, error) {
+	if ms.getThreadView == nil && ms.err == nil {
+		return nil, data.ErrNotFound
+	}
+	return ms.getThreadView, ms.err
+}
+
+func (ms *MockStore) StreamThreadView(ctx context.Context, catName string, threadNum int, page data.ThreadPage, w data.ThreadWriter) (bool, error) {
+	ms.threadPage = page
+	if ms.err != nil {
+		return false, ms.err
+	}
+	if ms.getThreadView == nil {
+		return false, data.ErrNotFound
+	}
+	posts, more := ms.getThreadView.Posts, false
+	skip := page.Offset
+	if page.Last > 0 && len(posts)-1 > page.Last {
+		skip = len(posts) - 1 - page.Last
+	}
+	if skip > len(posts)-1 {
+		skip = len(posts) - 1
+	}
+	if skip > 0 {
+		posts = append([]*data.Post{posts[0]}, posts[1+skip:]...)
+	}
+	if page.Limit > 0 && len(posts) > page.Limit {
+		posts, more = posts[:page.Limit], true
+	}
+	err := w.WriteCategory(ms.getThreadView.Category)
+	if err != nil {
+		return false, err
+	}
+	return more, w.WritePosts(posts)
+}
+
+func (ms *MockStore) GetCategory(ctx context.Context, catName string) (*data.Category, error) {
+	if ms.getCategory == nil && ms.err == nil {
+		return nil, data.ErrNotFound
+	}
+	return ms.getCategory, ms.err
+}
+
+func (ms *MockStore) GetCategoryView(ctx context.Context, catName string) (*data.CatView, error) {
+	if ms.getCategoryView == nil && ms.err == nil {
+		return nil, data.ErrNotFound
+	}
+	return ms.getCategoryView, ms.err
+}
+
+func (ms *MockStore) WritePost(ctx context.Context, catName string, parentThreadNumber int, subject string, content string, author *data.Identity, capcode string, threadType data.ThreadType, attachments []data.AttachmentRef) (int, error) {
+	ms.author = author
+	ms.wroteContent = content
+	ms.capcode = capcode
+	ms.threadType = threadType
+	ms.postAttachments = attachments
+	return 1, ms.err
+}
+
+func (ms *MockStore) WriteAttachment(ctx context.Context, attachment *data.Attachment) (int, error) {
+	ms.attachments = append(ms.attachments, attachment)
+	return len(ms.attachments), ms.err
+}
+
+func (ms *MockStore) GetAttachment(ctx context.Context, id int) (*data.Attachment, error) {
+	if id < 1 || id > len(ms.attachments) {
+		return nil, data.ErrNotFound
+	}
+	return ms.attachments[id-1], nil
+}
+
+func (ms *MockStore) SetAttachmentTranscode(ctx context.Context, id int, file string, mime string) error {
+	return ms.err
+}
+
+func (ms *MockStore) SetAttachmentFlags(ctx context.Context, id int, flags *data.AttachmentFlags) (*data.Attachment, error) {
+	if id < 1 || id > len(ms.attachments) {
+		return nil, data.ErrNotFound
+	}
+	attachment := ms.attachments[id-1]
+	if flags.Spoiler != nil {
+		attachment.Spoiler = *flags.Spoiler
+	}
+	if flags.NSFW != nil {
+		attachment.NSFW = *flags.NSFW
+	}
+	return attachment, ms.err
+}
+
+func (ms *MockStore) GetStorageUsage(ctx context.Context) (*data.StorageUsage, error) {
+	usage := &data.StorageUsage{Categories: make([]*data.CategoryUsage, 0)}
+	for _, attachment := range ms.attachments {
+		usage.TotalBytes += attachment.Size
+		usage.TotalFiles++
+	}
+	return usage, ms.err
+}
+
+func (ms *MockStore) GetCategoryStorageUsage(ctx context.Context, categoryTag string) (int64, error) {
+	var used int64
+	for _, attachment := range ms.attachments {
+		used += attachment.Size
+	}
+	return used, ms.err
+}
+
+func (ms *MockStore) PruneAttachments(ctx context.Context, categoryTag string, budget int64) ([]*data.Attachment, error) {
+	ms.pruneBudget = budget
+	return ms.pruned, ms.err
+}
+
+func (ms *MockStore) CollectOrphanedAttachments(ctx context.Context, grace time.Duration) ([]*data.Attachment, error) {
+	return nil, ms.err
+}
+
+func (ms *MockStore) AccountFirstSeen(ctx context.Context, accountID string) (time.Time, error) {
+	return ms.firstSeen, ms.err
+}
+
+func (ms *MockStore) GetPreferences(ctx context.Context, accountID string) (*data.Preferences, error) {
+	// err is for the post being written, not the author's preferences.
+	if ms.preferences == nil {
+		return &data.Preferences{}, nil
+	}
+	return ms.preferences, nil
+}
+
+func (ms *MockStore) UpdatePreferences(ctx context.Context, accountID string, update *data.PreferencesUpdate) (*data.Preferences, error) {
+	if ms.preferences == nil {
+		ms.preferences = &data.Preferences{}
+	}
+	if update.DisplayName != nil {
+		ms.preferences.DisplayName = *update.DisplayName
+	}
+	if update.Anonymous != nil {
+		ms.preferences.Anonymous = *update.Anonymous
+	}
+	if update.HideNSFW != nil {
+		ms.preferences.HideNSFW = *update.HideNSFW
+	}
+	if update.Timezone != nil {
+		ms.preferences.Timezone = *update.Timezone
+	}
+	if update.MuteMentions != nil {
+		ms.preferences.MuteMentions = *update.MuteMentions
+	}
+	return ms.preferences, ms.err
+}
+
+func (ms *MockStore) RecordAccount(ctx context.Context, accountID string, username string) error {
+	ms.recorded = username
+	return nil
+}
+
+func (ms *MockStore) SendMessage(ctx context.Context, sender *data.Identity, recipient string, content string) (*data.Message, error) {
+	if ms.err != nil {
+		return nil, ms.err
+	}
+	if ms.blocked[recipient] {
+		return nil, data.ErrCantMessage
+	}
+	message := &data.Message{ID: len(ms.messages) + 1, Conversation: 1, From: sender.Username, Content: content}
+	ms.messages = append(ms.messages, message)
+	return message, nil
+}
+
+func (ms *MockStore) GetConversations(ctx context.Context, accountID string) ([]*data.Conversation, error) {
+	return []*data.Conversation{{ID: 1, With: "other", Unread: len(ms.messages)}}, ms.err
+}
+
+func (ms *MockStore) GetMessages(ctx context.Context, accountID string, conversationID int, before int, limit int) ([]*data.Message, error) {
+	ms.pageBefore, ms.pageLimit = before, limit
+	if conversationID != 1 {
+		return nil, data.ErrNotFound
+	}
+	return ms.messages, ms.err
+}
+
+func (ms *MockStore) CountUnreadMessages(ctx context.Context, accountID string) (int, error) {
+	return len(ms.messages), ms.err
+}
+
+func (ms *MockStore) WriteMentions(ctx context.Context, post *data.Post, authorID string, usernames []string) (int, error) {
+	return len(usernames), ms.err
+}
+
+func (ms *MockStore) GetNotifications(ctx context.Context, accountID string, before int, limit int) ([]*data.Notification, error) {
+	ms.pageBefore, ms.pageLimit = before, limit
+	return ms.notifications, ms.err
+}
+
+func (ms *MockStore) CountUnreadNotifications(ctx context.Context, accountID string) (int, error) {
+	return len(ms.notifications), ms.err
+}
+
+func (ms *MockStore) SetBookmark(ctx context.Context, accountID string, categoryTag string, num int, bookmarked bool) error {
+	if ms.bookmarks == nil {
+		ms.bookmarks = make(map[string]bool)
+	}
+	key := fmt.Sprintf("%s/%d", categoryTag, num)
+	if bookmarked {
+		ms.bookmarks[key] = true
+	} else {
+		delete(ms.bookmarks, key)
+	}
+	return ms.err
+}
+
+func (ms *MockStore) GetBookmarks(ctx context.Context, accountID string, before int, limit int) ([]*data.Bookmark, error) {
+	ms.pageBefore, ms.pageLimit = before, limit
+	bookmarks := make([]*data.Bookmark, 0)
+	for range ms.bookmarks {
+		bookmarks = append(bookmarks, &data.Bookmark{Post: &data.Post{}})
+	}
+	return bookmarks, ms.err
+}
+
+func (ms *MockStore) WriteShareLink(ctx context.Context, categoryTag string, thread int, post int) (string, error) {
+	ms.shareLink = &data.ShareLink{Token: "abc", Cat: categoryTag, Slug: categoryTag, Thread: thread, Post: post}
+	return ms.shareLink.Token, ms.err
+}
+
+func (ms *MockStore) FollowShareLink(ctx context.Context, token string) (*data.ShareLink, error) {
+	if ms.shareLink == nil || token != ms.shareLink.Token {
+		return nil, data.ErrNotFound
+	}
+	ms.shareLink.Clicks++
+	return ms.shareLink, ms.err
+}
+
+func (ms *MockStore) SetCategorySubscription(ctx context.Context, accountID string, categoryTag string, subscribed bool) error {
+	if ms.subscriptions == nil {
+		ms.subscriptions = make(map[string]bool)
+	}
+	if subscribed {
+		ms.subscriptions[categoryTag] = true
+	} else {
+		delete(ms.subscriptions, categoryTag)
+	}
+	return ms.err
+}
+
+func (ms *MockStore) GetCategorySubscriptions(ctx context.Context, accountID string) ([]string, error) {
+	subscriptions := make([]string, 0)
+	for categoryTag := range ms.subscriptions {
+		subscriptions = append(subscriptions, categoryTag)
+	}
+	return subscriptions, ms.err
+}
+
+func (ms *MockStore) WriteDigests(ctx context.Context, size int) (int, error) {
+	return len(ms.subscriptions), ms.err
+}
+
+func (ms *MockStore) GetDigests(ctx context.Context, accountID string, before int, limit int) ([]*data.Digest, error) {
+	ms.pageBefore, ms.pageLimit = before, limit
+	return []*data.Digest{}, ms.err
+}
+
+func (ms *MockStore) GetStats(ctx context.Context) (*data.Stats, error) {
+	if ms.err != nil {
+		return nil, ms.err
+	}
+	ms.statsCounted++
+	return &data.Stats{Posts: 10, PostsToday: 2, ActiveThreads: 1, Categories: 3}, nil
+}
+
+func (ms *MockStore) WriteImpersonation(ctx context.Context, imp *data.Impersonation) (string, error) {
+	imp.ID = 1
+	ms.impersonation = imp
+	return "imp-token", ms.err
+}
+
+func (ms *MockStore) GetImpersonationByToken(ctx context.Context, token string) (*data.Impersonation, error) {
+	if ms.impersonation == nil || token != "imp-token" || ms.impersonation.Revoked ||
+		time.Now().After(ms.impersonation.Expires) {
+		return nil, data.ErrNotFound
+	}
+	return ms.impersonation, nil
+}
+
+func (ms *MockStore) GetImpersonations(ctx context.Context, limit int) ([]*data.Impersonation, error) {
+	if ms.impersonation == nil {
+		return []*data.Impersonation{}, ms.err
+	}
+	return []*data.Impersonation{ms.impersonation}, ms.err
+}
+
+func (ms *MockStore) RevokeImpersonation(ctx context.Context, id int) error {
+	if ms.impersonation == nil || id != ms.impersonation.ID {
+		return data.ErrNotFound
+	}
+	ms.impersonation.Revoked = true
+	return ms.err
+}
+
+func (ms *MockStore) LogImpersonationAction(ctx context.Context, id int, method string, path string) error {
+	if ms.err != nil {
+		return ms.err
+	}
+	ms.audited = append(ms.audited, method+" "+path)
+	return nil
+}
+
+func (ms *MockStore) GetImpersonationActions(ctx context.Context, id int) ([]*data.ImpersonationAction, error) {
+	actions := make([]*data.ImpersonationAction, len(ms.audited))
+	for i, action := range ms.audited {
+		parts := strings.SplitN(action, " ", 2)
+		actions[i] = &data.ImpersonationAction{Method: parts[0], Path: parts[1]}
+	}
+	return actions, ms.err
+}
+
+func (ms *MockStore) GetBlocked(ctx context.Context, accountID string) ([]string, error) {
+	blocked := make([]string, 0)
+	for username := range ms.blocked {
+		blocked = append(blocked, username)
+	}
+	return blocked, ms.err
+}
+
+func (ms *MockStore) SetBlocked(ctx context.Context, accountID string, username string, blocked bool) error {
+	if ms.blocked == nil {
+		ms.blocked = make(map[string]bool)
+	}
+	if blocked {
+		ms.blocked[username] = true
+	} else {
+		delete(ms.blocked, username)
+	}
+	return ms.err
+}
+
+func (ms *MockStore) RemovePost(ctx context.Context, categoryTag string, number int) (int, error) {
+	return 0, ms.err
+}
+
+func (ms *MockStore) GetPostOwnership(ctx context.Context, categoryTag string, postNumber int) (*data.PostOwnership, error) {
+	if ms.postOwnership == nil && ms.err == nil {
+		return nil, data.ErrNotFound
+	}
+	return ms.postOwnership, ms.err
+}
+
+func (ms *MockStore) CloseThread(ctx context.Context, categoryTag string, threadNum int) error {
+	ms.closed = ms.err == nil
+	return ms.err
+}
+
+func (ms *MockStore) SetBestAnswer(ctx context.Context, categoryTag string, threadNum int, replyNum int) error {
+	ms.bestAnswer = replyNum
+	return ms.err
+}
+
+func (ms *MockStore) ResolveCategorySlug(ctx context.Context, slug string) (string, string, error) {
+	if renamed, ok := ms.slugs[slug]; ok {
+		return slug, renamed, nil
+	}
+	return slug, slug, nil
+}
+
+func (ms *MockStore) SetCategorySlug(ctx context.Context, categoryTag string, slug string) error {
+	if ms.err != nil {
+		return ms.err
+	}
+	if ms.slugs == nil {
+		ms.slugs = make(map[string]string)
+	}
+	ms.slugs[categoryTag] = slug
+	return nil
+}
+
+func (ms *MockStore) SetCategoryListing(ctx context.Context, categoryTag string, sortOrder int, featured bool) error {
+	ms.listing = &data.Category{Tag: categoryTag, SortOrder: sortOrder, Featured: featured}
+	return ms.err
+}
+
+func (ms *MockStore) SetCategoryArchived(ctx context.Context, categoryTag string, archived bool) error {
+	ms.archived = archived
+	return ms.err
+}
+
+func (ms *MockStore) UpdateCategory(ctx context.Context, categoryTag string, version int, update *data.CategoryUpdate) (*data.Category, error) {
+	ms.categoryUpdate = update
+	ms.updateVersion = version
+	if ms.err != nil {
+		return nil, ms.err
+	}
+	return &data.Category{Tag: categoryTag, Version: version + 1}, nil
+}
+
+func (ms *MockStore) GetCategoryMasks(ctx context.Context, categoryTag string) ([]string, error) {
+	return ms.masks, nil
+}
+
+func (ms *MockStore) SetCategoryMasks(ctx context.Context, categoryTag string, words []string) error {
+	ms.masks = words
+	return ms.err
+}
+
+func (ms *MockStore) GetPostPolicy(ctx context.Context, categoryTag string) (*data.PostPolicy, error) {
+	policy := &data.PostPolicy{}
+	if ms.postPolicy != nil {
+		*policy = *ms.postPolicy
+	}
+	policy.MaskedWords = ms.masks
+	return policy, nil
+}
+
+func (ms *MockStore) SetPostPolicy(ctx context.Context, categoryTag string, policy *data.PostPolicy) error {
+	ms.postPolicy = policy
+	return ms.err
+}
+
+func (ms *MockStore) GetPostsByAuthor(ctx context.Context, authorID string) ([]*data.Post, error) {
+	var d []*data.Post
+	return d, ms.err
+}
+
+func (ms *MockStore) ClaimPosts(ctx context.Context, author *data.Identity) (int64, error) {
+	ms.claimedBy = author.ID
+	return 0, nil
+}
+
+func (ms *MockStore) SearchPosts(ctx context.Context, query string, categoryTag string, unanswered bool, limit int) ([]*data.Post, error) {
+	ms.unanswered = unanswered
+	return ms.searchPosts, ms.err
+}
+
+func (ms *MockStore) GetRetainedPosts(ctx context.Context, categoryTag string, number int) ([]*data.RetainedPost, error) {
+	return ms.getRetainedPosts, ms.err
+}
+
+func (ms *MockStore) PurgeRetainedPosts(ctx context.Context) (int64, error) {
+	return 0, ms.err
+}
+
+func (ms *MockStore) GetPosterHistory(ctx context.Context, posterHash string) (*data.PosterHistory, error) {
+	return ms.posterHistory, ms.err
+}
+
+func (ms *MockStore) WriteReport(ctx context.Context, categoryTag string, num int, reason string, reporterHash string) error {
+	return ms.err
+}
+
+func (ms *MockStore) GetModerationQueue(ctx context.Context, limit int) ([]*data.QueueItem, error) {
+	return ms.queue, ms.err
+}
+
+func (ms *MockStore) ResolveQueueItem(ctx context.Context, categoryTag string, num int, resolution data.Resolution) error {
+	ms.resolved = append(ms.resolved, resolution)
+	return ms.err
+}
+
+func (ms *MockStore) WriteComplaint(ctx context.Context, complaint *data.Complaint) (int, error) {
+	if ms.err != nil {
+		return 0, ms.err
+	}
+	complaint.ID = len(ms.complaints) + 1
+	ms.complaints = append(ms.complaints, complaint)
+	return len(complaint.Posts), nil
+}
+
+func (ms *MockStore) GetComplaint(ctx context.Context, id int) (*data.Complaint, error) {
+	if id < 1 || id > len(ms.complaints) {
+		return nil, data.ErrNotFound
+	}
+	return ms.complaints[id-1], nil
+}
+
+func (ms *MockStore) GetChanges(ctx context.Context, since int64, limit int) ([]*data.PostChange, error) {
+	changes := make([]*data.PostChange, 0)
+	for _, change := range ms.changes {
+		if change.Cursor > since && len(changes) < limit {
+			changes = append(changes, change)
+		}
+	}
+	return changes, ms.err
+}
+
+func (ms *MockStore) GetPostEvents(ctx context.Context, categoryTag string, num int) ([]*data.PostEvent, error) {
+	return ms.postEvents, ms.err
+}
+
+func (ms *MockStore) GetWebhooks(ctx context.Context) ([]*data.Webhook, error) {
+	return ms.webhooks, ms.err
+}
+
+// WriteWebhook refuses webhooks on category "missing".
+func (ms *MockStore) WriteWebhook(ctx context.Context, hook *data.Webhook) error {
+	if hook.Cat == "missing" {
+		return data.ErrNotFound
+	}
+	hook.ID = len(ms.webhooks) + 1
+	hook.Signed = len(hook.Secret) > 0
+	ms.webhooks = append(ms.webhooks, hook)
+	return ms.err
+}
+
+func (ms *MockStore) UpdateWebhook(ctx context.Context, hook *data.Webhook) error {
+	if hook.ID < 1 || hook.ID > len(ms.webhooks) || hook.Cat == "missing" {
+		return data.ErrNotFound
+	}
+	if len(hook.Secret) == 0 {
+		hook.Secret = ms.webhooks[hook.ID-1].Secret
+	}
+	hook.Signed = len(hook.Secret) > 0
+	ms.webhooks[hook.ID-1] = hook
+	return ms.err
+}
+
+func (ms *MockStore) RemoveWebhook(ctx context.Context, id int) error {
+	if id < 1 || id > len(ms.webhooks) || ms.webhooks[id-1] == nil {
+		return data.ErrNotFound
+	}
+	ms.webhooks[id-1] = nil
+	return ms.err
+}
+
+func (ms *MockStore) WriteClientKey(ctx context.Context, label string) (*data.ClientKey, string, error) {
+	if ms.clientKeyHashes == nil {
+		ms.clientKeyHashes = make(map[int]string)
+	}
+	clientKey := &data.ClientKey{ID: len(ms.clientKeys) + 1, Label: label}
+	key := fmt.Sprintf("key-%d", clientKey.ID)
+	ms.clientKeys = append(ms.clientKeys, clientKey)
+	ms.clientKeyHashes[clientKey.ID] = data.ClientKeyHash(key)
+	return clientKey, key, nil
+}
+
+func (ms *MockStore) WriteConfirmation(ctx context.Context, userID string, requestHash string, expires time.Time) (string, error) {
+	if ms.confirmations == nil {
+		ms.confirmations = make(map[string]string)
+	}
+	token := fmt.Sprintf("confirm-%d", len(ms.confirmations)+1)
+	ms.confirmations[token] = userID + " " + requestHash
+	return token, nil
+}
+
+func (ms *MockStore) UseConfirmation(ctx context.Context, token string, userID string, requestHash string) error {
+	if ms.confirmations[token] != userID+" "+requestHash {
+		return data.ErrNotFound
+	}
+	ms.confirmations[token] = ""
+	return nil
+}
+
+func (ms *MockStore) GetClientKeys(ctx context.Context) ([]*data.ClientKey, error) {
+	return ms.clientKeys, ms.err
+}
+
+func (ms *MockStore) RevokeClientKey(ctx context.Context, id int) error {
+	if id < 1 || id > len(ms.clientKeys) || ms.clientKeys[id-1].RevokedAt != nil {
+		return data.ErrNotFound
+	}
+	now := time.Now()
+	ms.clientKeys[id-1].RevokedAt = &now
+	return nil
+}
+
+func (ms *MockStore) IsClientKeyValid(ctx context.Context, keyHash string) (bool, error) {
+	for id, hash := range ms.clientKeyHashes {
+		if hash == keyHash {
+			return ms.clientKeys[id-1].RevokedAt == nil, nil
+		}
+	}
+	return false, ms.err
+}
+
+func (ms *MockStore) IsBanned(ctx context.Context, posterHashes ...string) (bool, error) {
+	return ms.banned, nil
+}
+
+// ApplyBulkActions fails every action on category "fail".
+func (ms *MockStore) ApplyBulkActions(ctx context.Context, actions data.BulkActions) (*data.BulkResults, error) {
+	ms.bulkActions = &actions
+	postResults := func(posts []data.PostRef) []data.ActionResult {
+		results := make([]data.ActionResult, len(posts))
+		for i, post := range posts {
+			results[i] = data.ActionResult{OK: post.Cat != "fail"}
+		}
+		return results
+	}
+	bans := make([]data.ActionResult, len(actions.BanPosters))
+	for i := range bans {
+		bans[i].OK = true
+	}
+	return &data.BulkResults{
+		DeletePosts: postResults(actions.DeletePosts),
+		BanPosters:  bans,
+		LockThreads: postResults(actions.LockThreads),
+	}, ms.err
+}
+
+func (ms *MockStore) GetRules(ctx context.Context) ([]*data.Rule, error) {
+	return ms.rules, ms.err
+}
+
+func (ms *MockStore) WriteRule(ctx context.Context, rule *data.Rule) (int, error) {
+	ms.rules = append(ms.rules, rule)
+	return len(ms.rules), ms.err
+}
+
+// UpdateRule and RemoveRule only know about rule 1.
+func (ms *MockStore) UpdateRule(ctx context.Context, rule *data.Rule) error {
+	if rule.ID != 1 {
+		return data.ErrNotFound
+	}
+	ms.rules = append(ms.rules, rule)
+	return ms.err
+}
+
+func (ms *MockStore) RemoveRule(ctx context.Context, id int) error {
+	if id != 1 {
+		return data.ErrNotFound
+	}
+	return ms.err
+}
+
+func (ms *MockStore) CountPosterDeletions(ctx context.Context, categoryTag string, num int, since time.Time) (string, int, error) {
+	return "", 0, ms.err
+}
+
+func (ms *MockStore) BanPoster(ctx context.Context, posterHash string, reason string, banFor time.Duration) error {
+	return ms.err
+}
+
+func (ms *MockStore) QuarantinePost(ctx context.Context, categoryTag string, num int) error {
+	return ms.err
+}
+
+func (ms *MockStore) WriteRuleAction(ctx context.Context, action *data.RuleAction) error {
+	return ms.err
+}
+
+func (ms *MockStore) GetRuleActions(ctx context.Context, limit int) ([]*data.RuleAction, error) {
+	return make([]*data.RuleAction, 0), ms.err
+}
+
+// WriteNote fails notes on category "missing".
+func (ms *MockStore) WriteNote(ctx context.Context, note *data.Note) (int, error) {
+	if note.Cat == "missing" {
+		return 0, data.ErrNotFound
+	}
+	ms.notes = append(ms.notes, note)
+	return len(ms.notes), ms.err
+}
+
+func (ms *MockStore) RemoveNote(ctx context.Context, id int) error {
+	if id > len(ms.notes) {
+		return data.ErrNotFound
+	}
+	return ms.err
+}
+
+func (ms *MockStore) GetModLog(ctx context.Context, categoryTag string, limit int) ([]*data.ModLogEntry, error) {
+	return []*data.ModLogEntry{{Num: 2, Thread: 1, Action: data.ActionDelete, Rule: 3}}, ms.err
+}
+
+func (ms *MockStore) GetCategoryRules(ctx context.Context, categoryTag string) ([]string, error) {
+	return ms.categoryRules, ms.err
+}
+
+func (ms *MockStore) SetCategoryRules(ctx context.Context, categoryTag string, rules []string) error {
+	ms.categoryRules = rules
+	return ms.err
+}
+
+// MustAcceptRules is true while the category has rules no one's accepted.
+func (ms *MockStore) MustAcceptRules(ctx context.Context, categoryTag string, posterHashes ...string) (bool, error) {
+	return len(ms.categoryRules) > 0 && len(ms.acceptedRules) == 0, nil
+}
+
+func (ms *MockStore) AcceptRules(ctx context.Context, categoryTag string, posterHashes ...string) error {
+	ms.acceptedRules = append(ms.acceptedRules, posterHashes...)
+	return ms.err
+}
+
+func (ms *MockStore) GetPages(ctx context.Context) ([]*data.Page, error) {
+	pages := make([]*data.Page, 0, len(ms.pages))
+	for _, page := range ms.pages {
+		pages = append(pages, &data.Page{Slug: page.Slug, Title: page.Title})
+	}
+	return pages, ms.err
+}
+
+func (ms *MockStore) GetPage(ctx context.Context, slug string) (*data.Page, error) {
+	for _, page := range ms.pages {
+		if page.Slug == slug {
+			return page, ms.err
+		}
+	}
+	return nil, data.ErrNotFound
+}
+
+func (ms *MockStore) WritePage(ctx context.Context, page *data.Page) error {
+	ms.pages = append(ms.pages, page)
+	return ms.err
+}
+
+func (ms *MockStore) RemovePage(ctx context.Context, slug string) error {
+	for i, page := range ms.pages {
+		if page.Slug == slug {
+			ms.pages = append(ms.pages[:i], ms.pages[i+1:]...)
+			return ms.err
+		}
+	}
+	return data.ErrNotFound
+}
+
+func (ms *MockStore) GetBlockedDomains(ctx context.Context) ([]*data.BlockedDomain, error) {
+	return ms.blockedDomains, ms.err
+}
+
+func (ms *MockStore) BlockDomain(ctx context.Context, domain string, action data.DomainAction) (*data.BlockedDomain, error) {
+	for _, blocked := range ms.blockedDomains {
+		if blocked.Domain == domain {
+			blocked.Action = action
+			return blocked, ms.err
+		}
+	}
+	blocked := &data.BlockedDomain{Domain: domain, Action: action}
+	ms.blockedDomains = append(ms.blockedDomains, blocked)
+	return blocked, ms.err
+}
+
+func (ms *MockStore) UnblockDomain(ctx context.Context, domain string) error {
+	for i, blocked := range ms.blockedDomains {
+		if blocked.Domain == domain {
+			ms.blockedDomains = append(ms.blockedDomains[:i], ms.blockedDomains[i+1:]...)
+			return ms.err
+		}
+	}
+	return data.ErrNotFound
+}
+
+func (ms *MockStore) RecordBlockedLinks(ctx context.Context, domains ...string) error {
+	for _, blocked := range ms.blockedDomains {
+		for _, domain := range domains {
+			if blocked.Domain == domain {
+				blocked.Blocked++
+			}
+		}
+	}
+	return ms.err
+}
+
+func (ms *MockStore) WriteCanary(ctx context.Context, canary *data.Canary) error {
+	canary.ID = len(ms.canaries) + 1
+	ms.canaries = append(ms.canaries, canary)
+	return ms.err
+}
+
+func (ms *MockStore) GetCanary(ctx context.Context, categoryTag string, num int) (*data.Canary, error) {
+	for _, canary := range ms.canaries {
+		if canary.Cat == categoryTag && canary.Num == num {
+			return canary, ms.err
+		}
+	}
+	return nil, data.ErrNotFound
+}
+
+func (ms *MockStore) GetCanaries(ctx context.Context) ([]*data.Canary, error) {
+	return ms.canaries, ms.err
+}
+
+func (ms *MockStore) RecordCanaryHit(ctx context.Context, hit *data.CanaryHit) error {
+	for _, canary := range ms.canaries {
+		if canary.ID == hit.CanaryID {
+			canary.Hits++
+			hit.Cat, hit.Num, hit.At = canary.Cat, canary.Num, time.Now()
+			ms.canaryHits = append(ms.canaryHits, hit)
+			return ms.err
+		}
+	}
+	return data.ErrNotFound
+}
+
+func (ms *MockStore) GetCanaryHits(ctx context.Context, limit int) ([]*data.CanaryHit, error) {
+	return ms.canaryHits, ms.err
+}
+
+func (ms *MockStore) LogPIIAccess(ctx context.Context, access *data.PIIAccess) error {
+	access.ID, access.At = len(ms.piiAccesses)+1, time.Now()
+	ms.piiAccesses = append(ms.piiAccesses, access)
+	return nil
+}
+
+func (ms *MockStore) GetPIIAccesses(ctx context.Context, limit int) ([]*data.PIIAccess, error) {
+	return ms.piiAccesses, ms.err
+}
+
+func (ms *MockStore) WriteSession(ctx context.Context, session *data.Session) error {
+	session.ID, session.CreatedAt, session.LastSeenAt = len(ms.sessions)+1, time.Now(), time.Now()
+	ms.sessions = append(ms.sessions, session)
+	if ms.sessionTokens == nil {
+		ms.sessionTokens = make(map[string]*data.Session)
+	}
+	ms.sessionTokens[session.TokenHash] = session
+	return nil
+}
+
+// Sessions are checked on every logged in request, so failing them with ms.err would fail every handler test.
+func (ms *MockStore) TouchSession(ctx context.Context, tokenHash string) (*data.Session, error) {
+	if session, ok := ms.sessionTokens[tokenHash]; ok {
+		session.LastSeenAt = time.Now()
+		return session, nil
+	}
+	for _, session := range ms.sessions {
+		if session.TokenHash == tokenHash {
+			session.LastSeenAt = time.Now()
+			return session, nil
+		}
+	}
+	return nil, data.ErrNotFound
+}
+
+func (ms *MockStore) GetSessions(ctx context.Context, userID string) ([]*data.Session, error) {
+	sessions := make([]*data.Session, 0)
+	for _, session := range ms.sessions {
+		if session.UserID == userID && !session.Revoked {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, ms.err
+}
+
+func (ms *MockStore) WriteTOTP(ctx context.Context, userID string, secret string) error {
+	if totp, ok := ms.totp[userID]; ok && totp.Confirmed {
+		return data.ErrTOTPEnrolled
+	}
+	if ms.totp == nil {
+		ms.totp = make(map[string]*data.TOTP)
+	}
+	ms.totp[userID] = &data.TOTP{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+	return ms.err
+}
+
+func (ms *MockStore) GetTOTP(ctx context.Context, userID string) (*data.TOTP, error) {
+	totp, ok := ms.totp[userID]
+	if !ok {
+		return nil, data.ErrNotFound
+	}
+	copied := *totp
+	return &copied, ms.err
+}
+
+func (ms *MockStore) UseTOTP(ctx context.Context, userID string, step int64) error {
+	totp, ok := ms.totp[userID]
+	if !ok || totp.LastStep >= step {
+		return data.ErrTOTPReused
+	}
+	totp.LastStep, totp.Confirmed = step, true
+	return ms.err
+}
+
+func (ms *MockStore) RemoveTOTP(ctx context.Context, userID string) error {
+	if _, ok := ms.totp[userID]; !ok {
+		return data.ErrNotFound
+	}
+	delete(ms.totp, userID)
+	for _, session := range ms.sessions {
+		if session.UserID == userID {
+			session.VerifiedAt = nil
+		}
+	}
+	return ms.err
+}
+
+func (ms *MockStore) VerifySession(ctx context.Context, tokenHash string) error {
+	for _, session := range ms.sessions {
+		if session.TokenHash == tokenHash && !session.Revoked {
+			now := time.Now()
+			session.VerifiedAt = &now
+			return ms.err
+		}
+	}
+	return data.ErrNotFound
+}
+
+func (ms *MockStore) LockInactiveThreads(ctx context.Context) (int64, error) {
+	return 0, ms.err
+}
+
+func (ms *MockStore) GetArchivedCategoryView(ctx context.Context, categoryTag string, offset int, limit int) (*data.CatView, error) {
+	ms.archiveOffset = offset
+	return ms.getCategoryView, ms.err
+}
+
+func (ms *MockStore) GetArchivedThreadView(ctx context.Context, categoryTag string, threadNum int) (*data.ThreadView, error) {
+	if ms.err != nil {
+		return nil, ms.err
+	}
+	if ms.getThreadView == nil {
+		return nil, data.ErrNotFound
+	}
+	return ms.getThreadView, nil
+}
+
+func (ms *MockStore) QueueAnonymization(ctx context.Context, categoryTag string, before time.Time, queuedBy string) (*data.AnonymizeJob, error) {
+	if ms.err != nil {
+		return nil, ms.err
+	}
+	job := &data.AnonymizeJob{
+		ID: len(ms.anonymizeJobs) + 1, Cat: categoryTag, Before: before, QueuedBy: queuedBy, Status: data.JobQueued,
+		CreatedAt: time.Now(),
+	}
+	ms.anonymizeJobs = append(ms.anonymizeJobs, job)
+	return job, nil
 }
 
-func (ms *MockStore) GetThreadView(ctx context.Context, catName string, threadNum int) (*data.ThreadView, error) {
-	return ms.getThreadView, ms.err
+func (ms *MockStore) GetAnonymizeJobs(ctx context.Context, limit int) ([]*data.AnonymizeJob, error) {
+	jobs := make([]*data.AnonymizeJob, 0)
+	for i := len(ms.anonymizeJobs) - 1; i >= 0 && len(jobs) < limit; i-- {
+		jobs = append(jobs, ms.anonymizeJobs[i])
+	}
+	return jobs, ms.err
 }
 
-func (ms *MockStore) GetCategory(ctx context.Context, catName string) (*data.Category, error) {
-	return ms.getCategory, ms.err
+func (ms *MockStore) GetAnonymizeJob(ctx context.Context, id int) (*data.AnonymizeJob, error) {
+	for _, job := range ms.anonymizeJobs {
+		if job.ID == id {
+			return job, ms.err
+		}
+	}
+	return nil, data.ErrNotFound
 }
 
-func (ms *MockStore) GetCategoryView(ctx context.Context, catName string) (*data.CatView, error) {
-	return ms.getCategoryView, ms.err
+func (ms *MockStore) AnonymizeBatch(ctx context.Context, size int) (*data.AnonymizeJob, []*data.Post, error) {
+	return nil, nil, data.ErrNotFound
 }
 
-func (ms *MockStore) WritePost(ctx context.Context, catName string, parentThreadNumber int, subject string, content string, username string, email string, ip string) error {
-	return ms.err
+func (ms *MockStore) GetSession(ctx context.Context, userID string, id int) (*data.Session, error) {
+	for _, session := range ms.sessions {
+		if session.ID == id && session.UserID == userID {
+			return session, ms.err
+		}
+	}
+	return nil, data.ErrNotFound
 }
 
-func (ms *MockStore) RemovePost(ctx context.Context, categoryTag string, number int) (int, error) {
-	return 0, ms.err
+func (ms *MockStore) GetRefreshSession(ctx context.Context, refreshToken string) (*data.Session, error) {
+	for _, session := range ms.sessions {
+		if len(refreshToken) > 0 && session.RefreshToken == refreshToken {
+			return session, ms.err
+		}
+	}
+	return nil, data.ErrNotFound
 }
 
-func (ms *MockStore) EmailMatches(ctx context.Context, categoryTag string, postNumber int, email string) (bool, error) {
-	return true, ms.err
+func (ms *MockStore) RenewSession(ctx context.Context, id int, renewed *data.Session) error {
+	for _, session := range ms.sessions {
+		if session.ID == id && !session.Revoked {
+			session.TokenHash, session.ExpiresAt = renewed.TokenHash, renewed.ExpiresAt
+			if len(renewed.RefreshToken) > 0 {
+				session.RefreshToken = renewed.RefreshToken
+			}
+			ms.sessionTokens[renewed.TokenHash] = session
+			return ms.err
+		}
+	}
+	return data.ErrNotFound
 }
 
-func (ms *MockStore) GetPostsByEmail(ctx context.Context, email string) ([]*data.Post, error) {
-	var d []*data.Post
-	return d, ms.err
+func (ms *MockStore) RevokeSession(ctx context.Context, userID string, id int) error {
+	for _, session := range ms.sessions {
+		if session.ID == id && session.UserID == userID && !session.Revoked {
+			session.Revoked = true
+			return ms.err
+		}
+	}
+	return data.ErrNotFound
 }
 
 type MockAuth struct {
 	err  error
 	user *auth.UserData
+	// Account the last verification email was resent to.
+	resentTo string
+	// Refresh tokens revoked.
+	revokedRefresh []string
 }
 
 func (ma *MockAuth) RequestSignUp(
@@ -91,12 +1148,133 @@ func (ma *MockAuth) GetUserFromToken(
 	return ma.user, ma.err
 }
 
-func CreateTestServer(mockStore *MockStore, mockAuth *MockAuth) *Server {
-	return NewServer(mockStore, mockAuth, ServerOptions{
-		Address:             "0.0.0.0",
-		PostCooldownSeconds: 0,
-		CorsOriginAllow:     "",
-	})
+func (ma *MockAuth) GetUser(ctx context.Context, userID string) (*auth.UserData, error) {
+	if ma.err != nil {
+		return nil, ma.err
+	}
+	if userID != "auth0|target" {
+		return nil, auth.ErrUserNotFound
+	}
+	return &auth.UserData{ID: userID, Username: "target", Email: "target@gmail.com", IsVerified: true}, nil
+}
+
+func (ma *MockAuth) Login(ctx context.Context, username string, password string) (*auth.Tokens, error) {
+	if ma.err != nil {
+		return nil, ma.err
+	}
+	if password != "hunter2" {
+		return nil, auth.ErrInvalidCredentials
+	}
+	return &auth.Tokens{AccessToken: "access-" + username, RefreshToken: "refresh-" + username, TokenType: "Bearer"}, nil
+}
+
+func (ma *MockAuth) Refresh(ctx context.Context, refreshToken string) (*auth.Tokens, error) {
+	if ma.err != nil {
+		return nil, ma.err
+	}
+	for _, revoked := range ma.revokedRefresh {
+		if revoked == refreshToken {
+			return nil, auth.ErrInvalidCredentials
+		}
+	}
+	return &auth.Tokens{AccessToken: "refreshed-" + refreshToken, TokenType: "Bearer"}, nil
+}
+
+func (ma *MockAuth) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	ma.revokedRefresh = append(ma.revokedRefresh, refreshToken)
+	return ma.err
+}
+
+func (ma *MockAuth) AuthorizeURL(connection string, redirectURI string, state string) string {
+	return "https://auth.example.com/authorize?connection=" + connection + "&state=" + state
+}
+
+func (ma *MockAuth) ExchangeCode(ctx context.Context, code string, redirectURI string) (*auth.Tokens, error) {
+	if ma.err != nil {
+		return nil, ma.err
+	}
+	return &auth.Tokens{AccessToken: "access-" + code, TokenType: "Bearer"}, nil
+}
+
+func (ma *MockAuth) ResendVerification(ctx context.Context, userID string) error {
+	ma.resentTo = userID
+	return ma.err
+}
+
+type MockLimiter struct {
+	err     error
+	limited bool
+	// Cooldowns started, Peek doesn't start any.
+	consumed int
+}
+
+func (ml *MockLimiter) IsRateLimited(ctx context.Context, key string, cooldown time.Duration) (bool, error) {
+	ml.consumed++
+	return ml.limited, ml.err
+}
+
+func (ml *MockLimiter) Peek(ctx context.Context, key string, cooldown time.Duration) (bool, error) {
+	return ml.limited, ml.err
+}
+
+// CreateTestServer creates a server on the mocks with the given options, listening on any address unless they say otherwise.
+func CreateTestServer(mockStore *MockStore, mockAuth *MockAuth, opts ServerOptions) *Server {
+	if len(opts.Address) == 0 {
+		opts.Address = "0.0.0.0"
+	}
+	return NewServer(mockStore, mockAuth, opts)
+}
+
+// staffUser is a verified admin@gmail.com account with the staff roles.
+func staffUser(roles ...auth.Role) *auth.UserData {
+	return &auth.UserData{
+		ID:         "auth0|admin",
+		Username:   "admin",
+		Email:      "admin@gmail.com",
+		IsVerified: true,
+		Roles:      roles,
+	}
+}
+
+// testClient makes requests to a test server, sending its token as their Authorization header unless it's empty.
+type testClient struct {
+	server *Server
+	auth   *MockAuth
+	token  string
+}
+
+// newTestClient returns a client logged in as whoever mockAuth returns, which the server must have been made with.
+func newTestClient(server *Server, mockAuth *MockAuth) *testClient {
+	return &testClient{server: server, auth: mockAuth, token: "ok"}
+}
+
+// as logs the client in as the user, or out if it's nil, returning it for making requests as them.
+func (c *testClient) as(user *auth.UserData) *testClient {
+	c.auth.user = user
+	if user == nil {
+		return c.withToken("")
+	}
+	return c.withToken("ok")
+}
+
+// withToken returns a client sending the token instead, or none if it's empty.
+func (c *testClient) withToken(token string) *testClient {
+	return &testClient{server: c.server, auth: c.auth, token: token}
+}
+
+// do makes a request with the body, returning the recorded response.
+func (c *testClient) do(method string, route string, body string) *httptest.ResponseRecorder {
+	return c.send(httptest.NewRequest(method, route, strings.NewReader(body)))
+}
+
+// send makes the request with the client's token, for requests needing other headers.
+func (c *testClient) send(req *http.Request) *httptest.ResponseRecorder {
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", c.token)
+	}
+	rr := httptest.NewRecorder()
+	c.server.ServeHTTP(rr, req)
+	return rr
 }
 
 func TestHandleCORSPreflight(t *testing.T) {
@@ -109,15 +1287,15 @@ func TestHandleCORSPreflight(t *testing.T) {
 
 	for _, allowedOrigin := range tests {
 		rr := httptest.NewRecorder()
-		req, err := http.NewRequest("OPTIONS", "/", nil)
+		req, err := http.NewRequest("OPTIONS", "/v1/dm", nil)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		allowedMethods := "GET,POST,DELETE"
+		allowedMethods := "GET, OPTIONS, POST"
 
-		handler := handleCORSPreflight(allowedOrigin)
-		handler.ServeHTTP(rr, req)
+		server := CreateTestServer(&MockStore{}, &MockAuth{}, ServerOptions{CorsOriginAllow: allowedOrigin})
+		server.ServeHTTP(rr, req)
 		if rr.Code != http.StatusNoContent {
 			t.Errorf("expected preflight status %d, got: %d", http.StatusNoContent, rr.Code)
 		}
@@ -133,12 +1311,375 @@ func TestHandleCORSPreflight(t *testing.T) {
 		}
 
 		resAllowedHeaders := rr.Header().Get("Access-Control-Allow-Headers")
-		if resAllowedHeaders != "Content-Type,Authorization" {
+		if resAllowedHeaders != "Content-Type,Authorization,If-Match,X-Captcha-Token,X-Client-Key" {
 			t.Errorf("expected Content-Type header allowed in CORS response, got: %s", resAllowedHeaders)
 		}
 	}
 }
 
+func TestMethodNotAllowed(t *testing.T) {
+	server := CreateTestServer(&MockStore{}, &MockAuth{}, ServerOptions{CorsOriginAllow: "example.com"})
+
+	req, err := http.NewRequest("DELETE", "/v1/categories", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d, got: %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+	if allow := rr.Header().Get("Allow"); allow != "GET, OPTIONS" {
+		t.Errorf("expected Allow to list the route's methods, got: %s", allow)
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != "example.com" {
+		t.Error("expected CORS headers on 405s")
+	}
+
+	req, err = http.NewRequest("OPTIONS", "/v1/nothing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected preflight for a route that doesn't exist to 404, got: %d", rr.Code)
+	}
+}
+
+func TestPostRateLimit(t *testing.T) {
+	tests := map[string]struct {
+		limiter      *MockLimiter
+		expectedCode int
+	}{
+		"Not limited": {
+			limiter:      &MockLimiter{},
+			expectedCode: http.StatusOK,
+		},
+		"Limited": {
+			limiter:      &MockLimiter{limited: true},
+			expectedCode: http.StatusTooManyRequests,
+		},
+		"Failing closed": {
+			limiter:      &MockLimiter{limited: true, err: ratelimit.ErrUnavailable},
+			expectedCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockAuth := &MockAuth{
+				user: &auth.UserData{
+					Username:   "test user",
+					Email:      "test@gmail.com",
+					IsVerified: true,
+				},
+			}
+			server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+				PostCooldownSeconds: 30,
+				RateLimiter:         test.limiter,
+			})
+
+			req, err := http.NewRequest("POST", "/v1/categories/cat/1", bytes.NewReader([]byte(`{"Content": "hello!"}`)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Authorization", "ok")
+
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Errorf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimit(t *testing.T) {
+	mockAuth := &MockAuth{
+		user: &auth.UserData{
+			Username:   "test user",
+			Email:      "test@gmail.com",
+			IsVerified: true,
+		},
+	}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+		PostCooldownSeconds: 30,
+		RateLimiter:         ratelimit.NewMemory(ratelimit.MemoryOptions{Burst: 2}),
+	})
+
+	for i, expectedCode := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req, err := http.NewRequest("POST", "/v1/categories/cat/1", bytes.NewReader([]byte(`{"Content": "hello!"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Authorization", "ok")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != expectedCode {
+			t.Errorf("expected post %d to get %d, got: %d", i+1, expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestAccountRateLimit(t *testing.T) {
+	tests := map[string]struct {
+		postCooldown    int
+		accountCooldown int
+		remoteAddrs     []string
+		expectedCodes   []int
+	}{
+		"Account switching IPs": {
+			postCooldown:    30,
+			accountCooldown: 30,
+			remoteAddrs:     []string{"10.0.0.1:1000", "10.0.0.2:1000"},
+			expectedCodes:   []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		"Shared IP without its own cooldown": {
+			postCooldown:    0,
+			accountCooldown: 30,
+			remoteAddrs:     []string{"10.0.0.1:1000", "10.0.0.1:1000"},
+			expectedCodes:   []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		"Accounts not limited": {
+			postCooldown:    30,
+			accountCooldown: 0,
+			remoteAddrs:     []string{"10.0.0.1:1000", "10.0.0.2:1000"},
+			expectedCodes:   []int{http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockAuth := &MockAuth{
+				user: &auth.UserData{
+					ID:         "account",
+					Username:   "test user",
+					Email:      "test@gmail.com",
+					IsVerified: true,
+				},
+			}
+			server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+				PostCooldownSeconds:    test.postCooldown,
+				AccountCooldownSeconds: test.accountCooldown,
+				RateLimiter:            ratelimit.NewMemory(ratelimit.MemoryOptions{}),
+			})
+
+			for i, remoteAddr := range test.remoteAddrs {
+				req, err := http.NewRequest("POST", "/v1/categories/cat/1", bytes.NewReader([]byte(`{"Content": "hello!"}`)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.RemoteAddr = remoteAddr
+				req.Header.Add("Authorization", "ok")
+				rr := httptest.NewRecorder()
+				server.ServeHTTP(rr, req)
+				if rr.Code != test.expectedCodes[i] {
+					t.Errorf("expected post %d to get %d, got: %d", i+1, test.expectedCodes[i], rr.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestPostEvents(t *testing.T) {
+	bus := events.NewBus()
+	var created events.Event
+	bus.Subscribe(func(ctx context.Context, event events.Event) {
+		created = event
+	}, events.PostCreated)
+
+	mockAuth := &MockAuth{
+		user: &auth.UserData{
+			Username:   "test user",
+			Email:      "test@gmail.com",
+			IsVerified: true,
+		},
+	}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+		Events: bus,
+	})
+
+	req, err := http.NewRequest("POST", "/v1/categories/cat/5", bytes.NewReader([]byte(`{"Content": "hello!"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Authorization", "ok")
+
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	bus.Wait()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if created.Category != "cat" || created.Num != 1 || created.Parent != 5 {
+		t.Errorf("unexpected PostCreated event: %+v", created)
+	}
+	if created.Post == nil || created.Post.Content != "hello!" {
+		t.Errorf("expected post content on PostCreated event, got: %+v", created.Post)
+	}
+}
+
+func TestRemovePost(t *testing.T) {
+	author := &auth.UserData{
+		ID:         "auth0|author",
+		Username:   "author",
+		Email:      "author@gmail.com",
+		IsVerified: true,
+	}
+	other := &auth.UserData{
+		ID:         "auth0|other",
+		Username:   "other",
+		Email:      "other@gmail.com",
+		IsVerified: true,
+	}
+
+	tests := map[string]struct {
+		user         *auth.UserData
+		ownership    *data.PostOwnership
+		opDelete     bool
+		err          error
+		expectedCode int
+		// Whether it's deleted through moderation, logged and counted against the poster.
+		moderated bool
+	}{
+		"Author": {
+			user:         author,
+			ownership:    &data.PostOwnership{AuthorID: author.ID},
+			expectedCode: http.StatusOK,
+		},
+		"Not the author": {
+			user:         other,
+			ownership:    &data.PostOwnership{AuthorID: author.ID},
+			expectedCode: http.StatusForbidden,
+		},
+		"No author": {
+			user:         other,
+			ownership:    &data.PostOwnership{},
+			expectedCode: http.StatusForbidden,
+		},
+		"Moderator": {
+			user:         moderator("mod@gmail.com"),
+			ownership:    &data.PostOwnership{AuthorID: author.ID},
+			expectedCode: http.StatusOK,
+			moderated:    true,
+		},
+		"Moderator without the moderate scope": {
+			user:         withScopes(moderator("mod@gmail.com"), auth.ScopeRead, auth.ScopePost),
+			ownership:    &data.PostOwnership{AuthorID: author.ID},
+			expectedCode: http.StatusForbidden,
+		},
+		"Thread author": {
+			user:         other,
+			ownership:    &data.PostOwnership{AuthorID: author.ID, ThreadAuthorID: other.ID},
+			opDelete:     true,
+			expectedCode: http.StatusOK,
+		},
+		"Thread author, disabled": {
+			user:         other,
+			ownership:    &data.PostOwnership{AuthorID: author.ID, ThreadAuthorID: other.ID},
+			expectedCode: http.StatusForbidden,
+		},
+		"No such post": {
+			user:         author,
+			err:          data.ErrNotFound,
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockStore := &MockStore{postOwnership: test.ownership, err: test.err}
+			server := CreateTestServer(mockStore, &MockAuth{user: test.user}, ServerOptions{OPDeleteReplies: test.opDelete})
+
+			req, err := http.NewRequest("DELETE", "/v1/categories/cat/3", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Authorization", "ok")
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Errorf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if moderated := len(mockStore.resolved) == 1 && mockStore.resolved[0].Action == data.ActionDelete; moderated != test.moderated {
+				t.Errorf("expected deleted through moderation %t, got: %+v", test.moderated, mockStore.resolved)
+			}
+		})
+	}
+}
+
+type MockSearch struct {
+	err   error
+	posts []*data.Post
+}
+
+func (msb *MockSearch) EnsureIndex(ctx context.Context) error { return nil }
+
+func (msb *MockSearch) Index(ctx context.Context, post *data.Post) error { return nil }
+
+func (msb *MockSearch) Remove(ctx context.Context, categoryTag string, num int) error { return nil }
+
+func (msb *MockSearch) Search(ctx context.Context, query string, categoryTag string, limit int) ([]*data.Post, error) {
+	return msb.posts, msb.err
+}
+
+func TestSearchFallback(t *testing.T) {
+	tests := map[string]struct {
+		backend        *MockSearch
+		query          string
+		expectedThread int
+	}{
+		"Backend": {
+			backend:        &MockSearch{posts: []*data.Post{{Num: 5, Cat: "cat"}}},
+			expectedThread: 5,
+		},
+		"Backend failure": {
+			backend:        &MockSearch{err: errors.New("down")},
+			expectedThread: 1,
+		},
+		"Unanswered": {
+			backend:        &MockSearch{posts: []*data.Post{{Num: 5, Cat: "cat"}}},
+			query:          "&unanswered=true",
+			expectedThread: 1,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockStore := &MockStore{searchPosts: []*data.Post{{Num: 2, Cat: "cat", Parent: 1}}}
+			server := CreateTestServer(mockStore, &MockAuth{}, ServerOptions{
+				Search: test.backend,
+			})
+
+			req, err := http.NewRequest("GET", "/v1/search?q=hello"+test.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+			}
+
+			var results []searchResult
+			if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 || results[0].Thread != test.expectedThread {
+				t.Errorf("expected a result in thread %d, got %+v", test.expectedThread, results)
+			}
+			if mockStore.unanswered != (len(test.query) > 0) {
+				t.Errorf("expected unanswered filter passed to the store")
+			}
+		})
+	}
+}
+
 type RouteMockTest struct {
 	route        string
 	setup        func(*MockStore, *MockAuth, *http.Request)
@@ -190,6 +1731,115 @@ func TestRoutes(t *testing.T) {
 			"Thread View (valid)": {
 				expectedCode: http.StatusOK,
 				route:        "/v1/categories/something/1",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					ms.getThreadView = &data.ThreadView{
+						Category: &data.Category{Tag: "something"},
+						Posts:    []*data.Post{{Num: 1}},
+					}
+				},
+			},
+			"Search (no query)": {
+				expectedCode: http.StatusBadRequest,
+				route:        "/v1/search",
+			},
+			"Retained posts (not logged in)": {
+				expectedCode: http.StatusUnauthorized,
+				route:        "/v1/admin/retention/cat/1",
+			},
+			"Retained posts (no role)": {
+				expectedCode: http.StatusForbidden,
+				route:        "/v1/admin/retention/cat/1",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					r.Header.Add("Authorization", "ok")
+					ma.user = staffUser(auth.RoleAdmin)
+				},
+			},
+			"Retained posts (no reason)": {
+				expectedCode: http.StatusBadRequest,
+				route:        "/v1/admin/retention/cat/1",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					r.Header.Add("Authorization", "ok")
+					ma.user = &auth.UserData{
+						Username:   "legal",
+						Email:      "legal@gmail.com",
+						IsVerified: true,
+						Roles:      []auth.Role{auth.RoleRetention},
+					}
+				},
+			},
+			"Retained posts (disabled)": {
+				expectedCode: http.StatusNotFound,
+				route:        "/v1/admin/retention/cat/1?reason=court+order",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					r.Header.Add("Authorization", "ok")
+					ma.user = &auth.UserData{
+						Username:   "legal",
+						Email:      "legal@gmail.com",
+						IsVerified: true,
+						Roles:      []auth.Role{auth.RoleRetention},
+					}
+					ms.err = data.ErrRetentionDisabled
+				},
+			},
+			"Retained posts (valid)": {
+				expectedCode: http.StatusOK,
+				route:        "/v1/admin/retention/cat/1?reason=court+order",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					r.Header.Add("Authorization", "ok")
+					ma.user = &auth.UserData{
+						Username:   "legal",
+						Email:      "legal@gmail.com",
+						IsVerified: true,
+						Roles:      []auth.Role{auth.RoleRetention},
+					}
+					ms.getRetainedPosts = []*data.RetainedPost{{Num: 1, Cat: "cat"}}
+				},
+			},
+			"Poster history (no role)": {
+				expectedCode: http.StatusForbidden,
+				route:        "/v1/admin/posters/" + data.PosterHash("127.0.0.1") + "/posts",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					r.Header.Add("Authorization", "ok")
+					ma.user = &auth.UserData{
+						Username:   "user",
+						Email:      "user@gmail.com",
+						IsVerified: true,
+					}
+				},
+			},
+			"Poster history (bad ID)": {
+				expectedCode: http.StatusBadRequest,
+				route:        "/v1/admin/posters/127.0.0.1/posts",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					r.Header.Add("Authorization", "ok")
+					ma.user = &auth.UserData{
+						Username:   "mod",
+						Email:      "mod@gmail.com",
+						IsVerified: true,
+						Roles:      []auth.Role{auth.RoleModerator},
+					}
+				},
+			},
+			"Poster history (valid)": {
+				expectedCode: http.StatusOK,
+				route:        "/v1/admin/posters/" + data.PosterHash("127.0.0.1") + "/posts",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					r.Header.Add("Authorization", "ok")
+					ma.user = &auth.UserData{
+						Username:   "mod",
+						Email:      "mod@gmail.com",
+						IsVerified: true,
+						Roles:      []auth.Role{auth.RoleModerator},
+					}
+					ms.posterHistory = &data.PosterHistory{Posts: []*data.PosterPost{{Num: 1, Cat: "cat"}}, Bans: 1}
+				},
+			},
+			"Search (valid)": {
+				expectedCode: http.StatusOK,
+				route:        "/v1/search?q=hello&cat=beep",
+				setup: func(ms *MockStore, ma *MockAuth, r *http.Request) {
+					ms.searchPosts = []*data.Post{{Num: 2, Cat: "beep", Parent: 1}}
+				},
 			},
 		},
 		"POST": {
@@ -282,7 +1932,7 @@ func TestRoutes(t *testing.T) {
 					test.setup(mockStore, mockAuth, req)
 				}
 
-				server := CreateTestServer(mockStore, mockAuth)
+				server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
 
 				rr := httptest.NewRecorder()
 
@@ -294,3 +1944,80 @@ func TestRoutes(t *testing.T) {
 		}
 	}
 }
+
+func TestBranding(t *testing.T) {
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(&MockStore{err: errors.New("down")}, mockAuth, ServerOptions{
+		Branding: Branding{
+			Name:         "spirit",
+			Description:  "a board",
+			ContactEmail: "admin@spirit.chat",
+		},
+	})
+	client := newTestClient(server, mockAuth).as(nil)
+
+	var config ConfigResponse
+	if err := json.NewDecoder(client.do("GET", "/v1/config", "").Body).Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "spirit" || config.Description != "a board" || config.ContactEmail != "admin@spirit.chat" {
+		t.Errorf("expected branding in config, got %+v", config)
+	}
+	if config.Features.MaxContentLength == 0 || config.Features.CooldownSeconds != 0 || config.Features.Captcha {
+		t.Errorf("expected default features, got %+v", config.Features)
+	}
+	if config.Features.Search || config.Features.AnonymousPosting || config.Features.Websockets {
+		t.Errorf("expected no search backend, anonymous posting or websockets, got %+v", config.Features)
+	}
+
+	rr := client.do("GET", "/nothing-here", "")
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "spirit") {
+		t.Errorf("expected branded 404, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = client.do("GET", "/v1/categories", "")
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "admin@spirit.chat") {
+		t.Errorf("expected contact email in error, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSocialLogin(t *testing.T) {
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(&MockStore{}, mockAuth, ServerOptions{
+		Social: SocialOptions{
+			Providers:   map[string]string{"google": "google-oauth2", "github": "github"},
+			RedirectURI: "https://example.com/login",
+		},
+	})
+	client := newTestClient(server, mockAuth).as(nil)
+
+	rr := client.do("GET", "/v1/auth/social", "")
+	var providers []string
+	if err := json.NewDecoder(rr.Body).Decode(&providers); err != nil || len(providers) != 2 || providers[0] != "github" {
+		t.Errorf("expected sorted providers, got %v %v", providers, err)
+	}
+
+	rr = client.do("GET", "/v1/auth/social/google", "")
+	var authorize socialAuthorize
+	if err := json.NewDecoder(rr.Body).Decode(&authorize); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected authorize URL, got: %d %v", rr.Code, err)
+	}
+	if len(authorize.State) != 32 || !strings.Contains(authorize.URL, "connection=google-oauth2") || !strings.Contains(authorize.URL, authorize.State) {
+		t.Errorf("expected URL to the connection with the state, got %+v", authorize)
+	}
+	if rr := client.do("GET", "/v1/auth/social/myspace", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected unknown provider to 404, got: %d", rr.Code)
+	}
+
+	rr = client.do("POST", "/v1/auth/social/callback", `{"code": "abc"}`)
+	var tokens auth.Tokens
+	if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil || tokens.AccessToken != "access-abc" {
+		t.Errorf("expected code exchanged for tokens, got %+v %v", tokens, err)
+	}
+	if rr := client.do("POST", "/v1/auth/social/callback", `{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected missing code to be refused, got: %d", rr.Code)
+	}
+	mockAuth.err = errors.New("invalid grant")
+	if rr := client.do("POST", "/v1/auth/social/callback", `{"code": "abc"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected failed exchange to be unauthorized, got: %d", rr.Code)
+	}
+}
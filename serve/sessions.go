@@ -0,0 +1,166 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"strconv"
+	"time"
+)
+
+// Used for tokens that don't say when they expire.
+const defaultSessionLifetime = time.Hour * 24
+
+var errSessionRevoked = errors.New("this session was logged out, log in again")
+var errRefreshUnknown = errors.New("that refresh token wasn't issued by logging in here, log in again")
+var errRefreshExpired = errors.New("that refresh token has expired, log in again")
+
+// sessionExpiry returns when the tokens' access token expires.
+func sessionExpiry(tokens *auth.Tokens) time.Time {
+	lifetime := time.Duration(tokens.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultSessionLifetime
+	}
+	return time.Now().Add(lifetime)
+}
+
+// recordSession records the tokens a login was issued as a session on the device that logged in.
+func (server *Server) recordSession(ctx context.Context, req *request, tokens *auth.Tokens) {
+	user, err := server.auth.GetUserFromToken(ctx, tokens.AccessToken)
+	if err != nil || user == nil {
+		log.Printf("Failed to look up user for session: %v", err)
+		return
+	}
+	err = server.store.WriteSession(ctx, &data.Session{
+		UserID:       user.ID,
+		TokenHash:    data.TokenHash(tokens.AccessToken),
+		RefreshToken: tokens.RefreshToken,
+		UserAgent:    req.header.Get("User-Agent"),
+		IPHash:       data.PosterHash(req.ip),
+		ExpiresAt:    sessionExpiry(tokens),
+	})
+	if err != nil {
+		log.Printf("Failed to record session: %s", err)
+	}
+}
+
+/*
+handleRefreshLogin handles a POST request exchanging a session's refresh token for a new access token, which is
+recorded on the session so revoking it refuses the new token too. Refresh tokens not issued to a session are refused.
+*/
+func (server *Server) handleRefreshLogin(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingRefresh(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	session, err := server.store.GetRefreshSession(ctx, incoming.RefreshToken)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusUnauthorized, nil, errRefreshUnknown.Error())
+			return
+		}
+		res.Fail("Failed to get session", err)
+		return
+	}
+	if session.Revoked {
+		res.Respond(http.StatusUnauthorized, nil, errSessionRevoked.Error())
+		return
+	}
+
+	tokens, err := server.auth.Refresh(ctx, incoming.RefreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			res.Respond(http.StatusUnauthorized, nil, errRefreshExpired.Error())
+			return
+		}
+		res.Fail("Failed to refresh tokens", err)
+		return
+	}
+	err = server.store.RenewSession(ctx, session.ID, &data.Session{
+		TokenHash:    data.TokenHash(tokens.AccessToken),
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    sessionExpiry(tokens),
+	})
+	if err != nil {
+		// Revoked since it was read, the new token's never handed out.
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusUnauthorized, nil, errSessionRevoked.Error())
+			return
+		}
+		res.Fail("Failed to renew session", err)
+		return
+	}
+	res.Respond(http.StatusOK, tokens, "")
+}
+
+/*
+loginSession returns the session a token was issued for, marking it seen. Tokens that weren't issued
+by logging in here, like third-party clients', have no session and nil is returned, as it is for
+tokens that couldn't be checked, with Auth0 still checking the token itself.
+*/
+func (server *Server) loginSession(ctx context.Context, token string) *data.Session {
+	session, err := server.store.TouchSession(ctx, data.TokenHash(token))
+	if err != nil {
+		if !errors.Is(err, data.ErrNotFound) {
+			log.Printf("Failed to check session: %s", err)
+		}
+		return nil
+	}
+	return session
+}
+
+// handleGetSessions handles a GET request for the user's logged in sessions, marking the one making the request.
+func (server *Server) handleGetSessions(ctx context.Context, req *request, res *response) {
+	sessions, err := server.store.GetSessions(ctx, req.user.ID)
+	if err != nil {
+		res.Fail("Failed to get sessions", err)
+		return
+	}
+	for _, session := range sessions {
+		session.Current = req.session != nil && session.ID == req.session.ID
+	}
+	res.Respond(http.StatusOK, sessions, "")
+}
+
+/*
+handleRevokeSession handles a DELETE request logging out one of the user's sessions, like one on a stolen device.
+Its refresh token is revoked at Auth0 first, so the device can't get new access tokens around the session.
+*/
+func (server *Server) handleRevokeSession(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid session ID")
+		return
+	}
+	session, err := server.store.GetSession(ctx, req.user.ID, id)
+	if err != nil || session.Revoked {
+		if err == nil || errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such session")
+			return
+		}
+		res.Fail("Failed to get session", err)
+		return
+	}
+	if len(session.RefreshToken) > 0 {
+		err = server.auth.RevokeRefreshToken(ctx, session.RefreshToken)
+		if err != nil {
+			res.Fail("Failed to revoke refresh token", err)
+			return
+		}
+	}
+	err = server.store.RevokeSession(ctx, req.user.ID, id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such session")
+			return
+		}
+		res.Fail("Failed to revoke session", err)
+		return
+	}
+	log.Printf("Session %d revoked by %s", id, req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "revoked"}, "")
+}
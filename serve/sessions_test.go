@@ -0,0 +1,120 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: &auth.UserData{ID: "auth0|bob", Username: "bob", Email: "bob@gmail.com", IsVerified: true}}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	body, _ := json.Marshal(incomingLogin{Username: "bob", Password: "hunter2"})
+	req := httptest.NewRequest("POST", "/v1/login", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "Firefox")
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	rr := client.withToken("").send(req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got: %d", rr.Code)
+	}
+	// Logged in on a device since stolen, and on someone else's account.
+	mockStore.sessions = append(mockStore.sessions,
+		&data.Session{
+			ID: 2, UserID: "auth0|bob", TokenHash: data.PosterHash("stolen"), RefreshToken: "refresh-stolen", UserAgent: "curl",
+			ExpiresAt: time.Now().Add(time.Hour),
+		},
+		&data.Session{ID: 3, UserID: "auth0|alice", TokenHash: data.PosterHash("alice"), ExpiresAt: time.Now().Add(time.Hour)},
+	)
+
+	rr = client.withToken("access-bob").do("GET", "/v1/me/sessions", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	var sessions []*data.Session
+	if err := json.NewDecoder(rr.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || !sessions[0].Current || sessions[0].UserAgent != "Firefox" ||
+		sessions[0].IPHash != data.PosterHash("10.0.0.1") || sessions[1].Current {
+		t.Errorf("expected the login's session marked current beside the other device, got %+v", sessions)
+	}
+
+	if rr := client.withToken("access-bob").do("DELETE", "/v1/me/sessions/3", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected someone else's session to 404, got: %d", rr.Code)
+	}
+	if rr := client.withToken("access-bob").do("DELETE", "/v1/me/sessions/2", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected the session revoked, got: %d", rr.Code)
+	}
+	if rr := client.withToken("access-bob").do("DELETE", "/v1/me/sessions/2", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected revoking twice to 404, got: %d", rr.Code)
+	}
+	if rr := client.withToken("stolen").do("GET", "/v1/me", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the revoked session's token refused, got: %d", rr.Code)
+	}
+	if len(mockAuth.revokedRefresh) != 1 || mockAuth.revokedRefresh[0] != "refresh-stolen" {
+		t.Errorf("expected the revoked session's refresh token revoked at Auth0, got %v", mockAuth.revokedRefresh)
+	}
+	if rr := client.withToken("access-bob").do("GET", "/v1/me", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected other sessions kept, got: %d", rr.Code)
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: &auth.UserData{ID: "auth0|bob", Username: "bob", Email: "bob@gmail.com", IsVerified: true}}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(incomingRefresh{RefreshToken: refreshToken})
+		return client.withToken("").do("POST", "/v1/login/refresh", string(body))
+	}
+
+	body, _ := json.Marshal(incomingLogin{Username: "bob", Password: "hunter2"})
+	rr := client.withToken("").do("POST", "/v1/login", string(body))
+	if rr.Code != http.StatusOK || len(mockStore.sessions) != 1 || mockStore.sessions[0].RefreshToken != "refresh-bob" {
+		t.Fatalf("expected the login's refresh token kept on its session, got: %d %+v", rr.Code, mockStore.sessions)
+	}
+
+	if rr := refresh("refresh-someone"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a refresh token no session was issued refused, got: %d", rr.Code)
+	}
+	rr = refresh("refresh-bob")
+	var tokens auth.Tokens
+	if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected the session refreshed, got: %d %v", rr.Code, err)
+	}
+	rr = client.withToken(tokens.AccessToken).do("GET", "/v1/me/sessions", "")
+	var sessions []*data.Session
+	if err := json.NewDecoder(rr.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("expected the refreshed token tracked on the same session, got %+v", sessions)
+	}
+
+	// Revoked from another device, both the refreshed and original tokens go with it.
+	if rr := client.withToken("access-bob").do("DELETE", "/v1/me/sessions/1", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected the session revoked, got: %d", rr.Code)
+	}
+	for _, token := range []string{"access-bob", tokens.AccessToken} {
+		if rr := client.withToken(token).do("GET", "/v1/me", ""); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected %s refused once its session's revoked, got: %d", token, rr.Code)
+		}
+	}
+	if rr := refresh("refresh-bob"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the revoked session's refresh token refused, got: %d", rr.Code)
+	}
+	if len(mockAuth.revokedRefresh) != 1 || mockAuth.revokedRefresh[0] != "refresh-bob" {
+		t.Errorf("expected the refresh token revoked at Auth0, got %v", mockAuth.revokedRefresh)
+	}
+}
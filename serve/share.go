@@ -0,0 +1,75 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"spiritchat/data"
+	"strings"
+)
+
+// shareLink is a short link made for sharing a thread.
+type shareLink struct {
+	Token string `json:"token"`
+	Path  string `json:"path"`
+}
+
+// handleWriteShareLink handles a POST request for a short link to a thread, optionally scrolled to a post in it.
+func (server *Server) handleWriteShareLink(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingShareLink(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	// Links are made by slug, but stored by tag so they outlive renames.
+	tag, err := server.categoryTag(ctx, incoming.Cat)
+	if err != nil {
+		res.Fail("Failed to resolve category slug", err)
+		return
+	}
+
+	token, err := server.store.WriteShareLink(ctx, tag, incoming.Thread, incoming.Post)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such thread")
+			return
+		}
+		res.Fail("Failed to write share link", err)
+		return
+	}
+	res.Respond(http.StatusOK, shareLink{Token: token, Path: "/s/" + token}, "")
+}
+
+/*
+handleFollowShareLink handles a GET request for a short link, counting the click and redirecting to the thread
+on the board's site under its category's current slug. Redirects to the API's thread view without a site URL.
+*/
+func (server *Server) handleFollowShareLink(ctx context.Context, req *request, res *response) {
+	link, err := server.store.FollowShareLink(ctx, req.params.ByName("token"))
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such link")
+			return
+		}
+		res.Fail("Failed to follow share link", err)
+		return
+	}
+
+	location := fmt.Sprintf("/v1/categories/%s/%d", link.Slug, link.Thread)
+	if len(server.branding.URL) > 0 {
+		location = fmt.Sprintf("%s/%s/%d", strings.TrimSuffix(server.branding.URL, "/"), link.Slug, link.Thread)
+	}
+	if link.Post > 0 && link.Post != link.Thread {
+		location += fmt.Sprintf("#%d", link.Post)
+	}
+	// Not permanent, so browsers come back and every click is counted.
+	res.rw.Header().Set("Location", location)
+	res.Respond(http.StatusFound, nil, "")
+}
@@ -0,0 +1,43 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestShareLinks(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{
+		Branding: Branding{URL: "https://example.com/"},
+	})
+
+	client := newTestClient(server, mockAuth).as(nil)
+
+	if rr := client.do("POST", "/v1/share", `{"cat": "cat"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected link without a thread to be rejected, got: %d", rr.Code)
+	}
+	rr := client.do("POST", "/v1/share", `{"cat": "cat", "thread": 4, "post": 6}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected link to be made, got: %d", rr.Code)
+	}
+	var link shareLink
+	if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+	if link.Path != "/s/abc" {
+		t.Errorf("expected /s/abc, got: %s", link.Path)
+	}
+
+	rr = client.do("GET", link.Path, "")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://example.com/cat/4#6" {
+		t.Errorf("expected redirect to the post, got: %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if mockStore.shareLink.Clicks != 1 {
+		t.Errorf("expected click to be counted, got: %d", mockStore.shareLink.Clicks)
+	}
+	if rr := client.do("GET", "/s/nope", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected unknown link to 404, got: %d", rr.Code)
+	}
+}
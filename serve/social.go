@@ -0,0 +1,77 @@
+package serve
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// SocialOptions configure logging in through Auth0 social connections.
+type SocialOptions struct {
+	// Providers users can log in with by name, like "github", mapped to their Auth0 connection.
+	Providers map[string]string
+	// Where Auth0 sends users back to with their authorization code, it must be an allowed callback URL.
+	RedirectURI string
+}
+
+// socialAuthorize is where to send a user to log in, and the state to check they come back with.
+type socialAuthorize struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+}
+
+// handleGetSocialProviders handles a GET request for the social providers users can log in with.
+func (server *Server) handleGetSocialProviders(ctx context.Context, req *request, res *response) {
+	providers := make([]string, 0, len(server.social.Providers))
+	for name := range server.social.Providers {
+		providers = append(providers, name)
+	}
+	sort.Strings(providers)
+	res.Respond(http.StatusOK, providers, "")
+}
+
+/*
+handleSocialAuthorize handles a GET request for where to send a user to log in with a social provider.
+Clients keep the returned state and check the login comes back with it before exchanging the code.
+*/
+func (server *Server) handleSocialAuthorize(ctx context.Context, req *request, res *response) {
+	connection, ok := server.social.Providers[req.params.ByName("provider")]
+	if !ok {
+		res.Respond(http.StatusNotFound, nil, "no such login provider")
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		res.Fail("Failed to generate login state", err)
+		return
+	}
+	state := hex.EncodeToString(b)
+	res.Respond(http.StatusOK, socialAuthorize{
+		URL:   server.auth.AuthorizeURL(connection, server.social.RedirectURI, state),
+		State: state,
+	}, "")
+}
+
+// handleSocialCallback handles a POST request exchanging a social login's authorization code for the user's tokens.
+func (server *Server) handleSocialCallback(ctx context.Context, req *request, res *response) {
+	if len(server.social.Providers) == 0 {
+		res.Respond(http.StatusNotFound, nil, "social login isn't enabled")
+		return
+	}
+	callback, err := getIncomingSocialCallback(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	tokens, err := server.auth.ExchangeCode(ctx, callback.Code, server.social.RedirectURI)
+	if err != nil {
+		res.Respond(http.StatusUnauthorized, nil, "failed to log in")
+		log.Printf("Failed to exchange social login code: %s", err)
+		return
+	}
+	server.recordSession(ctx, req, tokens)
+	res.Respond(http.StatusOK, tokens, "")
+}
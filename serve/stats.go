@@ -0,0 +1,77 @@
+package serve
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"spiritchat/counters"
+	"spiritchat/data"
+	"sync"
+	"time"
+)
+
+// How long stats are served from memory before they're counted again.
+const statsTTL = time.Minute
+
+// statsCache holds the last stats counted, so polling status pages don't each count every post.
+type statsCache struct {
+	mut     sync.Mutex
+	stats   *data.Stats
+	expires time.Time
+}
+
+/*
+get returns the cached stats, counting them again with the store if they've expired.
+Threads are totalled from the counter, if every category's been counted.
+*/
+func (sc *statsCache) get(ctx context.Context, store data.Store, counter counters.Counter) (*data.Stats, error) {
+	sc.mut.Lock()
+	defer sc.mut.Unlock()
+	if sc.stats != nil && time.Now().Before(sc.expires) {
+		return sc.stats, nil
+	}
+	stats, err := store.GetStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats.Threads = countAllThreads(ctx, store, counter)
+	sc.stats, sc.expires = stats, time.Now().Add(statsTTL)
+	return stats, nil
+}
+
+// countAllThreads totals every category's threads from the counter, or returns zero if any aren't counted.
+func countAllThreads(ctx context.Context, store data.Store, counter counters.Counter) int {
+	categories, err := store.GetCategories(ctx)
+	if err != nil {
+		log.Printf("Failed to get categories to count threads: %v", err)
+		return 0
+	}
+	tags := make([]string, len(categories))
+	for i, category := range categories {
+		tags[i] = category.Tag
+	}
+	threads, err := counter.Threads(ctx, tags)
+	if err != nil {
+		log.Printf("Failed to get thread counts: %v", err)
+		return 0
+	}
+	if len(threads) < len(tags) {
+		return 0
+	}
+	var total int
+	for _, count := range threads {
+		total += count
+	}
+	return total
+}
+
+// handleGetStats handles a GET request for the instance's public usage numbers.
+func (server *Server) handleGetStats(ctx context.Context, req *request, res *response) {
+	stats, err := server.stats.get(ctx, server.store, server.counter)
+	if err != nil {
+		res.Fail("Failed to get stats", err)
+		return
+	}
+	res.rw.Header().Set("Cache-Control", "public, max-age=60")
+	res.Respond(http.StatusOK, stats, "")
+}
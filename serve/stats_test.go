@@ -0,0 +1,39 @@
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	mockStore := &MockStore{err: errors.New("down")}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth).as(nil)
+
+	if rr := client.do("GET", "/v1/stats", ""); rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected store failure to 500, got: %d", rr.Code)
+	}
+	mockStore.err = nil
+
+	rr := client.do("GET", "/v1/stats", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got: %d", rr.Code)
+	}
+	var stats data.Stats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Posts != 10 || stats.PostsToday != 2 || stats.ActiveThreads != 1 || stats.Categories != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	client.do("GET", "/v1/stats", "")
+	if mockStore.statsCounted != 1 {
+		t.Errorf("expected stats to be cached, counted %d times", mockStore.statsCounted)
+	}
+}
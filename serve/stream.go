@@ -0,0 +1,114 @@
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"spiritchat/data"
+	"strconv"
+)
+
+// Most posts written in a thread view, threads longer than this are cut off with "more" set.
+const maxThreadViewPosts = 2000
+
+var errThreadPageNumber = errors.New("offset, limit and last must be numbers from 0")
+var errThreadPageBoth = errors.New("offset and last can't be used together")
+
+/*
+getThreadPage reads which replies of a thread to view from the query, offset and limit counting replies after
+the OP, or last for only the last of them. No more than maxThreadViewPosts are ever written.
+*/
+func getThreadPage(query url.Values) (data.ThreadPage, error) {
+	var page data.ThreadPage
+	var limit int
+	for name, into := range map[string]*int{"offset": &page.Offset, "limit": &limit, "last": &page.Last} {
+		raw := query.Get(name)
+		if len(raw) == 0 {
+			continue
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return page, errThreadPageNumber
+		}
+		*into = parsed
+	}
+	if page.Offset > 0 && page.Last > 0 {
+		return page, errThreadPageBoth
+	}
+	page.Limit = maxThreadViewPosts
+	if limit > 0 && limit < maxThreadViewPosts {
+		page.Limit = limit + 1
+	}
+	return page, nil
+}
+
+/*
+threadStream writes a thread view to the response as the store reads it, in the same shape as data.ThreadView,
+flushing after each batch of posts so big threads don't have to be held in memory.
+*/
+type threadStream struct {
+	rw      http.ResponseWriter
+	enc     *json.Encoder
+	started bool
+	posts   int
+}
+
+func newThreadStream(rw http.ResponseWriter) *threadStream {
+	return &threadStream{rw: rw, enc: json.NewEncoder(rw)}
+}
+
+func (ts *threadStream) WriteCategory(category *data.Category) error {
+	ts.rw.Header().Set("content-type", "application/json")
+	ts.rw.WriteHeader(http.StatusOK)
+	ts.started = true
+	_, err := io.WriteString(ts.rw, `{"category":`)
+	if err != nil {
+		return err
+	}
+	err = ts.enc.Encode(category)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(ts.rw, `,"posts":[`)
+	return err
+}
+
+func (ts *threadStream) WritePosts(posts []*data.Post) error {
+	for _, post := range posts {
+		if ts.posts > 0 {
+			_, err := io.WriteString(ts.rw, ",")
+			if err != nil {
+				return err
+			}
+		}
+		err := ts.enc.Encode(post)
+		if err != nil {
+			return err
+		}
+		ts.posts++
+	}
+	if flusher, ok := ts.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+/*
+finish closes the view, telling clients whether the thread had more posts than were written,
+and how many replies it has if they're known.
+*/
+func (ts *threadStream) finish(more bool, replies int) error {
+	end := "]}\n"
+	if more && replies >= 0 {
+		end = fmt.Sprintf(`],"more":true,"replies":%d}`, replies) + "\n"
+	} else if more {
+		end = `],"more":true}` + "\n"
+	} else if replies >= 0 {
+		end = fmt.Sprintf(`],"replies":%d}`, replies) + "\n"
+	}
+	_, err := io.WriteString(ts.rw, end)
+	return err
+}
@@ -0,0 +1,86 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+)
+
+/*
+requireThreadAuthor responds with an error and returns false unless the user wrote the
+thread, or is a moderator.
+*/
+func (server *Server) requireThreadAuthor(ctx context.Context, req *request, res *response, params *ReplyParameters) bool {
+	ownership, err := server.store.GetPostOwnership(ctx, params.categoryTag, params.threadNumber)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such thread")
+			return false
+		}
+		res.Fail("Failed to get thread ownership", err)
+		return false
+	}
+	if !ownership.IsThreadAuthor(data.IdentityFrom(ctx)) && !server.canUseRole(req, auth.RoleModerator) {
+		res.Respond(http.StatusForbidden, nil, "that's not your thread")
+		return false
+	}
+	return true
+}
+
+// handleCloseThread handles a POST request from a thread's author to stop replies to it.
+func (server *Server) handleCloseThread(ctx context.Context, req *request, res *response) {
+	params, err := getReplyParameters(req)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	if !server.requireThreadAuthor(ctx, req, res, params) {
+		return
+	}
+
+	err = server.store.CloseThread(ctx, params.categoryTag, params.threadNumber)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such thread")
+			return
+		}
+		res.Fail("Failed to close thread", err)
+		return
+	}
+	res.Respond(http.StatusOK, nil, "thread closed")
+}
+
+// handleSetBestAnswer handles a PUT request from a thread's author marking a reply as the best answer.
+func (server *Server) handleSetBestAnswer(ctx context.Context, req *request, res *response) {
+	params, err := getReplyParameters(req)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	incoming, err := getIncomingBestAnswer(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	if !server.requireThreadAuthor(ctx, req, res, params) {
+		return
+	}
+
+	err = server.store.SetBestAnswer(ctx, params.categoryTag, params.threadNumber, incoming.Num)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such reply on that thread")
+			return
+		}
+		res.Fail("Failed to set best answer", err)
+		return
+	}
+	res.Respond(http.StatusOK, nil, "best answer set")
+}
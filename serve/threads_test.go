@@ -0,0 +1,213 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestThreadAuthor(t *testing.T) {
+	op := &auth.UserData{
+		ID:         "auth0|op",
+		Username:   "op",
+		Email:      "op@gmail.com",
+		IsVerified: true,
+	}
+	other := &auth.UserData{
+		ID:         "auth0|other",
+		Username:   "other",
+		Email:      "other@gmail.com",
+		IsVerified: true,
+	}
+	mockStore := &MockStore{postOwnership: &data.PostOwnership{AuthorID: op.ID, ThreadAuthorID: op.ID}}
+	mockAuth := &MockAuth{}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	if rr := client.as(other).do("POST", "/v1/categories/cat/1/close", ""); rr.Code != http.StatusForbidden || mockStore.closed {
+		t.Errorf("expected others not to close the thread, got: %d", rr.Code)
+	}
+	postOnly := withScopes(moderator("mod@gmail.com"), auth.ScopePost)
+	if rr := client.as(postOnly).do("POST", "/v1/categories/cat/1/close", ""); rr.Code != http.StatusForbidden || mockStore.closed {
+		t.Errorf("expected moderators' tokens without the moderate scope not to close the thread, got: %d", rr.Code)
+	}
+	if rr := client.as(op).do("POST", "/v1/categories/cat/1/close", ""); rr.Code != http.StatusOK || !mockStore.closed {
+		t.Errorf("expected the thread's author to close it, got: %d", rr.Code)
+	}
+
+	if rr := client.as(other).do("PUT", "/v1/categories/cat/1/answer", `{"num": 3}`); rr.Code != http.StatusForbidden || mockStore.bestAnswer != 0 {
+		t.Errorf("expected others not to set the best answer, got: %d", rr.Code)
+	}
+	if rr := client.as(op).do("PUT", "/v1/categories/cat/1/answer", `{"num": -1}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid reply to be rejected, got: %d", rr.Code)
+	}
+	if rr := client.as(op).do("PUT", "/v1/categories/cat/1/answer", `{"num": 3}`); rr.Code != http.StatusOK || mockStore.bestAnswer != 3 {
+		t.Errorf("expected the thread's author to set the best answer, got: %d", rr.Code)
+	}
+	if rr := client.as(moderator("mod@gmail.com")).do("PUT", "/v1/categories/cat/1/answer", `{"num": 0}`); rr.Code != http.StatusOK || mockStore.bestAnswer != 0 {
+		t.Errorf("expected moderators to clear the best answer, got: %d", rr.Code)
+	}
+
+	mockStore.err = data.ErrNotFound
+	if rr := client.as(op).do("POST", "/v1/categories/cat/9/close", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected missing thread to 404, got: %d", rr.Code)
+	}
+}
+
+func TestThreadType(t *testing.T) {
+	tests := map[string]struct {
+		route        string
+		body         string
+		expectedType data.ThreadType
+		expectedCode int
+	}{
+		"Question": {
+			route:        "/v1/categories/cat/0",
+			body:         `{"subject": "how do owls", "content": "hello!", "type": "question"}`,
+			expectedType: data.ThreadQuestion,
+			expectedCode: http.StatusOK,
+		},
+		"Default": {
+			route:        "/v1/categories/cat/0",
+			body:         `{"subject": "about owls", "content": "hello!"}`,
+			expectedCode: http.StatusOK,
+		},
+		"Question reply": {
+			route:        "/v1/categories/cat/1",
+			body:         `{"content": "hello!", "type": "question"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		"Unknown type": {
+			route:        "/v1/categories/cat/0",
+			body:         `{"subject": "about owls", "content": "hello!", "type": "poll"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			mockStore := &MockStore{}
+			server := CreateTestServer(mockStore, &MockAuth{user: moderator("mod@gmail.com")}, ServerOptions{})
+
+			req, err := http.NewRequest("POST", test.route, bytes.NewReader([]byte(test.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Authorization", "ok")
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Fatalf("expected status %d, got: %d", test.expectedCode, rr.Code)
+			}
+			if mockStore.threadType != test.expectedType {
+				t.Errorf("expected thread type %q, got %q", test.expectedType, mockStore.threadType)
+			}
+		})
+	}
+}
+
+func TestStreamThreadView(t *testing.T) {
+	posts := make([]*data.Post, maxThreadViewPosts+5)
+	for i := range posts {
+		posts[i] = &data.Post{Num: i + 1, Cat: "tech", Content: "hi"}
+	}
+	store := &MockStore{getThreadView: &data.ThreadView{Category: &data.Category{Tag: "tech"}, Posts: posts}}
+	server := CreateTestServer(store, &MockAuth{}, ServerOptions{})
+
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/categories/tech/1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, got: %d", http.StatusOK, rr.Code)
+	}
+	var view struct {
+		data.ThreadView
+		More bool `json:"more"`
+	}
+	err := json.NewDecoder(rr.Body).Decode(&view)
+	if err != nil {
+		t.Fatalf("expected a valid thread view, got: %v", err)
+	}
+	if view.Category.Tag != "tech" || len(view.Posts) != maxThreadViewPosts || !view.More {
+		t.Errorf("expected the first %d posts with more set, got %d posts, more %v", maxThreadViewPosts, len(view.Posts), view.More)
+	}
+
+	store.getThreadView.Posts = posts[:3]
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/categories/tech/1", nil))
+	view.More = false
+	err = json.NewDecoder(rr.Body).Decode(&view)
+	if err != nil {
+		t.Fatalf("expected a valid thread view, got: %v", err)
+	}
+	if len(view.Posts) != 3 || view.More {
+		t.Errorf("expected 3 posts without more, got %d posts, more %v", len(view.Posts), view.More)
+	}
+}
+
+func TestThreadViewPages(t *testing.T) {
+	posts := make([]*data.Post, 11)
+	for i := range posts {
+		posts[i] = &data.Post{Num: i + 1, Cat: "tech", Content: "hi"}
+	}
+	store := &MockStore{getThreadView: &data.ThreadView{Category: &data.Category{Tag: "tech"}, Posts: posts}}
+	server := CreateTestServer(store, &MockAuth{}, ServerOptions{})
+
+	get := func(query string) ([]int, bool, int) {
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/categories/tech/1"+query, nil))
+		if rr.Code != http.StatusOK {
+			return nil, false, rr.Code
+		}
+		var view struct {
+			data.ThreadView
+			More bool `json:"more"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&view); err != nil {
+			t.Fatalf("expected a valid thread view, got: %v", err)
+		}
+		nums := make([]int, 0, len(view.Posts))
+		for _, post := range view.Posts {
+			nums = append(nums, post.Num)
+		}
+		return nums, view.More, rr.Code
+	}
+
+	tests := map[string]struct {
+		query string
+		nums  []int
+		more  bool
+	}{
+		"offset and limit":  {"?offset=2&limit=3", []int{1, 4, 5, 6}, true},
+		"offset to the end": {"?offset=7", []int{1, 9, 10, 11}, false},
+		"last replies":      {"?last=2", []int{1, 10, 11}, false},
+		"last and limit":    {"?last=5&limit=2", []int{1, 7, 8}, true},
+		"limit only":        {"?limit=10", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, false},
+	}
+	for testName, test := range tests {
+		nums, more, code := get(test.query)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected %d, got: %d", testName, http.StatusOK, code)
+			continue
+		}
+		if fmt.Sprint(nums) != fmt.Sprint(test.nums) || more != test.more {
+			t.Errorf("%s: expected posts %v with more %v, got %v with more %v", testName, test.nums, test.more, nums, more)
+		}
+	}
+
+	get("?limit=5000")
+	if store.threadPage.Limit != maxThreadViewPosts {
+		t.Errorf("expected the limit capped at %d, got %d", maxThreadViewPosts, store.threadPage.Limit)
+	}
+	for _, query := range []string{"?offset=-1", "?limit=ten", "?offset=2&last=2"} {
+		if _, _, code := get(query); code != http.StatusBadRequest {
+			t.Errorf("expected %q to be refused, got: %d", query, code)
+		}
+	}
+}
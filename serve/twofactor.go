@@ -0,0 +1,188 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"time"
+)
+
+var errTwoFactorRequired = errors.New("staff need to enter a two-factor code on this session first")
+var errWrongTOTPCode = errors.New("wrong two-factor code")
+var errTwoFactorDisabled = errors.New("two-factor authentication isn't set up")
+var errTwoFactorNoSession = errors.New("staff need to log in through /v1/login to enter a two-factor code on the session")
+
+func twoFactorKey(userID string) string {
+	return "2fa:" + userID
+}
+
+// totpEnrollment is a new two-factor secret, for the user to add to their authenticator app.
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	// otpauth URI to show as a QR code.
+	URI string `json:"uri"`
+}
+
+// twoFactorStatus is whether the user has two-factor authentication, and whether it's been entered on this session.
+type twoFactorStatus struct {
+	Enabled         bool `json:"enabled"`
+	SessionVerified bool `json:"sessionVerified"`
+	// Whether the user's staff roles need it.
+	Required bool `json:"required"`
+}
+
+func (server *Server) getTwoFactorStatus(ctx context.Context, req *request) (*twoFactorStatus, error) {
+	status := &twoFactorStatus{
+		SessionVerified: req.session != nil && req.session.VerifiedAt != nil,
+		Required:        server.staffTwoFactor && len(req.user.Roles) > 0,
+	}
+	totp, err := server.store.GetTOTP(ctx, req.user.ID)
+	if err != nil && !errors.Is(err, data.ErrNotFound) {
+		return nil, err
+	}
+	status.Enabled = totp != nil && totp.Confirmed
+	return status, nil
+}
+
+/*
+checkTOTPCode checks a code from the user's authenticator app, refusing codes that have already been used.
+Codes are counted before they're checked, and lock the user out of trying more for longer each time, like logins.
+Returns the status to respond with and why if the code isn't accepted, or zero if it is.
+*/
+func (server *Server) checkTOTPCode(ctx context.Context, req *request, code string) (int, error) {
+	totp, err := server.store.GetTOTP(ctx, req.user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			return http.StatusNotFound, errTwoFactorDisabled
+		}
+		return http.StatusInternalServerError, err
+	}
+	key := twoFactorKey(req.user.ID)
+	if _, allowed, err := server.login.Accounts.Attempt(ctx, key); err != nil {
+		log.Printf("Failed to count two-factor code: %s", err)
+	} else if !allowed {
+		return http.StatusTooManyRequests, errors.New("too many wrong two-factor codes, try again later")
+	}
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return http.StatusUnauthorized, errWrongTOTPCode
+	}
+	if err := server.store.UseTOTP(ctx, req.user.ID, step); err != nil {
+		if errors.Is(err, data.ErrTOTPReused) {
+			return http.StatusUnauthorized, err
+		}
+		return http.StatusInternalServerError, err
+	}
+	if err := server.login.Accounts.Reset(ctx, key); err != nil {
+		log.Printf("Failed to reset wrong two-factor codes: %s", err)
+	}
+	return 0, nil
+}
+
+// handleGetTwoFactor handles a GET request for whether the user has two-factor authentication.
+func (server *Server) handleGetTwoFactor(ctx context.Context, req *request, res *response) {
+	status, err := server.getTwoFactorStatus(ctx, req)
+	if err != nil {
+		res.Fail("Failed to get two-factor status", err)
+		return
+	}
+	res.Respond(http.StatusOK, status, "")
+}
+
+/*
+handleEnrollTwoFactor handles a POST request starting two-factor authentication, returning a new secret
+for the user's authenticator app. It's turned on once a code from the app is verified.
+*/
+func (server *Server) handleEnrollTwoFactor(ctx context.Context, req *request, res *response) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		res.Fail("Failed to generate two-factor secret", err)
+		return
+	}
+	err = server.store.WriteTOTP(ctx, req.user.ID, secret)
+	if err != nil {
+		if errors.Is(err, data.ErrTOTPEnrolled) {
+			res.Respond(http.StatusConflict, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to write two-factor secret", err)
+		return
+	}
+	res.Respond(http.StatusOK, totpEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(server.branding.Name, req.user.Email, secret),
+	}, "")
+}
+
+/*
+handleVerifyTwoFactor handles a POST request with a code from the user's authenticator app, turning two-factor
+authentication on if it's the first, and marking the session the request was made with as verified.
+*/
+func (server *Server) handleVerifyTwoFactor(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingTOTPCode(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	// Tokens not from /v1/login have no session to mark, so staff would use up the code and still be refused.
+	if req.session == nil && server.staffTwoFactor && len(req.user.Roles) > 0 {
+		res.Respond(http.StatusConflict, nil, errTwoFactorNoSession.Error())
+		return
+	}
+	status, err := server.checkTOTPCode(ctx, req, incoming.Code)
+	if status != 0 {
+		if status == http.StatusInternalServerError {
+			res.Fail("Failed to check two-factor code", err)
+			return
+		}
+		res.Respond(status, nil, err.Error())
+		return
+	}
+	if req.session != nil {
+		err = server.store.VerifySession(ctx, req.session.TokenHash)
+		if err != nil && !errors.Is(err, data.ErrNotFound) {
+			res.Fail("Failed to verify session", err)
+			return
+		}
+		now := time.Now()
+		req.session.VerifiedAt = &now
+	}
+	twoFactor, err := server.getTwoFactorStatus(ctx, req)
+	if err != nil {
+		res.Fail("Failed to get two-factor status", err)
+		return
+	}
+	res.Respond(http.StatusOK, twoFactor, "")
+}
+
+// handleDisableTwoFactor handles a DELETE request turning off two-factor authentication, which needs a current code.
+func (server *Server) handleDisableTwoFactor(ctx context.Context, req *request, res *response) {
+	incoming, err := getIncomingTOTPCode(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	status, err := server.checkTOTPCode(ctx, req, incoming.Code)
+	if status != 0 {
+		if status == http.StatusInternalServerError {
+			res.Fail("Failed to check two-factor code", err)
+			return
+		}
+		res.Respond(status, nil, err.Error())
+		return
+	}
+	err = server.store.RemoveTOTP(ctx, req.user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, errTwoFactorDisabled.Error())
+			return
+		}
+		res.Fail("Failed to remove two-factor secret", err)
+		return
+	}
+	log.Printf("Two-factor authentication turned off by %s", req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "two-factor authentication turned off"}, "")
+}
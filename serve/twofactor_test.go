@@ -0,0 +1,166 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTwoFactor(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{StaffTwoFactor: true})
+
+	client := newTestClient(server, mockAuth).withToken("access-admin")
+	do := func(method string, path string, code string) *httptest.ResponseRecorder {
+		var body []byte
+		if len(code) > 0 {
+			body, _ = json.Marshal(incomingTOTPCode{Code: code})
+		}
+		return client.do(method, path, string(body))
+	}
+	codeAt := func(secret string, at time.Time) string {
+		code, err := auth.TOTPCode(secret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	body, _ := json.Marshal(incomingLogin{Username: "admin", Password: "hunter2"})
+	rr := client.withToken("").do("POST", "/v1/login", string(body))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got: %d", rr.Code)
+	}
+
+	if rr := do("GET", "/v1/admin/cache-metrics", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected staff routes refused before a code's entered, got: %d", rr.Code)
+	}
+	capcoded := `{"content": "hello!", "capcode": "admin"}`
+	if rr := client.do("POST", "/v1/categories/cat/1", capcoded); rr.Code != http.StatusForbidden || len(mockStore.capcode) > 0 {
+		t.Errorf("expected staff capcodes refused before a code's entered, got: %d", rr.Code)
+	}
+	if rr := do("POST", "/v1/me/2fa/verify", "123456"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected verifying before enrolling to 404, got: %d", rr.Code)
+	}
+
+	// Enrolling again before confirming replaces the secret.
+	var enrollment totpEnrollment
+	for i := 0; i < 2; i++ {
+		rr = do("POST", "/v1/me/2fa", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected enrolling to succeed, got: %d", rr.Code)
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&enrollment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(enrollment.Secret) == 0 || !strings.HasPrefix(enrollment.URI, "otpauth://totp/") {
+		t.Fatalf("expected a secret and otpauth URI, got %+v", enrollment)
+	}
+
+	now := time.Now()
+	code := codeAt(enrollment.Secret, now)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if rr := do("POST", "/v1/me/2fa/verify", wrong); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a wrong code refused, got: %d", rr.Code)
+	}
+	rr = do("POST", "/v1/me/2fa/verify", code)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the code accepted, got: %d", rr.Code)
+	}
+	var status twoFactorStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || !status.SessionVerified || !status.Required {
+		t.Errorf("expected two-factor on and the session verified, got %+v", status)
+	}
+
+	if rr := do("GET", "/v1/admin/cache-metrics", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected staff routes allowed on a verified session, got: %d", rr.Code)
+	}
+	if rr := client.do("POST", "/v1/categories/cat/1", capcoded); rr.Code != http.StatusOK || mockStore.capcode != "admin" {
+		t.Errorf("expected staff capcodes allowed on a verified session, got: %d", rr.Code)
+	}
+	if rr := do("POST", "/v1/me/2fa", ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected enrolling again once confirmed to conflict, got: %d", rr.Code)
+	}
+	if rr := do("POST", "/v1/me/2fa/verify", code); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a used code refused, got: %d", rr.Code)
+	}
+
+	if rr := do("DELETE", "/v1/me/2fa", codeAt(enrollment.Secret, now.Add(time.Second*30))); rr.Code != http.StatusOK {
+		t.Fatalf("expected two-factor turned off, got: %d", rr.Code)
+	}
+	if rr := do("GET", "/v1/admin/cache-metrics", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected the session unverified once two-factor's off, got: %d", rr.Code)
+	}
+}
+
+func TestTwoFactorWithoutSession(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{StaffTwoFactor: true})
+
+	client := newTestClient(server, mockAuth)
+	do := func(method string, path string, token string, code string) *httptest.ResponseRecorder {
+		var body []byte
+		if len(code) > 0 {
+			body, _ = json.Marshal(incomingTOTPCode{Code: code})
+		}
+		return client.withToken(token).do(method, path, string(body))
+	}
+
+	// Tokens from elsewhere, like a client-side Auth0 login, have no session.
+	rr := do("POST", "/v1/me/2fa", "external-token", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected enrolling to succeed, got: %d", rr.Code)
+	}
+	var enrollment totpEnrollment
+	if err := json.NewDecoder(rr.Body).Decode(&enrollment); err != nil {
+		t.Fatal(err)
+	}
+	code, err := auth.TOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = do("POST", "/v1/me/2fa/verify", "external-token", code)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "/v1/login") {
+		t.Errorf("expected staff told to log in through /v1/login, got: %d %s", rr.Code, rr.Body.String())
+	}
+
+	body, _ := json.Marshal(incomingLogin{Username: "admin", Password: "hunter2"})
+	rr = client.withToken("").do("POST", "/v1/login", string(body))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got: %d", rr.Code)
+	}
+	if rr := do("POST", "/v1/me/2fa/verify", "access-admin", code); rr.Code != http.StatusOK {
+		t.Errorf("expected the refused code still usable once logged in, got: %d", rr.Code)
+	}
+	if rr := do("GET", "/v1/admin/cache-metrics", "access-admin", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected staff routes allowed on the verified session, got: %d", rr.Code)
+	}
+
+	// Users without staff roles don't need a session to turn two-factor on.
+	mockAuth.user = &auth.UserData{ID: "auth0|user", Username: "user", Email: "user@gmail.com", IsVerified: true}
+	rr = do("POST", "/v1/me/2fa", "external-token", "")
+	if err := json.NewDecoder(rr.Body).Decode(&enrollment); err != nil {
+		t.Fatal(err)
+	}
+	code, err = auth.TOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := do("POST", "/v1/me/2fa/verify", "external-token", code); rr.Code != http.StatusOK {
+		t.Errorf("expected users without staff roles to turn two-factor on without a session, got: %d", rr.Code)
+	}
+}
@@ -0,0 +1,462 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"spiritchat/data"
+	"spiritchat/events"
+	"spiritchat/media"
+	"strconv"
+	"time"
+)
+
+// Room left for the multipart headers around an upload.
+const maxUploadOverhead = 1 << 16
+
+// UploadOptions configure attachment uploads.
+type UploadOptions struct {
+	// Optional, uploads are disabled without one.
+	Storage media.Storage
+	// Optional, only images can be uploaded without one.
+	Prober        media.Prober
+	MaxImageBytes int64
+	MaxMediaBytes int64
+	// Longest audio or video that can be uploaded.
+	MaxMediaDuration time.Duration
+	// Re-encodes images instead of only stripping their metadata.
+	ReencodeImages bool
+	// Bytes all attachments can take up, uploads are refused past it. Zero for no quota.
+	MaxStorageBytes int64
+	// Optional, thumbnails are only JPEGs without one.
+	ImageEncoder media.ImageEncoder
+	// MIME types thumbnails are encoded as besides JPEG, image/webp and image/avif.
+	ThumbnailFormats []string
+}
+
+// maxBytes returns the size limit of the kind of upload.
+func (opts UploadOptions) maxBytes(kind data.AttachmentKind) int64 {
+	if kind == data.AttachmentImage {
+		return opts.MaxImageBytes
+	}
+	return opts.MaxMediaBytes
+}
+
+// Codes of upload errors clients are expected to explain.
+const (
+	rejectTooLarge    = "too_large"
+	rejectTooLong     = "too_long"
+	rejectUnsupported = "unsupported_type"
+	rejectMismatch    = "type_mismatch"
+	rejectPolyglot    = "polyglot"
+	rejectQuota       = "quota_exceeded"
+)
+
+var quotaRejection = rejection{Code: rejectQuota, Message: "there's no room left for more files"}
+
+// Shown in place of spoilered and NSFW attachments until they're opened.
+var spoilerPlaceholder = func() []byte {
+	placeholder := image.NewGray(image.Rect(0, 0, 200, 200))
+	for i := range placeholder.Pix {
+		placeholder.Pix[i] = 0x40
+	}
+	var b bytes.Buffer
+	png.Encode(&b, placeholder)
+	return b.Bytes()
+}()
+
+/*
+handleUpload handles a multipart POST uploading a single file in the "file" field.
+The file's type is sniffed from its contents, audio and video are probed for their length,
+and the attachment's returned for the uploader to post with. Naming the category it's for
+with ?cat= checks the file against the category's upload policy up front.
+*/
+func (server *Server) handleUpload(ctx context.Context, req *request, res *response) {
+	if server.uploads.Storage == nil {
+		res.Respond(http.StatusNotFound, nil, "uploads aren't enabled")
+		return
+	}
+
+	identity := data.IdentityFrom(ctx)
+	banned, err := server.store.IsBanned(ctx, identity.PosterHashes()...)
+	if err != nil {
+		res.Fail("Failed to check bans", err)
+		return
+	}
+	if banned {
+		res.Respond(http.StatusForbidden, nil, "you're banned from posting")
+		return
+	}
+
+	policy := &data.UploadPolicy{}
+	categoryTag := req.rawRequest.URL.Query().Get("cat")
+	if len(categoryTag) > 0 {
+		postPolicy, err := server.store.GetPostPolicy(ctx, categoryTag)
+		if err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				res.Respond(http.StatusNotFound, nil, err.Error())
+				return
+			}
+			res.Fail("Failed to get post policy", err)
+			return
+		}
+		policy = &postPolicy.Uploads
+	}
+
+	limit := server.uploads.MaxImageBytes
+	if server.uploads.Prober != nil && server.uploads.MaxMediaBytes > limit {
+		limit = server.uploads.MaxMediaBytes
+	}
+	if policy.MaxFileBytes > 0 && policy.MaxFileBytes < limit {
+		limit = policy.MaxFileBytes
+	}
+	req.rawRequest.Body = http.MaxBytesReader(res.rw, req.rawRequest.Body, limit+maxUploadOverhead)
+	reader, err := req.rawRequest.MultipartReader()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "expected a multipart upload")
+		return
+	}
+	var part *multipart.Part
+	for {
+		p, err := reader.NextPart()
+		if err != nil {
+			res.Respond(http.StatusBadRequest, nil, "no file uploaded")
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+			break
+		}
+	}
+
+	tmp, err := ioutil.TempFile("", "spiritchat-upload-")
+	if err != nil {
+		res.Fail("Failed to create upload file", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, io.LimitReader(part, limit+1))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "failed to read upload")
+		return
+	}
+	if size > limit {
+		res.Respond(http.StatusRequestEntityTooLarge, rejection{Code: rejectTooLarge, Message: "file is too large"}, "")
+		return
+	}
+
+	header := make([]byte, 512)
+	n, _ := tmp.ReadAt(header, 0)
+	mimeType, kind, err := media.Sniff(header[:n])
+	if err != nil || (kind != data.AttachmentImage && server.uploads.Prober == nil) {
+		res.Respond(http.StatusUnsupportedMediaType, rejection{Code: rejectUnsupported, Message: "that type of file can't be uploaded"}, "")
+		return
+	}
+	if size > server.uploads.maxBytes(kind) {
+		res.Respond(http.StatusRequestEntityTooLarge, rejection{Code: rejectTooLarge, Message: "file is too large"}, "")
+		return
+	}
+	if !policy.Allows(mimeType, 0) {
+		message := fmt.Sprintf("this category doesn't take %s files", mimeType)
+		res.Respond(http.StatusUnsupportedMediaType, rejection{Code: rejectUnsupported, Message: message}, "")
+		return
+	}
+	full, err := server.overQuota(ctx, categoryTag, policy, size)
+	if err != nil {
+		res.Fail("Failed to check storage quota", err)
+		return
+	}
+	if full {
+		res.Respond(http.StatusInsufficientStorage, quotaRejection, "")
+		return
+	}
+	if !media.MatchesDeclared(part.Header.Get("Content-Type"), mimeType) {
+		message := fmt.Sprintf("file was sent as %s but is %s", part.Header.Get("Content-Type"), mimeType)
+		res.Respond(http.StatusUnsupportedMediaType, rejection{Code: rejectMismatch, Message: message}, "")
+		return
+	}
+
+	// Images are small enough to clean up in memory, whatever's stored is read from content.
+	var content io.Reader = io.NewSectionReader(tmp, 0, size)
+	var info *media.Info
+	// What thumbnails are taken of, the cleaned image or a video's poster.
+	var still []byte
+	if kind == data.AttachmentImage {
+		still, err = server.cleanImage(mimeType, tmp)
+		if err == nil {
+			size = int64(len(still))
+			content = bytes.NewReader(still)
+			info, err = media.ProbeImage(bytes.NewReader(still))
+		}
+	} else {
+		var hasMarkup bool
+		hasMarkup, err = media.ContainsMarkup(io.NewSectionReader(tmp, 0, size))
+		if err == nil && hasMarkup {
+			err = media.ErrPolyglot
+		}
+		if err == nil {
+			info, err = server.uploads.Prober.Probe(ctx, tmp.Name())
+		}
+	}
+	if errors.Is(err, media.ErrPolyglot) {
+		res.Respond(http.StatusUnsupportedMediaType, rejection{Code: rejectPolyglot, Message: err.Error()}, "")
+		return
+	}
+	if err != nil {
+		res.Respond(http.StatusUnsupportedMediaType, rejection{Code: rejectUnsupported, Message: "file couldn't be read"}, "")
+		log.Printf("Failed to probe upload: %s", err)
+		return
+	}
+	if kind != data.AttachmentImage {
+		if !info.HasVideo {
+			kind = data.AttachmentAudio
+		}
+		if server.uploads.MaxMediaDuration > 0 && info.Duration > server.uploads.MaxMediaDuration {
+			message := fmt.Sprintf("audio and video can't be longer than %s", server.uploads.MaxMediaDuration)
+			res.Respond(http.StatusRequestEntityTooLarge, rejection{Code: rejectTooLong, Message: message}, "")
+			return
+		}
+	}
+
+	attachment := &data.Attachment{
+		Kind:       kind,
+		MIME:       mimeType,
+		Size:       size,
+		File:       media.NewKey(media.Extension(mimeType)),
+		Width:      info.Width,
+		Height:     info.Height,
+		DurationMS: int(info.Duration / time.Millisecond),
+		CreatedAt:  time.Now(),
+		UploaderID: identity.ID,
+	}
+	err = server.uploads.Storage.Put(ctx, attachment.File, content)
+	if err != nil {
+		res.Fail("Failed to store upload", err)
+		return
+	}
+
+	// Videos without a poster still play, so failing to take one isn't fatal.
+	if kind == data.AttachmentVideo {
+		var poster bytes.Buffer
+		err := server.uploads.Prober.PosterFrame(ctx, tmp.Name(), &poster)
+		if err == nil {
+			key := media.NewKey(".jpg")
+			still = poster.Bytes()
+			err = server.uploads.Storage.Put(ctx, key, &poster)
+			if err == nil {
+				attachment.Poster = key
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to take poster frame: %s", err)
+		}
+	}
+	if len(still) > 0 {
+		attachment.Thumbnails = server.storeThumbnails(ctx, still)
+	}
+
+	attachment.ID, err = server.store.WriteAttachment(ctx, attachment)
+	if err != nil {
+		for _, key := range attachment.Files() {
+			server.uploads.Storage.Remove(ctx, key)
+		}
+		res.Fail("Failed to write attachment", err)
+		return
+	}
+
+	server.events.Publish(events.Event{
+		Kind:       events.AttachmentUploaded,
+		Attachment: attachment,
+		Poster:     identity.IPHash,
+		At:         time.Now(),
+	})
+	res.Respond(http.StatusOK, attachment, "")
+}
+
+/*
+storeThumbnails stores thumbnails of the image in every size and format, returning what was stored.
+Attachments work without thumbnails, so failures are only logged.
+*/
+func (server *Server) storeThumbnails(ctx context.Context, still []byte) []data.Thumbnail {
+	img, _, err := image.Decode(bytes.NewReader(still))
+	if err != nil {
+		log.Printf("Failed to decode image for thumbnails: %s", err)
+		return nil
+	}
+	variants, err := media.Thumbnails(ctx, img, server.uploads.ImageEncoder, server.uploads.ThumbnailFormats)
+	if err != nil {
+		log.Printf("Failed to make thumbnails: %s", err)
+		return nil
+	}
+	thumbnails := make([]data.Thumbnail, 0, len(variants))
+	for _, variant := range variants {
+		if err := server.uploads.Storage.Put(ctx, variant.File, bytes.NewReader(variant.Content)); err != nil {
+			log.Printf("Failed to store thumbnail: %s", err)
+			continue
+		}
+		thumbnails = append(thumbnails, variant.Thumbnail)
+	}
+	return thumbnails
+}
+
+/*
+overQuota reports whether adding size bytes would go over the server's storage quota, or the category's
+if one's named and it doesn't prune its oldest files to make room.
+*/
+func (server *Server) overQuota(ctx context.Context, categoryTag string, policy *data.UploadPolicy, size int64) (bool, error) {
+	if server.uploads.MaxStorageBytes > 0 {
+		usage, err := server.store.GetStorageUsage(ctx)
+		if err != nil {
+			return false, err
+		}
+		if usage.TotalBytes+size > server.uploads.MaxStorageBytes {
+			return true, nil
+		}
+	}
+	if len(categoryTag) == 0 || policy.QuotaBytes == 0 || policy.PruneOldest {
+		return false, nil
+	}
+	used, err := server.store.GetCategoryStorageUsage(ctx, categoryTag)
+	if err != nil {
+		return false, err
+	}
+	return used+size > policy.QuotaBytes, nil
+}
+
+// pruneAttachments deletes a category's oldest attachments past its quota, files and all.
+func (server *Server) pruneAttachments(ctx context.Context, categoryTag string, quota int64) {
+	pruned, err := server.store.PruneAttachments(ctx, categoryTag, quota)
+	if err != nil {
+		log.Printf("Failed to prune attachments on %s: %s", categoryTag, err)
+		return
+	}
+	for _, attachment := range pruned {
+		for _, key := range attachment.Files() {
+			if err := server.uploads.Storage.Remove(ctx, key); err != nil {
+				log.Printf("Failed to remove pruned file %s: %s", key, err)
+			}
+		}
+	}
+	if len(pruned) > 0 {
+		log.Printf("Pruned %d attachments on %s to fit its quota", len(pruned), categoryTag)
+	}
+}
+
+// handleSetAttachmentFlags handles a PUT request from a moderator forcing an attachment to be a spoiler or NSFW, or not.
+func (server *Server) handleSetAttachmentFlags(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid attachment ID")
+		return
+	}
+	flags, err := getIncomingAttachmentFlags(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	attachment, err := server.store.SetAttachmentFlags(ctx, id, flags)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to set attachment flags", err)
+		return
+	}
+	log.Printf("Attachment %d flags set by %s", id, req.user.Email)
+	res.Respond(http.StatusOK, attachment, "")
+}
+
+// handleGetStorageUsage handles a GET request from an admin for how much storage attachments take up.
+func (server *Server) handleGetStorageUsage(ctx context.Context, req *request, res *response) {
+	usage, err := server.store.GetStorageUsage(ctx)
+	if err != nil {
+		res.Fail("Failed to get storage usage", err)
+		return
+	}
+	res.Respond(http.StatusOK, storageUsage{StorageUsage: usage, QuotaBytes: server.uploads.MaxStorageBytes}, "")
+}
+
+/*
+cleanImage strips an uploaded image's metadata, or re-encodes it in strict mode, and refuses
+images hiding markup or other files.
+*/
+func (server *Server) cleanImage(mimeType string, file io.ReadSeeker) ([]byte, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	original, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	// Stripped first either way, so trailing data is refused rather than quietly dropped.
+	image, err := media.StripMetadata(mimeType, original)
+	if err != nil {
+		return nil, err
+	}
+	if server.uploads.ReencodeImages {
+		image, err = media.Reencode(mimeType, image)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hasMarkup, err := media.ContainsMarkup(bytes.NewReader(image))
+	if err != nil {
+		return nil, err
+	}
+	if hasMarkup {
+		return nil, media.ErrPolyglot
+	}
+	return image, nil
+}
+
+// handleGetMedia handles a GET request for a stored file. Keys are random, so files never change.
+func (server *Server) handleGetMedia(ctx context.Context, req *request, res *response) {
+	if server.uploads.Storage == nil {
+		res.Respond(http.StatusNotFound, nil, "uploads aren't enabled")
+		return
+	}
+	key := req.params.ByName("file")
+	if key == data.SpoilerPreview {
+		res.rw.Header().Set("Content-Type", "image/png")
+		res.rw.Header().Set("Cache-Control", "public, max-age=86400")
+		res.rw.WriteHeader(http.StatusOK)
+		res.rw.Write(spoilerPlaceholder)
+		return
+	}
+	file, err := server.uploads.Storage.Open(ctx, key)
+	if err != nil {
+		if errors.Is(err, media.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to open media", err)
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	res.rw.Header().Set("Content-Type", contentType)
+	res.rw.Header().Set("X-Content-Type-Options", "nosniff")
+	res.rw.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	res.rw.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(res.rw, file); err != nil {
+		log.Printf("Failed to write media: %s", err)
+	}
+}
@@ -0,0 +1,435 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"spiritchat/media"
+	"testing"
+	"time"
+)
+
+type MockProber struct {
+	info *media.Info
+}
+
+func (mp *MockProber) Probe(ctx context.Context, path string) (*media.Info, error) {
+	return mp.info, nil
+}
+
+func (mp *MockProber) PosterFrame(ctx context.Context, path string, w io.Writer) error {
+	_, err := w.Write([]byte("poster"))
+	return err
+}
+
+func TestUploads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiritchat-uploads-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage, err := media.NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pngFile bytes.Buffer
+	if err := png.Encode(&pngFile, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	webm := append([]byte("\x1a\x45\xdf\xa3"), make([]byte, 64)...)
+
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: &auth.UserData{Username: "test user", Email: "test@gmail.com", IsVerified: true}}
+	prober := &MockProber{info: &media.Info{Width: 640, Height: 480, Duration: time.Second * 10, HasVideo: true}}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{
+		Uploads: UploadOptions{
+			Storage:          storage,
+			Prober:           prober,
+			MaxImageBytes:    1024,
+			MaxMediaBytes:    2048,
+			MaxMediaDuration: time.Minute,
+		},
+	})
+
+	upload := func(content []byte, declared string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="file"; filename="upload"`)
+		header.Set("Content-Type", declared)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+		form.Close()
+		req, err := http.NewRequest("POST", "/v1/uploads", &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Add("Authorization", "ok")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := upload(pngFile.Bytes(), "image/png")
+	var attachment data.Attachment
+	if err := json.NewDecoder(rr.Body).Decode(&attachment); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected image upload to succeed, got: %d %v", rr.Code, err)
+	}
+	if attachment.Kind != data.AttachmentImage || attachment.MIME != "image/png" || attachment.Width != 3 || attachment.Height != 2 {
+		t.Errorf("expected PNG attachment, got %+v", attachment)
+	}
+
+	req, _ := http.NewRequest("GET", "/v1/media/"+attachment.File, nil)
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rr.Body.Bytes(), pngFile.Bytes()) {
+		t.Errorf("expected stored PNG to be served, got: %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if len(attachment.Thumbnails) != 1 || attachment.Thumbnails[0].Size != "small" || attachment.Thumbnails[0].Width != 3 {
+		t.Fatalf("expected a small thumbnail of the image, got %+v", attachment.Thumbnails)
+	}
+	req, _ = http.NewRequest("GET", "/v1/media/"+attachment.Thumbnails[0].File, nil)
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("expected JPEG thumbnail to be served, got: %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	req, _ = http.NewRequest("GET", "/v1/media/..%2fetc", nil)
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected missing file to 404, got: %d", rr.Code)
+	}
+
+	rr = upload(webm, "video/webm")
+	attachment = data.Attachment{}
+	if err := json.NewDecoder(rr.Body).Decode(&attachment); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected video upload to succeed, got: %d %v", rr.Code, err)
+	}
+	if attachment.Kind != data.AttachmentVideo || attachment.DurationMS != 10000 || len(attachment.Poster) == 0 {
+		t.Errorf("expected probed video with a poster, got %+v", attachment)
+	}
+
+	prober.info = &media.Info{Duration: time.Second * 30, HasAudio: true}
+	rr = upload(webm, "video/webm")
+	attachment = data.Attachment{}
+	if err := json.NewDecoder(rr.Body).Decode(&attachment); err != nil || attachment.Kind != data.AttachmentAudio {
+		t.Errorf("expected WebM without video to be audio, got: %d %+v", rr.Code, attachment)
+	}
+
+	tests := map[string]struct {
+		content      []byte
+		declared     string
+		expectedCode int
+		expectedErr  string
+	}{
+		"Unsupported type": {[]byte("just some text"), "", http.StatusUnsupportedMediaType, rejectUnsupported},
+		"Image too large":  {append(pngFile.Bytes(), make([]byte, 1024)...), "", http.StatusRequestEntityTooLarge, rejectTooLarge},
+		"Video too large":  {append(webm, make([]byte, 2048)...), "", http.StatusRequestEntityTooLarge, rejectTooLarge},
+		"Type mismatch":    {pngFile.Bytes(), "image/gif", http.StatusUnsupportedMediaType, rejectMismatch},
+		"Trailing data":    {append(pngFile.Bytes(), "PK\x03\x04"...), "image/png", http.StatusUnsupportedMediaType, rejectPolyglot},
+		"Hidden markup":    {append(webm, "<script>alert(1)</script>"...), "", http.StatusUnsupportedMediaType, rejectPolyglot},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rr := upload(test.content, test.declared)
+			var rejected rejection
+			if err := json.NewDecoder(rr.Body).Decode(&rejected); err != nil || rr.Code != test.expectedCode || rejected.Code != test.expectedErr {
+				t.Errorf("expected %d %s, got: %d %+v", test.expectedCode, test.expectedErr, rr.Code, rejected)
+			}
+		})
+	}
+
+	prober.info = &media.Info{Duration: time.Hour, HasVideo: true}
+	if rr := upload(webm, "video/webm"); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected overly long video to be refused, got: %d", rr.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/v1/categories/cat/0", bytes.NewBufferString(`{"subject": "look here", "content": "look", "attachments": [1, 1, 2]}`))
+	req.Header.Add("Authorization", "ok")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || len(mockStore.postAttachments) != 2 {
+		t.Errorf("expected post with repeated attachments deduplicated, got: %d %v", rr.Code, mockStore.postAttachments)
+	}
+	req, _ = http.NewRequest("POST", "/v1/categories/cat/0", bytes.NewBufferString(`{"subject": "look here", "content": "look", "attachments": [1, 2, 3, 4, 5]}`))
+	req.Header.Add("Authorization", "ok")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected too many attachments to be refused, got: %d", rr.Code)
+	}
+
+	mockStore.err = data.ErrAttachmentUnavailable
+	req, _ = http.NewRequest("POST", "/v1/categories/cat/0", bytes.NewBufferString(`{"subject": "look here", "content": "look", "attachments": [9]}`))
+	req.Header.Add("Authorization", "ok")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unavailable attachments to be refused, got: %d", rr.Code)
+	}
+}
+
+func TestUploadsDisabled(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: &auth.UserData{Username: "test user", Email: "test@gmail.com", IsVerified: true}}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	req, _ := http.NewRequest("POST", "/v1/uploads", bytes.NewBufferString("file"))
+	req.Header.Add("Authorization", "ok")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected uploads to be disabled, got: %d", rr.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/v1/categories/cat/0", bytes.NewBufferString(`{"subject": "look here", "content": "look", "attachments": [1]}`))
+	req.Header.Add("Authorization", "ok")
+	rr = httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected attachments to be refused without uploads, got: %d", rr.Code)
+	}
+}
+
+func TestUploadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiritchat-uploads-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage, err := media.NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pngFile bytes.Buffer
+	if err := png.Encode(&pngFile, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{
+		Uploads: UploadOptions{Storage: storage, MaxImageBytes: 1 << 20},
+	})
+
+	client := newTestClient(server, mockAuth)
+	do := func(method string, route string, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, route, body)
+		req.Header.Set("Content-Type", contentType)
+		return client.send(req)
+	}
+	upload := func(route string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", "upload.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(pngFile.Bytes())
+		form.Close()
+		return do("POST", route, form.FormDataContentType(), &body)
+	}
+
+	invalid := []string{
+		`{"uploads": {"maxFiles": 5}}`,
+		`{"uploads": {"maxFileBytes": -1}}`,
+		`{"uploads": {"allowedTypes": ["text/html"]}}`,
+	}
+	for _, policy := range invalid {
+		if rr := do("PUT", "/v1/admin/categories/pics/policy", "application/json", bytes.NewBufferString(policy)); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be refused, got: %d", policy, rr.Code)
+		}
+	}
+	rr := do("PUT", "/v1/admin/categories/pics/policy", "application/json", bytes.NewBufferString(
+		`{"uploads": {"maxFiles": 1, "allowedTypes": [" image/GIF", "image/gif"]}}`,
+	))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if uploads := mockStore.postPolicy.Uploads; uploads.MaxFiles != 1 || len(uploads.AllowedTypes) != 1 || uploads.AllowedTypes[0] != "image/gif" {
+		t.Errorf("expected cleaned up upload policy, got %+v", uploads)
+	}
+
+	rr = upload("/v1/uploads?cat=pics")
+	var rejected rejection
+	if err := json.NewDecoder(rr.Body).Decode(&rejected); err != nil || rr.Code != http.StatusUnsupportedMediaType || rejected.Code != rejectUnsupported {
+		t.Errorf("expected PNG to be refused for a GIF only category, got: %d %+v", rr.Code, rejected)
+	}
+	if rr := upload("/v1/uploads"); rr.Code != http.StatusOK {
+		t.Fatalf("expected upload without a category to succeed, got: %d", rr.Code)
+	}
+
+	// The PNG uploaded without naming the category is caught when it's posted there.
+	rr = do("POST", "/v1/categories/pics/0", "application/json", bytes.NewBufferString(`{"subject": "look here", "content": "look", "attachments": [1]}`))
+	rejected = rejection{}
+	if err := json.NewDecoder(rr.Body).Decode(&rejected); err != nil || rr.Code != http.StatusBadRequest || rejected.Code != "attachment_not_allowed" {
+		t.Errorf("expected PNG to be refused on the post, got: %d %+v", rr.Code, rejected)
+	}
+	rr = do("POST", "/v1/categories/pics/0", "application/json", bytes.NewBufferString(`{"subject": "look here", "content": "look", "attachments": [1, 2]}`))
+	rejected = rejection{}
+	if err := json.NewDecoder(rr.Body).Decode(&rejected); err != nil || rr.Code != http.StatusBadRequest || rejected.Code != "too_many_attachments" {
+		t.Errorf("expected too many attachments to be refused, got: %d %+v", rr.Code, rejected)
+	}
+}
+
+func TestStorageQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiritchat-uploads-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage, err := media.NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), "old.png", bytes.NewBufferString("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	var pngFile bytes.Buffer
+	if err := png.Encode(&pngFile, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(pngFile.Len())
+
+	mockStore := &MockStore{postPolicy: &data.PostPolicy{Uploads: data.UploadPolicy{QuotaBytes: size}}}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{
+		Uploads: UploadOptions{Storage: storage, MaxImageBytes: 1 << 20, MaxStorageBytes: size * 2},
+	})
+
+	client := newTestClient(server, mockAuth)
+	do := func(method string, route string, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, route, body)
+		req.Header.Set("Content-Type", contentType)
+		return client.send(req)
+	}
+	upload := func(route string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("file", "upload.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(pngFile.Bytes())
+		form.Close()
+		return do("POST", route, form.FormDataContentType(), &body)
+	}
+	post := func(attachments string) *httptest.ResponseRecorder {
+		return do("POST", "/v1/categories/pics/0", "application/json", bytes.NewBufferString(
+			`{"subject": "look here", "content": "look", "attachments": `+attachments+`}`,
+		))
+	}
+
+	if rr := upload("/v1/uploads?cat=pics"); rr.Code != http.StatusOK {
+		t.Fatalf("expected upload within quota to succeed, got: %d", rr.Code)
+	}
+	// The mock counts every upload against the category.
+	rr := upload("/v1/uploads?cat=pics")
+	var rejected rejection
+	if err := json.NewDecoder(rr.Body).Decode(&rejected); err != nil || rr.Code != http.StatusInsufficientStorage || rejected.Code != rejectQuota {
+		t.Errorf("expected upload over the category's quota to be refused, got: %d %+v", rr.Code, rejected)
+	}
+	if rr := upload("/v1/uploads"); rr.Code != http.StatusOK {
+		t.Fatalf("expected upload without a category to succeed, got: %d", rr.Code)
+	}
+	if rr := post("[2]"); rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("expected post over the category's quota to be refused, got: %d", rr.Code)
+	}
+	if rr := upload("/v1/uploads"); rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("expected upload over the server's quota to be refused, got: %d", rr.Code)
+	}
+
+	mockStore.postPolicy.Uploads.PruneOldest = true
+	mockStore.pruned = []*data.Attachment{{ID: 9, File: "old.png"}}
+	if rr := post("[2]"); rr.Code != http.StatusOK {
+		t.Fatalf("expected post to prune rather than be refused, got: %d", rr.Code)
+	}
+	if mockStore.pruneBudget != size {
+		t.Errorf("expected category pruned to its quota, got %d", mockStore.pruneBudget)
+	}
+	if _, err := storage.Open(context.Background(), "old.png"); err != media.ErrNotFound {
+		t.Errorf("expected pruned file to be removed, got: %v", err)
+	}
+
+	rr = do("GET", "/v1/admin/storage", "", nil)
+	var usage struct {
+		TotalBytes int64 `json:"totalBytes"`
+		QuotaBytes int64 `json:"quotaBytes"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&usage); err != nil || usage.TotalBytes != size*2 || usage.QuotaBytes != size*2 {
+		t.Errorf("expected storage usage, got: %d %+v %v", rr.Code, usage, err)
+	}
+}
+
+func TestAttachmentFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiritchat-uploads-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage, err := media.NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moderator := &auth.UserData{Username: "mod", Email: "mod@gmail.com", IsVerified: true, Roles: []auth.Role{auth.RoleModerator}}
+	mockStore := &MockStore{attachments: []*data.Attachment{{ID: 1, Kind: data.AttachmentImage}, {ID: 2, Kind: data.AttachmentImage}}}
+	mockAuth := &MockAuth{user: moderator}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{
+		Uploads: UploadOptions{Storage: storage},
+	})
+
+	client := newTestClient(server, mockAuth)
+
+	rr := client.do("POST", "/v1/categories/pics/0", `{"subject": "look here", "content": "look", "attachments": [1, 2], "spoilers": [2], "nsfw": [1, 2]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected post with spoilers to succeed, got: %d", rr.Code)
+	}
+	expected := []data.AttachmentRef{{ID: 1, NSFW: true}, {ID: 2, Spoiler: true, NSFW: true}}
+	if len(mockStore.postAttachments) != 2 || mockStore.postAttachments[0] != expected[0] || mockStore.postAttachments[1] != expected[1] {
+		t.Errorf("expected attachments posted with their flags, got %+v", mockStore.postAttachments)
+	}
+	if rr := client.do("POST", "/v1/categories/pics/0", `{"subject": "look here", "content": "look", "attachments": [1], "spoilers": [2]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected spoilering another post's attachment to be refused, got: %d", rr.Code)
+	}
+
+	if rr := client.do("PUT", "/v1/admin/attachments/1/flags", `{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected flags to be required, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/admin/attachments/9/flags", `{"spoiler": true}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected missing attachment to 404, got: %d", rr.Code)
+	}
+	if rr := client.do("PUT", "/v1/admin/attachments/1/flags", `{"spoiler": true}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected moderator to force a spoiler, got: %d", rr.Code)
+	}
+	if !mockStore.attachments[0].Spoiler || mockStore.attachments[0].NSFW {
+		t.Errorf("expected only the spoiler flag set, got %+v", mockStore.attachments[0])
+	}
+
+	rr = client.do("GET", "/v1/media/"+data.SpoilerPreview, "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("expected spoiler placeholder to be served, got: %d", rr.Code)
+	}
+	if _, err := png.Decode(rr.Body); err != nil {
+		t.Errorf("expected placeholder to be a PNG, got %v", err)
+	}
+}
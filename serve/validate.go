@@ -0,0 +1,174 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+)
+
+// postCheck is the outcome of one of the checks a post has to pass to be written.
+type postCheck struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	// Code clients can give their own explanation for, on failed content checks.
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// postValidation reports which checks a post would fail, and whether it would be accepted at all.
+type postValidation struct {
+	Valid  bool         `json:"valid"`
+	Checks []*postCheck `json:"checks"`
+}
+
+// record adds the check's outcome, failing it with the error's message unless err is nil.
+func (report *postValidation) record(check string, err error) {
+	result := &postCheck{Check: check, Passed: err == nil}
+	if err != nil {
+		result.Code = rejectionCodes[err]
+		result.Message = err.Error()
+		report.Valid = false
+	}
+	report.Checks = append(report.Checks, result)
+}
+
+/*
+handleValidatePost handles a POST request to check a post without writing it, running the same checks
+posting would and reporting each, so clients can show what's wrong before it's submitted.
+Nothing is written and no cooldowns are started. Bot heuristics and network reputation aren't
+reported, they'd tell spammers what to change.
+*/
+func (server *Server) handleValidatePost(ctx context.Context, req *request, res *response) {
+	params, err := getReplyParameters(req)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	incomingReply, err := getIncomingReply(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return
+	}
+	report := &postValidation{Valid: true}
+
+	var unverified error
+	if params.isThread() && !req.user.IsVerified {
+		unverified = errUnverifiedThread
+	}
+	report.record("account", unverified)
+
+	category, err := server.store.GetCategory(ctx, params.categoryTag)
+	if err != nil && !errors.Is(err, data.ErrNotFound) {
+		res.Fail("Failed to get category", err)
+		return
+	}
+	if err != nil {
+		report.record("category", errors.New("no such category"))
+	} else if category.Archived {
+		report.record("category", data.ErrCategoryArchived)
+	} else {
+		report.record("category", nil)
+	}
+
+	if !params.isThread() {
+		thread, err := server.store.GetPostByNumber(ctx, params.categoryTag, params.threadNumber)
+		if err != nil && !errors.Is(err, data.ErrNotFound) {
+			res.Fail("Failed to get thread", err)
+			return
+		}
+		if err != nil || thread.IsReply() {
+			report.record("thread", errors.New("no such thread"))
+		} else if thread.Locked {
+			report.record("thread", data.ErrThreadLocked)
+		} else {
+			report.record("thread", nil)
+		}
+	}
+
+	policy, err := server.store.GetPostPolicy(ctx, params.categoryTag)
+	if err != nil {
+		if !errors.Is(err, data.ErrNotFound) {
+			res.Fail("Failed to get post policy", err)
+			return
+		}
+		policy = &data.PostPolicy{}
+	}
+	content := incomingReply.Sanitize(params.isThread(), policy)
+	report.record("content", content)
+
+	// Links can only be checked in content that's been sanitized.
+	var links error
+	if content == nil {
+		links = server.checkLinks(ctx, incomingReply, false)
+		if links != nil && !errors.Is(links, errBlockedDomain) {
+			res.Fail("Failed to check links", links)
+			return
+		}
+	}
+	report.record("links", links)
+
+	var attachments error
+	if len(incomingReply.Attachments) > 0 && server.uploads.Storage == nil {
+		attachments = errAttachmentsDisabled
+	} else {
+		attachments = server.checkAttachments(ctx, params.categoryTag, &policy.Uploads, incomingReply.Attachments)
+		if attachments != nil && !errors.Is(attachments, data.ErrAttachmentUnavailable) &&
+			!errors.Is(attachments, errAttachmentNotAllowed) && !errors.Is(attachments, errCategoryQuota) {
+			res.Fail("Failed to check attachments", attachments)
+			return
+		}
+	}
+	report.record("attachments", attachments)
+
+	identity := data.IdentityFrom(ctx)
+
+	var capcode error
+	if len(incomingReply.Capcode) > 0 {
+		role := auth.Role(incomingReply.Capcode)
+		if (role != auth.RoleModerator && role != auth.RoleAdmin) || !server.canUseRole(req, role) {
+			capcode = errCapcodeNotAllowed
+		}
+	}
+	report.record("capcode", capcode)
+
+	banned, err := server.store.IsBanned(ctx, identity.PosterHashes()...)
+	if err != nil {
+		res.Fail("Failed to check bans", err)
+		return
+	}
+	var ban error
+	if banned {
+		ban = errBannedPoster
+	}
+	report.record("ban", ban)
+
+	mustAcceptRules, err := server.store.MustAcceptRules(ctx, params.categoryTag, identity.PosterHashes()...)
+	if err != nil {
+		res.Fail("Failed to check rules acceptance", err)
+		return
+	}
+	var rules error
+	if mustAcceptRules && server.requireRulesAcceptance && !incomingReply.AcceptedRules {
+		rules = errRulesNotAccepted
+	}
+	report.record("rules", rules)
+
+	var limit error
+	if server.limiter != nil {
+		limited, err := server.isRateLimited(ctx, req.ip, identity, true)
+		if err != nil {
+			res.Respond(http.StatusServiceUnavailable, nil, genericFailMessage)
+			log.Printf("Failed to check post rate limit: %s", err)
+			return
+		}
+		if limited {
+			limit = errPostCooldown
+		}
+	}
+	report.record("rateLimit", limit)
+
+	res.Respond(http.StatusOK, report, "")
+}
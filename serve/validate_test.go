@@ -0,0 +1,103 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestValidatePost(t *testing.T) {
+	tests := map[string]struct {
+		store   *MockStore
+		limited bool
+		route   string
+		body    string
+		// Checks expected to fail, every other check should pass.
+		failed map[string]string
+	}{
+		"Valid reply": {
+			store: &MockStore{getCategory: &data.Category{Tag: "tech"}, getPost: &data.Post{Num: 1}},
+			route: "/v1/categories/tech/1/validate",
+			body:  `{"content": "hello!"}`,
+		},
+		"Valid thread": {
+			store: &MockStore{getCategory: &data.Category{Tag: "tech"}},
+			route: "/v1/categories/tech/0/validate",
+			body:  `{"subject": "hello there", "content": "hello!"}`,
+		},
+		"Everything wrong": {
+			store:   &MockStore{getCategory: &data.Category{Tag: "tech", Archived: true}, getPost: &data.Post{Num: 1, Locked: true}, banned: true},
+			limited: true,
+			route:   "/v1/categories/tech/1/validate",
+			body:    `{"content": "", "capcode": "admin"}`,
+			failed: map[string]string{
+				"category":  "",
+				"thread":    "",
+				"content":   "",
+				"capcode":   "",
+				"ban":       "",
+				"rateLimit": "",
+			},
+		},
+		"Missing thread": {
+			store:  &MockStore{getCategory: &data.Category{Tag: "tech"}, getPost: &data.Post{Num: 2, Parent: 1}},
+			route:  "/v1/categories/tech/2/validate",
+			body:   `{"content": "hello!"}`,
+			failed: map[string]string{"thread": ""},
+		},
+		"Rejected content": {
+			store:  &MockStore{getCategory: &data.Category{Tag: "tech"}, getPost: &data.Post{Num: 1}, postPolicy: &data.PostPolicy{RejectLinkOnly: true}},
+			route:  "/v1/categories/tech/1/validate",
+			body:   `{"content": "https://example.com"}`,
+			failed: map[string]string{"content": "link_only"},
+		},
+	}
+
+	for testName, test := range tests {
+		test := test
+		t.Run(testName, func(t *testing.T) {
+			limiter := &MockLimiter{limited: test.limited}
+			mockAuth := &MockAuth{user: &auth.UserData{Username: "test user", Email: "test@gmail.com", IsVerified: true}}
+			server := CreateTestServer(test.store, mockAuth, ServerOptions{
+				PostCooldownSeconds: 30,
+				RateLimiter:         limiter,
+			})
+
+			req := httptest.NewRequest("POST", test.route, bytes.NewReader([]byte(test.body)))
+			req.Header.Add("Authorization", "ok")
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got: %d", rr.Code)
+			}
+
+			var report postValidation
+			err := json.NewDecoder(rr.Body).Decode(&report)
+			if err != nil {
+				t.Fatalf("expected a valid report, got: %v", err)
+			}
+			if report.Valid != (len(test.failed) == 0) {
+				t.Errorf("expected valid %v, got %v", len(test.failed) == 0, report.Valid)
+			}
+			for _, check := range report.Checks {
+				code, shouldFail := test.failed[check.Check]
+				if check.Passed == shouldFail {
+					t.Errorf("expected %s check passed %v, got: %+v", check.Check, !shouldFail, check)
+				}
+				if shouldFail && check.Code != code {
+					t.Errorf("expected %s check code %q, got: %q", check.Check, code, check.Code)
+				}
+			}
+			if limiter.consumed != 0 {
+				t.Errorf("expected no cooldown to be started, started %d", limiter.consumed)
+			}
+			if test.store.author != nil {
+				t.Error("expected nothing to be written")
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"spiritchat/data"
+	"strconv"
+)
+
+// handleGetWebhooks handles a GET request for every webhook.
+func (server *Server) handleGetWebhooks(ctx context.Context, req *request, res *response) {
+	hooks, err := server.store.GetWebhooks(ctx)
+	if err != nil {
+		res.Fail("Failed to get webhooks", err)
+		return
+	}
+	res.Respond(http.StatusOK, hooks, "")
+}
+
+// incomingWebhook reads and checks a webhook from the request, with its category's slug resolved to a tag.
+func (server *Server) incomingWebhook(ctx context.Context, req *request, res *response) *data.Webhook {
+	incoming, err := getIncomingWebhook(req.rawRequest.Body)
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return nil
+	}
+	err = incoming.Sanitize()
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, err.Error())
+		return nil
+	}
+
+	hook := incoming.webhook()
+	if len(incoming.Cat) > 0 {
+		hook.Cat, err = server.categoryTag(ctx, incoming.Cat)
+		if err != nil {
+			res.Fail("Failed to resolve category slug", err)
+			return nil
+		}
+	}
+	return hook
+}
+
+// handleCreateWebhook handles a POST request adding a webhook, sent events from every category or just one.
+func (server *Server) handleCreateWebhook(ctx context.Context, req *request, res *response) {
+	hook := server.incomingWebhook(ctx, req, res)
+	if hook == nil {
+		return
+	}
+
+	err := server.store.WriteWebhook(ctx, hook)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such category")
+			return
+		}
+		res.Fail("Failed to write webhook", err)
+		return
+	}
+	log.Printf("Webhook %d created by %s", hook.ID, req.user.Email)
+	res.Respond(http.StatusOK, hook, "")
+}
+
+// handleUpdateWebhook handles a PUT request replacing a webhook.
+func (server *Server) handleUpdateWebhook(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid webhook ID")
+		return
+	}
+	hook := server.incomingWebhook(ctx, req, res)
+	if hook == nil {
+		return
+	}
+
+	hook.ID = id
+	err = server.store.UpdateWebhook(ctx, hook)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, "no such webhook or category")
+			return
+		}
+		res.Fail("Failed to update webhook", err)
+		return
+	}
+	log.Printf("Webhook %d updated by %s", hook.ID, req.user.Email)
+	res.Respond(http.StatusOK, hook, "")
+}
+
+// handleRemoveWebhook handles a DELETE request dropping a webhook.
+func (server *Server) handleRemoveWebhook(ctx context.Context, req *request, res *response) {
+	id, err := strconv.Atoi(req.params.ByName("id"))
+	if err != nil {
+		res.Respond(http.StatusBadRequest, nil, "invalid webhook ID")
+		return
+	}
+
+	err = server.store.RemoveWebhook(ctx, id)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			res.Respond(http.StatusNotFound, nil, err.Error())
+			return
+		}
+		res.Fail("Failed to remove webhook", err)
+		return
+	}
+	log.Printf("Webhook %d removed by %s", id, req.user.Email)
+	res.Respond(http.StatusOK, ok{Message: "removed"}, "")
+}
@@ -0,0 +1,68 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"spiritchat/auth"
+	"spiritchat/data"
+	"testing"
+)
+
+func TestWebhookAdmin(t *testing.T) {
+	mockStore := &MockStore{}
+	mockAuth := &MockAuth{user: staffUser(auth.RoleAdmin)}
+	server := CreateTestServer(mockStore, mockAuth, ServerOptions{})
+
+	client := newTestClient(server, mockAuth)
+
+	for _, body := range []string{
+		`{"url": "ftp://hooks.example/x", "enabled": true}`,
+		`{"url": "https://hooks.example/x", "events": ["report.filed"], "enabled": true}`,
+		`{"url": "https://hooks.example/x", "format": "slack", "enabled": true}`,
+		`{"url": "https://hooks.example/x", "format": "template", "enabled": true}`,
+		`{"url": "https://hooks.example/x", "format": "template", "template": "{{ .Nope ", "enabled": true}`,
+	} {
+		if rr := client.do("POST", "/v1/admin/webhooks", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected a bad request, got: %d", body, rr.Code)
+		}
+	}
+	if rr := client.do("POST", "/v1/admin/webhooks", `{"url": "https://hooks.example/x", "cat": "missing", "enabled": true}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a webhook on a missing category to 404, got: %d", rr.Code)
+	}
+
+	rr := client.do("POST", "/v1/admin/webhooks", `{"url": "https://discord.example/api/webhooks/1", "cat": "tech",
+		"events": ["post.created", "post.created"], "format": "discord", "secret": "shh", "enabled": true}`)
+	if rr.Code != http.StatusOK || len(mockStore.webhooks) != 1 {
+		t.Fatalf("expected the webhook to be created, got: %d", rr.Code)
+	}
+	var hook data.Webhook
+	if err := json.NewDecoder(rr.Body).Decode(&hook); err != nil {
+		t.Fatal(err)
+	}
+	if hook.Cat != "tech" || len(hook.Events) != 1 || hook.Format != data.WebhookDiscord || !hook.Signed || len(hook.Secret) > 0 {
+		t.Errorf("expected a signed Discord webhook on tech without its secret shown, got: %+v", hook)
+	}
+
+	rr = client.do("PUT", "/v1/admin/webhooks/1", `{"url": "https://hooks.example/y", "format": "template", "template": "{{ json .Event }}"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the webhook to be updated, got: %d", rr.Code)
+	}
+	if updated := mockStore.webhooks[0]; updated.Format != data.WebhookTemplate || updated.Enabled || updated.Secret != "shh" {
+		t.Errorf("expected a disabled template webhook keeping its secret, got: %+v", updated)
+	}
+	if rr := client.do("PUT", "/v1/admin/webhooks/2", `{"url": "https://hooks.example/y"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected updating a missing webhook to 404, got: %d", rr.Code)
+	}
+
+	if rr := client.do("DELETE", "/v1/admin/webhooks/1", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the webhook to be removed, got: %d", rr.Code)
+	}
+	if rr := client.do("DELETE", "/v1/admin/webhooks/1", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected removing it again to 404, got: %d", rr.Code)
+	}
+
+	mockAuth.user.Roles = nil
+	if rr := client.do("GET", "/v1/admin/webhooks", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected users not to see webhooks, got: %d", rr.Code)
+	}
+}
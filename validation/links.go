@@ -0,0 +1,123 @@
+package validation
+
+import (
+	"errors"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var ErrUnsafeLink = errors.New("links must be http or https addresses")
+
+// Punctuation ending a sentence rather than a link, like the full stop after "see example.com/page."
+const trailingPunctuation = ".,;:!?'\""
+
+// Link is a web address found in post content.
+type Link struct {
+	// The link as it appears in the content, HTML escaped like the content is.
+	Text string `json:"text"`
+	// Where the link goes, always with an http or https scheme.
+	URL  string `json:"url"`
+	Host string `json:"host"`
+}
+
+/*
+ValidateLink checks an address is an http or https URL with a host, adding a scheme to addresses
+starting with www. Addresses with user info, like https://example.com@evil.example, are refused,
+since they read as going somewhere they don't. Returns the URL, or ErrUnsafeLink.
+*/
+func ValidateLink(address string) (*url.URL, error) {
+	address = strings.TrimSpace(address)
+	if strings.HasPrefix(strings.ToLower(address), "www.") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.User != nil || len(u.Hostname()) == 0 {
+		return nil, ErrUnsafeLink
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ErrUnsafeLink
+	}
+	return u, nil
+}
+
+// trimLink drops punctuation after a link, and closing brackets it doesn't open, as in "(see example.com)".
+func trimLink(text string) string {
+	for len(text) > 0 {
+		last := text[len(text)-1]
+		if strings.IndexByte(trailingPunctuation, last) >= 0 {
+			text = text[:len(text)-1]
+			continue
+		}
+		if last == ')' && strings.Count(text, "(") < strings.Count(text, ")") {
+			text = text[:len(text)-1]
+			continue
+		}
+		return text
+	}
+	return text
+}
+
+// parseLink returns the link a match of the link pattern is, false if it isn't safe.
+func parseLink(match string) (Link, bool) {
+	text := trimLink(html.UnescapeString(match))
+	u, err := ValidateLink(text)
+	if err != nil {
+		return Link{}, false
+	}
+	return Link{Text: html.EscapeString(text), URL: u.String(), Host: strings.ToLower(u.Hostname())}, true
+}
+
+// FindLinks returns the safe links in sanitized content, in the order they appear, skipping any that aren't.
+func FindLinks(content string) []Link {
+	var links []Link
+	for _, match := range link.FindAllString(content, -1) {
+		if found, ok := parseLink(match); ok {
+			links = append(links, found)
+		}
+	}
+	return links
+}
+
+/*
+RemoveLinks returns sanitized content without the links remove returns true for, leaving everything else as it was.
+Returns ErrLinkOnly if removing them would leave nothing worth reading.
+*/
+func RemoveLinks(content string, remove func(Link) bool) (string, error) {
+	var kept strings.Builder
+	last := 0
+	for _, bounds := range link.FindAllStringIndex(content, -1) {
+		found, ok := parseLink(content[bounds[0]:bounds[1]])
+		if !ok || !remove(found) {
+			continue
+		}
+		kept.WriteString(content[last:bounds[0]])
+		last = bounds[0] + len(found.Text)
+	}
+	if last == 0 {
+		return content, nil
+	}
+	kept.WriteString(content[last:])
+	if !wordChars.MatchString(kept.String()) {
+		return "", ErrLinkOnly
+	}
+	return strings.TrimSpace(kept.String()), nil
+}
+
+// Domains are matched against link hosts, *. matching every subdomain.
+var domainPattern = regexp.MustCompile(`^(?:\*\.)?(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)*[a-z0-9](?:[a-z0-9-]*[a-z0-9])?$`)
+
+const maxDomainLen = 253
+
+var ErrInvalidDomain = errors.New("domains must be like example.com, or *.example.com for it and every subdomain")
+
+// ValidateDomain checks a domain to block links to, lowercasing it. Returns ErrInvalidDomain if it isn't one.
+func ValidateDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if len(domain) > maxDomainLen || !domainPattern.MatchString(domain) {
+		return "", ErrInvalidDomain
+	}
+	return domain, nil
+}
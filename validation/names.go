@@ -0,0 +1,91 @@
+package validation
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DefaultReservedNames can't be taken as usernames or display names unless others are configured.
+var DefaultReservedNames = []string{
+	"admin", "administrator", "mod", "moderator", "staff", "support", "system", "root", "official", "spiritchat",
+}
+
+// Letters that look like others, mapped to the ASCII letter they pass for.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't',
+	'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ї': 'i', 'ј': 'j', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'һ': 'h', 'ɡ': 'g',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+	'χ': 'x', 'ω': 'w',
+	// Digits and symbols standing in for letters
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '@': 'a', '$': 's', '|': 'l', '!': 'l',
+	// Letters that are hard to tell apart in most fonts
+	'i': 'l', 'ı': 'l', 'ł': 'l', 'ʟ': 'l',
+}
+
+// Accented Latin letters, by the letter they're accenting.
+var accented = map[rune]string{
+	'a': "àáâãäåāăą", 'c': "çćĉċč", 'd': "ďđ", 'e': "èéêëēĕėęě", 'g': "ĝğġģ", 'h': "ĥħ", 'i': "ìíîïĩīĭįİ",
+	'j': "ĵ", 'k': "ķ", 'l': "ĺļľŀ", 'n': "ñńņňŉ", 'o': "òóôõöøōŏő", 'r': "ŕŗř", 's': "śŝşš", 't': "ţťŧ",
+	'u': "ùúûüũūŭůűų", 'w': "ŵ", 'y': "ýÿŷ", 'z': "źżž",
+}
+
+func init() {
+	for letter, variants := range accented {
+		// Accented letters look like whatever their letter looks like.
+		if lookalike, ok := confusables[letter]; ok {
+			letter = lookalike
+		}
+		for _, variant := range variants {
+			if _, ok := confusables[variant]; !ok {
+				confusables[variant] = letter
+			}
+		}
+	}
+}
+
+/*
+skeleton reduces a name to what it looks like, so names that only differ by case, lookalike letters, accents,
+invisible characters or punctuation between letters come out the same.
+*/
+func skeleton(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		// Fullwidth forms, like ａｄｍｉｎ.
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if lookalike, ok := confusables[r]; ok {
+			r = lookalike
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// ReservedNames are names nobody can take, or anything that passes for one.
+type ReservedNames struct {
+	skeletons map[string]bool
+}
+
+// NewReservedNames reserves the names and their lookalikes.
+func NewReservedNames(names []string) *ReservedNames {
+	reserved := &ReservedNames{skeletons: make(map[string]bool, len(names))}
+	for _, name := range names {
+		if s := skeleton(name); len(s) > 0 {
+			reserved.skeletons[s] = true
+		}
+	}
+	return reserved
+}
+
+// Reserves returns true if the name is reserved or looks like a reserved name. Nil reserves nothing.
+func (reserved *ReservedNames) Reserves(name string) bool {
+	if reserved == nil {
+		return false
+	}
+	return reserved.skeletons[skeleton(name)]
+}
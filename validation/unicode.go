@@ -0,0 +1,133 @@
+package validation
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Latin letters and the letter they compose to with a following combining mark, in pairs.
+var latinCompositions = map[rune]string{
+	'\u0300': "aàeèiìnǹoòuùAÀEÈIÌNǸOÒUÙ",
+	'\u0301': "aácćeégǵiílĺnńoórŕsśuúyýzźAÁCĆEÉGǴIÍLĹNŃOÓRŔSŚUÚYÝZŹ",
+	'\u0302': "aâcĉeêgĝhĥiîjĵoôsŝuûwŵyŷAÂCĈEÊGĜHĤIÎJĴOÔSŜUÛWŴYŶ",
+	'\u0303': "aãiĩnñoõuũAÃIĨNÑOÕUŨ",
+	'\u0304': "aāeēiīoōuūyȳAĀEĒIĪOŌUŪYȲ",
+	'\u0306': "aăeĕgğiĭoŏuŭAĂEĔGĞIĬOŎUŬ",
+	'\u0307': "aȧcċeėgġoȯzżAȦCĊEĖGĠIİOȮZŻ",
+	'\u0308': "aäeëiïoöuüyÿAÄEËIÏOÖUÜYŸ",
+	'\u030a': "aåuůAÅUŮ",
+	'\u030b': "oőuűOŐUŰ",
+	'\u030c': "aǎcčdďeěgǧhȟiǐjǰkǩlľnňoǒrřsštťuǔzžAǍCČDĎEĚGǦHȞIǏKǨLĽNŇOǑRŘSŠTŤUǓZŽ",
+	'\u0327': "cçeȩgģkķlļnņrŗsştţCÇEȨGĢKĶLĻNŅRŖSŞTŢ",
+	'\u0328': "aąeęiįoǫuųAĄEĘIĮOǪUŲ",
+}
+
+type composition struct {
+	base rune
+	mark rune
+}
+
+var composed = make(map[composition]rune)
+
+// Homoglyphs of ASCII letters, folded to the letter when matching. Precomposed Latin letters are added on init.
+var homoglyphs = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't',
+	'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'һ': 'h', 'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+	'χ': 'x', 'ζ': 'z', 'η': 'n',
+	// Latin lookalikes
+	'ı': 'i', 'ſ': 's', 'ɑ': 'a', 'ɡ': 'g', 'ʏ': 'y',
+}
+
+func init() {
+	for mark, pairs := range latinCompositions {
+		letters := []rune(pairs)
+		for i := 0; i+1 < len(letters); i += 2 {
+			composed[composition{letters[i], mark}] = letters[i+1]
+			homoglyphs[letters[i+1]] = unicode.ToLower(letters[i])
+		}
+	}
+}
+
+/*
+isInvisible reports whether r is a zero-width or bidi control character that's stripped from text.
+Zero-width joiners are kept by stripInvisible when they join non-ASCII characters, where emoji and
+some scripts need them.
+*/
+func isInvisible(r rune) bool {
+	switch r {
+	case '\u00ad', '\u180e', '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff':
+		return true
+	}
+	// Bidi embeddings, overrides and isolates can reorder the text around them.
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// stripInvisible drops zero-width and bidi control characters from text.
+func stripInvisible(text string) string {
+	runes := []rune(text)
+	var b strings.Builder
+	b.Grow(len(text))
+	for i, r := range runes {
+		if !isInvisible(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if (r == '\u200c' || r == '\u200d') && i > 0 && i+1 < len(runes) && runes[i-1] > unicode.MaxASCII && runes[i+1] > unicode.MaxASCII {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+/*
+composeLatin composes Latin letters followed by a combining mark into the precomposed letter,
+as NFC normalization would, so "e" and a combining acute match and render as "é".
+*/
+func composeLatin(text string) string {
+	runes := []rune(text)
+	out := make([]rune, 0, len(runes))
+	for _, r := range runes {
+		if len(out) > 0 {
+			if c, ok := composed[composition{out[len(out)-1], r}]; ok {
+				out[len(out)-1] = c
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// normalize composes Latin letters and strips invisible characters, done to all text before it's escaped.
+func normalize(text string) string {
+	return composeLatin(stripInvisible(text))
+}
+
+/*
+FoldHomoglyphs lowercases text and folds lookalike letters, accented Latin letters, fullwidth forms
+and combining marks down to plain ASCII where it can, for matching text against filters. The result
+is only for matching and shouldn't be displayed.
+*/
+func FoldHomoglyphs(text string) string {
+	text = normalize(text)
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range text {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		// Fullwidth ASCII
+		if r >= '\uff01' && r <= '\uff5e' {
+			r -= 0xFEE0
+		}
+		r = unicode.ToLower(r)
+		if folded, ok := homoglyphs[r]; ok {
+			r = folded
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -6,15 +6,22 @@ import (
 	"html"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
 const maxContentLen = 300
 
+// MaxContentLen is the most characters post content can have.
+const MaxContentLen = maxContentLen
+
 const minContentLen = 2
 
 const minSubjectLen = 5
 const maxSubjectLen = 80
 
+// MaxSubjectLen is the most characters a thread subject can have.
+const MaxSubjectLen = maxSubjectLen
+
 var ErrInvalidContentLen = fmt.Errorf(
 	"content must be between %d and %d characters",
 	minContentLen,
@@ -26,9 +33,125 @@ var ErrInvalidSubjectLen = fmt.Errorf(
 	maxSubjectLen,
 )
 
+const minReportLen = 3
+const maxReportLen = 200
+
+var ErrInvalidReportLen = fmt.Errorf(
+	"report reason must be between %d and %d characters",
+	minReportLen,
+	maxReportLen,
+)
+
+const minCategoryRuleLen = 1
+const maxCategoryRuleLen = 500
+
+var ErrInvalidCategoryRuleLen = fmt.Errorf(
+	"each rule must be between %d and %d characters",
+	minCategoryRuleLen,
+	maxCategoryRuleLen,
+)
+
+const maxCategoryNameLen = 32
+const maxCategoryDescriptionLen = 200
+
+var ErrInvalidCategoryNameLen = fmt.Errorf("category name must be between 1 and %d characters", maxCategoryNameLen)
+var ErrInvalidCategoryDescriptionLen = fmt.Errorf(
+	"category description must be at most %d characters",
+	maxCategoryDescriptionLen,
+)
+
+const maxMaskedWordLen = 50
+
+var ErrInvalidMaskedWordLen = fmt.Errorf("masked words must be between 1 and %d characters", maxMaskedWordLen)
+
+const maxPageTitleLen = 100
+const maxPageBodyLen = 20000
+
+var ErrInvalidPageSlug = errors.New("page slug must be 1 to 64 lowercase letters, numbers or dashes")
+var ErrInvalidCategorySlug = errors.New("category slug must be 1 to 32 lowercase letters, numbers or dashes")
+var ErrInvalidCategoryTag = errors.New("category tag must be 1 to 12 lowercase letters or numbers")
+var ErrReservedCategoryName = errors.New("that name is reserved")
+var ErrInvalidPageTitleLen = fmt.Errorf("page title must be between 1 and %d characters", maxPageTitleLen)
+var ErrInvalidPageBodyLen = fmt.Errorf("page body must be between 1 and %d characters", maxPageBodyLen)
+
+const minNoteLen = 1
+const maxNoteLen = 1000
+
+var ErrInvalidNoteLen = fmt.Errorf(
+	"note must be between %d and %d characters",
+	minNoteLen,
+	maxNoteLen,
+)
+
+const minMessageLen = 1
+const maxMessageLen = 2000
+
+var ErrInvalidMessageLen = fmt.Errorf(
+	"message must be between %d and %d characters",
+	minMessageLen,
+	maxMessageLen,
+)
+
+const minComplaintLen = 10
+const maxComplaintLen = 5000
+
+var ErrInvalidComplaintLen = fmt.Errorf(
+	"complaint details must be between %d and %d characters",
+	minComplaintLen,
+	maxComplaintLen,
+)
+
+const maxComplainantNameLen = 100
+
+var ErrInvalidComplainantNameLen = fmt.Errorf("name must be between 1 and %d characters", maxComplainantNameLen)
+
+const maxDisplayNameLen = 32
+
+const minSearchLen = 2
+const maxSearchLen = 100
+
+var ErrInvalidSearchLen = fmt.Errorf(
+	"search must be between %d and %d characters",
+	minSearchLen,
+	maxSearchLen,
+)
+
+var ErrTooManyLinks = errors.New("too many links in your post")
+var ErrLinkOnly = errors.New("posts need some text besides links")
+
+// Links are web addresses, with a scheme or starting www.
+var link = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// Anything worth reading besides links and punctuation.
+var wordChars = regexp.MustCompile(`[\pL\pN]`)
+
 var ErrInvalidEmail = errors.New("that doesn't look like an email")
 var ErrInvalidUsername = errors.New("username required, > 3 characters")
+var ErrReservedUsername = errors.New("that name is reserved, or looks too much like one that is")
 var ErrInvalidPassword = errors.New("password required")
+var ErrInvalidPosterHash = errors.New("invalid poster ID")
+var ErrInvalidDisplayName = fmt.Errorf("display name must be at most %d characters", maxDisplayNameLen)
+var ErrInvalidTimezone = errors.New("timezone must be an IANA name like Europe/London")
+
+// Page slugs appear in URLs
+var pageSlug = regexp.MustCompile("^[a-z0-9-]{1,64}$")
+
+var categorySlug = regexp.MustCompile("^[a-z0-9-]{1,32}$")
+
+// Category tags are used raw in URLs, cache keys and logs.
+var categoryTag = regexp.MustCompile("^[a-z0-9]{1,12}$")
+
+// Names that would read as, or be mistaken for, part of the site rather than a category.
+var reservedCategoryNames = map[string]bool{
+	"admin": true, "api": true, "v1": true, "me": true, "new": true, "all": true, "search": true, "stats": true,
+	"media": true, "uploads": true, "config": true, "static": true, "modlog": true, "pages": true, "login": true,
+}
+
+// Poster hashes are hex SHA-256 digests
+var posterHash = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// Matches IANA timezone names, like UTC or America/Argentina/Buenos_Aires.
+var timezone = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+){0,2}$`)
 
 // Replace 3 or more manyNewlines, including possible spaces
 var manyNewlines = regexp.MustCompile("(\n\\s*){3,}")
@@ -36,15 +159,27 @@ var manyNewlines = regexp.MustCompile("(\n\\s*){3,}")
 // Replace one newline
 var newline = regexp.MustCompile(`\n`)
 
-// Replace all carriage returns with normal newlines
-var carriageReturns = regexp.MustCompile("\r\n")
+// Replace all carriage returns with normal newlines, including lone ones from old Mac clients
+var carriageReturns = regexp.MustCompile("\r\n?")
+
+/*
+Input longer than this many bytes per character allowed is rejected before sanitizing, so huge inputs don't go
+through every pass. It leaves room for multi-byte characters and whitespace that's collapsed or trimmed.
+*/
+const maxBytesPerChar = 16
+
+func tooLong(input string, maxLen int) bool {
+	return len(input) > maxLen*maxBytesPerChar
+}
 
 func sanitize(data string) string {
 	return strings.TrimSpace(
 		html.EscapeString(
-			strings.ToValidUTF8(
-				data,
-				"",
+			normalize(
+				strings.ToValidUTF8(
+					data,
+					"",
+				),
 			),
 		),
 	)
@@ -59,6 +194,9 @@ func ValidateReplySubject(subject string, isThread bool) (string, error) {
 	if !isThread {
 		return "", nil
 	}
+	if tooLong(subject, maxSubjectLen) {
+		return "", ErrInvalidSubjectLen
+	}
 
 	subject = newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(subject), ""), "")
 	runeLength := len([]rune(subject))
@@ -73,6 +211,9 @@ ValidateReplyContent validates a post's contents, returning the content sanitize
 the first argument, or a human-readable error message as the second.
 */
 func ValidateReplyContent(content string) (string, error) {
+	if tooLong(content, maxContentLen) {
+		return "", ErrInvalidContentLen
+	}
 	content = sanitize(content)
 	content = carriageReturns.ReplaceAllString(content, "\n")
 	content = manyNewlines.ReplaceAllString(content, "\n")
@@ -82,6 +223,216 @@ func ValidateReplyContent(content string) (string, error) {
 	return content, nil
 }
 
+// ValidateCategoryRule sanitizes one of a category's rules to a single line. Returns a human-readable error if it's too short or long.
+func ValidateCategoryRule(rule string) (string, error) {
+	if tooLong(rule, maxCategoryRuleLen) {
+		return "", ErrInvalidCategoryRuleLen
+	}
+	rule = newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(rule), " "), " ")
+	runeLength := len([]rune(rule))
+	if runeLength < minCategoryRuleLen || runeLength > maxCategoryRuleLen {
+		return "", ErrInvalidCategoryRuleLen
+	}
+	return rule, nil
+}
+
+// ValidateCategorySlug checks a category's URL slug is URL safe and not reserved. Returns a human-readable error if not.
+func ValidateCategorySlug(slug string) (string, error) {
+	if !categorySlug.MatchString(slug) {
+		return "", ErrInvalidCategorySlug
+	}
+	if reservedCategoryNames[slug] {
+		return "", ErrReservedCategoryName
+	}
+	return slug, nil
+}
+
+// ValidateCategoryTag checks a new category's tag is short, alphanumeric and not reserved. Returns a human-readable error if not.
+func ValidateCategoryTag(tag string) (string, error) {
+	if !categoryTag.MatchString(tag) {
+		return "", ErrInvalidCategoryTag
+	}
+	if reservedCategoryNames[tag] {
+		return "", ErrReservedCategoryName
+	}
+	return tag, nil
+}
+
+// ValidateCategoryName sanitizes a category's name to a single line. Returns a human-readable error if it's too short or long.
+func ValidateCategoryName(name string) (string, error) {
+	if tooLong(name, maxCategoryNameLen) {
+		return "", ErrInvalidCategoryNameLen
+	}
+	name = newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(name), " "), " ")
+	runeLength := len([]rune(name))
+	if runeLength < 1 || runeLength > maxCategoryNameLen {
+		return "", ErrInvalidCategoryNameLen
+	}
+	return name, nil
+}
+
+// ValidateCategoryDescription sanitizes a category's description to a single line. Returns a human-readable error if it's too long.
+func ValidateCategoryDescription(description string) (string, error) {
+	if tooLong(description, maxCategoryDescriptionLen) {
+		return "", ErrInvalidCategoryDescriptionLen
+	}
+	description = newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(description), " "), " ")
+	if len([]rune(description)) > maxCategoryDescriptionLen {
+		return "", ErrInvalidCategoryDescriptionLen
+	}
+	return description, nil
+}
+
+/*
+ValidateMaskedWord sanitizes a word masked in posts to a single lowercase line, escaped like post content so it
+matches it. Returns a human-readable error if it's too short or long.
+*/
+func ValidateMaskedWord(word string) (string, error) {
+	if tooLong(word, maxMaskedWordLen) {
+		return "", ErrInvalidMaskedWordLen
+	}
+	word = strings.ToLower(newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(word), " "), " "))
+	runeLength := len([]rune(word))
+	if runeLength < 1 || runeLength > maxMaskedWordLen {
+		return "", ErrInvalidMaskedWordLen
+	}
+	return word, nil
+}
+
+/*
+ValidateLinks checks sanitized content has at most maxLinks links, zero for no limit, and
+if rejectLinkOnly is set, that it isn't only links. Returns ErrTooManyLinks or ErrLinkOnly if not.
+*/
+func ValidateLinks(content string, maxLinks int, rejectLinkOnly bool) error {
+	links := link.FindAllStringIndex(content, -1)
+	if maxLinks > 0 && len(links) > maxLinks {
+		return ErrTooManyLinks
+	}
+	if rejectLinkOnly && len(links) > 0 && !wordChars.MatchString(link.ReplaceAllString(content, "")) {
+		return ErrLinkOnly
+	}
+	return nil
+}
+
+// ValidatePageSlug checks a page slug is URL safe. Returns a human-readable error if not.
+func ValidatePageSlug(slug string) (string, error) {
+	if !pageSlug.MatchString(slug) {
+		return "", ErrInvalidPageSlug
+	}
+	return slug, nil
+}
+
+/*
+ValidatePage sanitizes a page's title to a single line and its markdown body like post content,
+returning them or a human-readable error if either is too short or long.
+*/
+func ValidatePage(title string, body string) (string, string, error) {
+	if tooLong(title, maxPageTitleLen) {
+		return "", "", ErrInvalidPageTitleLen
+	}
+	if tooLong(body, maxPageBodyLen) {
+		return "", "", ErrInvalidPageBodyLen
+	}
+	title = newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(title), ""), "")
+	titleLength := len([]rune(title))
+	if titleLength < 1 || titleLength > maxPageTitleLen {
+		return "", "", ErrInvalidPageTitleLen
+	}
+	body = carriageReturns.ReplaceAllString(sanitize(body), "\n")
+	bodyLength := len([]rune(body))
+	if bodyLength < 1 || bodyLength > maxPageBodyLen {
+		return "", "", ErrInvalidPageBodyLen
+	}
+	return title, body, nil
+}
+
+// ValidateNote sanitizes a staff note like post content. Returns a human-readable error if it's too short or long.
+func ValidateNote(note string) (string, error) {
+	if tooLong(note, maxNoteLen) {
+		return "", ErrInvalidNoteLen
+	}
+	note = sanitize(note)
+	note = carriageReturns.ReplaceAllString(note, "\n")
+	note = manyNewlines.ReplaceAllString(note, "\n")
+	runeLength := len([]rune(note))
+	if runeLength < minNoteLen || runeLength > maxNoteLen {
+		return "", ErrInvalidNoteLen
+	}
+	return note, nil
+}
+
+// ValidateMessage sanitizes a private message like post content. Returns a human-readable error if it's too short or long.
+func ValidateMessage(message string) (string, error) {
+	if tooLong(message, maxMessageLen) {
+		return "", ErrInvalidMessageLen
+	}
+	message = sanitize(message)
+	message = carriageReturns.ReplaceAllString(message, "\n")
+	message = manyNewlines.ReplaceAllString(message, "\n")
+	runeLength := len([]rune(message))
+	if runeLength < minMessageLen || runeLength > maxMessageLen {
+		return "", ErrInvalidMessageLen
+	}
+	return message, nil
+}
+
+/*
+ValidateSearchQuery sanitizes a search query the same way post content is sanitized,
+so it matches stored posts. Returns a human-readable error if it's too short or long.
+*/
+func ValidateSearchQuery(query string) (string, error) {
+	if tooLong(query, maxSearchLen) {
+		return "", ErrInvalidSearchLen
+	}
+	query = newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(query), " "), " ")
+	runeLength := len([]rune(query))
+	if runeLength < minSearchLen || runeLength > maxSearchLen {
+		return "", ErrInvalidSearchLen
+	}
+	return query, nil
+}
+
+// ValidateReportReason sanitizes a report's reason. Returns a human-readable error if it's too short or long.
+func ValidateReportReason(reason string) (string, error) {
+	if tooLong(reason, maxReportLen) {
+		return "", ErrInvalidReportLen
+	}
+	reason = newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(reason), " "), " ")
+	runeLength := len([]rune(reason))
+	if runeLength < minReportLen || runeLength > maxReportLen {
+		return "", ErrInvalidReportLen
+	}
+	return reason, nil
+}
+
+// ValidateComplaint sanitizes the details of an abuse complaint like post content. Returns a human-readable error if they're too short or long.
+func ValidateComplaint(details string) (string, error) {
+	if tooLong(details, maxComplaintLen) {
+		return "", ErrInvalidComplaintLen
+	}
+	details = sanitize(details)
+	details = carriageReturns.ReplaceAllString(details, "\n")
+	details = manyNewlines.ReplaceAllString(details, "\n")
+	runeLength := len([]rune(details))
+	if runeLength < minComplaintLen || runeLength > maxComplaintLen {
+		return "", ErrInvalidComplaintLen
+	}
+	return details, nil
+}
+
+// ValidateComplainantName sanitizes the name a complaint is filed or signed under. Returns a human-readable error if it's empty or too long.
+func ValidateComplainantName(name string) (string, error) {
+	if tooLong(name, maxComplainantNameLen) {
+		return "", ErrInvalidComplainantNameLen
+	}
+	name = strings.TrimSpace(newline.ReplaceAllString(carriageReturns.ReplaceAllString(sanitize(name), " "), " "))
+	runeLength := len([]rune(name))
+	if runeLength < 1 || runeLength > maxComplainantNameLen {
+		return "", ErrInvalidComplainantNameLen
+	}
+	return name, nil
+}
+
 /*
 ValidateEmail is a very basic email check. Returns human readable error if issues found.
 */
@@ -95,14 +446,52 @@ func ValidateEmail(email string) (string, error) {
 	return email, nil
 }
 
-// ValidateUsername does a length check. Returns human readable errors if issues found.
-func ValidateUsername(username string) (string, error) {
+// ValidateUsername does a length check, and rejects reserved names and their lookalikes. Returns human readable errors if issues found.
+func ValidateUsername(username string, reserved *ReservedNames) (string, error) {
 	if len(username) < 3 {
 		return "", ErrInvalidUsername
 	}
+	if reserved.Reserves(username) {
+		return "", ErrReservedUsername
+	}
 	return username, nil
 }
 
+// ValidatePosterHash checks a poster ID is a hash, lowercasing it. Returns human readable errors if issues found.
+func ValidatePosterHash(hash string) (string, error) {
+	hash = strings.ToLower(hash)
+	if !posterHash.MatchString(hash) {
+		return "", ErrInvalidPosterHash
+	}
+	return hash, nil
+}
+
+/*
+ValidateDisplayName trims and length checks a display name, empty clears it, and rejects reserved names
+and their lookalikes. Returns human readable errors if issues found.
+*/
+func ValidateDisplayName(name string, reserved *ReservedNames) (string, error) {
+	if tooLong(name, maxDisplayNameLen) {
+		return "", ErrInvalidDisplayName
+	}
+	name = strings.TrimSpace(sanitize(name))
+	if strings.ContainsAny(name, "\n") || utf8.RuneCountInString(name) > maxDisplayNameLen {
+		return "", ErrInvalidDisplayName
+	}
+	if reserved.Reserves(html.UnescapeString(name)) {
+		return "", ErrReservedUsername
+	}
+	return name, nil
+}
+
+// ValidateTimezone checks a timezone looks like an IANA name, empty clears it. Returns human readable errors if issues found.
+func ValidateTimezone(name string) (string, error) {
+	if len(name) > 0 && (len(name) > 64 || !timezone.MatchString(name)) {
+		return "", ErrInvalidTimezone
+	}
+	return name, nil
+}
+
 // ValidatePassword does a length check. Returns human readable errors if issues found.
 func ValidatePassword(password string) (string, error) {
 	if len(password) < 1 {
@@ -1,8 +1,10 @@
 package validation
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 // Generates string of "a" n times
@@ -72,6 +74,26 @@ func TestCheckSubject(t *testing.T) {
 	}
 }
 
+func TestCheckSearchQuery(t *testing.T) {
+	_, err := ValidateSearchQuery(genStr(minSearchLen-1, "a"))
+	if err == nil {
+		t.Error("expected an err string")
+	}
+
+	_, err = ValidateSearchQuery(genStr(maxSearchLen+1, "a"))
+	if err == nil {
+		t.Error("expected an err string")
+	}
+
+	ret, err := ValidateSearchQuery("  <cats>\r\ndogs  ")
+	if err != nil {
+		t.Error("expected no err string")
+	}
+	if ret != "&lt;cats&gt; dogs" {
+		t.Errorf("expected escaped single line query, got %s", ret)
+	}
+}
+
 // Test sanitizing a post's content.
 func TestCheckContent(t *testing.T) {
 	onMin := genStr(minContentLen, "a")
@@ -124,6 +146,405 @@ func TestCheckContent(t *testing.T) {
 	}
 }
 
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"cafe\u0301":                 "café",
+		"zero\u200bwidth\ufeff":      "zerowidth",
+		"evil\u202etxt.exe":          "eviltxt.exe",
+		"isolate\u2066d\u2069":       "isolated",
+		"soft\u00adhyphen":           "softhyphen",
+		"a\u200db":                   "ab",
+		"\U0001F469\u200d\U0001F4BB": "\U0001F469\u200d\U0001F4BB",
+		"plain text":                 "plain text",
+	}
+
+	for input, expect := range tests {
+		t.Run(input, func(t *testing.T) {
+			if got := normalize(input); got != expect {
+				t.Errorf("expected %q, got %q", expect, got)
+			}
+		})
+	}
+}
+
+func TestFoldHomoglyphs(t *testing.T) {
+	tests := map[string]string{
+		"\u0430dmin":                           "admin",
+		"ΒUY NOW":                              "buy now",
+		"ｆｒｅｅ":                                 "free",
+		"Crème Brûlée":                         "creme brulee",
+		"n\u0303o\u0336pe":                     "nope",
+		"fr\u200bee":                           "free",
+		"\u0440\u0430\u0443\u0440\u0430\u0406": "paypai",
+	}
+
+	for input, expect := range tests {
+		t.Run(input, func(t *testing.T) {
+			if got := FoldHomoglyphs(input); got != expect {
+				t.Errorf("expected %q, got %q", expect, got)
+			}
+		})
+	}
+}
+
+func TestValidateReportReason(t *testing.T) {
+	tests := map[string]error{
+		"":                       ErrInvalidReportLen,
+		"no":                     ErrInvalidReportLen,
+		"spam":                   nil,
+		"off topic\r\nand rude":  nil,
+		strings.Repeat("a", 201): ErrInvalidReportLen,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateReportReason(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateComplaint(t *testing.T) {
+	tests := map[string]error{
+		"":                         ErrInvalidComplaintLen,
+		"too short":                ErrInvalidComplaintLen,
+		"this post has my address": nil,
+		"line one\r\n\r\nline two": nil,
+		strings.Repeat("a", 5001):  ErrInvalidComplaintLen,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateComplaint(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCategoryName(t *testing.T) {
+	tests := map[string]error{
+		"":                      ErrInvalidCategoryNameLen,
+		"Technology":            nil,
+		"Tech\r\nnology":        nil,
+		strings.Repeat("a", 33): ErrInvalidCategoryNameLen,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateCategoryName(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+
+	description, err := ValidateCategoryDescription("")
+	if err != nil || description != "" {
+		t.Errorf("expected empty descriptions to be allowed, got %q %v", description, err)
+	}
+	if _, err := ValidateCategoryDescription(strings.Repeat("a", 201)); err != ErrInvalidCategoryDescriptionLen {
+		t.Errorf("expected %v, got %v", ErrInvalidCategoryDescriptionLen, err)
+	}
+}
+
+func TestValidateMaskedWord(t *testing.T) {
+	tests := map[string]struct {
+		expect    string
+		expectErr error
+	}{
+		"":                      {"", ErrInvalidMaskedWordLen},
+		"  Darn ":               {"darn", nil},
+		"a&b":                   {"a&amp;b", nil},
+		strings.Repeat("a", 51): {"", ErrInvalidMaskedWordLen},
+	}
+
+	for input, test := range tests {
+		t.Run(input, func(t *testing.T) {
+			word, err := ValidateMaskedWord(input)
+			if err != test.expectErr || word != test.expect {
+				t.Errorf("expected %q %v, got %q %v", test.expect, test.expectErr, word, err)
+			}
+		})
+	}
+}
+
+func TestValidateLinks(t *testing.T) {
+	tests := map[string]struct {
+		content        string
+		maxLinks       int
+		rejectLinkOnly bool
+		expectErr      error
+	}{
+		"No links":          {"just some words", 1, true, nil},
+		"Under the limit":   {"see https://a.com and www.b.com", 2, false, nil},
+		"Over the limit":    {"https://a.com https://b.com http://c.com", 2, false, ErrTooManyLinks},
+		"No limit":          {"https://a.com https://b.com http://c.com", 0, false, nil},
+		"Link only":         {"  https://spam.example/buy?a=1&amp;b=2 ", 0, true, ErrLinkOnly},
+		"Links with dashes": {"https://a.com - https://b.com", 0, true, ErrLinkOnly},
+		"Link with text":    {"look https://a.com", 0, true, nil},
+		"Link only allowed": {"https://a.com", 0, false, nil},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateLinks(test.content, test.maxLinks, test.rejectLinkOnly)
+			if err != test.expectErr {
+				t.Errorf("expected %v, got %v", test.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCategoryRule(t *testing.T) {
+	tests := map[string]error{
+		"":                       ErrInvalidCategoryRuleLen,
+		"Be nice":                nil,
+		"No spam\r\nor ads":      nil,
+		strings.Repeat("a", 501): ErrInvalidCategoryRuleLen,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateCategoryRule(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateDisplayName(t *testing.T) {
+	tests := map[string]struct {
+		expected    string
+		expectedErr error
+	}{
+		"":                      {"", nil},
+		"  spirit  ":            {"spirit", nil},
+		"<b>":                   {"&lt;b&gt;", nil},
+		"two\nlines":            {"", ErrInvalidDisplayName},
+		strings.Repeat("a", 33): {"", ErrInvalidDisplayName},
+		" Admin ":               {"", ErrReservedUsername},
+		"<mod>":                 {"", ErrReservedUsername},
+	}
+
+	reserved := NewReservedNames(DefaultReservedNames)
+	for input, test := range tests {
+		t.Run(input, func(t *testing.T) {
+			name, err := ValidateDisplayName(input, reserved)
+			if name != test.expected || err != test.expectedErr {
+				t.Errorf("expected %q %v, got %q %v", test.expected, test.expectedErr, name, err)
+			}
+		})
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := map[string]error{
+		"":            ErrInvalidUsername,
+		"ab":          ErrInvalidUsername,
+		"spirit":      nil,
+		"admins":      nil,
+		"badminton":   nil,
+		"admin":       ErrReservedUsername,
+		"ADMIN":       ErrReservedUsername,
+		"ad_min":      ErrReservedUsername,
+		"adm1n":       ErrReservedUsername,
+		"аdmin":       ErrReservedUsername, // Cyrillic a
+		"ａｄｍｉｎ":       ErrReservedUsername,
+		"ádmín":       ErrReservedUsername,
+		"ad\u200bmin": ErrReservedUsername,
+		"m0derator":   ErrReservedUsername,
+		"Lucy":        ErrReservedUsername,
+	}
+
+	reserved := NewReservedNames(append([]string{"lucy"}, DefaultReservedNames...))
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateUsername(input, reserved)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+
+	if _, err := ValidateUsername("admin", nil); err != nil {
+		t.Errorf("expected nothing reserved without a list, got %v", err)
+	}
+}
+
+func TestValidateTimezone(t *testing.T) {
+	tests := map[string]error{
+		"":                               nil,
+		"UTC":                            nil,
+		"Europe/London":                  nil,
+		"America/Argentina/Buenos_Aires": nil,
+		"Etc/GMT+5":                      nil,
+		"../etc/passwd":                  ErrInvalidTimezone,
+		"Europe/":                        ErrInvalidTimezone,
+		"Mars Base":                      ErrInvalidTimezone,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateTimezone(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidatePageSlug(t *testing.T) {
+	tests := map[string]error{
+		"":                      ErrInvalidPageSlug,
+		"faq":                   nil,
+		"contact-us-2":          nil,
+		"Rules":                 ErrInvalidPageSlug,
+		"../admin":              ErrInvalidPageSlug,
+		strings.Repeat("a", 65): ErrInvalidPageSlug,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidatePageSlug(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCategorySlug(t *testing.T) {
+	tests := map[string]error{
+		"":                      ErrInvalidCategorySlug,
+		"tech":                  nil,
+		"retro-games":           nil,
+		"Tech":                  ErrInvalidCategorySlug,
+		"tech/1":                ErrInvalidCategorySlug,
+		"admin":                 ErrReservedCategoryName,
+		strings.Repeat("a", 33): ErrInvalidCategorySlug,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateCategorySlug(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateCategoryTag(t *testing.T) {
+	tests := map[string]error{
+		"":                      ErrInvalidCategoryTag,
+		"tech":                  nil,
+		"a":                     nil,
+		"retro2":                nil,
+		"retro-games":           ErrInvalidCategoryTag,
+		"Tech":                  ErrInvalidCategoryTag,
+		"; DROP TABLE posts":    ErrInvalidCategoryTag,
+		"tëch":                  ErrInvalidCategoryTag,
+		"admin":                 ErrReservedCategoryName,
+		"stats":                 ErrReservedCategoryName,
+		strings.Repeat("a", 12): nil,
+		strings.Repeat("a", 13): ErrInvalidCategoryTag,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateCategoryTag(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidatePage(t *testing.T) {
+	tests := map[string]struct {
+		title     string
+		body      string
+		expectErr error
+	}{
+		"valid":      {title: "FAQ", body: "# Questions\r\n\r\nAsk away", expectErr: nil},
+		"no title":   {title: " ", body: "body", expectErr: ErrInvalidPageTitleLen},
+		"long title": {title: strings.Repeat("a", 101), body: "body", expectErr: ErrInvalidPageTitleLen},
+		"no body":    {title: "FAQ", body: "", expectErr: ErrInvalidPageBodyLen},
+		"long body":  {title: "FAQ", body: strings.Repeat("a", 20001), expectErr: ErrInvalidPageBodyLen},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := ValidatePage(test.title, test.body)
+			if err != test.expectErr {
+				t.Errorf("expected %v, got %v", test.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateNote(t *testing.T) {
+	tests := map[string]error{
+		"":                        ErrInvalidNoteLen,
+		"  ":                      ErrInvalidNoteLen,
+		"warned for spam":         nil,
+		"warned\r\nagain":         nil,
+		strings.Repeat("a", 1001): ErrInvalidNoteLen,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateNote(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	tests := map[string]error{
+		"":                        ErrInvalidMessageLen,
+		"  ":                      ErrInvalidMessageLen,
+		"hi":                      nil,
+		"hi\r\nthere":             nil,
+		strings.Repeat("a", 2001): ErrInvalidMessageLen,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidateMessage(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidatePosterHash(t *testing.T) {
+	tests := map[string]error{
+		"":                      ErrInvalidPosterHash,
+		"not hex!":              ErrInvalidPosterHash,
+		strings.Repeat("a", 63): ErrInvalidPosterHash,
+		strings.Repeat("A", 64): nil,
+		strings.Repeat("0", 64): nil,
+	}
+
+	for input, expectErr := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := ValidatePosterHash(input)
+			if err != expectErr {
+				t.Errorf("expected %v, got %v", expectErr, err)
+			}
+		})
+	}
+}
+
 func TestValidateEmail(t *testing.T) {
 	tests := map[string]error{
 		"":             ErrInvalidEmail,
@@ -140,3 +561,114 @@ func TestValidateEmail(t *testing.T) {
 		})
 	}
 }
+
+func FuzzValidateReplyContent(f *testing.F) {
+	for _, seed := range []string{"hello", "dog\n cat \n\n tiger", "\rxxz\r \r\n  \r", "<b>&amp;</b>", "ｆｕｌｌ​width", strings.Repeat("\n", 1000) + "hi"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, content string) {
+		ret, err := ValidateReplyContent(content)
+		if err != nil {
+			return
+		}
+		if !utf8.ValidString(ret) {
+			t.Errorf("invalid UTF-8 in %q", ret)
+		}
+		if n := utf8.RuneCountInString(ret); n < minContentLen || n > maxContentLen {
+			t.Errorf("%d characters in %q", n, ret)
+		}
+		if strings.ContainsAny(ret, "<>\"\r") {
+			t.Errorf("unescaped characters in %q", ret)
+		}
+	})
+}
+
+func FuzzValidateReplySubject(f *testing.F) {
+	for _, seed := range []string{"hello there", "a\nb\r\nc de", "<script>", strings.Repeat("é", maxSubjectLen)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, subject string) {
+		ret, err := ValidateReplySubject(subject, true)
+		if err != nil {
+			return
+		}
+		if !utf8.ValidString(ret) {
+			t.Errorf("invalid UTF-8 in %q", ret)
+		}
+		if n := utf8.RuneCountInString(ret); n < minSubjectLen || n > maxSubjectLen {
+			t.Errorf("%d characters in %q", n, ret)
+		}
+		if strings.ContainsAny(ret, "<>\"\r\n") {
+			t.Errorf("unescaped characters or line breaks in %q", ret)
+		}
+	})
+}
+
+func TestRejectsHugeInput(t *testing.T) {
+	huge := strings.Repeat("a", maxContentLen*maxBytesPerChar+1)
+	if _, err := ValidateReplyContent(huge); err != ErrInvalidContentLen {
+		t.Errorf("expected ErrInvalidContentLen, got: %v", err)
+	}
+	// Runs of blank lines collapse, so content can be longer than the limit before sanitizing.
+	padded := "dog" + strings.Repeat("\n", maxContentLen*2) + "cat"
+	if _, err := ValidateReplyContent(padded); err != nil {
+		t.Errorf("expected padded content to be valid, got: %v", err)
+	}
+}
+
+func TestFindLinks(t *testing.T) {
+	content, err := ValidateReplyContent(`see (https://example.com/a?b=1&c=2), www.Example.org/x. and "http://quoted.example" ` +
+		`but not javascript:alert(1) or https://good.example@evil.example or ftp://files.example`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := FindLinks(content)
+	expected := []Link{
+		{Text: "https://example.com/a?b=1&amp;c=2", URL: "https://example.com/a?b=1&c=2", Host: "example.com"},
+		{Text: "www.Example.org/x", URL: "http://www.Example.org/x", Host: "www.example.org"},
+		{Text: "http://quoted.example", URL: "http://quoted.example", Host: "quoted.example"},
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected links %+v, got %+v", expected, links)
+	}
+	for _, link := range links {
+		if !strings.Contains(content, link.Text) {
+			t.Errorf("expected %q to appear in the content as is", link.Text)
+		}
+	}
+
+	for _, address := range []string{"javascript:alert(1)", "data:text/html,hi", "https://", "//example.com", "https://a@example.com"} {
+		if _, err := ValidateLink(address); err != ErrUnsafeLink {
+			t.Errorf("expected %q to be unsafe, got: %v", address, err)
+		}
+	}
+}
+
+func TestRemoveLinks(t *testing.T) {
+	spam := func(link Link) bool { return link.Host == "spam.example" }
+	content, err := RemoveLinks("buy at https://spam.example/x, or https://spam.example.org", spam)
+	if err != nil || content != "buy at , or https://spam.example.org" {
+		t.Errorf("expected only the spam link removed, got %q %v", content, err)
+	}
+	if _, err := RemoveLinks("https://spam.example!", spam); err != ErrLinkOnly {
+		t.Errorf("expected nothing left to be ErrLinkOnly, got: %v", err)
+	}
+
+	domains := map[string]string{
+		"Example.com.":    "example.com",
+		"*.example.co.uk": "*.example.co.uk",
+		"example":         "example",
+		"*example.com":    "",
+		"example..com":    "",
+		"-example.com":    "",
+		"https://a.com":   "",
+		"a.*.example.com": "",
+		"exa mple.com":    "",
+	}
+	for domain, expected := range domains {
+		valid, err := ValidateDomain(domain)
+		if valid != expected || (err != nil) != (len(expected) == 0) {
+			t.Errorf("%q: expected %q, got %q %v", domain, expected, valid, err)
+		}
+	}
+}